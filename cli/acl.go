@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// newACLCommand builds `shadowmesh acl`
+func newACLCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "acl",
+		Short: "Show the ACL rules and how often each one matched",
+		Long: "Show the ACL rules in the order they are checked, with the number of\n" +
+			"connections each one allowed or denied since the rules were loaded, the\n" +
+			"defaults, and the packets dropped in each direction.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var response daemonmgr.ACLResponse
+			if err := opts.client().Get("/acl", &response); err != nil {
+				return err
+			}
+
+			return opts.output(cmd, response, func(w io.Writer) {
+				stats := response.ACL
+				if stats == nil {
+					fmt.Fprintln(w, "ACL disabled, peer traffic is not filtered")
+					return
+				}
+
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "#\tRULE\tHITS")
+				for i, rule := range stats.Rules {
+					fmt.Fprintf(tw, "%d\t%s\t%d\n", i+1, rule.Rule, rule.Hits)
+				}
+				fmt.Fprintf(tw, "-\tdefault inbound: %s\t%d\n", stats.DefaultInbound.Action, stats.DefaultInbound.Hits)
+				fmt.Fprintf(tw, "-\tdefault outbound: %s\t%d\n", stats.DefaultOutbound.Action, stats.DefaultOutbound.Hits)
+				tw.Flush()
+
+				fmt.Fprintf(w, "\n%d connections tracked, %d packets passed on them; denied %d inbound, %d outbound\n",
+					stats.Connections, stats.Established, stats.DeniedInbound, stats.DeniedOutbound)
+				if stats.Evicted > 0 {
+					fmt.Fprintf(w, "%d connections evicted from the full table, their next packets are decided again\n", stats.Evicted)
+				}
+			})
+		},
+	}
+}
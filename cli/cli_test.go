@@ -0,0 +1,417 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+)
+
+// fakeDaemon serves a minimal DaemonAPI and records the paths posted to
+func fakeDaemon(t *testing.T, state string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var posted []string
+
+	peers := []daemonmgr.PeerStatus{
+		{SenderID: "00000000000000ab", Name: "bob", Transport: "relay", FEC: true, Addresses: []string{"10.0.0.2"}, Subnets: []string{"192.168.1.0/24"}, RxBytes: 1536, InboundLoss: 0.05},
+	}
+
+	aclStats := &acl.Stats{
+		Rules:           []acl.RuleStats{{Rule: "allow in tcp 22 from group:admins", Action: acl.Allow, Hits: 7}},
+		DefaultInbound:  acl.DefaultStats{Action: acl.Deny, Hits: 2},
+		DefaultOutbound: acl.DefaultStats{Action: acl.Allow, Hits: 11},
+		Connections:     4,
+		Established:     380,
+		DeniedInbound:   9,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(daemonmgr.StatusResponse{
+			Status:      "success",
+			DaemonState: state,
+			Details: daemonmgr.DaemonStatus{
+				State:       state,
+				TAPDevice:   "tap0",
+				LocalIP:     "10.0.0.1/24",
+				KeySequence: 3,
+				Peers:       peers,
+				DNS:         &daemonmgr.DNSStatus{Domain: "mesh.internal", Name: "alice", Listen: []string{"10.0.0.1:53"}, Conflicts: []string{"bob"}},
+				ACL:         aclStats,
+				Neighbors:   &daemonmgr.NeighborStatus{Proxy: true, Bindings: 4, Answered: 12, Suppressed: 30, BroadcastLimit: 100},
+				Networks:    []daemonmgr.NetworkStatus{{ID: "office"}, {ID: "lab", VLAN: 20}, {ID: "guest", Device: "tap-guest"}},
+			},
+		})
+	})
+	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(daemonmgr.PeersResponse{Status: "success", Peers: peers})
+	})
+	mux.HandleFunc("/acl", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(daemonmgr.ACLResponse{Status: "success", Enabled: true, ACL: aclStats})
+	})
+	mux.HandleFunc("/connect", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+		json.NewEncoder(w).Encode(daemonmgr.ConnectResponse{Status: "success", Message: "Connected to peer at 192.0.2.1:9001"})
+	})
+	mux.HandleFunc("/disconnect", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(daemonmgr.DisconnectResponse{Status: "error", Message: "Disconnect failed: not connected"})
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+		json.NewEncoder(w).Encode(daemonmgr.ReloadResponse{
+			Status:  "success",
+			Message: "Configuration reloaded",
+			ReloadResult: daemonmgr.ReloadResult{
+				Applied:         []string{"nat.stun_server"},
+				RestartRequired: []string{"network.local_ip"},
+			},
+		})
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []daemonmgr.Event{
+			{ID: 1, Type: daemonmgr.EventStateChanged, State: &daemonmgr.StateChangedEvent{From: "Disconnected", To: "Connecting"}},
+			{ID: 2, Type: daemonmgr.EventKeyRotated, Key: &daemonmgr.KeyRotatedEvent{KeySequence: 4}},
+		}
+		fmt.Fprint(w, ": keepalive\n\n")
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &posted
+}
+
+// run executes the CLI with args and returns its output
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd := NewRootCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+// TestStatusOutput tests human and JSON status output
+func TestStatusOutput(t *testing.T) {
+	server, _ := fakeDaemon(t, "Connected")
+
+	out, err := run(t, "--api", server.URL, "status")
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !strings.Contains(out, "Connected") || !strings.Contains(out, "Key sequence:  3") || !strings.Contains(out, "alice.mesh.internal (on 10.0.0.1:53)") ||
+		!strings.Contains(out, "conflicting names: bob") || !strings.Contains(out, "1 rules, 9 denied inbound") || !strings.Contains(out, "proxy on (4 bindings, 12 answered), 30 broadcasts suppressed") ||
+		!strings.Contains(out, "office, lab (vlan 20), guest (tap-guest)") {
+		t.Errorf("Unexpected status output:\n%s", out)
+	}
+
+	out, err = run(t, "--api", server.URL, "--json", "status")
+	if err != nil {
+		t.Fatalf("status --json failed: %v", err)
+	}
+	var status daemonmgr.StatusResponse
+	if err := json.Unmarshal([]byte(out), &status); err != nil || status.DaemonState != "Connected" {
+		t.Errorf("Invalid JSON status (%v):\n%s", err, out)
+	}
+}
+
+// TestPeersOutput tests the peers table
+func TestPeersOutput(t *testing.T) {
+	server, _ := fakeDaemon(t, "Connected")
+
+	out, err := run(t, "--api", server.URL, "peers")
+	if err != nil {
+		t.Fatalf("peers failed: %v", err)
+	}
+	for _, want := range []string{"00000000000000ab", "bob", "relay", "fec", "10.0.0.2", "192.168.1.0/24", "5.0%", "1.5 KiB"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Peers output is missing %q:\n%s", want, out)
+		}
+	}
+
+	out, err = run(t, "--api", server.URL, "--json", "peers")
+	if err != nil {
+		t.Fatalf("peers --json failed: %v", err)
+	}
+	var peers []daemonmgr.PeerStatus
+	if err := json.Unmarshal([]byte(out), &peers); err != nil || len(peers) != 1 || peers[0].RxBytes != 1536 {
+		t.Errorf("Unexpected peers JSON (%v):\n%s", err, out)
+	}
+}
+
+// TestFormatBytes tests byte count formatting
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"}
+	for n, want := range cases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+// TestUpDownIdempotent tests that up and down only act when the state changes
+func TestUpDownIdempotent(t *testing.T) {
+	server, posted := fakeDaemon(t, "Connected")
+
+	if out, err := run(t, "--api", server.URL, "up"); err != nil || !strings.Contains(out, "already up") {
+		t.Errorf("up while connected: %v\n%s", err, out)
+	}
+	if len(*posted) != 0 {
+		t.Errorf("up while connected posted %v", *posted)
+	}
+
+	server, posted = fakeDaemon(t, "Disconnected")
+	if out, err := run(t, "--api", server.URL, "up"); err != nil || !strings.Contains(out, "Connected to peer") {
+		t.Errorf("up while disconnected: %v\n%s", err, out)
+	}
+	if out, err := run(t, "--api", server.URL, "down"); err != nil || !strings.Contains(out, "already down") {
+		t.Errorf("down while disconnected: %v\n%s", err, out)
+	}
+	if len(*posted) != 1 || (*posted)[0] != "/connect" {
+		t.Errorf("Expected only /connect to be posted, got %v", *posted)
+	}
+}
+
+// TestDownWhileReconnecting tests that down stops a daemon that is retrying a lost connection
+func TestDownWhileReconnecting(t *testing.T) {
+	server, posted := fakeDaemon(t, daemonmgr.StateReconnecting.String())
+
+	run(t, "--api", server.URL, "down") // The fake daemon's /disconnect always fails
+	if len(*posted) != 1 || (*posted)[0] != "/disconnect" {
+		t.Errorf("Expected a POST to /disconnect, got %v", *posted)
+	}
+}
+
+// TestAPIErrorMessage tests that API error messages are surfaced
+func TestAPIErrorMessage(t *testing.T) {
+	server, _ := fakeDaemon(t, "Disconnected")
+
+	_, err := run(t, "--api", server.URL, "disconnect")
+	if err == nil || err.Error() != "Disconnect failed: not connected" {
+		t.Errorf("Expected the daemon's message, got %v", err)
+	}
+}
+
+// TestWatch tests printing streamed events
+func TestWatch(t *testing.T) {
+	server, _ := fakeDaemon(t, "Connected")
+
+	out, err := run(t, "--api", server.URL, "watch", "-n", "2")
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	if !strings.Contains(out, "Disconnected → Connecting") || !strings.Contains(out, "transmit key sequence 4") {
+		t.Errorf("Unexpected watch output:\n%s", out)
+	}
+
+	out, err = run(t, "--api", server.URL, "--json", "watch", "-n", "2")
+	if err != nil {
+		t.Fatalf("watch --json failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var event daemonmgr.Event
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &event) != nil || event.Key == nil || event.Key.KeySequence != 4 {
+		t.Errorf("Unexpected JSON events:\n%s", out)
+	}
+}
+
+// TestConfigValidate tests validating good and bad configuration files
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	bad := filepath.Join(dir, "bad.yaml")
+
+	os.WriteFile(good, []byte("network:\n  local_ip: 10.0.0.1/24\nencryption:\n  key: \""+strings.Repeat("ab", 32)+"\"\n"), 0600)
+	os.WriteFile(bad, []byte("network:\n  local_ip: 10.0.0.1/24\nencryption:\n  key: short\n"), 0600)
+
+	if out, err := run(t, "config", "validate", good); err != nil || !strings.Contains(out, "is valid") {
+		t.Errorf("Valid config rejected: %v\n%s", err, out)
+	}
+
+	out, err := run(t, "--json", "config", "validate", bad)
+	if err == nil {
+		t.Fatal("Invalid config accepted")
+	}
+	var result validationResult
+	if jsonErr := json.Unmarshal([]byte(out), &result); jsonErr != nil || result.Valid || !strings.Contains(result.Error, "encryption.key") {
+		t.Errorf("Unexpected JSON result (%v):\n%s", jsonErr, out)
+	}
+}
+
+// TestACLOutput tests the rule hit table
+func TestACLOutput(t *testing.T) {
+	server, _ := fakeDaemon(t, "Connected")
+
+	out, err := run(t, "--api", server.URL, "acl")
+	if err != nil {
+		t.Fatalf("acl failed: %v", err)
+	}
+	for _, want := range []string{"allow in tcp 22 from group:admins  7", "default inbound: deny", "default outbound: allow", "4 connections tracked", "denied 9 inbound"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ACL output is missing %q:\n%s", want, out)
+		}
+	}
+
+	out, err = run(t, "--api", server.URL, "--json", "acl")
+	if err != nil {
+		t.Fatalf("acl --json failed: %v", err)
+	}
+	var response daemonmgr.ACLResponse
+	if err := json.Unmarshal([]byte(out), &response); err != nil || response.ACL == nil || response.ACL.Rules[0].Hits != 7 {
+		t.Errorf("Unexpected ACL JSON (%v):\n%s", err, out)
+	}
+}
+
+// TestConfigSchemaUpToDate tests that configs/daemon.schema.json matches `config schema`
+func TestConfigSchemaUpToDate(t *testing.T) {
+	out, err := run(t, "config", "schema")
+	if err != nil {
+		t.Fatalf("config schema failed: %v", err)
+	}
+
+	committed, err := os.ReadFile(filepath.Join("..", "configs", "daemon.schema.json"))
+	if err != nil {
+		t.Fatalf("Failed to read committed schema: %v", err)
+	}
+	if out != string(committed) {
+		t.Error("configs/daemon.schema.json is stale; regenerate it with `shadowmesh config schema -o configs/daemon.schema.json`")
+	}
+}
+
+// TestConfigReload tests reporting applied and restart-only fields
+func TestConfigReload(t *testing.T) {
+	server, posted := fakeDaemon(t, "Connected")
+
+	out, err := run(t, "--api", server.URL, "config", "reload")
+	if err != nil {
+		t.Fatalf("config reload failed: %v", err)
+	}
+	if len(*posted) != 1 || (*posted)[0] != "/reload" {
+		t.Errorf("Expected a POST to /reload, got %v", *posted)
+	}
+	if !strings.Contains(out, "applied:          nat.stun_server") || !strings.Contains(out, "restart required: network.local_ip") {
+		t.Errorf("Unexpected reload output:\n%s", out)
+	}
+}
+
+// TestKeysGenerateWriteKeyFile tests that keys generate --write replaces the key file and leaves the configuration alone
+func TestKeysGenerateWriteKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(strings.Repeat("cd", 32)+"\n"), 0600)
+
+	path := filepath.Join(dir, "daemon.yaml")
+	config := "network:\n  local_ip: 10.0.0.1/24\nencryption:\n  key_file: " + keyFile + "\n"
+	os.WriteFile(path, []byte(config), 0600)
+
+	out, err := run(t, "--config", path, "--json", "keys", "generate", "--write")
+	if err != nil {
+		t.Fatalf("keys generate failed: %v", err)
+	}
+	var result keyResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.Config != keyFile {
+		t.Fatalf("Key not written to the key file (%v):\n%s", err, out)
+	}
+	if loaded, err := daemonmgr.LoadConfig(path); err != nil || loaded.Encryption.Key != result.Key {
+		t.Errorf("Generated key not loaded (%v)", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != config {
+		t.Errorf("Configuration changed:\n%s", data)
+	}
+}
+
+// TestKeysIdentity tests that keys identity prints a seed and the public key derived from it
+func TestKeysIdentity(t *testing.T) {
+	out, err := run(t, "--json", "keys", "identity")
+	if err != nil {
+		t.Fatalf("keys identity failed: %v", err)
+	}
+	var result keyResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Invalid key result (%v):\n%s", err, out)
+	}
+	seed, err := hex.DecodeString(result.Key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		t.Fatalf("Key = %q, want a hex Ed25519 seed", result.Key)
+	}
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	if result.PublicKey != hex.EncodeToString(public) {
+		t.Errorf("PublicKey = %s, want %x", result.PublicKey, public)
+	}
+}
+
+// TestKeysGenerateWrite tests writing a new key into a configuration file
+func TestKeysGenerateWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.yaml")
+	os.WriteFile(path, []byte("# Node config\nnetwork:\n  local_ip: 10.0.0.1/24 # tunnel address\nencryption:\n  key: \"old\"\n"), 0600)
+
+	out, err := run(t, "--config", path, "--json", "keys", "generate", "--write")
+	if err != nil {
+		t.Fatalf("keys generate failed: %v", err)
+	}
+	var result keyResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil || len(result.Key) != 64 {
+		t.Fatalf("Invalid key result (%v):\n%s", err, out)
+	}
+
+	config, err := daemonmgr.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Encryption.Key != result.Key || config.Network.LocalIP != "10.0.0.1/24" {
+		t.Errorf("Key not written or config damaged: %+v", config)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# tunnel address") {
+		t.Errorf("Comments were not preserved:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("File mode changed to %v", info.Mode().Perm())
+	}
+}
+
+// TestAPIToken tests that the bearer token is sent to TCP daemons
+func TestAPIToken(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(daemonmgr.StatusResponse{Status: "success", DaemonState: "Connected"})
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := run(t, "--api", server.URL, "--api-token", "0123456789abcdef", "status"); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if got != "Bearer 0123456789abcdef" {
+		t.Errorf("Expected bearer token, got %q", got)
+	}
+
+	t.Setenv("SHADOWMESH_API_TOKEN", "fedcba9876543210")
+	if _, err := run(t, "--api", server.URL, "status"); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if got != "Bearer fedcba9876543210" {
+		t.Errorf("Expected token from environment, got %q", got)
+	}
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client talks to the daemon's HTTP API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// apiResponse is the envelope every DaemonAPI reply shares
+type apiResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// NewClient creates an API client
+// address is "host:port", an http:// URL, or a Unix socket as "unix:/path" or "/path".
+// token is sent as a bearer token; the daemon requires it on TCP addresses.
+func NewClient(address, token string, timeout time.Duration) *Client {
+	transport := &http.Transport{}
+	baseURL := address
+
+	switch {
+	case strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "/"):
+		socketPath := strings.TrimPrefix(address, "unix:")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		baseURL = "http://shadowmesh"
+	case !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://"):
+		baseURL = "http://" + address
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+	}
+}
+
+// Get performs a GET request and decodes the JSON reply into out
+func (c *Client) Get(path string, out interface{}) error {
+	return c.do(http.MethodGet, path, nil, out)
+}
+
+// Post performs a POST request with an optional JSON body and decodes the reply into out
+func (c *Client) Post(path string, in, out interface{}) error {
+	return c.do(http.MethodPost, path, in, out)
+}
+
+// ServerEvent is one message of a Server-Sent Events stream
+type ServerEvent struct {
+	ID   string
+	Type string
+	Data []byte
+}
+
+// Stream reads a Server-Sent Events stream from path, calling handle for each event
+// It returns when ctx is cancelled (nil), the daemon ends the stream (io.EOF) or
+// handle returns an error. lastEventID resumes an interrupted stream.
+func (c *Client) Stream(ctx context.Context, path, lastEventID string, handle func(ServerEvent) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	// The request timeout applies to ordinary calls, not to a stream
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("daemon not reachable (is shadowmesh-daemon running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(resp.Body)
+		var apiErr apiResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		return fmt.Errorf("daemon returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var event ServerEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch {
+		case line == "":
+			if len(event.Data) > 0 {
+				if err := handle(event); err != nil {
+					return err
+				}
+			}
+			event = ServerEvent{ID: event.ID}
+		case field == "":
+			// Comment (heartbeat)
+		case field == "id":
+			event.ID = value
+		case field == "event":
+			event.Type = value
+		case field == "data":
+			if len(event.Data) > 0 {
+				event.Data = append(event.Data, '\n')
+			}
+			event.Data = append(event.Data, value...)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("event stream interrupted: %w", err)
+	}
+	return io.EOF
+}
+
+// do sends a request and turns API errors into Go errors
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("permission denied on the daemon socket (use sudo or join the daemon's socket_group): %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("daemon not reachable (is shadowmesh-daemon running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		var apiErr apiResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		return fmt.Errorf("daemon returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response from daemon: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// validationResult is the --json output of `shadowmesh config validate`
+type validationResult struct {
+	Config string                 `json:"config"`
+	Valid  bool                   `json:"valid"`
+	Error  string                 `json:"error,omitempty"`
+	Errors daemonmgr.ConfigErrors `json:"errors,omitempty"` // Each problem with its line and column
+}
+
+// newConfigCommand builds `shadowmesh config`
+func newConfigCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with daemon configuration files",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a configuration file without starting the daemon",
+		Long: "Check a configuration file without starting the daemon.\n" +
+			"Reports unknown fields, values of the wrong type and invalid settings,\n" +
+			"each with its line and column. Defaults to the file given by --config.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := opts.configPath
+			if len(args) == 1 {
+				path = args[0]
+			}
+
+			result := validationResult{Config: path, Valid: true}
+
+			config, err := daemonmgr.LoadConfig(path)
+			if err == nil {
+				err = config.Validate()
+			}
+			if err != nil {
+				result.Valid = false
+				result.Error = err.Error()
+				errors.As(err, &result.Errors)
+			}
+
+			if outErr := opts.output(cmd, result, func(w io.Writer) {
+				if result.Valid {
+					fmt.Fprintf(w, "✅ %s is valid\n", path)
+				}
+			}); outErr != nil {
+				return outErr
+			}
+
+			var configErrs daemonmgr.ConfigErrors
+			if errors.As(err, &configErrs) {
+				// Every problem already names the file and position
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return nil
+		},
+	})
+
+	var schemaOutput string
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the configuration file",
+		Long: "Print the JSON Schema of the configuration file, for editor completion and checks.\n" +
+			"With the YAML language server, add this line to daemon.yaml:\n" +
+			"  # yaml-language-server: $schema=daemon.schema.json",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := json.MarshalIndent(daemonmgr.ConfigSchema(), "", "  ")
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+
+			if schemaOutput == "" {
+				_, err := cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := os.WriteFile(schemaOutput, data, 0644); err != nil {
+				return fmt.Errorf("write schema: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "✅ Schema written to %s\n", schemaOutput)
+			return nil
+		},
+	}
+	schemaCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "write the schema to this file instead of stdout")
+	cmd.AddCommand(schemaCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reload",
+		Short: "Make the daemon re-read its configuration file",
+		Long: "Make the daemon re-read its configuration file without dropping tunnels.\n" +
+			"Peer, relay, NAT, log level and key rotation settings apply immediately;\n" +
+			"other changed settings are listed and take effect after a restart.\n" +
+			"Sending SIGHUP to shadowmesh-daemon does the same.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp daemonmgr.ReloadResponse
+			if err := opts.client().Post("/reload", nil, &resp); err != nil {
+				return err
+			}
+
+			return opts.output(cmd, resp, func(w io.Writer) {
+				if len(resp.Applied) == 0 && len(resp.RestartRequired) == 0 {
+					fmt.Fprintln(w, "✅ Configuration unchanged")
+					return
+				}
+				fmt.Fprintln(w, "✅ Configuration reloaded")
+				for _, field := range resp.Applied {
+					fmt.Fprintf(w, "   applied:          %s\n", field)
+				}
+				for _, field := range resp.RestartRequired {
+					fmt.Fprintf(w, "   restart required: %s\n", field)
+				}
+			})
+		},
+	})
+
+	return cmd
+}
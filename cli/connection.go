@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// connectionResult is the --json output of connection commands
+type connectionResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Changed bool   `json:"changed"` // False if the tunnel was already in the requested state
+}
+
+// daemonState returns the daemon's connection state ("Connected", "Disconnected", ...)
+func daemonState(client *Client) (string, error) {
+	var status daemonmgr.StatusResponse
+	if err := client.Get("/status", &status); err != nil {
+		return "", err
+	}
+	return status.DaemonState, nil
+}
+
+// printConnectionResult prints the outcome of a connection command
+func printConnectionResult(opts *options, cmd *cobra.Command, result connectionResult) error {
+	return opts.output(cmd, result, func(w io.Writer) {
+		if result.Changed {
+			fmt.Fprintf(w, "✅ %s\n", result.Message)
+		} else {
+			fmt.Fprintln(w, result.Message)
+		}
+	})
+}
+
+// newUpCommand builds `shadowmesh up`
+func newUpCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "up",
+		Short: "Connect to the peer or relay from the daemon configuration",
+		Long: "Connect to the peer or relay from the daemon configuration.\n" +
+			"Does nothing if the tunnel is already up.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := opts.client()
+
+			state, err := daemonState(client)
+			if err != nil {
+				return err
+			}
+			if state == daemonmgr.StateConnected.String() {
+				return printConnectionResult(opts, cmd, connectionResult{Status: "success", Message: "Tunnel is already up"})
+			}
+
+			var resp daemonmgr.ConnectResponse
+			if err := client.Post("/connect", daemonmgr.ConnectRequest{}, &resp); err != nil {
+				return err
+			}
+			return printConnectionResult(opts, cmd, connectionResult{Status: resp.Status, Message: resp.Message, Changed: true})
+		},
+	}
+}
+
+// newDownCommand builds `shadowmesh down`
+func newDownCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "down",
+		Short: "Take the tunnel down",
+		Long: "Take the tunnel down, or stop reconnecting it. Does nothing if it is already down;\n" +
+			"the daemon keeps running.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := opts.client()
+
+			state, err := daemonState(client)
+			if err != nil {
+				return err
+			}
+			if state == daemonmgr.StateDisconnected.String() || state == daemonmgr.StateError.String() {
+				return printConnectionResult(opts, cmd, connectionResult{Status: "success", Message: "Tunnel is already down"})
+			}
+
+			var resp daemonmgr.DisconnectResponse
+			if err := client.Post("/disconnect", nil, &resp); err != nil {
+				return err
+			}
+			return printConnectionResult(opts, cmd, connectionResult{Status: resp.Status, Message: resp.Message, Changed: true})
+		},
+	}
+}
+
+// newConnectCommand builds `shadowmesh connect`
+func newConnectCommand(opts *options) *cobra.Command {
+	var req daemonmgr.ConnectRequest
+
+	cmd := &cobra.Command{
+		Use:   "connect [peer-address]",
+		Short: "Connect to a peer directly or through a relay",
+		Example: "  shadowmesh connect 192.168.1.100:9001\n" +
+			"  shadowmesh connect --relay ws://relay.example.com:9545 --peer-id office-pi",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				req.PeerAddress = args[0]
+			}
+			req.UseRelay = req.RelayServer != ""
+
+			if req.PeerAddress == "" && !req.UseRelay {
+				return fmt.Errorf("a peer address or --relay is required")
+			}
+
+			var resp daemonmgr.ConnectResponse
+			if err := opts.client().Post("/connect", req, &resp); err != nil {
+				return err
+			}
+			return printConnectionResult(opts, cmd, connectionResult{Status: resp.Status, Message: resp.Message, Changed: true})
+		},
+	}
+
+	cmd.Flags().StringVar(&req.RelayServer, "relay", "", "connect through this relay server")
+	cmd.Flags().StringVar(&req.PeerID, "peer-id", "", "peer ID to register with the relay")
+
+	return cmd
+}
+
+// newDisconnectCommand builds `shadowmesh disconnect`
+func newDisconnectCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "disconnect",
+		Short: "Disconnect from the current peer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp daemonmgr.DisconnectResponse
+			if err := opts.client().Post("/disconnect", nil, &resp); err != nil {
+				return err
+			}
+			return printConnectionResult(opts, cmd, connectionResult{Status: resp.Status, Message: resp.Message, Changed: true})
+		},
+	}
+}
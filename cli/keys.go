@@ -0,0 +1,301 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// keyResult is the --json output of key commands
+type keyResult struct {
+	Key         string  `json:"key,omitempty"`
+	PublicKey   string  `json:"public_key,omitempty"` // Identity keys only
+	Fingerprint string  `json:"fingerprint"`
+	Config      string  `json:"config,omitempty"`
+	KeySequence *uint64 `json:"key_sequence,omitempty"` // Session key sequence, if the daemon is reachable
+}
+
+// keyFingerprint identifies a key without revealing it
+func keyFingerprint(keyHex string) string {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return "invalid"
+	}
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// newKeysCommand builds `shadowmesh keys`
+func newKeysCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Generate, inspect and rotate encryption and identity keys",
+	}
+
+	cmd.AddCommand(
+		newKeysGenerateCommand(opts),
+		newKeysShowCommand(opts),
+		newKeysRotateCommand(opts),
+		newKeysIdentityCommand(opts),
+	)
+
+	return cmd
+}
+
+// newKeysGenerateCommand builds `shadowmesh keys generate`
+func newKeysGenerateCommand(opts *options) *cobra.Command {
+	var write bool
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new pre-shared encryption key",
+		Long: "Generate a new 256-bit pre-shared encryption key.\n" +
+			"Both peers need the same key; with --write it is stored in the configuration file,\n" +
+			"or in the file named by its encryption.key_file.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key [32]byte
+			if _, err := rand.Read(key[:]); err != nil {
+				return fmt.Errorf("failed to generate key: %w", err)
+			}
+
+			result := keyResult{
+				Key:         hex.EncodeToString(key[:]),
+				Fingerprint: keyFingerprint(hex.EncodeToString(key[:])),
+			}
+
+			if write {
+				written, err := writeConfigKey(opts.configPath, result.Key)
+				if err != nil {
+					return err
+				}
+				result.Config = written
+			}
+
+			return opts.output(cmd, result, func(w io.Writer) {
+				fmt.Fprintln(w, result.Key)
+				if write {
+					fmt.Fprintf(w, "✅ Written to %s; copy the key to the peer and restart both daemons\n", result.Config)
+				}
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&write, "write", false, "store the key as encryption.key in the configuration file (or in its encryption.key_file)")
+
+	return cmd
+}
+
+// newKeysShowCommand builds `shadowmesh keys show`
+func newKeysShowCommand(opts *options) *cobra.Command {
+	var reveal bool
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the configured key's fingerprint and the current session key sequence",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := daemonmgr.LoadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+
+			result := keyResult{
+				Fingerprint: keyFingerprint(config.Encryption.Key),
+				Config:      opts.configPath,
+			}
+			if reveal {
+				result.Key = config.Encryption.Key
+			}
+
+			// The session key sequence is only known to a running daemon
+			var status daemonmgr.StatusResponse
+			if err := opts.client().Get("/status", &status); err == nil {
+				sequence := status.Details.KeySequence
+				result.KeySequence = &sequence
+			}
+
+			return opts.output(cmd, result, func(w io.Writer) {
+				fmt.Fprintf(w, "Config:       %s\n", result.Config)
+				fmt.Fprintf(w, "Fingerprint:  %s\n", result.Fingerprint)
+				if reveal {
+					fmt.Fprintf(w, "Key:          %s\n", result.Key)
+				}
+				if result.KeySequence != nil {
+					fmt.Fprintf(w, "Key sequence: %d\n", *result.KeySequence)
+				}
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&reveal, "reveal", false, "also print the key itself")
+
+	return cmd
+}
+
+// newKeysRotateCommand builds `shadowmesh keys rotate`
+func newKeysRotateCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate",
+		Short: "Rotate the daemon's session transmit key now",
+		Long: "Rotate the daemon's session transmit key now.\n" +
+			"The peer follows the rotation automatically; the pre-shared key is unchanged.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp daemonmgr.RotateKeysResponse
+			if err := opts.client().Post("/keys/rotate", nil, &resp); err != nil {
+				return err
+			}
+
+			return opts.output(cmd, resp, func(w io.Writer) {
+				fmt.Fprintf(w, "✅ %s\n", resp.Message)
+			})
+		},
+	}
+}
+
+// newKeysIdentityCommand builds `shadowmesh keys identity`
+func newKeysIdentityCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "identity",
+		Short: "Generate a key pair proving this node's name to its peers",
+		Long: "Generate an Ed25519 key pair proving this node's name (dns.name) to its peers.\n" +
+			"The private key goes into identity.key; every peer lists the public key under\n" +
+			"identity.peers.<name>. ACL rules and MagicDNS only trust names proven this way.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			public, private, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return fmt.Errorf("failed to generate key: %w", err)
+			}
+
+			result := keyResult{
+				Key:         hex.EncodeToString(private.Seed()),
+				PublicKey:   hex.EncodeToString(public),
+				Fingerprint: keyFingerprint(hex.EncodeToString(public)),
+			}
+
+			return opts.output(cmd, result, func(w io.Writer) {
+				fmt.Fprintf(w, "Key:         %s\n", result.Key)
+				fmt.Fprintf(w, "Public key:  %s\n", result.PublicKey)
+				fmt.Fprintf(w, "Fingerprint: %s\n", result.Fingerprint)
+				fmt.Fprintln(w, "Set identity.key to the key here, and identity.peers.<name> to the public key on every peer")
+			})
+		},
+	}
+}
+
+// writeConfigKey sets encryption.key in a YAML configuration file, keeping its comments
+// If the file sets encryption.key_file the key is written there instead. Returns the file written.
+func writeConfigKey(path, key string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("read config file: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("parse YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	if encryption := lookupValue(doc.Content[0], "encryption"); encryption != nil {
+		if keyFile := lookupValue(encryption, "key_file"); keyFile != nil && keyFile.Kind == yaml.ScalarNode && keyFile.Value != "" {
+			// The daemon refuses key files that group or others can read
+			if err := replaceFile(keyFile.Value, []byte(key+"\n"), 0600); err != nil {
+				return "", fmt.Errorf("write key file: %w", err)
+			}
+			return keyFile.Value, nil
+		}
+	}
+
+	encryption := mappingValue(doc.Content[0], "encryption", yaml.MappingNode)
+	keyNode := mappingValue(encryption, "key", yaml.ScalarNode)
+	keyNode.Value = key
+	keyNode.Tag = "!!str"
+	keyNode.Style = yaml.DoubleQuotedStyle
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return "", fmt.Errorf("encode YAML: %w", err)
+	}
+	encoder.Close()
+
+	if err := replaceFile(path, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("write config file: %w", err)
+	}
+	return path, nil
+}
+
+// replaceFile writes data next to path and renames it into place, so a failure never leaves a truncated file
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Set the mode before writing, so the data is never readable with the default mode
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lookupValue returns the value node for key in a YAML mapping, or nil if it is missing
+func lookupValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node for key in a YAML mapping, adding it if missing
+func mappingValue(mapping *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			if value.Kind != kind {
+				// e.g. "encryption:" with no value parses as a null scalar
+				*value = yaml.Node{Kind: kind}
+			}
+			return value
+		}
+	}
+
+	value := &yaml.Node{Kind: kind}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
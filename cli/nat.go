@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/shadowmesh/shadowmesh/pkg/nat"
+	"github.com/spf13/cobra"
+)
+
+// natResult is the --json output of `shadowmesh nat detect`
+type natResult struct {
+	NATType       string  `json:"nat_type"`
+	PublicIP      string  `json:"public_ip"`
+	PublicPort    int     `json:"public_port"`
+	P2PFeasible   bool    `json:"p2p_feasible"`
+	DetectionTime float64 `json:"detection_ms"`
+}
+
+// newNATCommand builds `shadowmesh nat`
+func newNATCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nat",
+		Short: "NAT traversal diagnostics",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "detect",
+		Short: "Detect this host's NAT type with STUN",
+		Long: "Detect this host's NAT type with STUN.\n" +
+			"Runs locally and does not need the daemon.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			detector := nat.NewNATDetector()
+
+			result, err := detector.DetectNATType(context.Background())
+			if err != nil {
+				return err
+			}
+
+			out := natResult{
+				NATType:       result.NATType.String(),
+				PublicIP:      result.PublicIP.String(),
+				PublicPort:    result.PublicPort,
+				P2PFeasible:   detector.IsP2PFeasible(),
+				DetectionTime: float64(result.DetectionTime.Microseconds()) / 1000,
+			}
+
+			return opts.output(cmd, out, func(w io.Writer) {
+				fmt.Fprintf(w, "NAT type:     %s\n", out.NATType)
+				fmt.Fprintf(w, "Public addr:  %s:%d\n", out.PublicIP, out.PublicPort)
+				if out.P2PFeasible {
+					fmt.Fprintln(w, "Direct P2P:   feasible")
+				} else {
+					fmt.Fprintln(w, "Direct P2P:   unlikely, traffic will use a relay")
+				}
+			})
+		},
+	})
+
+	return cmd
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// pingResult is the --json output of `shadowmesh ping`
+type pingResult struct {
+	Sent     int       `json:"sent"`
+	Received int       `json:"received"`
+	RTTs     []float64 `json:"rtt_ms"`
+	Min      float64   `json:"min_ms"`
+	Avg      float64   `json:"avg_ms"`
+	Max      float64   `json:"max_ms"`
+}
+
+// newPingCommand builds `shadowmesh ping`
+func newPingCommand(opts *options) *cobra.Command {
+	var (
+		count    int
+		interval time.Duration
+		wait     time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "ping",
+		Short: "Measure the round trip to the peer through the tunnel",
+		Long: "Measure the round trip to the peer through the encrypted tunnel.\n" +
+			"Pings travel as control messages, so they work without IP routing.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
+
+			client := opts.client()
+			w := cmd.OutOrStdout()
+			result := pingResult{RTTs: []float64{}}
+
+			for i := 0; i < count; i++ {
+				if i > 0 {
+					time.Sleep(interval)
+				}
+
+				result.Sent++
+				var resp daemonmgr.PingResponse
+				err := client.Post("/ping", daemonmgr.PingRequest{TimeoutMillis: int(wait.Milliseconds())}, &resp)
+				if err != nil {
+					if !opts.jsonOutput {
+						fmt.Fprintf(w, "seq=%d: %v\n", i+1, err)
+					}
+					continue
+				}
+
+				result.Received++
+				result.RTTs = append(result.RTTs, resp.RTTMillis)
+				if !opts.jsonOutput {
+					fmt.Fprintf(w, "seq=%d time=%.2f ms\n", i+1, resp.RTTMillis)
+				}
+			}
+
+			for i, rtt := range result.RTTs {
+				if i == 0 || rtt < result.Min {
+					result.Min = rtt
+				}
+				if rtt > result.Max {
+					result.Max = rtt
+				}
+				result.Avg += rtt / float64(len(result.RTTs))
+			}
+
+			if err := opts.output(cmd, result, func(w io.Writer) {
+				loss := float64(result.Sent-result.Received) / float64(result.Sent) * 100
+				fmt.Fprintf(w, "\n%d sent, %d received, %.0f%% loss\n", result.Sent, result.Received, loss)
+				if result.Received > 0 {
+					fmt.Fprintf(w, "rtt min/avg/max = %.2f/%.2f/%.2f ms\n", result.Min, result.Avg, result.Max)
+				}
+			}); err != nil {
+				return err
+			}
+
+			if result.Received == 0 {
+				return fmt.Errorf("no reply from peer")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&count, "count", "n", 4, "number of pings")
+	cmd.Flags().DurationVarP(&interval, "interval", "i", time.Second, "time between pings")
+	cmd.Flags().DurationVarP(&wait, "wait", "W", 2*time.Second, "time to wait for each reply")
+
+	return cmd
+}
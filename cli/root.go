@@ -0,0 +1,120 @@
+// Package cli implements the shadowmesh command-line client
+//
+// Commands that act on the running daemon talk to DaemonAPI; key, NAT and
+// configuration commands work locally. Every command accepts --json for scripting.
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// Version is the CLI version reported by `shadowmesh version`
+const Version = "0.1.0-epic2"
+
+// DefaultAPIAddress is the daemon API address used when neither --api nor SHADOWMESH_API is set
+const DefaultAPIAddress = "unix:" + daemonmgr.DefaultSocketPath
+
+// options holds the global flags shared by every command
+type options struct {
+	apiAddress string
+	apiToken   string
+	configPath string
+	jsonOutput bool
+	timeout    time.Duration
+}
+
+// client returns an API client for the configured daemon address
+func (o *options) client() *Client {
+	token := o.apiToken
+	if token == "" {
+		// Read here rather than as the flag default so --help never prints it
+		token = os.Getenv("SHADOWMESH_API_TOKEN")
+	}
+	return NewClient(o.apiAddress, token, o.timeout)
+}
+
+// output writes v as JSON with --json, or calls human otherwise
+func (o *options) output(cmd *cobra.Command, v interface{}, human func(w io.Writer)) error {
+	w := cmd.OutOrStdout()
+	if o.jsonOutput {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	human(w)
+	return nil
+}
+
+// NewRootCommand builds the shadowmesh command tree
+func NewRootCommand() *cobra.Command {
+	opts := &options{}
+
+	root := &cobra.Command{
+		Use:           "shadowmesh",
+		Short:         "Control the ShadowMesh daemon",
+		Long:          "shadowmesh controls a running shadowmesh-daemon and manages its keys and configuration.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	apiDefault := os.Getenv("SHADOWMESH_API")
+	if apiDefault == "" {
+		apiDefault = DefaultAPIAddress
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.apiAddress, "api", apiDefault, "daemon API address (unix:/path/to/socket or host:port; env SHADOWMESH_API)")
+	flags.StringVar(&opts.apiToken, "api-token", "", "bearer token for a remote TCP API (env SHADOWMESH_API_TOKEN)")
+	flags.StringVarP(&opts.configPath, "config", "c", daemonmgr.DefaultConfigPath, "daemon configuration file")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "print machine-readable JSON")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Second, "API request timeout")
+
+	root.AddCommand(
+		newUpCommand(opts),
+		newDownCommand(opts),
+		newConnectCommand(opts),
+		newDisconnectCommand(opts),
+		newStatusCommand(opts),
+		newPeersCommand(opts),
+		newACLCommand(opts),
+		newKeysCommand(opts),
+		newNATCommand(opts),
+		newPingCommand(opts),
+		newWatchCommand(opts),
+		newConfigCommand(opts),
+		newVersionCommand(opts),
+	)
+
+	return root
+}
+
+// Execute runs the CLI and returns the process exit code
+func Execute() int {
+	if err := NewRootCommand().Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newVersionCommand builds `shadowmesh version`
+func newVersionCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the CLI version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.output(cmd, map[string]string{"version": Version}, func(w io.Writer) {
+				fmt.Fprintf(w, "shadowmesh v%s\n", Version)
+			})
+		},
+	}
+}
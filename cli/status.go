@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// newStatusCommand builds `shadowmesh status`
+func newStatusCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the daemon and tunnel status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var status daemonmgr.StatusResponse
+			if err := opts.client().Get("/status", &status); err != nil {
+				return err
+			}
+
+			return opts.output(cmd, status, func(w io.Writer) {
+				printStatus(w, status.Details)
+			})
+		},
+	}
+}
+
+// printStatus prints the human-readable status summary
+func printStatus(w io.Writer, status daemonmgr.DaemonStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "State:\t%s\n", status.State)
+	if status.LastError != "" {
+		fmt.Fprintf(tw, "Last error:\t%s\n", status.LastError)
+	}
+	if status.PeerAddress != "" {
+		fmt.Fprintf(tw, "Peer:\t%s\n", status.PeerAddress)
+	}
+	if status.Transport != "" {
+		fmt.Fprintf(tw, "Transport:\t%s\n", status.Transport)
+	}
+	fmt.Fprintf(tw, "Device:\t%s (%s)\n", status.TAPDevice, status.LocalIP)
+	if status.NAT != nil {
+		fmt.Fprintf(tw, "NAT:\t%s (public %s:%d, hole punching %d ok / %d failed)\n",
+			status.NAT.Type, status.NAT.PublicIP, status.NAT.PublicPort,
+			status.NAT.HolePunch.Successes, status.NAT.HolePunch.Failures+status.NAT.HolePunch.Timeouts)
+	}
+	fmt.Fprintf(tw, "Traffic:\ttx %d frames (%s), rx %d frames, %d dropped\n",
+		status.Pipeline.TxFrames, formatBytes(status.Pipeline.TxBytes), status.Pipeline.RxFrames, status.Pipeline.Dropped)
+
+	fmt.Fprintf(tw, "MTU:\t%d", status.MTU.DeviceMTU)
+	if status.MTU.PathMTU > 0 {
+		fmt.Fprintf(tw, " (path %d, %s)", status.MTU.PathMTU, status.MTU.DiscoveryState)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "Compression:\t%s", onOff(status.Compression))
+	if status.Compression {
+		fmt.Fprintf(tw, " (ratio %.2f)", status.CompressionRatio)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "FEC:\t%s", onOff(status.FEC.Enabled))
+	if status.FEC.Enabled {
+		fmt.Fprintf(tw, " (%d+%d, %d recovered)", status.FEC.DataShards, status.FEC.ParityShards, status.FEC.Recovered)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "Multipath:\t%s", onOff(status.Multipath.Enabled))
+	if status.Multipath.Enabled {
+		fmt.Fprintf(tw, " (%s, %d paths)", status.Multipath.Mode, len(status.Multipath.Paths))
+	}
+	fmt.Fprintln(tw)
+
+	switch exit := status.ExitNode; {
+	case exit.Role == "exit":
+		fmt.Fprintln(tw, "Exit node:\tadvertised")
+	case exit.Role == "client":
+		fmt.Fprintf(tw, "Exit node:\t%s", dash(exit.Peer))
+		if exit.KillSwitch {
+			fmt.Fprint(tw, " (kill switch)")
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if dns := status.DNS; dns != nil {
+		fmt.Fprintf(tw, "DNS:\t%s.%s", dns.Name, dns.Domain)
+		if len(dns.Listen) > 0 {
+			fmt.Fprintf(tw, " (on %s)", strings.Join(dns.Listen, ", "))
+		}
+		fmt.Fprintln(tw)
+		if len(dns.Conflicts) > 0 {
+			fmt.Fprintf(tw, "\tconflicting names: %s\n", strings.Join(dns.Conflicts, ", "))
+		}
+		if len(dns.Unverified) > 0 {
+			fmt.Fprintf(tw, "\tunverified names: %s\n", strings.Join(dns.Unverified, ", "))
+		}
+	}
+
+	if acl := status.ACL; acl != nil {
+		fmt.Fprintf(tw, "ACL:\t%d rules, %d denied inbound, %d outbound\n", len(acl.Rules), acl.DeniedInbound, acl.DeniedOutbound)
+	}
+
+	if neighbors := status.Neighbors; neighbors != nil && (neighbors.Proxy || neighbors.BroadcastLimit > 0 || neighbors.Suppressed > 0) {
+		fmt.Fprintf(tw, "Neighbours:\tproxy %s (%d bindings, %d answered), %d broadcasts suppressed\n",
+			onOff(neighbors.Proxy), neighbors.Bindings, neighbors.Answered, neighbors.Suppressed)
+	}
+
+	if len(status.Networks) > 0 {
+		networks := make([]string, 0, len(status.Networks))
+		for _, network := range status.Networks {
+			switch {
+			case network.VLAN != 0:
+				networks = append(networks, fmt.Sprintf("%s (vlan %d)", network.ID, network.VLAN))
+			case network.Device != "":
+				networks = append(networks, fmt.Sprintf("%s (%s)", network.ID, network.Device))
+			default:
+				networks = append(networks, network.ID)
+			}
+		}
+		fmt.Fprintf(tw, "Networks:\t%s\n", strings.Join(networks, ", "))
+	}
+
+	fmt.Fprintf(tw, "Key sequence:\t%d\n", status.KeySequence)
+}
+
+// onOff formats a boolean feature state
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+// newPeersCommand builds `shadowmesh peers`
+func newPeersCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "peers",
+		Short: "List peers and what was negotiated with them",
+		Args:  cobra.NoArgs,
+		Long: "List peers with what was negotiated with them and the traffic exchanged:\n" +
+			"name (marked if not proven by identity.peers or held by another peer),\n" +
+			"transport and path, NAT type, tunnel address and accepted subnets,\n" +
+			"round-trip time, loss in each direction, bytes received and sent, drops and\n" +
+			"the time of the last handshake.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var response daemonmgr.PeersResponse
+			if err := opts.client().Get("/peers", &response); err != nil {
+				return err
+			}
+
+			peers := response.Peers
+			if peers == nil {
+				peers = []daemonmgr.PeerStatus{}
+			}
+
+			return opts.output(cmd, peers, func(w io.Writer) {
+				if len(peers) == 0 {
+					fmt.Fprintln(w, "No peers")
+					return
+				}
+
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				defer tw.Flush()
+
+				fmt.Fprintln(tw, "SENDER ID\tNAME\tTRANSPORT\tNAT\tFEATURES\tADDRESS\tSUBNETS\tRTT\tLOSS IN\tLOSS OUT\tRX\tTX\tDROPS\tLAST HANDSHAKE")
+				for _, peer := range peers {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.1f ms\t%s\t%s\t%s\t%s\t%d\t%s\n",
+						peer.SenderID, peerName(peer), dash(peer.Transport), dash(peer.NATType), features(peer),
+						dash(strings.Join(peer.Addresses, ",")), dash(strings.Join(peer.Subnets, ",")),
+						peer.RTTMillis, percent(peer.InboundLoss), percent(peer.OutboundLoss),
+						formatBytes(peer.RxBytes), formatBytes(peer.TxBytes), totalDrops(peer.Drops),
+						peer.LastHandshake.Local().Format(time.RFC3339))
+				}
+			})
+		},
+	}
+}
+
+// peerName shows a peer's name and whether ACL rules and MagicDNS trust it
+func peerName(peer daemonmgr.PeerStatus) string {
+	switch {
+	case peer.Name == "" || peer.NameVerified:
+		return dash(peer.Name)
+	case peer.NameConflict:
+		return peer.Name + " (conflict)"
+	default:
+		return peer.Name + " (unverified)"
+	}
+}
+
+// features lists the session features negotiated with a peer
+func features(peer daemonmgr.PeerStatus) string {
+	var enabled []string
+	if peer.Compression {
+		enabled = append(enabled, "lz4")
+	}
+	if peer.FEC {
+		enabled = append(enabled, "fec")
+	}
+	if peer.Multipath {
+		enabled = append(enabled, "multipath")
+	}
+	if peer.Exit {
+		enabled = append(enabled, "exit")
+	}
+	if peer.SenderKey {
+		enabled = append(enabled, "sender-key")
+	}
+	return dash(strings.Join(enabled, ","))
+}
+
+// totalDrops sums drop counters over all reasons
+func totalDrops(drops map[string]uint64) uint64 {
+	var total uint64
+	for _, count := range drops {
+		total += count
+	}
+	return total
+}
+
+// dash stands in for an empty table cell
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// percent formats a fraction as a percentage
+func percent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
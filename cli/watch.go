@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/spf13/cobra"
+)
+
+// watchReconnectDelay is the pause before resuming an interrupted event stream
+const watchReconnectDelay = time.Second
+
+// errWatchDone stops the stream once --count events have been printed
+var errWatchDone = errors.New("watch done")
+
+// newWatchCommand builds `shadowmesh watch`
+func newWatchCommand(opts *options) *cobra.Command {
+	var (
+		types []string
+		count int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Stream daemon events as they happen",
+		Long: "Stream daemon events as they happen: state changes, path changes, NAT results,\n" +
+			"key rotations, peers joining and leaving, and bursts of dropped frames.\n" +
+			"With --json each event is printed as one JSON object per line.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			path := "/events"
+			if len(types) > 0 {
+				path += "?types=" + url.QueryEscape(strings.Join(types, ","))
+			}
+
+			client := opts.client()
+			w := cmd.OutOrStdout()
+			encoder := json.NewEncoder(w)
+
+			var lastID string
+			printed := 0
+			handle := func(message ServerEvent) error {
+				var event daemonmgr.Event
+				if err := json.Unmarshal(message.Data, &event); err != nil {
+					return fmt.Errorf("invalid event from daemon: %w", err)
+				}
+				lastID = message.ID
+
+				if opts.jsonOutput {
+					if err := encoder.Encode(event); err != nil {
+						return err
+					}
+				} else {
+					fmt.Fprintf(w, "%s  %-14s %s\n", event.Time.Local().Format("15:04:05"), event.Type, event)
+				}
+
+				printed++
+				if count > 0 && printed >= count {
+					return errWatchDone
+				}
+				return nil
+			}
+
+			for {
+				err := client.Stream(ctx, path, lastID, handle)
+				switch {
+				case err == nil, errors.Is(err, errWatchDone):
+					return nil
+				case !errors.Is(err, io.EOF) && lastID == "":
+					// Never connected: report instead of retrying forever
+					return err
+				}
+
+				if !opts.jsonOutput {
+					fmt.Fprintln(cmd.ErrOrStderr(), "⚠️  Event stream interrupted, reconnecting...")
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(watchReconnectDelay):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&types, "type", "t", nil, "only show these event types (e.g. state_changed,path_changed)")
+	cmd.Flags().IntVarP(&count, "count", "n", 0, "exit after this many events (0 = run until interrupted)")
+
+	return cmd
+}
@@ -2,22 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+	"github.com/shadowmesh/shadowmesh/pkg/metrics"
 )
 
+// frameSizeBuckets are upper bounds for the relayed frame size histogram
+var frameSizeBuckets = []float64{64, 128, 256, 512, 1024, 1500, 4096, 9000, 16384, 65536}
+
 // PeerConnection represents a connected peer
 type PeerConnection struct {
 	ID         string
+	Address    netip.Prefix               // Tunnel address assigned from the pool, if any
+	lease      string                     // Identity the peer's lease is kept under (ipam.Claim.ID); empty for none
+	Networks   []string                   // Virtual networks joined with network= (none: the default network)
+	networkIDs frameencryption.NetworkSet // Networks whose frames the peer receives
 	Conn       *websocket.Conn
 	SendChan   chan []byte
 	LastActive time.Time
@@ -30,11 +45,24 @@ type RelayServer struct {
 	peersMutex sync.RWMutex
 	upgrader   websocket.Upgrader
 	port       int
+	pool       *ipam.Pool      // Assigns tunnel addresses on connect; nil leaves addressing to the peers
+	networks   map[string]bool // Virtual networks peers may join with network=; others are refused
+
+	// Statistics (updated atomically), exported on /metrics
+	connectionsTotal uint64
+	framesReceived   uint64
+	bytesReceived    uint64
+	framesForwarded  uint64 // One per receiving peer
+	bytesForwarded   uint64
+	bufferDrops      uint64 // Frames dropped because a peer's send buffer was full
+	staleRemoved     uint64
+	frameSize        *metrics.Histogram
+	registry         *metrics.Registry
 }
 
 // NewRelayServer creates a new relay server
 func NewRelayServer(port int) *RelayServer {
-	return &RelayServer{
+	rs := &RelayServer{
 		peers: make(map[string]*PeerConnection),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  2 * 1024 * 1024, // 2MB (increased from 4KB for burst traffic)
@@ -43,8 +71,13 @@ func NewRelayServer(port int) *RelayServer {
 				return true // Accept all origins for now
 			},
 		},
-		port: port,
+		port:      port,
+		networks:  make(map[string]bool),
+		frameSize: metrics.NewHistogram(frameSizeBuckets),
+		registry:  metrics.NewRegistry(),
 	}
+	rs.registry.Register(rs.collectMetrics)
+	return rs
 }
 
 // handleWebSocket handles incoming WebSocket connections
@@ -56,6 +89,35 @@ func (rs *RelayServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only networks the relay was started with can be joined. Any peer may join them:
+	// joining only routes a network's frames, which are encrypted under its key
+	// (networks[].key), so peers without it can neither read nor send them.
+	networks := r.URL.Query()["network"]
+	for _, network := range networks {
+		if !rs.networks[network] {
+			log.Printf("❌ Peer %s refused: network %q is not served here", peerID, network)
+			http.Error(w, fmt.Sprintf("network %q is not served by this relay", network), http.StatusForbidden)
+			return
+		}
+	}
+
+	// Lease the peer's address before upgrading, so a refused lease is an HTTP error
+	assignment, lease, err := rs.lease(peerID, r)
+	if err != nil {
+		log.Printf("❌ Failed to lease an address to %s: %v", peerID, err)
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, ipam.ErrLeaseHeld):
+			status = http.StatusConflict
+		case errors.Is(err, ipam.ErrInvalidClaim):
+			status = http.StatusForbidden
+		case errors.Is(err, ipam.ErrPoolExhausted):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	// Upgrade to WebSocket
 	conn, err := rs.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -63,11 +125,25 @@ func (rs *RelayServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Send the assigned tunnel addresses before any frame, so the peer can configure its device
+	if assignment.Address.IsValid() {
+		if err := conn.WriteJSON(assignment); err != nil {
+			log.Printf("❌ Failed to send the assigned address to %s: %v", peerID, err)
+			conn.Close()
+			return
+		}
+	}
+
 	log.Printf("✅ Peer connected: %s from %s", peerID, r.RemoteAddr)
+	atomic.AddUint64(&rs.connectionsTotal, 1)
 
 	// Create peer connection
 	peer := &PeerConnection{
 		ID:         peerID,
+		Address:    assignment.Address,
+		lease:      lease,
+		Networks:   networks,
+		networkIDs: frameencryption.JoinNetworks(networks),
 		Conn:       conn,
 		SendChan:   make(chan []byte, 1000),
 		LastActive: time.Now(),
@@ -83,12 +159,13 @@ func (rs *RelayServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	rs.peers[peerID] = peer
 	rs.peersMutex.Unlock()
 
-	// Cleanup on disconnect
+	// Cleanup on disconnect; the lease expires the lease time after this
 	defer func() {
 		rs.peersMutex.Lock()
 		delete(rs.peers, peerID)
 		rs.peersMutex.Unlock()
 		conn.Close()
+		rs.renewLeases(lease)
 		log.Printf("🔌 Peer disconnected: %s", peerID)
 	}()
 
@@ -122,38 +199,90 @@ func (rs *RelayServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			atomic.AddUint64(&rs.framesReceived, 1)
+			atomic.AddUint64(&rs.bytesReceived, uint64(len(data)))
+			rs.frameSize.Observe(float64(len(data)))
+
 			// Update last active time
 			peer.mu.Lock()
 			peer.LastActive = time.Now()
 			peer.mu.Unlock()
 
-			// Forward frame to all other peers (broadcast mode for now)
-			rs.forwardFrame(peerID, data)
+			// Forward frame to the other peers in its network
+			rs.forwardFrame(peer, data)
 		}
 	}()
 
 	wg.Wait()
 }
 
-// forwardFrame forwards a frame from one peer to all others
-func (rs *RelayServer) forwardFrame(senderID string, frame []byte) {
+// lease reserves the peer's configured address (address=) or assigns it one from the pool
+// The lease is kept under the identity key of the claim in the ipam.ClaimHeader header,
+// which the peer signs with that key, so only its holder gets the address. It returns
+// the identity; the assignment is zero without a pool or for a peer with a configured
+// address. Peers without a claim get no lease.
+func (rs *RelayServer) lease(peerID string, r *http.Request) (ipam.Assignment, string, error) {
+	header := r.Header.Get(ipam.ClaimHeader)
+	if rs.pool == nil || header == "" {
+		if rs.pool != nil {
+			log.Printf("⚠️  Peer %s has no lease claim, it gets no address", peerID)
+		}
+		return ipam.Assignment{}, "", nil
+	}
+	claim, err := ipam.ParseClaim(header)
+	if err != nil {
+		return ipam.Assignment{}, "", err
+	}
+	if address := r.URL.Query().Get("address"); address != "" {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return ipam.Assignment{}, "", fmt.Errorf("invalid address: %w", err)
+		}
+		return ipam.Assignment{}, claim.ID(), rs.pool.Reserve(claim, addr)
+	}
+	assignment, err := rs.pool.Allocate(claim)
+	return assignment, claim.ID(), err
+}
+
+// renewLeases keeps the leases of connected peers, by identity, from expiring
+func (rs *RelayServer) renewLeases(identities ...string) {
+	if rs.pool == nil {
+		return
+	}
+	if err := rs.pool.Renew(identities...); err != nil {
+		log.Printf("⚠️  Failed to renew leases: %v", err)
+	}
+}
+
+// forwardFrame forwards a frame from one peer to all others in the frame's network
+// Peers only receive data frames of networks both they and the sender joined, and
+// control frames from senders they share a network with.
+func (rs *RelayServer) forwardFrame(sender *PeerConnection, frame []byte) {
 	rs.peersMutex.RLock()
 	defer rs.peersMutex.RUnlock()
 
+	senderID := sender.ID
 	forwarded := 0
 	for id, peer := range rs.peers {
 		if id == senderID {
 			continue // Don't forward to sender
 		}
+		if !peer.networkIDs.Relays(sender.networkIDs, frame) {
+			continue
+		}
 
 		select {
 		case peer.SendChan <- frame:
 			forwarded++
 		default:
+			atomic.AddUint64(&rs.bufferDrops, 1)
 			log.Printf("⚠️  Send buffer full for peer %s, dropping frame", id)
 		}
 	}
 
+	atomic.AddUint64(&rs.framesForwarded, uint64(forwarded))
+	atomic.AddUint64(&rs.bytesForwarded, uint64(forwarded*len(frame)))
+
 	if forwarded > 0 {
 		log.Printf("📤 Forwarded frame from %s to %d peer(s)", senderID, forwarded)
 	}
@@ -175,14 +304,40 @@ func (rs *RelayServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		peer.mu.Lock()
 		lastActive := peer.LastActive
 		peer.mu.Unlock()
-		fmt.Fprintf(w, `{"id":"%s","last_active":"%s"}`, id, lastActive.Format(time.RFC3339))
+		fmt.Fprintf(w, `{"id":"%s"`, id)
+		if peer.Address.IsValid() {
+			fmt.Fprintf(w, `,"address":"%s"`, peer.Address)
+		}
+		if len(peer.Networks) > 0 {
+			networks, _ := json.Marshal(peer.Networks)
+			fmt.Fprintf(w, `,"networks":%s`, networks)
+		}
+		fmt.Fprintf(w, `,"last_active":"%s"}`, lastActive.Format(time.RFC3339))
 		first = false
 	}
 
 	fmt.Fprint(w, `]}`)
 }
 
-// cleanupStaleConnections removes inactive peers
+// collectMetrics writes the relay's metrics for a /metrics scrape
+// Names match relay/server so the same Grafana dashboard covers both relays.
+func (rs *RelayServer) collectMetrics(w *metrics.Writer) {
+	rs.peersMutex.RLock()
+	peers := len(rs.peers)
+	rs.peersMutex.RUnlock()
+
+	w.Gauge("shadowmesh_relay_clients", "Connected clients.", float64(peers))
+	w.Counter("shadowmesh_relay_connections_total", "Client connections accepted.", float64(atomic.LoadUint64(&rs.connectionsTotal)))
+	w.Counter("shadowmesh_relay_frames_total", "Frames received from and forwarded to clients.", float64(atomic.LoadUint64(&rs.framesReceived)), metrics.L("direction", "in"))
+	w.Counter("shadowmesh_relay_frames_total", "Frames received from and forwarded to clients.", float64(atomic.LoadUint64(&rs.framesForwarded)), metrics.L("direction", "out"))
+	w.Counter("shadowmesh_relay_bytes_total", "Frame bytes received from and forwarded to clients.", float64(atomic.LoadUint64(&rs.bytesReceived)), metrics.L("direction", "in"))
+	w.Counter("shadowmesh_relay_bytes_total", "Frame bytes received from and forwarded to clients.", float64(atomic.LoadUint64(&rs.bytesForwarded)), metrics.L("direction", "out"))
+	w.Counter("shadowmesh_relay_dropped_frames_total", "Frames dropped, by reason.", float64(atomic.LoadUint64(&rs.bufferDrops)), metrics.L("reason", "send_buffer_full"))
+	w.Counter("shadowmesh_relay_stale_clients_total", "Clients disconnected for inactivity.", float64(atomic.LoadUint64(&rs.staleRemoved)))
+	w.Histogram("shadowmesh_relay_frame_size_bytes", "Size of frames received from clients.", rs.frameSize)
+}
+
+// cleanupStaleConnections removes inactive peers and renews the leases of the others
 func (rs *RelayServer) cleanupStaleConnections(ctx context.Context, timeout time.Duration) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -203,9 +358,17 @@ func (rs *RelayServer) cleanupStaleConnections(ctx context.Context, timeout time
 					log.Printf("🧹 Removing stale peer: %s (inactive for %v)", id, now.Sub(lastActive))
 					peer.Conn.Close()
 					delete(rs.peers, id)
+					atomic.AddUint64(&rs.staleRemoved, 1)
+				}
+			}
+			connected := make([]string, 0, len(rs.peers))
+			for _, peer := range rs.peers {
+				if peer.lease != "" {
+					connected = append(connected, peer.lease)
 				}
 			}
 			rs.peersMutex.Unlock()
+			rs.renewLeases(connected...)
 		}
 	}
 }
@@ -215,6 +378,7 @@ func (rs *RelayServer) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/relay", rs.handleWebSocket)
 	mux.HandleFunc("/status", rs.handleStatus)
+	mux.Handle("/metrics", rs.registry.Handler())
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "OK")
@@ -231,9 +395,10 @@ func (rs *RelayServer) Start(ctx context.Context) error {
 	// Start server
 	go func() {
 		log.Printf("🚀 ShadowMesh Relay Server starting on port %d", rs.port)
-		log.Printf("   WebSocket endpoint: ws://0.0.0.0:%d/relay?peer_id=<id>", rs.port)
+		log.Printf("   WebSocket endpoint: ws://0.0.0.0:%d/relay?peer_id=<id>[&network=<name>...][&address=<ip>]", rs.port)
 		log.Printf("   Status endpoint: http://0.0.0.0:%d/status", rs.port)
 		log.Printf("   Health endpoint: http://0.0.0.0:%d/health", rs.port)
+		log.Printf("   Metrics endpoint: http://0.0.0.0:%d/metrics", rs.port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("❌ Server error: %v", err)
 		}
@@ -252,10 +417,42 @@ func (rs *RelayServer) Start(ctx context.Context) error {
 
 func main() {
 	port := flag.Int("port", 9545, "Port to listen on")
+	pool := flag.String("pool", "", "IPv4 network to assign tunnel addresses from (e.g. 10.77.0.0/24); empty disables assignment")
+	pool6 := flag.String("pool6", "", "IPv6 ULA prefix to derive tunnel addresses in (e.g. fd77::/64); requires -pool")
+	leases := flag.String("leases", "leases.json", "File the assigned addresses are persisted in")
+	leaseTime := flag.Duration("lease-time", ipam.DefaultLeaseTime, "How long a lease outlives its peer's last connection")
+	networks := flag.String("networks", "", "Comma-separated virtual networks peers may join (e.g. office,lab); empty allows only the default network")
 	flag.Parse()
 
 	// Create relay server
 	relay := NewRelayServer(*port)
+	for _, network := range strings.Split(*networks, ",") {
+		if network = strings.TrimSpace(network); network != "" {
+			relay.networks[network] = true
+		}
+	}
+	if len(relay.networks) > 0 {
+		log.Printf("🌐 Serving virtual networks %s", *networks)
+	}
+	if *pool != "" {
+		prefix, err := netip.ParsePrefix(*pool)
+		if err != nil {
+			log.Fatalf("❌ Invalid -pool: %v", err)
+		}
+		var prefix6 netip.Prefix
+		if *pool6 != "" {
+			if prefix6, err = netip.ParsePrefix(*pool6); err != nil {
+				log.Fatalf("❌ Invalid -pool6: %v", err)
+			}
+		}
+		if relay.pool, err = ipam.NewPool(prefix, prefix6, *leases); err != nil {
+			log.Fatalf("❌ Address pool: %v", err)
+		}
+		relay.pool.SetLeaseTime(*leaseTime)
+		log.Printf("📇 Assigning tunnel addresses from %s %s (leases in %s, kept %v)", prefix, *pool6, *leases, *leaseTime)
+	} else if *pool6 != "" {
+		log.Fatalf("❌ -pool6 requires -pool")
+	}
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
@@ -9,12 +9,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
-	"gopkg.in/yaml.v3"
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
 )
 
 const (
@@ -34,23 +35,24 @@ func main() {
 	log.Printf("ShadowMesh Daemon v%s", version)
 	log.Printf("Loading configuration from: %s", configPath)
 
-	config, err := loadConfig(configPath)
+	config, err := daemonmgr.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Setup logging
-	setupLogging(config.Daemon.LogLevel)
-
 	// Validate configuration
-	if err := validateConfig(config); err != nil {
+	if err := config.Validate(); err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	log.Printf("Configuration loaded successfully")
-	log.Printf("  Listen Address: %s", config.Daemon.ListenAddress)
-	log.Printf("  TAP Device: %s", config.Network.TAPDevice)
-	log.Printf("  Local IP: %s", config.Network.LocalIP)
+	// Setup logging; everything from here on is structured
+	if err := logging.Configure(config.LoggingOptions()); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	logger := logging.Logger("daemon")
+
+	logger.Info("configuration loaded", "path", configPath, "version", version,
+		"socket", config.Daemon.Socket, "device", config.Network.TAPDevice, "address", config.Network.LocalIP)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -59,96 +61,53 @@ func main() {
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
 	// Create and start daemon
 	dm, err := daemonmgr.NewDaemonManager(config)
 	if err != nil {
-		log.Fatalf("Failed to create daemon: %v", err)
+		fatal(logger, "failed to create daemon", err)
 	}
 
 	// Start daemon
-	log.Printf("Starting ShadowMesh daemon...")
+	logger.Info("starting daemon")
 	if err := dm.Start(ctx); err != nil {
-		log.Fatalf("Failed to start daemon: %v", err)
+		fatal(logger, "failed to start daemon", err)
 	}
 
-	log.Printf("✅ ShadowMesh daemon started successfully")
-	log.Printf("   API listening on: %s", config.Daemon.ListenAddress)
-	log.Printf("   TAP device: %s (%s)", config.Network.TAPDevice, config.Network.LocalIP)
-	log.Printf("")
-	log.Printf("Use 'shadowmesh connect <peer-address>' to establish P2P connection")
-	log.Printf("Press Ctrl+C to shutdown gracefully")
+	attrs := []any{"socket", config.Daemon.Socket, "device", config.Network.TAPDevice, "address", config.Network.LocalIP}
+	if config.Daemon.ListenAddress != "" && config.Daemon.APIToken != "" {
+		attrs = append(attrs, "remote_api", config.Daemon.ListenAddress)
+	}
+	logger.Info("daemon started; connect with 'shadowmesh connect <peer-address>', reload with SIGHUP", attrs...)
+
+	// Reload the configuration on SIGHUP
+	go func() {
+		for range reloadChan {
+			logger.Info("SIGHUP received, reloading configuration")
+			if _, err := dm.Reload(); err != nil {
+				logger.Warn("configuration not reloaded", "error", err)
+			}
+		}
+	}()
 
 	// Wait for shutdown signal
 	<-sigChan
-	log.Printf("\n🛑 Shutdown signal received, stopping daemon...")
+	logger.Info("shutdown signal received, stopping daemon")
 
 	// Graceful shutdown
 	if err := dm.Stop(); err != nil {
-		log.Printf("⚠️  Error during shutdown: %v", err)
+		logger.Warn("error during shutdown", "error", err)
 	} else {
-		log.Printf("✅ Daemon stopped successfully")
-	}
-}
-
-// loadConfig loads daemon configuration from YAML file
-func loadConfig(path string) (*daemonmgr.DaemonConfig, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("read config file: %w", err)
-	}
-
-	var config daemonmgr.DaemonConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("parse YAML: %w", err)
-	}
-
-	// Set defaults
-	if config.Daemon.ListenAddress == "" {
-		config.Daemon.ListenAddress = "127.0.0.1:9090"
+		logger.Info("daemon stopped")
 	}
-	if config.Daemon.LogLevel == "" {
-		config.Daemon.LogLevel = "info"
-	}
-	if config.Network.TAPDevice == "" {
-		config.Network.TAPDevice = "tap0"
-	}
-	if config.NAT.STUNServer == "" {
-		config.NAT.STUNServer = "stun.l.google.com:19302"
-	}
-
-	return &config, nil
-}
-
-// validateConfig validates daemon configuration
-func validateConfig(config *daemonmgr.DaemonConfig) error {
-	if config.Network.LocalIP == "" {
-		return fmt.Errorf("network.local_ip is required")
-	}
-
-	if len(config.Encryption.Key) != 64 {
-		return fmt.Errorf("encryption.key must be 64 hex characters (32 bytes)")
-	}
-
-	return nil
 }
 
-// setupLogging configures logging based on log level
-func setupLogging(level string) {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-
-	switch level {
-	case "debug":
-		log.SetPrefix("[DEBUG] ")
-	case "info":
-		log.SetPrefix("[INFO] ")
-	case "warn":
-		log.SetPrefix("[WARN] ")
-	case "error":
-		log.SetPrefix("[ERROR] ")
-	default:
-		log.SetPrefix("[INFO] ")
-	}
+// fatal logs an error and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 // printUsage prints usage information
@@ -167,14 +126,17 @@ Example:
 Configuration file format (YAML):
   daemon:
     listen_address: "127.0.0.1:9090"
-    log_level: "info"
+    log_level: "info"     # debug, info, warn or error
+    log_format: "text"    # or json
+    log_levels:           # Per-component levels, e.g.
+      nat: debug
 
   network:
     tap_device: "tap0"
     local_ip: "10.0.0.1/24"
 
   encryption:
-    key: "0123456789abcdef..."  # 64 hex chars (32 bytes)
+    key_file: "/etc/shadowmesh/encryption.key"  # 64 hex chars (32 bytes), mode 0600
 
   peer:
     address: ""  # Set via CLI 'connect' command
@@ -183,5 +145,8 @@ Configuration file format (YAML):
     enabled: true
     stun_server: "stun.l.google.com:19302"
 
+Any field can be overridden with SHADOWMESH_<SECTION>_<FIELD>, e.g.
+SHADOWMESH_DAEMON_LOG_LEVEL=debug.
+
 `, version)
 }
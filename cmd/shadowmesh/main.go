@@ -0,0 +1,13 @@
+// Package main implements the shadowmesh command-line client
+// Commands are defined in the cli package; see `shadowmesh --help`.
+package main
+
+import (
+	"os"
+
+	"github.com/shadowmesh/shadowmesh/cli"
+)
+
+func main() {
+	os.Exit(cli.Execute())
+}
@@ -0,0 +1,562 @@
+// Package acl filters the packets exchanged with mesh peers
+//
+// Rules match the peer by name, group or address, the protocol, the port, the
+// direction a connection is opened in and the virtual network, and allow or deny it; the first matching rule
+// decides, else the default of the direction. The Engine is stateful: once a rule has
+// allowed a connection, its packets in both directions pass without being matched
+// again, so replies to connections we open need no inbound rule.
+package acl
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// Rule actions
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule directions: in matches connections peers open to us, out those we open to peers
+const (
+	In   = "in"
+	Out  = "out"
+	Both = "both"
+)
+
+// groupPrefix marks a group in a rule's peers, e.g. "group:admins"
+const groupPrefix = "group:"
+
+// Direction is the way a packet travels through the tunnel
+type Direction int
+
+const (
+	// Inbound is a packet received from a peer, before it is written to the device
+	Inbound Direction = iota
+	// Outbound is a packet read from the device, before it is sent to a peer
+	Outbound
+)
+
+// String returns "inbound" or "outbound"
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// Rule allows or denies the connections it matches
+type Rule struct {
+	Action    string   `yaml:"action" json:"action"`                 // Allow or Deny
+	Direction string   `yaml:"direction" json:"direction,omitempty"` // In (default), Out or Both
+	Peers     []string `yaml:"peers" json:"peers,omitempty"`         // Peer names, "group:<name>" or addresses and CIDRs; none matches any peer
+	Protocol  string   `yaml:"protocol" json:"protocol,omitempty"`   // tcp, udp, icmp or any (default)
+	Ports     []string `yaml:"ports" json:"ports,omitempty"`         // Ports or ranges such as "8000-8080" the connection is opened to; tcp and udp only
+	Networks  []string `yaml:"networks" json:"networks,omitempty"`   // Virtual networks (Flow.Network) the rule applies in; none: every network
+}
+
+// Config is the rule set of an Engine
+type Config struct {
+	DefaultInbound  string              // Action for connections no rule matches that peers open (default: Deny)
+	DefaultOutbound string              // Action for connections no rule matches that we open (default: Allow)
+	Groups          map[string][]string // Named lists of peer names and addresses
+	Rules           []Rule
+}
+
+// ValidAction reports whether action is Allow or Deny, or "" for the default
+func ValidAction(action string) bool {
+	return action == "" || action == Allow || action == Deny
+}
+
+// ValidateGroup checks the members of a group: peer names, addresses and CIDRs
+func ValidateGroup(name string, members []string) error {
+	if name == "" || strings.ContainsAny(name, ": \t") {
+		return fmt.Errorf("group name must not be empty or contain colons or spaces, got %q", name)
+	}
+	for i, member := range members {
+		if strings.HasPrefix(member, groupPrefix) {
+			return fmt.Errorf("member %d: groups cannot contain groups, got %q", i, member)
+		}
+		if _, _, err := parsePeer(member); err != nil {
+			return fmt.Errorf("member %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a rule; groups are the groups it may refer to
+func (r *Rule) Validate(groups map[string][]string) error {
+	if r.Action != Allow && r.Action != Deny {
+		return fmt.Errorf("action must be allow or deny, got %q", r.Action)
+	}
+	switch r.Direction {
+	case "", In, Out, Both:
+	default:
+		return fmt.Errorf("direction must be in, out or both, got %q", r.Direction)
+	}
+	for _, peer := range r.Peers {
+		if name, ok := strings.CutPrefix(peer, groupPrefix); ok {
+			if _, defined := groups[name]; !defined {
+				return fmt.Errorf("peers: group %q is not defined", name)
+			}
+			continue
+		}
+		if _, _, err := parsePeer(peer); err != nil {
+			return fmt.Errorf("peers: %w", err)
+		}
+	}
+	switch strings.ToLower(r.Protocol) {
+	case "", "any", "tcp", "udp", "icmp":
+	default:
+		return fmt.Errorf("protocol must be tcp, udp, icmp or any, got %q", r.Protocol)
+	}
+	if len(r.Ports) > 0 {
+		if protocol := strings.ToLower(r.Protocol); protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("ports need protocol tcp or udp")
+		}
+	}
+	for _, port := range r.Ports {
+		if _, err := parsePorts(port); err != nil {
+			return fmt.Errorf("ports: %w", err)
+		}
+	}
+	if slices.Contains(r.Networks, "") {
+		return fmt.Errorf("networks: network names must not be empty")
+	}
+	return nil
+}
+
+// Names returns the peer names the rule matches, including those of its groups, lower case
+// Addresses, CIDRs and "*" are left out. groups are the groups it may refer to.
+func (r *Rule) Names(groups map[string][]string) []string {
+	var peers, names []string
+	for _, peer := range r.Peers {
+		if name, ok := strings.CutPrefix(peer, groupPrefix); ok {
+			peers = append(peers, groups[name]...)
+		} else {
+			peers = append(peers, peer)
+		}
+	}
+	for _, peer := range peers {
+		if peer == "*" {
+			continue
+		}
+		if name, _, err := parsePeer(peer); err == nil && name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// String summarises the rule, e.g. "allow in tcp 22 from group:admins"
+func (r *Rule) String() string {
+	direction := r.Direction
+	if direction == "" {
+		direction = In
+	}
+	protocol := strings.ToLower(r.Protocol)
+	if protocol == "" {
+		protocol = "any"
+	}
+
+	parts := []string{r.Action, direction, protocol}
+	if len(r.Ports) > 0 {
+		parts = append(parts, strings.Join(r.Ports, ","))
+	}
+	peers := "*"
+	if len(r.Peers) > 0 {
+		peers = strings.Join(r.Peers, ",")
+	}
+	switch direction {
+	case In:
+		parts = append(parts, "from", peers)
+	case Out:
+		parts = append(parts, "to", peers)
+	default:
+		parts = append(parts, "with", peers)
+	}
+	if len(r.Networks) > 0 {
+		parts = append(parts, "in network", strings.Join(r.Networks, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parsePeer parses a peer name, address or CIDR; "*" matches any peer
+// Names are returned lower case, as peers announce them.
+func parsePeer(peer string) (name string, prefix netip.Prefix, err error) {
+	if prefix, err := netip.ParsePrefix(peer); err == nil {
+		return "", prefix.Masked(), nil
+	}
+	if addr, err := netip.ParseAddr(peer); err == nil {
+		return "", netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	if peer == "" || strings.ContainsAny(peer, ":/ \t") {
+		return "", netip.Prefix{}, fmt.Errorf("must be a peer name, an address or a CIDR, got %q", peer)
+	}
+	return strings.ToLower(peer), netip.Prefix{}, nil
+}
+
+// portRange is an inclusive range of ports
+type portRange struct {
+	first, last uint16
+}
+
+// parsePorts parses a port such as "22" or a range such as "8000-8080"
+func parsePorts(ports string) (portRange, error) {
+	first, last, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		last = first
+	}
+	from, err1 := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	to, err2 := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err1 != nil || err2 != nil || from == 0 || from > to {
+		return portRange{}, fmt.Errorf("must be a port or a range like 8000-8080, got %q", ports)
+	}
+	return portRange{first: uint16(from), last: uint16(to)}, nil
+}
+
+// compiledRule is a Rule prepared for matching, with its hit counter
+type compiledRule struct {
+	rule      Rule
+	allow     bool
+	inbound   bool
+	outbound  bool
+	anyPeer   bool
+	names     map[string]bool
+	prefixes  []netip.Prefix
+	protocols []uint8 // None matches any protocol
+	ports     []portRange
+	networks  map[string]bool // None matches any network
+	hits      atomic.Uint64
+}
+
+// matches reports whether the rule applies to a new connection in direction dir
+func (r *compiledRule) matches(dir Direction, peer string, flow *Flow) bool {
+	if dir == Inbound && !r.inbound || dir == Outbound && !r.outbound {
+		return false
+	}
+	if len(r.networks) > 0 && !r.networks[flow.Network] {
+		return false
+	}
+
+	if !r.anyPeer && !r.names[peer] {
+		remote := flow.Src
+		if dir == Outbound {
+			remote = flow.Dst
+		}
+		found := false
+		for _, prefix := range r.prefixes {
+			if prefix.Contains(remote) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.protocols) > 0 {
+		found := false
+		for _, protocol := range r.protocols {
+			if protocol == flow.Protocol {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.ports) > 0 {
+		if !flow.HasPorts {
+			return false
+		}
+		for _, ports := range r.ports {
+			if flow.DstPort >= ports.first && flow.DstPort <= ports.last {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ruleset is a compiled Config
+type ruleset struct {
+	rules       []*compiledRule
+	allow       [2]bool          // Default action by Direction
+	defaultHits [2]atomic.Uint64 // Connections the default decided, by Direction
+}
+
+// compile validates and prepares a Config
+func compile(config Config) (*ruleset, error) {
+	if !ValidAction(config.DefaultInbound) {
+		return nil, fmt.Errorf("default inbound action must be allow or deny, got %q", config.DefaultInbound)
+	}
+	if !ValidAction(config.DefaultOutbound) {
+		return nil, fmt.Errorf("default outbound action must be allow or deny, got %q", config.DefaultOutbound)
+	}
+	for name, members := range config.Groups {
+		if err := ValidateGroup(name, members); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+
+	rs := &ruleset{}
+	rs.allow[Inbound] = config.DefaultInbound == Allow
+	rs.allow[Outbound] = config.DefaultOutbound != Deny
+
+	for i := range config.Rules {
+		rule := config.Rules[i]
+		if err := rule.Validate(config.Groups); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		compiled := &compiledRule{
+			rule:     rule,
+			allow:    rule.Action == Allow,
+			inbound:  rule.Direction != Out,
+			outbound: rule.Direction == Out || rule.Direction == Both,
+			anyPeer:  len(rule.Peers) == 0,
+			names:    make(map[string]bool),
+		}
+
+		var peers []string
+		for _, peer := range rule.Peers {
+			if name, ok := strings.CutPrefix(peer, groupPrefix); ok {
+				peers = append(peers, config.Groups[name]...)
+			} else {
+				peers = append(peers, peer)
+			}
+		}
+		for _, peer := range peers {
+			if peer == "*" {
+				compiled.anyPeer = true
+				continue
+			}
+			name, prefix, _ := parsePeer(peer) // Validated above
+			if name != "" {
+				compiled.names[name] = true
+			} else {
+				compiled.prefixes = append(compiled.prefixes, prefix)
+			}
+		}
+
+		switch strings.ToLower(rule.Protocol) {
+		case "tcp":
+			compiled.protocols = []uint8{protoTCP}
+		case "udp":
+			compiled.protocols = []uint8{protoUDP}
+		case "icmp":
+			compiled.protocols = []uint8{protoICMP, protoICMPv6}
+		}
+		for _, ports := range rule.Ports {
+			portRange, _ := parsePorts(ports) // Validated above
+			compiled.ports = append(compiled.ports, portRange)
+		}
+		if len(rule.Networks) > 0 {
+			compiled.networks = make(map[string]bool)
+			for _, network := range rule.Networks {
+				compiled.networks[network] = true
+			}
+		}
+
+		rs.rules = append(rs.rules, compiled)
+	}
+	return rs, nil
+}
+
+// match returns the first rule matching a new connection; nil if the default decides
+func (rs *ruleset) match(dir Direction, peer string, flow *Flow) *compiledRule {
+	for _, rule := range rs.rules {
+		if rule.matches(dir, peer, flow) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// decide returns whether a new connection is allowed, counting the rule or default that decided
+func (rs *ruleset) decide(dir Direction, peer string, flow *Flow) bool {
+	if rule := rs.match(dir, peer, flow); rule != nil {
+		rule.hits.Add(1)
+		return rule.allow
+	}
+	rs.defaultHits[dir].Add(1)
+	return rs.allow[dir]
+}
+
+// permits reports whether the connection origin opened is allowed, without counting
+func (rs *ruleset) permits(origin *connOrigin) bool {
+	if rule := rs.match(origin.dir, origin.peer, &origin.flow); rule != nil {
+		return rule.allow
+	}
+	return rs.allow[origin.dir]
+}
+
+// Engine filters packets by a rule set, tracking the connections it allows
+// Thread-safe; Update replaces the rules while packets are filtered.
+type Engine struct {
+	rules       atomic.Pointer[ruleset]
+	conns       *conntrack
+	established atomic.Uint64
+	denied      [2]atomic.Uint64
+	now         func() time.Time
+}
+
+// NewEngine creates an engine filtering by config
+func NewEngine(config Config) (*Engine, error) {
+	rs, err := compile(config)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{conns: newConntrack(), now: time.Now}
+	e.rules.Store(rs)
+	return e, nil
+}
+
+// Update replaces the rules; hit counters restart
+// Each tracked connection is checked against the new rules as the packet that opened
+// it, by the same peer and direction: those still allowed are kept, the others cut.
+func (e *Engine) Update(config Config) error {
+	rs, err := compile(config)
+	if err != nil {
+		return err
+	}
+	e.rules.Store(rs)
+	e.conns.recheck(rs.permits)
+	return nil
+}
+
+// Allow decides whether a packet may pass
+// peer is the name of the peer the packet comes from (Inbound) or goes to (Outbound),
+// or "" if unknown. Packets of tracked connections and ICMP errors about them pass;
+// otherwise the rules decide, and allowed connections are tracked. ICMPv6 neighbour
+// discovery always passes, as the link depends on it.
+func (e *Engine) Allow(dir Direction, peer string, flow Flow) bool {
+	if flow.neighborDisc {
+		return true
+	}
+	now := e.now()
+
+	if flow.laterFrag {
+		// Fragments without a transport header follow the first fragment
+		if _, ok := e.conns.touch(flow.fragmentKey(), now); ok {
+			e.established.Add(1)
+			return true
+		}
+		e.denied[dir].Add(1)
+		return false
+	}
+
+	origin, tracked := e.conns.touch(flow.key(), now)
+	if !tracked && flow.embedded != nil {
+		flow.embedded.Network = flow.Network
+		origin, tracked = e.conns.touch(flow.embedded.key(), now)
+	}
+	if tracked {
+		e.established.Add(1)
+	} else if e.rules.Load().decide(dir, peer, &flow) {
+		origin = connOrigin{dir: dir, peer: peer, flow: flow}
+		e.conns.add(flow.key(), origin, now)
+	} else {
+		e.denied[dir].Add(1)
+		return false
+	}
+
+	if flow.fragment {
+		// The fragments follow the connection, also when the rules change
+		e.conns.add(flow.fragmentKey(), origin, now)
+	}
+	return true
+}
+
+// AllowPacket decides whether an IP packet may pass; see Allow
+// Data that is not an IP packet is decided by the default of the direction.
+func (e *Engine) AllowPacket(dir Direction, peer string, packet []byte) bool {
+	flow, ok := ParsePacket(packet)
+	if !ok {
+		return e.allowDefault(dir)
+	}
+	return e.Allow(dir, peer, flow)
+}
+
+// AllowEtherType decides whether a frame that does not carry IP may pass
+// ARP always passes, as the link depends on it; other protocols are decided by the
+// default of the direction.
+func (e *Engine) AllowEtherType(dir Direction, etherType uint16) bool {
+	if etherType == layer2.EtherTypeARP {
+		return true
+	}
+	return e.allowDefault(dir)
+}
+
+// allowDefault applies the default action of the direction
+func (e *Engine) allowDefault(dir Direction) bool {
+	rs := e.rules.Load()
+	rs.defaultHits[dir].Add(1)
+	if !rs.allow[dir] {
+		e.denied[dir].Add(1)
+		return false
+	}
+	return true
+}
+
+// RuleStats reports how often one rule decided
+type RuleStats struct {
+	Rule   string `json:"rule"`   // Summary, see Rule.String
+	Action string `json:"action"` // Allow or Deny
+	Hits   uint64 `json:"hits"`   // Connections the rule allowed or denied since the rules were loaded
+}
+
+// DefaultStats reports how often the default action of a direction decided
+type DefaultStats struct {
+	Action string `json:"action"`
+	Hits   uint64 `json:"hits"`
+}
+
+// Stats is a snapshot of the engine's counters
+type Stats struct {
+	Rules           []RuleStats  `json:"rules"`
+	DefaultInbound  DefaultStats `json:"default_inbound"`
+	DefaultOutbound DefaultStats `json:"default_outbound"`
+	Connections     int          `json:"connections"`     // Connections tracked now
+	Evicted         uint64       `json:"evicted"`         // Connections forgotten early because the table or a peer's share was full
+	Established     uint64       `json:"established"`     // Packets passed as part of tracked connections
+	DeniedInbound   uint64       `json:"denied_inbound"`  // Packets from peers dropped
+	DeniedOutbound  uint64       `json:"denied_outbound"` // Packets to peers dropped
+}
+
+// Stats returns the hit counters of the rules and the traffic counters
+func (e *Engine) Stats() Stats {
+	rs := e.rules.Load()
+	stats := Stats{
+		Rules:          make([]RuleStats, len(rs.rules)),
+		Connections:    e.conns.active(e.now()),
+		Evicted:        e.conns.evictions(),
+		Established:    e.established.Load(),
+		DeniedInbound:  e.denied[Inbound].Load(),
+		DeniedOutbound: e.denied[Outbound].Load(),
+	}
+	for i, rule := range rs.rules {
+		stats.Rules[i] = RuleStats{Rule: rule.rule.String(), Action: rule.rule.Action, Hits: rule.hits.Load()}
+	}
+	for dir, defaults := range []*DefaultStats{&stats.DefaultInbound, &stats.DefaultOutbound} {
+		defaults.Action = Deny
+		if rs.allow[dir] {
+			defaults.Action = Allow
+		}
+		defaults.Hits = rs.defaultHits[dir].Load()
+	}
+	return stats
+}
@@ -0,0 +1,460 @@
+package acl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ipv4 builds an IPv4 packet; flags holds the flags and fragment offset field
+func ipv4(protocol uint8, src, dst string, flags uint16, transport []byte) []byte {
+	packet := make([]byte, 20, 20+len(transport))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(transport)))
+	binary.BigEndian.PutUint16(packet[4:6], 0x1234)
+	binary.BigEndian.PutUint16(packet[6:8], flags)
+	packet[8] = 64
+	packet[9] = protocol
+	copy(packet[12:16], netip.MustParseAddr(src).AsSlice())
+	copy(packet[16:20], netip.MustParseAddr(dst).AsSlice())
+	return append(packet, transport...)
+}
+
+// ipv6 builds an IPv6 packet with a destination options header before the transport header
+func ipv6(protocol uint8, src, dst string, transport []byte) []byte {
+	packet := make([]byte, 48, 48+len(transport))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(8+len(transport)))
+	packet[6] = 60
+	packet[7] = 64
+	copy(packet[8:24], netip.MustParseAddr(src).AsSlice())
+	copy(packet[24:40], netip.MustParseAddr(dst).AsSlice())
+	packet[40] = protocol
+	return append(packet, transport...)
+}
+
+// ports builds a TCP or UDP header start
+func ports(src, dst uint16) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:2], src)
+	binary.BigEndian.PutUint16(header[2:4], dst)
+	return header
+}
+
+// echo builds an ICMP or ICMPv6 echo message
+func echo(icmpType uint8, id uint16) []byte {
+	message := make([]byte, 8)
+	message[0] = icmpType
+	binary.BigEndian.PutUint16(message[4:6], id)
+	return message
+}
+
+// flow parses a packet built by the helpers
+func flow(t *testing.T, packet []byte) Flow {
+	t.Helper()
+	f, ok := ParsePacket(packet)
+	if !ok {
+		t.Fatalf("ParsePacket(% x) failed", packet)
+	}
+	return f
+}
+
+// TestParsePacket tests reading addresses, protocols and ports
+func TestParsePacket(t *testing.T) {
+	tcp := flow(t, ipv4(protoTCP, "10.0.0.2", "10.0.0.1", 0x4000, ports(40000, 22)))
+	if tcp.Protocol != protoTCP || tcp.Src.String() != "10.0.0.2" || tcp.Dst.String() != "10.0.0.1" ||
+		!tcp.HasPorts || tcp.SrcPort != 40000 || tcp.DstPort != 22 || tcp.fragment {
+		t.Errorf("TCP over IPv4 = %+v", tcp)
+	}
+
+	udp := flow(t, ipv6(protoUDP, "fd00::2", "fd00::1", ports(5353, 53)))
+	if udp.Protocol != protoUDP || udp.Dst.String() != "fd00::1" || udp.SrcPort != 5353 || udp.DstPort != 53 {
+		t.Errorf("UDP over IPv6 after extension header = %+v", udp)
+	}
+
+	ping := flow(t, ipv4(protoICMP, "10.0.0.2", "10.0.0.1", 0, echo(8, 77)))
+	if !ping.HasPorts || ping.SrcPort != 77 || ping.DstPort != 77 || ping.ICMPType != 8 {
+		t.Errorf("ICMP echo = %+v", ping)
+	}
+
+	later := flow(t, ipv4(protoUDP, "10.0.0.2", "10.0.0.1", 185, []byte{1, 2, 3, 4}))
+	if !later.fragment || !later.laterFrag || later.HasPorts {
+		t.Errorf("later fragment = %+v", later)
+	}
+
+	solicit := flow(t, ipv6(protoICMPv6, "fe80::2", "ff02::1:ff00:1", []byte{135, 0, 0, 0}))
+	if !solicit.neighborDisc {
+		t.Errorf("neighbour solicitation = %+v, want neighbour discovery", solicit)
+	}
+
+	for _, data := range [][]byte{nil, {0x45, 0}, make([]byte, 40), {0x60}} {
+		if _, ok := ParsePacket(data); ok {
+			t.Errorf("ParsePacket(% x) succeeded", data)
+		}
+	}
+}
+
+// newTestEngine creates an engine and fails the test on error
+func newTestEngine(t *testing.T, config Config) *Engine {
+	t.Helper()
+	e, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine() failed: %v", err)
+	}
+	return e
+}
+
+// TestEngineInbound tests rules for connections peers open
+func TestEngineInbound(t *testing.T) {
+	e := newTestEngine(t, Config{
+		Groups: map[string][]string{"admins": {"Alice", "10.0.0.9"}},
+		Rules: []Rule{
+			{Action: Allow, Peers: []string{"group:admins"}, Protocol: "tcp", Ports: []string{"22"}},
+			{Action: Allow, Protocol: "icmp"},
+			{Action: Deny, Peers: []string{"bob"}, Protocol: "udp"},
+			{Action: Allow, Protocol: "udp", Ports: []string{"8000-8100"}},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		peer   string
+		packet []byte
+		want   bool
+	}{
+		{"admin by name to ssh", "alice", ipv4(protoTCP, "10.0.0.2", "10.0.0.1", 0, ports(40000, 22)), true},
+		{"admin by address to ssh", "", ipv4(protoTCP, "10.0.0.9", "10.0.0.1", 0, ports(40000, 22)), true},
+		{"admin to http", "alice", ipv4(protoTCP, "10.0.0.2", "10.0.0.1", 0, ports(40001, 80)), false},
+		{"other peer to ssh", "carol", ipv4(protoTCP, "10.0.0.3", "10.0.0.1", 0, ports(40000, 22)), false},
+		{"ping", "carol", ipv4(protoICMP, "10.0.0.3", "10.0.0.1", 0, echo(8, 1)), true},
+		{"ping6", "carol", ipv6(protoICMPv6, "fd00::3", "fd00::1", echo(128, 1)), true},
+		{"denied peer to port range", "bob", ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 0, ports(5000, 8080)), false},
+		{"port range", "carol", ipv4(protoUDP, "10.0.0.3", "10.0.0.1", 0, ports(5000, 8080)), true},
+		{"neighbour discovery", "bob", ipv6(protoICMPv6, "fe80::4", "ff02::1:ff00:1", []byte{135, 0, 0, 0}), true},
+	}
+	for _, tt := range tests {
+		if got := e.AllowPacket(Inbound, tt.peer, tt.packet); got != tt.want {
+			t.Errorf("%s: AllowPacket() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The reply to an allowed connection passes; one to a denied connection is a new outbound one
+	if !e.AllowPacket(Outbound, "alice", ipv4(protoTCP, "10.0.0.1", "10.0.0.2", 0, ports(22, 40000))) {
+		t.Error("reply to allowed connection denied")
+	}
+
+	stats := e.Stats()
+	wantHits := []uint64{2, 2, 1, 1}
+	for i, want := range wantHits {
+		if stats.Rules[i].Hits != want {
+			t.Errorf("rule %d (%s) hits = %d, want %d", i, stats.Rules[i].Rule, stats.Rules[i].Hits, want)
+		}
+	}
+	if stats.DefaultInbound.Action != Deny || stats.DefaultInbound.Hits != 2 {
+		t.Errorf("default inbound = %+v, want deny with 2 hits", stats.DefaultInbound)
+	}
+	if stats.DeniedInbound != 3 || stats.Established != 1 || stats.Connections != 5 {
+		t.Errorf("stats = %+v, want 3 denied inbound, 1 established and 5 connections", stats)
+	}
+	if stats.Rules[0].Rule != "allow in tcp 22 from group:admins" {
+		t.Errorf("rule summary = %q", stats.Rules[0].Rule)
+	}
+}
+
+// TestEngineOutbound tests that replies to our connections pass and outbound rules
+func TestEngineOutbound(t *testing.T) {
+	e := newTestEngine(t, Config{
+		Rules: []Rule{{Action: Deny, Direction: Out, Peers: []string{"10.0.0.0/30"}, Protocol: "tcp", Ports: []string{"25"}}},
+	})
+
+	if !e.AllowPacket(Outbound, "bob", ipv4(protoTCP, "10.0.0.1", "10.0.0.4", 0, ports(41000, 443))) {
+		t.Fatal("outbound connection denied by default allow")
+	}
+	if !e.AllowPacket(Inbound, "bob", ipv4(protoTCP, "10.0.0.4", "10.0.0.1", 0, ports(443, 41000))) {
+		t.Error("reply to outbound connection denied")
+	}
+	if e.AllowPacket(Inbound, "bob", ipv4(protoTCP, "10.0.0.4", "10.0.0.1", 0, ports(443, 41001))) {
+		t.Error("inbound packet without connection allowed")
+	}
+	if e.AllowPacket(Outbound, "alice", ipv4(protoTCP, "10.0.0.1", "10.0.0.2", 0, ports(41002, 25))) {
+		t.Error("outbound connection allowed despite deny out rule")
+	}
+	if !e.AllowPacket(Outbound, "bob", ipv4(protoTCP, "10.0.0.1", "10.0.0.4", 0, ports(41003, 25))) {
+		t.Error("deny out rule matched an address outside its CIDR")
+	}
+
+	stats := e.Stats()
+	if stats.DefaultOutbound.Action != Allow || stats.DefaultOutbound.Hits != 2 || stats.DeniedOutbound != 1 {
+		t.Errorf("stats = %+v, want default outbound allow with 2 hits and 1 denied", stats)
+	}
+}
+
+// TestEngineFragmentsAndErrors tests later fragments and ICMP errors about tracked connections
+func TestEngineFragmentsAndErrors(t *testing.T) {
+	e := newTestEngine(t, Config{Rules: []Rule{{Action: Allow, Protocol: "udp", Ports: []string{"4789"}}}})
+
+	if e.AllowPacket(Inbound, "bob", ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 185, []byte{1, 2, 3, 4})) {
+		t.Error("later fragment without its first fragment allowed")
+	}
+	if !e.AllowPacket(Inbound, "bob", ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 0x2000, ports(5000, 4789))) {
+		t.Fatal("first fragment denied")
+	}
+	if !e.AllowPacket(Inbound, "bob", ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 185, []byte{1, 2, 3, 4})) {
+		t.Error("later fragment of allowed packet denied")
+	}
+
+	// Our reply bounces: the peer's unreachable quotes it
+	quoted := ipv4(protoUDP, "10.0.0.1", "10.0.0.4", 0, ports(4789, 5000))
+	unreachable := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, quoted...)
+	if !e.AllowPacket(Inbound, "bob", ipv4(protoICMP, "10.0.0.4", "10.0.0.1", 0, unreachable)) {
+		t.Error("ICMP error about tracked connection denied")
+	}
+	stray := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, ipv4(protoUDP, "10.0.0.1", "10.0.0.4", 0, ports(4789, 6000))...)
+	if e.AllowPacket(Inbound, "bob", ipv4(protoICMP, "10.0.0.4", "10.0.0.1", 0, stray)) {
+		t.Error("ICMP error about untracked connection allowed")
+	}
+}
+
+// TestEngineUpdate tests that new rules cut connections they deny and keep the others
+func TestEngineUpdate(t *testing.T) {
+	config := Config{Rules: []Rule{
+		{Action: Allow, Peers: []string{"alice"}, Protocol: "tcp", Ports: []string{"22"}},
+		{Action: Allow, Peers: []string{"bob"}, Protocol: "tcp", Ports: []string{"22"}},
+	}}
+	e := newTestEngine(t, config)
+
+	alice := ipv4(protoTCP, "10.0.0.2", "10.0.0.1", 0, ports(40000, 22))
+	bob := ipv4(protoTCP, "10.0.0.4", "10.0.0.1", 0, ports(40000, 22))
+	dave := ipv4(protoTCP, "10.0.0.1", "10.0.0.5", 0, ports(41000, 443))
+	daveReply := ipv4(protoTCP, "10.0.0.5", "10.0.0.1", 0, ports(443, 41000))
+	if !e.AllowPacket(Inbound, "alice", alice) || !e.AllowPacket(Inbound, "bob", bob) || !e.AllowPacket(Outbound, "dave", dave) {
+		t.Fatal("connections denied before update")
+	}
+
+	// Fragments of bob's connection follow it
+	if !e.AllowPacket(Inbound, "bob", ipv4(protoTCP, "10.0.0.4", "10.0.0.1", 0x2000, ports(40000, 22))) {
+		t.Fatal("first fragment of bob's connection denied")
+	}
+	bobFragment := ipv4(protoTCP, "10.0.0.4", "10.0.0.1", 185, []byte{1, 2, 3, 4})
+
+	config.Rules = config.Rules[:1]
+	if err := e.Update(config); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if !e.AllowPacket(Inbound, "alice", alice) {
+		t.Error("connection still allowed denied after update")
+	}
+	if !e.AllowPacket(Inbound, "dave", daveReply) {
+		t.Error("reply to our connection, still allowed outbound, denied after update")
+	}
+	if e.AllowPacket(Inbound, "bob", bob) {
+		t.Error("connection no longer allowed passed after update")
+	}
+	if e.AllowPacket(Inbound, "bob", bobFragment) {
+		t.Error("fragment of a connection no longer allowed passed after update")
+	}
+
+	// The kept connections are not decided again
+	stats := e.Stats()
+	if stats.Rules[0].Hits != 0 || stats.Connections != 2 {
+		t.Errorf("hits, connections after update = %d, %d, want 0, 2", stats.Rules[0].Hits, stats.Connections)
+	}
+
+	if err := e.Update(Config{DefaultInbound: "drop"}); err == nil {
+		t.Error("Update() with invalid default succeeded")
+	}
+	if len(e.Stats().Rules) != 1 {
+		t.Error("failed Update() replaced the rules")
+	}
+}
+
+// TestEngineNetworks tests that rules apply in their networks and connections are tracked per network
+func TestEngineNetworks(t *testing.T) {
+	e := newTestEngine(t, Config{Rules: []Rule{
+		{Action: Allow, Protocol: "tcp", Ports: []string{"22"}, Networks: []string{"lab"}},
+		{Action: Allow, Protocol: "icmp"},
+	}})
+	inNetwork := func(network string, packet []byte) Flow {
+		f := flow(t, packet)
+		f.Network = network
+		return f
+	}
+
+	ssh := ipv4(protoTCP, "10.0.0.2", "10.0.0.1", 0, ports(40000, 22))
+	reply := ipv4(protoTCP, "10.0.0.1", "10.0.0.2", 0, ports(22, 40000))
+	tests := []struct {
+		name    string
+		dir     Direction
+		network string
+		packet  []byte
+		want    bool
+	}{
+		{"ssh in its network", Inbound, "lab", ssh, true},
+		{"ssh in another network", Inbound, "guest", ssh, false},
+		{"ssh in the default network", Inbound, "", ssh, false},
+		{"ping in any network", Inbound, "guest", ipv4(protoICMP, "10.0.0.2", "10.0.0.1", 0, echo(8, 1)), true},
+		{"reply in the network of the connection", Outbound, "lab", reply, true},
+		{"same reply in another network", Outbound, "guest", reply, true},
+	}
+	for _, tt := range tests {
+		if got := e.Allow(tt.dir, "alice", inNetwork(tt.network, tt.packet)); got != tt.want {
+			t.Errorf("%s: Allow() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Only the reply in another network is a new connection, passed by the default
+	stats := e.Stats()
+	if stats.DefaultOutbound.Hits != 1 || stats.Established != 1 {
+		t.Errorf("default outbound hits, established = %d, %d, want 1, 1", stats.DefaultOutbound.Hits, stats.Established)
+	}
+	if summary := stats.Rules[0].Rule; summary != "allow in tcp 22 from * in network lab" {
+		t.Errorf("rule summary = %q", summary)
+	}
+}
+
+// TestConntrackExpiry tests that idle connections are forgotten
+func TestConntrackExpiry(t *testing.T) {
+	e := newTestEngine(t, Config{DefaultInbound: Deny})
+	now := time.Unix(1700000000, 0)
+	e.now = func() time.Time { return now }
+
+	if !e.AllowPacket(Outbound, "bob", ipv4(protoUDP, "10.0.0.1", "10.0.0.4", 0, ports(5000, 53))) {
+		t.Fatal("outbound datagram denied")
+	}
+	reply := ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 0, ports(53, 5000))
+
+	now = now.Add(udpTimeout - time.Second)
+	if !e.AllowPacket(Inbound, "bob", reply) {
+		t.Error("reply within the timeout denied")
+	}
+	now = now.Add(udpTimeout + time.Second)
+	if e.AllowPacket(Inbound, "bob", reply) {
+		t.Error("reply after the timeout allowed")
+	}
+	if connections := e.Stats().Connections; connections != 0 {
+		t.Errorf("connections = %d, want 0", connections)
+	}
+}
+
+// TestEngineEtherType tests frames that do not carry IP
+func TestEngineEtherType(t *testing.T) {
+	e := newTestEngine(t, Config{DefaultInbound: Deny})
+	if !e.AllowEtherType(Inbound, 0x0806) {
+		t.Error("ARP denied")
+	}
+	if e.AllowEtherType(Inbound, 0x88cc) {
+		t.Error("LLDP allowed by default deny")
+	}
+	if !e.AllowEtherType(Outbound, 0x88cc) {
+		t.Error("LLDP denied by default allow")
+	}
+}
+
+// TestRuleValidate tests the checks on rules
+func TestRuleValidate(t *testing.T) {
+	groups := map[string][]string{"admins": {"alice"}}
+	tests := []struct {
+		rule Rule
+		err  string // Substring of the error; "" if valid
+	}{
+		{Rule{Action: Allow}, ""},
+		{Rule{Action: Deny, Direction: Both, Peers: []string{"group:admins", "10.0.0.0/24", "fd00::1", "*"}}, ""},
+		{Rule{Action: Allow, Protocol: "TCP", Ports: []string{"22", "8000-8080"}}, ""},
+		{Rule{Action: "permit"}, "action"},
+		{Rule{Action: Allow, Direction: "ingress"}, "direction"},
+		{Rule{Action: Allow, Peers: []string{"group:ops"}}, "not defined"},
+		{Rule{Action: Allow, Peers: []string{"10.0.0.0/33"}}, "peer name"},
+		{Rule{Action: Allow, Protocol: "sctp"}, "protocol"},
+		{Rule{Action: Allow, Ports: []string{"22"}}, "need protocol"},
+		{Rule{Action: Allow, Protocol: "udp", Ports: []string{"9000-8000"}}, "ports"},
+		{Rule{Action: Allow, Protocol: "udp", Ports: []string{"0"}}, "ports"},
+		{Rule{Action: Allow, Networks: []string{"lab", ""}}, "networks"},
+	}
+	for _, tt := range tests {
+		err := tt.rule.Validate(groups)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Validate(%+v) = %v, want nil", tt.rule, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate(%+v) = %v, want error containing %q", tt.rule, err, tt.err)
+		}
+	}
+
+	if err := ValidateGroup("ops", []string{"group:admins"}); err == nil {
+		t.Error("ValidateGroup() accepted a nested group")
+	}
+}
+
+// TestRuleNames tests listing the peer names a rule matches, with those of its groups
+func TestRuleNames(t *testing.T) {
+	groups := map[string][]string{"admins": {"Alice", "10.0.0.9", "bob"}}
+	rule := Rule{Action: Allow, Peers: []string{"group:admins", "carol", "ALICE", "10.0.0.0/24", "*"}}
+
+	got := rule.Names(groups)
+	if want := []string{"alice", "bob", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if names := (&Rule{Action: Allow}).Names(groups); len(names) != 0 {
+		t.Errorf("Names() of a rule for any peer = %v, want none", names)
+	}
+}
+
+// TestConntrackLimits tests that a full table or peer share evicts the least recently used connection
+func TestConntrackLimits(t *testing.T) {
+	e := newTestEngine(t, Config{DefaultInbound: Allow})
+	opened := 0
+	open := func(peer string) []byte {
+		packet := ipv4(protoUDP, "10.0.0.4", "10.0.0.1", 0, ports(uint16(opened), uint16(opened>>16)))
+		opened++
+		if !e.AllowPacket(Inbound, peer, packet) {
+			t.Fatalf("connection %d of %s denied", opened, peer)
+		}
+		return packet
+	}
+	tracked := func(peer string, packet []byte) bool {
+		rules := e.rules.Swap(&ruleset{}) // Deny by default: only tracked connections pass
+		defer e.rules.Store(rules)
+		return e.AllowPacket(Inbound, peer, packet)
+	}
+
+	// One peer's connections only push out its own
+	alice := open("alice")
+	first := open("mallory")
+	second := open("mallory")
+	third := open("mallory")
+	for i := 3; i < maxPeerConnections; i++ {
+		open("mallory")
+	}
+	if !tracked("mallory", second) { // Used again, so not evicted next
+		t.Fatal("connection within the peer's share not tracked")
+	}
+	open("mallory")
+	if tracked("mallory", first) {
+		t.Error("least recently used connection of the peer still tracked")
+	}
+	if !tracked("mallory", second) || !tracked("alice", alice) {
+		t.Error("recently used or another peer's connection evicted")
+	}
+	if stats := e.Stats(); stats.Evicted != 1 || stats.Connections != maxPeerConnections+1 {
+		t.Errorf("evicted, connections = %d, %d, want 1, %d", stats.Evicted, stats.Connections, maxPeerConnections+1)
+	}
+
+	// A full table evicts the least recently used connection of any peer
+	for i := 0; opened-1 < maxConnections; i++ { // One was evicted
+		open(fmt.Sprintf("peer%d", i%8))
+	}
+	if connections := e.Stats().Connections; connections != maxConnections {
+		t.Fatalf("connections = %d, want a full table of %d", connections, maxConnections)
+	}
+	open("carol")
+	if tracked("mallory", third) || !tracked("alice", alice) {
+		t.Error("connection other than the least recently used evicted from a full table")
+	}
+	if evicted := e.Stats().Evicted; evicted != 2 {
+		t.Errorf("evicted = %d, want 2", evicted)
+	}
+}
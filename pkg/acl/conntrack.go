@@ -0,0 +1,229 @@
+package acl
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Idle timeouts of tracked connections
+const (
+	tcpTimeout      = 30 * time.Minute
+	udpTimeout      = 2 * time.Minute
+	otherTimeout    = 30 * time.Second // ICMP echo and other protocols
+	fragmentTimeout = 30 * time.Second // Later fragments of an allowed packet
+)
+
+// Bounds of the connection table. When it is full, the least recently used connection
+// is evicted; so is a peer's own when it holds maxPeerConnections, so one peer cannot
+// push the others' connections out.
+const (
+	maxConnections     = 65536
+	maxPeerConnections = 8192
+)
+
+// connKey identifies a connection by the packet that opened it
+type connKey struct {
+	network  string
+	protocol uint8
+	src, dst netip.Addr
+	srcPort  uint16
+	dstPort  uint16
+	fragment bool   // The key of a fragmented packet rather than a connection
+	id       uint32 // Fragment identification
+}
+
+// key returns the flow's connection key, as sent
+func (f *Flow) key() connKey {
+	return connKey{network: f.Network, protocol: f.Protocol, src: f.Src, dst: f.Dst, srcPort: f.SrcPort, dstPort: f.DstPort}
+}
+
+// fragmentKey returns the key the fragments of the flow's packet share
+func (f *Flow) fragmentKey() connKey {
+	return connKey{network: f.Network, protocol: f.Protocol, src: f.Src, dst: f.Dst, fragment: true, id: f.fragmentID}
+}
+
+// reverse returns the key of the connection's packets in the other direction
+func (k connKey) reverse() connKey {
+	k.src, k.dst = k.dst, k.src
+	k.srcPort, k.dstPort = k.dstPort, k.srcPort
+	return k
+}
+
+// timeout returns how long the connection is kept without packets
+func (k connKey) timeout() time.Duration {
+	switch {
+	case k.fragment:
+		return fragmentTimeout
+	case k.protocol == protoTCP:
+		return tcpTimeout
+	case k.protocol == protoUDP:
+		return udpTimeout
+	}
+	return otherTimeout
+}
+
+// connOrigin is the packet that opened a connection, as the rules decided it
+type connOrigin struct {
+	dir  Direction
+	peer string
+	flow Flow
+}
+
+// connOwner is who a connection counts against: the peer by name, else its address
+type connOwner struct {
+	name string
+	addr netip.Addr
+}
+
+// owner returns who the connection counts against
+func (o *connOrigin) owner() connOwner {
+	switch {
+	case o.peer != "":
+		return connOwner{name: o.peer}
+	case o.dir == Outbound:
+		return connOwner{addr: o.flow.Dst}
+	}
+	return connOwner{addr: o.flow.Src}
+}
+
+// connEntry is a tracked connection
+type connEntry struct {
+	key     connKey
+	origin  connOrigin
+	owner   connOwner
+	expires time.Time
+	used    *list.Element // In conntrack.used
+	owned   *list.Element // In the owner's list in conntrack.owners
+}
+
+// conntrack remembers the connections the rules allowed, so their packets in both directions pass
+// Entries are kept in order of use, overall and per owner, so expiring and evicting
+// take the front of a list. Thread-safe.
+type conntrack struct {
+	mu      sync.Mutex
+	entries map[connKey]*connEntry
+	used    *list.List               // *connEntry, least recently used first
+	owners  map[connOwner]*list.List // *connEntry by owner, least recently used first
+	evicted uint64                   // Connections evicted to make room
+}
+
+// newConntrack creates an empty connection table
+func newConntrack() *conntrack {
+	return &conntrack{
+		entries: make(map[connKey]*connEntry),
+		used:    list.New(),
+		owners:  make(map[connOwner]*list.List),
+	}
+}
+
+// touch returns the origin of the connection key or its reverse belongs to, extending its timeout
+// ok is false if neither is tracked.
+func (c *conntrack) touch(key connKey, now time.Time) (origin connOrigin, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range [2]connKey{key, key.reverse()} {
+		entry, ok := c.entries[k]
+		if !ok {
+			continue
+		}
+		if now.After(entry.expires) {
+			c.remove(entry)
+			continue
+		}
+		c.refresh(entry, now)
+		return entry.origin, true
+	}
+	return connOrigin{}, false
+}
+
+// add tracks key as part of the connection opened by origin
+// Expired entries are dropped first; if the owner's share or the table is still full,
+// the least recently used entry of the owner, respectively of all, is evicted.
+func (c *conntrack) add(key connKey, origin connOrigin, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		c.refresh(entry, now)
+		return
+	}
+	for front := c.used.Front(); front != nil && now.After(front.Value.(*connEntry).expires); front = c.used.Front() {
+		c.remove(front.Value.(*connEntry))
+	}
+
+	origin.flow.embedded = nil
+	entry := &connEntry{key: key, origin: origin, owner: origin.owner(), expires: now.Add(key.timeout())}
+	owned := c.owners[entry.owner]
+	if owned == nil {
+		owned = list.New()
+		c.owners[entry.owner] = owned
+	}
+	if owned.Len() >= maxPeerConnections {
+		c.remove(owned.Front().Value.(*connEntry))
+		c.evicted++
+	} else if len(c.entries) >= maxConnections {
+		c.remove(c.used.Front().Value.(*connEntry))
+		c.evicted++
+	}
+
+	entry.used = c.used.PushBack(entry)
+	entry.owned = owned.PushBack(entry)
+	c.entries[key] = entry
+}
+
+// refresh extends an entry's timeout and moves it to the back of its lists (c.mu held)
+func (c *conntrack) refresh(entry *connEntry, now time.Time) {
+	entry.expires = now.Add(entry.key.timeout())
+	c.used.MoveToBack(entry.used)
+	c.owners[entry.owner].MoveToBack(entry.owned)
+}
+
+// remove forgets an entry (c.mu held)
+func (c *conntrack) remove(entry *connEntry) {
+	delete(c.entries, entry.key)
+	c.used.Remove(entry.used)
+	owned := c.owners[entry.owner]
+	owned.Remove(entry.owned)
+	if owned.Len() == 0 {
+		delete(c.owners, entry.owner)
+	}
+}
+
+// active returns the number of tracked connections, forgetting expired ones
+func (c *conntrack) active(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for _, entry := range c.entries {
+		switch {
+		case now.After(entry.expires):
+			c.remove(entry)
+		case !entry.key.fragment:
+			count++
+		}
+	}
+	return count
+}
+
+// evictions returns the number of connections evicted to make room
+func (c *conntrack) evictions() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evicted
+}
+
+// recheck forgets the connections whose origin allowed no longer accepts
+func (c *conntrack) recheck(allowed func(origin *connOrigin) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.entries {
+		if !allowed(&entry.origin) {
+			c.remove(entry)
+		}
+	}
+}
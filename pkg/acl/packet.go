@@ -0,0 +1,162 @@
+package acl
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IP protocol numbers rules match on
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// maxExtensionHeaders bounds the IPv6 extension headers skipped to reach the transport header
+const maxExtensionHeaders = 8
+
+// Flow is what the rules and the connection tracker see of an IP packet
+type Flow struct {
+	Protocol uint8
+	Src, Dst netip.Addr
+	SrcPort  uint16 // TCP or UDP port; the identifier for ICMP echo
+	DstPort  uint16 // TCP or UDP port; the identifier for ICMP echo
+	HasPorts bool   // Ports are known: false for later fragments and ICMP other than echo
+	ICMPType uint8  // ICMP or ICMPv6 message type
+	Network  string // Virtual network the packet is in, set by the caller; connections are tracked per network
+
+	fragment     bool   // Part of a fragmented packet
+	laterFrag    bool   // Fragment other than the first, without a transport header
+	fragmentID   uint32 // IPv4 identification or IPv6 fragment identification
+	embedded     *Flow  // Packet quoted by an ICMP error
+	neighborDisc bool   // ICMPv6 neighbour discovery or multicast listener message
+}
+
+// ParsePacket reads the addresses, protocol and ports of an IPv4 or IPv6 packet
+// ok is false for data that is not an IP packet.
+func ParsePacket(packet []byte) (flow Flow, ok bool) {
+	return parsePacket(packet, true)
+}
+
+// parsePacket is ParsePacket; quoted packets inside ICMP errors are parsed with icmpErrors false
+func parsePacket(packet []byte, icmpErrors bool) (flow Flow, ok bool) {
+	if len(packet) < 1 {
+		return Flow{}, false
+	}
+
+	var transport []byte
+	switch packet[0] >> 4 {
+	case 4:
+		headerLen := int(packet[0]&0x0f) * 4
+		if len(packet) < 20 || headerLen < 20 || len(packet) < headerLen {
+			return Flow{}, false
+		}
+		flow.Protocol = packet[9]
+		flow.Src = netip.AddrFrom4([4]byte(packet[12:16]))
+		flow.Dst = netip.AddrFrom4([4]byte(packet[16:20]))
+
+		flags := binary.BigEndian.Uint16(packet[6:8])
+		if flags&0x3fff != 0 { // More fragments or a fragment offset
+			flow.fragment = true
+			flow.fragmentID = uint32(binary.BigEndian.Uint16(packet[4:6]))
+			flow.laterFrag = flags&0x1fff != 0
+		}
+		transport = packet[headerLen:]
+	case 6:
+		if len(packet) < 40 {
+			return Flow{}, false
+		}
+		flow.Src = netip.AddrFrom16([16]byte(packet[8:24]))
+		flow.Dst = netip.AddrFrom16([16]byte(packet[24:40]))
+
+		next, offset := packet[6], 40
+	headers:
+		for i := 0; i < maxExtensionHeaders; i++ {
+			switch next {
+			case 0, 43, 60: // Hop-by-hop, routing and destination options
+				if len(packet) < offset+2 {
+					break headers
+				}
+				next = packet[offset]
+				offset += (int(packet[offset+1]) + 1) * 8
+			case 44: // Fragment
+				if len(packet) < offset+8 {
+					break headers
+				}
+				flow.fragment = true
+				flow.fragmentID = binary.BigEndian.Uint32(packet[offset+4 : offset+8])
+				flow.laterFrag = binary.BigEndian.Uint16(packet[offset+2:offset+4])&^7 != 0
+				next = packet[offset]
+				offset += 8
+			default:
+				break headers
+			}
+		}
+		flow.Protocol = next
+		if offset <= len(packet) {
+			transport = packet[offset:]
+		}
+	default:
+		return Flow{}, false
+	}
+
+	if flow.laterFrag {
+		return flow, true
+	}
+	flow.parseTransport(transport, icmpErrors)
+	return flow, true
+}
+
+// parseTransport reads the ports or ICMP message of the transport header
+func (f *Flow) parseTransport(transport []byte, icmpErrors bool) {
+	switch f.Protocol {
+	case protoTCP, protoUDP:
+		if len(transport) >= 4 {
+			f.SrcPort = binary.BigEndian.Uint16(transport[0:2])
+			f.DstPort = binary.BigEndian.Uint16(transport[2:4])
+			f.HasPorts = true
+		}
+	case protoICMP, protoICMPv6:
+		if len(transport) < 1 {
+			return
+		}
+		f.ICMPType = transport[0]
+		switch {
+		case f.echo():
+			if len(transport) >= 6 {
+				f.SrcPort = binary.BigEndian.Uint16(transport[4:6])
+				f.DstPort = f.SrcPort
+				f.HasPorts = true
+			}
+		case f.icmpError():
+			if !icmpErrors || len(transport) < 8 {
+				return
+			}
+			if quoted, ok := parsePacket(transport[8:], false); ok && !quoted.laterFrag {
+				f.embedded = &quoted
+			}
+		case f.Protocol == protoICMPv6:
+			// Router and neighbour solicitation and advertisement, redirect, MLD
+			f.neighborDisc = f.ICMPType >= 130 && f.ICMPType <= 137 || f.ICMPType == 143
+		}
+	}
+}
+
+// echo reports whether the flow is an ICMP echo request or reply
+func (f *Flow) echo() bool {
+	if f.Protocol == protoICMP {
+		return f.ICMPType == 0 || f.ICMPType == 8
+	}
+	return f.ICMPType == 128 || f.ICMPType == 129
+}
+
+// icmpError reports whether the flow is an ICMP error quoting the packet that caused it
+func (f *Flow) icmpError() bool {
+	if f.Protocol == protoICMP {
+		// Destination unreachable, source quench, redirect, time exceeded, parameter problem
+		return f.ICMPType == 3 || f.ICMPType == 4 || f.ICMPType == 5 || f.ICMPType == 11 || f.ICMPType == 12
+	}
+	// Destination unreachable, packet too big, time exceeded, parameter problem
+	return f.ICMPType >= 1 && f.ICMPType <= 4
+}
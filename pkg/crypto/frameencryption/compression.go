@@ -0,0 +1,75 @@
+package frameencryption
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// CompressionLZ4 is the algorithm name advertised during session negotiation
+const CompressionLZ4 = "lz4"
+
+// compressedHeaderSize is the original-length prefix carried before the lz4 block
+const compressedHeaderSize = 2
+
+var (
+	// ErrDecompressionFailed indicates a frame flagged as compressed could not be expanded
+	ErrDecompressionFailed = errors.New("frame decompression failed")
+)
+
+// frameCompressor compresses plaintext frames before encryption
+//
+// Compressed payload format: [2 bytes original length (big-endian)][lz4 block]
+// Not safe for concurrent use; owned by encryptionLoop.
+type frameCompressor struct {
+	lz4 lz4.Compressor
+	buf []byte
+}
+
+// compress returns the compressed payload, or false if the frame does not shrink
+func (fc *frameCompressor) compress(plaintext []byte) ([]byte, bool) {
+	// Anything that would not save at least the length prefix is sent as-is
+	if len(plaintext) <= compressedHeaderSize+1 || len(plaintext) > 0xFFFF {
+		return nil, false
+	}
+
+	limit := len(plaintext) - compressedHeaderSize - 1
+	if cap(fc.buf) < limit {
+		fc.buf = make([]byte, limit)
+	}
+	dst := fc.buf[:limit]
+
+	// A destination smaller than CompressBlockBound makes lz4 report (0, nil) or an
+	// error for incompressible input, which is exactly the skip signal we want
+	n, err := fc.lz4.CompressBlock(plaintext, dst)
+	if err != nil || n == 0 {
+		return nil, false
+	}
+
+	out := make([]byte, compressedHeaderSize+n)
+	binary.BigEndian.PutUint16(out[:compressedHeaderSize], uint16(len(plaintext)))
+	copy(out[compressedHeaderSize:], dst[:n])
+	return out, true
+}
+
+// decompressFrame expands a payload produced by frameCompressor.compress
+func decompressFrame(payload []byte) ([]byte, error) {
+	if len(payload) <= compressedHeaderSize {
+		return nil, fmt.Errorf("%w: payload too short", ErrDecompressionFailed)
+	}
+
+	originalSize := int(binary.BigEndian.Uint16(payload[:compressedHeaderSize]))
+	out := make([]byte, originalSize)
+
+	n, err := lz4.UncompressBlock(payload[compressedHeaderSize:], out)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecompressionFailed, err)
+	}
+	if n != originalSize {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrDecompressionFailed, originalSize, n)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,176 @@
+package frameencryption
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Fragment header carried at the start of the plaintext of every FlagFragment frame:
+//
+//	[4 bytes fragment ID][1 byte index][1 byte count]
+//
+// The header is encrypted and authenticated with the fragment, so reassembly only ever
+// sees fragments that came from a sender holding the session key.
+const (
+	// FragmentHeaderSize is the size of the per-fragment header inside the plaintext
+	FragmentHeaderSize = 6
+	// MaxFragments is the largest number of fragments a single frame may be split into
+	MaxFragments = 255
+
+	// fragmentTimeout is how long a partially received frame is kept
+	fragmentTimeout = 2 * time.Second
+	// maxPendingReassemblies bounds memory used by incomplete frames
+	maxPendingReassemblies = 64
+)
+
+var (
+	// ErrFrameTooLarge indicates a frame needs more than MaxFragments fragments
+	ErrFrameTooLarge = errors.New("frame too large to fragment")
+	// ErrInvalidFragment indicates a fragment header is malformed or inconsistent
+	ErrInvalidFragment = errors.New("invalid fragment")
+)
+
+// fragmentPayload splits payload into chunks that each fit in maxChunk bytes of
+// plaintext, including the fragment header
+func fragmentPayload(payload []byte, fragmentID uint32, maxChunk int) ([][]byte, error) {
+	dataPerFragment := maxChunk - FragmentHeaderSize
+	if dataPerFragment <= 0 {
+		return nil, fmt.Errorf("%w: no room for fragment data (%d bytes)", ErrFrameTooLarge, maxChunk)
+	}
+
+	count := (len(payload) + dataPerFragment - 1) / dataPerFragment
+	if count > MaxFragments {
+		return nil, fmt.Errorf("%w: %d bytes needs %d fragments", ErrFrameTooLarge, len(payload), count)
+	}
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		start := i * dataPerFragment
+		end := start + dataPerFragment
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		fragment := make([]byte, FragmentHeaderSize+end-start)
+		binary.BigEndian.PutUint32(fragment[0:4], fragmentID)
+		fragment[4] = byte(i)
+		fragment[5] = byte(count)
+		copy(fragment[FragmentHeaderSize:], payload[start:end])
+		fragments = append(fragments, fragment)
+	}
+
+	return fragments, nil
+}
+
+// reassemblyKey identifies one fragmented frame from one sender
+type reassemblyKey struct {
+	senderID   uint64
+	fragmentID uint32
+}
+
+// partialFrame collects the fragments of one frame
+type partialFrame struct {
+	parts    [][]byte
+	received int
+	size     int
+	expires  time.Time
+}
+
+// reassembler rebuilds fragmented frames
+// Not safe for concurrent use; owned by decryptionLoop.
+type reassembler struct {
+	pending map[reassemblyKey]*partialFrame
+	expired uint64 // Incomplete frames discarded (timeout or eviction), read atomically by GetMetrics
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		pending: make(map[reassemblyKey]*partialFrame),
+	}
+}
+
+// add stores a decrypted fragment and returns the full payload once all fragments arrived
+func (r *reassembler) add(senderID uint64, fragment []byte, now time.Time) ([]byte, bool, error) {
+	if len(fragment) < FragmentHeaderSize {
+		return nil, false, fmt.Errorf("%w: %d bytes", ErrInvalidFragment, len(fragment))
+	}
+
+	key := reassemblyKey{
+		senderID:   senderID,
+		fragmentID: binary.BigEndian.Uint32(fragment[0:4]),
+	}
+	index := int(fragment[4])
+	count := int(fragment[5])
+	if count == 0 || index >= count {
+		return nil, false, fmt.Errorf("%w: index %d of %d", ErrInvalidFragment, index, count)
+	}
+
+	r.expire(now)
+
+	partial, ok := r.pending[key]
+	if !ok {
+		if len(r.pending) >= maxPendingReassemblies {
+			r.evictOldest()
+		}
+		partial = &partialFrame{
+			parts:   make([][]byte, count),
+			expires: now.Add(fragmentTimeout),
+		}
+		r.pending[key] = partial
+	}
+
+	if len(partial.parts) != count {
+		delete(r.pending, key)
+		return nil, false, fmt.Errorf("%w: fragment count changed from %d to %d", ErrInvalidFragment, len(partial.parts), count)
+	}
+
+	// Duplicates (e.g. from redundant paths) are ignored
+	if partial.parts[index] != nil {
+		return nil, false, nil
+	}
+
+	partial.parts[index] = fragment[FragmentHeaderSize:]
+	partial.received++
+	partial.size += len(fragment) - FragmentHeaderSize
+
+	if partial.received < count {
+		return nil, false, nil
+	}
+
+	delete(r.pending, key)
+
+	payload := make([]byte, 0, partial.size)
+	for _, part := range partial.parts {
+		payload = append(payload, part...)
+	}
+	return payload, true, nil
+}
+
+// expire drops incomplete frames whose fragments stopped arriving
+func (r *reassembler) expire(now time.Time) {
+	for key, partial := range r.pending {
+		if now.After(partial.expires) {
+			delete(r.pending, key)
+			atomic.AddUint64(&r.expired, 1)
+		}
+	}
+}
+
+// evictOldest drops the incomplete frame closest to expiry
+func (r *reassembler) evictOldest() {
+	var oldestKey reassemblyKey
+	var oldest *partialFrame
+	for key, partial := range r.pending {
+		if oldest == nil || partial.expires.Before(oldest.expires) {
+			oldestKey = key
+			oldest = partial
+		}
+	}
+	if oldest != nil {
+		delete(r.pending, oldestKey)
+		atomic.AddUint64(&r.expired, 1)
+	}
+}
@@ -0,0 +1,132 @@
+package frameencryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestFragmentRoundTrip tests splitting and reassembling payloads of various sizes
+func TestFragmentRoundTrip(t *testing.T) {
+	for _, size := range []int{1, 100, 494, 495, 3000} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+
+		fragments, err := fragmentPayload(payload, 7, 100)
+		if err != nil {
+			t.Fatalf("size %d: fragmentPayload failed: %v", size, err)
+		}
+
+		for i, fragment := range fragments {
+			if len(fragment) > 100 {
+				t.Errorf("size %d: fragment %d is %d bytes, limit 100", size, i, len(fragment))
+			}
+		}
+
+		r := newReassembler()
+		now := time.Now()
+		var result []byte
+		for i, fragment := range fragments {
+			out, complete, err := r.add(1, fragment, now)
+			if err != nil {
+				t.Fatalf("size %d: add failed: %v", size, err)
+			}
+			if complete != (i == len(fragments)-1) {
+				t.Fatalf("size %d: fragment %d complete = %v", size, i, complete)
+			}
+			result = out
+		}
+
+		if !bytes.Equal(result, payload) {
+			t.Errorf("size %d: reassembled payload does not match", size)
+		}
+		if len(r.pending) != 0 {
+			t.Errorf("size %d: %d reassemblies left pending", size, len(r.pending))
+		}
+	}
+}
+
+// TestFragmentTooLarge tests that frames needing more than MaxFragments are rejected
+func TestFragmentTooLarge(t *testing.T) {
+	payload := make([]byte, (MaxFragments+1)*10)
+
+	_, err := fragmentPayload(payload, 1, 10+FragmentHeaderSize)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+// TestReassemblerSeparatesSenders tests that equal fragment IDs from different senders don't mix
+func TestReassemblerSeparatesSenders(t *testing.T) {
+	a, _ := fragmentPayload(bytes.Repeat([]byte{0xAA}, 50), 1, 36)
+	b, _ := fragmentPayload(bytes.Repeat([]byte{0xBB}, 50), 1, 36)
+
+	r := newReassembler()
+	now := time.Now()
+
+	r.add(1, a[0], now)
+	r.add(2, b[0], now)
+
+	out, complete, _ := r.add(1, a[1], now)
+	if !complete || !bytes.Equal(out, bytes.Repeat([]byte{0xAA}, 50)) {
+		t.Error("Sender 1 frame not reassembled correctly")
+	}
+	out, complete, _ = r.add(2, b[1], now)
+	if !complete || !bytes.Equal(out, bytes.Repeat([]byte{0xBB}, 50)) {
+		t.Error("Sender 2 frame not reassembled correctly")
+	}
+}
+
+// TestReassemblerTimeout tests that incomplete frames expire
+func TestReassemblerTimeout(t *testing.T) {
+	fragments, _ := fragmentPayload(make([]byte, 100), 9, 56)
+
+	r := newReassembler()
+	now := time.Now()
+	r.add(1, fragments[0], now)
+
+	// Next fragment arrives after the timeout: the old partial frame is discarded
+	_, complete, err := r.add(1, fragments[1], now.Add(fragmentTimeout+time.Millisecond))
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if complete {
+		t.Error("Frame should not complete after its first fragment expired")
+	}
+	if r.expired != 1 {
+		t.Errorf("Expected 1 expired reassembly, got %d", r.expired)
+	}
+}
+
+// TestReassemblerRejectsMalformed tests header validation
+func TestReassemblerRejectsMalformed(t *testing.T) {
+	r := newReassembler()
+	now := time.Now()
+
+	for name, fragment := range map[string][]byte{
+		"short":          {0, 0, 0, 1, 0},
+		"zero count":     {0, 0, 0, 1, 0, 0},
+		"index too high": {0, 0, 0, 1, 3, 3},
+	} {
+		if _, _, err := r.add(1, fragment, now); !errors.Is(err, ErrInvalidFragment) {
+			t.Errorf("%s: expected ErrInvalidFragment, got %v", name, err)
+		}
+	}
+}
+
+// TestReassemblerBoundsPending tests that the number of incomplete frames is capped
+func TestReassemblerBoundsPending(t *testing.T) {
+	r := newReassembler()
+	now := time.Now()
+
+	for id := uint32(0); id < maxPendingReassemblies*2; id++ {
+		fragments, _ := fragmentPayload(make([]byte, 20), id, 16)
+		r.add(1, fragments[0], now.Add(time.Duration(id)*time.Millisecond))
+	}
+
+	if len(r.pending) > maxPendingReassemblies {
+		t.Errorf("Expected at most %d pending reassemblies, got %d", maxPendingReassemblies, len(r.pending))
+	}
+}
@@ -0,0 +1,181 @@
+package frameencryption
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/symmetric"
+)
+
+// Wire format of an encrypted frame:
+//
+//	[1 byte version][1 byte flags][8 bytes sender ID]([4 bytes network ID])[12 bytes nonce][ciphertext + 16 byte tag]
+//
+// The header is authenticated as AEAD additional data. The sender ID selects the
+// per-direction session key and the nonce carries the key's rotation sequence. Frames of
+// a named virtual network carry FlagNetwork and its ID, which relays read to switch the
+// frame only to that network's members.
+const (
+	// FrameVersion is the current wire format version
+	FrameVersion = 1
+	// FrameHeaderSize is the size of the cleartext header (version + flags + sender ID)
+	FrameHeaderSize = 10
+	// FrameOverhead is the total number of bytes added to each plaintext frame
+	FrameOverhead = FrameHeaderSize + symmetric.NonceSize + symmetric.TagSize
+	// NetworkIDSize is the size of the network ID that follows the header with FlagNetwork
+	NetworkIDSize = 4
+
+	// DefaultNetwork is the network of frames without a network ID, shared by all peers
+	// that join no named network
+	DefaultNetwork uint32 = 0
+)
+
+// Frame header flags
+const (
+	// FlagCompressed marks an lz4-compressed payload (see compression.go)
+	FlagCompressed uint8 = 1 << 0
+	// FlagControl marks a daemon control message rather than network device traffic
+	FlagControl uint8 = 1 << 1
+	// FlagFragment marks one piece of a frame that exceeded the path MTU (see fragment.go)
+	FlagFragment uint8 = 1 << 2
+	// FlagNetwork marks a frame of a named virtual network; the network ID follows the header
+	FlagNetwork uint8 = 1 << 3
+
+	// knownFlags is the set of flags this version understands; others are rejected
+	knownFlags = FlagCompressed | FlagControl | FlagFragment | FlagNetwork
+)
+
+var (
+	// ErrFrameTooShort indicates the encrypted frame is smaller than the fixed overhead
+	ErrFrameTooShort = errors.New("encrypted frame too short")
+	// ErrUnsupportedVersion indicates the frame was produced by an incompatible peer
+	ErrUnsupportedVersion = errors.New("unsupported encrypted frame version")
+	// ErrUnknownFlags indicates the frame header carries flags this version cannot handle
+	ErrUnknownFlags = errors.New("unknown encrypted frame flags")
+)
+
+// EncryptedEthernetFrame wraps a symmetric.EncryptedFrame with metadata
+type EncryptedEthernetFrame struct {
+	SenderID  uint64 // Session identifier of the sending pipeline (selects the key)
+	Flags     uint8  // Per-frame flags (FlagCompressed, FlagControl, FlagFragment, FlagNetwork)
+	Network   uint32 // Virtual network ID with FlagNetwork, else DefaultNetwork
+	Frame     *symmetric.EncryptedFrame
+	Timestamp time.Time
+}
+
+// headerSize returns the length of a header with flags
+func headerSize(flags uint8) int {
+	if flags&FlagNetwork != 0 {
+		return FrameHeaderSize + NetworkIDSize
+	}
+	return FrameHeaderSize
+}
+
+// header returns the cleartext header that is authenticated as additional data
+func (f *EncryptedEthernetFrame) header() []byte {
+	header := make([]byte, headerSize(f.Flags))
+	header[0] = FrameVersion
+	header[1] = f.Flags
+	binary.BigEndian.PutUint64(header[2:10], f.SenderID)
+	if f.Flags&FlagNetwork != 0 {
+		binary.BigEndian.PutUint32(header[10:14], f.Network)
+	}
+	return header
+}
+
+// wireSize returns the length of the marshaled frame
+func (f *EncryptedEthernetFrame) wireSize() int {
+	return headerSize(f.Flags) + symmetric.NonceSize + len(f.Frame.Ciphertext)
+}
+
+// Marshal serializes the frame for transmission
+func (f *EncryptedEthernetFrame) Marshal() []byte {
+	data := make([]byte, f.wireSize())
+	n := copy(data, f.header())
+	copy(data[n:], f.Frame.Nonce[:])
+	copy(data[n+symmetric.NonceSize:], f.Frame.Ciphertext)
+	return data
+}
+
+// UnmarshalEncryptedFrame parses a frame produced by Marshal
+// The ciphertext is not verified here; authentication happens during decryption.
+func UnmarshalEncryptedFrame(data []byte) (*EncryptedEthernetFrame, error) {
+	if len(data) < FrameOverhead {
+		return nil, fmt.Errorf("%w: got %d bytes, minimum %d", ErrFrameTooShort, len(data), FrameOverhead)
+	}
+
+	if data[0] != FrameVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[0])
+	}
+
+	if data[1]&^knownFlags != 0 {
+		return nil, fmt.Errorf("%w: %#02x", ErrUnknownFlags, data[1])
+	}
+
+	flags := data[1]
+	n := headerSize(flags)
+	if len(data) < n+symmetric.NonceSize+symmetric.TagSize {
+		return nil, fmt.Errorf("%w: got %d bytes, minimum %d with a network ID", ErrFrameTooShort, len(data), n+symmetric.NonceSize+symmetric.TagSize)
+	}
+
+	frame := &symmetric.EncryptedFrame{}
+	copy(frame.Nonce[:], data[n:n+symmetric.NonceSize])
+	frame.Ciphertext = make([]byte, len(data)-n-symmetric.NonceSize)
+	copy(frame.Ciphertext, data[n+symmetric.NonceSize:])
+
+	out := &EncryptedEthernetFrame{
+		SenderID:  binary.BigEndian.Uint64(data[2:10]),
+		Flags:     flags,
+		Frame:     frame,
+		Timestamp: time.Now(),
+	}
+	if flags&FlagNetwork != 0 {
+		out.Network = binary.BigEndian.Uint32(data[10:14])
+	}
+	return out, nil
+}
+
+// FrameNetwork returns the virtual network of a marshaled frame without decrypting it
+// control is true for daemon control messages, which belong to no network. Relays use
+// it to switch frames only to the members of their network.
+func FrameNetwork(data []byte) (network uint32, control bool, err error) {
+	if len(data) < FrameOverhead {
+		return 0, false, fmt.Errorf("%w: got %d bytes, minimum %d", ErrFrameTooShort, len(data), FrameOverhead)
+	}
+	if data[0] != FrameVersion {
+		return 0, false, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[0])
+	}
+	if data[1]&FlagControl != 0 {
+		return DefaultNetwork, true, nil
+	}
+	if data[1]&FlagNetwork != 0 {
+		return binary.BigEndian.Uint32(data[10:14]), false, nil
+	}
+	return DefaultNetwork, false, nil
+}
+
+// NetworkID returns the ID frames of the virtual network named name carry
+// It is derived from the name, so peers and relays agree on it without coordination.
+func NetworkID(name string) uint32 {
+	sum := sha256.Sum256([]byte(name))
+	if id := binary.BigEndian.Uint32(sum[:NetworkIDSize]); id != DefaultNetwork {
+		return id
+	}
+	return 1
+}
+
+// DecryptedFrame is a decrypted frame or packet for the device together with the session that sent it
+type DecryptedFrame struct {
+	SenderID uint64
+	Network  uint32 // Virtual network the frame belongs to (DefaultNetwork if unnamed)
+	Payload  []byte
+}
+
+// ControlFrame is a decrypted control message together with the session that sent it
+type ControlFrame struct {
+	SenderID uint64
+	Payload  []byte
+}
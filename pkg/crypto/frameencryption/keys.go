@@ -0,0 +1,163 @@
+package frameencryption
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/rotation"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/symmetric"
+)
+
+// MaxKeySequenceSkip is how many rotations ahead of the last seen key a sender may jump
+// Bounds the HKDF work an unauthenticated frame can trigger on the receiver.
+const MaxKeySequenceSkip = 16
+
+var (
+	// ErrStaleKeySequence indicates a frame uses a key that has already been retired
+	ErrStaleKeySequence = errors.New("frame key sequence is no longer accepted")
+	// ErrKeySequenceTooFar indicates a frame claims a key too far ahead of the current one
+	ErrKeySequenceTooFar = errors.New("frame key sequence too far ahead")
+)
+
+// txChain is a transmit key chain and the nonce generator for its current key
+// Only touched by encryptionLoop after construction, apart from rekeyRequested.
+type txChain struct {
+	rotation       *rotation.RotationManager
+	key            [symmetric.KeySize]byte
+	nonceGen       *symmetric.NonceGenerator
+	rekeyRequested atomic.Bool // Set by RequestRekey; rotated before the next frame
+}
+
+// newTxChain starts the chain of senderID under base at sequence 0
+func newTxChain(base [symmetric.KeySize]byte, senderID uint64) (*txChain, error) {
+	key, err := rotation.DeriveDirectionKey(base, senderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive session key: %w", err)
+	}
+
+	// Create nonce generator bound to the transmit key
+	nonceGen, err := symmetric.NewNonceGenerator(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nonce generator: %w", err)
+	}
+	return &txChain{rotation: rotation.NewRotationManager(key), key: key, nonceGen: nonceGen}, nil
+}
+
+// zero wipes the chain's current key
+func (c *txChain) zero() {
+	rotation.SecureZero(&c.key)
+}
+
+// keyringID identifies a receive keyring other than the static one
+type keyringID struct {
+	bound   bool   // Keyed by the sender key of sender
+	sender  uint64 // Set if bound
+	network uint32 // Network with a key; DefaultNetwork for the networks without
+}
+
+// receiveKey tracks the session key of one remote sender
+type receiveKey struct {
+	sequence    uint64
+	key         [symmetric.KeySize]byte
+	previous    [symmetric.KeySize]byte // Key for sequence-1, accepted during rotation grace period
+	hasPrevious bool
+}
+
+// receiveKeyring derives and caches per-sender receive keys from the static key, or
+// for a bound sender from its sender key, and for a network with a key from the key
+// derived from it (rotation.DeriveNetworkKey)
+//
+// Keys follow the same chain as rotation.RotationManager:
+// key(0) = DeriveDirectionKey(static, sender), key(n) = DeriveRotationKey(key(n-1), n)
+type receiveKeyring struct {
+	staticKey [symmetric.KeySize]byte
+	senders   map[uint64]*receiveKey
+	mu        sync.Mutex
+}
+
+func newReceiveKeyring(staticKey [symmetric.KeySize]byte) *receiveKeyring {
+	return &receiveKeyring{
+		staticKey: staticKey,
+		senders:   make(map[uint64]*receiveKey),
+	}
+}
+
+// lookup returns the key for (senderID, sequence) without modifying the keyring
+// The returned state must be passed to commit once the frame has authenticated.
+func (kr *receiveKeyring) lookup(senderID, sequence uint64) ([symmetric.KeySize]byte, *receiveKey, error) {
+	kr.mu.Lock()
+	state, known := kr.senders[senderID]
+	var current receiveKey
+	if known {
+		current = *state
+	}
+	kr.mu.Unlock()
+
+	if !known {
+		base, err := rotation.DeriveDirectionKey(kr.staticKey, senderID)
+		if err != nil {
+			return [symmetric.KeySize]byte{}, nil, err
+		}
+		current = receiveKey{sequence: 0, key: base}
+	}
+
+	switch {
+	case sequence == current.sequence:
+		return current.key, &current, nil
+	case sequence+1 == current.sequence && current.hasPrevious:
+		// Late frame from before the sender's last rotation
+		return current.previous, nil, nil
+	case sequence < current.sequence:
+		return [symmetric.KeySize]byte{}, nil, fmt.Errorf("%w: got %d, current %d", ErrStaleKeySequence, sequence, current.sequence)
+	case sequence-current.sequence > MaxKeySequenceSkip:
+		return [symmetric.KeySize]byte{}, nil, fmt.Errorf("%w: got %d, current %d", ErrKeySequenceTooFar, sequence, current.sequence)
+	}
+
+	// Sender has rotated: follow the derivation chain forward
+	next := current
+	for next.sequence < sequence {
+		derived, err := rotation.DeriveRotationKey(next.key, next.sequence+1)
+		if err != nil {
+			return [symmetric.KeySize]byte{}, nil, err
+		}
+		next.previous = next.key
+		next.hasPrevious = true
+		next.key = derived
+		next.sequence++
+	}
+
+	return next.key, &next, nil
+}
+
+// commit records the sender's key state after a frame has authenticated under it
+func (kr *receiveKeyring) commit(senderID uint64, state *receiveKey) {
+	if state == nil {
+		return
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if existing, ok := kr.senders[senderID]; ok {
+		if existing.sequence >= state.sequence {
+			return
+		}
+		rotation.SecureZero(&existing.previous)
+	}
+
+	stored := *state
+	kr.senders[senderID] = &stored
+}
+
+// zero wipes all cached receive keys
+func (kr *receiveKeyring) zero() {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for id, state := range kr.senders {
+		rotation.SecureZeroMultiple(&state.key, &state.previous)
+		delete(kr.senders, id)
+	}
+}
@@ -0,0 +1,41 @@
+package frameencryption
+
+// NetworkSet is the set of virtual networks a peer joined at a relay
+type NetworkSet map[uint32]bool
+
+// JoinNetworks returns the networks of the given names; without names, the default network
+func JoinNetworks(names []string) NetworkSet {
+	set := make(NetworkSet, len(names))
+	for _, name := range names {
+		set[NetworkID(name)] = true
+	}
+	if len(set) == 0 {
+		set[DefaultNetwork] = true
+	}
+	return set
+}
+
+// Shares reports whether two peers have joined a network in common
+func (s NetworkSet) Shares(other NetworkSet) bool {
+	for network := range s {
+		if other[network] {
+			return true
+		}
+	}
+	return false
+}
+
+// Relays reports whether a relay passes a frame from a peer in sender to a peer in s
+// Data frames only reach members of their network, and only if the sender joined it.
+// Control frames carry no network and reach every peer sharing one with the sender.
+// Frames that do not parse are treated as data in the default network.
+func (s NetworkSet) Relays(sender NetworkSet, frame []byte) bool {
+	network, control, err := FrameNetwork(frame)
+	switch {
+	case err != nil:
+		return sender[DefaultNetwork] && s[DefaultNetwork]
+	case control:
+		return s.Shares(sender)
+	}
+	return sender[network] && s[network]
+}
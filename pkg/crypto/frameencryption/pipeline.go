@@ -4,28 +4,78 @@ package frameencryption
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/rotation"
 	"github.com/shadowmesh/shadowmesh/pkg/crypto/symmetric"
 	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
+)
+
+var logger = logging.Logger("pipeline")
+
+// Per-frame warnings are rate limited per call site; every drop is still counted in the statistics
+var (
+	encryptErrorLog  = logging.NewLimiter(time.Second)
+	encryptedFullLog = logging.NewLimiter(time.Second)
+	fragmentLog      = logging.NewLimiter(time.Second)
+	noKeyLog         = logging.NewLimiter(time.Second)
+	authFailedLog    = logging.NewLimiter(time.Second)
+	payloadLog       = logging.NewLimiter(time.Second)
+	controlFullLog   = logging.NewLimiter(time.Second)
+	outboundFullLog  = logging.NewLimiter(time.Second)
 )
 
 // Pipeline stages: TAP capture → encrypt → WSS transmit → WSS receive → decrypt → TAP inject
 
 // EncryptionPipeline handles frame encryption/decryption with goroutine-based pipeline architecture
 type EncryptionPipeline struct {
-	// Encryption key (256-bit ChaCha20-Poly1305 key)
+	// Static key (256-bit) shared with the peer; session keys are derived from it
 	key [symmetric.KeySize]byte
 
-	// Nonce generator for replay protection
-	nonceGen *symmetric.NonceGenerator
+	// Transmit direction: random sender ID and its key chain under the static key.
+	// With a sender key, data frames use a second chain derived from it; control
+	// frames stay on the static chain so peers can read hellos before the exchange.
+	// Frames of networks with a key use a chain of their own derived from it.
+	senderID   uint64
+	tx         *txChain
+	txData     *txChain                 // nil without a sender key
+	txNetworks map[uint32]*txChain      // By network ID; fixed after construction
+	rekeyAfter uint64                   // Rotate the transmit key after this many frames
+	onRekey    func(keySequence uint64) // Optional rotation notification (static chain)
+
+	// Receive direction: per-sender keys derived on demand from the static key, and
+	// for data frames from senders bound with BindSender or of networks with a key,
+	// from the keyring of that sender and network
+	rxKeys      *receiveKeyring
+	networkKeys map[uint32][symmetric.KeySize]byte // Fixed after construction
+	senderKeys  map[uint64][symmetric.KeySize]byte // Bound senders (keyringsMu)
+	keyrings    map[keyringID]*receiveKeyring      // (keyringsMu)
+	keyringsMu  sync.Mutex
+
+	// Optional lz4 compression of outbound frames (negotiated per session)
+	compressionEnabled atomic.Bool
+	compressor         frameCompressor
+
+	// Fragmentation of frames larger than the path MTU allows (0 = never fragment)
+	maxFrameSize atomic.Int64 // Largest marshaled frame the transport can carry
+	fragmentID   uint32       // Next fragment ID; only touched by encryptionLoop
+	reassembly   *reassembler // Only touched by decryptionLoop
+
+	// Receive statistics per authenticated sender
+	senders *senderTable
 
 	// Pipeline channels
-	inboundFrames  chan *layer2.EthernetFrame // TAP → Encrypt
-	outboundFrames chan []byte                // Decrypt → TAP
+	inboundFrames  chan *plainFrame     // TAP → Encrypt
+	outboundFrames chan *DecryptedFrame // Decrypt → TAP
+	controlFrames  chan *ControlFrame   // Decrypt → daemon control handler
 
 	encryptedFrames chan *EncryptedEthernetFrame // Encrypt → WSS
 	receivedFrames  chan *EncryptedEthernetFrame // WSS → Decrypt
@@ -35,34 +85,103 @@ type EncryptionPipeline struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// Metrics
+	// Metrics (updated atomically)
 	encryptedCount uint64
+	encryptedBytes uint64 // Wire size of encrypted frames
 	decryptedCount uint64
-	droppedCount   uint64 // Invalid frames dropped
+	receivedBytes  uint64       // Wire size of authenticated frames received
+	droppedCount   uint64       // Frames dropped for any reason
+	drops          dropCounters // Frames dropped by reason
+	rekeyCount     uint64       // Transmit key rotations (nonce limit or requested)
 	startTime      time.Time
 
+	// Compression metrics (frames considered for compression only)
+	compressedCount   uint64 // Frames sent compressed
+	incompressible    uint64 // Frames sent uncompressed because they did not shrink
+	compressionInput  uint64 // Plaintext bytes considered for compression
+	compressionOutput uint64 // Bytes actually encrypted for those frames
+
+	// Fragmentation metrics
+	fragmentedCount  uint64 // Frames split because they exceeded the maximum frame size
+	fragmentCount    uint64 // Fragments sent for those frames
+	reassembledCount uint64 // Fragmented frames received and rebuilt
+
 	// Configuration
 	bufferSize int // Channel buffer size
 }
 
-// EncryptedEthernetFrame wraps a symmetric.EncryptedFrame with metadata
-type EncryptedEthernetFrame struct {
-	Frame     *symmetric.EncryptedFrame
-	Timestamp time.Time
+// plainFrame is a plaintext payload queued for encryption
+type plainFrame struct {
+	data    []byte
+	flags   uint8
+	network uint32 // Sent with FlagNetwork unless DefaultNetwork
 }
 
 // PipelineConfig contains configuration for the encryption pipeline
 type PipelineConfig struct {
-	Key        [symmetric.KeySize]byte // Encryption key
-	BufferSize int                     // Channel buffer size (default: 100)
+	Key              [symmetric.KeySize]byte // Static key shared with the peer
+	BufferSize       int                     // Channel buffer size (default: 100)
+	SenderID         uint64                  // Session identifier for transmitted frames (default: random)
+	RekeyAfterFrames uint64                  // Rotate the transmit key after this many frames (default: symmetric.MaxCounter)
+	MaxFrameSize     int                     // Fragment frames whose marshaled size exceeds this (default: 0, never)
+
+	// SenderKey, if not zero, keys data frames instead of Key; only receivers that
+	// bound this sender to it with BindSender can read or forge them
+	SenderKey [symmetric.KeySize]byte
+
+	// NetworkKeys keys the frames of virtual networks (by network ID) in addition to the
+	// static or sender key; only peers holding a network's key can read or send its frames
+	NetworkKeys map[uint32][symmetric.KeySize]byte
+
+	// OnRekey is called from the encryption loop after each transmit key rotation (optional, must not block)
+	OnRekey func(keySequence uint64)
 }
 
 // NewEncryptionPipeline creates a new frame encryption pipeline
+//
+// Each pipeline transmits under its own session key, derived from the static key and a
+// sender ID that is unique to this pipeline instance. Received frames are decrypted with
+// the key of the sender named in their header, so each direction uses a different key and
+// restarting a daemon never reuses a (key, nonce) pair.
 func NewEncryptionPipeline(config *PipelineConfig) (*EncryptionPipeline, error) {
-	// Create nonce generator for replay protection
-	nonceGen, err := symmetric.NewNonceGenerator()
+	senderID := config.SenderID
+	if senderID == 0 {
+		var idBytes [8]byte
+		if _, err := rand.Read(idBytes[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate sender ID: %w", err)
+		}
+		senderID = binary.BigEndian.Uint64(idBytes[:])
+	}
+
+	// Derive the transmit session keys (sequence 0) for this sender
+	tx, err := newTxChain(config.Key, senderID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create nonce generator: %w", err)
+		return nil, err
+	}
+	base := config.Key
+	var txData *txChain
+	if config.SenderKey != ([symmetric.KeySize]byte{}) {
+		if txData, err = newTxChain(config.SenderKey, senderID); err != nil {
+			return nil, err
+		}
+		base = config.SenderKey
+	}
+	txNetworks := make(map[uint32]*txChain, len(config.NetworkKeys))
+	networkKeys := make(map[uint32][symmetric.KeySize]byte, len(config.NetworkKeys))
+	for network, networkKey := range config.NetworkKeys {
+		networkBase, err := rotation.DeriveNetworkKey(base, networkKey, network)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key of network %08x: %w", network, err)
+		}
+		if txNetworks[network], err = newTxChain(networkBase, senderID); err != nil {
+			return nil, err
+		}
+		networkKeys[network] = networkKey
+	}
+
+	rekeyAfter := config.RekeyAfterFrames
+	if rekeyAfter == 0 || rekeyAfter > symmetric.MaxCounter {
+		rekeyAfter = symmetric.MaxCounter
 	}
 
 	bufferSize := config.BufferSize
@@ -72,13 +191,25 @@ func NewEncryptionPipeline(config *PipelineConfig) (*EncryptionPipeline, error)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &EncryptionPipeline{
-		key:      config.Key,
-		nonceGen: nonceGen,
+	p := &EncryptionPipeline{
+		key:         config.Key,
+		senderID:    senderID,
+		tx:          tx,
+		txData:      txData,
+		txNetworks:  txNetworks,
+		rekeyAfter:  rekeyAfter,
+		onRekey:     config.OnRekey,
+		rxKeys:      newReceiveKeyring(config.Key),
+		networkKeys: networkKeys,
+		senderKeys:  make(map[uint64][symmetric.KeySize]byte),
+		keyrings:    make(map[keyringID]*receiveKeyring),
+		reassembly:  newReassembler(),
+		senders:     newSenderTable(),
 
 		// Buffered channels for pipeline stages
-		inboundFrames:   make(chan *layer2.EthernetFrame, bufferSize),
-		outboundFrames:  make(chan []byte, bufferSize),
+		inboundFrames:   make(chan *plainFrame, bufferSize),
+		outboundFrames:  make(chan *DecryptedFrame, bufferSize),
+		controlFrames:   make(chan *ControlFrame, bufferSize),
 		encryptedFrames: make(chan *EncryptedEthernetFrame, bufferSize),
 		receivedFrames:  make(chan *EncryptedEthernetFrame, bufferSize),
 
@@ -86,7 +217,10 @@ func NewEncryptionPipeline(config *PipelineConfig) (*EncryptionPipeline, error)
 		cancel:     cancel,
 		bufferSize: bufferSize,
 		startTime:  time.Now(),
-	}, nil
+	}
+	p.maxFrameSize.Store(int64(config.MaxFrameSize))
+
+	return p, nil
 }
 
 // Start starts all pipeline goroutines
@@ -108,12 +242,288 @@ func (p *EncryptionPipeline) Stop() {
 	// Close channels
 	close(p.inboundFrames)
 	close(p.outboundFrames)
+	close(p.controlFrames)
 	close(p.encryptedFrames)
 	close(p.receivedFrames)
+
+	// Wipe session keys
+	for _, chain := range p.txChains() {
+		chain.zero()
+	}
+	p.rxKeys.zero()
+	p.keyringsMu.Lock()
+	for id, keys := range p.keyrings {
+		keys.zero()
+		delete(p.keyrings, id)
+	}
+	for id, key := range p.senderKeys {
+		rotation.SecureZero(&key)
+		delete(p.senderKeys, id)
+	}
+	for id, key := range p.networkKeys {
+		rotation.SecureZero(&key)
+		delete(p.networkKeys, id)
+	}
+	p.keyringsMu.Unlock()
+}
+
+// rekey rotates a transmit key chain and starts a fresh nonce counter under it
+// Called when the counter for the current key reaches its limit or a rotation was
+// requested; the counter is never reset under an existing key.
+func (p *EncryptionPipeline) rekey(chain *txChain) error {
+	result, err := chain.rotation.RotateKey()
+	if err != nil {
+		return fmt.Errorf("transmit key rotation failed: %w", err)
+	}
+
+	nonceGen, err := symmetric.NewNonceGenerator(result.Sequence)
+	if err != nil {
+		return fmt.Errorf("failed to create nonce generator: %w", err)
+	}
+
+	chain.key = result.NewKey
+	chain.nonceGen = nonceGen
+	atomic.AddUint64(&p.rekeyCount, 1)
+
+	// Frames already sent under the old key are decrypted by the peer from its own
+	// derivation chain, so the old key can be wiped immediately
+	rotation.SecureZero(&result.OldKey)
+
+	if chain != p.tx {
+		logger.Info("transmit data key rotated", "key_sequence", result.Sequence)
+		return nil
+	}
+	logger.Info("transmit key rotated", "key_sequence", result.Sequence)
+	if p.onRekey != nil {
+		p.onRekey(result.Sequence)
+	}
+	return nil
+}
+
+// nextNonce returns a nonce for the chain's current key, rotating the key when its
+// frame limit is reached
+func (p *EncryptionPipeline) nextNonce(chain *txChain) ([symmetric.NonceSize]byte, error) {
+	if chain.rekeyRequested.Swap(false) || chain.nonceGen.GetCounter() >= p.rekeyAfter {
+		if err := p.rekey(chain); err != nil {
+			return [symmetric.NonceSize]byte{}, err
+		}
+	}
+
+	nonce, err := chain.nonceGen.GenerateNonce()
+	if errors.Is(err, symmetric.ErrNonceExhausted) {
+		if err := p.rekey(chain); err != nil {
+			return nonce, err
+		}
+		return chain.nonceGen.GenerateNonce()
+	}
+	return nonce, err
+}
+
+// RequestRekey rotates the transmit keys before the next frame is encrypted under each
+// The peer follows the rotation from the key sequence in the nonce.
+// Thread-safe: can be called while the pipeline is running.
+func (p *EncryptionPipeline) RequestRekey() {
+	for _, chain := range p.txChains() {
+		chain.rekeyRequested.Store(true)
+	}
+}
+
+// txChains returns every transmit key chain
+func (p *EncryptionPipeline) txChains() []*txChain {
+	chains := []*txChain{p.tx}
+	if p.txData != nil {
+		chains = append(chains, p.txData)
+	}
+	for _, chain := range p.txNetworks {
+		chains = append(chains, chain)
+	}
+	return chains
+}
+
+// txChain returns the chain a frame is encrypted under
+func (p *EncryptionPipeline) txChain(flags uint8, network uint32) *txChain {
+	switch {
+	case flags&FlagControl != 0:
+		return p.tx
+	case p.txNetworks[network] != nil:
+		return p.txNetworks[network]
+	case p.txData != nil:
+		return p.txData
+	}
+	return p.tx
+}
+
+// BindSender makes data frames from senderID decrypt only under the chain derived from
+// its sender key (PipelineConfig.SenderKey), so holding the static key no longer lets
+// anyone else send data frames under that sender ID. Control frames from it still use
+// the static key. Binding the same key again keeps the sender's key state.
+// Thread-safe: can be called while the pipeline is running.
+func (p *EncryptionPipeline) BindSender(senderID uint64, senderKey [symmetric.KeySize]byte) {
+	p.keyringsMu.Lock()
+	defer p.keyringsMu.Unlock()
+
+	if key, ok := p.senderKeys[senderID]; ok {
+		if key == senderKey {
+			return
+		}
+		for id, keys := range p.keyrings {
+			if id.bound && id.sender == senderID {
+				keys.zero()
+				delete(p.keyrings, id)
+			}
+		}
+	}
+	p.senderKeys[senderID] = senderKey
+}
+
+// receiveKeys returns the keyring for a frame
+// Control frames and data frames of unbound senders outside networks with a key use
+// the static keyring; the others the keyring of their sender (if bound) and network
+// (if it has a key), created on first use.
+func (p *EncryptionPipeline) receiveKeys(frame *EncryptedEthernetFrame) (*receiveKeyring, error) {
+	if frame.Flags&FlagControl != 0 {
+		return p.rxKeys, nil
+	}
+
+	p.keyringsMu.Lock()
+	defer p.keyringsMu.Unlock()
+
+	senderKey, bound := p.senderKeys[frame.SenderID]
+	networkKey, keyed := p.networkKeys[frame.Network]
+	if !bound && !keyed {
+		return p.rxKeys, nil
+	}
+
+	id := keyringID{bound: bound}
+	base := p.key
+	if bound {
+		id.sender, base = frame.SenderID, senderKey
+	}
+	if keyed {
+		id.network = frame.Network
+	}
+	if keys, ok := p.keyrings[id]; ok {
+		return keys, nil
+	}
+
+	if keyed {
+		var err error
+		if base, err = rotation.DeriveNetworkKey(base, networkKey, frame.Network); err != nil {
+			return nil, err
+		}
+	}
+	keys := newReceiveKeyring(base)
+	p.keyrings[id] = keys
+	return keys, nil
+}
+
+// SetCompression enables or disables lz4 compression of outbound data frames
+// Should only be enabled once every peer has advertised lz4 support; receiving
+// compressed frames is always supported.
+func (p *EncryptionPipeline) SetCompression(enabled bool) {
+	p.compressionEnabled.Store(enabled)
+}
+
+// CompressionEnabled reports whether outbound data frames are compressed
+func (p *EncryptionPipeline) CompressionEnabled() bool {
+	return p.compressionEnabled.Load()
+}
+
+// maybeCompress compresses a data frame when compression is enabled and it helps
+func (p *EncryptionPipeline) maybeCompress(frame *plainFrame) ([]byte, uint8) {
+	if frame.flags&FlagControl != 0 || !p.compressionEnabled.Load() {
+		return frame.data, frame.flags
+	}
+
+	atomic.AddUint64(&p.compressionInput, uint64(len(frame.data)))
+
+	compressed, ok := p.compressor.compress(frame.data)
+	if !ok {
+		atomic.AddUint64(&p.incompressible, 1)
+		atomic.AddUint64(&p.compressionOutput, uint64(len(frame.data)))
+		return frame.data, frame.flags
+	}
+
+	atomic.AddUint64(&p.compressedCount, 1)
+	atomic.AddUint64(&p.compressionOutput, uint64(len(compressed)))
+	return compressed, frame.flags | FlagCompressed
+}
+
+// SetMaxFrameSize sets the largest marshaled frame the transport can carry
+// Data frames that would exceed it are fragmented; control frames are always sent whole
+// so path MTU probes measure the real limit. 0 disables fragmentation.
+func (p *EncryptionPipeline) SetMaxFrameSize(size int) {
+	p.maxFrameSize.Store(int64(size))
+}
+
+// MaxFrameSize returns the current fragmentation threshold (0 = disabled)
+func (p *EncryptionPipeline) MaxFrameSize() int {
+	return int(p.maxFrameSize.Load())
+}
+
+// maybeFragment splits a data frame that would not fit in a single transport datagram
+// Returns nil if the frame can be sent as-is.
+func (p *EncryptionPipeline) maybeFragment(plaintext []byte, flags uint8) ([][]byte, error) {
+	maxSize := int(p.maxFrameSize.Load())
+	overhead := FrameOverhead + headerSize(flags) - FrameHeaderSize
+	if maxSize <= 0 || flags&FlagControl != 0 || overhead+len(plaintext) <= maxSize {
+		return nil, nil
+	}
+
+	p.fragmentID++
+	fragments, err := fragmentPayload(plaintext, p.fragmentID, maxSize-overhead)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddUint64(&p.fragmentedCount, 1)
+	atomic.AddUint64(&p.fragmentCount, uint64(len(fragments)))
+	return fragments, nil
+}
+
+// encryptAndQueue encrypts one plaintext and queues it for transmission
+// Returns false if the pipeline is shutting down.
+func (p *EncryptionPipeline) encryptAndQueue(plaintext []byte, flags uint8, network uint32) bool {
+	chain := p.txChain(flags, network)
+
+	// Generate unique nonce for this frame (rotates the key if exhausted)
+	nonce, err := p.nextNonce(chain)
+	if err != nil {
+		encryptErrorLog.Log(logger, slog.LevelError, "failed to generate nonce", "error", err)
+		return true
+	}
+
+	// Encrypt frame with ChaCha20-Poly1305 AEAD, authenticating the header
+	out := &EncryptedEthernetFrame{
+		SenderID:  p.senderID,
+		Flags:     flags,
+		Network:   network,
+		Timestamp: time.Now(),
+	}
+	encrypted, err := symmetric.EncryptWithAdditionalData(plaintext, out.header(), chain.key, nonce)
+	if err != nil {
+		encryptErrorLog.Log(logger, slog.LevelError, "encryption failed", "error", err)
+		return true
+	}
+	out.Frame = encrypted
+
+	// Send to encrypted frames channel (for WSS transmission)
+	select {
+	case p.encryptedFrames <- out:
+		atomic.AddUint64(&p.encryptedCount, 1)
+		atomic.AddUint64(&p.encryptedBytes, uint64(out.wireSize()))
+	case <-p.ctx.Done():
+		return false
+	default:
+		// Channel full - drop frame (backpressure)
+		encryptedFullLog.Warn(logger, "encrypted channel full, dropping frame")
+		p.drop(DropQueueFull, nil)
+	}
+	return true
 }
 
 // encryptionLoop handles frame encryption (runs in separate goroutine)
-// Pipeline: TAP readChan → Serialize() → Encrypt() → encryptedFrames channel
+// Pipeline: TAP readChan → Serialize() → Compress() → Fragment() → Encrypt() → encryptedFrames channel
 func (p *EncryptionPipeline) encryptionLoop() {
 	defer p.wg.Done()
 
@@ -126,35 +536,31 @@ func (p *EncryptionPipeline) encryptionLoop() {
 				return
 			}
 
-			// Serialize frame to bytes
-			plaintext := frame.Serialize()
+			// Compress if negotiated and worthwhile
+			plaintext, flags := p.maybeCompress(frame)
+			if frame.network != DefaultNetwork {
+				flags |= FlagNetwork
+			}
 
-			// Generate unique nonce for this frame
-			nonce, err := p.nonceGen.GenerateNonce()
+			// Split frames the path cannot carry in one datagram
+			fragments, err := p.maybeFragment(plaintext, flags)
 			if err != nil {
-				log.Printf("FrameEncryption: Failed to generate nonce: %v", err)
+				fragmentLog.Warn(logger, "dropping frame", "error", err)
+				p.drop(DropFragment, nil)
 				continue
 			}
 
-			// Encrypt frame with ChaCha20-Poly1305 AEAD
-			encrypted, err := symmetric.Encrypt(plaintext, p.key, nonce)
-			if err != nil {
-				log.Printf("FrameEncryption: Encryption failed: %v", err)
+			if fragments == nil {
+				if !p.encryptAndQueue(plaintext, flags, frame.network) {
+					return
+				}
 				continue
 			}
 
-			// Send to encrypted frames channel (for WSS transmission)
-			select {
-			case p.encryptedFrames <- &EncryptedEthernetFrame{
-				Frame:     encrypted,
-				Timestamp: time.Now(),
-			}:
-				p.encryptedCount++
-			case <-p.ctx.Done():
-				return
-			default:
-				// Channel full - drop frame (backpressure)
-				log.Printf("FrameEncryption: Encrypted channel full, dropping frame")
+			for _, fragment := range fragments {
+				if !p.encryptAndQueue(fragment, flags|FlagFragment, frame.network) {
+					return
+				}
 			}
 		}
 	}
@@ -174,25 +580,83 @@ func (p *EncryptionPipeline) decryptionLoop() {
 				return
 			}
 
+			// Select the sender's key for the sequence carried in the nonce
+			_, keySequence := symmetric.ParseNonce(encFrame.Frame.Nonce)
+			keys, err := p.receiveKeys(encFrame)
+			if err != nil {
+				noKeyLog.Warn(logger, "no key for frame", "sender", encFrame.SenderID, "error", err)
+				p.drop(DropNoKey, nil)
+				continue
+			}
+			key, keyState, err := keys.lookup(encFrame.SenderID, keySequence)
+			if err != nil {
+				noKeyLog.Warn(logger, "no key for frame", "sender", encFrame.SenderID, "error", err)
+				p.drop(DropNoKey, nil)
+				continue
+			}
+
 			// Decrypt and validate authentication tag
-			plaintext, err := symmetric.Decrypt(encFrame.Frame, p.key)
+			plaintext, err := symmetric.DecryptWithAdditionalData(encFrame.Frame, encFrame.header(), key)
 			if err != nil {
 				// Invalid authentication tag - frame tampered or wrong key
-				log.Printf("FrameEncryption: Decryption failed (invalid tag): %v", err)
-				p.droppedCount++
+				authFailedLog.Warn(logger, "decryption failed (invalid tag)", "sender", encFrame.SenderID, "error", err)
+				p.drop(DropAuthFailed, nil)
 				continue // Drop invalid frame
 			}
 
+			// Only authenticated frames may advance the sender's key state or create statistics
+			keys.commit(encFrame.SenderID, keyState)
+			sender := p.senders.get(encFrame.SenderID)
+			sender.received(encFrame.wireSize(), keySequence, time.Now())
+			atomic.AddUint64(&p.receivedBytes, uint64(encFrame.wireSize()))
+
+			// Hold fragments until the whole frame has arrived
+			if encFrame.Flags&FlagFragment != 0 {
+				payload, complete, err := p.reassembly.add(encFrame.SenderID, plaintext, time.Now())
+				if err != nil {
+					payloadLog.Warn(logger, "dropping fragment", "sender", encFrame.SenderID, "error", err)
+					p.drop(DropReassembly, sender)
+					continue
+				}
+				if !complete {
+					continue
+				}
+				plaintext = payload
+				atomic.AddUint64(&p.reassembledCount, 1)
+			}
+
+			if encFrame.Flags&FlagCompressed != 0 {
+				plaintext, err = decompressFrame(plaintext)
+				if err != nil {
+					payloadLog.Warn(logger, "dropping frame", "sender", encFrame.SenderID, "error", err)
+					p.drop(DropDecompress, sender)
+					continue
+				}
+			}
+
+			// Control messages go to the daemon, not the network device
+			if encFrame.Flags&FlagControl != 0 {
+				select {
+				case p.controlFrames <- &ControlFrame{SenderID: encFrame.SenderID, Payload: plaintext}:
+				case <-p.ctx.Done():
+					return
+				default:
+					controlFullLog.Warn(logger, "control channel full, dropping control frame")
+					p.drop(DropQueueFull, sender)
+				}
+				continue
+			}
+
 			// Send decrypted frame to outbound channel (for TAP injection)
 			select {
-			case p.outboundFrames <- plaintext:
-				p.decryptedCount++
+			case p.outboundFrames <- &DecryptedFrame{SenderID: encFrame.SenderID, Network: encFrame.Network, Payload: plaintext}:
+				atomic.AddUint64(&p.decryptedCount, 1)
 			case <-p.ctx.Done():
 				return
 			default:
 				// Channel full - drop frame (backpressure)
-				log.Printf("FrameEncryption: Outbound channel full, dropping frame")
-				p.droppedCount++
+				outboundFullLog.Warn(logger, "outbound channel full, dropping frame")
+				p.drop(DropQueueFull, sender)
 			}
 		}
 	}
@@ -201,8 +665,15 @@ func (p *EncryptionPipeline) decryptionLoop() {
 // SendFrame sends a frame for encryption (called by TAP device)
 // Non-blocking: returns immediately if channel is full
 func (p *EncryptionPipeline) SendFrame(frame *layer2.EthernetFrame) bool {
+	return p.SendNetworkFrame(DefaultNetwork, frame)
+}
+
+// SendNetworkFrame sends a frame of a virtual network for encryption
+// The frame carries the network ID, so relays pass it to that network's members only.
+// Non-blocking like SendFrame.
+func (p *EncryptionPipeline) SendNetworkFrame(network uint32, frame *layer2.EthernetFrame) bool {
 	select {
-	case p.inboundFrames <- frame:
+	case p.inboundFrames <- &plainFrame{data: frame.Serialize(), network: network}:
 		return true
 	default:
 		// Channel full - cannot accept frame
@@ -210,9 +681,36 @@ func (p *EncryptionPipeline) SendFrame(frame *layer2.EthernetFrame) bool {
 	}
 }
 
+// SendPacket sends a bare IP packet for encryption (called for TUN devices)
+// Non-blocking: returns immediately if channel is full
+func (p *EncryptionPipeline) SendPacket(packet []byte) bool {
+	select {
+	case p.inboundFrames <- &plainFrame{data: packet}:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendControl queues a daemon control message for encryption
+// Non-blocking: returns immediately if channel is full
+func (p *EncryptionPipeline) SendControl(payload []byte) bool {
+	select {
+	case p.inboundFrames <- &plainFrame{data: payload, flags: FlagControl}:
+		return true
+	default:
+		return false
+	}
+}
+
 // ReceiveEncryptedFrame receives an encrypted frame for transmission (called by WebSocket sender)
 // Blocking: waits until frame is available or context is canceled
 func (p *EncryptionPipeline) ReceiveEncryptedFrame(ctx context.Context) (*EncryptedEthernetFrame, error) {
+	// Buffered frames are discarded once the pipeline has stopped
+	if p.ctx.Err() != nil {
+		return nil, fmt.Errorf("pipeline stopped")
+	}
+
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -240,7 +738,12 @@ func (p *EncryptionPipeline) SendEncryptedFrame(frame *EncryptedEthernetFrame) b
 
 // ReceiveDecryptedFrame receives a decrypted frame for TAP injection (called by TAP device)
 // Blocking: waits until frame is available or context is canceled
-func (p *EncryptionPipeline) ReceiveDecryptedFrame(ctx context.Context) ([]byte, error) {
+func (p *EncryptionPipeline) ReceiveDecryptedFrame(ctx context.Context) (*DecryptedFrame, error) {
+	// Buffered frames are discarded once the pipeline has stopped
+	if p.ctx.Err() != nil {
+		return nil, fmt.Errorf("pipeline stopped")
+	}
+
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -254,24 +757,93 @@ func (p *EncryptionPipeline) ReceiveDecryptedFrame(ctx context.Context) ([]byte,
 	}
 }
 
+// SenderID returns the session identifier carried in frames sent by this pipeline
+func (p *EncryptionPipeline) SenderID() uint64 {
+	return p.senderID
+}
+
+// ReceiveControlFrame receives a decrypted control message (called by the daemon)
+// Blocking: waits until a message is available or context is canceled
+func (p *EncryptionPipeline) ReceiveControlFrame(ctx context.Context) (*ControlFrame, error) {
+	// Buffered frames are discarded once the pipeline has stopped
+	if p.ctx.Err() != nil {
+		return nil, fmt.Errorf("pipeline stopped")
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.ctx.Done():
+		return nil, fmt.Errorf("pipeline stopped")
+	case frame, ok := <-p.controlFrames:
+		if !ok {
+			return nil, fmt.Errorf("control frames channel closed")
+		}
+		return frame, nil
+	}
+}
+
 // GetMetrics returns pipeline performance metrics
 func (p *EncryptionPipeline) GetMetrics() *PipelineMetrics {
 	return &PipelineMetrics{
-		EncryptedCount: p.encryptedCount,
-		DecryptedCount: p.decryptedCount,
-		DroppedCount:   p.droppedCount,
-		Uptime:         time.Since(p.startTime),
-		BufferSize:     p.bufferSize,
+		EncryptedCount: atomic.LoadUint64(&p.encryptedCount),
+		EncryptedBytes: atomic.LoadUint64(&p.encryptedBytes),
+		DecryptedCount: atomic.LoadUint64(&p.decryptedCount),
+		ReceivedBytes:  atomic.LoadUint64(&p.receivedBytes),
+		DroppedCount:   atomic.LoadUint64(&p.droppedCount),
+		Drops:          p.drops.snapshot(),
+		RekeyCount:     atomic.LoadUint64(&p.rekeyCount),
+		KeySequence:    p.tx.rotation.GetSequence(),
+
+		CompressionEnabled:  p.compressionEnabled.Load(),
+		CompressedCount:     atomic.LoadUint64(&p.compressedCount),
+		IncompressibleCount: atomic.LoadUint64(&p.incompressible),
+		CompressionInput:    atomic.LoadUint64(&p.compressionInput),
+		CompressionOutput:   atomic.LoadUint64(&p.compressionOutput),
+
+		MaxFrameSize:       p.MaxFrameSize(),
+		FragmentedCount:    atomic.LoadUint64(&p.fragmentedCount),
+		FragmentCount:      atomic.LoadUint64(&p.fragmentCount),
+		ReassembledCount:   atomic.LoadUint64(&p.reassembledCount),
+		ReassemblyTimeouts: atomic.LoadUint64(&p.reassembly.expired),
+
+		Uptime:     time.Since(p.startTime),
+		BufferSize: p.bufferSize,
 	}
 }
 
 // PipelineMetrics contains pipeline performance metrics
 type PipelineMetrics struct {
-	EncryptedCount uint64        // Total frames encrypted
-	DecryptedCount uint64        // Total frames decrypted
-	DroppedCount   uint64        // Total frames dropped (invalid tag or buffer full)
-	Uptime         time.Duration // Pipeline uptime
-	BufferSize     int           // Channel buffer size
+	EncryptedCount uint64            // Total frames encrypted
+	EncryptedBytes uint64            // Wire size of those frames
+	DecryptedCount uint64            // Total frames decrypted
+	ReceivedBytes  uint64            // Wire size of authenticated frames received (fragments included)
+	DroppedCount   uint64            // Total frames dropped (invalid tag or buffer full)
+	Drops          map[string]uint64 // DroppedCount by DropReason name
+	RekeyCount     uint64            // Transmit key rotations (nonce limit or requested)
+	KeySequence    uint64            // Rotation sequence of the current transmit key under the static key
+
+	CompressionEnabled  bool          // Outbound lz4 compression negotiated
+	CompressedCount     uint64        // Frames sent compressed
+	IncompressibleCount uint64        // Frames skipped because they did not shrink
+	CompressionInput    uint64        // Plaintext bytes considered for compression
+	CompressionOutput   uint64        // Bytes encrypted for those frames after compression
+	MaxFrameSize        int           // Fragmentation threshold (0 = disabled)
+	FragmentedCount     uint64        // Frames split into fragments
+	FragmentCount       uint64        // Fragments sent
+	ReassembledCount    uint64        // Fragmented frames rebuilt on receive
+	ReassemblyTimeouts  uint64        // Incomplete fragmented frames discarded
+	Uptime              time.Duration // Pipeline uptime
+	BufferSize          int           // Channel buffer size
+}
+
+// CompressionRatio returns input/output bytes for frames considered for compression
+// 1.0 means no savings; 2.0 means frames shrank to half their size on average
+func (m *PipelineMetrics) CompressionRatio() float64 {
+	if m.CompressionOutput == 0 {
+		return 1.0
+	}
+	return float64(m.CompressionInput) / float64(m.CompressionOutput)
 }
 
 // GetThroughput calculates throughput in frames per second
@@ -1,6 +1,7 @@
 package frameencryption
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"testing"
@@ -29,7 +30,7 @@ func TestPipelineCreation(t *testing.T) {
 		t.Errorf("Expected buffer size 100, got %d", pipeline.bufferSize)
 	}
 
-	if pipeline.nonceGen == nil {
+	if pipeline.tx.nonceGen == nil {
 		t.Error("Nonce generator not initialized")
 	}
 }
@@ -82,10 +83,11 @@ func TestEncryptionDecryption(t *testing.T) {
 	}
 
 	// Receive decrypted frame
-	decryptedBytes, err := pipeline.ReceiveDecryptedFrame(ctx)
+	decrypted, err := pipeline.ReceiveDecryptedFrame(ctx)
 	if err != nil {
 		t.Fatalf("Failed to receive decrypted frame: %v", err)
 	}
+	decryptedBytes := decrypted.Payload
 
 	// Verify decrypted frame matches original
 	originalBytes := testFrame.Serialize()
@@ -124,17 +126,19 @@ func TestInvalidTagRejection(t *testing.T) {
 	testFrame := createTestFrame()
 	plaintext := testFrame.Serialize()
 
-	nonce, _ := pipeline.nonceGen.GenerateNonce()
-	encrypted, _ := symmetric.Encrypt(plaintext, key, nonce)
+	tamperedFrame := &EncryptedEthernetFrame{
+		SenderID:  pipeline.SenderID(),
+		Timestamp: time.Now(),
+	}
+
+	nonce, _ := pipeline.tx.nonceGen.GenerateNonce()
+	encrypted, _ := symmetric.EncryptWithAdditionalData(plaintext, tamperedFrame.header(), pipeline.tx.key, nonce)
 
 	// Tamper with ciphertext (flip a bit)
 	encrypted.Ciphertext[0] ^= 0x01
 
 	// Send tampered frame for decryption
-	tamperedFrame := &EncryptedEthernetFrame{
-		Frame:     encrypted,
-		Timestamp: time.Now(),
-	}
+	tamperedFrame.Frame = encrypted
 
 	if !pipeline.SendEncryptedFrame(tamperedFrame) {
 		t.Fatal("Failed to send tampered frame")
@@ -151,8 +155,13 @@ func TestInvalidTagRejection(t *testing.T) {
 
 	// Verify dropped count increased
 	metrics := pipeline.GetMetrics()
-	if metrics.DroppedCount != 1 {
-		t.Errorf("Expected 1 dropped frame, got %d", metrics.DroppedCount)
+	if metrics.DroppedCount != 1 || metrics.Drops[DropAuthFailed.String()] != 1 {
+		t.Errorf("Expected 1 dropped frame (auth_failed), got %d %v", metrics.DroppedCount, metrics.Drops)
+	}
+
+	// Unauthenticated frames must not create per-sender statistics
+	if _, ok := pipeline.SenderStats(pipeline.SenderID()); ok {
+		t.Error("Tampered frame was attributed to its claimed sender")
 	}
 }
 
@@ -370,6 +379,493 @@ func TestPipelineGracefulShutdown(t *testing.T) {
 	// This is expected behavior - users should not call SendFrame() after Stop()
 }
 
+// TestDirectionalKeys tests that two peers sharing a static key transmit under different keys
+func TestDirectionalKeys(t *testing.T) {
+	key := generateTestKey()
+
+	alice, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer alice.Stop()
+
+	bob, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer bob.Stop()
+
+	if alice.SenderID() == bob.SenderID() {
+		t.Fatal("Pipelines should pick distinct sender IDs")
+	}
+	if alice.tx.key == bob.tx.key || alice.tx.key == key {
+		t.Fatal("Each direction should use its own key, distinct from the static key")
+	}
+
+	alice.Start()
+	bob.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	testFrame := createTestFrame()
+
+	// Alice → wire → Bob
+	if !alice.SendFrame(testFrame) {
+		t.Fatal("Failed to send frame for encryption")
+	}
+	encFrame, err := alice.ReceiveEncryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive encrypted frame: %v", err)
+	}
+
+	received, err := UnmarshalEncryptedFrame(encFrame.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to unmarshal frame: %v", err)
+	}
+	if received.SenderID != alice.SenderID() {
+		t.Errorf("Sender ID mismatch: got %x, expected %x", received.SenderID, alice.SenderID())
+	}
+
+	if !bob.SendEncryptedFrame(received) {
+		t.Fatal("Failed to send encrypted frame for decryption")
+	}
+	decrypted, err := bob.ReceiveDecryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to decrypt frame from peer: %v", err)
+	}
+	if string(decrypted.Payload) != string(testFrame.Serialize()) {
+		t.Error("Decrypted frame does not match original")
+	}
+	if decrypted.SenderID != alice.SenderID() {
+		t.Errorf("Decrypted frame sender = %016x, want %016x", decrypted.SenderID, alice.SenderID())
+	}
+
+	stats, ok := bob.SenderStats(alice.SenderID())
+	if !ok || stats.Frames != 1 || stats.Bytes != uint64(len(encFrame.Marshal())) || stats.LastFrame.IsZero() {
+		t.Errorf("Unexpected sender statistics: %+v", stats)
+	}
+	if metrics := alice.GetMetrics(); metrics.EncryptedBytes != stats.Bytes {
+		t.Errorf("Sender counted %d bytes, receiver %d", metrics.EncryptedBytes, stats.Bytes)
+	}
+	if metrics := bob.GetMetrics(); metrics.ReceivedBytes != stats.Bytes {
+		t.Errorf("Receiver counted %d bytes in total, %d from the sender", metrics.ReceivedBytes, stats.Bytes)
+	}
+
+	// Forging the sender ID must fail authentication
+	received.SenderID = bob.SenderID()
+	bob.SendEncryptedFrame(received)
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	if _, err := bob.ReceiveDecryptedFrame(shortCtx); err == nil {
+		t.Error("Frame with forged sender ID should be dropped")
+	}
+}
+
+// TestRekeyOnFrameLimit tests that reaching the nonce limit rotates the key instead of reusing it
+func TestRekeyOnFrameLimit(t *testing.T) {
+	key := generateTestKey()
+
+	sender, err := NewEncryptionPipeline(&PipelineConfig{
+		Key:              key,
+		BufferSize:       100,
+		RekeyAfterFrames: 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer sender.Stop()
+
+	receiver, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 100})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer receiver.Stop()
+
+	sender.Start()
+	receiver.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	testFrame := createTestFrame()
+	seenNonces := make(map[[symmetric.NonceSize]byte]bool)
+
+	const numFrames = 12
+	for i := 0; i < numFrames; i++ {
+		if !sender.SendFrame(testFrame) {
+			t.Fatalf("Failed to send frame %d", i)
+		}
+
+		encFrame, err := sender.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive encrypted frame %d: %v", i, err)
+		}
+
+		if seenNonces[encFrame.Frame.Nonce] {
+			t.Fatalf("Nonce reused at frame %d", i)
+		}
+		seenNonces[encFrame.Frame.Nonce] = true
+
+		_, keySequence := symmetric.ParseNonce(encFrame.Frame.Nonce)
+		if expected := uint64(i / 5); keySequence != expected {
+			t.Errorf("Frame %d: expected key sequence %d, got %d", i, expected, keySequence)
+		}
+
+		if !receiver.SendEncryptedFrame(encFrame) {
+			t.Fatalf("Failed to send frame %d for decryption", i)
+		}
+		if _, err := receiver.ReceiveDecryptedFrame(ctx); err != nil {
+			t.Fatalf("Receiver failed to follow key rotation at frame %d: %v", i, err)
+		}
+	}
+
+	metrics := sender.GetMetrics()
+	if metrics.RekeyCount != 2 {
+		t.Errorf("Expected 2 rekeys, got %d", metrics.RekeyCount)
+	}
+	if metrics.KeySequence != 2 {
+		t.Errorf("Expected key sequence 2, got %d", metrics.KeySequence)
+	}
+}
+
+// TestRequestedRekey tests rotating the transmit key on request
+func TestRequestedRekey(t *testing.T) {
+	key := generateTestKey()
+
+	rotations := make(chan uint64, 4)
+	sender, err := NewEncryptionPipeline(&PipelineConfig{
+		Key:        key,
+		BufferSize: 100,
+		OnRekey:    func(keySequence uint64) { rotations <- keySequence },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer sender.Stop()
+
+	receiver, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 100})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer receiver.Stop()
+
+	sender.Start()
+	receiver.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	testFrame := createTestFrame()
+	for i, rekeyFirst := range []bool{false, true, false} {
+		if rekeyFirst {
+			sender.RequestRekey()
+		}
+		if !sender.SendFrame(testFrame) {
+			t.Fatalf("Failed to send frame %d", i)
+		}
+
+		encFrame, err := sender.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive encrypted frame %d: %v", i, err)
+		}
+
+		_, keySequence := symmetric.ParseNonce(encFrame.Frame.Nonce)
+		if expected := uint64(min(i, 1)); keySequence != expected {
+			t.Errorf("Frame %d: expected key sequence %d, got %d", i, expected, keySequence)
+		}
+
+		receiver.SendEncryptedFrame(encFrame)
+		if _, err := receiver.ReceiveDecryptedFrame(ctx); err != nil {
+			t.Fatalf("Receiver failed to follow requested rotation at frame %d: %v", i, err)
+		}
+	}
+
+	if metrics := sender.GetMetrics(); metrics.RekeyCount != 1 || metrics.KeySequence != 1 {
+		t.Errorf("Expected 1 rekey at sequence 1, got %d at %d", metrics.RekeyCount, metrics.KeySequence)
+	}
+	if len(rotations) != 1 || <-rotations != 1 {
+		t.Error("Expected OnRekey to report the rotation to sequence 1")
+	}
+}
+
+// TestUnmarshalRejectsMalformedFrames tests wire format validation
+func TestUnmarshalRejectsMalformedFrames(t *testing.T) {
+	if _, err := UnmarshalEncryptedFrame(make([]byte, FrameOverhead-1)); err == nil {
+		t.Error("Expected error for frame shorter than overhead")
+	}
+
+	data := make([]byte, FrameOverhead)
+	data[0] = FrameVersion + 1
+	if _, err := UnmarshalEncryptedFrame(data); err == nil {
+		t.Error("Expected error for unsupported version")
+	}
+}
+
+// TestCompression tests that compressible frames are compressed and incompressible ones are not
+func TestCompression(t *testing.T) {
+	key := generateTestKey()
+
+	pipeline, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer pipeline.Stop()
+
+	pipeline.SetCompression(true)
+	pipeline.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	compressible := createTestFrame()
+	compressible.Payload = bytes.Repeat([]byte("telemetry=42;"), 100)
+
+	random := createTestFrame()
+	random.Payload = make([]byte, 1200)
+	rand.Read(random.Payload)
+
+	for _, tc := range []struct {
+		name           string
+		frame          *layer2.EthernetFrame
+		wantCompressed bool
+	}{
+		{"compressible", compressible, true},
+		{"incompressible", random, false},
+	} {
+		if !pipeline.SendFrame(tc.frame) {
+			t.Fatalf("%s: failed to send frame", tc.name)
+		}
+		encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("%s: failed to receive encrypted frame: %v", tc.name, err)
+		}
+
+		compressed := encFrame.Flags&FlagCompressed != 0
+		if compressed != tc.wantCompressed {
+			t.Errorf("%s: compressed flag = %v, expected %v", tc.name, compressed, tc.wantCompressed)
+		}
+		if compressed && len(encFrame.Frame.Ciphertext) >= len(tc.frame.Serialize()) {
+			t.Errorf("%s: compressed frame did not shrink", tc.name)
+		}
+
+		pipeline.SendEncryptedFrame(encFrame)
+		decrypted, err := pipeline.ReceiveDecryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("%s: failed to decrypt: %v", tc.name, err)
+		}
+		if !bytes.Equal(decrypted.Payload, tc.frame.Serialize()) {
+			t.Errorf("%s: decrypted frame does not match original", tc.name)
+		}
+	}
+
+	metrics := pipeline.GetMetrics()
+	if metrics.CompressedCount != 1 || metrics.IncompressibleCount != 1 {
+		t.Errorf("Expected 1 compressed and 1 incompressible frame, got %d and %d",
+			metrics.CompressedCount, metrics.IncompressibleCount)
+	}
+	if metrics.CompressionRatio() <= 1.0 {
+		t.Errorf("Expected compression ratio above 1.0, got %.2f", metrics.CompressionRatio())
+	}
+}
+
+// TestControlFrames tests that control messages bypass the data path
+func TestControlFrames(t *testing.T) {
+	key := generateTestKey()
+
+	pipeline, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer pipeline.Stop()
+
+	pipeline.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	payload := []byte(`{"type":"hello"}`)
+	if !pipeline.SendControl(payload) {
+		t.Fatal("Failed to send control message")
+	}
+
+	encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive encrypted frame: %v", err)
+	}
+	if encFrame.Flags&FlagControl == 0 {
+		t.Fatal("Control flag not set")
+	}
+
+	pipeline.SendEncryptedFrame(encFrame)
+
+	control, err := pipeline.ReceiveControlFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive control frame: %v", err)
+	}
+	if !bytes.Equal(control.Payload, payload) || control.SenderID != pipeline.SenderID() {
+		t.Error("Control frame payload or sender mismatch")
+	}
+}
+
+// TestBoundSender tests that a bound sender's data frames only decrypt under its sender key
+func TestBoundSender(t *testing.T) {
+	key, senderKey := generateTestKey(), generateTestKey()
+
+	alice, err := NewEncryptionPipeline(&PipelineConfig{Key: key, SenderKey: senderKey, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer alice.Stop()
+
+	// Mallory holds the static key and sends under alice's sender ID
+	mallory, err := NewEncryptionPipeline(&PipelineConfig{Key: key, SenderID: alice.SenderID(), BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer mallory.Stop()
+
+	bob, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer bob.Stop()
+
+	alice.Start()
+	mallory.Start()
+	bob.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	// transmit moves one frame from a sender to bob
+	transmit := func(sender *EncryptionPipeline, send func() bool) {
+		if !send() {
+			t.Fatal("Failed to send frame for encryption")
+		}
+		encFrame, err := sender.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive encrypted frame: %v", err)
+		}
+		bob.SendEncryptedFrame(encFrame)
+	}
+	testFrame := createTestFrame()
+
+	// Unbound, alice's data frames do not decrypt under the static key
+	transmit(alice, func() bool { return alice.SendFrame(testFrame) })
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	if _, err := bob.ReceiveDecryptedFrame(shortCtx); err == nil {
+		t.Error("Data frame under an unbound sender key should be dropped")
+	}
+
+	// Control frames stay under the static key
+	transmit(alice, func() bool { return alice.SendControl([]byte(`{"type":"hello"}`)) })
+	if _, err := bob.ReceiveControlFrame(ctx); err != nil {
+		t.Fatalf("Failed to receive control frame: %v", err)
+	}
+
+	bob.BindSender(alice.SenderID(), senderKey)
+	transmit(alice, func() bool { return alice.SendFrame(testFrame) })
+	decrypted, err := bob.ReceiveDecryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to decrypt frame from bound sender: %v", err)
+	}
+	if !bytes.Equal(decrypted.Payload, testFrame.Serialize()) {
+		t.Error("Decrypted frame does not match original")
+	}
+
+	// Rebinding the same key keeps the key state; a forged data frame is dropped
+	bob.BindSender(alice.SenderID(), senderKey)
+	transmit(mallory, func() bool { return mallory.SendFrame(testFrame) })
+	transmit(alice, func() bool { return alice.SendFrame(testFrame) })
+	if _, err := bob.ReceiveDecryptedFrame(ctx); err != nil {
+		t.Fatalf("Failed to decrypt frame from bound sender: %v", err)
+	}
+	if metrics := bob.GetMetrics(); metrics.DecryptedCount != 2 || metrics.Drops[DropAuthFailed.String()] != 2 {
+		t.Errorf("DecryptedCount, auth failures = %d, %d, want 2, 2", metrics.DecryptedCount, metrics.Drops[DropAuthFailed.String()])
+	}
+}
+
+// TestNetworkKeys tests that frames of a network with a key are only read by its members
+func TestNetworkKeys(t *testing.T) {
+	key, networkKey := generateTestKey(), generateTestKey()
+	network := NetworkID("lab")
+	networkKeys := map[uint32][symmetric.KeySize]byte{network: networkKey}
+
+	alice, err := NewEncryptionPipeline(&PipelineConfig{Key: key, NetworkKeys: networkKeys, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer alice.Stop()
+
+	bob, err := NewEncryptionPipeline(&PipelineConfig{Key: key, NetworkKeys: networkKeys, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer bob.Stop()
+
+	// Mallory holds the static key but not the network key
+	mallory, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer mallory.Stop()
+
+	alice.Start()
+	bob.Start()
+	mallory.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	// transmit moves one frame of the network between pipelines
+	transmit := func(sender, receiver *EncryptionPipeline) {
+		if !sender.SendNetworkFrame(network, createTestFrame()) {
+			t.Fatal("Failed to send frame for encryption")
+		}
+		encFrame, err := sender.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive encrypted frame: %v", err)
+		}
+		receiver.SendEncryptedFrame(encFrame)
+	}
+
+	transmit(alice, bob)
+	decrypted, err := bob.ReceiveDecryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to decrypt frame of the network: %v", err)
+	}
+	if decrypted.Network != network {
+		t.Errorf("Network = %08x, want %08x", decrypted.Network, network)
+	}
+
+	// Neither reading nor sending works without the network key
+	transmit(alice, mallory)
+	transmit(mallory, bob)
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	if _, err := mallory.ReceiveDecryptedFrame(shortCtx); err == nil {
+		t.Error("Frame of the network decrypted without its key")
+	}
+	if _, err := bob.ReceiveDecryptedFrame(shortCtx); err == nil {
+		t.Error("Frame of the network sent without its key was accepted")
+	}
+
+	// Frames of the default network still use the static key
+	if !alice.SendFrame(createTestFrame()) {
+		t.Fatal("Failed to send frame for encryption")
+	}
+	encFrame, err := alice.ReceiveEncryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive encrypted frame: %v", err)
+	}
+	mallory.SendEncryptedFrame(encFrame)
+	if _, err := mallory.ReceiveDecryptedFrame(ctx); err != nil {
+		t.Errorf("Failed to decrypt frame of the default network: %v", err)
+	}
+}
+
 // Helper functions
 
 func generateTestKey() [symmetric.KeySize]byte {
@@ -386,3 +882,197 @@ func createTestFrame() *layer2.EthernetFrame {
 		Payload:        []byte("Test payload data for encryption"),
 	}
 }
+
+// TestFragmentation tests that frames above the maximum frame size are split and rebuilt
+func TestFragmentation(t *testing.T) {
+	key := generateTestKey()
+
+	pipeline, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 20, MaxFrameSize: 500})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer pipeline.Stop()
+
+	pipeline.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	frame := createTestFrame()
+	frame.Payload = make([]byte, 1400)
+	rand.Read(frame.Payload)
+
+	if !pipeline.SendFrame(frame) {
+		t.Fatal("Failed to send frame")
+	}
+
+	// 1414 bytes of plaintext with 456 bytes of data per fragment
+	var fragments []*EncryptedEthernetFrame
+	for i := 0; i < 4; i++ {
+		encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive fragment %d: %v", i, err)
+		}
+		if encFrame.Flags&FlagFragment == 0 {
+			t.Errorf("Fragment %d missing FlagFragment", i)
+		}
+		if size := len(encFrame.Marshal()); size > 500 {
+			t.Errorf("Fragment %d is %d bytes, exceeds maximum 500", i, size)
+		}
+		fragments = append(fragments, encFrame)
+	}
+
+	// Deliver out of order
+	for _, i := range []int{2, 0, 3, 1} {
+		pipeline.SendEncryptedFrame(fragments[i])
+	}
+
+	decrypted, err := pipeline.ReceiveDecryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive reassembled frame: %v", err)
+	}
+	if !bytes.Equal(decrypted.Payload, frame.Serialize()) {
+		t.Error("Reassembled frame does not match original")
+	}
+
+	// Control frames are never fragmented
+	pipeline.SendControl(make([]byte, 800))
+	control, err := pipeline.ReceiveEncryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive control frame: %v", err)
+	}
+	if control.Flags&FlagFragment != 0 {
+		t.Error("Control frame should not be fragmented")
+	}
+
+	metrics := pipeline.GetMetrics()
+	if metrics.FragmentedCount != 1 || metrics.FragmentCount != 4 || metrics.ReassembledCount != 1 {
+		t.Errorf("Unexpected fragmentation metrics: fragmented=%d fragments=%d reassembled=%d",
+			metrics.FragmentedCount, metrics.FragmentCount, metrics.ReassembledCount)
+	}
+}
+
+// TestNetworkFrames tests that frames of a virtual network carry its authenticated ID
+func TestNetworkFrames(t *testing.T) {
+	key := generateTestKey()
+
+	pipeline, err := NewEncryptionPipeline(&PipelineConfig{Key: key, BufferSize: 20, MaxFrameSize: 500})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	defer pipeline.Stop()
+
+	pipeline.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	network := NetworkID("lab")
+	if network == DefaultNetwork || network == NetworkID("office") {
+		t.Fatalf("NetworkID(lab) = %#x", network)
+	}
+
+	frame := createTestFrame()
+	if !pipeline.SendNetworkFrame(network, frame) {
+		t.Fatal("Failed to send frame")
+	}
+	encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive encrypted frame: %v", err)
+	}
+	data := encFrame.Marshal()
+	if got, control, err := FrameNetwork(data); err != nil || got != network || control {
+		t.Errorf("FrameNetwork() = %#x, %v, %v; want %#x", got, control, err, network)
+	}
+
+	parsed, err := UnmarshalEncryptedFrame(data)
+	if err != nil {
+		t.Fatalf("UnmarshalEncryptedFrame() failed: %v", err)
+	}
+	pipeline.SendEncryptedFrame(parsed)
+	decrypted, err := pipeline.ReceiveDecryptedFrame(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive decrypted frame: %v", err)
+	}
+	if decrypted.Network != network || !bytes.Equal(decrypted.Payload, frame.Serialize()) {
+		t.Errorf("Decrypted frame in network %#x, want %#x", decrypted.Network, network)
+	}
+
+	// Moving a frame to another network breaks its authentication
+	pipeline.SendNetworkFrame(network, frame)
+	encFrame, _ = pipeline.ReceiveEncryptedFrame(ctx)
+	data = encFrame.Marshal()
+	data[13] ^= 1
+	moved, _ := UnmarshalEncryptedFrame(data)
+	pipeline.SendEncryptedFrame(moved)
+	if _, err := pipeline.ReceiveDecryptedFrame(ctx); err == nil {
+		t.Error("Frame moved to another network was accepted")
+	}
+
+	// Fragments all carry the ID and still fit
+	cancel()
+	ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	frame.Payload = make([]byte, 1400)
+	pipeline.SendNetworkFrame(network, frame)
+	for i := 0; i < 4; i++ {
+		encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive fragment %d: %v", i, err)
+		}
+		if encFrame.Network != network || encFrame.Flags&FlagFragment == 0 {
+			t.Errorf("Fragment %d in network %#x, flags %#x", i, encFrame.Network, encFrame.Flags)
+		}
+		if size := len(encFrame.Marshal()); size > 500 {
+			t.Errorf("Fragment %d is %d bytes, exceeds maximum 500", i, size)
+		}
+	}
+
+	// Control frames and frames without a network are in the default network
+	pipeline.SendFrame(createTestFrame())
+	pipeline.SendControl([]byte(`{"type":"hello"}`))
+	for _, wantControl := range []bool{false, true} {
+		encFrame, err := pipeline.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive frame: %v", err)
+		}
+		if got, control, err := FrameNetwork(encFrame.Marshal()); err != nil || got != DefaultNetwork || control != wantControl {
+			t.Errorf("FrameNetwork() = %#x, %v, %v; want the default network, control %v", got, control, err, wantControl)
+		}
+	}
+}
+
+func TestNetworkSetRelays(t *testing.T) {
+	marshal := func(flags uint8, network uint32) []byte {
+		frame := &EncryptedEthernetFrame{Flags: flags, Network: network, Frame: &symmetric.EncryptedFrame{Ciphertext: make([]byte, symmetric.TagSize+8)}}
+		return frame.Marshal()
+	}
+	lab := NetworkID("lab")
+	office := NetworkID("office")
+
+	unnamed := JoinNetworks(nil)
+	labOnly := JoinNetworks([]string{"lab"})
+	both := JoinNetworks([]string{"lab", "office"})
+
+	tests := []struct {
+		name             string
+		sender, receiver NetworkSet
+		frame            []byte
+		want             bool
+	}{
+		{"default network", unnamed, JoinNetworks(nil), marshal(0, DefaultNetwork), true},
+		{"named network", both, labOnly, marshal(FlagNetwork, lab), true},
+		{"other network", both, labOnly, marshal(FlagNetwork, office), false},
+		{"not joined by sender", labOnly, both, marshal(FlagNetwork, office), false},
+		{"untagged to named", unnamed, labOnly, marshal(0, DefaultNetwork), false},
+		{"control shared", both, labOnly, marshal(FlagControl, DefaultNetwork), true},
+		{"control not shared", unnamed, labOnly, marshal(FlagControl, DefaultNetwork), false},
+		{"unparsable", unnamed, JoinNetworks(nil), []byte{0xff}, true},
+		{"unparsable to named", unnamed, labOnly, []byte{0xff}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.receiver.Relays(tt.sender, tt.frame); got != tt.want {
+			t.Errorf("%s: Relays() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
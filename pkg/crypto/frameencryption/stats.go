@@ -0,0 +1,188 @@
+package frameencryption
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTrackedSenders bounds per-sender statistics; the least recently heard sender is forgotten first
+const maxTrackedSenders = 64
+
+// DropReason classifies frames discarded by the pipeline
+type DropReason int
+
+const (
+	// DropNoKey is a frame whose sender and key sequence have no usable receive key
+	DropNoKey DropReason = iota
+	// DropAuthFailed is a frame whose authentication tag did not verify
+	DropAuthFailed
+	// DropReassembly is a fragment that could not be added to its frame
+	DropReassembly
+	// DropDecompress is a compressed payload that failed to decompress
+	DropDecompress
+	// DropQueueFull is a frame discarded because the next pipeline stage was full
+	DropQueueFull
+	// DropFragment is an outbound frame that could not be split for the path MTU
+	DropFragment
+	// DropSourceAddress is a delivered packet whose source the sender may not use (counted by the daemon)
+	DropSourceAddress
+	// DropACL is a delivered packet the access control rules deny (counted by the daemon)
+	DropACL
+	// DropNetwork is a delivered frame of a virtual network this node has not joined (counted by the daemon)
+	DropNetwork
+
+	numDropReasons
+)
+
+// String returns the reason's name as used in metrics and the status API
+func (r DropReason) String() string {
+	switch r {
+	case DropNoKey:
+		return "no_key"
+	case DropAuthFailed:
+		return "auth_failed"
+	case DropReassembly:
+		return "reassembly"
+	case DropDecompress:
+		return "decompress"
+	case DropQueueFull:
+		return "queue_full"
+	case DropFragment:
+		return "fragment"
+	case DropSourceAddress:
+		return "source_address"
+	case DropACL:
+		return "acl"
+	case DropNetwork:
+		return "network"
+	default:
+		return "unknown"
+	}
+}
+
+// dropCounters counts drops by reason (updated atomically)
+type dropCounters [numDropReasons]uint64
+
+// snapshot returns the non-zero counters keyed by reason name
+func (d *dropCounters) snapshot() map[string]uint64 {
+	drops := make(map[string]uint64)
+	for reason := DropReason(0); reason < numDropReasons; reason++ {
+		if count := atomic.LoadUint64(&d[reason]); count > 0 {
+			drops[reason.String()] = count
+		}
+	}
+	return drops
+}
+
+// senderCounters tracks frames received from one authenticated sender
+type senderCounters struct {
+	frames      atomic.Uint64
+	bytes       atomic.Uint64
+	keySequence atomic.Uint64
+	lastFrame   atomic.Int64 // Unix nanoseconds
+	drops       dropCounters
+}
+
+// SenderStats is a snapshot of what the pipeline received from one sender
+// Only authenticated frames are attributed to a sender; frames that fail
+// authentication count towards PipelineMetrics.Drops alone.
+type SenderStats struct {
+	SenderID    uint64
+	Frames      uint64            // Authenticated frames, including fragments and control frames
+	Bytes       uint64            // Wire size of those frames
+	KeySequence uint64            // Sender's transmit key sequence seen most recently
+	LastFrame   time.Time         // When the last authenticated frame arrived
+	Drops       map[string]uint64 // Authenticated frames discarded afterwards, by DropReason name
+}
+
+// senderTable holds per-sender counters; only the decryption loop adds entries
+type senderTable struct {
+	mu      sync.RWMutex
+	senders map[uint64]*senderCounters
+}
+
+// newSenderTable creates an empty sender table
+func newSenderTable() *senderTable {
+	return &senderTable{senders: make(map[uint64]*senderCounters)}
+}
+
+// get returns the counters for senderID, adding them if needed
+func (t *senderTable) get(senderID uint64) *senderCounters {
+	t.mu.RLock()
+	counters, ok := t.senders[senderID]
+	t.mu.RUnlock()
+	if ok {
+		return counters
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.senders) >= maxTrackedSenders {
+		var oldestID uint64
+		oldest := int64(-1)
+		for id, c := range t.senders {
+			if last := c.lastFrame.Load(); oldest < 0 || last < oldest {
+				oldestID, oldest = id, last
+			}
+		}
+		delete(t.senders, oldestID)
+	}
+
+	counters = &senderCounters{}
+	t.senders[senderID] = counters
+	return counters
+}
+
+// stats returns a snapshot of one sender's counters
+func (t *senderTable) stats(senderID uint64) (SenderStats, bool) {
+	t.mu.RLock()
+	counters, ok := t.senders[senderID]
+	t.mu.RUnlock()
+	if !ok {
+		return SenderStats{}, false
+	}
+
+	stats := SenderStats{
+		SenderID:    senderID,
+		Frames:      counters.frames.Load(),
+		Bytes:       counters.bytes.Load(),
+		KeySequence: counters.keySequence.Load(),
+		Drops:       counters.drops.snapshot(),
+	}
+	if last := counters.lastFrame.Load(); last != 0 {
+		stats.LastFrame = time.Unix(0, last)
+	}
+	return stats, true
+}
+
+// received records an authenticated frame from a sender
+func (c *senderCounters) received(size int, keySequence uint64, now time.Time) {
+	c.frames.Add(1)
+	c.bytes.Add(uint64(size))
+	c.keySequence.Store(keySequence)
+	c.lastFrame.Store(now.UnixNano())
+}
+
+// drop counts a discarded frame by reason, and against its sender if it was authenticated
+func (p *EncryptionPipeline) drop(reason DropReason, sender *senderCounters) {
+	atomic.AddUint64(&p.droppedCount, 1)
+	atomic.AddUint64(&p.drops[reason], 1)
+	if sender != nil {
+		atomic.AddUint64(&sender.drops[reason], 1)
+	}
+}
+
+// RecordDrop counts a decrypted frame from senderID that was discarded after delivery
+// It lets the receiver's own checks show up in the sender's drops next to the pipeline's.
+// Thread-safe: can be called while the pipeline is running.
+func (p *EncryptionPipeline) RecordDrop(senderID uint64, reason DropReason) {
+	p.drop(reason, p.senders.get(senderID))
+}
+
+// SenderStats returns what the pipeline has received from senderID
+// Thread-safe: can be called while the pipeline is running.
+func (p *EncryptionPipeline) SenderStats(senderID uint64) (SenderStats, bool) {
+	return p.senders.stats(senderID)
+}
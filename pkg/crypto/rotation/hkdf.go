@@ -17,6 +17,10 @@ const (
 	KeySize = 32
 	// InfoPrefix is the HKDF info string prefix
 	InfoPrefix = "shadowmesh-rotation"
+	// DirectionInfoPrefix is the HKDF info string prefix for per-direction session keys
+	DirectionInfoPrefix = "shadowmesh-direction"
+	// NetworkInfoPrefix is the HKDF info string prefix for per-network base keys
+	NetworkInfoPrefix = "shadowmesh-network"
 )
 
 var (
@@ -74,6 +78,76 @@ func DeriveRotationKey(currentKey [32]byte, sequence uint64) ([32]byte, error) {
 	return newKey, nil
 }
 
+// DeriveDirectionKey derives the initial session key for one direction of traffic
+//
+// Parameters:
+// - staticKey: Pre-shared 32-byte key known to both peers
+// - senderID: Random 64-bit identifier chosen by the sender for this session
+//
+// Returns:
+// - [32]byte: Session key (rotation sequence 0) for frames sent by senderID
+// - error: Error if derivation fails
+//
+// HKDF Construction:
+// - Hash: SHA-256
+// - IKM: staticKey
+// - Salt: none
+// - Info: "shadowmesh-direction" || senderID (8 bytes big-endian)
+//
+// Every session picks a fresh senderID, so each direction of each session gets an
+// independent key and nonce counters can start from zero without colliding with
+// any other sender that shares the static key.
+func DeriveDirectionKey(staticKey [32]byte, senderID uint64) ([32]byte, error) {
+	var sessionKey [32]byte
+
+	info := make([]byte, len(DirectionInfoPrefix)+8)
+	copy(info, []byte(DirectionInfoPrefix))
+	binary.BigEndian.PutUint64(info[len(DirectionInfoPrefix):], senderID)
+
+	hkdfReader := hkdf.New(sha256.New, staticKey[:], nil, info)
+
+	if _, err := io.ReadFull(hkdfReader, sessionKey[:]); err != nil {
+		return sessionKey, fmt.Errorf("%w: failed to read from HKDF: %v", ErrKeyDerivationFailed, err)
+	}
+
+	return sessionKey, nil
+}
+
+// DeriveNetworkKey derives the base key of a virtual network's frames
+//
+// Parameters:
+// - baseKey: Key the sender's frames are otherwise derived from (static or sender key)
+// - networkKey: 32-byte secret shared by the network's members
+// - network: ID of the network
+//
+// Returns:
+// - [32]byte: Base key that direction keys of the network's frames derive from
+// - error: Error if derivation fails
+//
+// HKDF Construction:
+// - Hash: SHA-256
+// - IKM: networkKey
+// - Salt: baseKey
+// - Info: "shadowmesh-network" || network (4 bytes big-endian)
+//
+// Without networkKey the result cannot be computed, so holding the base key alone
+// neither reads nor forges the network's frames.
+func DeriveNetworkKey(baseKey, networkKey [32]byte, network uint32) ([32]byte, error) {
+	var key [32]byte
+
+	info := make([]byte, len(NetworkInfoPrefix)+4)
+	copy(info, []byte(NetworkInfoPrefix))
+	binary.BigEndian.PutUint32(info[len(NetworkInfoPrefix):], network)
+
+	hkdfReader := hkdf.New(sha256.New, networkKey[:], baseKey[:], info)
+
+	if _, err := io.ReadFull(hkdfReader, key[:]); err != nil {
+		return key, fmt.Errorf("%w: failed to read from HKDF: %v", ErrKeyDerivationFailed, err)
+	}
+
+	return key, nil
+}
+
 // DeriveMultipleKeys derives multiple rotation keys in sequence
 // Useful for testing or generating a chain of derived keys
 //
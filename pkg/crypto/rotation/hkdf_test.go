@@ -110,6 +110,56 @@ func TestDeriveRotationKeyHighSequence(t *testing.T) {
 	}
 }
 
+// TestDeriveDirectionKey tests that each sender gets an independent, deterministic key
+func TestDeriveDirectionKey(t *testing.T) {
+	var staticKey [32]byte
+	rand.Read(staticKey[:])
+
+	keyA1, err := DeriveDirectionKey(staticKey, 0xA)
+	if err != nil {
+		t.Fatalf("DeriveDirectionKey failed: %v", err)
+	}
+	keyA2, _ := DeriveDirectionKey(staticKey, 0xA)
+	keyB, _ := DeriveDirectionKey(staticKey, 0xB)
+
+	if keyA1 != keyA2 {
+		t.Error("Direction key derivation should be deterministic")
+	}
+	if keyA1 == keyB {
+		t.Error("Different senders should get different keys")
+	}
+	if keyA1 == staticKey {
+		t.Error("Direction key should differ from the static key")
+	}
+}
+
+// TestDeriveNetworkKey tests that a network's key depends on the base key, the network key and the network
+func TestDeriveNetworkKey(t *testing.T) {
+	var baseKey, networkKey, otherKey [32]byte
+	rand.Read(baseKey[:])
+	rand.Read(networkKey[:])
+	rand.Read(otherKey[:])
+
+	key1, err := DeriveNetworkKey(baseKey, networkKey, 7)
+	if err != nil {
+		t.Fatalf("DeriveNetworkKey failed: %v", err)
+	}
+	key2, _ := DeriveNetworkKey(baseKey, networkKey, 7)
+	otherNetwork, _ := DeriveNetworkKey(baseKey, networkKey, 8)
+	otherSecret, _ := DeriveNetworkKey(baseKey, otherKey, 7)
+	otherBase, _ := DeriveNetworkKey(otherKey, networkKey, 7)
+
+	if key1 != key2 {
+		t.Error("Network key derivation should be deterministic")
+	}
+	if key1 == otherNetwork || key1 == otherSecret || key1 == otherBase {
+		t.Error("Network keys should differ by network, network key and base key")
+	}
+	if key1 == baseKey || key1 == networkKey {
+		t.Error("Network key should differ from its inputs")
+	}
+}
+
 // TestDeriveMultipleKeys tests deriving a chain of keys
 func TestDeriveMultipleKeys(t *testing.T) {
 	var initialKey [32]byte
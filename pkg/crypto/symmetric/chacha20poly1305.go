@@ -19,7 +19,7 @@ const (
 
 // EncryptedFrame represents an encrypted Ethernet frame with AEAD authentication
 type EncryptedFrame struct {
-	Nonce      [NonceSize]byte // 96-bit nonce (48-bit counter + 48-bit salt)
+	Nonce      [NonceSize]byte // 96-bit nonce (48-bit counter + 48-bit key sequence)
 	Ciphertext []byte          // Encrypted payload
 	// Note: Poly1305 tag (16 bytes) is appended to Ciphertext by AEAD
 }
@@ -43,6 +43,13 @@ var (
 //
 // Performance: ~1+ Gbps on single CPU core (commodity 4 GHz hardware)
 func Encrypt(plaintext []byte, key [KeySize]byte, nonce [NonceSize]byte) (*EncryptedFrame, error) {
+	return EncryptWithAdditionalData(plaintext, nil, key, nonce)
+}
+
+// EncryptWithAdditionalData encrypts plaintext and authenticates additionalData alongside it
+// additionalData is not encrypted or included in the frame; the receiver must supply the
+// same bytes to DecryptWithAdditionalData (e.g. a cleartext frame header)
+func EncryptWithAdditionalData(plaintext, additionalData []byte, key [KeySize]byte, nonce [NonceSize]byte) (*EncryptedFrame, error) {
 	// Validate inputs
 	if len(key) != KeySize {
 		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidKeySize, len(key))
@@ -60,7 +67,7 @@ func Encrypt(plaintext []byte, key [KeySize]byte, nonce [NonceSize]byte) (*Encry
 	// Encrypt and authenticate
 	// aead.Seal appends the ciphertext and tag to dst
 	// Output format: ciphertext || 16-byte Poly1305 tag
-	ciphertext := aead.Seal(nil, nonce[:], plaintext, nil)
+	ciphertext := aead.Seal(nil, nonce[:], plaintext, additionalData)
 
 	return &EncryptedFrame{
 		Nonce:      nonce,
@@ -76,6 +83,12 @@ func Encrypt(plaintext []byte, key [KeySize]byte, nonce [NonceSize]byte) (*Encry
 // - Fails fast if tag is invalid (no partial decryption)
 // - Prevents tampering, replay, and forgery attacks
 func Decrypt(frame *EncryptedFrame, key [KeySize]byte) ([]byte, error) {
+	return DecryptWithAdditionalData(frame, nil, key)
+}
+
+// DecryptWithAdditionalData decrypts frame and verifies that additionalData matches
+// the bytes authenticated by EncryptWithAdditionalData
+func DecryptWithAdditionalData(frame *EncryptedFrame, additionalData []byte, key [KeySize]byte) ([]byte, error) {
 	// Validate inputs
 	if frame == nil {
 		return nil, fmt.Errorf("%w: frame cannot be nil", ErrDecryptionFailed)
@@ -100,7 +113,7 @@ func Decrypt(frame *EncryptedFrame, key [KeySize]byte) ([]byte, error) {
 
 	// Decrypt and verify authentication tag
 	// aead.Open validates the tag using constant-time comparison (via subtle.ConstantTimeCompare)
-	plaintext, err := aead.Open(nil, frame.Nonce[:], frame.Ciphertext, nil)
+	plaintext, err := aead.Open(nil, frame.Nonce[:], frame.Ciphertext, additionalData)
 	if err != nil {
 		// Tag validation failed - ciphertext was tampered with or wrong key
 		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
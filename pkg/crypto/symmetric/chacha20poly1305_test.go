@@ -303,6 +303,40 @@ func TestDifferentNoncesSameKey(t *testing.T) {
 	}
 }
 
+// TestAdditionalDataAuthenticated tests that additional data must match for decryption
+func TestAdditionalDataAuthenticated(t *testing.T) {
+	plaintext := []byte("Frame payload")
+	header := []byte{0x01, 0x00, 0xAA, 0xBB}
+	var key [KeySize]byte
+	var nonce [NonceSize]byte
+	rand.Read(key[:])
+	rand.Read(nonce[:])
+
+	frame, err := EncryptWithAdditionalData(plaintext, header, key, nonce)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	decrypted, err := DecryptWithAdditionalData(frame, header, key)
+	if err != nil {
+		t.Fatalf("Decryption with matching additional data failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("Decrypted plaintext mismatch")
+	}
+
+	// Tampered header must be rejected
+	tampered := []byte{0x01, 0x01, 0xAA, 0xBB}
+	if _, err := DecryptWithAdditionalData(frame, tampered, key); !isErrorType(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for tampered additional data, got %v", err)
+	}
+
+	// Missing header must be rejected
+	if _, err := Decrypt(frame, key); !isErrorType(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed without additional data, got %v", err)
+	}
+}
+
 // Benchmark: Encrypt small frame (1 KB)
 func BenchmarkEncrypt1KB(b *testing.B) {
 	plaintext := make([]byte, 1024)
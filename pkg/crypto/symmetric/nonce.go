@@ -1,127 +1,106 @@
 package symmetric
 
 import (
-	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"sync"
 	"sync/atomic"
 )
 
 const (
 	// CounterSize is 48 bits (6 bytes)
 	CounterSize = 6
-	// SaltSize is 48 bits (6 bytes)
-	SaltSize = 6
-	// MaxCounter is 2^48 - 1 (281 trillion frames before overflow)
+	// KeySequenceSize is 48 bits (6 bytes)
+	KeySequenceSize = 6
+	// MaxCounter is 2^48 - 1 (281 trillion frames per key)
 	MaxCounter uint64 = (1 << 48) - 1
+	// MaxKeySequence is 2^48 - 1 (largest key sequence that fits in a nonce)
+	MaxKeySequence uint64 = (1 << 48) - 1
 )
 
 var (
-	// ErrCounterOverflow indicates the 48-bit counter has wrapped around
-	ErrCounterOverflow = errors.New("nonce counter overflow: regenerating salt")
-	// ErrRandomGenerationFailed indicates crypto/rand failed
-	ErrRandomGenerationFailed = errors.New("failed to generate random salt")
+	// ErrNonceExhausted indicates every counter value under the current key has been used
+	// The key must be rotated before any further frames are encrypted
+	ErrNonceExhausted = errors.New("nonce counter exhausted: key must be rotated")
+	// ErrInvalidKeySequence indicates the key sequence does not fit in 48 bits
+	ErrInvalidKeySequence = errors.New("invalid key sequence: must fit in 48 bits")
 )
 
-// NonceGenerator generates unique nonces for ChaCha20-Poly1305
-// Nonce format: [6 bytes counter (big-endian)][6 bytes random salt]
+// NonceGenerator generates unique nonces for ChaCha20-Poly1305 under a single key
+// Nonce format: [6 bytes counter (big-endian)][6 bytes key sequence (big-endian)]
 //
-// Thread-safe: Uses atomic operations for counter, mutex for salt regeneration
-// Security: Counter ensures uniqueness within a session, salt provides randomness across sessions
+// Thread-safe: Uses atomic operations for the counter
+// Security: One generator belongs to exactly one key. The counter is never reset
+// and never wraps; once MaxCounter is reached every call returns ErrNonceExhausted
+// and the caller must rotate to a new key (with a new generator). The key sequence
+// is carried in the nonce so the receiver can select the matching key.
 type NonceGenerator struct {
-	counter uint64         // 48-bit atomic counter (lower 48 bits used)
-	salt    [SaltSize]byte // 48-bit random salt (regenerated on counter overflow)
-	mu      sync.Mutex     // Protects salt regeneration
+	counter     uint64 // 48-bit atomic counter (lower 48 bits used)
+	keySequence uint64 // Rotation sequence of the key this generator belongs to
 }
 
-// NewNonceGenerator creates a new nonce generator with random salt
-func NewNonceGenerator() (*NonceGenerator, error) {
-	ng := &NonceGenerator{}
-
-	// Generate initial random salt
-	if err := ng.regenerateSalt(); err != nil {
-		return nil, err
+// NewNonceGenerator creates a nonce generator for the key with the given rotation sequence
+func NewNonceGenerator(keySequence uint64) (*NonceGenerator, error) {
+	if keySequence > MaxKeySequence {
+		return nil, fmt.Errorf("%w: got %d", ErrInvalidKeySequence, keySequence)
 	}
 
-	return ng, nil
+	return &NonceGenerator{keySequence: keySequence}, nil
 }
 
 // GenerateNonce creates a unique 12-byte nonce
-// Format: 6 bytes counter (big-endian) || 6 bytes random salt
+// Format: 6 bytes counter (big-endian) || 6 bytes key sequence (big-endian)
 //
 // Thread-safe: Can be called concurrently from multiple goroutines
-// Performance: ~100 ns/op (atomic counter increment + memory copy)
+// Performance: ~15 ns/op (atomic counter increment + memory copy)
 func (ng *NonceGenerator) GenerateNonce() ([NonceSize]byte, error) {
 	var nonce [NonceSize]byte
 
 	// Atomically increment counter (uses lower 48 bits only)
 	currentCounter := atomic.AddUint64(&ng.counter, 1)
 
-	// Check for overflow (counter wrapped past 2^48)
+	// Never wrap: reusing a counter value under the same key breaks AEAD security
 	if currentCounter > MaxCounter {
-		// Regenerate salt and reset counter
-		ng.mu.Lock()
-		// Double-check after acquiring lock (another goroutine may have already reset)
-		if atomic.LoadUint64(&ng.counter) > MaxCounter {
-			if err := ng.regenerateSalt(); err != nil {
-				ng.mu.Unlock()
-				return nonce, fmt.Errorf("%w: %v", ErrCounterOverflow, err)
-			}
-			atomic.StoreUint64(&ng.counter, 1)
-			currentCounter = 1
-		} else {
-			currentCounter = atomic.LoadUint64(&ng.counter)
-		}
-		ng.mu.Unlock()
+		return nonce, ErrNonceExhausted
 	}
 
-	// Encode counter as big-endian 6 bytes (bits 47-0 of uint64)
+	// Encode counter and key sequence as big-endian 6 bytes each
 	// We use 8 bytes then truncate to 6 to handle endianness properly
-	var counterBytes [8]byte
-	binary.BigEndian.PutUint64(counterBytes[:], currentCounter)
-	copy(nonce[:CounterSize], counterBytes[2:8]) // Take lower 6 bytes
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], currentCounter)
+	copy(nonce[:CounterSize], buf[2:8])
 
-	// Copy salt (protected by happens-before relationship via atomic counter)
-	ng.mu.Lock()
-	copy(nonce[CounterSize:], ng.salt[:])
-	ng.mu.Unlock()
+	binary.BigEndian.PutUint64(buf[:], ng.keySequence)
+	copy(nonce[CounterSize:], buf[2:8])
 
 	return nonce, nil
 }
 
-// regenerateSalt generates a new random 48-bit salt
-// Must be called with ng.mu locked
-func (ng *NonceGenerator) regenerateSalt() error {
-	_, err := rand.Read(ng.salt[:])
-	if err != nil {
-		return fmt.Errorf("%w: %v", ErrRandomGenerationFailed, err)
-	}
-	return nil
-}
-
 // GetCounter returns the current counter value (for testing/debugging)
 func (ng *NonceGenerator) GetCounter() uint64 {
 	return atomic.LoadUint64(&ng.counter)
 }
 
-// GetSalt returns a copy of the current salt (for testing/debugging)
-func (ng *NonceGenerator) GetSalt() [SaltSize]byte {
-	ng.mu.Lock()
-	defer ng.mu.Unlock()
+// GetKeySequence returns the key sequence this generator belongs to
+func (ng *NonceGenerator) GetKeySequence() uint64 {
+	return ng.keySequence
+}
 
-	var salt [SaltSize]byte
-	copy(salt[:], ng.salt[:])
-	return salt
+// Exhausted reports whether every counter value under this key has been used
+func (ng *NonceGenerator) Exhausted() bool {
+	return atomic.LoadUint64(&ng.counter) >= MaxCounter
 }
 
-// Reset resets the counter to 0 and regenerates the salt
-// Useful for testing or after long-running sessions
-func (ng *NonceGenerator) Reset() error {
-	ng.mu.Lock()
-	defer ng.mu.Unlock()
+// ParseNonce extracts the counter and key sequence from a nonce
+// Used by the receiver to select the key a frame was encrypted with
+func ParseNonce(nonce [NonceSize]byte) (counter uint64, keySequence uint64) {
+	var buf [8]byte
+
+	copy(buf[2:], nonce[:CounterSize])
+	counter = binary.BigEndian.Uint64(buf[:])
+
+	copy(buf[2:], nonce[CounterSize:])
+	keySequence = binary.BigEndian.Uint64(buf[:])
 
-	atomic.StoreUint64(&ng.counter, 0)
-	return ng.regenerateSalt()
+	return counter, keySequence
 }
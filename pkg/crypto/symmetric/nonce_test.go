@@ -1,13 +1,14 @@
 package symmetric
 
 import (
+	"errors"
 	"sync"
 	"testing"
 )
 
 // TestNewNonceGenerator tests nonce generator initialization
 func TestNewNonceGenerator(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -21,23 +22,20 @@ func TestNewNonceGenerator(t *testing.T) {
 		t.Errorf("Initial counter should be 0, got %d", ng.GetCounter())
 	}
 
-	// Verify salt is non-zero (random)
-	salt := ng.GetSalt()
-	allZero := true
-	for _, b := range salt {
-		if b != 0 {
-			allZero = false
-			break
-		}
+	// Verify key sequence is recorded
+	if ng.GetKeySequence() != 0 {
+		t.Errorf("Key sequence should be 0, got %d", ng.GetKeySequence())
 	}
-	if allZero {
-		t.Error("Salt should not be all zeros")
+
+	// Key sequences wider than 48 bits cannot be encoded in the nonce
+	if _, err := NewNonceGenerator(MaxKeySequence + 1); err == nil {
+		t.Error("Expected error for key sequence exceeding 48 bits")
 	}
 }
 
 // TestGenerateNonce tests basic nonce generation
 func TestGenerateNonce(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -60,7 +58,7 @@ func TestGenerateNonce(t *testing.T) {
 
 // TestNonceUniqueness tests that nonces are unique
 func TestNonceUniqueness(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -89,7 +87,7 @@ func TestNonceUniqueness(t *testing.T) {
 
 // TestConcurrentNonceGeneration tests thread-safety
 func TestConcurrentNonceGeneration(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -140,29 +138,25 @@ func TestConcurrentNonceGeneration(t *testing.T) {
 	}
 }
 
-// TestNonceFormat tests nonce structure (counter || salt)
+// TestNonceFormat tests nonce structure (counter || key sequence)
 func TestNonceFormat(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0x0102030405)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
 
-	salt := ng.GetSalt()
-
 	// Generate two nonces
 	nonce1, _ := ng.GenerateNonce()
 	nonce2, _ := ng.GenerateNonce()
 
-	// Verify salt portion is the same (last 6 bytes)
-	for i := 0; i < SaltSize; i++ {
-		if nonce1[CounterSize+i] != salt[i] {
-			t.Errorf("Nonce1 salt mismatch at byte %d: got %x, want %x", i, nonce1[CounterSize+i], salt[i])
-		}
-		if nonce2[CounterSize+i] != salt[i] {
-			t.Errorf("Nonce2 salt mismatch at byte %d: got %x, want %x", i, nonce2[CounterSize+i], salt[i])
+	// Verify key sequence portion is the same (last 6 bytes, big-endian)
+	expectedSequence := [KeySequenceSize]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	for i := 0; i < KeySequenceSize; i++ {
+		if nonce1[CounterSize+i] != expectedSequence[i] {
+			t.Errorf("Nonce1 key sequence mismatch at byte %d: got %x, want %x", i, nonce1[CounterSize+i], expectedSequence[i])
 		}
-		if nonce1[CounterSize+i] != nonce2[CounterSize+i] {
-			t.Errorf("Salt mismatch between nonce1 and nonce2 at byte %d", i)
+		if nonce2[CounterSize+i] != expectedSequence[i] {
+			t.Errorf("Nonce2 key sequence mismatch at byte %d: got %x, want %x", i, nonce2[CounterSize+i], expectedSequence[i])
 		}
 	}
 
@@ -177,11 +171,20 @@ func TestNonceFormat(t *testing.T) {
 	if counterSame {
 		t.Error("Counter portion should be different between sequential nonces")
 	}
+
+	// Verify ParseNonce round-trips both fields
+	counter, keySequence := ParseNonce(nonce2)
+	if counter != 2 {
+		t.Errorf("Parsed counter should be 2, got %d", counter)
+	}
+	if keySequence != 0x0102030405 {
+		t.Errorf("Parsed key sequence should be 0x0102030405, got %#x", keySequence)
+	}
 }
 
 // TestCounterIncrement tests that counter increments correctly
 func TestCounterIncrement(t *testing.T) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -198,135 +201,65 @@ func TestCounterIncrement(t *testing.T) {
 	}
 }
 
-// TestReset tests nonce generator reset
-func TestReset(t *testing.T) {
-	ng, err := NewNonceGenerator()
-	if err != nil {
-		t.Fatalf("NewNonceGenerator failed: %v", err)
-	}
-
-	// Generate some nonces
-	for i := 0; i < 100; i++ {
-		_, err := ng.GenerateNonce()
-		if err != nil {
-			t.Fatalf("GenerateNonce failed: %v", err)
-		}
-	}
-
-	initialSalt := ng.GetSalt()
-
-	// Reset
-	err = ng.Reset()
-	if err != nil {
-		t.Fatalf("Reset failed: %v", err)
-	}
-
-	// Verify counter is reset
-	if ng.GetCounter() != 0 {
-		t.Errorf("Counter should be 0 after reset, got %d", ng.GetCounter())
-	}
-
-	// Verify salt changed
-	newSalt := ng.GetSalt()
-	saltChanged := false
-	for i := 0; i < SaltSize; i++ {
-		if initialSalt[i] != newSalt[i] {
-			saltChanged = true
-			break
-		}
-	}
-	if !saltChanged {
-		t.Error("Salt should change after reset")
-	}
-
-	// Generate a nonce and verify counter increments from 0
-	_, err = ng.GenerateNonce()
-	if err != nil {
-		t.Fatalf("GenerateNonce after reset failed: %v", err)
-	}
-	if ng.GetCounter() != 1 {
-		t.Errorf("Counter should be 1 after reset and one nonce, got %d", ng.GetCounter())
-	}
-}
-
-// TestDifferentGeneratorsDifferentSalts tests that different generators have different salts
-func TestDifferentGeneratorsDifferentSalts(t *testing.T) {
-	ng1, err := NewNonceGenerator()
+// TestDifferentKeySequencesDifferentNonces tests that generators for different keys never collide
+func TestDifferentKeySequencesDifferentNonces(t *testing.T) {
+	ng1, err := NewNonceGenerator(1)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator 1 failed: %v", err)
 	}
 
-	ng2, err := NewNonceGenerator()
+	ng2, err := NewNonceGenerator(2)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator 2 failed: %v", err)
 	}
 
-	salt1 := ng1.GetSalt()
-	salt2 := ng2.GetSalt()
-
-	// Salts should be different (extremely unlikely to be the same with crypto/rand)
-	if salt1 == salt2 {
-		t.Error("Different generators should have different salts (extremely unlikely collision)")
-	}
-}
-
-// TestNoncesFromDifferentGenerators tests that nonces from different generators are unique
-func TestNoncesFromDifferentGenerators(t *testing.T) {
-	ng1, _ := NewNonceGenerator()
-	ng2, _ := NewNonceGenerator()
-
 	nonce1, _ := ng1.GenerateNonce()
 	nonce2, _ := ng2.GenerateNonce()
 
-	// Nonces should be different (due to different salts)
+	// Same counter, different key sequence
 	if nonce1 == nonce2 {
-		t.Error("Nonces from different generators should be different")
+		t.Error("Nonces for different key sequences should be different")
 	}
 }
 
-// TestCounterOverflow tests counter overflow handling
+// TestCounterExhaustion tests that the counter never wraps under the same key
 // Note: This test would take too long to reach 2^48, so we test the logic manually
-func TestCounterOverflowSimulation(t *testing.T) {
-	ng, err := NewNonceGenerator()
+func TestCounterExhaustion(t *testing.T) {
+	ng, err := NewNonceGenerator(7)
 	if err != nil {
 		t.Fatalf("NewNonceGenerator failed: %v", err)
 	}
 
-	// Manually set counter near overflow
-	ng.counter = MaxCounter - 5
-
-	initialSalt := ng.GetSalt()
+	// Manually set counter near exhaustion
+	ng.counter = MaxCounter - 2
 
-	// Generate 10 nonces (should trigger overflow and salt regeneration)
-	for i := 0; i < 10; i++ {
-		_, err := ng.GenerateNonce()
-		if err != nil {
-			t.Fatalf("GenerateNonce failed at iteration %d: %v", i, err)
+	// The last two counter values are still usable
+	for i := 0; i < 2; i++ {
+		if _, err := ng.GenerateNonce(); err != nil {
+			t.Fatalf("GenerateNonce failed before exhaustion at iteration %d: %v", i, err)
 		}
 	}
 
-	// Counter should have reset
-	if ng.GetCounter() > 10 {
-		t.Logf("Counter after overflow: %d", ng.GetCounter())
+	if !ng.Exhausted() {
+		t.Error("Generator should report exhaustion after MaxCounter nonces")
 	}
 
-	// Salt should have changed after overflow
-	newSalt := ng.GetSalt()
-	saltChanged := false
-	for i := 0; i < SaltSize; i++ {
-		if initialSalt[i] != newSalt[i] {
-			saltChanged = true
-			break
+	// Every further call must fail rather than wrap around
+	for i := 0; i < 5; i++ {
+		_, err := ng.GenerateNonce()
+		if !errors.Is(err, ErrNonceExhausted) {
+			t.Fatalf("Expected ErrNonceExhausted at iteration %d, got %v", i, err)
 		}
 	}
-	if !saltChanged {
-		t.Error("Salt should change after counter overflow")
+
+	if ng.GetCounter() <= MaxCounter {
+		t.Errorf("Counter should stay past MaxCounter, got %d", ng.GetCounter())
 	}
 }
 
 // BenchmarkGenerateNonce benchmarks nonce generation
 func BenchmarkGenerateNonce(b *testing.B) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		b.Fatalf("NewNonceGenerator failed: %v", err)
 	}
@@ -343,7 +276,7 @@ func BenchmarkGenerateNonce(b *testing.B) {
 
 // BenchmarkGenerateNonceParallel benchmarks concurrent nonce generation
 func BenchmarkGenerateNonceParallel(b *testing.B) {
-	ng, err := NewNonceGenerator()
+	ng, err := NewNonceGenerator(0)
 	if err != nil {
 		b.Fatalf("NewNonceGenerator failed: %v", err)
 	}
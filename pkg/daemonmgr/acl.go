@@ -0,0 +1,151 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// aclConfig returns the rule set of the acl section
+func (c *DaemonConfig) aclConfig() acl.Config {
+	return acl.Config{
+		DefaultInbound:  c.ACL.DefaultInbound,
+		DefaultOutbound: c.ACL.DefaultOutbound,
+		Groups:          c.ACL.Groups,
+		Rules:           c.ACL.Rules,
+	}
+}
+
+// updateACL applies a changed acl section: the rules are replaced, or filtering is switched on or off
+// Connections the new rules deny are cut; the others continue.
+func (dm *DaemonManager) updateACL(next *DaemonConfig) {
+	if !next.ACL.Enabled {
+		if dm.aclEngine.Swap(nil) != nil {
+			routerLogger.Info("peer traffic no longer filtered")
+		}
+		return
+	}
+
+	if engine := dm.aclEngine.Load(); engine != nil {
+		if err := engine.Update(next.aclConfig()); err != nil {
+			routerLogger.Warn("failed to apply ACL rules, keeping the previous ones", "error", err)
+			return
+		}
+	} else {
+		engine, err := acl.NewEngine(next.aclConfig())
+		if err != nil {
+			routerLogger.Warn("failed to apply ACL rules, peer traffic stays unfiltered", "error", err)
+			return
+		}
+		dm.aclEngine.Store(engine)
+	}
+	routerLogger.Info("filtering peer traffic", "rules", len(next.ACL.Rules))
+}
+
+// aclAllows applies the ACL to a packet exchanged with a peer in network
+// packet is the payload of a frame of type etherType. Inbound packets come from
+// senderID; outbound ones go to the peer owning the destination address. Other than
+// the primary network, networks do not use tunnel addresses: there an outbound packet's
+// peer is unknown, so only rules without peer names match it. Everything passes while
+// the ACL is disabled.
+func (dm *DaemonManager) aclAllows(dir acl.Direction, network *virtualNetwork, senderID uint64, etherType uint16, packet []byte) bool {
+	engine := dm.aclEngine.Load()
+	if engine == nil {
+		return true
+	}
+	if etherType != layer2.EtherTypeIPv4 && etherType != layer2.EtherTypeIPv6 {
+		return engine.AllowEtherType(dir, etherType)
+	}
+
+	flow, ok := acl.ParsePacket(packet)
+	if !ok {
+		return engine.AllowPacket(dir, "", packet)
+	}
+
+	if network != nil {
+		flow.Network = network.ID
+	}
+
+	var peer string
+	if filter := dm.sources.Load(); filter != nil {
+		if dir == acl.Outbound && network.primary() {
+			senderID, _ = filter.owner(flow.Dst)
+		}
+		if dir == acl.Inbound || network.primary() {
+			peer = filter.names[senderID]
+		}
+	}
+	return engine.Allow(dir, peer, flow)
+}
+
+// deliveredFrame splits a payload delivered from a peer into a frame for the ACL and logs
+// In TUN mode the payload is an IP packet, in TAP mode a serialized Ethernet frame.
+func deliveredFrame(payload []byte, layer layer2.Layer) *layer2.EthernetFrame {
+	if layer == layer2.Layer3 {
+		frame := &layer2.EthernetFrame{EtherType: layer2.EtherTypeIPv4, Payload: payload}
+		if len(payload) > 0 && payload[0]>>4 == 6 {
+			frame.EtherType = layer2.EtherTypeIPv6
+		}
+		return frame
+	}
+	etherType, packet, _ := layer2.SplitFrame(payload)
+	return &layer2.EthernetFrame{EtherType: etherType, Payload: packet}
+}
+
+// GetACL returns the ACL's rule hit counters; nil while the ACL is disabled
+func (dm *DaemonManager) GetACL() *acl.Stats {
+	engine := dm.aclEngine.Load()
+	if engine == nil {
+		return nil
+	}
+	stats := engine.Stats()
+	return &stats
+}
+
+// validateACL checks the acl section, reporting problems through fail
+func (c *DaemonConfig) validateACL(fail func(field, format string, args ...interface{})) {
+	if !acl.ValidAction(c.ACL.DefaultInbound) {
+		fail("acl.default_inbound", "acl.default_inbound must be allow or deny, got %q", c.ACL.DefaultInbound)
+	}
+	if !acl.ValidAction(c.ACL.DefaultOutbound) {
+		fail("acl.default_outbound", "acl.default_outbound must be allow or deny, got %q", c.ACL.DefaultOutbound)
+	}
+	names := make([]string, 0, len(c.ACL.Groups))
+	for name := range c.ACL.Groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := acl.ValidateGroup(name, c.ACL.Groups[name]); err != nil {
+			fail("acl.groups."+name, "acl.groups.%s: %v", name, err)
+		}
+	}
+	networks := c.networkIDs()
+	for i := range c.ACL.Rules {
+		field := fmt.Sprintf("acl.rules[%d]", i)
+		if err := c.ACL.Rules[i].Validate(c.ACL.Groups); err != nil {
+			fail(field, "%s %v", field, err)
+		}
+		for _, network := range c.ACL.Rules[i].Networks {
+			if network != "" && !slices.Contains(networks, network) {
+				fail(field+".networks", "%s.networks: %s is not in networks", field, network)
+			}
+		}
+	}
+}
+
+// owner returns the peer whose tunnel address or accepted subnet contains addr, by longest prefix
+func (f *sourceFilter) owner(addr netip.Addr) (senderID uint64, ok bool) {
+	best := -1
+	for id, prefixes := range f.peers {
+		for _, prefix := range prefixes {
+			if prefix.Bits() > best && prefix.Contains(addr) {
+				senderID, best = id, prefix.Bits()
+			}
+		}
+	}
+	return senderID, best >= 0
+}
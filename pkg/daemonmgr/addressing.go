@@ -0,0 +1,100 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+)
+
+// AutoAddress as network.local_ip makes the relay assign the tunnel addresses
+// The relay allocates them from its pool (cmd/relay-server -pool) per identity.key, so
+// a node keeps its addresses across reconnects and restarts. Each connection claims
+// the lease with a signature of the key (ipam.Claim), and the IPv6 address derives
+// from the hash of its public key. The lease expires when the node has not connected
+// for the relay's lease time.
+const AutoAddress = "auto"
+
+// autoAddress reports whether the tunnel addresses are assigned by the relay
+func (c *DaemonConfig) autoAddress() bool {
+	return c.Network.LocalIP == AutoAddress
+}
+
+// staticAddress returns the configured IPv4 tunnel address for the relay to reserve; zero with local_ip: auto
+func (c *DaemonConfig) staticAddress() netip.Addr {
+	if prefix, err := netip.ParsePrefix(c.Network.LocalIP); err == nil && prefix.Addr().Is4() {
+		return prefix.Addr()
+	}
+	return netip.Addr{}
+}
+
+// tunnelPrefixes returns our tunnel addresses: the configured ones and those the relay assigned
+func (dm *DaemonManager) tunnelPrefixes() []netip.Prefix {
+	prefixes := dm.cfg().tunnelPrefixes()
+	if assigned := dm.assigned.Load(); assigned != nil {
+		prefixes = append(prefixes, *assigned...)
+	}
+	return prefixes
+}
+
+// localIP returns network.local_ip for the status API, or the assigned address in its place
+func (dm *DaemonManager) localIP() string {
+	config := dm.cfg()
+	if !config.autoAddress() {
+		return config.Network.LocalIP
+	}
+	if assigned := dm.assigned.Load(); assigned != nil {
+		return (*assigned)[0].String()
+	}
+	return AutoAddress
+}
+
+// applyAssignment configures the addresses the relay assigned on the device
+// Addresses from an earlier, different assignment are removed. An assigned IPv6
+// address is not used if network.local_ipv6 is configured. Called from dial.
+func (dm *DaemonManager) applyAssignment(assignment *ipam.Assignment) error {
+	if assignment == nil {
+		return fmt.Errorf("relay did not assign a tunnel address")
+	}
+
+	next := []netip.Prefix{assignment.Address}
+	if assignment.Address6.IsValid() && dm.cfg().Network.LocalIPv6 == "" {
+		next = append(next, assignment.Address6)
+	}
+
+	var previous []netip.Prefix
+	if assigned := dm.assigned.Load(); assigned != nil {
+		previous = *assigned
+	}
+	if slices.Equal(previous, next) {
+		return nil
+	}
+
+	if dm.tapDevice != nil {
+		for _, prefix := range previous {
+			if slices.Contains(next, prefix) {
+				continue
+			}
+			if err := dm.tapDevice.RemoveAddress(prefix); err != nil {
+				logger.Warn("failed to remove previously assigned address", "address", prefix, "error", err)
+			}
+		}
+		for _, prefix := range next {
+			if slices.Contains(previous, prefix) {
+				continue
+			}
+			if err := dm.tapDevice.AddAddress(prefix); err != nil {
+				return fmt.Errorf("failed to apply assigned address: %w", err)
+			}
+		}
+	}
+
+	dm.assigned.Store(&next)
+	logger.Info("tunnel addresses assigned by relay", "addresses", next)
+	dm.updatePeerSubnets()
+	if err := dm.startDNS(); err != nil {
+		dnsLogger.Warn("failed to resolve peer names on the assigned address", "error", err)
+	}
+	return nil
+}
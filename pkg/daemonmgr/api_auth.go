@@ -0,0 +1,177 @@
+package daemonmgr
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errPeerCredUnsupported is returned where the OS cannot report a socket peer's credentials
+var errPeerCredUnsupported = errors.New("peer credentials not supported on this platform")
+
+// peerCred identifies the process on the other end of the API socket
+type peerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// peerCredKey is the request context key for peerCredResult
+type peerCredKey struct{}
+
+// peerCredResult is what withPeerCredentials learned about a connection
+type peerCredResult struct {
+	cred peerCred
+	err  error
+}
+
+// withPeerCredentials records the connecting process's credentials for authorizeLocal
+func withPeerCredentials(ctx context.Context, conn net.Conn) context.Context {
+	cred, err := peerCredentials(conn)
+	return context.WithValue(ctx, peerCredKey{}, peerCredResult{cred: cred, err: err})
+}
+
+// authorizeLocal only lets root, the daemon's own user and members of the
+// socket group through. The socket's file mode already enforces this; the
+// SO_PEERCRED check also covers the window between bind and chmod and sockets
+// reached through a more permissive bind mount.
+func (api *DaemonAPI) authorizeLocal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, _ := r.Context().Value(peerCredKey{}).(peerCredResult)
+
+		switch {
+		case errors.Is(result.err, errPeerCredUnsupported):
+			// Fall back to the socket's file mode and group
+		case result.err != nil:
+			rejectedLog.Warn(apiLogger, "rejected local request", "path", r.URL.Path, "error", result.err)
+			api.sendJSON(w, http.StatusForbidden, ErrorResponse{Status: "error", Message: "Unable to verify client credentials"})
+			return
+		case !api.localAllowed(result.cred):
+			rejectedLog.Warn(apiLogger, "rejected local request", "path", r.URL.Path, "uid", result.cred.UID, "pid", result.cred.PID)
+			api.sendJSON(w, http.StatusForbidden, ErrorResponse{
+				Status:  "error",
+				Message: "Permission denied: run as root or join the daemon's socket group",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localAllowed reports whether a socket peer may use the API
+func (api *DaemonAPI) localAllowed(cred peerCred) bool {
+	if cred.UID == 0 || int(cred.UID) == os.Geteuid() {
+		return true
+	}
+	if api.socketGID < 0 {
+		return false
+	}
+	if int(cred.GID) == api.socketGID {
+		return true
+	}
+	return peerInGroup(cred, api.socketGID)
+}
+
+// authorizeToken requires "Authorization: Bearer <api_token>" on the TCP listener
+// Browsers cannot attach this header cross-origin without a CORS preflight, which
+// DaemonAPI never answers, so the TCP API is not reachable through CSRF.
+func (api *DaemonAPI) authorizeToken(next http.Handler) http.Handler {
+	expected := []byte(api.config.Token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+			rejectedLog.Warn(apiLogger, "rejected unauthenticated request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="shadowmesh"`)
+			api.sendJSON(w, http.StatusUnauthorized, ErrorResponse{Status: "error", Message: "Invalid or missing API token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenSocket creates the API's Unix socket with the configured mode and group
+func (api *DaemonAPI) listenSocket() (net.Listener, error) {
+	path := api.config.SocketPath
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	if api.socketGID >= 0 {
+		if err := os.Chown(path, -1, api.socketGID); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+	if err := os.Chmod(path, api.config.SocketMode.Perm()); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a daemon that did not shut down cleanly
+// It refuses to touch anything that is not a socket, or a socket another daemon still serves.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon is already listening on %s", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// lookupGroup resolves a group name or numeric GID, returning -1 for ""
+func lookupGroup(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, fmt.Errorf("socket group %q: %w", group, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return -1, fmt.Errorf("socket group %q has non-numeric gid %q", group, g.Gid)
+	}
+	return gid, nil
+}
@@ -0,0 +1,154 @@
+package daemonmgr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestAPI creates a DaemonAPI for an unstarted daemon
+func newTestAPI(t *testing.T, config APIConfig) *DaemonAPI {
+	t.Helper()
+
+	if config.SocketPath == "" {
+		config.SocketPath = filepath.Join(t.TempDir(), "daemon.sock")
+	}
+	if config.SocketMode == 0 {
+		config.SocketMode = 0660
+	}
+
+	dm, err := NewDaemonManager(&DaemonConfig{})
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+	api, err := NewDaemonAPI(config, dm)
+	if err != nil {
+		t.Fatalf("NewDaemonAPI() failed: %v", err)
+	}
+	return api
+}
+
+// TestAuthorizeToken tests that the TCP listener only serves requests with the configured bearer token
+func TestAuthorizeToken(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	api := newTestAPI(t, APIConfig{TCPAddress: "127.0.0.1:0", Token: token})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer 0123456789abcdef0124", http.StatusUnauthorized},
+		{"token prefix", "Bearer " + token[:16], http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + token, http.StatusUnauthorized},
+		{"no scheme", token, http.StatusUnauthorized},
+		{"correct", "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			api.tcpServer.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 response without WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+// TestNewDaemonAPIToken tests that the TCP API is refused without a token or with one shorter than minAPITokenLength
+func TestNewDaemonAPIToken(t *testing.T) {
+	dm, err := NewDaemonManager(&DaemonConfig{})
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "daemon.sock")
+
+	tests := []struct {
+		name    string
+		tcp     string
+		token   string
+		wantErr bool
+	}{
+		{"socket only", "", "", false},
+		{"tcp without token", "127.0.0.1:0", "", true},
+		{"short token", "127.0.0.1:0", "0123456789abcde", true},
+		{"minimum length token", "127.0.0.1:0", "0123456789abcdef", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDaemonAPI(APIConfig{SocketPath: socket, SocketMode: 0660, TCPAddress: tt.tcp, Token: tt.token}, dm)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDaemonAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLocalAllowed tests the uid and group checks applied to Unix socket clients
+func TestLocalAllowed(t *testing.T) {
+	other := uint32(os.Geteuid() + 4242)
+
+	tests := []struct {
+		name      string
+		socketGID int
+		cred      peerCred
+		want      bool
+	}{
+		{"root", -1, peerCred{UID: 0, GID: other}, true},
+		{"daemon user", -1, peerCred{UID: uint32(os.Geteuid()), GID: other}, true},
+		{"other user without socket group", -1, peerCred{UID: other, GID: other}, false},
+		{"other user in socket group", int(other) + 1, peerCred{UID: other, GID: other + 1}, true},
+		{"other user outside socket group", int(other) + 1, peerCred{UID: other, GID: other, PID: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &DaemonAPI{socketGID: tt.socketGID}
+			if got := api.localAllowed(tt.cred); got != tt.want {
+				t.Errorf("localAllowed(%+v) = %v, want %v", tt.cred, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAuthorizeLocal tests that the socket listener serves or rejects requests by their SO_PEERCRED result
+func TestAuthorizeLocal(t *testing.T) {
+	api := newTestAPI(t, APIConfig{})
+	other := uint32(os.Geteuid() + 4242)
+
+	tests := []struct {
+		name   string
+		result *peerCredResult
+		want   int
+	}{
+		{"daemon user", &peerCredResult{cred: peerCred{UID: uint32(os.Geteuid())}}, http.StatusOK},
+		{"root", &peerCredResult{cred: peerCred{UID: 0}}, http.StatusOK},
+		{"other user", &peerCredResult{cred: peerCred{UID: other, GID: other, PID: -1}}, http.StatusForbidden},
+		{"credentials error", &peerCredResult{err: errors.New("SO_PEERCRED: bad file descriptor")}, http.StatusForbidden},
+		{"unsupported platform", &peerCredResult{err: errPeerCredUnsupported}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req = req.WithContext(context.WithValue(req.Context(), peerCredKey{}, *tt.result))
+			rec := httptest.NewRecorder()
+			api.socketServer.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
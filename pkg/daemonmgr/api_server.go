@@ -4,28 +4,75 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
 )
 
+// eventsHeartbeatInterval keeps idle /events streams from being closed by proxies
+const eventsHeartbeatInterval = 15 * time.Second
+
 // DaemonAPI provides HTTP API for CLI communication
+//
+// Local clients use a Unix socket guarded by its file mode, group and the
+// connecting process's credentials. A TCP listener for remote management is
+// only opened when a bearer token is configured. Prometheus can scrape /metrics
+// from a separate unauthenticated listener that serves nothing else.
 type DaemonAPI struct {
 	manager *DaemonManager
-	server  *http.Server
-	mu      sync.RWMutex
+	config  APIConfig
+
+	socketServer  *http.Server
+	tcpServer     *http.Server
+	metricsServer *http.Server
+	socketGID     int // -1 when no socket group is configured
+
+	// Closed by Stop so /events streams end and shutdown does not wait for them
+	closing chan struct{}
+
+	wg sync.WaitGroup
+	mu sync.RWMutex
+}
+
+// APIConfig configures where and how DaemonAPI listens
+type APIConfig struct {
+	SocketPath  string      // Unix socket path (required)
+	SocketMode  os.FileMode // Permissions of the socket file
+	SocketGroup string      // Group name or GID allowed to connect (optional)
+	TCPAddress  string      // Remote management address (optional, requires Token)
+	Token       string      // Bearer token for the TCP listener
+
+	MetricsAddress string // Address serving only /metrics, without authentication (optional)
 }
 
 // NewDaemonAPI creates a new daemon API server
-func NewDaemonAPI(addr string, manager *DaemonManager) (*DaemonAPI, error) {
+func NewDaemonAPI(config APIConfig, manager *DaemonManager) (*DaemonAPI, error) {
+	if config.SocketPath == "" {
+		return nil, fmt.Errorf("API socket path is required")
+	}
+	if config.TCPAddress != "" && config.Token == "" {
+		return nil, fmt.Errorf("TCP API on %s requires an API token", config.TCPAddress)
+	}
+	if config.Token != "" && len(config.Token) < minAPITokenLength {
+		return nil, fmt.Errorf("API token must be at least %d characters", minAPITokenLength)
+	}
+
+	socketGID, err := lookupGroup(config.SocketGroup)
+	if err != nil {
+		return nil, err
+	}
+
 	api := &DaemonAPI{
-		manager: manager,
-		server: &http.Server{
-			Addr:         addr,
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
-		},
+		manager:   manager,
+		config:    config,
+		socketGID: socketGID,
+		closing:   make(chan struct{}),
 	}
 
 	// Set up HTTP routes
@@ -33,28 +80,124 @@ func NewDaemonAPI(addr string, manager *DaemonManager) (*DaemonAPI, error) {
 	mux.HandleFunc("/connect", api.handleConnect)
 	mux.HandleFunc("/disconnect", api.handleDisconnect)
 	mux.HandleFunc("/status", api.handleStatus)
+	mux.HandleFunc("/peers", api.handlePeers)
+	mux.HandleFunc("/acl", api.handleACL)
 	mux.HandleFunc("/health", api.handleHealth)
+	mux.HandleFunc("/ping", api.handlePing)
+	mux.HandleFunc("/keys/rotate", api.handleRotateKeys)
+	mux.HandleFunc("/reload", api.handleReload)
+	mux.HandleFunc("/events", api.handleEvents)
+	mux.Handle("/metrics", manager.MetricsHandler())
+
+	api.socketServer = &http.Server{
+		Handler:      api.authorizeLocal(mux),
+		ConnContext:  withPeerCredentials,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	if config.TCPAddress != "" {
+		api.tcpServer = &http.Server{
+			Addr:         config.TCPAddress,
+			Handler:      api.authorizeToken(mux),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
 
-	api.server.Handler = mux
+	if config.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", manager.MetricsHandler())
+		api.metricsServer = &http.Server{
+			Addr:         config.MetricsAddress,
+			Handler:      metricsMux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
 
 	return api, nil
 }
 
-// Start starts the HTTP API server
+// Start opens the API listeners and serves them in the background
+// Listener errors (socket in use, bad permissions) are returned before anything is served.
 func (api *DaemonAPI) Start() error {
-	log.Printf("Starting daemon API server on %s", api.server.Addr)
-	if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("API server error: %w", err)
+	socketListener, err := api.listenSocket()
+	if err != nil {
+		return err
+	}
+
+	var tcpListener, metricsListener net.Listener
+	if api.tcpServer != nil {
+		tcpListener, err = net.Listen("tcp", api.tcpServer.Addr)
+		if err != nil {
+			socketListener.Close()
+			return fmt.Errorf("failed to listen on %s: %w", api.tcpServer.Addr, err)
+		}
+	}
+	if api.metricsServer != nil {
+		metricsListener, err = net.Listen("tcp", api.metricsServer.Addr)
+		if err != nil {
+			socketListener.Close()
+			if tcpListener != nil {
+				tcpListener.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", api.metricsServer.Addr, err)
+		}
+	}
+
+	apiLogger.Info("API listening", "socket", api.config.SocketPath, "mode", fmt.Sprintf("%04o", api.config.SocketMode.Perm()))
+	api.serve(api.socketServer, socketListener)
+
+	if tcpListener != nil {
+		apiLogger.Info("token-authenticated API listening", "address", tcpListener.Addr())
+		api.serve(api.tcpServer, tcpListener)
+	}
+
+	if metricsListener != nil {
+		apiLogger.Info("serving Prometheus metrics", "url", fmt.Sprintf("http://%s/metrics", metricsListener.Addr()))
+		api.serve(api.metricsServer, metricsListener)
 	}
+
 	return nil
 }
 
-// Stop gracefully stops the API server
+// serve runs server on listener until Stop
+func (api *DaemonAPI) serve(server *http.Server, listener net.Listener) {
+	api.wg.Add(1)
+	go func() {
+		defer api.wg.Done()
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			apiLogger.Error("API server failed", "address", listener.Addr(), "error", err)
+		}
+	}()
+}
+
+// Stop gracefully stops the API server and removes its socket
 func (api *DaemonAPI) Stop() error {
-	log.Println("Stopping daemon API server")
+	apiLogger.Debug("stopping API server")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return api.server.Shutdown(ctx)
+
+	close(api.closing)
+	err := api.socketServer.Shutdown(ctx)
+	for _, server := range []*http.Server{api.tcpServer, api.metricsServer} {
+		if server == nil {
+			continue
+		}
+		if serverErr := server.Shutdown(ctx); err == nil {
+			err = serverErr
+		}
+	}
+
+	api.wg.Wait()
+	return err
+}
+
+// ErrorResponse is returned when a request is rejected before reaching a handler
+type ErrorResponse struct {
+	Status  string `json:"status"`  // Always "error"
+	Message string `json:"message"` // Human-readable message
 }
 
 // ConnectRequest represents a connect request from CLI
@@ -79,9 +222,22 @@ type DisconnectResponse struct {
 
 // StatusResponse represents the daemon status
 type StatusResponse struct {
-	Status      string                 `json:"status"`  // "success" or "error"
-	DaemonState string                 `json:"state"`   // "connected", "disconnected", etc.
-	Details     map[string]interface{} `json:"details"` // Detailed status from manager
+	Status      string       `json:"status"`  // "success" or "error"
+	DaemonState string       `json:"state"`   // "connected", "disconnected", etc.
+	Details     DaemonStatus `json:"details"` // Detailed status from manager
+}
+
+// PeersResponse lists the daemon's peers
+type PeersResponse struct {
+	Status string       `json:"status"` // "success" or "error"
+	Peers  []PeerStatus `json:"peers"`
+}
+
+// ACLResponse reports the ACL's rule hit counters
+type ACLResponse struct {
+	Status  string     `json:"status"`        // "success" or "error"
+	Enabled bool       `json:"enabled"`       // acl.enabled
+	ACL     *acl.Stats `json:"acl,omitempty"` // Omitted while the ACL is disabled
 }
 
 // HealthResponse represents the daemon health check
@@ -90,6 +246,32 @@ type HealthResponse struct {
 	Message string `json:"message"` // Health message
 }
 
+// PingRequest represents a ping request from CLI
+type PingRequest struct {
+	TimeoutMillis int `json:"timeout_ms"` // How long to wait for the reply (default: 2000)
+}
+
+// PingResponse represents the round trip measured through the tunnel
+type PingResponse struct {
+	Status    string  `json:"status"`  // "success" or "error"
+	Message   string  `json:"message"` // Human-readable message
+	RTTMillis float64 `json:"rtt_ms"`  // Round-trip time over the encrypted control channel
+}
+
+// RotateKeysResponse represents the response to a key rotation request
+type RotateKeysResponse struct {
+	Status      string `json:"status"`       // "success" or "error"
+	Message     string `json:"message"`      // Human-readable message
+	KeySequence uint64 `json:"key_sequence"` // Sequence of the key being replaced
+}
+
+// ReloadResponse represents the response to a configuration reload
+type ReloadResponse struct {
+	Status  string `json:"status"`  // "success" or "error"
+	Message string `json:"message"` // Human-readable message
+	ReloadResult
+}
+
 // handleConnect handles /connect endpoint
 func (api *DaemonAPI) handleConnect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -107,13 +289,14 @@ func (api *DaemonAPI) handleConnect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if relay mode is requested (via API or config)
-	useRelay := req.UseRelay || api.manager.config.Relay.Enabled
+	config := api.manager.cfg()
+	useRelay := req.UseRelay || config.Relay.Enabled
 
 	if useRelay {
 		// Relay mode
 		relayServer := req.RelayServer
 		if relayServer == "" {
-			relayServer = api.manager.config.Relay.Server
+			relayServer = config.Relay.Server
 		}
 		if relayServer == "" {
 			api.sendJSON(w, http.StatusBadRequest, ConnectResponse{
@@ -125,22 +308,17 @@ func (api *DaemonAPI) handleConnect(w http.ResponseWriter, r *http.Request) {
 
 		peerID := req.PeerID
 		if peerID == "" {
-			peerID = api.manager.config.Peer.ID
+			peerID = config.Peer.ID
 		}
 		if peerID == "" {
 			// Generate random peer ID
 			peerID = fmt.Sprintf("peer-%d", time.Now().UnixNano())
 		}
 
-		log.Printf("Connecting via relay server: %s (peer ID: %s)", relayServer, peerID)
-
-		// Temporarily set config for relay connection
-		api.manager.config.Relay.Enabled = true
-		api.manager.config.Relay.Server = relayServer
-		api.manager.config.Peer.ID = peerID
+		apiLogger.Info("connect requested via relay", "relay", relayServer, "peer_id", peerID)
 
 		// Connect to relay server
-		if err := api.manager.Connect(""); err != nil {
+		if err := api.manager.ConnectRelay(relayServer, peerID); err != nil {
 			api.sendJSON(w, http.StatusInternalServerError, ConnectResponse{
 				Status:  "error",
 				Message: fmt.Sprintf("Relay connection failed: %v", err),
@@ -155,7 +333,10 @@ func (api *DaemonAPI) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Direct P2P mode requires peer_address
+	// Direct P2P mode requires peer_address, from the request or the configuration
+	if req.PeerAddress == "" {
+		req.PeerAddress = config.Peer.Address
+	}
 	if req.PeerAddress == "" {
 		api.sendJSON(w, http.StatusBadRequest, ConnectResponse{
 			Status:  "error",
@@ -212,11 +393,39 @@ func (api *DaemonAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
 
 	api.sendJSON(w, http.StatusOK, StatusResponse{
 		Status:      "success",
-		DaemonState: status["state"].(string),
+		DaemonState: status.State,
 		Details:     status,
 	})
 }
 
+// handleACL handles /acl endpoint
+func (api *DaemonAPI) handleACL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := api.manager.GetACL()
+	api.sendJSON(w, http.StatusOK, ACLResponse{
+		Status:  "success",
+		Enabled: stats != nil,
+		ACL:     stats,
+	})
+}
+
+// handlePeers handles /peers endpoint
+func (api *DaemonAPI) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.sendJSON(w, http.StatusOK, PeersResponse{
+		Status: "success",
+		Peers:  api.manager.GetPeers(),
+	})
+}
+
 // handleHealth handles /health endpoint
 func (api *DaemonAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -230,11 +439,177 @@ func (api *DaemonAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handlePing handles /ping endpoint
+func (api *DaemonAPI) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PingRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.sendJSON(w, http.StatusBadRequest, PingResponse{
+				Status:  "error",
+				Message: fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+	}
+
+	timeout := 2 * time.Second
+	if req.TimeoutMillis > 0 {
+		timeout = time.Duration(req.TimeoutMillis) * time.Millisecond
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	rtt, err := api.manager.Ping(ctx)
+	if err != nil {
+		api.sendJSON(w, http.StatusServiceUnavailable, PingResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Ping failed: %v", err),
+		})
+		return
+	}
+
+	api.sendJSON(w, http.StatusOK, PingResponse{
+		Status:    "success",
+		Message:   fmt.Sprintf("Reply from peer in %v", rtt.Round(time.Microsecond)),
+		RTTMillis: float64(rtt) / float64(time.Millisecond),
+	})
+}
+
+// handleRotateKeys handles /keys/rotate endpoint
+func (api *DaemonAPI) handleRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sequence, err := api.manager.RotateKeys()
+	if err != nil {
+		api.sendJSON(w, http.StatusInternalServerError, RotateKeysResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Key rotation failed: %v", err),
+		})
+		return
+	}
+
+	api.sendJSON(w, http.StatusOK, RotateKeysResponse{
+		Status:      "success",
+		Message:     fmt.Sprintf("Session key rotation requested (replacing sequence %d)", sequence),
+		KeySequence: sequence,
+	})
+}
+
+// handleReload handles /reload endpoint
+func (api *DaemonAPI) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := api.manager.Reload()
+	if err != nil {
+		api.sendJSON(w, http.StatusUnprocessableEntity, ReloadResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Configuration not reloaded: %v", err),
+		})
+		return
+	}
+
+	api.sendJSON(w, http.StatusOK, ReloadResponse{
+		Status:       "success",
+		Message:      fmt.Sprintf("Configuration reloaded: %s", result),
+		ReloadResult: *result,
+	})
+}
+
+// handleEvents handles /events, streaming daemon events as Server-Sent Events
+// Clients resume with the Last-Event-ID header; ?types=a,b limits the stream to those event types.
+func (api *DaemonAPI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			api.sendJSON(w, http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid Last-Event-ID"})
+			return
+		}
+		lastID = id
+	}
+
+	var types map[EventType]bool
+	if filter := r.URL.Query().Get("types"); filter != "" {
+		types = make(map[EventType]bool)
+		for _, name := range strings.Split(filter, ",") {
+			types[EventType(strings.TrimSpace(name))] = true
+		}
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		apiLogger.Warn("cannot stream events", "error", err)
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := api.manager.SubscribeEvents(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-api.closing:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// Too far behind; the client reconnects with Last-Event-ID
+				return
+			}
+			if types != nil && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				apiLogger.Warn("failed to encode event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
 // sendJSON sends a JSON response
 func (api *DaemonAPI) sendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		log.Printf("⚠️  Error encoding JSON response: %v", err)
+		apiLogger.Debug("failed to write JSON response", "error", err)
 	}
 }
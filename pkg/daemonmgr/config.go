@@ -0,0 +1,646 @@
+package daemonmgr
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/fec"
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultConfigPath is where packaged installs keep the daemon configuration
+	DefaultConfigPath = "/etc/shadowmesh/daemon.yaml"
+
+	// DefaultSocketPath is the Unix socket the daemon API listens on
+	DefaultSocketPath = "/run/shadowmesh/daemon.sock"
+
+	// defaultSocketMode lets the owner and the socket group connect
+	defaultSocketMode = 0660
+
+	// minAPITokenLength rejects tokens short enough to guess
+	minAPITokenLength = 16
+
+	// minRotationInterval keeps scheduled rotations from flooding the peer with key changes
+	minRotationInterval = time.Minute
+
+	// maxInterfaceNameLength is IFNAMSIZ without the terminating NUL
+	maxInterfaceNameLength = 15
+
+	// Device MTU and tunnel datagram bounds (576 is the smallest MTU every IPv4 host accepts)
+	minMTU          = 576
+	maxMTU          = 65535
+	maxDatagramSize = 65507 // Largest UDP payload over IPv4
+)
+
+// ConfigError is one problem at one place in a configuration file
+type ConfigError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`   // 1-based; 0 when the field is not in the file
+	Column  int    `json:"column,omitempty"` // 1-based; 0 when unknown
+	Field   string `json:"field,omitempty"`  // YAML path, e.g. "p2p.listener_port"
+	Message string `json:"message"`          // Starts with the field name where there is one
+}
+
+// Error formats the problem as "file:line:column: message"
+func (e *ConfigError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			location += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	if location == "" {
+		return e.Message
+	}
+	return location + ": " + e.Message
+}
+
+// ConfigErrors is every problem found in a configuration file, in file order where known
+type ConfigErrors []*ConfigError
+
+// Error lists the problems one per line
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// position is where a field's value appears in the configuration file
+type position struct {
+	line, column int
+	source       string // Environment variable or secret file that overrode the file's value
+}
+
+// LoadConfig reads a daemon configuration file and applies overrides and defaults
+// Decoding is strict: unknown fields and values of the wrong type are reported as
+// ConfigErrors with their line and column. SHADOWMESH_* environment variables then
+// override the file, and secrets are read from their *_file settings or systemd
+// credentials. Call Validate for the semantic checks.
+func LoadConfig(path string) (*DaemonConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	config, err := parseConfig(data, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(config); err != nil {
+		return nil, err
+	}
+	if err := loadSecrets(config); err != nil {
+		return nil, err
+	}
+
+	// Set defaults
+	if config.Daemon.Socket == "" {
+		config.Daemon.Socket = DefaultSocketPath
+	}
+	if config.Daemon.LogLevel == "" {
+		config.Daemon.LogLevel = "info"
+	}
+	if config.Network.TAPDevice == "" {
+		config.Network.TAPDevice = "tap0"
+	}
+	if config.NAT.STUNServer == "" {
+		config.NAT.STUNServer = "stun.l.google.com:19302"
+	}
+
+	return config, nil
+}
+
+// yamlLinePattern finds the line number in yaml.v3 syntax errors
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parseConfig decodes YAML strictly into a DaemonConfig, recording where each field was set
+func parseConfig(data []byte, path string) (*DaemonConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		configErr := &ConfigError{File: path, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if match := yamlLinePattern.FindStringSubmatch(err.Error()); match != nil {
+			configErr.Line, _ = strconv.Atoi(match[1])
+			configErr.Message = strings.TrimPrefix(err.Error(), match[0])
+		}
+		return nil, ConfigErrors{configErr}
+	}
+
+	config := &DaemonConfig{path: path, positions: make(map[string]position)}
+	var errs ConfigErrors
+	if len(doc.Content) > 0 {
+		decodeStrict(doc.Content[0], reflect.ValueOf(config).Elem(), "", config, &errs)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// decodeStrict decodes node into v, reporting unknown, duplicate and mistyped fields
+func decodeStrict(node *yaml.Node, v reflect.Value, prefix string, config *DaemonConfig, errs *ConfigErrors) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	// Lists of sections, such as acl.rules, are decoded item by item as strictly
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct && node.Kind == yaml.SequenceNode {
+		items := reflect.MakeSlice(v.Type(), len(node.Content), len(node.Content))
+		for i, item := range node.Content {
+			path := fmt.Sprintf("%s[%d]", prefix, i)
+			config.positions[path] = position{line: item.Line, column: item.Column}
+			decodeStrict(item, items.Index(i), path, config, errs)
+		}
+		v.Set(items)
+		return
+	}
+
+	if v.Kind() != reflect.Struct {
+		if err := node.Decode(v.Addr().Interface()); err != nil {
+			*errs = append(*errs, &ConfigError{
+				File:    config.path,
+				Line:    node.Line,
+				Column:  node.Column,
+				Field:   prefix,
+				Message: fmt.Sprintf("%s must be %s, got %s", prefix, describeType(v.Type()), describeNode(node)),
+			})
+		}
+		return
+	}
+
+	// An empty section ("nat:") leaves the defaults in place
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		name := prefix
+		if name == "" {
+			name = "the configuration"
+		}
+		*errs = append(*errs, &ConfigError{
+			File:    config.path,
+			Line:    node.Line,
+			Column:  node.Column,
+			Field:   prefix,
+			Message: fmt.Sprintf("%s must be a mapping of fields, got %s", name, describeNode(node)),
+		})
+		return
+	}
+
+	fields := make(map[string]int)
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if field.IsExported() && name != "" && name != "-" {
+			fields[name] = i
+			names = append(names, name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		index, ok := fields[key.Value]
+		if !ok {
+			message := fmt.Sprintf("unknown field %s", path)
+			if suggestion := closestName(key.Value, names); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+			}
+			*errs = append(*errs, &ConfigError{File: config.path, Line: key.Line, Column: key.Column, Field: path, Message: message})
+			continue
+		}
+		if seen[key.Value] {
+			*errs = append(*errs, &ConfigError{File: config.path, Line: key.Line, Column: key.Column, Field: path, Message: fmt.Sprintf("%s is set more than once", path)})
+			continue
+		}
+		seen[key.Value] = true
+
+		config.positions[path] = position{line: value.Line, column: value.Column}
+		decodeStrict(value, v.Field(index), path, config, errs)
+	}
+}
+
+// describeType names the YAML value a Go type expects, for type errors
+func describeType(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return `a duration like "30s" or "1h"`
+	case t.Kind() == reflect.Bool:
+		return "true or false"
+	case t.Kind() == reflect.Int:
+		return "a whole number"
+	case t.Kind() == reflect.String:
+		return "a string"
+	case t.Kind() == reflect.Slice:
+		return "a list of " + strings.TrimPrefix(describeType(t.Elem()), "a ") + "s"
+	case t.Kind() == reflect.Struct:
+		return "a mapping"
+	}
+	return t.String()
+}
+
+// describeNode summarises a YAML value for type errors
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return strconv.Quote(node.Value)
+}
+
+// closestName returns the candidate a typo of name most likely meant, if any
+// At most two edits are allowed, and no more than one per three characters.
+func closestName(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance && d*3 <= len(name) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// Validate checks that the configuration can start a daemon
+// Every problem is reported, as ConfigErrors pointing at the offending field.
+func (c *DaemonConfig) Validate() error {
+	var errs ConfigErrors
+	fail := func(field, format string, args ...interface{}) {
+		configErr := &ConfigError{File: c.path, Field: field, Message: fmt.Sprintf(format, args...)}
+		if pos, ok := c.positions[field]; ok {
+			configErr.Line, configErr.Column = pos.line, pos.column
+			if pos.source != "" {
+				configErr.File = pos.source
+			}
+		}
+		errs = append(errs, configErr)
+	}
+	checkAddress := func(field, address string) {
+		if address == "" {
+			return
+		}
+		if err := validateHostPort(address); err != nil {
+			fail(field, "%s must be host:port: %v", field, err)
+		}
+	}
+
+	// daemon
+	switch c.Daemon.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		fail("daemon.log_level", "daemon.log_level must be debug, info, warn or error, got %q", c.Daemon.LogLevel)
+	}
+	switch c.Daemon.LogFormat {
+	case "", "text", "json":
+	default:
+		fail("daemon.log_format", "daemon.log_format must be text or json, got %q", c.Daemon.LogFormat)
+	}
+	components := logging.Components()
+	for _, component := range sortedKeys(c.Daemon.LogLevels) {
+		if !containsString(components, component) {
+			message := fmt.Sprintf("daemon.log_levels: unknown component %s", component)
+			if suggestion := closestName(component, components); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+			} else {
+				message += fmt.Sprintf(" (components: %s)", strings.Join(components, ", "))
+			}
+			fail("daemon.log_levels", "%s", message)
+		} else if _, err := logging.ParseLevel(c.Daemon.LogLevels[component]); err != nil {
+			fail("daemon.log_levels", "daemon.log_levels.%s must be debug, info, warn or error, got %q", component, c.Daemon.LogLevels[component])
+		}
+	}
+	if _, err := c.socketMode(); err != nil {
+		fail("daemon.socket_mode", "%v", err)
+	}
+	if c.Daemon.APIToken != "" && len(c.Daemon.APIToken) < minAPITokenLength {
+		fail("daemon.api_token", "daemon.api_token must be at least %d characters", minAPITokenLength)
+	}
+	checkAddress("daemon.listen_address", c.Daemon.ListenAddress)
+
+	// network
+	switch c.Network.Mode {
+	case "", "tap", "tun":
+	default:
+		fail("network.mode", "network.mode must be tap or tun, got %q", c.Network.Mode)
+	}
+	if c.Network.LocalIP == "" {
+		fail("network.local_ip", "network.local_ip is required")
+	} else if c.autoAddress() {
+		if !c.Relay.Enabled {
+			fail("network.local_ip", "network.local_ip: auto requires relay.enabled, addresses are assigned by the relay")
+		}
+		if c.Identity.Key == "" {
+			fail("identity.key", "identity.key is required with network.local_ip: auto, the relay keeps the assigned address per identity key")
+		}
+	} else if _, _, err := net.ParseCIDR(c.Network.LocalIP); err != nil {
+		fail("network.local_ip", "network.local_ip must be an address with a prefix length like 10.0.0.1/24, got %q", c.Network.LocalIP)
+	}
+	if c.Network.LocalIPv6 != "" {
+		if prefix, err := netip.ParsePrefix(c.Network.LocalIPv6); err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+			fail("network.local_ipv6", "network.local_ipv6 must be an IPv6 address with a prefix length like fd00:5d::1/64, got %q", c.Network.LocalIPv6)
+		}
+	}
+	for _, field := range []string{"network.routes", "network.advertise_subnets", "network.accept_subnets"} {
+		subnets := c.Network.Routes
+		switch field {
+		case "network.advertise_subnets":
+			subnets = c.Network.AdvertiseSubnets
+		case "network.accept_subnets":
+			subnets = c.Network.AcceptSubnets
+		}
+		for i, subnet := range subnets {
+			if _, err := netip.ParsePrefix(subnet); err != nil {
+				fail(field, "%s[%d] must be a subnet like 192.168.1.0/24, got %q", field, i, subnet)
+			}
+		}
+	}
+	for _, name := range sortedKeys(c.Network.PeerSubnets) {
+		if _, ok := c.Identity.Peers[name]; !ok {
+			fail("network.peer_subnets", "network.peer_subnets: peer %s is not in identity.peers, its name cannot be verified", name)
+		}
+		for i, subnet := range c.Network.PeerSubnets[name] {
+			if _, err := netip.ParsePrefix(subnet); err != nil {
+				fail("network.peer_subnets", "network.peer_subnets.%s[%d] must be a subnet like 192.168.1.0/24, got %q", name, i, subnet)
+			}
+		}
+	}
+	for _, field := range []string{"network.tap_device", "network.device_name"} {
+		name := c.Network.TAPDevice
+		if field == "network.device_name" {
+			name = c.Network.DeviceName
+		}
+		if len(name) > maxInterfaceNameLength || strings.ContainsAny(name, "/ \t") {
+			fail(field, "%s must be an interface name of at most %d characters without spaces or slashes", field, maxInterfaceNameLength)
+		}
+	}
+	if mtu := c.Network.MTU; mtu != 0 && (mtu < minMTU || mtu > maxMTU) {
+		fail("network.mtu", "network.mtu must be 0 (automatic) or between %d and %d", minMTU, maxMTU)
+	}
+	if c.Network.ProxyNeighbors && c.Network.Mode == "tun" {
+		fail("network.proxy_neighbors", "network.proxy_neighbors needs network.mode tap, a TUN device has no ARP or neighbour discovery")
+	}
+	if c.Network.BroadcastLimit < 0 {
+		fail("network.broadcast_limit", "network.broadcast_limit must be 0 (no limit) or a number of frames per second, got %d", c.Network.BroadcastLimit)
+	}
+	if size := c.PathMTU.MaxSize; size != 0 && (size < minMTU || size > maxDatagramSize) {
+		fail("path_mtu.max_size", "path_mtu.max_size must be 0 (default) or between %d and %d", minMTU, maxDatagramSize)
+	}
+
+	// exit_node
+	if c.ExitNode.Advertise && c.ExitNode.Use {
+		fail("exit_node.use", "exit_node.use and exit_node.advertise exclude each other; a node is either an exit or routes through one")
+	}
+	if name := c.ExitNode.Interface; len(name) > maxInterfaceNameLength || strings.ContainsAny(name, "/ \t") {
+		fail("exit_node.interface", "exit_node.interface must be an interface name of at most %d characters without spaces or slashes", maxInterfaceNameLength)
+	}
+	if c.ExitNode.Interface != "" && !c.ExitNode.Advertise {
+		fail("exit_node.interface", "exit_node.interface is only used with exit_node.advertise")
+	}
+	if c.ExitNode.AllowLAN && !c.ExitNode.Advertise {
+		fail("exit_node.allow_lan", "exit_node.allow_lan is only used with exit_node.advertise")
+	}
+	if c.ExitNode.Use && c.ExitNode.Peer == "" {
+		fail("exit_node.peer", "exit_node.peer is required with exit_node.use, internet traffic only goes to the peer named there")
+	} else if c.ExitNode.Peer != "" {
+		if !c.ExitNode.Use {
+			fail("exit_node.peer", "exit_node.peer is only used with exit_node.use")
+		} else if _, ok := c.Identity.Peers[c.ExitNode.Peer]; !ok {
+			fail("exit_node.peer", "exit_node.peer: peer %s is not in identity.peers, its name cannot be verified", c.ExitNode.Peer)
+		}
+	}
+	for i, server := range c.ExitNode.DNS {
+		if _, err := netip.ParseAddr(server); err != nil {
+			fail("exit_node.dns", "exit_node.dns[%d] must be an IP address, got %q", i, server)
+		}
+	}
+	if len(c.ExitNode.DNS) > 0 && !c.ExitNode.Use {
+		fail("exit_node.dns", "exit_node.dns is only used with exit_node.use")
+	}
+	if c.ExitNode.KillSwitch && !c.ExitNode.Use {
+		fail("exit_node.kill_switch", "exit_node.kill_switch requires exit_node.use")
+	}
+
+	// dns
+	if c.DNS.Domain != "" && !magicdns.ValidDomain(c.DNS.Domain) {
+		fail("dns.domain", "dns.domain must be a domain name like mesh.internal, got %q", c.DNS.Domain)
+	}
+	if c.DNS.Name != "" && magicdns.Label(c.DNS.Name) == "" {
+		fail("dns.name", "dns.name must contain letters or digits, got %q", c.DNS.Name)
+	}
+	for i, address := range c.DNS.Listen {
+		if _, err := netip.ParseAddrPort(address); err != nil {
+			fail("dns.listen", "dns.listen[%d] must be an IP address with a port like 10.0.0.1:53, got %q", i, address)
+		}
+	}
+	for i, server := range c.DNS.Upstream {
+		if _, err := parseUpstream(server); err != nil {
+			fail("dns.upstream", "dns.upstream[%d] %v", i, err)
+		}
+	}
+
+	// acl
+	c.validateACL(fail)
+
+	// networks
+	c.validateNetworks(fail)
+
+	// encryption
+	if c.Encryption.Key == "" {
+		fail("encryption.key", "encryption.key is required (or encryption.key_file, %s, or a systemd credential)", EnvVarName("encryption.key"))
+	} else if key, err := hex.DecodeString(c.Encryption.Key); err != nil || len(key) != 32 {
+		fail("encryption.key", "encryption.key must be 64 hex characters (32 bytes)")
+	}
+	if interval := c.Encryption.RotationInterval; interval < 0 || (interval > 0 && interval < minRotationInterval) {
+		fail("encryption.rotation_interval", "encryption.rotation_interval must be 0 (off) or at least %v", minRotationInterval)
+	}
+
+	// identity
+	c.validateIdentity(fail)
+
+	// peer and relay
+	checkAddress("peer.address", c.Peer.Address)
+	if c.Relay.Server != "" {
+		if err := validateRelayURL(c.Relay.Server); err != nil {
+			fail("relay.server", "relay.server %v", err)
+		}
+	}
+	if c.Relay.Enabled && c.Relay.Server == "" {
+		fail("relay.server", "relay.server is required when relay.enabled is true")
+	}
+	if c.Relay.Enabled && c.Peer.Address != "" {
+		fail("peer.address", "peer.address is not used when relay.enabled is true; remove one of them")
+	}
+
+	// reconnect
+	if c.Reconnect.InitialBackoff < 0 {
+		fail("reconnect.initial_backoff", "reconnect.initial_backoff must not be negative")
+	}
+	if c.Reconnect.MaxBackoff < 0 {
+		fail("reconnect.max_backoff", "reconnect.max_backoff must not be negative")
+	} else if initial, _ := c.reconnectBackoff(); c.Reconnect.MaxBackoff != 0 && c.Reconnect.MaxBackoff < initial {
+		fail("reconnect.max_backoff", "reconnect.max_backoff must not be below reconnect.initial_backoff (%v)", initial)
+	}
+	if c.Reconnect.MaxAttempts < 0 {
+		fail("reconnect.max_attempts", "reconnect.max_attempts must be 0 (retry forever) or more")
+	}
+
+	// nat and p2p
+	if c.NAT.Enabled {
+		checkAddress("nat.stun_server", c.NAT.STUNServer)
+	}
+	if port := c.P2P.ListenerPort; port < 0 || port > 65535 {
+		fail("p2p.listener_port", "p2p.listener_port must be between 1 and 65535 (0 for the default)")
+	}
+
+	// fec
+	if shards := c.FEC.DataShards; shards < 0 || shards > fec.MaxDataShards {
+		fail("fec.data_shards", "fec.data_shards must be between 1 and %d (0 for the default)", fec.MaxDataShards)
+	}
+	if shards := c.FEC.ParityShards; shards < 0 || shards > fec.MaxParityShards {
+		fail("fec.parity_shards", "fec.parity_shards must be between 1 and %d (0 for the default)", fec.MaxParityShards)
+	}
+	if shards := c.FEC.MaxParityShards; shards < 0 || shards > fec.MaxParityShards {
+		fail("fec.max_parity_shards", "fec.max_parity_shards must be between 1 and %d (0 for the default)", fec.MaxParityShards)
+	} else if shards != 0 && shards < c.FEC.ParityShards {
+		fail("fec.max_parity_shards", "fec.max_parity_shards must not be below fec.parity_shards (%d)", c.FEC.ParityShards)
+	}
+
+	// multipath
+	if _, err := multipath.ParseMode(c.Multipath.Mode); err != nil {
+		fail("multipath.mode", "multipath.mode: %v", err)
+	}
+	interfaces := make(map[string]bool)
+	for _, name := range c.Multipath.Interfaces {
+		if name == "" || interfaces[name] {
+			fail("multipath.interfaces", "multipath.interfaces must list each interface once, got %q", c.Multipath.Interfaces)
+			break
+		}
+		interfaces[name] = true
+	}
+
+	// metrics
+	checkAddress("metrics.listen_address", c.Metrics.ListenAddress)
+	if c.Metrics.ListenAddress != "" && c.Metrics.ListenAddress == c.Daemon.ListenAddress {
+		fail("metrics.listen_address", "metrics.listen_address must differ from daemon.listen_address")
+	}
+
+	if len(errs) > 0 {
+		// File order; fields missing from the file go last
+		sort.SliceStable(errs, func(i, j int) bool {
+			a, b := errs[i], errs[j]
+			if (a.Line == 0) != (b.Line == 0) {
+				return b.Line == 0
+			}
+			return a.Line < b.Line
+		})
+		return errs
+	}
+	return nil
+}
+
+// validateHostPort checks a "host:port" address with a numeric port
+func validateHostPort(address string) error {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port, err := strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	return nil
+}
+
+// validateRelayURL checks a relay server URL such as ws://relay.example.com:9545
+func validateRelayURL(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("must be a URL: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("must be a ws:// or wss:// URL, got %q", server)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("must include a host, got %q", server)
+	}
+	return nil
+}
+
+// socketMode parses daemon.socket_mode, e.g. "0660"
+func (c *DaemonConfig) socketMode() (os.FileMode, error) {
+	if c.Daemon.SocketMode == "" {
+		return defaultSocketMode, nil
+	}
+
+	mode, err := strconv.ParseUint(c.Daemon.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("daemon.socket_mode must be an octal file mode like \"0660\"")
+	}
+	if mode&0007 != 0 {
+		return 0, fmt.Errorf("daemon.socket_mode %s gives every local user access; use a socket_group instead", c.Daemon.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// sortedKeys returns a map's keys in order, for reporting problems deterministically
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package daemonmgr
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfigKey is a valid encryption.key for test configurations
+var testConfigKey = strings.Repeat("ab", 32)
+
+// keySection is an encryption section with testConfigKey, to append to test configurations
+var keySection = "encryption:\n  key: \"" + testConfigKey + "\"\n"
+
+// writeConfig writes a configuration file into a test directory and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "daemon.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// validateConfig loads and validates a configuration, returning its problems as `config validate` does
+func validateConfig(t *testing.T, content string) ConfigErrors {
+	t.Helper()
+
+	config, err := LoadConfig(writeConfig(t, content))
+	if err == nil {
+		err = config.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error is not a ConfigErrors: %v", err)
+	}
+	return errs
+}
+
+// expectErrors checks that errs has one error per entry of want, each containing it
+func expectErrors(t *testing.T, errs ConfigErrors, want ...string) {
+	t.Helper()
+
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, message := range want {
+		if !strings.Contains(errs[i].Message, message) {
+			t.Errorf("error %d %q does not contain %q", i, errs[i].Message, message)
+		}
+	}
+}
+
+// TestConfigValidatePositions tests that every problem is reported with its line and column
+func TestConfigValidatePositions(t *testing.T) {
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\np2p:\n  listner_port: 9000\n  listener_enabled: maybe\n")
+	if len(errs) != 2 {
+		t.Fatalf("Expected two errors:\n%v", errs)
+	}
+
+	unknown, mistyped := errs[0], errs[1]
+	if unknown.Line != 4 || unknown.Column != 3 || !strings.Contains(unknown.Message, "did you mean listener_port?") {
+		t.Errorf("Unexpected unknown field error: %+v", unknown)
+	}
+	if mistyped.Line != 5 || mistyped.Column != 21 || mistyped.Field != "p2p.listener_enabled" {
+		t.Errorf("Unexpected type error: %+v", mistyped)
+	}
+}
+
+// TestConfigValidateLogging tests that unknown log formats, components and levels are reported
+func TestConfigValidateLogging(t *testing.T) {
+	errs := validateConfig(t, "daemon:\n  log_format: xml\n  log_levels:\n    natt: debug\n    router: loud\nnetwork:\n  local_ip: 10.0.0.1/24\n"+keySection)
+	expectErrors(t, errs, "must be text or json", "did you mean nat?", "log_levels.router must be debug")
+}
+
+// TestConfigValidateNetwork tests checking IPv6 addresses, routes, per-peer subnets and neighbour settings
+func TestConfigValidateNetwork(t *testing.T) {
+	errs := validateConfig(t, "network:\n  mode: tun\n  local_ip: 10.0.0.1/24\n  local_ipv6: 10.0.0.1/24\n  proxy_neighbors: true\n  broadcast_limit: -1\n"+
+		"  routes:\n    - 192.168.1.0/24\n    - 192.168.2.0\n  accept_subnets:\n    - lan\n"+keySection)
+	expectErrors(t, errs, "local_ipv6 must be an IPv6 address", "proxy_neighbors needs network.mode tap", "broadcast_limit must be 0",
+		"routes[1] must be a subnet", "accept_subnets[0] must be a subnet")
+
+	errs = validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\n  peer_subnets:\n    office: [192.168.1.0/24, lan]\n"+keySection)
+	expectErrors(t, errs, "peer office is not in identity.peers", "peer_subnets.office[1] must be a subnet")
+}
+
+// TestConfigValidateNetworks tests checking virtual networks
+func TestConfigValidateNetworks(t *testing.T) {
+	key := "    key: " + testConfigKey + "\n"
+	errs := validateConfig(t, "network:\n  device_name: tap0\n  local_ip: 10.0.0.1/24\nnetworks:\n  - id: office\n"+key+"  - id: bad name\n    vlan: 7\n"+key+"  - id: lab\n    vlan: 5000\n"+key+
+		"  - id: guest\n    vlan: 3\n    device: tap-guest\n"+key+"  - id: office\n    device: tap0\n"+key+"  - id: spare\n"+key+"  - id: vault\n    vlan: 8\n  - id: attic\n    vlan: 9\n    key: beef\n"+keySection)
+	expectErrors(t, errs, "networks[1].id must be 1 to 64 letters", "networks[2].vlan must be between 1 and 4094", "vlan and device exclude each other",
+		"networks[4].id \"office\" is listed twice", "networks[4].device tap0 is already in use", "only one network can be untagged",
+		"networks[7].key must be 64 hex characters", "networks[6].key is required")
+
+	errs = validateConfig(t, "network:\n  mode: tun\n  local_ip: 10.0.0.1/24\nnetworks:\n  - id: office\n"+key+keySection)
+	expectErrors(t, errs, "networks need network.mode tap")
+}
+
+// TestConfigValidateExitNode tests checking exit node settings
+func TestConfigValidateExitNode(t *testing.T) {
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nexit_node:\n  advertise: true\n  use: true\n  dns:\n    - resolver\n"+keySection)
+	expectErrors(t, errs, "exit_node.use and exit_node.advertise exclude each other", "dns[0] must be an IP address", "exit_node.peer is required")
+
+	errs = validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nexit_node:\n  use: true\n  peer: gateway\n"+keySection)
+	expectErrors(t, errs, "peer gateway is not in identity.peers")
+
+	errs = validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nexit_node:\n  allow_lan: true\n"+keySection)
+	expectErrors(t, errs, "exit_node.allow_lan is only used with exit_node.advertise")
+}
+
+// TestConfigValidateAutoAddress tests that local_ip: auto requires a relay and an identity key
+func TestConfigValidateAutoAddress(t *testing.T) {
+	errs := validateConfig(t, "network:\n  local_ip: auto\n"+keySection)
+	expectErrors(t, errs, "requires relay.enabled", "identity.key is required")
+
+	peer := hex.EncodeToString(testIdentity(0).Public().(ed25519.PublicKey))
+	errs = validateConfig(t, "network:\n  local_ip: auto\nrelay:\n  enabled: true\n  server: ws://relay.example.com:9545\npeer:\n  id: alice\n"+
+		"identity:\n  key: "+testIdentityKey+"\n  peers:\n    bob: "+peer+"\n"+keySection)
+	expectErrors(t, errs)
+}
+
+// TestConfigValidateDNS tests peer name resolution settings
+func TestConfigValidateDNS(t *testing.T) {
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\ndns:\n  enabled: true\n  domain: mesh.\n  listen:\n    - 10.0.0.1\n  upstream:\n    - 1.1.1.1\n    - resolver:53\n"+keySection)
+	expectErrors(t, errs, "dns.domain must be a domain name", "dns.listen[0] must be an IP address with a port", "dns.upstream[1] must be an IP address")
+}
+
+// TestConfigValidateACL tests checks on the acl section, including fields of single rules
+func TestConfigValidateACL(t *testing.T) {
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nacl:\n  enabled: true\n  rules:\n    - action: allow\n      port: [\"22\"]\n"+keySection)
+	expectErrors(t, errs, "unknown field acl.rules[0].port (did you mean ports?)")
+	if errs[0].Line != 7 {
+		t.Errorf("unknown rule field reported on line %d, want 7", errs[0].Line)
+	}
+
+	errs = validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nacl:\n  enabled: true\n  default_inbound: drop\n  groups:\n    admins: [laptop]\n  rules:\n"+
+		"    - action: allow\n      peers: [\"group:admins\"]\n      protocol: tcp\n      ports: [\"22\"]\n"+
+		"    - action: permit\n"+
+		"    - action: allow\n      peers: [\"group:ops\"]\n"+
+		"    - action: deny\n      ports: [\"80\"]\n"+keySection)
+	expectErrors(t, errs, "acl.default_inbound must be allow or deny", "peer laptop is not in identity.peers", "acl.rules[1] action must be allow or deny",
+		"acl.rules[2] peers: group \"ops\" is not defined", "acl.rules[3] ports need protocol tcp or udp")
+}
@@ -0,0 +1,580 @@
+package daemonmgr
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
+)
+
+// Control message types exchanged between daemons over the encrypted tunnel
+const (
+	// ControlHello advertises session capabilities; sent when the frame router starts
+	// It is the capability handshake between daemons: the transport handshake only
+	// reaches the relay or listener, not the peers behind it. Until a peer's hello
+	// has arrived every feature that needs its support (compression, FEC,
+	// multipath) stays off, so frames sent before it are understood by any peer.
+	ControlHello = "hello"
+	// ControlPMTUProbe is a padded path MTU probe; the peer answers with ControlPMTUAck
+	ControlPMTUProbe = "pmtu_probe"
+	// ControlPMTUAck acknowledges a path MTU probe
+	ControlPMTUAck = "pmtu_ack"
+	// ControlKeepalive carries packet counters used to measure loss in each direction
+	ControlKeepalive = "keepalive"
+	// ControlPing asks the peer for an immediate ControlPong (round-trip measurement)
+	ControlPing = "ping"
+	// ControlPong answers a ControlPing
+	ControlPong = "pong"
+	// ControlSenderKey carries the sender key of our data frames to one peer (see identity.go)
+	ControlSenderKey = "sender_key"
+)
+
+// ControlMessage is the JSON payload of an encrypted control frame
+type ControlMessage struct {
+	Type        string   `json:"type"`
+	Reply       bool     `json:"reply,omitempty"`       // Set on the answer to a hello so it is not echoed back
+	Compression []string `json:"compression,omitempty"` // Compression algorithms the sender can decode
+	ProbeID     uint32   `json:"probe_id,omitempty"`    // Path MTU probe being sent or acknowledged
+	FEC         bool     `json:"fec,omitempty"`         // Sender can decode FEC-protected datagrams
+	Multipath   bool     `json:"multipath,omitempty"`   // Sender can decode sequenced multipath datagrams
+	TxPackets   uint64   `json:"tx_packets,omitempty"`  // Datagrams the sender has transmitted on this connection
+	Loss        float64  `json:"loss,omitempty"`        // Inbound loss measured by the sender (our outbound loss)
+	PingID      uint32   `json:"ping_id,omitempty"`     // Ping being sent or answered
+	NATType     string   `json:"nat_type,omitempty"`    // Sender's detected NAT type (hello)
+	Addresses   []string `json:"addresses,omitempty"`   // Sender's tunnel addresses (hello)
+	Subnets     []string `json:"subnets,omitempty"`     // Subnets the sender routes for the mesh (hello)
+	Exit        bool     `json:"exit,omitempty"`        // Sender forwards internet traffic for peers (hello)
+	Name        string   `json:"name,omitempty"`        // Sender's name, resolvable as <name>.<dns.domain> (hello)
+	Identity    string   `json:"identity,omitempty"`    // Sender's Ed25519 public key (hello, see identity.go)
+	KeyShare    string   `json:"key_share,omitempty"`   // Sender's X25519 key share for sender keys (hello)
+	Signature   string   `json:"signature,omitempty"`   // Signature over the sender ID, key share and name by Identity (hello)
+	To          uint64   `json:"to,omitempty"`          // Sender ID the message is meant for (sender_key)
+	SenderKey   string   `json:"sender_key,omitempty"`  // Sender key encrypted to the recipient's key share (sender_key)
+}
+
+const (
+	// rttSmoothing weights each new round-trip sample (as TCP's SRTT)
+	rttSmoothing = 0.125
+
+	// pingExpiry is how long an unanswered ping is remembered
+	pingExpiry = 30 * time.Second
+)
+
+// pendingPing is a ping waiting for its pong
+type pendingPing struct {
+	sent time.Time
+	done chan struct{} // Closed on reply; nil for the keepalive's RTT probes
+}
+
+// peerSession holds what we learned about a remote pipeline from its control messages
+type peerSession struct {
+	senderID    uint64
+	compression bool // Peer can decode lz4-compressed frames
+	fec         bool // Peer can decode FEC-protected datagrams
+	multipath   bool // Peer can decode sequenced multipath datagrams
+	natType     string
+	lastSeen    time.Time
+	lastHello   time.Time
+	rtt         time.Duration // Smoothed round trip over the control channel
+
+	// Addresses and subnets from the hello (see routes.go)
+	announced   bool           // Hello listed tunnel addresses; daemons before subnet advertisement send none
+	joined      time.Time      // First hello; an address claimed by two peers stays with the earlier one
+	claims      []netip.Addr   // Tunnel addresses from the hello, within our tunnel networks
+	addresses   []netip.Addr   // Claimed addresses no other peer announced first
+	conflicting []netip.Addr   // Claimed addresses another peer announced first
+	subnets     []netip.Prefix // Advertised subnets
+	accepted    []netip.Prefix // Advertised subnets within network.accept_subnets
+	ignored     []netip.Prefix // Advertised subnets outside network.accept_subnets or network.peer_subnets, or overlapping our tunnel network
+	exit        bool           // Peer offers itself as internet exit
+	name        string         // Announced name as a DNS label (see dns.go)
+
+	// Name ownership (see identity.go)
+	identity     ed25519.PublicKey // Key that signed the announced name; nil if unsigned
+	keyShare     *ecdh.PublicKey   // Signed key share our sender key is sent under; nil if none
+	senderKey    bool              // Data frames are bound to the sender key the peer sent under identity
+	nameVerified bool              // Name is proven by the key in identity.peers and held by this peer
+	nameConflict bool              // Another peer announces the same name and this one does not hold it
+
+	// Pipeline transmit counters when the peer joined; our traffic since then went to it
+	txFramesBase uint64
+	txBytesBase  uint64
+
+	// Loss measurement from keepalives
+	keepaliveSeen bool
+	lastPeerTx    uint64  // Peer's transmit counter at the previous keepalive
+	lastRx        uint64  // Our receive counter at the previous keepalive
+	inboundLoss   float64 // Smoothed loss of datagrams sent by the peer
+	outboundLoss  float64 // Loss of our datagrams as reported by the peer
+}
+
+// sendControl queues a control message for the outbound frame router
+func (dm *DaemonManager) sendControl(msg *ControlMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode control message: %w", err)
+	}
+
+	return dm.queueControl(msg.Type, payload)
+}
+
+// queueControl queues an encoded control message without blocking
+func (dm *DaemonManager) queueControl(msgType string, payload []byte) error {
+	select {
+	case dm.controlOut <- payload:
+		return nil
+	default:
+		return fmt.Errorf("control queue full, dropping %s message", msgType)
+	}
+}
+
+// sendHello advertises our capabilities to the peer
+func (dm *DaemonManager) sendHello(reply bool) error {
+	msg := &ControlMessage{
+		Type:  ControlHello,
+		Reply: reply,
+	}
+
+	// Every daemon can decode compressed frames; advertising is independent of
+	// whether we compress our own traffic
+	msg.Compression = []string{frameencryption.CompressionLZ4}
+	msg.FEC = true
+	msg.Multipath = true
+	msg.NATType = dm.natType()
+	msg.Exit = dm.cfg().ExitNode.Advertise
+	msg.Name = dm.cfg().dnsName()
+	dm.signHello(msg)
+	dm.announceSubnets(msg)
+
+	return dm.sendControl(msg)
+}
+
+// handleControlFrame decodes and dispatches a control message from a peer
+func (dm *DaemonManager) handleControlFrame(frame *frameencryption.ControlFrame) {
+	// Ignore our own messages reflected back (e.g. by a relay)
+	if frame.SenderID == dm.encryptionPipeline.SenderID() {
+		return
+	}
+
+	var msg ControlMessage
+	if err := json.Unmarshal(frame.Payload, &msg); err != nil {
+		invalidControlLog.Warn(controlLogger, "invalid control message", senderAttr(frame.SenderID), "error", err)
+		return
+	}
+
+	switch msg.Type {
+	case ControlHello:
+		dm.handleHello(frame.SenderID, &msg)
+	case ControlPMTUProbe:
+		if err := dm.sendControl(&ControlMessage{Type: ControlPMTUAck, ProbeID: msg.ProbeID}); err != nil {
+			pmtuLogger.Warn("failed to acknowledge path MTU probe", "error", err)
+		}
+	case ControlPMTUAck:
+		dm.handlePMTUAck(msg.ProbeID)
+	case ControlKeepalive:
+		dm.handleKeepalive(frame.SenderID, &msg)
+	case ControlPing:
+		if err := dm.sendControl(&ControlMessage{Type: ControlPong, PingID: msg.PingID}); err != nil {
+			controlLogger.Warn("failed to answer ping", "error", err)
+		}
+	case ControlPong:
+		dm.handlePong(frame.SenderID, msg.PingID)
+	case ControlSenderKey:
+		dm.handleSenderKey(frame.SenderID, &msg)
+	default:
+		// Unknown types come from newer peers; ignore them for forward compatibility
+		controlLogger.Debug("ignoring unknown control message", "type", msg.Type, senderAttr(frame.SenderID))
+	}
+}
+
+// handleHello records the peer's capabilities and answers with our own
+func (dm *DaemonManager) handleHello(senderID uint64, msg *ControlMessage) {
+	supportsLZ4 := false
+	for _, algorithm := range msg.Compression {
+		if algorithm == frameencryption.CompressionLZ4 {
+			supportsLZ4 = true
+			break
+		}
+	}
+
+	addresses, subnets := dm.parseAnnouncement(senderID, msg)
+	identity, keyShare := helloIdentity(senderID, msg)
+	now := time.Now()
+
+	dm.peersMu.Lock()
+	if bound, ok := dm.boundSenders[senderID]; ok && !bound.Equal(identity) {
+		dm.peersMu.Unlock()
+		controlLogger.Warn("ignoring hello not signed by the key the sender is bound to", senderAttr(senderID))
+		return
+	}
+	peer, known := dm.peers[senderID]
+	if !known {
+		peer = &peerSession{senderID: senderID, joined: now}
+		if dm.encryptionPipeline != nil {
+			metrics := dm.encryptionPipeline.GetMetrics()
+			peer.txFramesBase = metrics.EncryptedCount
+			peer.txBytesBase = metrics.EncryptedBytes
+		}
+		dm.peers[senderID] = peer
+	}
+	peer.compression = supportsLZ4
+	peer.fec = msg.FEC
+	peer.multipath = msg.Multipath
+	peer.natType = msg.NATType
+	peer.announced = len(msg.Addresses) > 0
+	peer.claims = addresses
+	peer.subnets = subnets
+	peer.exit = msg.Exit
+	peer.name = magicdns.Label(msg.Name)
+	peer.identity = identity
+	peer.keyShare = keyShare
+	_, peer.senderKey = dm.boundSenders[senderID]
+	peer.lastHello = now
+	peer.lastSeen = now
+	joined := peer.status()
+	dm.peersMu.Unlock()
+
+	if !known {
+		dm.events.publish(Event{Type: EventPeerJoined, Peer: &joined})
+	}
+
+	controlLogger.Info("received hello", senderAttr(senderID), "lz4", supportsLZ4, "fec", msg.FEC, "multipath", msg.Multipath, "subnets", subnets, "exit", msg.Exit)
+
+	dm.updatePeerSubnets()
+	dm.peersMu.RLock()
+	ignored, conflicting := peer.ignored, peer.conflicting
+	name, nameVerified, nameConflict := peer.name, peer.nameVerified, peer.nameConflict
+	dm.peersMu.RUnlock()
+	keys := dm.cfg().peerKeys()
+	pending := identity != nil && identity.Equal(keys[name]) // Verified once its sender key arrives
+	if len(ignored) > 0 {
+		controlLogger.Warn("ignoring advertised subnets outside network.accept_subnets or network.peer_subnets, or overlapping the tunnel network", senderAttr(senderID), "subnets", ignored)
+	}
+	if len(conflicting) > 0 {
+		controlLogger.Warn("ignoring tunnel addresses another peer already uses", senderAttr(senderID), "addresses", conflicting)
+	}
+	switch {
+	case nameConflict:
+		controlLogger.Warn("ignoring name another peer holds", senderAttr(senderID), "name", name)
+	case name != "" && !nameVerified && !pending:
+		controlLogger.Warn("ignoring name not signed by its key in identity.peers", senderAttr(senderID), "name", name)
+	}
+
+	dm.updateCompression()
+	dm.updateFEC()
+	dm.updateMultipath()
+
+	if !msg.Reply {
+		if err := dm.sendHello(true); err != nil {
+			controlLogger.Warn("failed to answer hello", "error", err)
+		}
+	}
+
+	// Peers signing with a key in identity.peers get our sender key after the hello,
+	// so they know our key share before it arrives
+	if dm.keyShare != nil && keyShare != nil && listedIdentity(keys, identity) {
+		if err := dm.sendSenderKey(senderID, keyShare); err != nil {
+			controlLogger.Warn("failed to send sender key", senderAttr(senderID), "error", err)
+		}
+	}
+}
+
+// updateCompression enables compression only if configured and every known peer supports it
+// Called whenever a hello arrives or peers are forgotten, so compression starts with the
+// first frame after the hello exchange and stops before a peer that cannot decode it
+// is sent anything compressed. Each side decides for its own transmit direction:
+// with compression.enabled on one side only, frames are compressed in that direction.
+func (dm *DaemonManager) updateCompression() {
+	enabled := dm.cfg().Compression.Enabled
+
+	dm.peersMu.RLock()
+	if len(dm.peers) == 0 {
+		enabled = false
+	}
+	for _, peer := range dm.peers {
+		if !peer.compression {
+			enabled = false
+			break
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	if dm.encryptionPipeline.CompressionEnabled() == enabled {
+		return
+	}
+
+	dm.encryptionPipeline.SetCompression(enabled)
+	if enabled {
+		controlLogger.Info("lz4 frame compression enabled")
+	} else {
+		controlLogger.Info("lz4 frame compression disabled")
+	}
+}
+
+// Ping measures the round trip to the peer over the encrypted control channel
+func (dm *DaemonManager) Ping(ctx context.Context) (time.Duration, error) {
+	if dm.GetState() != StateConnected {
+		return 0, fmt.Errorf("not connected")
+	}
+
+	var buf [4]byte
+	rand.Read(buf[:])
+	id := binary.BigEndian.Uint32(buf[:])
+
+	pong := make(chan struct{})
+	start := time.Now()
+	dm.pingsMu.Lock()
+	dm.pings[id] = &pendingPing{sent: start, done: pong}
+	dm.pingsMu.Unlock()
+
+	defer func() {
+		dm.pingsMu.Lock()
+		delete(dm.pings, id)
+		dm.pingsMu.Unlock()
+	}()
+
+	if err := dm.sendControl(&ControlMessage{Type: ControlPing, PingID: id}); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("no reply from peer: %w", ctx.Err())
+	}
+}
+
+// sendRTTProbe pings the peer in the background so its RTT stays current
+func (dm *DaemonManager) sendRTTProbe() {
+	var buf [4]byte
+	rand.Read(buf[:])
+	id := binary.BigEndian.Uint32(buf[:])
+	now := time.Now()
+
+	dm.pingsMu.Lock()
+	for pendingID, ping := range dm.pings {
+		if ping.done == nil && now.Sub(ping.sent) > pingExpiry {
+			delete(dm.pings, pendingID)
+		}
+	}
+	dm.pings[id] = &pendingPing{sent: now}
+	dm.pingsMu.Unlock()
+
+	if err := dm.sendControl(&ControlMessage{Type: ControlPing, PingID: id}); err != nil {
+		controlLogger.Warn("failed to send RTT probe", "error", err)
+	}
+}
+
+// handlePong records the round trip and completes the ping waiting for this reply
+func (dm *DaemonManager) handlePong(senderID uint64, pingID uint32) {
+	now := time.Now()
+
+	dm.pingsMu.Lock()
+	ping, ok := dm.pings[pingID]
+	delete(dm.pings, pingID)
+	dm.pingsMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	sample := now.Sub(ping.sent)
+	dm.metrics.rtt.ObserveDuration(sample)
+
+	dm.peersMu.Lock()
+	if peer, known := dm.peers[senderID]; known {
+		if peer.rtt == 0 {
+			peer.rtt = sample
+		} else {
+			peer.rtt += time.Duration(rttSmoothing * float64(sample-peer.rtt))
+		}
+		peer.lastSeen = now
+	}
+	dm.peersMu.Unlock()
+
+	if ping.done != nil {
+		close(ping.done)
+	}
+}
+
+// PeerStatus reports what was negotiated with a peer and the traffic exchanged with it
+type PeerStatus struct {
+	SenderID       string            `json:"sender_id"`
+	Name           string            `json:"name,omitempty"`          // As announced in the peer's hello
+	NameVerified   bool              `json:"name_verified,omitempty"` // Name is signed by its key in identity.peers; only then ACL rules and MagicDNS use it
+	NameConflict   bool              `json:"name_conflict,omitempty"` // Another peer holds the announced name
+	SenderKey      bool              `json:"sender_key,omitempty"`    // Data frames are bound to the sender key the peer sent under its identity key
+	Transport      string            `json:"transport,omitempty"`     // "udp", "websocket" or "relay"
+	Path           string            `json:"path,omitempty"`          // Path frames to the peer take
+	NATType        string            `json:"nat_type,omitempty"`      // As announced in the peer's hello
+	Compression    bool              `json:"compression"`
+	FEC            bool              `json:"fec"`
+	Multipath      bool              `json:"multipath"`
+	LastHandshake  time.Time         `json:"last_handshake"` // Most recent hello
+	LastSeen       time.Time         `json:"last_seen"`
+	KeySequence    uint64            `json:"key_sequence"` // Peer's transmit key sequence seen most recently
+	TxFrames       uint64            `json:"tx_frames"`
+	TxBytes        uint64            `json:"tx_bytes"`
+	RxFrames       uint64            `json:"rx_frames"`
+	RxBytes        uint64            `json:"rx_bytes"`
+	Drops          map[string]uint64 `json:"drops"`                     // Authenticated frames from the peer discarded, by reason
+	Addresses      []string          `json:"addresses,omitempty"`       // Tunnel addresses from the peer's hello
+	Subnets        []string          `json:"subnets,omitempty"`         // Advertised subnets accepted from the peer
+	IgnoredSubnets []string          `json:"ignored_subnets,omitempty"` // Advertised subnets outside network.accept_subnets or network.peer_subnets, or overlapping the tunnel network
+	Conflicting    []string          `json:"conflicting,omitempty"`     // Tunnel addresses from the hello that another peer announced first
+	Exit           bool              `json:"exit,omitempty"`            // Peer offers itself as internet exit
+	RTTMillis      float64           `json:"rtt_ms"`
+	InboundLoss    float64           `json:"inbound_loss"`
+	OutboundLoss   float64           `json:"outbound_loss"`
+}
+
+// GetPeers returns each peer's negotiated features and traffic, ordered by sender ID
+func (dm *DaemonManager) GetPeers() []PeerStatus {
+	return dm.peerStatus()
+}
+
+// peerStatus returns each peer's negotiated features and traffic for the status API
+func (dm *DaemonManager) peerStatus() []PeerStatus {
+	type peerCounters struct {
+		senderID                  uint64
+		txFramesBase, txBytesBase uint64
+	}
+
+	dm.peersMu.RLock()
+	peers := make([]PeerStatus, 0, len(dm.peers))
+	counters := make([]peerCounters, 0, len(dm.peers))
+	for _, peer := range dm.peers {
+		peers = append(peers, peer.status())
+		counters = append(counters, peerCounters{peer.senderID, peer.txFramesBase, peer.txBytesBase})
+	}
+	dm.peersMu.RUnlock()
+
+	transport, path := dm.transportPath()
+
+	metrics := &frameencryption.PipelineMetrics{}
+	if dm.encryptionPipeline != nil {
+		metrics = dm.encryptionPipeline.GetMetrics()
+	}
+
+	for i := range peers {
+		peer := &peers[i]
+		peer.Transport = transport
+		peer.Path = path
+
+		// Frames are not addressed to a peer, so everything sent since it joined went to it
+		if metrics.EncryptedCount >= counters[i].txFramesBase {
+			peer.TxFrames = metrics.EncryptedCount - counters[i].txFramesBase
+			peer.TxBytes = metrics.EncryptedBytes - counters[i].txBytesBase
+		}
+
+		peer.Drops = map[string]uint64{}
+		if dm.encryptionPipeline == nil {
+			continue
+		}
+		if rx, ok := dm.encryptionPipeline.SenderStats(counters[i].senderID); ok {
+			peer.RxFrames = rx.Frames
+			peer.RxBytes = rx.Bytes
+			peer.KeySequence = rx.KeySequence
+			peer.Drops = rx.Drops
+			if rx.LastFrame.After(peer.LastSeen) {
+				peer.LastSeen = rx.LastFrame
+			}
+		}
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].SenderID < peers[j].SenderID })
+	return peers
+}
+
+// transportPath describes the active transport and the path it sends on
+func (dm *DaemonManager) transportPath() (transport, path string) {
+	conn := dm.p2pConnection
+	if conn == nil || !conn.isConnected() {
+		return "", ""
+	}
+
+	path = conn.PeerAddr()
+	if bond := conn.Multipath(); bond != nil {
+		// Report the fastest path that is up; the bond may spread traffic over the others
+		var best *multipath.PathStats
+		paths := bond.Paths()
+		for i := range paths {
+			if paths[i].Up && (best == nil || paths[i].RTT < best.RTT) {
+				best = &paths[i]
+			}
+		}
+		if best != nil {
+			path = fmt.Sprintf("%s %s → %s", best.Interface, best.Local, best.Remote)
+		}
+	}
+	return conn.TransportName(), path
+}
+
+// status returns the peer's negotiated state (dm.peersMu held); peerStatus adds traffic
+func (peer *peerSession) status() PeerStatus {
+	status := PeerStatus{
+		SenderID:      fmt.Sprintf("%016x", peer.senderID),
+		Name:          peer.name,
+		NameVerified:  peer.nameVerified,
+		NameConflict:  peer.nameConflict,
+		SenderKey:     peer.senderKey,
+		NATType:       peer.natType,
+		Compression:   peer.compression,
+		FEC:           peer.fec,
+		Multipath:     peer.multipath,
+		LastHandshake: peer.lastHello,
+		LastSeen:      peer.lastSeen,
+		RTTMillis:     float64(peer.rtt.Microseconds()) / 1000,
+		InboundLoss:   peer.inboundLoss,
+		OutboundLoss:  peer.outboundLoss,
+		Exit:          peer.exit,
+	}
+	for _, addr := range peer.addresses {
+		status.Addresses = append(status.Addresses, addr.String())
+	}
+	for _, subnet := range peer.accepted {
+		status.Subnets = append(status.Subnets, subnet.String())
+	}
+	for _, subnet := range peer.ignored {
+		status.IgnoredSubnets = append(status.IgnoredSubnets, subnet.String())
+	}
+	for _, addr := range peer.conflicting {
+		status.Conflicting = append(status.Conflicting, addr.String())
+	}
+	return status
+}
+
+// resetPeers forgets negotiated peer state (called on disconnect)
+func (dm *DaemonManager) resetPeers() {
+	dm.peersMu.Lock()
+	left := make([]PeerStatus, 0, len(dm.peers))
+	for _, peer := range dm.peers {
+		left = append(left, peer.status())
+	}
+	dm.peers = make(map[uint64]*peerSession)
+	dm.peersMu.Unlock()
+
+	dm.updatePeerSubnets()
+	dm.neighbors.Clear()
+
+	for i := range left {
+		dm.events.publish(Event{Type: EventPeerLeft, Peer: &left[i]})
+	}
+
+	if dm.encryptionPipeline != nil {
+		dm.updateCompression()
+	}
+	dm.updateFEC()
+	dm.updateMultipath()
+}
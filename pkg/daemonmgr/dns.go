@@ -0,0 +1,245 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+)
+
+// resolvConf lists the host's name servers, the default upstream servers
+const resolvConf = "/etc/resolv.conf"
+
+// DNSStatus reports peer name resolution for the status API
+type DNSStatus struct {
+	Domain   string   `json:"domain"`
+	Name     string   `json:"name"`               // Name this node announces, resolvable as <name>.<domain>
+	Listen   []string `json:"listen,omitempty"`   // Addresses served on; none until the tunnel has an address
+	Upstream []string `json:"upstream,omitempty"` // Servers other queries are forwarded to
+
+	// Names announced by peers that do not resolve to them (see identity.go)
+	Conflicts  []string `json:"conflicts,omitempty"`  // Names several nodes announce; only the one holding the name resolves
+	Unverified []string `json:"unverified,omitempty"` // Names not signed with their key in identity.peers
+}
+
+// SetDNSConfigurator makes the daemon set up split DNS through configurator
+// Must be called before Start. By default systemd-resolved is configured (see
+// magicdns.NewConfigurator); a device outside the host network stack, such as a
+// layer2.PipeDevice, needs magicdns.NopConfigurator.
+func (dm *DaemonManager) SetDNSConfigurator(configurator magicdns.Configurator) {
+	dm.dnsConfigurator = configurator
+}
+
+// dnsDomain returns the domain peer names are resolved under
+func (c *DaemonConfig) dnsDomain() string {
+	if c.DNS.Domain == "" {
+		return magicdns.DefaultDomain
+	}
+	return strings.ToLower(c.DNS.Domain)
+}
+
+// dnsName returns the name this node announces in its hello, as a DNS label
+// It is dns.name, else peer.id, else the first label of the hostname.
+func (c *DaemonConfig) dnsName() string {
+	if c.DNS.Name != "" {
+		return magicdns.Label(c.DNS.Name)
+	}
+	if c.Peer.ID != "" {
+		return magicdns.Label(c.Peer.ID)
+	}
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return magicdns.Label(hostname)
+}
+
+// parseUpstream parses a DNS server as address or address:port (default port 53)
+func parseUpstream(server string) (netip.AddrPort, error) {
+	if addrPort, err := netip.ParseAddrPort(server); err == nil {
+		return addrPort, nil
+	}
+	addr, err := netip.ParseAddr(server)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("must be an IP address, optionally with a port, got %q", server)
+	}
+	return netip.AddrPortFrom(addr, 53), nil
+}
+
+// startDNS starts resolving peer names on the tunnel address, replacing a running server
+// Without a tunnel address yet (network.local_ip: auto before the relay assigned one)
+// nothing is started; applyAssignment calls it again. Failing to set up split DNS
+// is only logged: names still resolve when queried at the listen address.
+func (dm *DaemonManager) startDNS() error {
+	config := dm.cfg()
+	if !config.DNS.Enabled {
+		return nil
+	}
+
+	dm.dnsMu.Lock()
+	defer dm.dnsMu.Unlock()
+	dm.stopDNSLocked()
+
+	listen := dm.dnsListen()
+	if len(listen) == 0 {
+		dnsLogger.Debug("no tunnel address yet, not resolving peer names")
+		return nil
+	}
+	upstream := dm.dnsUpstream(listen)
+	domain := config.dnsDomain()
+
+	server := magicdns.NewServer(magicdns.Config{
+		Listen:   listen,
+		Domain:   domain,
+		Upstream: upstream,
+		Resolve:  dm.resolvePeer,
+	})
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("DNS server failed to start: %w", err)
+	}
+	dm.dnsServer = server
+	dnsLogger.Info("resolving peer names", "domain", domain, "name", config.dnsName(), "listen", server.Listen(), "upstream", upstream)
+
+	if dm.dnsConfigurator == nil {
+		dm.dnsConfigurator = magicdns.NewConfigurator()
+	}
+	var servers []netip.Addr
+	for _, addr := range server.Listen() {
+		if addr.Port() == 53 {
+			servers = append(servers, addr.Addr())
+		}
+	}
+	if len(servers) == 0 {
+		dnsLogger.Warn("split DNS needs the DNS server on port 53, query it directly", "listen", server.Listen())
+	} else if err := dm.dnsConfigurator.Configure(dm.tapDevice.Name(), servers, domain); err != nil {
+		dnsLogger.Warn("failed to set up split DNS, query the DNS server directly", "error", err)
+	} else {
+		dnsLogger.Info("split DNS configured", "device", dm.tapDevice.Name(), "domain", domain)
+	}
+	return nil
+}
+
+// stopDNS stops resolving peer names and reverts split DNS
+func (dm *DaemonManager) stopDNS() {
+	dm.dnsMu.Lock()
+	defer dm.dnsMu.Unlock()
+	dm.stopDNSLocked()
+}
+
+// stopDNSLocked is stopDNS with dm.dnsMu held
+func (dm *DaemonManager) stopDNSLocked() {
+	if dm.dnsServer == nil {
+		return
+	}
+	if err := dm.dnsConfigurator.Revert(); err != nil {
+		dnsLogger.Warn("failed to revert split DNS", "error", err)
+	}
+	if err := dm.dnsServer.Close(); err != nil {
+		dnsLogger.Warn("failed to stop DNS server", "error", err)
+	}
+	dm.dnsServer = nil
+}
+
+// dnsListen returns dns.listen, or the IPv4 tunnel address on port 53
+func (dm *DaemonManager) dnsListen() []netip.AddrPort {
+	var listen []netip.AddrPort
+	if configured := dm.cfg().DNS.Listen; len(configured) > 0 {
+		for _, address := range configured {
+			if addrPort, err := netip.ParseAddrPort(address); err == nil { // Validated when loaded
+				listen = append(listen, addrPort)
+			}
+		}
+		return listen
+	}
+
+	for _, prefix := range dm.tunnelPrefixes() {
+		if prefix.Addr().Is4() {
+			return []netip.AddrPort{netip.AddrPortFrom(prefix.Addr(), 53)}
+		}
+	}
+	return nil
+}
+
+// dnsUpstream returns dns.upstream, or the host's name servers other than ours
+func (dm *DaemonManager) dnsUpstream(listen []netip.AddrPort) []netip.AddrPort {
+	var upstream []netip.AddrPort
+	if configured := dm.cfg().DNS.Upstream; len(configured) > 0 {
+		for _, server := range configured {
+			if addrPort, err := parseUpstream(server); err == nil { // Validated when loaded
+				upstream = append(upstream, addrPort)
+			}
+		}
+		return upstream
+	}
+
+	servers, err := magicdns.SystemUpstream(resolvConf)
+	if err != nil {
+		dnsLogger.Warn("no upstream DNS servers, only peer names resolve", "error", err)
+		return nil
+	}
+	for _, server := range servers {
+		if !slices.Contains(listen, server) {
+			upstream = append(upstream, server)
+		}
+	}
+	return upstream
+}
+
+// resolvePeer returns the tunnel addresses of the node called name, ourselves included
+// A peer's name resolves only once verified: signed with its key in identity.peers and
+// not held by a node that announced it earlier.
+func (dm *DaemonManager) resolvePeer(name string) []netip.Addr {
+	if name == dm.cfg().dnsName() {
+		var addrs []netip.Addr
+		for _, prefix := range dm.tunnelPrefixes() {
+			addrs = append(addrs, prefix.Addr())
+		}
+		return addrs
+	}
+
+	dm.peersMu.RLock()
+	defer dm.peersMu.RUnlock()
+
+	for _, peer := range dm.peers {
+		if peer.name == name && peer.nameVerified {
+			return slices.Clone(peer.addresses)
+		}
+	}
+	return nil
+}
+
+// dnsStatus reports peer name resolution for the status API; nil if disabled
+func (dm *DaemonManager) dnsStatus() *DNSStatus {
+	config := dm.cfg()
+	if !config.DNS.Enabled {
+		return nil
+	}
+	status := &DNSStatus{Domain: config.dnsDomain(), Name: config.dnsName()}
+
+	dm.peersMu.RLock()
+	for _, peer := range dm.peers {
+		switch {
+		case peer.nameConflict && !slices.Contains(status.Conflicts, peer.name):
+			status.Conflicts = append(status.Conflicts, peer.name)
+		case peer.name != "" && !peer.nameVerified && !peer.nameConflict && !slices.Contains(status.Unverified, peer.name):
+			status.Unverified = append(status.Unverified, peer.name)
+		}
+	}
+	dm.peersMu.RUnlock()
+	slices.Sort(status.Conflicts)
+	slices.Sort(status.Unverified)
+
+	dm.dnsMu.Lock()
+	defer dm.dnsMu.Unlock()
+	if dm.dnsServer == nil {
+		return status
+	}
+	for _, addr := range dm.dnsServer.Listen() {
+		status.Listen = append(status.Listen, addr.String())
+	}
+	for _, addr := range dm.dnsServer.Upstream() {
+		status.Upstream = append(status.Upstream, addr.String())
+	}
+	return status
+}
@@ -0,0 +1,299 @@
+package daemonmgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
+	"github.com/shadowmesh/shadowmesh/pkg/nat"
+)
+
+const (
+	// eventHistorySize is how many events are kept for clients resuming with Last-Event-ID
+	eventHistorySize = 256
+
+	// eventBufferSize is each subscriber's queue; a subscriber that falls this far behind is dropped
+	eventBufferSize = 512
+
+	// dropSampleInterval is how often drop counters are checked for bursts
+	dropSampleInterval = time.Second
+
+	// dropBurstThreshold is the number of drops within one sample that is reported as a burst
+	dropBurstThreshold = 50
+)
+
+// EventType names a daemon event
+type EventType string
+
+// Event types streamed by /events
+const (
+	// EventStateChanged is a connection state transition (Event.State)
+	EventStateChanged EventType = "state_changed"
+	// EventPathChanged is a multipath path coming up or going down (Event.Path)
+	EventPathChanged EventType = "path_changed"
+	// EventNATDetected is the result of NAT type detection (Event.NAT)
+	EventNATDetected EventType = "nat_detected"
+	// EventKeyRotated is a transmit session key rotation (Event.Key)
+	EventKeyRotated EventType = "key_rotated"
+	// EventPeerJoined is a peer announcing itself with a hello (Event.Peer)
+	EventPeerJoined EventType = "peer_joined"
+	// EventPeerLeft is a peer forgotten on disconnect (Event.Peer)
+	EventPeerLeft EventType = "peer_left"
+	// EventDropBurst is a burst of dropped frames (Event.Drops)
+	EventDropBurst EventType = "drop_burst"
+	// EventConfigReloaded is a configuration reload that changed something (Event.Reload)
+	EventConfigReloaded EventType = "config_reloaded"
+	// EventReconnecting is a retry of a lost or failed connection, before its backoff delay (Event.Reconnect)
+	EventReconnecting EventType = "reconnecting"
+	// EventReconnected is a lost connection coming back (Event.Reconnect)
+	EventReconnected EventType = "reconnected"
+)
+
+// Event is one entry of the daemon's event stream
+// Exactly one payload field is set, matching Type.
+type Event struct {
+	ID   uint64    `json:"id"` // Increases by one per event; used as the SSE event ID
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	State     *StateChangedEvent   `json:"state,omitempty"`
+	Path      *multipath.PathStats `json:"path,omitempty"`
+	NAT       *NATDetectedEvent    `json:"nat,omitempty"`
+	Key       *KeyRotatedEvent     `json:"key,omitempty"`
+	Peer      *PeerStatus          `json:"peer,omitempty"`
+	Drops     *DropBurstEvent      `json:"drops,omitempty"`
+	Reload    *ReloadResult        `json:"reload,omitempty"`
+	Reconnect *ReconnectEvent      `json:"reconnect,omitempty"`
+}
+
+// StateChangedEvent describes a connection state transition
+type StateChangedEvent struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"` // Set for transitions caused by an error
+}
+
+// NATDetectedEvent describes the result of NAT type detection
+type NATDetectedEvent struct {
+	NATType         string  `json:"nat_type"`
+	PublicIP        string  `json:"public_ip"`
+	PublicPort      int     `json:"public_port"`
+	P2PFeasible     bool    `json:"p2p_feasible"`
+	DetectionMillis float64 `json:"detection_ms"`
+}
+
+// KeyRotatedEvent describes a transmit session key rotation
+type KeyRotatedEvent struct {
+	KeySequence uint64 `json:"key_sequence"` // Sequence of the new key
+}
+
+// DropBurstEvent describes frames dropped within one sample interval
+type DropBurstEvent struct {
+	Reason         string  `json:"reason"` // frameencryption.DropReason name, "reassembly_timeout" or "receive_queue"
+	Dropped        uint64  `json:"dropped"`
+	IntervalMillis float64 `json:"interval_ms"`
+}
+
+// ReconnectEvent describes a reconnect attempt (EventReconnecting) or its success (EventReconnected)
+type ReconnectEvent struct {
+	Target         string  `json:"target"`                // Peer or relay being redialled
+	Attempt        int     `json:"attempt"`               // Retry number, from 1
+	DelayMillis    float64 `json:"delay_ms,omitempty"`    // Backoff before this attempt
+	Error          string  `json:"error,omitempty"`       // Why the connection or the previous attempt failed
+	DowntimeMillis float64 `json:"downtime_ms,omitempty"` // Time without a connection (reconnected)
+}
+
+// String formats the event for logs and `shadowmesh watch`
+func (e Event) String() string {
+	switch {
+	case e.State != nil && e.State.Error != "":
+		return fmt.Sprintf("%s → %s: %s", e.State.From, e.State.To, e.State.Error)
+	case e.State != nil:
+		return fmt.Sprintf("%s → %s", e.State.From, e.State.To)
+	case e.Path != nil:
+		state := "down"
+		if e.Path.Up {
+			state = "up"
+		}
+		return fmt.Sprintf("path %s (%s → %s) %s", e.Path.Interface, e.Path.Local, e.Path.Remote, state)
+	case e.NAT != nil:
+		return fmt.Sprintf("%s, public %s:%d, direct P2P feasible: %v", e.NAT.NATType, e.NAT.PublicIP, e.NAT.PublicPort, e.NAT.P2PFeasible)
+	case e.Key != nil:
+		return fmt.Sprintf("transmit key sequence %d", e.Key.KeySequence)
+	case e.Peer != nil:
+		return fmt.Sprintf("peer %s", e.Peer.SenderID)
+	case e.Drops != nil:
+		return fmt.Sprintf("%d frames dropped (%s) in %.0f ms", e.Drops.Dropped, e.Drops.Reason, e.Drops.IntervalMillis)
+	case e.Reload != nil:
+		return e.Reload.String()
+	case e.Reconnect != nil && e.Type == EventReconnected:
+		return fmt.Sprintf("reconnected to %s after %d attempts, down %.1f s", e.Reconnect.Target, e.Reconnect.Attempt, e.Reconnect.DowntimeMillis/1000)
+	case e.Reconnect != nil:
+		return fmt.Sprintf("reconnecting to %s (attempt %d in %.1f s): %s", e.Reconnect.Target, e.Reconnect.Attempt, e.Reconnect.DelayMillis/1000, e.Reconnect.Error)
+	}
+	return string(e.Type)
+}
+
+// eventBus fans events out to subscribers and keeps recent history for resuming clients
+// Thread-safe: publish never blocks; subscribers that fall behind are closed.
+type eventBus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event
+	subscribers map[chan Event]struct{}
+}
+
+// newEventBus creates an event bus without subscribers
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// publish assigns the event an ID and timestamp and delivers it to every subscriber
+func (b *eventBus) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if len(b.history) == eventHistorySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistorySize-1]
+	}
+	b.history = append(b.history, event)
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Closing tells the client to reconnect and resume from its last event ID
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of events after lastID (0 = only new events)
+func (b *eventBus) subscribe(lastID uint64) chan Event {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				ch <- event
+			}
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+// unsubscribe stops delivery to ch and closes it
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// SubscribeEvents streams daemon events until cancel is called
+// With lastID > 0, retained events after that ID are replayed first. The channel
+// is closed if the subscriber falls too far behind; resubscribe from the last ID seen.
+func (dm *DaemonManager) SubscribeEvents(lastID uint64) (events <-chan Event, cancel func()) {
+	ch := dm.events.subscribe(lastID)
+	return ch, func() { dm.events.unsubscribe(ch) }
+}
+
+// publishNATResult reports a NAT detection result
+func (dm *DaemonManager) publishNATResult(result *nat.DetectionResult, feasible bool) {
+	dm.events.publish(Event{
+		Type: EventNATDetected,
+		NAT: &NATDetectedEvent{
+			NATType:         result.NATType.String(),
+			PublicIP:        result.PublicIP.String(),
+			PublicPort:      result.PublicPort,
+			P2PFeasible:     feasible,
+			DetectionMillis: float64(result.DetectionTime.Microseconds()) / 1000,
+		},
+	})
+}
+
+// publishKeyRotation reports a transmit key rotation (called from the encryption loop)
+func (dm *DaemonManager) publishKeyRotation(keySequence uint64) {
+	dm.events.publish(Event{
+		Type: EventKeyRotated,
+		Key:  &KeyRotatedEvent{KeySequence: keySequence},
+	})
+}
+
+// publishPathChange reports a multipath path coming up or going down
+func (dm *DaemonManager) publishPathChange(stats multipath.PathStats) {
+	dm.events.publish(Event{Type: EventPathChanged, Path: &stats})
+}
+
+// dropCounters returns cumulative drop counts by reason
+func (dm *DaemonManager) dropCounters() map[string]uint64 {
+	counters := make(map[string]uint64)
+
+	if dm.encryptionPipeline != nil {
+		metrics := dm.encryptionPipeline.GetMetrics()
+		for reason, count := range metrics.Drops {
+			counters[reason] = count
+		}
+		counters["reassembly_timeout"] = metrics.ReassemblyTimeouts
+	}
+	if dm.p2pConnection != nil {
+		counters["receive_queue"] = dm.p2pConnection.Dropped()
+	}
+
+	return counters
+}
+
+// frameRouterDrops samples drop counters and reports bursts
+func (dm *DaemonManager) frameRouterDrops(ctx context.Context) {
+	ticker := time.NewTicker(dropSampleInterval)
+	defer ticker.Stop()
+
+	previous := dm.dropCounters()
+	lastSample := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := dm.dropCounters()
+			for reason, count := range current {
+				// Counters restart with a new connection
+				if count < previous[reason] || count-previous[reason] < dropBurstThreshold {
+					continue
+				}
+				dm.events.publish(Event{
+					Type: EventDropBurst,
+					Time: now,
+					Drops: &DropBurstEvent{
+						Reason:         reason,
+						Dropped:        count - previous[reason],
+						IntervalMillis: float64(now.Sub(lastSample).Microseconds()) / 1000,
+					},
+				})
+			}
+			previous = current
+			lastSample = now
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package daemonmgr
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscriberCount returns the number of live subscribers of the daemon's event bus
+func subscriberCount(dm *DaemonManager) int {
+	dm.events.mu.Lock()
+	defer dm.events.mu.Unlock()
+	return len(dm.events.subscribers)
+}
+
+// waitSubscribers waits until the event bus has want subscribers
+func waitSubscribers(t *testing.T, dm *DaemonManager, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(dm) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("event bus has %d subscribers, want %d", subscriberCount(dm), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readSSE reads one Server-Sent Event, returning its fields
+func readSSE(t *testing.T, reader *bufio.Reader) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fields
+		}
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			t.Fatalf("malformed event stream line %q", line)
+		}
+		fields[name] = value
+	}
+}
+
+// TestEventsStream tests the SSE framing and type filter of /events, and that a disconnecting client is unsubscribed
+func TestEventsStream(t *testing.T) {
+	api := newTestAPI(t, APIConfig{})
+	dm := api.manager
+	server := httptest.NewServer(http.HandlerFunc(api.handleEvents))
+	defer server.Close()
+
+	// An event from before the subscription, only seen when resuming
+	dm.events.publish(Event{Type: EventStateChanged, State: &StateChangedEvent{From: "disconnected", To: "connecting"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?types=peer_joined,state_changed", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+	waitSubscribers(t, dm, 1)
+
+	// Filtered out by ?types
+	dm.events.publish(Event{Type: EventKeyRotated, Key: &KeyRotatedEvent{KeySequence: 2}})
+	dm.events.publish(Event{Type: EventPeerJoined, Peer: &PeerStatus{SenderID: "0x2a", Name: "bob"}})
+
+	fields := readSSE(t, bufio.NewReader(resp.Body))
+	if fields["id"] != "3" || fields["event"] != string(EventPeerJoined) {
+		t.Errorf("event id %q type %q, want id 3 type %s", fields["id"], fields["event"], EventPeerJoined)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(fields["data"]), &event); err != nil {
+		t.Fatalf("event data %q: %v", fields["data"], err)
+	}
+	if event.ID != 3 || event.Type != EventPeerJoined || event.Peer == nil || event.Peer.Name != "bob" {
+		t.Errorf("event = %+v, want the published peer_joined event", event)
+	}
+
+	// Disconnecting unsubscribes
+	cancel()
+	waitSubscribers(t, dm, 0)
+}
+
+// TestEventsResume tests replaying retained events after Last-Event-ID
+func TestEventsResume(t *testing.T) {
+	api := newTestAPI(t, APIConfig{})
+	dm := api.manager
+	server := httptest.NewServer(http.HandlerFunc(api.handleEvents))
+	defer server.Close()
+
+	for sequence := uint64(1); sequence <= 3; sequence++ {
+		dm.events.publish(Event{Type: EventKeyRotated, Key: &KeyRotatedEvent{KeySequence: sequence}})
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{"2", "3"} {
+		if fields := readSSE(t, reader); fields["id"] != want {
+			t.Errorf("replayed event id %q, want %s", fields["id"], want)
+		}
+	}
+
+	resp.Body.Close()
+	waitSubscribers(t, dm, 0)
+
+	req.Header.Set("Last-Event-ID", "latest")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid Last-Event-ID: status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
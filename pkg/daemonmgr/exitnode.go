@@ -0,0 +1,212 @@
+package daemonmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/exitnode"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// endpointLookupTimeout bounds resolving the relay, peer and STUN hosts for exit node mode
+const endpointLookupTimeout = 5 * time.Second
+
+// ExitNodeStatus reports exit node mode for the status API
+type ExitNodeStatus struct {
+	Role       string   `json:"role,omitempty"`        // "exit" (exit_node.advertise) or "client" (exit_node.use)
+	Peer       string   `json:"peer,omitempty"`        // Sender ID of the exit all traffic is routed through (client)
+	KillSwitch bool     `json:"kill_switch,omitempty"` // Traffic outside the tunnel is blocked (client)
+	DNS        []string `json:"dns,omitempty"`         // DNS queries are redirected to these servers while an exit is in use
+}
+
+// SetExitNodeController makes the daemon apply exit node settings through controller
+// Must be called before Start. By default the host network stack is configured (see
+// exitnode.NewController); a device outside it, such as a layer2.PipeDevice, needs
+// exitnode.NopController.
+func (dm *DaemonManager) SetExitNodeController(controller exitnode.Controller) {
+	dm.exitController = controller
+}
+
+// startExitNode enables forwarding and masquerading on an exit, or prepares a client
+// A client's kill switch is installed here, before the daemon connects, so nothing
+// leaks while the tunnel comes up. Its default routes follow when an exit is announced.
+func (dm *DaemonManager) startExitNode() error {
+	config := dm.cfg().ExitNode
+	if !config.Advertise && !config.Use {
+		return nil
+	}
+	if dm.exitController == nil {
+		dm.exitController = exitnode.NewController()
+	}
+
+	if config.Advertise {
+		gateway := exitnode.GatewayConfig{
+			Device:   dm.tapDevice.Name(),
+			Uplink:   config.Interface,
+			AllowLAN: config.AllowLAN,
+			Subnets:  parseSubnets(dm.cfg().Network.AdvertiseSubnets),
+		}
+		if err := dm.exitController.StartGateway(gateway); err != nil {
+			return err
+		}
+		routerLogger.Info("acting as exit node", "device", gateway.Device, "uplink", gateway.Uplink, "allow_lan", gateway.AllowLAN)
+		return nil
+	}
+
+	client := exitnode.ClientConfig{
+		Device:     dm.tapDevice.Name(),
+		Endpoints:  dm.exitEndpoints(),
+		KillSwitch: config.KillSwitch,
+	}
+	if p2p := dm.cfg().P2P; p2p.ListenerEnabled {
+		client.ListenPort = p2p.ListenerPort
+		if client.ListenPort == 0 {
+			client.ListenPort = 9545
+		}
+	}
+	if err := dm.exitController.StartClient(client); err != nil {
+		return err
+	}
+	routerLogger.Info("exit node client started, waiting for an exit", "kill_switch", client.KillSwitch, "endpoints", client.Endpoints)
+	return nil
+}
+
+// stopExitNode removes the exit node settings from the host
+func (dm *DaemonManager) stopExitNode() {
+	if dm.exitController == nil {
+		return
+	}
+	if err := dm.exitController.Stop(); err != nil {
+		routerLogger.Warn("failed to remove exit node settings", "error", err)
+	}
+}
+
+// exitEndpoints resolves the underlay addresses the tunnel itself runs over
+// These are the relay, the configured peer and the STUN server. They keep a route
+// outside the tunnel and pass the kill switch; hosts that do not resolve are skipped.
+func (dm *DaemonManager) exitEndpoints() []netip.Addr {
+	config := dm.cfg()
+
+	var hosts []string
+	if config.Relay.Server != "" {
+		if u, err := url.Parse(config.Relay.Server); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	for _, address := range []string{config.Peer.Address, config.NAT.STUNServer} {
+		if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(dm.ctx, endpointLookupTimeout)
+	defer cancel()
+
+	var endpoints []netip.Addr
+	for _, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			routerLogger.Warn("failed to resolve endpoint for exit node mode", "host", host, "error", err)
+			continue
+		}
+		for _, addr := range addrs {
+			if addr = addr.Unmap(); !slices.Contains(endpoints, addr) {
+				endpoints = append(endpoints, addr)
+			}
+		}
+	}
+	return endpoints
+}
+
+// exitDNS returns the configured DNS servers (validated when loaded)
+func (c *DaemonConfig) exitDNS() []netip.Addr {
+	var servers []netip.Addr
+	for _, server := range c.ExitNode.DNS {
+		if addr, err := netip.ParseAddr(server); err == nil {
+			servers = append(servers, addr)
+		}
+	}
+	return servers
+}
+
+// selectExit returns the peer to route all traffic through: the one holding the verified name exit_node.peer
+// It must announce an exit; no other peer is used in its place. dm.peersMu is held.
+// ok is false if the peer is not connected or offers no exit.
+func (dm *DaemonManager) selectExit(name string) (senderID uint64, ok bool) {
+	for senderID, peer := range dm.peers {
+		if peer.name == name && peer.nameVerified && peer.announced && peer.exit {
+			return senderID, true
+		}
+	}
+	return 0, false
+}
+
+// useExit routes DefaultRoutes through the exit peer and lets it send from any address
+// IPv6 is only routed if both ends have an IPv6 tunnel address. dm.peersMu is held.
+func (dm *DaemonManager) useExit(exit *peerSession, layer layer2.Layer, routes map[netip.Prefix]netip.Addr, filter *sourceFilter) {
+	ipv6 := slices.ContainsFunc(dm.tunnelPrefixes(), func(prefix netip.Prefix) bool { return prefix.Addr().Is6() }) && slices.ContainsFunc(exit.addresses, netip.Addr.Is6)
+
+	filter.peers[exit.senderID] = append(filter.peers[exit.senderID], exitnode.AnyAddress...)
+	for _, route := range exitnode.DefaultRoutes {
+		if route.Addr().Is6() && !ipv6 {
+			continue
+		}
+		routes[route] = exit.gateway(route, layer)
+	}
+}
+
+// activateExit prepares the client side for routing through exit, before the default routes are added
+// The endpoint routes and DNS redirection are set up when the first exit appears.
+// Returns false if that failed; the default routes must then stay out. dm.subnetMu is held.
+func (dm *DaemonManager) activateExit(exit uint64) bool {
+	if !dm.exitActive {
+		if err := dm.exitController.Activate(dm.cfg().exitDNS()); err != nil {
+			routerLogger.Warn("failed to activate exit node, not routing traffic through it", senderAttr(exit), "error", err)
+			return false
+		}
+		dm.exitActive = true
+		routerLogger.Info("routing all traffic through exit node", senderAttr(exit))
+	} else if exit != dm.exitPeer {
+		routerLogger.Info("switched exit node", senderAttr(exit))
+	}
+	dm.exitPeer = exit
+	return true
+}
+
+// deactivateExit undoes activateExit once the default routes are removed (dm.subnetMu held)
+func (dm *DaemonManager) deactivateExit() {
+	if !dm.exitActive {
+		return
+	}
+	if err := dm.exitController.Deactivate(); err != nil {
+		routerLogger.Warn("failed to deactivate exit node", "error", err)
+	}
+	dm.exitActive, dm.exitPeer = false, 0
+	routerLogger.Warn("no exit node available, internet traffic is not tunnelled", "kill_switch", dm.cfg().ExitNode.KillSwitch)
+}
+
+// exitNodeStatus reports exit node mode for the status API
+func (dm *DaemonManager) exitNodeStatus() ExitNodeStatus {
+	config := dm.cfg().ExitNode
+	status := ExitNodeStatus{}
+	switch {
+	case config.Advertise:
+		status.Role = "exit"
+	case config.Use:
+		status.Role = "client"
+		status.KillSwitch = config.KillSwitch
+
+		dm.subnetMu.Lock()
+		if dm.exitActive {
+			status.Peer = fmt.Sprintf("%016x", dm.exitPeer)
+			status.DNS = config.DNS
+		}
+		dm.subnetMu.Unlock()
+	}
+	return status
+}
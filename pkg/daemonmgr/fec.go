@@ -0,0 +1,252 @@
+package daemonmgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/fec"
+)
+
+const (
+	// keepaliveInterval is how often packet counters are exchanged with the peer
+	keepaliveInterval = 5 * time.Second
+
+	// minLossSamplePackets is the fewest datagrams a keepalive interval needs to yield a loss sample
+	minLossSamplePackets = 20
+
+	// lossSmoothing weights each new loss sample in the moving average
+	lossSmoothing = 0.3
+
+	// FEC defaults when the configuration leaves them unset
+	defaultFECDataShards      = 8
+	defaultFECParityShards    = 2
+	defaultFECMaxParityShards = 8
+)
+
+// fecShardCounts returns the configured data shards and the parity bounds, with defaults applied
+func (dm *DaemonManager) fecShardCounts() (dataShards, minParity, maxParity int) {
+	dataShards = dm.cfg().FEC.DataShards
+	if dataShards <= 0 {
+		dataShards = defaultFECDataShards
+	}
+
+	minParity = dm.cfg().FEC.ParityShards
+	if minParity <= 0 {
+		minParity = defaultFECParityShards
+	}
+
+	maxParity = dm.cfg().FEC.MaxParityShards
+	if maxParity <= 0 {
+		maxParity = defaultFECMaxParityShards
+	}
+	if maxParity < minParity {
+		maxParity = minParity
+	}
+
+	return dataShards, minParity, maxParity
+}
+
+// updateFEC enables FEC only if configured, on the UDP transport, and every known peer supports it
+func (dm *DaemonManager) updateFEC() {
+	p2p := dm.p2pConnection
+	if p2p == nil {
+		return
+	}
+
+	enabled := dm.cfg().FEC.Enabled && p2p.Transport() == TransportUDP
+
+	dm.peersMu.RLock()
+	if len(dm.peers) == 0 {
+		enabled = false
+	}
+	for _, peer := range dm.peers {
+		if !peer.fec {
+			enabled = false
+			break
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	if (p2p.FECEncoder() != nil) == enabled {
+		return
+	}
+
+	if !enabled {
+		p2p.SetFECEncoder(nil)
+		fecLogger.Info("forward error correction disabled")
+		return
+	}
+
+	dataShards, parityShards, _ := dm.fecShardCounts()
+	encoder, err := fec.NewEncoder(dataShards, parityShards)
+	if err != nil {
+		fecLogger.Error("invalid FEC configuration", "error", err)
+		return
+	}
+
+	p2p.SetFECEncoder(encoder)
+	fecLogger.Info("forward error correction enabled", "data_shards", dataShards, "parity_shards", parityShards)
+
+	dm.adaptFEC()
+}
+
+// adaptFEC sizes the parity of the next FEC group to the worst outbound loss reported by a peer
+func (dm *DaemonManager) adaptFEC() {
+	if !dm.cfg().FEC.Adaptive || dm.p2pConnection == nil {
+		return
+	}
+
+	encoder := dm.p2pConnection.FECEncoder()
+	if encoder == nil {
+		return
+	}
+
+	loss := 0.0
+	dm.peersMu.RLock()
+	for _, peer := range dm.peers {
+		if peer.outboundLoss > loss {
+			loss = peer.outboundLoss
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	dataShards, minParity, maxParity := dm.fecShardCounts()
+	parity := fec.ParityForLoss(dataShards, loss, minParity, maxParity)
+	current := encoder.ParityShards()
+	if parity == current {
+		return
+	}
+
+	if err := encoder.SetParityShards(parity); err != nil {
+		fecLogger.Warn("failed to adjust FEC parity", "error", err)
+		return
+	}
+	fecLogger.Info("FEC parity adjusted", "from", current, "to", parity, "outbound_loss", loss)
+}
+
+// frameRouterKeepalive periodically sends our packet counters so the peer can measure loss
+// Each keepalive is followed by a ping that keeps the peers' RTT current.
+func (dm *DaemonManager) frameRouterKeepalive(ctx context.Context) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dm.sendKeepalive()
+			dm.sendRTTProbe()
+		}
+	}
+}
+
+// sendKeepalive reports our transmit counter and the inbound loss we measured
+func (dm *DaemonManager) sendKeepalive() {
+	if dm.p2pConnection == nil {
+		return
+	}
+
+	tx, _ := dm.p2pConnection.PacketCounts()
+	msg := &ControlMessage{
+		Type:      ControlKeepalive,
+		TxPackets: tx,
+	}
+
+	// With several peers on a relay only the worst inbound path is reported
+	dm.peersMu.RLock()
+	for _, peer := range dm.peers {
+		if peer.inboundLoss > msg.Loss {
+			msg.Loss = peer.inboundLoss
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	if err := dm.sendControl(msg); err != nil {
+		controlLogger.Warn("failed to send keepalive", "error", err)
+	}
+}
+
+// handleKeepalive measures inbound loss from the peer's counters and records the loss it reports
+func (dm *DaemonManager) handleKeepalive(senderID uint64, msg *ControlMessage) {
+	if dm.p2pConnection == nil {
+		return
+	}
+	_, rx := dm.p2pConnection.PacketCounts()
+
+	dm.peersMu.Lock()
+	peer, ok := dm.peers[senderID]
+	if !ok {
+		// Keepalive before hello; loss is tracked once the peer has introduced itself
+		dm.peersMu.Unlock()
+		return
+	}
+
+	peer.lastSeen = time.Now()
+	peer.outboundLoss = msg.Loss
+
+	if peer.keepaliveSeen && msg.TxPackets >= peer.lastPeerTx && rx >= peer.lastRx {
+		sent := msg.TxPackets - peer.lastPeerTx
+		received := rx - peer.lastRx
+		if sent >= minLossSamplePackets {
+			sample := 0.0
+			if received < sent {
+				sample = float64(sent-received) / float64(sent)
+			}
+			peer.inboundLoss = lossSmoothing*sample + (1-lossSmoothing)*peer.inboundLoss
+		}
+	}
+
+	// Only move the baseline once a sample was taken or the counters were reset,
+	// so quiet intervals accumulate into the next sample
+	if !peer.keepaliveSeen || msg.TxPackets < peer.lastPeerTx || rx < peer.lastRx ||
+		msg.TxPackets-peer.lastPeerTx >= minLossSamplePackets {
+		peer.keepaliveSeen = true
+		peer.lastPeerTx = msg.TxPackets
+		peer.lastRx = rx
+	}
+	dm.peersMu.Unlock()
+
+	dm.adaptFEC()
+}
+
+// FECStatus reports forward error correction on the UDP transport
+type FECStatus struct {
+	Enabled       bool    `json:"enabled"`
+	DataShards    int     `json:"data_shards,omitempty"`
+	ParityShards  int     `json:"parity_shards,omitempty"`
+	Recovered     uint64  `json:"recovered"`     // Datagrams rebuilt from parity
+	Unrecoverable uint64  `json:"unrecoverable"` // Groups with too many shards missing
+	InboundLoss   float64 `json:"inbound_loss"`  // Worst loss of datagrams sent to us
+	OutboundLoss  float64 `json:"outbound_loss"` // Worst loss of our datagrams reported by a peer
+}
+
+// fecStatus returns forward error correction details for the status API
+func (dm *DaemonManager) fecStatus() FECStatus {
+	var status FECStatus
+
+	dm.peersMu.RLock()
+	for _, peer := range dm.peers {
+		if peer.inboundLoss > status.InboundLoss {
+			status.InboundLoss = peer.inboundLoss
+		}
+		if peer.outboundLoss > status.OutboundLoss {
+			status.OutboundLoss = peer.outboundLoss
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	if dm.p2pConnection == nil {
+		return status
+	}
+
+	if encoder := dm.p2pConnection.FECEncoder(); encoder != nil {
+		status.Enabled = true
+		status.DataShards = encoder.DataShards()
+		status.ParityShards = encoder.ParityShards()
+	}
+
+	status.Recovered, status.Unrecoverable = dm.p2pConnection.FECStats()
+
+	return status
+}
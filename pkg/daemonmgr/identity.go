@@ -0,0 +1,290 @@
+package daemonmgr
+
+// Peer names and the identity section
+//
+// A peer's name comes from its hello and is used by ACL rules and MagicDNS. Every
+// peer holds the mesh encryption key, so any of them could announce another's name;
+// a name is therefore only trusted when the hello is signed with the Ed25519 key that
+// identity.peers lists for it, and neither this node nor a peer that joined earlier
+// holds the name. Other peers announcing the name are reported as conflicting; they
+// match no ACL rule by name and do not resolve.
+//
+// The signature binds the name and an X25519 key share to the sender ID of the hello.
+// With identity.key set, our data frames are encrypted under a random sender key
+// instead of the mesh key, and that key is sent, encrypted to the peer's key share,
+// only to peers whose hello is signed with a key in identity.peers. Once a peer's
+// sender key has arrived its data frames must decrypt under it, and hellos under its
+// sender ID must be signed by the same key, so a peer holding only the mesh key can
+// no longer send data under another's sender ID. From then on its name is trusted if
+// it is the one identity.peers lists for the key.
+//
+// Peers that do not list each other exchange no sender keys and cannot read each
+// other's data frames. Other control messages (keepalives, pings, path MTU probes)
+// remain under the mesh key, and so do data frames from peers without identity.key:
+// rules by address cannot tell such frames from forgeries.
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+)
+
+const (
+	// helloSignaturePrefix separates hello signatures from anything else signed with the key
+	helloSignaturePrefix = "shadowmesh hello\x00"
+
+	// senderKeyInfo separates the key protecting a sender key from other uses of the key shares
+	senderKeyInfo = "shadowmesh sender key\x00"
+)
+
+// helloSignedData returns what a hello's signature covers: the sender ID, the key share and the name
+func helloSignedData(senderID uint64, keyShare []byte, name string) []byte {
+	data := make([]byte, 0, len(helloSignaturePrefix)+9+len(keyShare)+len(name))
+	data = append(data, helloSignaturePrefix...)
+	data = binary.BigEndian.AppendUint64(data, senderID)
+	data = append(data, byte(len(keyShare)))
+	data = append(data, keyShare...)
+	return append(data, name...)
+}
+
+// identityKey returns the key signing our hellos; nil without identity.key
+func (c *DaemonConfig) identityKey() ed25519.PrivateKey {
+	seed, err := hex.DecodeString(c.Identity.Key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// peerKeys returns the public keys of identity.peers by name
+func (c *DaemonConfig) peerKeys() map[string]ed25519.PublicKey {
+	keys := make(map[string]ed25519.PublicKey, len(c.Identity.Peers))
+	for name, value := range c.Identity.Peers {
+		if key, err := hex.DecodeString(value); err == nil && len(key) == ed25519.PublicKeySize {
+			keys[magicdns.Label(name)] = key
+		}
+	}
+	return keys
+}
+
+// newSenderKey returns a random sender key and the key share it is sent under
+func newSenderKey() ([32]byte, *ecdh.PrivateKey, error) {
+	var senderKey [32]byte
+	if _, err := rand.Read(senderKey[:]); err != nil {
+		return senderKey, nil, fmt.Errorf("failed to generate sender key: %w", err)
+	}
+	keyShare, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return senderKey, nil, fmt.Errorf("failed to generate key share: %w", err)
+	}
+	return senderKey, keyShare, nil
+}
+
+// signHello signs the name and key share in a hello with identity.key
+func (dm *DaemonManager) signHello(msg *ControlMessage) {
+	key := dm.cfg().identityKey()
+	if key == nil || msg.Name == "" || dm.encryptionPipeline == nil {
+		return
+	}
+	var keyShare []byte
+	if dm.keyShare != nil {
+		keyShare = dm.keyShare.PublicKey().Bytes()
+		msg.KeyShare = hex.EncodeToString(keyShare)
+	}
+	signature := ed25519.Sign(key, helloSignedData(dm.encryptionPipeline.SenderID(), keyShare, msg.Name))
+	msg.Identity = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	msg.Signature = hex.EncodeToString(signature)
+}
+
+// helloIdentity returns the key that signed a hello and the key share it carries
+// Both are nil if the hello is unsigned or the signature is invalid; the key share
+// is also nil if the hello has none.
+func helloIdentity(senderID uint64, msg *ControlMessage) (ed25519.PublicKey, *ecdh.PublicKey) {
+	key, err := hex.DecodeString(msg.Identity)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, nil
+	}
+	keyShare, err := hex.DecodeString(msg.KeyShare)
+	if err != nil {
+		return nil, nil
+	}
+	signature, err := hex.DecodeString(msg.Signature)
+	if err != nil || !ed25519.Verify(key, helloSignedData(senderID, keyShare, msg.Name), signature) {
+		return nil, nil
+	}
+	share, err := ecdh.X25519().NewPublicKey(keyShare)
+	if err != nil {
+		return key, nil
+	}
+	return key, share
+}
+
+// senderKeyCipher returns the AEAD protecting a sender key sent from one sender ID to another
+// Its key is derived from our key share and the peer's, so only the two of us hold it.
+func senderKeyCipher(private *ecdh.PrivateKey, share *ecdh.PublicKey, from, to uint64) (cipher.AEAD, error) {
+	secret, err := private.ECDH(share)
+	if err != nil {
+		return nil, err
+	}
+	info := binary.BigEndian.AppendUint64([]byte(senderKeyInfo), from)
+	info = binary.BigEndian.AppendUint64(info, to)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// sendSenderKey sends our sender key to a peer, encrypted to its key share
+func (dm *DaemonManager) sendSenderKey(senderID uint64, share *ecdh.PublicKey) error {
+	from := dm.encryptionPipeline.SenderID()
+	aead, err := senderKeyCipher(dm.keyShare, share, from, senderID)
+	if err != nil {
+		return fmt.Errorf("failed to derive sender key cipher: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dm.senderKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, dm.senderKey[:], nil)
+	return dm.sendControl(&ControlMessage{Type: ControlSenderKey, To: senderID, SenderKey: hex.EncodeToString(sealed)})
+}
+
+// openSenderKey decrypts the sender key a peer sent us
+func (dm *DaemonManager) openSenderKey(senderID uint64, share *ecdh.PublicKey, msg *ControlMessage) ([32]byte, error) {
+	var senderKey [32]byte
+	sealed, err := hex.DecodeString(msg.SenderKey)
+	if err != nil {
+		return senderKey, fmt.Errorf("invalid sender key: %w", err)
+	}
+	aead, err := senderKeyCipher(dm.keyShare, share, senderID, dm.encryptionPipeline.SenderID())
+	if err != nil {
+		return senderKey, fmt.Errorf("failed to derive sender key cipher: %w", err)
+	}
+	if len(sealed) != aead.NonceSize()+len(senderKey)+aead.Overhead() {
+		return senderKey, fmt.Errorf("invalid sender key length %d", len(sealed))
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return senderKey, fmt.Errorf("sender key does not authenticate: %w", err)
+	}
+	copy(senderKey[:], plaintext)
+	return senderKey, nil
+}
+
+// listedIdentity reports whether identity is one of the keys in identity.peers
+func listedIdentity(keys map[string]ed25519.PublicKey, identity ed25519.PublicKey) bool {
+	if identity == nil {
+		return false
+	}
+	for _, key := range keys {
+		if identity.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSenderKey binds a peer's data frames to the sender key it sent us
+// Only a key from a peer whose hello is signed with a key in identity.peers is
+// accepted; from then on its hellos must be signed by that key (see handleHello).
+func (dm *DaemonManager) handleSenderKey(senderID uint64, msg *ControlMessage) {
+	if dm.keyShare == nil || msg.To != dm.encryptionPipeline.SenderID() {
+		return
+	}
+	keys := dm.cfg().peerKeys()
+
+	dm.peersMu.Lock()
+	peer, known := dm.peers[senderID]
+	if !known || peer.keyShare == nil || !listedIdentity(keys, peer.identity) {
+		dm.peersMu.Unlock()
+		controlLogger.Warn("ignoring sender key from a peer not proven by identity.peers", senderAttr(senderID))
+		return
+	}
+	senderKey, err := dm.openSenderKey(senderID, peer.keyShare, msg)
+	if err != nil {
+		dm.peersMu.Unlock()
+		controlLogger.Warn("ignoring sender key", senderAttr(senderID), "error", err)
+		return
+	}
+	rebound := peer.senderKey
+	dm.boundSenders[senderID] = peer.identity
+	peer.senderKey = true
+	name := peer.name
+	dm.peersMu.Unlock()
+
+	dm.encryptionPipeline.BindSender(senderID, senderKey)
+	if !rebound {
+		controlLogger.Info("data frames bound to the peer's sender key", senderAttr(senderID), "name", name)
+	}
+	dm.updatePeerSubnets()
+}
+
+// nameOwners returns which peer holds each name (dm.peersMu held)
+// byJoin lists the sender IDs in the order the peers joined. A name belongs to the
+// earliest peer whose hello proved it with the key in identity.peers and whose data
+// frames are bound to a sender key exchanged under it; our own name is held by our
+// sender ID.
+func (dm *DaemonManager) nameOwners(byJoin []uint64, keys map[string]ed25519.PublicKey) map[string]uint64 {
+	owners := make(map[string]uint64)
+	if name := dm.cfg().dnsName(); name != "" && dm.encryptionPipeline != nil {
+		owners[name] = dm.encryptionPipeline.SenderID()
+	}
+	for _, senderID := range byJoin {
+		peer := dm.peers[senderID]
+		if peer.name == "" || peer.identity == nil {
+			continue
+		}
+		if _, taken := owners[peer.name]; !taken && peer.senderKey && peer.identity.Equal(keys[peer.name]) {
+			owners[peer.name] = senderID
+		}
+	}
+	return owners
+}
+
+// validateIdentity checks the identity section, reporting problems through fail
+// With the ACL enabled, peer names in its rules must be listed in identity.peers:
+// no other peer's name is trusted, so such a rule could never match.
+func (c *DaemonConfig) validateIdentity(fail func(field, format string, args ...interface{})) {
+	if c.Identity.Key != "" && c.identityKey() == nil {
+		fail("identity.key", "identity.key must be 64 hex characters (a 32-byte Ed25519 seed)")
+	}
+	if (c.Identity.Key == "") != (len(c.Identity.Peers) == 0) {
+		fail("identity", "identity.key and identity.peers must be set together: sender keys are only exchanged between peers that list each other")
+	}
+	for _, name := range sortedKeys(c.Identity.Peers) {
+		if label := magicdns.Label(name); label != name {
+			fail("identity.peers", "identity.peers: %q is not a peer name, use %q", name, label)
+		}
+		if key, err := hex.DecodeString(c.Identity.Peers[name]); err != nil || len(key) != ed25519.PublicKeySize {
+			fail("identity.peers", "identity.peers.%s must be 64 hex characters (an Ed25519 public key)", name)
+		}
+	}
+
+	if !c.ACL.Enabled {
+		return
+	}
+	var missing []string
+	for i := range c.ACL.Rules {
+		for _, name := range c.ACL.Rules[i].Names(c.ACL.Groups) {
+			if _, ok := c.Identity.Peers[name]; !ok && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+		}
+	}
+	for _, name := range missing {
+		fail("acl.rules", "acl.rules: peer %s is not in identity.peers, its name cannot be verified and no peer matches it", name)
+	}
+}
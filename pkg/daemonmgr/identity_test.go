@@ -0,0 +1,268 @@
+package daemonmgr
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// testIdentity returns a deterministic identity key for tests
+func testIdentity(b byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(rune('a'+b)), ed25519.SeedSize)))
+}
+
+// testIdentityKey is identity.key of test daemons that verify peer names
+var testIdentityKey = hex.EncodeToString(testIdentity(25).Seed())
+
+// proveHello hands dm a hello from senderID signed with key if not nil, followed by the
+// peer's sender key as a daemon would send it after reading dm's key share
+func proveHello(t *testing.T, dm *DaemonManager, senderID uint64, msg *ControlMessage, key ed25519.PrivateKey) {
+	t.Helper()
+
+	if key == nil {
+		dm.handleHello(senderID, msg)
+		return
+	}
+	senderKey, keyShare, err := newSenderKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Identity = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	msg.KeyShare = hex.EncodeToString(keyShare.PublicKey().Bytes())
+	msg.Signature = hex.EncodeToString(ed25519.Sign(key, helloSignedData(senderID, keyShare.PublicKey().Bytes(), msg.Name)))
+	dm.handleHello(senderID, msg)
+
+	// The peer's view of dm: its sender key goes to dm's key share
+	if dm.keyShare == nil {
+		return
+	}
+	peer := &DaemonManager{senderKey: senderKey, keyShare: keyShare, encryptionPipeline: testPipeline(t, senderID), controlOut: make(chan []byte, 1)}
+	if err := peer.sendSenderKey(dm.encryptionPipeline.SenderID(), dm.keyShare.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	var sent ControlMessage
+	if err := json.Unmarshal(<-peer.controlOut, &sent); err != nil {
+		t.Fatal(err)
+	}
+	dm.handleSenderKey(senderID, &sent)
+}
+
+// testPipeline returns a stopped encryption pipeline sending as senderID
+func testPipeline(t *testing.T, senderID uint64) *frameencryption.EncryptionPipeline {
+	t.Helper()
+
+	pipeline, err := frameencryption.NewEncryptionPipeline(&frameencryption.PipelineConfig{SenderID: senderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipeline
+}
+
+// signedHello hands dm a hello from senderID announcing name, signed with key if not nil
+func signedHello(t *testing.T, dm *DaemonManager, senderID uint64, address, name string, key ed25519.PrivateKey) {
+	t.Helper()
+	proveHello(t, dm, senderID, &ControlMessage{Type: ControlHello, Reply: true, Addresses: []string{address}, Name: name}, key)
+}
+
+// icmpEcho returns an IPv4 ICMP echo request from src to 10.77.0.1
+func icmpEcho(src string, id byte) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[3] = 28
+	packet[8] = 64
+	packet[9] = 1 // ICMP
+	copy(packet[12:16], netip.MustParseAddr(src).AsSlice())
+	copy(packet[16:20], netip.MustParseAddr("10.77.0.1").AsSlice())
+	packet[20] = 8 // Echo request
+	packet[25] = id
+	return packet
+}
+
+// TestPeerNameIdentity tests that ACL rules only match a name signed with its key in identity.peers
+func TestPeerNameIdentity(t *testing.T) {
+	alice, mallory := testIdentity(0), testIdentity(1)
+	dm := newTestDaemon(t, func(config *DaemonConfig) {
+		config.Identity.Key = testIdentityKey
+		config.Identity.Peers = map[string]string{"alice": hex.EncodeToString(alice.Public().(ed25519.PublicKey))}
+		config.ACL.Enabled = true
+		config.ACL.DefaultInbound = acl.Deny
+		config.ACL.Rules = []acl.Rule{{Action: acl.Allow, Peers: []string{"alice"}, Protocol: "icmp"}}
+	})
+
+	// A signature binds the name to the sender ID it was made for
+	replayed := &ControlMessage{Type: ControlHello, Reply: true, Addresses: []string{"10.77.0.5"}, Name: "alice",
+		Identity:  hex.EncodeToString(alice.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(alice, helloSignedData(0x0a, nil, "alice")))}
+
+	tests := []struct {
+		name         string
+		senderID     uint64
+		address      string
+		hello        func(senderID uint64, address string)
+		wantVerified bool
+		wantConflict bool
+	}{
+		{"unsigned before alice", 0x01, "10.77.0.3", func(id uint64, addr string) { signedHello(t, dm, id, addr, "alice", nil) }, false, true},
+		{"alice", 0x0a, "10.77.0.2", func(id uint64, addr string) { signedHello(t, dm, id, addr, "alice", alice) }, true, false},
+		{"unsigned", 0x0b, "10.77.0.4", func(id uint64, addr string) { signedHello(t, dm, id, addr, "alice", nil) }, false, true},
+		{"signed with another key", 0x0c, "10.77.0.6", func(id uint64, addr string) { signedHello(t, dm, id, addr, "alice", mallory) }, false, true},
+		{"replayed signature", 0x0d, "10.77.0.5", func(id uint64, addr string) { dm.handleHello(id, replayed) }, false, true},
+	}
+	for _, tt := range tests {
+		tt.hello(tt.senderID, tt.address)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := peerByID(t, dm, tt.senderID)
+			if status.NameVerified != tt.wantVerified || status.NameConflict != tt.wantConflict {
+				t.Errorf("NameVerified, NameConflict = %v, %v, want %v, %v", status.NameVerified, status.NameConflict, tt.wantVerified, tt.wantConflict)
+			}
+			allowed := dm.aclAllows(acl.Inbound, nil, tt.senderID, layer2.EtherTypeIPv4, icmpEcho(tt.address, byte(i)))
+			if allowed != tt.wantVerified {
+				t.Errorf("aclAllows() = %v, want %v", allowed, tt.wantVerified)
+			}
+		})
+	}
+}
+
+// TestConfigValidateIdentity tests checks on the identity section and ACL names missing from it
+func TestConfigValidateIdentity(t *testing.T) {
+	key := strings.Repeat("cd", 32)
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nidentity:\n  key: abc\n  peers:\n    Laptop: "+key+"\n    bob: xyz\n"+keySection)
+	expectErrors(t, errs, "identity.key must be 64 hex characters", "\"Laptop\" is not a peer name, use \"laptop\"", "identity.peers.bob must be 64 hex characters")
+
+	errs = validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\nidentity:\n  peers:\n    alice: "+key+"\nacl:\n  enabled: true\n  groups:\n    admins: [alice, bob, 10.0.0.9]\n  rules:\n"+
+		"    - action: allow\n      peers: [\"group:admins\", carol]\n"+keySection)
+	expectErrors(t, errs, "identity.key and identity.peers must be set together", "peer bob is not in identity.peers", "peer carol is not in identity.peers")
+}
+
+// TestResolvePeerIdentity tests that only verified names resolve, and that other claims are reported
+func TestResolvePeerIdentity(t *testing.T) {
+	alice, bob := testIdentity(0), testIdentity(1)
+	dm := newTestDaemon(t, func(config *DaemonConfig) {
+		config.DNS.Enabled = true
+		config.DNS.Name = "self"
+		config.Identity.Key = testIdentityKey
+		config.Identity.Peers = map[string]string{
+			"alice": hex.EncodeToString(alice.Public().(ed25519.PublicKey)),
+			"bob":   hex.EncodeToString(bob.Public().(ed25519.PublicKey)),
+			"self":  hex.EncodeToString(bob.Public().(ed25519.PublicKey)),
+		}
+	})
+
+	signedHello(t, dm, 0x01, "10.77.0.9", "alice", nil) // Mallory's lower sender ID must not matter
+	signedHello(t, dm, 0x0a, "10.77.0.2", "alice", alice)
+	signedHello(t, dm, 0x0b, "10.77.0.3", "bob", bob)
+	signedHello(t, dm, 0x0c, "10.77.0.4", "self", bob) // Our own name stays ours
+	signedHello(t, dm, 0x0d, "10.77.0.5", "carol", nil)
+
+	tests := []struct {
+		name string
+		want []netip.Addr
+	}{
+		{"alice", []netip.Addr{netip.MustParseAddr("10.77.0.2")}},
+		{"bob", []netip.Addr{netip.MustParseAddr("10.77.0.3")}},
+		{"self", []netip.Addr{netip.MustParseAddr("10.77.0.1")}},
+		{"carol", nil},
+	}
+	for _, tt := range tests {
+		if got := dm.resolvePeer(tt.name); !slices.Equal(got, tt.want) {
+			t.Errorf("resolvePeer(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	status := dm.dnsStatus()
+	if !slices.Equal(status.Conflicts, []string{"alice", "self"}) || !slices.Equal(status.Unverified, []string{"carol"}) {
+		t.Errorf("Conflicts, Unverified = %v, %v, want [alice self], [carol]", status.Conflicts, status.Unverified)
+	}
+}
+
+// TestNetworkACL tests that the ACL applies in every network, with rules scoped to networks
+func TestNetworkACL(t *testing.T) {
+	alice := testIdentity(0)
+	dm := newTestDaemon(t, func(config *DaemonConfig) {
+		config.Network.Mode = layer2.ModeTAP
+		config.Networks = []VirtualNetwork{{ID: "office", Key: testConfigKey}, {ID: "lab", Key: testConfigKey, VLAN: 20}, {ID: "guest", Key: testConfigKey, VLAN: 30}}
+		config.Identity.Key = testIdentityKey
+		config.Identity.Peers = map[string]string{"alice": hex.EncodeToString(alice.Public().(ed25519.PublicKey))}
+		config.ACL.Enabled = true
+		config.ACL.DefaultInbound = acl.Deny
+		config.ACL.Rules = []acl.Rule{{Action: acl.Allow, Peers: []string{"alice"}, Protocol: "icmp", Networks: []string{"lab"}}}
+	})
+	signedHello(t, dm, 0x0a, "10.77.0.2", "alice", alice)
+
+	office := &virtualNetwork{VirtualNetwork: VirtualNetwork{ID: "office"}}
+	lab := &virtualNetwork{VirtualNetwork: VirtualNetwork{ID: "lab", VLAN: 20}}
+	tests := []struct {
+		name    string
+		network *virtualNetwork
+		src     string
+		want    bool
+	}{
+		{"rule's network", lab, "10.81.0.2", true},
+		{"primary network", office, "10.77.0.2", false},
+		{"default action off the primary network", &virtualNetwork{VirtualNetwork: VirtualNetwork{ID: "guest", VLAN: 30}}, "10.82.0.2", false},
+	}
+	for i, tt := range tests {
+		if got := dm.aclAllows(acl.Inbound, tt.network, 0x0a, layer2.EtherTypeIPv4, icmpEcho(tt.src, byte(i))); got != tt.want {
+			t.Errorf("%s: aclAllows() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	errs := validateConfig(t, "network:\n  local_ip: 10.0.0.1/24\n  mode: tap\nnetworks:\n  - id: office\n    key: "+testConfigKey+"\nacl:\n  enabled: true\n  rules:\n"+
+		"    - action: allow\n      networks: [office, lab]\n"+keySection)
+	expectErrors(t, errs, "acl.rules[0].networks: lab is not in networks")
+}
+
+// TestSenderKey tests that sender keys are only exchanged with peers proven by identity.peers,
+// and that a bound sender ID cannot be taken over by another key
+func TestSenderKey(t *testing.T) {
+	alice, mallory := testIdentity(0), testIdentity(1)
+	dm := newTestDaemon(t, func(config *DaemonConfig) {
+		config.Identity.Key = testIdentityKey
+		config.Identity.Peers = map[string]string{"alice": hex.EncodeToString(alice.Public().(ed25519.PublicKey))}
+	})
+	const aliceID, malloryID = 0x0a, 0x0b
+
+	signedHello(t, dm, aliceID, "10.77.0.2", "alice", alice)
+	signedHello(t, dm, malloryID, "10.77.0.3", "mallory", mallory)
+
+	// Only alice is sent our sender key, and only hers is bound: mallory is not in identity.peers
+	var sentTo []uint64
+	for len(dm.controlOut) > 0 {
+		var msg ControlMessage
+		if err := json.Unmarshal(<-dm.controlOut, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == ControlSenderKey {
+			sentTo = append(sentTo, msg.To)
+		}
+	}
+	if !slices.Equal(sentTo, []uint64{aliceID}) {
+		t.Errorf("sender keys sent to %v, want [%x]", sentTo, aliceID)
+	}
+
+	dm.peersMu.RLock()
+	_, aliceBound := dm.boundSenders[aliceID]
+	_, malloryBound := dm.boundSenders[malloryID]
+	dm.peersMu.RUnlock()
+	if !aliceBound || malloryBound {
+		t.Errorf("alice, mallory bound = %v, %v, want true, false", aliceBound, malloryBound)
+	}
+
+	// Hellos under alice's sender ID must be signed by her key
+	signedHello(t, dm, aliceID, "10.77.0.4", "alice", nil)
+	signedHello(t, dm, aliceID, "10.77.0.4", "alice", mallory)
+	status := peerByID(t, dm, aliceID)
+	if !status.NameVerified || !slices.Equal(status.Addresses, []string{"10.77.0.2"}) {
+		t.Errorf("alice's NameVerified, Addresses = %v, %v, want true, [10.77.0.2]", status.NameVerified, status.Addresses)
+	}
+}
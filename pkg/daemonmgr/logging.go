@@ -0,0 +1,59 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
+)
+
+// Component loggers; daemon.log_levels sets their levels by these names
+var (
+	logger          = logging.Logger("daemon")
+	apiLogger       = logging.Logger("api")
+	p2pLogger       = logging.Logger("p2p")
+	routerLogger    = logging.Logger("router")
+	controlLogger   = logging.Logger("control")
+	natLogger       = logging.Logger("nat")
+	pmtuLogger      = logging.Logger("pmtu")
+	fecLogger       = logging.Logger("fec")
+	multipathLogger = logging.Logger("multipath")
+	dnsLogger       = logging.Logger("dns")
+)
+
+// Per-frame warnings are rate limited per call site; the drops are still counted in /status and /metrics
+var (
+	pipelineFullLog = logging.NewLimiter(time.Second)
+	controlFullLog  = logging.NewLimiter(time.Second)
+	sendFailedLog   = logging.NewLimiter(time.Second)
+	invalidFrameLog = logging.NewLimiter(time.Second)
+	decryptFullLog  = logging.NewLimiter(time.Second)
+	deviceFullLog   = logging.NewLimiter(time.Second)
+	deviceErrorLog  = logging.NewLimiter(time.Second)
+	mismatchedLog   = logging.NewLimiter(time.Second)
+	spoofedLog      = logging.NewLimiter(time.Second)
+	aclDeniedLog    = logging.NewLimiter(time.Second)
+	suppressedLog   = logging.NewLimiter(time.Second)
+	networkLog      = logging.NewLimiter(time.Second)
+
+	recvFullLog          = logging.NewLimiter(time.Second)
+	unexpectedMessageLog = logging.NewLimiter(time.Second)
+	fecErrorLog          = logging.NewLimiter(time.Second)
+	invalidControlLog    = logging.NewLimiter(time.Second)
+	rejectedLog          = logging.NewLimiter(time.Second)
+)
+
+// LoggingOptions returns the logging settings of the daemon section
+func (c *DaemonConfig) LoggingOptions() logging.Options {
+	return logging.Options{
+		Level:      c.Daemon.LogLevel,
+		Format:     c.Daemon.LogFormat,
+		Components: c.Daemon.LogLevels,
+	}
+}
+
+// senderAttr formats a peer's sender ID the way /peers reports it
+func senderAttr(senderID uint64) slog.Attr {
+	return slog.String("sender", fmt.Sprintf("%016x", senderID))
+}
@@ -2,37 +2,106 @@ package daemonmgr
 
 import (
 	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
 	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
 	"github.com/shadowmesh/shadowmesh/pkg/crypto/symmetric"
+	"github.com/shadowmesh/shadowmesh/pkg/exitnode"
 	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
 	"github.com/shadowmesh/shadowmesh/pkg/nat"
+	"github.com/shadowmesh/shadowmesh/pkg/neighbor"
+	"github.com/shadowmesh/shadowmesh/pkg/pmtu"
 )
 
 // DaemonConfig contains complete daemon configuration
 type DaemonConfig struct {
 	Daemon struct {
-		ListenAddress string `yaml:"listen_address"` // HTTP API address
-		LogLevel      string `yaml:"log_level"`
+		ListenAddress string            `yaml:"listen_address"` // TCP API address for remote management; only served with api_token
+		LogLevel      string            `yaml:"log_level"`
+		LogFormat     string            `yaml:"log_format"`     // "text" or "json" (default: text)
+		LogLevels     map[string]string `yaml:"log_levels"`     // Levels for single components, e.g. {nat: debug}
+		Socket        string            `yaml:"socket"`         // Unix socket for local control (default: /run/shadowmesh/daemon.sock)
+		SocketMode    string            `yaml:"socket_mode"`    // Socket file mode in octal (default: "0660")
+		SocketGroup   string            `yaml:"socket_group"`   // Group whose members may use the socket (default: root and the daemon user only)
+		APIToken      string            `yaml:"api_token"`      // Bearer token required on the TCP API
+		APITokenFile  string            `yaml:"api_token_file"` // File holding api_token instead (mode 0600 or stricter)
 	} `yaml:"daemon"`
 
 	Network struct {
-		Mode      string `yaml:"mode"`       // "tap" or "tun" (default: "tun" on macOS)
-		TAPDevice string `yaml:"tap_device"` // Device name (for backward compatibility)
-		DeviceName string `yaml:"device_name"` // Device name (preferred)
-		LocalIP   string `yaml:"local_ip"` // IP with CIDR (e.g., "10.0.0.1/24")
+		Mode       string   `yaml:"mode"`        // "tap" or "tun" (default: "tun" on macOS)
+		TAPDevice  string   `yaml:"tap_device"`  // Device name (for backward compatibility)
+		DeviceName string   `yaml:"device_name"` // Device name (preferred)
+		LocalIP    string   `yaml:"local_ip"`    // IP with CIDR (e.g., "10.0.0.1/24"), or "auto" to have the relay assign it
+		LocalIPv6  string   `yaml:"local_ipv6"`  // IPv6 address with prefix length (e.g., "fd00:5d::1/64")
+		MTU        int      `yaml:"mtu"`         // Fixed device MTU (default: derived from the path MTU)
+		Routes     []string `yaml:"routes"`      // Subnets reached through the mesh, routed to the device (e.g., a peer's LAN)
+
+		AdvertiseSubnets []string `yaml:"advertise_subnets"` // Local subnets this node routes for peers (site-to-site), announced in the hello
+		AcceptSubnets    []string `yaml:"accept_subnets"`    // Subnets peers may advertise: routed to the device and accepted as sources from that peer
+
+		PeerSubnets map[string][]string `yaml:"peer_subnets"` // Subnets each peer, by its name in identity.peers, may advertise; when set, no other peer may
+
+		ProxyNeighbors bool `yaml:"proxy_neighbors"` // Answer ARP and neighbour solicitations for peers locally (TAP mode)
+		BroadcastLimit int  `yaml:"broadcast_limit"` // Broadcast and multicast frames sent to peers per second (0: no limit)
 	} `yaml:"network"`
 
+	Networks []VirtualNetwork `yaml:"networks"` // Named virtual networks to join, each untagged, on a VLAN or on its own device (TAP mode)
+
+	ExitNode struct {
+		Advertise  bool     `yaml:"advertise"`   // Act as internet exit for peers: enable forwarding and masquerade their traffic
+		Interface  string   `yaml:"interface"`   // Uplink the exit masquerades out of (default: any interface but the tunnel)
+		AllowLAN   bool     `yaml:"allow_lan"`   // Let peers using the exit reach its local networks, not just the internet
+		Use        bool     `yaml:"use"`         // Route all traffic through a peer that advertises itself as exit
+		Peer       string   `yaml:"peer"`        // Name in identity.peers of the only peer used as exit
+		DNS        []string `yaml:"dns"`         // Redirect DNS queries to these servers while an exit is in use
+		KillSwitch bool     `yaml:"kill_switch"` // Block traffic leaving outside the tunnel, also while no exit is available
+	} `yaml:"exit_node"`
+
+	DNS struct {
+		Enabled  bool     `yaml:"enabled"`  // Resolve <peer name>.<domain> to tunnel addresses, forwarding other queries upstream
+		Domain   string   `yaml:"domain"`   // Domain peer names are resolved under (default: mesh.internal)
+		Name     string   `yaml:"name"`     // Name this node announces to peers (default: peer.id, else the hostname)
+		Listen   []string `yaml:"listen"`   // host:port addresses to serve on (default: the IPv4 tunnel address, port 53)
+		Upstream []string `yaml:"upstream"` // Servers other queries are forwarded to (default: the name servers in /etc/resolv.conf)
+	} `yaml:"dns"`
+
+	ACL struct {
+		Enabled         bool                `yaml:"enabled"`          // Filter the packets exchanged with peers by rules
+		DefaultInbound  string              `yaml:"default_inbound"`  // "allow" or "deny" connections from peers no rule matches (default: deny)
+		DefaultOutbound string              `yaml:"default_outbound"` // "allow" or "deny" connections to peers no rule matches (default: allow)
+		Groups          map[string][]string `yaml:"groups"`           // Named lists of peer names and addresses, referred to as "group:<name>"
+		Rules           []acl.Rule          `yaml:"rules"`            // Checked in order; the first match decides
+	} `yaml:"acl"`
+
+	PathMTU struct {
+		Discovery bool `yaml:"discovery"` // Probe the path MTU to the peer (DPLPMTUD) and resize the device
+		MaxSize   int  `yaml:"max_size"`  // Largest tunnel datagram in bytes (default: 1472)
+	} `yaml:"path_mtu"`
+
 	Encryption struct {
-		Key string `yaml:"key"` // Hex-encoded 32-byte key
+		Key              string        `yaml:"key"`               // Hex-encoded 32-byte key
+		KeyFile          string        `yaml:"key_file"`          // File holding key instead (mode 0600 or stricter)
+		RotationInterval time.Duration `yaml:"rotation_interval"` // Rotate the session transmit key this often (default: only at the nonce limit)
 	} `yaml:"encryption"`
 
+	Identity struct {
+		Key     string            `yaml:"key"`      // Hex-encoded 32-byte Ed25519 seed signing our name (shadowmesh keys identity)
+		KeyFile string            `yaml:"key_file"` // File holding key instead (mode 0600 or stricter)
+		Peers   map[string]string `yaml:"peers"`    // Public key of each peer name trusted by the ACL and MagicDNS
+	} `yaml:"identity"`
+
 	Peer struct {
 		Address string `yaml:"address"` // Peer address (set dynamically via CLI)
 		ID      string `yaml:"id"`      // Peer ID for relay mode
@@ -48,10 +117,41 @@ type DaemonConfig struct {
 		Server  string `yaml:"server"`  // Relay server URL (e.g., ws://94.237.121.21:9545/relay)
 	} `yaml:"relay"`
 
+	Reconnect struct {
+		InitialBackoff time.Duration `yaml:"initial_backoff"` // Delay before the first retry (default: 1s)
+		MaxBackoff     time.Duration `yaml:"max_backoff"`     // Longest delay between retries (default: 1m)
+		MaxAttempts    int           `yaml:"max_attempts"`    // Give up after this many failed retries (default: 0, never)
+	} `yaml:"reconnect"`
+
 	P2P struct {
 		ListenerEnabled bool `yaml:"listener_enabled"` // Enable P2P listener for incoming connections (default: true)
 		ListenerPort    int  `yaml:"listener_port"`    // P2P listener port (default: 9545)
 	} `yaml:"p2p"`
+
+	Compression struct {
+		Enabled bool `yaml:"enabled"` // Compress our frames with lz4 once every peer's hello shows it can decode them
+	} `yaml:"compression"`
+
+	FEC struct {
+		Enabled         bool `yaml:"enabled"`           // Reed-Solomon parity on the direct UDP transport (used only if every peer supports it)
+		DataShards      int  `yaml:"data_shards"`       // Frames per FEC group (default: 8)
+		ParityShards    int  `yaml:"parity_shards"`     // Parity frames per group; the minimum when adaptive (default: 2)
+		MaxParityShards int  `yaml:"max_parity_shards"` // Upper bound for adaptive parity (default: 8)
+		Adaptive        bool `yaml:"adaptive"`          // Adjust parity to the loss measured from keepalives
+	} `yaml:"fec"`
+
+	Multipath struct {
+		Enabled    bool     `yaml:"enabled"`    // Bond a direct UDP path per local uplink (the peer must enable it too)
+		Interfaces []string `yaml:"interfaces"` // Uplinks to bond, e.g. [eth0, wwan0] (default: every interface with an IPv4 address)
+		Mode       string   `yaml:"mode"`       // "lowest_latency", "round_robin" or "redundant" (default: lowest_latency)
+	} `yaml:"multipath"`
+
+	Metrics struct {
+		ListenAddress string `yaml:"listen_address"` // Also serve Prometheus /metrics over plain TCP, e.g. "127.0.0.1:9101"
+	} `yaml:"metrics"`
+
+	path      string              // File the configuration was loaded from, re-read by Reload
+	positions map[string]position // Where each field was set in that file, for errors
 }
 
 // ConnectionState represents daemon connection state
@@ -62,6 +162,7 @@ const (
 	StateConnecting
 	StateConnected
 	StateError
+	StateReconnecting // The connection was lost (or never made) and is being retried
 )
 
 func (s ConnectionState) String() string {
@@ -74,6 +175,8 @@ func (s ConnectionState) String() string {
 		return "Connected"
 	case StateError:
 		return "Error"
+	case StateReconnecting:
+		return "Reconnecting"
 	default:
 		return "Unknown"
 	}
@@ -81,20 +184,28 @@ func (s ConnectionState) String() string {
 
 // DaemonManager manages the complete ShadowMesh daemon lifecycle
 type DaemonManager struct {
-	config *DaemonConfig
+	config   atomic.Pointer[DaemonConfig] // Replaced as a whole by Reload
+	reloadMu sync.Mutex
 
 	// Epic 2 Components
 	tapDevice          layer2.NetworkDevice // Supports both TAP and TUN
 	encryptionPipeline *frameencryption.EncryptionPipeline
 	p2pConnection      *P2PConnection
-	natDetector        *nat.NATDetector
-	holePuncher        *nat.HolePuncher
+	natDetector        *nat.NATDetector // Replaced on reload (natMu)
+	holePuncher        *nat.HolePuncher // Replaced on reload (natMu)
+	natMu              sync.RWMutex
+	natResult          atomic.Pointer[NATStatus] // Detection result the daemon acted on
 	daemonAPI          *DaemonAPI
 
 	// State management
-	state     ConnectionState
-	stateMu   sync.RWMutex
-	lastError error
+	state       ConnectionState
+	stateMu     sync.RWMutex
+	lastError   error
+	connectedTo string // Address passed to Connect; empty for accepted connections
+
+	// Connection supervision (see reconnect.go)
+	session   *connectSession // Connection being kept up; nil when disconnected (stateMu)
+	connectMu sync.Mutex      // Serialises dialling and tearing down the transport
 
 	// Lifecycle
 	ctx    context.Context
@@ -105,18 +216,112 @@ type DaemonManager struct {
 	frameRouterStop    chan struct{}
 	frameRouterRunning bool
 	frameRouterMu      sync.Mutex
+
+	// Control channel (capability negotiation between daemons)
+	controlOut chan []byte
+	peers      map[uint64]*peerSession
+	peersMu    sync.RWMutex
+
+	// Sender keys (see identity.go); nil keyShare without identity.key
+	senderKey    [symmetric.KeySize]byte      // Keys our data frames
+	keyShare     *ecdh.PrivateKey             // Peers encrypt their sender keys to its public key
+	boundSenders map[uint64]ed25519.PublicKey // Identity each peer's data frames are bound to; kept across reconnects (peersMu)
+
+	// Subnets advertised by peers (see routes.go)
+	sources      atomic.Pointer[sourceFilter] // Source addresses each peer may use on delivery
+	subnetRoutes map[netip.Prefix]netip.Addr  // Peer subnets routed to the device, with their next hop (subnetMu)
+	subnetMu     sync.Mutex                   // Held while updating sources and subnetRoutes
+
+	// Exit node mode (see exitnode.go)
+	exitController exitnode.Controller // Applies forwarding, masquerade, kill switch and DNS redirection to the host
+	exitActive     bool                // Traffic is routed through exitPeer (subnetMu)
+	exitPeer       uint64              // Sender ID of the exit in use (subnetMu)
+
+	// Peer name resolution (see dns.go)
+	dnsServer       *magicdns.Server
+	dnsConfigurator magicdns.Configurator // Points the host's resolver at dnsServer for the mesh domain
+	dnsMu           sync.Mutex            // Held while starting and stopping dnsServer
+
+	// Packet filtering by the acl section; nil while disabled (see acl.go)
+	aclEngine atomic.Pointer[acl.Engine]
+
+	// ARP/ND proxying and flood suppression in TAP mode (see neighbor.go)
+	neighbors          *neighbor.Table
+	suppressor         *neighbor.Suppressor
+	neighborAnswered   atomic.Uint64
+	neighborSuppressed atomic.Uint64
+
+	// Virtual networks from the networks section; nil without (see networks.go)
+	networks       *networkTable
+	networkDevices map[string]layer2.NetworkDevice // Devices set with SetVirtualNetworkDevice, by network
+
+	// Tunnel addresses assigned by the relay with network.local_ip: auto (see addressing.go)
+	assigned atomic.Pointer[[]netip.Prefix]
+
+	// Outstanding pings by ID, completed when the pong arrives
+	pings   map[uint32]*pendingPing
+	pingsMu sync.Mutex
+
+	// Path MTU discovery (one prober per connection)
+	prober    *pmtu.Prober
+	proberMu  sync.RWMutex
+	tunnelMTU atomic.Int64 // Largest IP packet that crosses the tunnel unfragmented
+
+	// Multipath bonding (paths are added once the peer has negotiated it)
+	multipathMode    multipath.Mode
+	multipathStarted bool
+	multipathMu      sync.Mutex
+
+	// Time-based key rotation; signalled when encryption.rotation_interval changes
+	rotationChanged chan struct{}
+
+	// Typed events for /events subscribers
+	events *eventBus
+
+	// Prometheus metrics for /metrics
+	metrics *daemonMetrics
+}
+
+// cfg returns the configuration in effect
+func (dm *DaemonManager) cfg() *DaemonConfig {
+	return dm.config.Load()
 }
 
 // NewDaemonManager creates a new daemon manager
 func NewDaemonManager(config *DaemonConfig) (*DaemonManager, error) {
+	multipathMode, err := multipath.ParseMode(config.Multipath.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipath configuration: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	dm := &DaemonManager{
-		config:          config,
 		state:           StateDisconnected,
 		ctx:             ctx,
 		cancel:          cancel,
 		frameRouterStop: make(chan struct{}),
+		controlOut:      make(chan []byte, 16),
+		peers:           make(map[uint64]*peerSession),
+		boundSenders:    make(map[uint64]ed25519.PublicKey),
+		pings:           make(map[uint32]*pendingPing),
+		subnetRoutes:    make(map[netip.Prefix]netip.Addr),
+		multipathMode:   multipathMode,
+		rotationChanged: make(chan struct{}, 1),
+		events:          newEventBus(),
+		neighbors:       neighbor.NewTable(neighbor.DefaultTTL),
+		suppressor:      neighbor.NewSuppressor(config.Network.BroadcastLimit),
+	}
+	dm.config.Store(config)
+	dm.metrics = newDaemonMetrics(dm)
+
+	if config.ACL.Enabled {
+		engine, err := acl.NewEngine(config.aclConfig())
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("invalid acl configuration: %w", err)
+		}
+		dm.aclEngine.Store(engine)
 	}
 
 	return dm, nil
@@ -124,22 +329,34 @@ func NewDaemonManager(config *DaemonConfig) (*DaemonManager, error) {
 
 // Start initializes and starts the daemon
 func (dm *DaemonManager) Start(ctx context.Context) error {
-	log.Printf("Initializing ShadowMesh daemon components...")
+	logger.Info("initializing daemon components")
 
 	// Phase 1: Initialize TAP device
 	if err := dm.initTAPDevice(); err != nil {
 		return fmt.Errorf("TAP device initialization failed: %w", err)
 	}
 
+	// Exit node mode: forwarding on an exit, the kill switch on a client before anything connects
+	if err := dm.startExitNode(); err != nil {
+		dm.stopExitNode()
+		return fmt.Errorf("exit node initialization failed: %w", err)
+	}
+
+	// Peer name resolution on the tunnel address
+	if err := dm.startDNS(); err != nil {
+		dm.stopExitNode()
+		return err
+	}
+
 	// Phase 2: Initialize encryption pipeline
 	if err := dm.initEncryptionPipeline(); err != nil {
 		return fmt.Errorf("encryption pipeline initialization failed: %w", err)
 	}
 
 	// Phase 3: Initialize NAT components (optional)
-	if dm.config.NAT.Enabled {
+	if dm.cfg().NAT.Enabled {
 		if err := dm.initNATComponents(); err != nil {
-			log.Printf("⚠️  NAT initialization failed (continuing anyway): %v", err)
+			natLogger.Warn("NAT initialization failed, continuing without it", "error", err)
 		}
 	}
 
@@ -151,9 +368,9 @@ func (dm *DaemonManager) Start(ctx context.Context) error {
 	// Phase 5: Start P2P WebSocket listener (optional)
 	// Skip if explicitly disabled (useful for relay-only mode or multi-node testing on same machine)
 	listenerEnabled := true
-	if dm.config.P2P.ListenerEnabled == false {
+	if dm.cfg().P2P.ListenerEnabled == false {
 		listenerEnabled = false
-		log.Printf("P2P listener disabled by configuration")
+		p2pLogger.Info("P2P listener disabled by configuration")
 	}
 
 	if listenerEnabled {
@@ -162,58 +379,48 @@ func (dm *DaemonManager) Start(ctx context.Context) error {
 		}
 	}
 
-	log.Printf("✅ All daemon components initialized successfully")
-
-	// Phase 6: Auto-connect to relay/peer if configured
-	if dm.config.Relay.Enabled && dm.config.Relay.Server != "" {
-		log.Printf("Auto-connecting to relay server: %s", dm.config.Relay.Server)
-
-		// Start relay connection in background to avoid blocking startup
-		dm.wg.Add(1)
-		go func() {
-			defer dm.wg.Done()
-
-			// Wait a moment for all components to be fully ready
-			time.Sleep(1 * time.Second)
-
-			if err := dm.Connect(dm.config.Relay.Server); err != nil {
-				log.Printf("⚠️  Auto-connect to relay failed: %v", err)
-				log.Printf("   Daemon still running - use API to connect manually")
-			} else {
-				log.Printf("✅ Connected to relay server: %s", dm.config.Relay.Server)
-			}
-		}()
-	} else if dm.config.Peer.Address != "" {
-		log.Printf("Auto-connecting to configured peer: %s", dm.config.Peer.Address)
-
-		// Start connection in background to avoid blocking startup
-		dm.wg.Add(1)
-		go func() {
-			defer dm.wg.Done()
+	logger.Info("all daemon components initialized")
 
-			// Wait a moment for all components to be fully ready
-			time.Sleep(1 * time.Second)
+	// Phase 6: Rotate session keys on a timer if configured
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.keyRotationLoop()
+	}()
 
-			if err := dm.Connect(dm.config.Peer.Address); err != nil {
-				log.Printf("⚠️  Auto-connect failed: %v", err)
-				log.Printf("   Daemon still running - use API to connect manually")
-			}
-		}()
+	// Phase 7: Auto-connect to relay/peer if configured
+	if target := connectTarget(dm.cfg()); target != "" {
+		dm.autoConnect(target)
 	}
 
 	return nil
 }
 
+// connectTarget returns where the daemon connects on its own: the relay if enabled, else the configured peer
+func connectTarget(config *DaemonConfig) string {
+	if config.Relay.Enabled && config.Relay.Server != "" {
+		return config.Relay.Server
+	}
+	return config.Peer.Address
+}
+
 // Stop performs graceful shutdown
 func (dm *DaemonManager) Stop() error {
-	log.Printf("Stopping daemon components...")
+	logger.Info("stopping daemon components")
 
 	// Stop HTTP API server first (allows Ctrl+C to respond quickly)
 	if dm.daemonAPI != nil {
 		if err := dm.daemonAPI.Stop(); err != nil {
-			log.Printf("⚠️  Error stopping API server: %v", err)
+			apiLogger.Warn("error stopping API server", "error", err)
 		} else {
-			log.Printf("✅ API server stopped")
+			apiLogger.Info("API server stopped")
+		}
+	}
+
+	// Disconnect if connected, and stop reconnecting
+	if dm.hasSession() {
+		if err := dm.Disconnect(); err != nil {
+			logger.Warn("error disconnecting", "error", err)
 		}
 	}
 
@@ -222,25 +429,30 @@ func (dm *DaemonManager) Stop() error {
 		close(dm.frameRouterStop)
 	}
 
-	// Disconnect if connected
-	if dm.state == StateConnected {
-		if err := dm.Disconnect(); err != nil {
-			log.Printf("⚠️  Error disconnecting: %v", err)
-		}
+	// Stop the P2P listener
+	if dm.p2pConnection != nil {
+		dm.p2pConnection.Close()
 	}
 
 	// Stop encryption pipeline
 	if dm.encryptionPipeline != nil {
 		dm.encryptionPipeline.Stop()
-		log.Printf("✅ Encryption pipeline stopped")
+		logger.Info("encryption pipeline stopped")
 	}
 
-	// Close TAP device
+	// Remove the exit node and split DNS settings while the device still exists
+	dm.stopExitNode()
+	dm.stopDNS()
+
+	// Close the networks' own devices and the TAP device
+	if dm.networks != nil {
+		stopNetworkDevices(dm.networks.list)
+	}
 	if dm.tapDevice != nil {
 		if err := dm.tapDevice.Stop(); err != nil {
-			log.Printf("⚠️  Error stopping TAP device: %v", err)
+			logger.Warn("error stopping network device", "error", err)
 		} else {
-			log.Printf("✅ TAP device stopped")
+			logger.Info("network device stopped")
 		}
 	}
 
@@ -252,31 +464,84 @@ func (dm *DaemonManager) Stop() error {
 }
 
 // Connect establishes P2P connection to peer (or relay server)
-// Attempts direct UDP P2P first, then falls back to relay if needed
+// Attempts direct UDP P2P first, then falls back to relay if needed. Once connected,
+// a lost connection is retried with backoff until Disconnect.
 func (dm *DaemonManager) Connect(peerAddr string) error {
+	return dm.connect(dm.dialTargetFor(peerAddr))
+}
+
+// ConnectRelay connects through relayServer as peerID, whatever the relay configuration says
+func (dm *DaemonManager) ConnectRelay(relayServer, peerID string) error {
+	return dm.connect(dialTarget{relayServer: relayServer, peerID: peerID})
+}
+
+// dialTargetFor returns how Connect reaches peerAddr: through the relay if enabled
+func (dm *DaemonManager) dialTargetFor(peerAddr string) dialTarget {
+	config := dm.cfg()
+	target := dialTarget{peerAddr: peerAddr, peerID: config.Peer.ID}
+	if config.Relay.Enabled {
+		target.relayServer = config.Relay.Server
+	}
+	return target
+}
+
+// connect dials target once and, if that succeeds, keeps the connection up until Disconnect
+// A manual connection replaces a connection that is still being retried in the background.
+func (dm *DaemonManager) connect(target dialTarget) error {
 	dm.stateMu.Lock()
 	if dm.state == StateConnected {
 		dm.stateMu.Unlock()
 		return fmt.Errorf("already connected")
 	}
-	dm.state = StateConnecting
+	previous := dm.session
+	dm.session = nil
 	dm.stateMu.Unlock()
 
+	if previous != nil {
+		previous.cancel()
+	}
+
+	dm.connectMu.Lock()
+	defer dm.connectMu.Unlock()
+
+	dm.setState(StateConnecting, nil)
+	start := time.Now()
+	err := dm.dial(&target)
+	dm.metrics.handshakeDone(start, err)
+	if err != nil {
+		dm.setState(StateError, err)
+		return err
+	}
+
+	dm.superviseSession(dm.established(dm.newSession(target, false)))
+	return nil
+}
+
+// dial connects the transport to target: directly over UDP if NAT traversal allows, else
+// through the relay, else over a direct WebSocket
+// The relay and peer ID used are written back to target, so redials reuse them.
+// The caller holds connectMu and updates the state.
+func (dm *DaemonManager) dial(target *dialTarget) error {
+	peerAddr, relayServer, peerID := target.peerAddr, target.relayServer, target.peerID
+	config := dm.cfg()
+	natDetector, holePuncher := dm.natComponents()
+
 	// Initialize P2P connection if not already done
 	if dm.p2pConnection == nil {
-		dm.p2pConnection = NewP2PConnection()
+		dm.p2pConnection = dm.newP2PConnection()
 	}
 
 	// Strategy: Try direct UDP P2P first, fallback to relay if needed
 	directP2PSuccess := false
 
 	// Attempt direct UDP P2P if NAT components are available and peerAddr provided
-	if dm.config.NAT.Enabled && dm.natDetector != nil && dm.holePuncher != nil && peerAddr != "" {
-		log.Printf("Attempting direct UDP P2P connection to %s...", peerAddr)
+	// With local_ip: auto the addresses come from the relay, so it is always used
+	if config.NAT.Enabled && natDetector != nil && holePuncher != nil && peerAddr != "" && !config.autoAddress() {
+		natLogger.Info("attempting direct UDP connection", "peer", peerAddr)
 
 		// Check if NAT type is compatible with P2P
-		if dm.natDetector.IsP2PFeasible() {
-			log.Printf("NAT type is compatible with direct P2P, attempting UDP hole punching...")
+		if natDetector.IsP2PFeasible() {
+			natLogger.Info("NAT type allows direct P2P, hole punching")
 
 			// TODO: Exchange candidates with peer (requires signaling mechanism)
 			// For now, we'll create a simple candidate from the peer address
@@ -294,36 +559,40 @@ func (dm *DaemonManager) Connect(peerAddr string) error {
 				}
 
 				// Attempt UDP hole punching (500ms timeout)
-				udpConn, err := dm.holePuncher.EstablishConnection(remoteCandidates)
+				punchStart := time.Now()
+				udpConn, err := holePuncher.EstablishConnection(remoteCandidates)
+				if err == nil {
+					dm.metrics.holePunchDuration.ObserveDuration(time.Since(punchStart))
+				}
 				if err != nil {
-					log.Printf("⚠️  UDP hole punching failed: %v", err)
-					log.Printf("Falling back to relay mode...")
+					natLogger.Warn("UDP hole punching failed, falling back to relay", "peer", peerAddr, "error", err)
 				} else {
 					// UDP hole punching succeeded!
 					peerUDPAddr, _ := net.ResolveUDPAddr("udp", peerAddr)
+					dm.p2pConnection.SetMultipathConfig(multipath.Config{
+						Mode:         dm.multipathMode,
+						AcceptPaths:  config.Multipath.Enabled,
+						OnPathChange: dm.publishPathChange,
+					})
 					if err := dm.p2pConnection.ConnectUDP(udpConn, peerUDPAddr); err != nil {
-						log.Printf("⚠️  UDP connection setup failed: %v", err)
+						natLogger.Warn("UDP connection setup failed", "peer", peerAddr, "error", err)
 						udpConn.Close()
 					} else {
-						log.Printf("✅ Direct UDP P2P connection established to %s", peerAddr)
+						natLogger.Info("direct UDP connection established", "peer", peerAddr)
 						directP2PSuccess = true
 					}
 				}
 			}
 		} else {
-			log.Printf("NAT type not compatible with direct P2P (Symmetric NAT detected)")
-			log.Printf("Falling back to relay mode...")
+			natLogger.Info("symmetric NAT prevents direct P2P, falling back to relay")
 		}
 	}
 
 	// Fallback to relay mode if direct P2P failed or wasn't attempted
 	if !directP2PSuccess {
 		// Determine relay server address
-		// Priority: explicit relay.server > peer.address (if port 9545)
-		relayServer := ""
-		if dm.config.Relay.Enabled && dm.config.Relay.Server != "" {
-			relayServer = dm.config.Relay.Server
-		} else {
+		// Priority: explicit relay server > peer address (if port 9545)
+		if relayServer == "" {
 			// Check if peer address is a relay server (port 9545)
 			host, portStr, err := net.SplitHostPort(peerAddr)
 			if err == nil && (portStr == "9545" || portStr == "8545") {
@@ -334,10 +603,9 @@ func (dm *DaemonManager) Connect(peerAddr string) error {
 
 		if relayServer != "" {
 			// Generate peer ID if not configured
-			peerID := dm.config.Peer.ID
 			if peerID == "" {
 				// Use local IP as peer ID
-				ip, _, _ := net.ParseCIDR(dm.config.Network.LocalIP)
+				ip, _, _ := net.ParseCIDR(config.Network.LocalIP)
 				if ip != nil {
 					peerID = fmt.Sprintf("peer-%s", ip.String())
 				} else {
@@ -345,54 +613,79 @@ func (dm *DaemonManager) Connect(peerAddr string) error {
 				}
 			}
 
-			log.Printf("Connecting via relay server: %s (peer ID: %s)", relayServer, peerID)
+			logger.Info("connecting via relay", "relay", relayServer, "peer_id", peerID)
 
 			// Enable relay mode
 			dm.p2pConnection.EnableRelayMode(relayServer, peerID)
+			dm.p2pConnection.RequireAssignment(config.autoAddress())
+			dm.p2pConnection.ClaimLease(config.identityKey(), config.staticAddress())
+			dm.p2pConnection.JoinNetworks(config.networkIDs())
 
 			// Connect to relay server
 			if err := dm.p2pConnection.ConnectViaRelay(); err != nil {
-				dm.setState(StateError, err)
 				return fmt.Errorf("relay connection failed: %w", err)
 			}
+			if config.autoAddress() {
+				if err := dm.applyAssignment(dm.p2pConnection.Assignment()); err != nil {
+					dm.p2pConnection.CloseTransport()
+					return err
+				}
+			}
 
-			log.Printf("✅ Connected to relay server successfully")
+			logger.Info("connected to relay", "relay", relayServer)
 		} else {
 			// No relay available, try direct WebSocket as last resort
-			log.Printf("Connecting to peer via WebSocket: %s", peerAddr)
+			logger.Info("connecting to peer over WebSocket", "peer", peerAddr)
 
 			// Establish direct WebSocket connection
 			if err := dm.p2pConnection.Connect(peerAddr); err != nil {
-				dm.setState(StateError, err)
 				return fmt.Errorf("connection failed: %w", err)
 			}
 
-			log.Printf("✅ Connected to peer via WebSocket")
+			logger.Info("connected to peer over WebSocket", "peer", peerAddr)
 		}
 	}
 
-	// Update config with peer address
-	dm.config.Peer.Address = peerAddr
-
-	// Start frame router
-	dm.startFrameRouter()
-
-	dm.setState(StateConnected, nil)
-
+	target.relayServer, target.peerID = relayServer, peerID
 	return nil
 }
 
-// Disconnect closes the P2P connection
+// Disconnect closes the P2P connection, or stops retrying it
 func (dm *DaemonManager) Disconnect() error {
 	dm.stateMu.Lock()
-	if dm.state != StateConnected {
+	session := dm.session
+	if session == nil && dm.state != StateConnected {
 		dm.stateMu.Unlock()
 		return fmt.Errorf("not connected")
 	}
+	dm.session = nil
 	dm.stateMu.Unlock()
 
-	log.Printf("Disconnecting from peer...")
+	// Stop the supervisor before taking the transport down, so it does not redial
+	if session != nil {
+		session.cancel()
+	}
+
+	logger.Info("disconnecting")
 
+	dm.connectMu.Lock()
+	dm.stateMu.Lock()
+	if dm.session == session {
+		dm.session = nil // Re-established by a redial that was already under way
+	}
+	dm.stateMu.Unlock()
+	dm.teardown()
+	dm.connectMu.Unlock()
+
+	dm.setState(StateDisconnected, nil)
+	logger.Info("disconnected")
+
+	return nil
+}
+
+// teardown stops the frame router, forgets the peers and closes the transport
+// The caller holds connectMu and sets the new state.
+func (dm *DaemonManager) teardown() {
 	// Stop frame router
 	if dm.frameRouterStop != nil {
 		close(dm.frameRouterStop)
@@ -404,116 +697,272 @@ func (dm *DaemonManager) Disconnect() error {
 	dm.frameRouterRunning = false
 	dm.frameRouterMu.Unlock()
 
-	// Close P2P connection
+	// Forget negotiated peer capabilities
+	dm.resetPeers()
+
+	// Close the transport; the P2P listener keeps accepting connections
 	if dm.p2pConnection != nil {
-		if err := dm.p2pConnection.Close(); err != nil {
-			log.Printf("⚠️  Error during disconnect: %v", err)
+		dm.p2pConnection.CloseTransport()
+	}
+
+	dm.stateMu.Lock()
+	dm.connectedTo = ""
+	dm.stateMu.Unlock()
+}
+
+// GetState returns the current connection state
+func (dm *DaemonManager) GetState() ConnectionState {
+	dm.stateMu.RLock()
+	defer dm.stateMu.RUnlock()
+	return dm.state
+}
+
+// RotateKeys rotates the session transmit key; the peer follows from the key sequence
+// Returns the key sequence in use before the rotation.
+func (dm *DaemonManager) RotateKeys() (uint64, error) {
+	if dm.encryptionPipeline == nil {
+		return 0, fmt.Errorf("encryption pipeline not initialized")
+	}
+
+	sequence := dm.encryptionPipeline.GetMetrics().KeySequence
+	dm.encryptionPipeline.RequestRekey()
+	logger.Info("session key rotation requested", "key_sequence", sequence)
+
+	return sequence, nil
+}
+
+// keyRotationLoop rotates the transmit key every encryption.rotation_interval while connected
+// The timer restarts whenever a reload changes the interval.
+func (dm *DaemonManager) keyRotationLoop() {
+	for {
+		var expired <-chan time.Time
+		interval := dm.cfg().Encryption.RotationInterval
+		timer := time.NewTimer(interval)
+		if interval > 0 {
+			expired = timer.C
+		}
+
+		select {
+		case <-dm.ctx.Done():
+			timer.Stop()
+			return
+		case <-dm.rotationChanged:
+			timer.Stop()
+		case <-expired:
+			if dm.GetState() == StateConnected {
+				if _, err := dm.RotateKeys(); err != nil {
+					logger.Warn("scheduled key rotation failed", "error", err)
+				}
+			}
 		}
 	}
+}
 
-	dm.setState(StateDisconnected, nil)
-	log.Printf("✅ Disconnected successfully")
+// DaemonStatus is a point-in-time snapshot of the daemon (see SubscribeEvents for changes)
+type DaemonStatus struct {
+	State            string            `json:"state"`
+	TAPDevice        string            `json:"tap_device"`
+	LocalIP          string            `json:"local_ip"`
+	LastError        string            `json:"last_error,omitempty"`
+	Connected        bool              `json:"connected"`
+	PeerAddress      string            `json:"peer_address,omitempty"`
+	Compression      bool              `json:"compression"`
+	CompressionRatio float64           `json:"compression_ratio"`
+	KeySequence      uint64            `json:"key_sequence"`        // Rotation sequence of the transmit key
+	Transport        string            `json:"transport,omitempty"` // "udp", "websocket" or "relay"
+	Peers            []PeerStatus      `json:"peers"`
+	Pipeline         PipelineStatus    `json:"pipeline"`
+	Drops            map[string]uint64 `json:"drops"` // Pipeline and transport drops by reason
+	NAT              *NATStatus        `json:"nat,omitempty"`
+	MTU              MTUStatus         `json:"mtu"`
+	FEC              FECStatus         `json:"fec"`
+	Multipath        MultipathStatus   `json:"multipath"`
+	ExitNode         ExitNodeStatus    `json:"exit_node"`
+	DNS              *DNSStatus        `json:"dns,omitempty"`
+	ACL              *acl.Stats        `json:"acl,omitempty"`       // Rule hit counters; omitted while the ACL is disabled
+	Neighbors        *NeighborStatus   `json:"neighbors,omitempty"` // ARP/ND proxying; omitted in TUN mode
+	Networks         []NetworkStatus   `json:"networks,omitempty"`  // Joined virtual networks; omitted without
+}
 
-	return nil
+// PipelineStatus reports encryption pipeline totals across all peers
+type PipelineStatus struct {
+	TxFrames           uint64  `json:"tx_frames"`
+	TxBytes            uint64  `json:"tx_bytes"` // Encrypted wire size
+	RxFrames           uint64  `json:"rx_frames"`
+	Dropped            uint64  `json:"dropped"`
+	Rekeys             uint64  `json:"rekeys"`
+	FragmentedFrames   uint64  `json:"fragmented_frames"`
+	ReassembledFrames  uint64  `json:"reassembled_frames"`
+	ReassemblyTimeouts uint64  `json:"reassembly_timeouts"`
+	UptimeSeconds      float64 `json:"uptime_seconds"`
 }
 
 // GetStatus returns current daemon status
-func (dm *DaemonManager) GetStatus() map[string]interface{} {
+func (dm *DaemonManager) GetStatus() DaemonStatus {
 	dm.stateMu.RLock()
 	state := dm.state
 	lastError := dm.lastError
+	connectedTo := dm.connectedTo
 	dm.stateMu.RUnlock()
 
-	status := map[string]interface{}{
-		"state":      state.String(),
-		"tap_device": dm.config.Network.TAPDevice,
-		"local_ip":   dm.config.Network.LocalIP,
+	config := dm.cfg()
+	status := DaemonStatus{
+		State:     state.String(),
+		TAPDevice: config.Network.TAPDevice,
+		LocalIP:   dm.localIP(),
 	}
 
 	if lastError != nil {
-		status["last_error"] = lastError.Error()
+		status.LastError = lastError.Error()
 	}
 
 	if dm.p2pConnection != nil && state == StateConnected {
-		status["peer_address"] = dm.config.Peer.Address
-		status["connected"] = true
-	} else {
-		status["connected"] = false
+		status.PeerAddress = connectedTo
+		status.Connected = true
+	}
+
+	if dm.encryptionPipeline != nil {
+		metrics := dm.encryptionPipeline.GetMetrics()
+		status.Compression = metrics.CompressionEnabled
+		status.CompressionRatio = metrics.CompressionRatio()
+		status.KeySequence = metrics.KeySequence
+		status.Pipeline = PipelineStatus{
+			TxFrames:           metrics.EncryptedCount,
+			TxBytes:            metrics.EncryptedBytes,
+			RxFrames:           metrics.DecryptedCount,
+			Dropped:            metrics.DroppedCount,
+			Rekeys:             metrics.RekeyCount,
+			FragmentedFrames:   metrics.FragmentedCount,
+			ReassembledFrames:  metrics.ReassembledCount,
+			ReassemblyTimeouts: metrics.ReassemblyTimeouts,
+			UptimeSeconds:      metrics.Uptime.Seconds(),
+		}
 	}
 
+	if status.Connected {
+		status.Transport = dm.p2pConnection.TransportName()
+	}
+	status.Peers = dm.peerStatus()
+	status.Drops = dm.dropCounters()
+	status.NAT = dm.natStatus()
+
+	status.MTU = dm.pathMTUStatus()
+	status.FEC = dm.fecStatus()
+	status.Multipath = dm.multipathStatus()
+	status.ExitNode = dm.exitNodeStatus()
+	status.DNS = dm.dnsStatus()
+	status.ACL = dm.GetACL()
+	status.Neighbors = dm.neighborStatus()
+	status.Networks = dm.networkStatus()
+
 	return status
 }
 
 // initTAPDevice initializes the network device (TAP or TUN based on config/platform)
 func (dm *DaemonManager) initTAPDevice() error {
 	// Determine device mode (default to TUN on macOS, TAP otherwise)
-	mode := dm.config.Network.Mode
-	if mode == "" {
-		// Auto-detect based on platform
-		mode = "tun" // Default to TUN for maximum compatibility
-	}
+	mode := dm.deviceMode()
 
 	// Determine device name (prefer device_name, fallback to tap_device)
-	deviceName := dm.config.Network.DeviceName
+	deviceName := dm.cfg().Network.DeviceName
 	if deviceName == "" {
-		deviceName = dm.config.Network.TAPDevice
+		deviceName = dm.cfg().Network.TAPDevice
 	}
 
-	log.Printf("Creating %s device: %s", mode, deviceName)
+	logger.Info("creating network device", "mode", mode, "device", deviceName)
 
 	// Create network device with unified interface
+	// The device is created at the largest MTU the tunnel can carry; path MTU
+	// discovery lowers it if the path to the peer is narrower
+	mtu := dm.cfg().Network.MTU
+	if mtu == 0 {
+		mtu = dm.deviceMTUFor(dm.maxTunnelFrameSize())
+	}
+
 	deviceConfig := layer2.DeviceConfig{
 		Mode: mode,
 		Name: deviceName,
-		MTU:  1500,
+		MTU:  mtu,
 	}
 
-	device, err := layer2.NewNetworkDevice(deviceConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create %s device: %w", mode, err)
+	if dm.tapDevice == nil {
+		device, err := layer2.NewNetworkDevice(deviceConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create %s device: %w", mode, err)
+		}
+		dm.tapDevice = device
+	} else if want := layerFor(mode); dm.tapDevice.Layer() != want {
+		return fmt.Errorf("device %s carries %v traffic, but network.mode %s needs %v", dm.tapDevice.Name(), dm.tapDevice.Layer(), mode, want)
 	}
-	dm.tapDevice = device
 
-	// Parse IP address and netmask from CIDR
-	ip, ipNet, err := net.ParseCIDR(dm.config.Network.LocalIP)
-	if err != nil {
-		return fmt.Errorf("invalid local IP address: %w", err)
+	// Bring the interface up with its addresses and routes
+	if err := dm.configureDevice(); err != nil {
+		// Undo what was configured, so that a retry starts clean
+		if stopErr := dm.tapDevice.Stop(); stopErr != nil {
+			logger.Warn("error stopping network device", "error", stopErr)
+		}
+		dm.tapDevice = nil
+		return fmt.Errorf("failed to configure interface: %w", err)
 	}
 
-	// Calculate netmask as string (e.g., "24" for /24)
-	ones, _ := ipNet.Mask.Size()
-	netmask := fmt.Sprintf("%d", ones)
+	logger.Info("network device created", "mode", mode, "layer", dm.tapDevice.Layer(), "device", dm.tapDevice.Name(), "address", dm.cfg().Network.LocalIP)
 
-	// Configure IP address on network device
-	// Type assert to access platform-specific ConfigureInterface method
-	type Configurable interface {
-		ConfigureInterface(ipAddr, netmask string) error
-	}
+	// Start reading/writing frames
+	dm.tapDevice.Start()
 
-	if configurable, ok := dm.tapDevice.(Configurable); ok {
-		if err := configurable.ConfigureInterface(ip.String(), netmask); err != nil {
-			return fmt.Errorf("failed to configure interface: %w", err)
+	// Bring up the devices of networks that have their own
+	if err := dm.initNetworks(); err != nil {
+		if stopErr := dm.tapDevice.Stop(); stopErr != nil {
+			logger.Warn("error stopping network device", "error", stopErr)
 		}
-	} else {
-		return fmt.Errorf("device does not support configuration")
+		dm.tapDevice = nil
+		return err
 	}
 
-	log.Printf("✅ %s device %s created with IP %s", mode, dm.tapDevice.Name(), dm.config.Network.LocalIP)
-
-	// Start reading/writing frames
-	dm.tapDevice.Start()
+	dm.wg.Add(1)
+	go dm.deviceErrors()
 
-	log.Printf("✅ %s device reading/writing started", mode)
+	logger.Debug("network device started", "mode", mode)
 
 	return nil
 }
 
+// SetNetworkDevice makes the daemon use device instead of creating one
+// Must be called before Start; the daemon configures, starts and stops the device
+// as its own. Lets tests and userspace network stacks run the daemon over a
+// layer2.PipeDevice without privileges.
+func (dm *DaemonManager) SetNetworkDevice(device layer2.NetworkDevice) {
+	dm.tapDevice = device
+}
+
+// layerFor returns the layer a device in mode carries
+func layerFor(mode string) layer2.Layer {
+	if mode == layer2.ModeTUN {
+		return layer2.Layer3
+	}
+	return layer2.Layer2
+}
+
+// deviceErrors logs errors reported by the network device until the daemon stops
+func (dm *DaemonManager) deviceErrors() {
+	defer dm.wg.Done()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case err := <-dm.tapDevice.ErrorChannel():
+			deviceErrorLog.Warn(routerLogger, "network device error", "device", dm.tapDevice.Name(), "error", err)
+		}
+	}
+}
+
 // initEncryptionPipeline initializes the frame encryption pipeline
 func (dm *DaemonManager) initEncryptionPipeline() error {
-	log.Printf("Initializing encryption pipeline...")
+	logger.Debug("initializing encryption pipeline")
 
 	// Decode hex key
-	keyBytes, err := hex.DecodeString(dm.config.Encryption.Key)
+	keyBytes, err := hex.DecodeString(dm.cfg().Encryption.Key)
 	if err != nil {
 		return fmt.Errorf("invalid encryption key (must be hex): %w", err)
 	}
@@ -527,8 +976,20 @@ func (dm *DaemonManager) initEncryptionPipeline() error {
 
 	// Create pipeline config
 	pipelineConfig := &frameencryption.PipelineConfig{
-		Key:        encKey,
-		BufferSize: 100,
+		Key:          encKey,
+		BufferSize:   100,
+		MaxFrameSize: dm.initialTunnelFrameSize(), // Larger frames are fragmented
+		OnRekey:      dm.publishKeyRotation,
+		NetworkKeys:  dm.cfg().networkKeys(),
+	}
+
+	// With identity.key our data frames are only readable by the peers we send our sender key
+	if dm.cfg().identityKey() != nil {
+		dm.senderKey, dm.keyShare, err = newSenderKey()
+		if err != nil {
+			return err
+		}
+		pipelineConfig.SenderKey = dm.senderKey
 	}
 
 	// Create pipeline
@@ -541,18 +1002,24 @@ func (dm *DaemonManager) initEncryptionPipeline() error {
 	// Start pipeline goroutines
 	pipeline.Start()
 
-	log.Printf("✅ Encryption pipeline started (ChaCha20-Poly1305)")
+	// Size the device and MSS clamping for the initial tunnel datagram size
+	dm.applyPathMTU(dm.initialTunnelFrameSize())
+
+	logger.Info("encryption pipeline started", "cipher", "ChaCha20-Poly1305")
 
 	return nil
 }
 
 // initNATComponents initializes NAT detection and hole punching
+// Called again on reload when the NAT settings change; the previous components are replaced.
 func (dm *DaemonManager) initNATComponents() error {
-	log.Printf("Initializing NAT components...")
+	natLogger.Debug("initializing NAT components")
 
-	// Create NAT detector (no parameters - uses default STUN client)
+	// Create NAT detector using the configured STUN server
 	detector := nat.NewNATDetector()
-	dm.natDetector = detector
+	if server := dm.cfg().NAT.STUNServer; server != "" {
+		detector.SetSTUNServers(server)
+	}
 
 	// Detect NAT type
 	ctx, cancel := context.WithTimeout(dm.ctx, 5*time.Second)
@@ -563,47 +1030,61 @@ func (dm *DaemonManager) initNATComponents() error {
 		return fmt.Errorf("NAT detection failed: %w", err)
 	}
 
-	log.Printf("✅ NAT Type: %s (detected in %v)", result.NATType, result.DetectionTime)
-	log.Printf("   Public IP: %s", result.PublicIP)
-
 	// Check if P2P is feasible
 	feasible := detector.IsP2PFeasible()
-	log.Printf("   P2P Feasible: %v", feasible)
+	natLogger.Info("NAT type detected", "type", result.NATType, "public_ip", result.PublicIP, "p2p_feasible", feasible, "duration", result.DetectionTime)
 
 	// Create hole puncher if P2P is feasible
+	var holePuncher *nat.HolePuncher
 	if feasible {
 		// Use port 0 for automatic port assignment
-		holePuncher, err := nat.NewHolePuncher(0, detector)
+		holePuncher, err = nat.NewHolePuncher(0, detector)
 		if err != nil {
 			return fmt.Errorf("failed to create hole puncher: %w", err)
 		}
-		dm.holePuncher = holePuncher
-		log.Printf("✅ UDP hole puncher initialized")
+		natLogger.Debug("UDP hole puncher initialized")
 	}
 
+	dm.setNATComponents(detector, holePuncher)
+	dm.recordNATResult(result, feasible)
+
 	return nil
 }
 
-// initAPI initializes the HTTP API server
+// initAPI initializes the HTTP API server (Unix socket, plus TCP when a token is configured)
 func (dm *DaemonManager) initAPI() error {
-	log.Printf("Starting HTTP API on %s", dm.config.Daemon.ListenAddress)
+	config := dm.cfg()
+	socketMode, err := config.socketMode()
+	if err != nil {
+		return err
+	}
+
+	apiConfig := APIConfig{
+		SocketPath:  config.Daemon.Socket,
+		SocketMode:  socketMode,
+		SocketGroup: config.Daemon.SocketGroup,
+		TCPAddress:  config.Daemon.ListenAddress,
+		Token:       config.Daemon.APIToken,
 
-	api, err := NewDaemonAPI(dm.config.Daemon.ListenAddress, dm)
+		MetricsAddress: config.Metrics.ListenAddress,
+	}
+
+	if apiConfig.TCPAddress != "" && apiConfig.Token == "" {
+		apiLogger.Warn("daemon.listen_address ignored: set daemon.api_token to enable the TCP API", "address", apiConfig.TCPAddress)
+		apiConfig.TCPAddress = ""
+	}
+
+	api, err := NewDaemonAPI(apiConfig, dm)
 	if err != nil {
 		return fmt.Errorf("failed to create API: %w", err)
 	}
-	dm.daemonAPI = api
 
-	// Start API server in background
-	dm.wg.Add(1)
-	go func() {
-		defer dm.wg.Done()
-		if err := api.Start(); err != nil {
-			log.Printf("⚠️  API server error: %v", err)
-		}
-	}()
+	if err := api.Start(); err != nil {
+		return err
+	}
+	dm.daemonAPI = api
 
-	log.Printf("✅ HTTP API started successfully")
+	apiLogger.Debug("HTTP API started")
 
 	return nil
 }
@@ -611,23 +1092,22 @@ func (dm *DaemonManager) initAPI() error {
 // initP2PListener initializes the P2P WebSocket listener
 func (dm *DaemonManager) initP2PListener() error {
 	// Determine listener port (default 9545)
-	port := dm.config.P2P.ListenerPort
+	port := dm.cfg().P2P.ListenerPort
 	if port == 0 {
 		port = 9545 // Default port
 	}
 
-	log.Printf("Starting P2P WebSocket listener on port %d...", port)
+	p2pLogger.Debug("starting P2P WebSocket listener", "port", port)
 
 	// Initialize P2P connection
 	if dm.p2pConnection == nil {
-		dm.p2pConnection = NewP2PConnection()
+		dm.p2pConnection = dm.newP2PConnection()
 	}
 
 	// Register callback for incoming connections (responder mode)
 	dm.p2pConnection.SetOnConnectionAccepted(func() {
-		log.Printf("Incoming connection accepted - starting frame router on responder")
-		dm.startFrameRouter()
-		dm.setState(StateConnected, nil)
+		p2pLogger.Info("incoming connection accepted, starting frame router")
+		dm.acceptSession()
 	})
 
 	// Start listening for incoming WebSocket connections
@@ -636,7 +1116,7 @@ func (dm *DaemonManager) initP2PListener() error {
 		return fmt.Errorf("failed to start P2P listener: %w", err)
 	}
 
-	log.Printf("✅ P2P WebSocket listener started on port %d", port)
+	p2pLogger.Info("P2P WebSocket listener started", "port", port)
 
 	return nil
 }
@@ -648,163 +1128,332 @@ func (dm *DaemonManager) startFrameRouter() {
 
 	// Prevent starting multiple times
 	if dm.frameRouterRunning {
-		log.Printf("Frame router already running, skipping duplicate start")
+		routerLogger.Debug("frame router already running, skipping duplicate start")
 		return
 	}
 
-	log.Printf("Starting frame router...")
+	routerLogger.Debug("starting frame router")
+
+	// Router goroutines stop when frameRouterStop is closed (disconnect) or the daemon stops
+	stop := dm.frameRouterStop
+	routerCtx, cancel := context.WithCancel(dm.ctx)
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		defer cancel()
+		select {
+		case <-stop:
+		case <-routerCtx.Done():
+		}
+	}()
+
+	// Outbound: TAP → Encrypt
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterOutbound(routerCtx)
+	}()
+
+	// Outbound for networks on their own device: device → Encrypt
+	if dm.networks != nil {
+		for _, network := range dm.networks.list {
+			if network.device == nil {
+				continue
+			}
+			dm.wg.Add(1)
+			go func(network *virtualNetwork) {
+				defer dm.wg.Done()
+				dm.frameRouterNetwork(routerCtx, network)
+			}(network)
+		}
+	}
+
+	// Transmit: Encrypt → WebSocket/UDP
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterTransmit(routerCtx)
+	}()
 
-	// Outbound: TAP → Encrypt → WebSocket
+	// Inbound: WebSocket → Decrypt
 	dm.wg.Add(1)
 	go func() {
 		defer dm.wg.Done()
-		dm.frameRouterOutbound()
+		dm.frameRouterInbound(routerCtx)
 	}()
 
-	// Inbound: WebSocket → Decrypt → TAP
+	// Delivery: Decrypt → TAP
 	dm.wg.Add(1)
 	go func() {
 		defer dm.wg.Done()
-		dm.frameRouterInbound()
+		dm.frameRouterDeliver(routerCtx)
+	}()
+
+	// Control: Decrypt → control message handler
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterControl(routerCtx)
+	}()
+
+	// Path MTU discovery for this connection
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterPMTU(routerCtx)
+	}()
+
+	// Keepalives: loss measurement for adaptive FEC
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterKeepalive(routerCtx)
+	}()
+
+	// Drop bursts for event subscribers
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.frameRouterDrops(routerCtx)
 	}()
 
 	dm.frameRouterRunning = true
-	log.Printf("✅ Frame router started")
+	routerLogger.Info("frame router started")
+
+	// Announce our capabilities to whoever is on the other end
+	if err := dm.sendHello(false); err != nil {
+		controlLogger.Warn("failed to send hello", "error", err)
+	}
 }
 
-// frameRouterOutbound routes frames from TAP → Encrypt → WebSocket
-func (dm *DaemonManager) frameRouterOutbound() {
+// frameRouterOutbound routes frames from the device → Encrypt
+// A TAP device's Ethernet frames are sent whole; from a TUN device only the IP
+// packet is sent, so both ends of a tunnel must use the same device mode.
+func (dm *DaemonManager) frameRouterOutbound(ctx context.Context) {
+	layer := dm.tapDevice.Layer()
 	for {
 		select {
-		case <-dm.frameRouterStop:
-			log.Printf("Frame router outbound stopped")
-			return
-		case <-dm.ctx.Done():
+		case <-ctx.Done():
+			routerLogger.Debug("frame router outbound stopped")
 			return
-		case packet := <-dm.tapDevice.ReadChannel():
-			// Detect protocol for debugging
-			protocol := "UNKNOWN"
-			if len(packet) >= 20 {
-				protoNum := packet[9]
-				switch protoNum {
-				case 1:
-					protocol = "ICMP"
-				case 6:
-					protocol = "TCP"
-				case 17:
-					protocol = "UDP"
-				}
+		case payload := <-dm.controlOut:
+			// Control messages share the encrypted path with device traffic
+			if !dm.encryptionPipeline.SendControl(payload) {
+				controlFullLog.Warn(routerLogger, "encryption pipeline full, dropping control message")
 			}
-
-			// Send raw IP packet to encryption pipeline (non-blocking)
-			if !dm.encryptionPipeline.SendFrame(packet) {
-				log.Printf("⚠️  [%s] Encryption pipeline full, dropping packet", protocol)
+		case packet := <-dm.tapDevice.ReadChannel():
+			// With networks, the VLAN tag selects the network
+			network, ok := dm.mainDeviceNetwork(packet)
+			if !ok {
+				networkLog.Log(routerLogger, slog.LevelDebug, "dropping frame on a VLAN no network uses", "vlan", packet.VLAN)
 				continue
 			}
+			dm.sendDeviceFrame(layer, network, packet)
+		}
+	}
+}
 
-			// DEBUG: Log that we sent to encryption
-			if protocol == "TCP" {
-				log.Printf("🔐 [TCP] Sent to encryption pipeline, waiting for encrypted frame...")
-			}
+// sendDeviceFrame sends a frame read from a device to the peers in its network
+func (dm *DaemonManager) sendDeviceFrame(layer layer2.Layer, network *virtualNetwork, packet *layer2.EthernetFrame) {
+	// Clamp TCP MSS on SYNs so segments fit the tunnel without fragmentation
+	layer2.ClampFrameMSS(packet, dm.currentTunnelMTU())
 
-			// Receive encrypted frame (blocking with context)
-			ctx, cancel := context.WithTimeout(dm.ctx, 100*time.Millisecond)
-			encryptedFrame, err := dm.encryptionPipeline.ReceiveEncryptedFrame(ctx)
-			cancel()
+	// Answer ARP and ND for known peers here, and keep broadcast storms off the mesh
+	if layer == layer2.Layer2 && ((network.primary() && dm.proxyNeighbor(packet)) || dm.suppressFlood(packet)) {
+		return
+	}
 
-			if err != nil {
-				if err != context.DeadlineExceeded {
-					log.Printf("⚠️  [%s] Failed to receive encrypted frame: %v", protocol, err)
-				} else if protocol == "TCP" {
-					log.Printf("⚠️  [TCP] TIMEOUT waiting for encrypted frame (100ms exceeded)")
-				}
-				continue
-			}
+	// Connections to peers the ACL denies are dropped before encryption
+	if !dm.aclAllows(acl.Outbound, network, 0, packet.EtherType, packet.Payload) {
+		aclDeniedLog.Log(routerLogger, slog.LevelDebug, "ACL denies packet to peer", "protocol", packetProtocol(packet))
+		return
+	}
 
-			// DEBUG: Log successful encryption
-			if protocol == "TCP" {
-				log.Printf("✅ [TCP] Received encrypted frame (%d bytes)", len(encryptedFrame.Frame.Ciphertext))
-			}
+	// Send to encryption pipeline (non-blocking)
+	var sent bool
+	if layer == layer2.Layer3 {
+		sent = dm.encryptionPipeline.SendPacket(packet.Payload)
+	} else {
+		sent = dm.encryptionPipeline.SendNetworkFrame(network.wireID(), packet)
+	}
+	if !sent {
+		pipelineFullLog.Warn(routerLogger, "encryption pipeline full, dropping packet", "protocol", packetProtocol(packet))
+		return
+	}
+	if network != nil {
+		network.txFrames.Add(1)
+	}
+}
 
-			// Serialize encrypted frame to bytes for WebSocket transmission
-			// Format: [12-byte nonce][ciphertext with tag]
-			frameBytes := make([]byte, len(encryptedFrame.Frame.Nonce)+len(encryptedFrame.Frame.Ciphertext))
-			copy(frameBytes[:len(encryptedFrame.Frame.Nonce)], encryptedFrame.Frame.Nonce[:])
-			copy(frameBytes[len(encryptedFrame.Frame.Nonce):], encryptedFrame.Frame.Ciphertext)
-
-			// Send over WebSocket
-			if err := dm.p2pConnection.SendFrame(frameBytes); err != nil {
-				log.Printf("⚠️  [%s] Failed to send frame over WebSocket: %v", protocol, err)
-			} else if protocol == "TCP" {
-				log.Printf("📡 [TCP] Sent over WebSocket (%d bytes)", len(frameBytes))
+// packetProtocol names the transport protocol of an IP frame, for logs
+func packetProtocol(frame *layer2.EthernetFrame) string {
+	var proto byte
+	switch {
+	case frame.EtherType == layer2.EtherTypeIPv4 && len(frame.Payload) >= 20:
+		proto = frame.Payload[9]
+	case frame.EtherType == layer2.EtherTypeIPv6 && len(frame.Payload) >= 40:
+		proto = frame.Payload[6] // Next header; extension headers are not followed
+	default:
+		return "UNKNOWN"
+	}
+
+	switch proto {
+	case 1, 58:
+		return "ICMP"
+	case 6:
+		return "TCP"
+	case 17:
+		return "UDP"
+	}
+	return "UNKNOWN"
+}
+
+// frameRouterTransmit sends encrypted frames to the peer
+// Runs separately from frameRouterOutbound because one frame may be fragmented into several.
+func (dm *DaemonManager) frameRouterTransmit(ctx context.Context) {
+	for {
+		encryptedFrame, err := dm.encryptionPipeline.ReceiveEncryptedFrame(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				routerLogger.Warn("failed to receive encrypted frame", "error", err)
 			}
+			return
+		}
+
+		// Serialize encrypted frame to bytes for transmission
+		// Format: [10-byte header][12-byte nonce][ciphertext with tag]
+		frameBytes := encryptedFrame.Marshal()
+
+		if err := dm.p2pConnection.SendFrame(frameBytes); err != nil {
+			sendFailedLog.Warn(routerLogger, "failed to send frame", "bytes", len(frameBytes), "error", err)
 		}
 	}
 }
 
-// frameRouterInbound routes frames from WebSocket → Decrypt → TAP
-func (dm *DaemonManager) frameRouterInbound() {
+// frameRouterInbound routes frames from WebSocket → Decrypt
+func (dm *DaemonManager) frameRouterInbound(ctx context.Context) {
 	for {
 		select {
-		case <-dm.frameRouterStop:
-			log.Printf("Frame router inbound stopped")
-			return
-		case <-dm.ctx.Done():
+		case <-ctx.Done():
+			routerLogger.Debug("frame router inbound stopped")
 			return
 		case encryptedBytes := <-dm.p2pConnection.RecvChannel():
 			// Parse encrypted frame from bytes
-			// Format: [12-byte nonce][ciphertext with tag]
-			if len(encryptedBytes) < symmetric.NonceSize {
-				log.Printf("⚠️  Invalid encrypted frame: too short")
+			// Format: [10-byte header][12-byte nonce][ciphertext with tag]
+			encryptedFrame, err := frameencryption.UnmarshalEncryptedFrame(encryptedBytes)
+			if err != nil {
+				invalidFrameLog.Warn(routerLogger, "invalid encrypted frame", "error", err)
 				continue
 			}
 
-			var nonce [symmetric.NonceSize]byte
-			copy(nonce[:], encryptedBytes[:symmetric.NonceSize])
-			ciphertext := encryptedBytes[symmetric.NonceSize:]
+			// Send to decryption pipeline (non-blocking)
+			if !dm.encryptionPipeline.SendEncryptedFrame(encryptedFrame) {
+				decryptFullLog.Warn(routerLogger, "decryption pipeline full, dropping frame")
+			}
+		}
+	}
+}
 
-			encryptedFrame := &frameencryption.EncryptedEthernetFrame{
-				Frame: &symmetric.EncryptedFrame{
-					Nonce:      nonce,
-					Ciphertext: ciphertext,
-				},
-				Timestamp: time.Now(),
+// frameRouterDeliver writes decrypted frames to the device
+// In TUN mode, payloads that are not IP packets (e.g. from a peer in TAP mode) are dropped,
+// as are packets from a source address the sending peer may not use. Frames of networks
+// this node has not joined are dropped; the others go to their network's device.
+func (dm *DaemonManager) frameRouterDeliver(ctx context.Context) {
+	layer := dm.tapDevice.Layer()
+	for {
+		frame, err := dm.encryptionPipeline.ReceiveDecryptedFrame(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				routerLogger.Warn("failed to receive decrypted frame", "error", err)
 			}
+			return
+		}
+		decryptedBytes := frame.Payload
 
-			// Send to decryption pipeline (non-blocking)
-			if !dm.encryptionPipeline.SendEncryptedFrame(encryptedFrame) {
-				log.Printf("⚠️  Decryption pipeline full, dropping frame")
+		// Clamp TCP MSS on SYNs from the peer so our replies fit the tunnel
+		if layer == layer2.Layer3 {
+			if !layer2.IsIPPacket(decryptedBytes) {
+				mismatchedLog.Warn(routerLogger, "dropping non-IP payload, is the peer in tap mode?", "bytes", len(decryptedBytes))
 				continue
 			}
+			layer2.ClampMSS(decryptedBytes, dm.currentTunnelMTU())
+		} else {
+			layer2.ClampRawFrameMSS(decryptedBytes, dm.currentTunnelMTU())
+		}
 
-			// Receive decrypted frame (blocking with context)
-			ctx, cancel := context.WithTimeout(dm.ctx, 100*time.Millisecond)
-			decryptedBytes, err := dm.encryptionPipeline.ReceiveDecryptedFrame(ctx)
-			cancel()
+		network, ok := dm.deliveredNetwork(frame)
+		if !ok {
+			dm.encryptionPipeline.RecordDrop(frame.SenderID, frameencryption.DropNetwork)
+			networkLog.Log(routerLogger, slog.LevelDebug, "dropping frame of a network not joined", senderAttr(frame.SenderID), "network", frame.Network)
+			continue
+		}
 
-			if err != nil {
-				if err != context.DeadlineExceeded {
-					log.Printf("⚠️  Failed to receive decrypted frame: %v", err)
-				}
+		// Peers may only send from their tunnel address and the subnets we accept from them
+		// Other networks do not use tunnel addresses; their sources are not checked.
+		if network.primary() {
+			if src, allowed := dm.sourceAllowed(frame.SenderID, decryptedBytes, layer); !allowed {
+				dm.encryptionPipeline.RecordDrop(frame.SenderID, frameencryption.DropSourceAddress)
+				spoofedLog.Warn(routerLogger, "dropping packet from a source the peer may not use", senderAttr(frame.SenderID), "source", src)
 				continue
 			}
+		}
 
-			// Write to TAP device
-			select {
-			case dm.tapDevice.WriteChannel() <- decryptedBytes:
-			case <-dm.frameRouterStop:
-				return
-			default:
-				log.Printf("⚠️  TAP write channel full, dropping frame")
-			}
+		// Peers only reach what the ACL allows them, in every network
+		if delivered := deliveredFrame(decryptedBytes, layer); !dm.aclAllows(acl.Inbound, network, frame.SenderID, delivered.EtherType, delivered.Payload) {
+			dm.encryptionPipeline.RecordDrop(frame.SenderID, frameencryption.DropACL)
+			aclDeniedLog.Log(routerLogger, slog.LevelDebug, "ACL denies packet from peer", senderAttr(frame.SenderID), "protocol", packetProtocol(delivered))
+			continue
+		}
+
+		// Remember the MAC addresses peers announce, to answer ARP and ND for them
+		if layer == layer2.Layer2 && network.primary() {
+			dm.learnNeighbors(frame.SenderID, decryptedBytes)
 		}
+
+		dm.deliverNetworkFrame(ctx, network, decryptedBytes)
 	}
 }
 
-// setState updates the connection state
+// deliverNetworkFrame writes a delivered frame to its network's device (non-blocking)
+func (dm *DaemonManager) deliverNetworkFrame(ctx context.Context, network *virtualNetwork, payload []byte) {
+	output, payload := dm.networkOutput(network, payload)
+	select {
+	case output <- payload:
+		if network != nil {
+			network.rxFrames.Add(1)
+		}
+	case <-ctx.Done():
+	default:
+		deviceFullLog.Warn(routerLogger, "device write channel full, dropping frame")
+	}
+}
+
+// frameRouterControl dispatches decrypted control messages from peers
+func (dm *DaemonManager) frameRouterControl(ctx context.Context) {
+	for {
+		frame, err := dm.encryptionPipeline.ReceiveControlFrame(ctx)
+		if err != nil {
+			return
+		}
+		dm.handleControlFrame(frame)
+	}
+}
+
+// setState updates the connection state and reports transitions to event subscribers
 func (dm *DaemonManager) setState(state ConnectionState, err error) {
 	dm.stateMu.Lock()
+	previous := dm.state
 	dm.state = state
 	dm.lastError = err
 	dm.stateMu.Unlock()
+
+	if previous == state && err == nil {
+		return
+	}
+
+	event := &StateChangedEvent{From: previous.String(), To: state.String()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	dm.events.publish(Event{Type: EventStateChanged, State: event})
 }
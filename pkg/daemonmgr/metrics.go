@@ -0,0 +1,153 @@
+package daemonmgr
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/metrics"
+)
+
+// daemonMetrics holds what /metrics cannot read from component counters at scrape time
+type daemonMetrics struct {
+	registry *metrics.Registry
+
+	// A handshake is one Connect: from dialling the peer or relay until the tunnel is up
+	handshakeSuccesses atomic.Uint64
+	handshakeFailures  atomic.Uint64
+	handshakeDuration  *metrics.Histogram
+
+	holePunchDuration *metrics.Histogram // Successful hole punches only
+	rtt               *metrics.Histogram // Control channel round trips to every peer
+}
+
+// newDaemonMetrics creates the daemon's metrics registry
+func newDaemonMetrics(dm *DaemonManager) *daemonMetrics {
+	m := &daemonMetrics{
+		registry:          metrics.NewRegistry(),
+		handshakeDuration: metrics.NewHistogram(metrics.LatencyBuckets),
+		holePunchDuration: metrics.NewHistogram(metrics.LatencyBuckets),
+		rtt:               metrics.NewHistogram(metrics.LatencyBuckets),
+	}
+	m.registry.Register(dm.collectMetrics)
+	return m
+}
+
+// handshakeDone records the outcome of a Connect
+func (m *daemonMetrics) handshakeDone(start time.Time, err error) {
+	if err != nil {
+		m.handshakeFailures.Add(1)
+		return
+	}
+	m.handshakeSuccesses.Add(1)
+	m.handshakeDuration.ObserveDuration(time.Since(start))
+}
+
+// MetricsHandler serves the daemon's metrics in the Prometheus text format
+func (dm *DaemonManager) MetricsHandler() http.Handler {
+	return dm.metrics.registry.Handler()
+}
+
+// collectMetrics writes the daemon's metrics for a scrape
+func (dm *DaemonManager) collectMetrics(w *metrics.Writer) {
+	status := dm.GetStatus()
+
+	for _, state := range []ConnectionState{StateDisconnected, StateConnecting, StateConnected, StateReconnecting, StateError} {
+		w.Gauge("shadowmesh_connection_state", "Connection state (1 for the current state).",
+			boolValue(status.State == state.String()), metrics.L("state", state.String()))
+	}
+	w.Gauge("shadowmesh_peers", "Peers that have announced themselves with a hello.", float64(len(status.Peers)))
+
+	// Encryption pipeline
+	pipeline := status.Pipeline
+	w.Counter("shadowmesh_frames_total", "Frames through the encryption pipeline.", float64(pipeline.TxFrames), metrics.L("direction", "tx"))
+	w.Counter("shadowmesh_frames_total", "Frames through the encryption pipeline.", float64(pipeline.RxFrames), metrics.L("direction", "rx"))
+	var rxBytes uint64
+	if dm.encryptionPipeline != nil {
+		rxBytes = dm.encryptionPipeline.GetMetrics().ReceivedBytes
+	}
+	w.Counter("shadowmesh_bytes_total", "Encrypted bytes on the wire.", float64(pipeline.TxBytes), metrics.L("direction", "tx"))
+	w.Counter("shadowmesh_bytes_total", "Encrypted bytes on the wire.", float64(rxBytes), metrics.L("direction", "rx"))
+	reasons := make([]string, 0, len(status.Drops))
+	for reason := range status.Drops {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		w.Counter("shadowmesh_dropped_frames_total", "Frames dropped, by reason.", float64(status.Drops[reason]), metrics.L("reason", reason))
+	}
+	w.Counter("shadowmesh_rekeys_total", "Transmit key rotations.", float64(pipeline.Rekeys))
+	w.Gauge("shadowmesh_key_sequence", "Rotation sequence of the transmit key.", float64(status.KeySequence))
+	w.Gauge("shadowmesh_compression_ratio", "Plaintext to compressed bytes for frames considered for compression.", status.CompressionRatio)
+
+	// Connection setup
+	w.Counter("shadowmesh_handshakes_total", "Connection attempts to a peer or relay, by result.", float64(dm.metrics.handshakeSuccesses.Load()), metrics.L("result", "success"))
+	w.Counter("shadowmesh_handshakes_total", "Connection attempts to a peer or relay, by result.", float64(dm.metrics.handshakeFailures.Load()), metrics.L("result", "failure"))
+	w.Histogram("shadowmesh_handshake_duration_seconds", "Time from dialling a peer or relay until the tunnel is up.", dm.metrics.handshakeDuration)
+
+	if status.NAT != nil {
+		w.Gauge("shadowmesh_nat_type", "Detected NAT type (1 for the detected type).", 1, metrics.L("type", status.NAT.Type))
+		w.Counter("shadowmesh_hole_punch_attempts_total", "UDP hole punching attempts, by result.", float64(status.NAT.HolePunch.Successes), metrics.L("result", "success"))
+		w.Counter("shadowmesh_hole_punch_attempts_total", "UDP hole punching attempts, by result.", float64(status.NAT.HolePunch.Failures), metrics.L("result", "failure"))
+		w.Counter("shadowmesh_hole_punch_attempts_total", "UDP hole punching attempts, by result.", float64(status.NAT.HolePunch.Timeouts), metrics.L("result", "timeout"))
+	}
+	w.Histogram("shadowmesh_hole_punch_duration_seconds", "Time taken by successful UDP hole punches.", dm.metrics.holePunchDuration)
+
+	// Peers
+	w.Histogram("shadowmesh_rtt_seconds", "Control channel round trips to peers.", dm.metrics.rtt)
+	for _, peer := range status.Peers {
+		id := metrics.L("peer", peer.SenderID)
+		w.Gauge("shadowmesh_peer_rtt_seconds", "Smoothed round trip time to the peer.", peer.RTTMillis/1000, id)
+		w.Gauge("shadowmesh_peer_loss_ratio", "Datagram loss to and from the peer.", peer.InboundLoss, id, metrics.L("direction", "in"))
+		w.Gauge("shadowmesh_peer_loss_ratio", "Datagram loss to and from the peer.", peer.OutboundLoss, id, metrics.L("direction", "out"))
+		w.Counter("shadowmesh_peer_frames_total", "Frames exchanged with the peer.", float64(peer.TxFrames), id, metrics.L("direction", "tx"))
+		w.Counter("shadowmesh_peer_frames_total", "Frames exchanged with the peer.", float64(peer.RxFrames), id, metrics.L("direction", "rx"))
+		w.Counter("shadowmesh_peer_bytes_total", "Encrypted bytes exchanged with the peer.", float64(peer.TxBytes), id, metrics.L("direction", "tx"))
+		w.Counter("shadowmesh_peer_bytes_total", "Encrypted bytes exchanged with the peer.", float64(peer.RxBytes), id, metrics.L("direction", "rx"))
+		w.Gauge("shadowmesh_peer_last_handshake_timestamp_seconds", "Unix time of the peer's last hello.", unixSeconds(peer.LastHandshake), id)
+	}
+
+	// Transport
+	w.Gauge("shadowmesh_tunnel_mtu_bytes", "Largest IP packet the tunnel device accepts.", float64(status.MTU.DeviceMTU))
+	w.Gauge("shadowmesh_path_mtu_bytes", "Largest datagram confirmed to reach the peer (0 before discovery).", float64(status.MTU.PathMTU))
+	w.Gauge("shadowmesh_fec_parity_shards", "FEC parity shards per group (0 when FEC is off).", float64(status.FEC.ParityShards))
+	w.Counter("shadowmesh_fec_recovered_total", "Datagrams rebuilt from FEC parity.", float64(status.FEC.Recovered))
+	w.Counter("shadowmesh_fec_unrecoverable_total", "FEC groups with too many datagrams missing.", float64(status.FEC.Unrecoverable))
+	for _, path := range status.Multipath.Paths {
+		name := metrics.L("path", path.Interface)
+		w.Gauge("shadowmesh_path_up", "Whether the multipath path answers probes.", boolValue(path.Up), name)
+		w.Gauge("shadowmesh_path_rtt_seconds", "Round trip time measured by the path's probes.", path.RTT.Seconds(), name)
+		w.Gauge("shadowmesh_path_loss_ratio", "Probe loss on the path.", path.Loss, name)
+		w.Counter("shadowmesh_path_bytes_total", "Bytes sent and received on the path.", float64(path.TxBytes), name, metrics.L("direction", "tx"))
+		w.Counter("shadowmesh_path_bytes_total", "Bytes sent and received on the path.", float64(path.RxBytes), name, metrics.L("direction", "rx"))
+	}
+
+	if neighbors := status.Neighbors; neighbors != nil {
+		w.Gauge("shadowmesh_neighbor_bindings", "Peer addresses with a known MAC address.", float64(neighbors.Bindings))
+		w.Counter("shadowmesh_neighbor_answered_total", "ARP requests and neighbour solicitations answered for peers.", float64(neighbors.Answered))
+		w.Counter("shadowmesh_broadcast_suppressed_total", "Broadcast and multicast frames not sent to peers.", float64(neighbors.Suppressed))
+	}
+
+	for _, network := range status.Networks {
+		id := metrics.L("network", network.ID)
+		w.Counter("shadowmesh_network_frames_total", "Frames exchanged in the virtual network.", float64(network.TxFrames), id, metrics.L("direction", "tx"))
+		w.Counter("shadowmesh_network_frames_total", "Frames exchanged in the virtual network.", float64(network.RxFrames), id, metrics.L("direction", "rx"))
+	}
+}
+
+// boolValue converts a boolean to a 0/1 sample
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unixSeconds converts a timestamp to a sample (0 for the zero time)
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,118 @@
+package daemonmgr
+
+import (
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
+)
+
+// updateMultipath enables multipath framing once every peer supports it and opens a path per uplink
+func (dm *DaemonManager) updateMultipath() {
+	if dm.p2pConnection == nil {
+		return
+	}
+
+	bond := dm.p2pConnection.Multipath()
+	if bond == nil {
+		return
+	}
+
+	dm.peersMu.RLock()
+	hasPeers := len(dm.peers) > 0
+	enabled := dm.cfg().Multipath.Enabled && hasPeers
+	for _, peer := range dm.peers {
+		if !peer.multipath {
+			enabled = false
+			break
+		}
+	}
+	dm.peersMu.RUnlock()
+
+	dm.multipathMu.Lock()
+	defer dm.multipathMu.Unlock()
+
+	if !hasPeers {
+		// Disconnected; paths close with the connection
+		dm.multipathStarted = false
+	}
+
+	bond.SetSequenced(enabled)
+	if !enabled || dm.multipathStarted {
+		return
+	}
+	dm.multipathStarted = true
+
+	for _, name := range dm.multipathInterfaces() {
+		if err := dm.p2pConnection.AddInterfacePath(name); err != nil {
+			multipathLogger.Warn("failed to add path", "interface", name, "error", err)
+		}
+	}
+
+	multipathLogger.Info("multipath enabled", "mode", bond.Mode())
+}
+
+// multipathInterfaces returns the uplinks that get an extra path
+// The interface already used by the primary path is skipped, as is the tunnel device.
+func (dm *DaemonManager) multipathInterfaces() []string {
+	var exclude []string
+	if dm.tapDevice != nil {
+		exclude = append(exclude, dm.tapDevice.Name())
+	}
+
+	if peerAddr := dm.p2pConnection.UDPPeerAddr(); peerAddr != nil {
+		if primary, err := multipath.RouteInterface(peerAddr); err == nil {
+			exclude = append(exclude, primary)
+		}
+	}
+
+	if len(dm.cfg().Multipath.Interfaces) == 0 {
+		names, err := multipath.Interfaces(exclude...)
+		if err != nil {
+			multipathLogger.Warn("failed to list interfaces", "error", err)
+		}
+		return names
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	var names []string
+	for _, name := range dm.cfg().Multipath.Interfaces {
+		if !skip[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// MultipathStatus reports multipath bonding and its paths
+type MultipathStatus struct {
+	Enabled    bool                  `json:"enabled"`
+	Mode       string                `json:"mode"`
+	Paths      []multipath.PathStats `json:"paths,omitempty"`
+	Reordered  uint64                `json:"reordered"`  // Datagrams delivered after waiting for a gap
+	Duplicates uint64                `json:"duplicates"` // Redundant copies discarded
+	Skipped    uint64                `json:"skipped"`    // Gaps given up on
+}
+
+// multipathStatus returns per-path statistics for the status API
+func (dm *DaemonManager) multipathStatus() MultipathStatus {
+	status := MultipathStatus{
+		Mode: dm.multipathMode.String(),
+	}
+
+	if dm.p2pConnection == nil {
+		return status
+	}
+
+	bond := dm.p2pConnection.Multipath()
+	if bond == nil {
+		return status
+	}
+
+	status.Enabled = bond.Sequenced()
+	status.Paths = bond.Paths()
+	status.Reordered, status.Duplicates, status.Skipped = bond.ReorderStats()
+
+	return status
+}
@@ -0,0 +1,110 @@
+package daemonmgr
+
+import (
+	"github.com/shadowmesh/shadowmesh/pkg/nat"
+)
+
+// NATStatus reports the local NAT detection result and hole punching outcomes
+type NATStatus struct {
+	Type        string          `json:"type"`
+	PublicIP    string          `json:"public_ip,omitempty"`
+	PublicPort  int             `json:"public_port,omitempty"`
+	P2PFeasible bool            `json:"p2p_feasible"`
+	HolePunch   HolePunchStatus `json:"hole_punch"`
+}
+
+// HolePunchStatus counts hole punching attempts
+type HolePunchStatus struct {
+	Successes uint64 `json:"successes"`
+	Failures  uint64 `json:"failures"`
+	Timeouts  uint64 `json:"timeouts"`
+}
+
+// natComponents returns the NAT detector and hole puncher (nil when NAT traversal is off or infeasible)
+func (dm *DaemonManager) natComponents() (*nat.NATDetector, *nat.HolePuncher) {
+	dm.natMu.RLock()
+	defer dm.natMu.RUnlock()
+	return dm.natDetector, dm.holePuncher
+}
+
+// setNATComponents replaces the NAT detector and hole puncher
+// Passing nil for both turns NAT traversal off; the last detection result is forgotten then.
+func (dm *DaemonManager) setNATComponents(detector *nat.NATDetector, holePuncher *nat.HolePuncher) {
+	dm.natMu.Lock()
+	previous := dm.holePuncher
+	dm.natDetector = detector
+	dm.holePuncher = holePuncher
+	dm.natMu.Unlock()
+
+	// A successful hole punch hands its socket to the direct connection; that socket
+	// is closed with the connection instead
+	if previous != nil && !dm.directUDPConnected() {
+		previous.Close()
+	}
+	if detector == nil {
+		dm.natResult.Store(nil)
+	}
+}
+
+// renewHolePuncher replaces a hole puncher whose socket went to a direct connection that has since closed
+func (dm *DaemonManager) renewHolePuncher() {
+	detector, previous := dm.natComponents()
+	if detector == nil || previous == nil {
+		return
+	}
+
+	holePuncher, err := nat.NewHolePuncher(0, detector)
+	if err != nil {
+		natLogger.Warn("failed to create hole puncher", "error", err)
+		return
+	}
+	dm.setNATComponents(detector, holePuncher)
+}
+
+// recordNATResult keeps a detection result for the status API and announces it
+// The detector's own cache expires, so the daemon holds on to the result it acted on.
+func (dm *DaemonManager) recordNATResult(result *nat.DetectionResult, feasible bool) {
+	status := &NATStatus{
+		Type:        result.NATType.String(),
+		PublicPort:  result.PublicPort,
+		P2PFeasible: feasible,
+	}
+	if result.PublicIP != nil {
+		status.PublicIP = result.PublicIP.String()
+	}
+	dm.natResult.Store(status)
+
+	dm.publishNATResult(result, feasible)
+}
+
+// natType returns the detected NAT type, or "" before detection (sent in hellos)
+func (dm *DaemonManager) natType() string {
+	if result := dm.natResult.Load(); result != nil {
+		return result.Type
+	}
+	return ""
+}
+
+// natStatus returns NAT details for the status API, or nil if NAT traversal is off
+func (dm *DaemonManager) natStatus() *NATStatus {
+	result := dm.natResult.Load()
+	if result == nil {
+		return nil
+	}
+
+	status := *result
+	if _, holePuncher := dm.natComponents(); holePuncher != nil {
+		metrics := holePuncher.GetMetrics()
+		status.HolePunch = HolePunchStatus{
+			Successes: metrics.SuccessCount,
+			Failures:  metrics.FailureCount,
+			Timeouts:  metrics.TimeoutCount,
+		}
+	}
+	return &status
+}
+
+// directUDPConnected reports whether the tunnel runs over a hole-punched UDP socket
+func (dm *DaemonManager) directUDPConnected() bool {
+	return dm.GetState() == StateConnected && dm.p2pConnection != nil && dm.p2pConnection.Transport() == TransportUDP
+}
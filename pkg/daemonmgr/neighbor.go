@@ -0,0 +1,87 @@
+package daemonmgr
+
+import (
+	"log/slog"
+	"net/netip"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// NeighborStatus reports ARP/ND proxying and flood suppression in TAP mode
+type NeighborStatus struct {
+	Proxy          bool   `json:"proxy"`           // network.proxy_neighbors
+	Bindings       int    `json:"bindings"`        // Peer addresses with a known MAC address
+	Answered       uint64 `json:"answered"`        // ARP requests and neighbour solicitations answered locally
+	Suppressed     uint64 `json:"suppressed"`      // Broadcast and multicast frames not sent to peers
+	BroadcastLimit int    `json:"broadcast_limit"` // Broadcast and multicast frames sent per second (0: no limit)
+}
+
+// proxyNeighbor answers an ARP request or neighbour solicitation read from the device
+// for a peer whose MAC address is known, so the request need not be flooded to peers.
+// Reports whether the frame was answered.
+func (dm *DaemonManager) proxyNeighbor(frame *layer2.EthernetFrame) bool {
+	if !dm.cfg().Network.ProxyNeighbors {
+		return false
+	}
+	reply := dm.neighbors.Reply(frame)
+	if reply == nil {
+		return false
+	}
+
+	select {
+	case dm.tapDevice.WriteChannel() <- reply:
+		dm.neighborAnswered.Add(1)
+		return true
+	default:
+		return false // Sent to the peers instead, who answer themselves
+	}
+}
+
+// suppressFlood reports whether a broadcast or multicast frame read from the device is
+// dropped instead of flooded to every peer: a duplicate, or over network.broadcast_limit
+func (dm *DaemonManager) suppressFlood(frame *layer2.EthernetFrame) bool {
+	if dm.suppressor.Allow(frame) {
+		return false
+	}
+	dm.neighborSuppressed.Add(1)
+	suppressedLog.Log(routerLogger, slog.LevelDebug, "suppressing broadcast to peers", "ethertype", frame.EtherType)
+	return true
+}
+
+// learnNeighbors records the MAC addresses a peer announces in a frame delivered from it
+// Only the tunnel addresses the peer announced and addresses in subnets accepted from
+// it are learned, so a peer cannot take over another's; peers without a hello are not
+// proxied.
+func (dm *DaemonManager) learnNeighbors(senderID uint64, frame []byte) {
+	if !dm.cfg().Network.ProxyNeighbors {
+		return
+	}
+	filter := dm.sources.Load()
+	dm.neighbors.Learn(frame, func(addr netip.Addr) bool {
+		return filter != nil && filter.announces(senderID, addr)
+	})
+}
+
+// updateNeighbors applies changed network.proxy_neighbors and network.broadcast_limit settings
+func (dm *DaemonManager) updateNeighbors(next *DaemonConfig) {
+	if !next.Network.ProxyNeighbors {
+		dm.neighbors.Clear()
+	}
+	dm.suppressor.SetRate(next.Network.BroadcastLimit)
+	routerLogger.Info("neighbour settings changed", "proxy", next.Network.ProxyNeighbors, "broadcast_limit", next.Network.BroadcastLimit)
+}
+
+// neighborStatus reports ARP/ND proxying; nil in TUN mode, which has neither
+func (dm *DaemonManager) neighborStatus() *NeighborStatus {
+	if dm.deviceMode() == layer2.ModeTUN {
+		return nil
+	}
+	config := dm.cfg()
+	return &NeighborStatus{
+		Proxy:          config.Network.ProxyNeighbors,
+		Bindings:       dm.neighbors.Len(),
+		Answered:       dm.neighborAnswered.Load(),
+		Suppressed:     dm.neighborSuppressed.Load(),
+		BroadcastLimit: config.Network.BroadcastLimit,
+	}
+}
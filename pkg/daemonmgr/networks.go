@@ -0,0 +1,335 @@
+package daemonmgr
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/symmetric"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// maxNetworkIDLength bounds virtual network names
+const maxNetworkIDLength = 64
+
+// VirtualNetwork is a named network the daemon joins, and where its frames go on this node
+// A network is carried untagged on the main device, with an 802.1Q tag on it, or on a
+// TAP device of its own. The relay only passes a peer the frames of networks it joined,
+// but any peer can join any network there: the network's key is what keeps others out,
+// as its frames are encrypted under a key derived from it (rotation.DeriveNetworkKey).
+type VirtualNetwork struct {
+	ID      string `yaml:"id"`       // Network name; peers that join the same name share the network
+	Key     string `yaml:"key"`      // Hex-encoded 32-byte key shared by the network's members
+	KeyFile string `yaml:"key_file"` // File holding key instead (mode 0600 or stricter)
+	VLAN    int    `yaml:"vlan"`     // 802.1Q tag of the network's frames on the main device (0: untagged)
+	Device  string `yaml:"device"`   // TAP device of its own instead of the main device
+}
+
+// NetworkStatus reports a joined virtual network for the status API
+type NetworkStatus struct {
+	ID       string `json:"id"`
+	VLAN     int    `json:"vlan,omitempty"`   // Tag on the main device
+	Device   string `json:"device,omitempty"` // Own device; empty for the main device
+	TxFrames uint64 `json:"tx_frames"`        // Frames sent to peers
+	RxFrames uint64 `json:"rx_frames"`        // Frames delivered from peers
+}
+
+// virtualNetwork is a joined network with the device its frames are on
+type virtualNetwork struct {
+	VirtualNetwork
+	id     uint32               // Carried in its frames (frameencryption.NetworkID)
+	device layer2.NetworkDevice // Own device; nil for the main device
+
+	txFrames atomic.Uint64
+	rxFrames atomic.Uint64
+}
+
+// wireID returns the network ID of the network's frames; nil is the default network
+func (n *virtualNetwork) wireID() uint32 {
+	if n == nil {
+		return frameencryption.DefaultNetwork
+	}
+	return n.id
+}
+
+// primary reports whether the network's frames are subject to the tunnel address checks and neighbour proxy
+// That is the default network, or the one untagged on the main device: both are about
+// tunnel addresses, which networks on a VLAN or their own device do not use. The ACL
+// applies in every network, with rules scoped by acl.rules[].networks; elsewhere source
+// addresses go unchecked, so rules by address match whatever address a peer sends from.
+func (n *virtualNetwork) primary() bool {
+	return n == nil || (n.device == nil && n.VLAN == 0)
+}
+
+// networkTable maps frames to the joined networks
+type networkTable struct {
+	byID   map[uint32]*virtualNetwork
+	byVLAN map[uint16]*virtualNetwork // Networks on the main device by tag (0: untagged)
+	list   []*virtualNetwork          // In configuration order
+}
+
+// networkIDs returns the names of the networks to join at the relay
+func (c *DaemonConfig) networkIDs() []string {
+	ids := make([]string, 0, len(c.Networks))
+	for _, network := range c.Networks {
+		ids = append(ids, network.ID)
+	}
+	return ids
+}
+
+// networkKeys returns the keys of the networks by the ID carried in their frames
+// Keys are checked by validateNetworks.
+func (c *DaemonConfig) networkKeys() map[uint32][symmetric.KeySize]byte {
+	keys := make(map[uint32][symmetric.KeySize]byte, len(c.Networks))
+	for _, network := range c.Networks {
+		var key [symmetric.KeySize]byte
+		hex.Decode(key[:], []byte(network.Key))
+		keys[frameencryption.NetworkID(network.ID)] = key
+	}
+	return keys
+}
+
+// validNetworkID reports whether id is usable as a network name: letters, digits, '-', '_' and '.'
+func validNetworkID(id string) bool {
+	if id == "" || len(id) > maxNetworkIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateNetworks checks the networks section
+func (c *DaemonConfig) validateNetworks(fail func(field, format string, args ...interface{})) {
+	if len(c.Networks) > 0 && c.Network.Mode == layer2.ModeTUN {
+		fail("networks", "networks need network.mode tap, they are separated by VLAN tag or device")
+	}
+
+	ids := make(map[string]bool)
+	vlans := make(map[int]string)
+	devices := map[string]bool{c.Network.DeviceName: true, c.Network.TAPDevice: true}
+	for i, network := range c.Networks {
+		field := fmt.Sprintf("networks[%d]", i)
+		switch {
+		case !validNetworkID(network.ID):
+			fail(field+".id", "%s.id must be 1 to %d letters, digits, '-', '_' or '.', got %q", field, maxNetworkIDLength, network.ID)
+		case ids[network.ID]:
+			fail(field+".id", "%s.id %q is listed twice", field, network.ID)
+		}
+		ids[network.ID] = true
+
+		if network.Key == "" {
+			fail(field+".key", "%s.key is required (or %s.key_file): only peers holding it can read or send the network's frames", field, field)
+		} else if key, err := hex.DecodeString(network.Key); err != nil || len(key) != symmetric.KeySize {
+			fail(field+".key", "%s.key must be 64 hex characters (32 bytes)", field)
+		}
+
+		if network.Device != "" {
+			switch {
+			case network.VLAN != 0:
+				fail(field+".vlan", "%s: vlan and device exclude each other; a network on its own device is not tagged", field)
+			case len(network.Device) > maxInterfaceNameLength || strings.ContainsAny(network.Device, "/ \t"):
+				fail(field+".device", "%s.device must be an interface name of at most %d characters without spaces or slashes", field, maxInterfaceNameLength)
+			case devices[network.Device]:
+				fail(field+".device", "%s.device %s is already in use", field, network.Device)
+			}
+			devices[network.Device] = true
+			continue
+		}
+
+		switch other, taken := vlans[network.VLAN]; {
+		case network.VLAN < 0 || network.VLAN > layer2.MaxVLAN:
+			fail(field+".vlan", "%s.vlan must be between 1 and %d, or 0 for untagged frames", field, layer2.MaxVLAN)
+		case taken && network.VLAN == 0:
+			fail(field, "%s: only one network can be untagged on the main device, %s already is", field, other)
+		case taken:
+			fail(field+".vlan", "%s.vlan %d is already used by %s", field, network.VLAN, other)
+		}
+		vlans[network.VLAN] = network.ID
+	}
+}
+
+// SetVirtualNetworkDevice makes the daemon use device for the network with the given id instead of creating one
+// Must be called before Start, like SetNetworkDevice; the network must have a device in
+// the configuration.
+func (dm *DaemonManager) SetVirtualNetworkDevice(id string, device layer2.NetworkDevice) {
+	if dm.networkDevices == nil {
+		dm.networkDevices = make(map[string]layer2.NetworkDevice)
+	}
+	dm.networkDevices[id] = device
+}
+
+// initNetworks sets up the configured networks, creating and starting the devices of those with their own
+// Called once the main device is up. Without networks every frame is in the default network.
+func (dm *DaemonManager) initNetworks() error {
+	config := dm.cfg()
+	if len(config.Networks) == 0 {
+		return nil
+	}
+	if dm.tapDevice.Layer() != layer2.Layer2 {
+		return fmt.Errorf("networks need a TAP device, %s carries %v traffic", dm.tapDevice.Name(), dm.tapDevice.Layer())
+	}
+
+	table := &networkTable{
+		byID:   make(map[uint32]*virtualNetwork),
+		byVLAN: make(map[uint16]*virtualNetwork),
+	}
+	for _, vn := range config.Networks {
+		network := &virtualNetwork{VirtualNetwork: vn, id: frameencryption.NetworkID(vn.ID)}
+		if other, ok := table.byID[network.id]; ok {
+			stopNetworkDevices(table.list)
+			return fmt.Errorf("networks %s and %s have the same ID, rename one", other.ID, vn.ID)
+		}
+
+		if vn.Device != "" {
+			device, err := dm.networkDevice(vn)
+			if err != nil {
+				stopNetworkDevices(table.list)
+				return err
+			}
+			network.device = device
+		} else {
+			table.byVLAN[uint16(vn.VLAN)] = network
+		}
+		table.byID[network.id] = network
+		table.list = append(table.list, network)
+		logger.Info("joined network", "network", vn.ID, "vlan", vn.VLAN, "device", network.deviceName(dm.tapDevice))
+	}
+
+	dm.networks = table
+	return nil
+}
+
+// networkDevice creates, brings up and starts the TAP device of a network
+func (dm *DaemonManager) networkDevice(network VirtualNetwork) (layer2.NetworkDevice, error) {
+	device := dm.networkDevices[network.ID]
+	if device == nil {
+		var err error
+		device, err = layer2.NewNetworkDevice(layer2.DeviceConfig{Mode: layer2.ModeTAP, Name: network.Device, MTU: dm.tapDevice.MTU()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create device %s for network %s: %w", network.Device, network.ID, err)
+		}
+	} else if device.Layer() != layer2.Layer2 {
+		return nil, fmt.Errorf("device %s for network %s carries %v traffic, networks need TAP devices", device.Name(), network.ID, device.Layer())
+	}
+
+	if err := device.SetUp(true); err != nil {
+		device.Stop()
+		return nil, fmt.Errorf("failed to bring up device %s for network %s: %w", device.Name(), network.ID, err)
+	}
+	device.Start()
+	return device, nil
+}
+
+// deviceName returns the name of the device the network's frames are on
+func (n *virtualNetwork) deviceName(main layer2.NetworkDevice) string {
+	if n.device != nil {
+		return n.device.Name()
+	}
+	return main.Name()
+}
+
+// stopNetworkDevices stops the devices of networks that have their own
+func stopNetworkDevices(networks []*virtualNetwork) {
+	for _, network := range networks {
+		if network.device == nil {
+			continue
+		}
+		if err := network.device.Stop(); err != nil {
+			logger.Warn("error stopping network device", "network", network.ID, "error", err)
+		}
+	}
+}
+
+// mainDeviceNetwork returns the network of a frame read from the main device and removes its VLAN tag
+// Without networks, frames are in the default network with their tags. ok is false
+// for a tag no network uses.
+func (dm *DaemonManager) mainDeviceNetwork(frame *layer2.EthernetFrame) (network *virtualNetwork, ok bool) {
+	if dm.networks == nil {
+		return nil, true
+	}
+	network, ok = dm.networks.byVLAN[frame.VLAN]
+	if ok {
+		frame.VLAN, frame.Priority = 0, 0
+	}
+	return network, ok
+}
+
+// deliveredNetwork returns the joined network a frame delivered from a peer belongs to
+// ok is false for a network this node has not joined, and for frames with a VLAN tag
+// in a network on the main device, which would otherwise reach another network there.
+func (dm *DaemonManager) deliveredNetwork(frame *frameencryption.DecryptedFrame) (network *virtualNetwork, ok bool) {
+	if dm.networks == nil {
+		return nil, frame.Network == frameencryption.DefaultNetwork
+	}
+	network, ok = dm.networks.byID[frame.Network]
+	if !ok {
+		return nil, false
+	}
+	if network.device == nil && len(frame.Payload) >= layer2.EthernetHeaderSize &&
+		binary.BigEndian.Uint16(frame.Payload[12:14]) == layer2.EtherTypeVLAN {
+		return nil, false
+	}
+	return network, true
+}
+
+// networkOutput returns the device channel a delivered frame of network goes to, and the frame to write
+// Frames of a network on a VLAN get its tag.
+func (dm *DaemonManager) networkOutput(network *virtualNetwork, payload []byte) (chan<- []byte, []byte) {
+	switch {
+	case network == nil || (network.device == nil && network.VLAN == 0):
+		return dm.tapDevice.WriteChannel(), payload
+	case network.device != nil:
+		return network.device.WriteChannel(), payload
+	}
+
+	tagged := make([]byte, len(payload)+layer2.VLANTagSize)
+	copy(tagged[0:12], payload[0:12])
+	binary.BigEndian.PutUint16(tagged[12:14], layer2.EtherTypeVLAN)
+	binary.BigEndian.PutUint16(tagged[14:16], uint16(network.VLAN))
+	copy(tagged[16:], payload[12:])
+	return dm.tapDevice.WriteChannel(), tagged
+}
+
+// frameRouterNetwork routes frames from a network's own device → Encrypt
+func (dm *DaemonManager) frameRouterNetwork(ctx context.Context, network *virtualNetwork) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet := <-network.device.ReadChannel():
+			dm.sendDeviceFrame(layer2.Layer2, network, packet)
+		}
+	}
+}
+
+// networkStatus reports the joined networks; nil without networks
+func (dm *DaemonManager) networkStatus() []NetworkStatus {
+	if dm.networks == nil {
+		return nil
+	}
+	status := make([]NetworkStatus, 0, len(dm.networks.list))
+	for _, network := range dm.networks.list {
+		var device string
+		if network.device != nil {
+			device = network.device.Name()
+		}
+		status = append(status, NetworkStatus{
+			ID:       network.ID,
+			VLAN:     network.VLAN,
+			Device:   device,
+			TxFrames: network.txFrames.Load(),
+			RxFrames: network.rxFrames.Load(),
+		})
+	}
+	return status
+}
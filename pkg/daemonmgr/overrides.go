@@ -0,0 +1,258 @@
+package daemonmgr
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables that override configuration fields
+// The rest of the name is the field's YAML path in upper case with dots as underscores,
+// e.g. SHADOWMESH_NETWORK_LOCAL_IP for network.local_ip.
+const EnvPrefix = "SHADOWMESH_"
+
+// EnvVarName returns the environment variable that overrides a field, e.g. "encryption.key"
+func EnvVarName(field string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(field, ".", "_"))
+}
+
+// applyEnvOverrides sets every field that has a SHADOWMESH_* environment variable
+// Lists are comma-separated, maps are comma-separated key=value pairs, and an empty
+// variable resets the field to its default. A variable naming a section but no field
+// in it is reported like an unknown field in the file.
+func applyEnvOverrides(config *DaemonConfig) error {
+	var errs ConfigErrors
+	known := make(map[string]string)
+	applyEnv(reflect.ValueOf(config).Elem(), "", config, known, &errs)
+	checkUnknownEnv(known, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// applyEnv walks the fields of v, decoding the environment variable of each one that has it set
+// Every field's variable is recorded in known (variable → YAML path).
+func applyEnv(v reflect.Value, prefix string, config *DaemonConfig, known map[string]string, errs *ConfigErrors) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			applyEnv(v.Field(i), name, config, known, errs)
+			continue
+		}
+
+		variable := EnvVarName(name)
+		known[variable] = name
+		value, ok := os.LookupEnv(variable)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			*errs = append(*errs, &ConfigError{
+				File:    variable,
+				Field:   name,
+				Message: fmt.Sprintf("%s must be %s, got %q", name, describeType(field.Type), value),
+			})
+			continue
+		}
+		config.positions[name] = position{source: variable}
+	}
+}
+
+// checkUnknownEnv reports SHADOWMESH_* variables within a configuration section that match no field
+// Variables outside every section, like the CLI's SHADOWMESH_API, belong to other programs.
+func checkUnknownEnv(known map[string]string, errs *ConfigErrors) {
+	var sections, variables []string
+	for variable, field := range known {
+		variables = append(variables, variable)
+		if section, _, ok := strings.Cut(field, "."); ok && !containsString(sections, section) {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(variables)
+
+	var unknown []string
+	for _, entry := range os.Environ() {
+		variable, _, _ := strings.Cut(entry, "=")
+		if _, ok := known[variable]; ok || !strings.HasPrefix(variable, EnvPrefix) {
+			continue
+		}
+		for _, section := range sections {
+			if strings.HasPrefix(variable, EnvVarName(section)+"_") {
+				unknown = append(unknown, variable)
+				break
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, variable := range unknown {
+		message := "unknown configuration variable"
+		if suggestion := closestName(variable, variables); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+		}
+		*errs = append(*errs, &ConfigError{File: variable, Message: message})
+	}
+}
+
+// setFromEnv decodes an environment variable's value into a field
+func setFromEnv(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		field.Set(reflect.Zero(field.Type()))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String:
+		// key=value pairs, e.g. SHADOWMESH_DAEMON_LOG_LEVELS=nat=debug,router=warn
+		items := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			key, itemValue, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=value", item)
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(itemValue)
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		// Booleans, numbers and durations parse as they would in the YAML file
+		node := yaml.Node{Kind: yaml.ScalarNode, Value: value}
+		return node.Decode(field.Addr().Interface())
+	}
+	return nil
+}
+
+// secret is a field that can be read from a file instead of being written into the configuration
+type secret struct {
+	field      string  // YAML path of the value
+	value      *string // The value
+	file       *string // Its *_file setting
+	credential string  // systemd credential name (LoadCredential=); empty for none
+}
+
+// secrets lists the configuration's secret fields
+// Network keys have the credential networks.<id>.key, if the ID is valid.
+func (c *DaemonConfig) secrets() []secret {
+	secrets := []secret{
+		{field: "encryption.key", value: &c.Encryption.Key, file: &c.Encryption.KeyFile, credential: "encryption.key"},
+		{field: "identity.key", value: &c.Identity.Key, file: &c.Identity.KeyFile, credential: "identity.key"},
+		{field: "daemon.api_token", value: &c.Daemon.APIToken, file: &c.Daemon.APITokenFile, credential: "daemon.api_token"},
+	}
+	for i := range c.Networks {
+		network := &c.Networks[i]
+		var credential string
+		if validNetworkID(network.ID) {
+			credential = "networks." + network.ID + ".key"
+		}
+		secrets = append(secrets, secret{field: fmt.Sprintf("networks[%d].key", i), value: &network.Key, file: &network.KeyFile, credential: credential})
+	}
+	return secrets
+}
+
+// loadSecrets reads secrets from their *_file settings, or from systemd credentials
+// A credential in $CREDENTIALS_DIRECTORY is used only when neither the value nor its
+// file is configured. Secret files must not be accessible to group or others.
+func loadSecrets(config *DaemonConfig) error {
+	var errs ConfigErrors
+	fail := func(field, source, format string, args ...interface{}) {
+		configErr := &ConfigError{File: config.path, Field: field, Message: fmt.Sprintf(format, args...)}
+		if pos, ok := config.positions[field]; ok {
+			configErr.Line, configErr.Column = pos.line, pos.column
+			if pos.source != "" {
+				configErr.File = pos.source
+			}
+		}
+		if source != "" {
+			configErr.File, configErr.Line, configErr.Column = source, 0, 0
+		}
+		errs = append(errs, configErr)
+	}
+
+	credentials := os.Getenv("CREDENTIALS_DIRECTORY")
+	for _, s := range config.secrets() {
+		fileField := s.field + "_file"
+		path := *s.file
+
+		switch {
+		case path != "" && *s.value != "":
+			fail(fileField, "", "set either %s or %s, not both", s.field, fileField)
+			continue
+		case path == "" && *s.value == "" && credentials != "" && s.credential != "":
+			candidate := filepath.Join(credentials, s.credential)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+		if path == "" {
+			continue
+		}
+
+		value, err := readSecretFile(path)
+		if err != nil {
+			if path == *s.file {
+				fail(fileField, "", "%s: %v", fileField, err)
+			} else {
+				fail(s.field, path, "%s credential: %v", s.field, err)
+			}
+			continue
+		}
+		*s.value = value
+		config.positions[s.field] = position{source: path}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// readSecretFile reads a secret, refusing files that group or others can access
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%s does not exist", path)
+		}
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return "", fmt.Errorf("%s is accessible by group or others (mode %04o); run chmod 600 %s", path, perm, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
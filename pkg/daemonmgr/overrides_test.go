@@ -0,0 +1,164 @@
+package daemonmgr
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigEnvOverrides tests overriding configuration fields with SHADOWMESH_* variables
+func TestConfigEnvOverrides(t *testing.T) {
+	path := writeConfig(t, "network:\n  local_ip: 10.0.0.1/24\n  mtu: 1400\nencryption:\n  key: short\n")
+
+	t.Setenv("SHADOWMESH_ENCRYPTION_KEY", testConfigKey)
+	t.Setenv("SHADOWMESH_NETWORK_MTU", "1280")
+	t.Setenv("SHADOWMESH_MULTIPATH_INTERFACES", "eth0, wwan0")
+	t.Setenv("SHADOWMESH_ENCRYPTION_ROTATION_INTERVAL", "1h")
+	t.Setenv("SHADOWMESH_DAEMON_LOG_LEVELS", "nat=debug, router=warn")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Overridden key rejected: %v", err)
+	}
+	if config.Network.MTU != 1280 || config.Network.LocalIP != "10.0.0.1/24" || config.Encryption.RotationInterval != time.Hour {
+		t.Errorf("Overrides not applied: %+v", config.Network)
+	}
+	if len(config.Multipath.Interfaces) != 2 || config.Multipath.Interfaces[1] != "wwan0" {
+		t.Errorf("Unexpected interfaces %q", config.Multipath.Interfaces)
+	}
+	if config.Daemon.LogLevels["router"] != "warn" {
+		t.Errorf("Unexpected log levels %v", config.Daemon.LogLevels)
+	}
+
+	// Problems with an overridden value point at the variable, not the file
+	t.Setenv("SHADOWMESH_NETWORK_MTU", "100")
+	config, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "SHADOWMESH_NETWORK_MTU: network.mtu must be") {
+		t.Errorf("Expected an error naming the variable, got %v", err)
+	}
+
+	// An empty variable resets the field
+	t.Setenv("SHADOWMESH_NETWORK_MTU", "")
+	if config, err := LoadConfig(path); err != nil || config.Network.MTU != 0 {
+		t.Errorf("Empty variable did not reset network.mtu (%v)", err)
+	}
+}
+
+// TestConfigEnvInvalid tests that badly typed and unknown SHADOWMESH_* variables are reported
+func TestConfigEnvInvalid(t *testing.T) {
+	path := writeConfig(t, "network:\n  local_ip: 10.0.0.1/24\n"+keySection)
+
+	tests := []struct {
+		name     string
+		variable string
+		value    string
+		want     string
+	}{
+		{"number", "SHADOWMESH_NETWORK_MTU", "large", "SHADOWMESH_NETWORK_MTU: network.mtu must be a whole number, got \"large\""},
+		{"boolean", "SHADOWMESH_P2P_LISTENER_ENABLED", "maybe", "SHADOWMESH_P2P_LISTENER_ENABLED: p2p.listener_enabled must be true or false"},
+		{"duration", "SHADOWMESH_RECONNECT_MAX_BACKOFF", "soon", "reconnect.max_backoff must be a duration"},
+		{"map", "SHADOWMESH_DAEMON_LOG_LEVELS", "nat", "daemon.log_levels must be"},
+		{"unknown field", "SHADOWMESH_NETWORK_MTUU", "1280", "SHADOWMESH_NETWORK_MTUU: unknown configuration variable (did you mean SHADOWMESH_NETWORK_MTU?)"},
+		{"unknown field without suggestion", "SHADOWMESH_NAT_BOGUS_SETTING", "1", "SHADOWMESH_NAT_BOGUS_SETTING: unknown configuration variable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.variable, tt.value)
+
+			_, err := LoadConfig(path)
+			var errs ConfigErrors
+			if !errors.As(err, &errs) || len(errs) != 1 {
+				t.Fatalf("LoadConfig() error = %v, want one ConfigError", err)
+			}
+			if !strings.Contains(errs[0].Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", errs[0].Error(), tt.want)
+			}
+		})
+	}
+
+	// Variables outside the configuration's sections belong to other programs
+	t.Setenv("SHADOWMESH_API", "unix:/run/shadowmesh/daemon.sock")
+	t.Setenv("SHADOWMESH_AUTO_INSTALL", "1")
+	if _, err := LoadConfig(path); err != nil {
+		t.Errorf("LoadConfig() rejected a variable outside the configuration: %v", err)
+	}
+}
+
+// TestConfigKeyFile tests reading the key from key_file and systemd credentials
+func TestConfigKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key := strings.Repeat("cd", 32)
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(key+"\n"), 0600)
+
+	path := writeConfig(t, "network:\n  local_ip: 10.0.0.1/24\nencryption:\n  key_file: "+keyFile+"\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Encryption.Key != key {
+		t.Errorf("Key not read from key_file: %q", config.Encryption.Key)
+	}
+
+	// A key file others can read is refused
+	for _, mode := range []os.FileMode{0640, 0604} {
+		os.Chmod(keyFile, mode)
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+			t.Errorf("Mode %04o: expected a permissions error, got %v", mode, err)
+		}
+	}
+	os.Chmod(keyFile, 0600)
+
+	// Setting both the key and its file is ambiguous
+	t.Setenv("SHADOWMESH_ENCRYPTION_KEY", key)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Errorf("Expected a conflict error, got %v", err)
+	}
+	os.Unsetenv("SHADOWMESH_ENCRYPTION_KEY")
+
+	// Missing and empty key files
+	os.WriteFile(keyFile, nil, 0600)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("Expected an empty file error, got %v", err)
+	}
+	os.Remove(keyFile)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+
+	// Without a key or key_file, a systemd credential provides it
+	credentials := filepath.Join(dir, "credentials")
+	os.Mkdir(credentials, 0700)
+	os.WriteFile(filepath.Join(credentials, "encryption.key"), []byte(key), 0400)
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	os.WriteFile(path, []byte("network:\n  local_ip: 10.0.0.1/24\n"), 0600)
+
+	config, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Encryption.Key != key {
+		t.Errorf("Key not read from the credential: %q", config.Encryption.Key)
+	}
+	// Network keys have credentials of their own
+	os.WriteFile(filepath.Join(credentials, "networks.office.key"), []byte(key), 0400)
+	os.WriteFile(path, []byte("network:\n  local_ip: 10.0.0.1/24\n  mode: tap\nnetworks:\n  - id: office\n"), 0600)
+
+	config, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Networks[0].Key != key {
+		t.Errorf("Network key not read from the credential: %q", config.Networks[0].Key)
+	}
+}
@@ -2,20 +2,27 @@ package daemonmgr
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"fmt"
-	"log"
 	"math/big"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/shadowmesh/shadowmesh/pkg/fec"
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+	"github.com/shadowmesh/shadowmesh/pkg/multipath"
 )
 
 // TransportMode defines the connection transport type
@@ -26,6 +33,18 @@ const (
 	TransportUDP                            // UDP transport (direct P2P)
 )
 
+// String returns the transport's name as reported by the status API
+func (m TransportMode) String() string {
+	switch m {
+	case TransportUDP:
+		return "udp"
+	case TransportWebSocket:
+		return "websocket"
+	default:
+		return "unknown"
+	}
+}
+
 // P2PConnection manages a single P2P connection (UDP or WebSocket)
 type P2PConnection struct {
 	// Transport mode
@@ -40,6 +59,10 @@ type P2PConnection struct {
 	udpPeerAddr  *net.UDPAddr
 	udpConnMutex sync.RWMutex
 
+	// Multipath bond carrying the UDP transport; the hole-punched socket is its first path
+	bond            *multipath.Bond
+	multipathConfig multipath.Config
+
 	peerAddr string
 
 	// Channels for frame transmission
@@ -50,6 +73,11 @@ type P2PConnection struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
+	server *http.Server // P2P listener, if started
+
+	// Current transport (WebSocket or UDP); replaced by every new connection
+	transport   *transport
+	transportMu sync.Mutex
 
 	// State
 	connected   bool
@@ -58,10 +86,37 @@ type P2PConnection struct {
 	// Connection callback - called when incoming connection is accepted
 	onConnectionAccepted func()
 
+	// Called once when a transport fails (not when it is closed); see SetOnTransportLost
+	onTransportLost func(err error)
+
 	// Relay mode
 	relayMode   bool
 	relayServer string
 	peerID      string
+	networks    []string // Virtual networks joined at the relay (none: the default network)
+
+	// Tunnel addresses assigned by the relay (see ipam.Assignment)
+	awaitAssignment bool                            // ConnectViaRelay fails unless the relay assigns addresses
+	assignment      atomic.Pointer[ipam.Assignment] // Latest assignment received; nil if none
+	leaseKey        ed25519.PrivateKey              // Signs our lease claims at the relay (ipam.ClaimHeader); nil for none
+	staticAddress   netip.Addr                      // Configured IPv4 address the relay reserves for us; zero if assigned
+
+	// Forward error correction (UDP transport only)
+	fecEncoder atomic.Pointer[fec.Encoder] // nil = send datagrams as-is
+	fecDecoder *fec.Decoder                // Only touched by recvLoopUDP
+
+	// Datagram counters (after FEC encoding / before FEC decoding), used to measure loss
+	txPackets uint64
+	rxPackets uint64
+	rxDropped uint64 // Frames discarded because the receive buffer was full
+}
+
+// transport is the lifetime of one connection's send and receive loops
+type transport struct {
+	ctx    context.Context // Cancelled when the transport is closed
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // Send and receive loops
+	once   sync.Once      // Reports the first failure only
 }
 
 // NewP2PConnection creates a new P2P connection
@@ -69,39 +124,51 @@ func NewP2PConnection() *P2PConnection {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &P2PConnection{
-		sendChan: make(chan []byte, 1000), // Increased from 100 to handle bursts
-		recvChan: make(chan []byte, 1000), // Increased from 100 to handle bursts
-		ctx:      ctx,
-		cancel:   cancel,
+		sendChan:   make(chan []byte, 1000), // Increased from 100 to handle bursts
+		recvChan:   make(chan []byte, 1000), // Increased from 100 to handle bursts
+		fecDecoder: fec.NewDecoder(),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
 // ConnectUDP establishes direct UDP P2P connection using hole punching
 func (p *P2PConnection) ConnectUDP(udpConn *net.UDPConn, peerAddr *net.UDPAddr) error {
+	p.closeTransport()
 	p.peerAddr = peerAddr.String()
 	p.transportMode = TransportUDP
+	p.relayMode = false
+
+	bond := multipath.NewBond(p.multipathConfig)
+	if err := bond.AddPath("primary", udpConn, peerAddr, true); err != nil {
+		bond.Close()
+		return fmt.Errorf("failed to add primary path: %w", err)
+	}
 
 	p.udpConnMutex.Lock()
 	p.udpConn = udpConn
 	p.udpPeerAddr = peerAddr
+	p.bond = bond
 	p.udpConnMutex.Unlock()
 
 	p.setConnected(true)
 
-	log.Printf("✅ Direct UDP P2P connection established to %s", peerAddr)
+	p2pLogger.Debug("UDP transport started", "peer", peerAddr)
 
 	// Start send/receive goroutines for UDP
-	p.wg.Add(2)
-	go p.sendLoopUDP()
-	go p.recvLoopUDP()
+	t := p.startTransport()
+	go p.sendLoopUDP(t)
+	go p.recvLoopUDP(t)
 
 	return nil
 }
 
 // Connect establishes WebSocket connection to peer
 func (p *P2PConnection) Connect(peerAddr string) error {
+	p.closeTransport()
 	p.peerAddr = peerAddr
 	p.transportMode = TransportWebSocket
+	p.relayMode = false
 
 	// Parse peer address
 	host, port, err := net.SplitHostPort(peerAddr)
@@ -113,7 +180,7 @@ func (p *P2PConnection) Connect(peerAddr string) error {
 	// Traffic is encrypted at frame level with ChaCha20-Poly1305, so TLS is redundant
 	wsURL := fmt.Sprintf("ws://%s:%s/p2p", host, port)
 
-	log.Printf("Connecting to peer WebSocket: %s", wsURL)
+	p2pLogger.Debug("dialling peer WebSocket", "url", wsURL)
 
 	dialer := &websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
@@ -135,12 +202,12 @@ func (p *P2PConnection) Connect(peerAddr string) error {
 
 	p.setConnected(true)
 
-	log.Printf("✅ WebSocket connection established to %s", peerAddr)
+	p2pLogger.Debug("WebSocket connection established", "peer", peerAddr)
 
 	// Start send/receive goroutines
-	p.wg.Add(2)
-	go p.sendLoop()
-	go p.recvLoop()
+	t := p.startTransport()
+	go p.sendLoop(t)
+	go p.recvLoop(t)
 
 	return nil
 }
@@ -161,14 +228,15 @@ func (p *P2PConnection) Listen(listenAddr string) error {
 	server := &http.Server{
 		Handler: mux,
 	}
+	p.server = server
 
 	// Start server in background
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		log.Printf("WebSocket server listening on %s (IPv4, unencrypted transport, encrypted frames)", listenAddr)
+		p2pLogger.Debug("WebSocket server listening (IPv4, unencrypted transport, encrypted frames)", "address", listenAddr)
 		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			log.Printf("⚠️  WebSocket server error: %v", err)
+			p2pLogger.Error("WebSocket server failed", "error", err)
 		}
 	}()
 
@@ -196,38 +264,100 @@ func (p *P2PConnection) RecvChannel() <-chan []byte {
 	return p.recvChan
 }
 
-// Close closes the P2P connection
+// Close closes the transport and the P2P listener; the connection cannot be used afterwards
 func (p *P2PConnection) Close() error {
+	p.closeTransport()
 	p.cancel()
 
+	if p.server != nil {
+		p.server.Close()
+	}
+
+	p.wg.Wait()
+
+	return nil
+}
+
+// CloseTransport closes the current connection but keeps the P2P listener running
+// The next Connect, ConnectUDP, ConnectViaRelay or accepted connection starts a new transport.
+func (p *P2PConnection) CloseTransport() {
+	p.closeTransport()
+}
+
+// startTransport begins the lifetime of a connection's send and receive loops
+// The caller starts both loops, passing them the transport.
+func (p *P2PConnection) startTransport() *transport {
+	ctx, cancel := context.WithCancel(p.ctx)
+	t := &transport{ctx: ctx, cancel: cancel}
+	t.wg.Add(2)
+
+	p.transportMu.Lock()
+	p.transport = t
+	p.transportMu.Unlock()
+
+	return t
+}
+
+// closeTransport stops the current transport's loops and closes its sockets
+// Loops stopped this way do not report the transport as lost.
+func (p *P2PConnection) closeTransport() {
+	p.transportMu.Lock()
+	t := p.transport
+	p.transport = nil
+	p.transportMu.Unlock()
+
+	// Cancel first, so that loops failing on the closed sockets know it was intended
+	if t != nil {
+		t.cancel()
+	}
+
 	// Close WebSocket connection if exists
 	p.connMutex.Lock()
 	if p.conn != nil {
 		p.conn.Close()
+		p.conn = nil
 	}
 	p.connMutex.Unlock()
 
-	// Close UDP connection if exists
+	// Close UDP connection if exists (the bond owns every path's socket)
 	p.udpConnMutex.Lock()
-	if p.udpConn != nil {
+	if p.bond != nil {
+		p.bond.Close()
+	} else if p.udpConn != nil {
 		p.udpConn.Close()
 	}
+	p.bond = nil
+	p.udpConn = nil
+	p.udpPeerAddr = nil
 	p.udpConnMutex.Unlock()
 
 	p.setConnected(false)
 
-	p.wg.Wait()
+	if t != nil {
+		t.wg.Wait()
+	}
+}
 
-	return nil
+// transportFailed marks the connection down and reports the failure once per transport
+func (p *P2PConnection) transportFailed(t *transport, err error) {
+	if t.ctx.Err() != nil {
+		return // Closed on purpose
+	}
+	p.setConnected(false)
+	t.once.Do(func() {
+		if p.onTransportLost != nil {
+			p.onTransportLost(err)
+		}
+	})
 }
 
 // sendLoop sends frames over WebSocket
-func (p *P2PConnection) sendLoop() {
-	defer p.wg.Done()
+func (p *P2PConnection) sendLoop(t *transport) {
+	defer t.wg.Done()
 
 	for {
 		select {
-		case <-p.ctx.Done():
+		case <-t.ctx.Done():
 			return
 		case frame := <-p.sendChan:
 			p.connMutex.RLock()
@@ -240,17 +370,20 @@ func (p *P2PConnection) sendLoop() {
 
 			// Send binary frame
 			if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
-				log.Printf("⚠️  Failed to send frame: %v", err)
-				p.setConnected(false)
+				if t.ctx.Err() == nil {
+					p2pLogger.Warn("failed to send frame, connection lost", "error", err)
+				}
+				p.transportFailed(t, fmt.Errorf("send failed: %w", err))
 				return
 			}
+			atomic.AddUint64(&p.txPackets, 1)
 		}
 	}
 }
 
 // recvLoop receives frames from WebSocket
-func (p *P2PConnection) recvLoop() {
-	defer p.wg.Done()
+func (p *P2PConnection) recvLoop(t *transport) {
+	defer t.wg.Done()
 
 	for {
 		p.connMutex.RLock()
@@ -264,113 +397,242 @@ func (p *P2PConnection) recvLoop() {
 		// Read frame
 		msgType, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("⚠️  WebSocket read error: %v", err)
-			p.setConnected(false)
+			if t.ctx.Err() == nil {
+				p2pLogger.Warn("WebSocket read failed, connection lost", "error", err)
+			}
+			p.transportFailed(t, fmt.Errorf("receive failed: %w", err))
 			return
 		}
 
+		if msgType == websocket.TextMessage && p.handleRelayMessage(data) {
+			continue
+		}
 		if msgType != websocket.BinaryMessage {
-			log.Printf("⚠️  Unexpected message type: %d", msgType)
+			unexpectedMessageLog.Warn(p2pLogger, "unexpected WebSocket message type", "type", msgType)
 			continue
 		}
+		atomic.AddUint64(&p.rxPackets, 1)
 
 		// Send to receive channel
 		select {
 		case p.recvChan <- data:
-		case <-p.ctx.Done():
+		case <-t.ctx.Done():
 			return
 		default:
-			log.Printf("⚠️  Receive buffer full, dropping frame")
+			atomic.AddUint64(&p.rxDropped, 1)
+			recvFullLog.Warn(p2pLogger, "receive buffer full, dropping frame", "transport", "websocket")
 		}
 	}
 }
 
 // sendLoopUDP sends frames over UDP
-func (p *P2PConnection) sendLoopUDP() {
-	defer p.wg.Done()
+func (p *P2PConnection) sendLoopUDP(t *transport) {
+	defer t.wg.Done()
+
+	// Closes partially filled FEC groups so their parity is not held back
+	flushTicker := time.NewTicker(fec.DefaultFlushInterval / 2)
+	defer flushTicker.Stop()
 
 	for {
 		select {
-		case <-p.ctx.Done():
+		case <-t.ctx.Done():
 			return
-		case frame := <-p.sendChan:
-			p.udpConnMutex.RLock()
-			conn := p.udpConn
-			peerAddr := p.udpPeerAddr
-			p.udpConnMutex.RUnlock()
-
-			if conn == nil || peerAddr == nil {
+		case now := <-flushTicker.C:
+			encoder := p.fecEncoder.Load()
+			if encoder == nil {
 				continue
 			}
-
-			// Send UDP packet
-			_, err := conn.WriteToUDP(frame, peerAddr)
+			parity, err := encoder.Flush(now)
 			if err != nil {
-				log.Printf("⚠️  Failed to send UDP frame: %v", err)
-				p.setConnected(false)
+				fecErrorLog.Warn(fecLogger, "FEC flush failed", "error", err)
+			}
+			if !p.writeUDP(t, parity) {
 				return
 			}
+		case frame := <-p.sendChan:
+			datagrams := [][]byte{frame}
+			if encoder := p.fecEncoder.Load(); encoder != nil {
+				encoded, err := encoder.Encode(frame, time.Now())
+				if err != nil {
+					fecErrorLog.Warn(fecLogger, "FEC encoding failed", "error", err)
+				}
+				datagrams = encoded
+			}
+			if !p.writeUDP(t, datagrams) {
+				return
+			}
+		}
+	}
+}
+
+// writeUDP sends datagrams to the peer, returning false if no path could send
+func (p *P2PConnection) writeUDP(t *transport, datagrams [][]byte) bool {
+	p.udpConnMutex.RLock()
+	bond := p.bond
+	p.udpConnMutex.RUnlock()
+
+	if bond == nil {
+		return true
+	}
+
+	for _, datagram := range datagrams {
+		// Send UDP packet on the path(s) chosen by the bond
+		if err := bond.Send(datagram); err != nil {
+			if t.ctx.Err() == nil {
+				p2pLogger.Warn("failed to send UDP frame, connection lost", "error", err)
+			}
+			p.transportFailed(t, fmt.Errorf("send failed: %w", err))
+			return false
 		}
+		atomic.AddUint64(&p.txPackets, 1)
 	}
+	return true
 }
 
 // recvLoopUDP receives frames from UDP
-func (p *P2PConnection) recvLoopUDP() {
-	defer p.wg.Done()
+// The bond reads every path's socket, drops datagrams from unknown addresses
+// and restores send order; FEC is decoded here on the merged stream.
+func (p *P2PConnection) recvLoopUDP(t *transport) {
+	defer t.wg.Done()
 
-	buffer := make([]byte, 65535) // Maximum UDP packet size
+	p.udpConnMutex.RLock()
+	bond := p.bond
+	p.udpConnMutex.RUnlock()
+
+	if bond == nil {
+		return
+	}
 
 	for {
+		var data []byte
 		select {
-		case <-p.ctx.Done():
+		case <-t.ctx.Done():
 			return
-		default:
-			p.udpConnMutex.RLock()
-			conn := p.udpConn
-			peerAddr := p.udpPeerAddr
-			p.udpConnMutex.RUnlock()
-
-			if conn == nil {
-				return
-			}
-
-			// Set read deadline to allow checking ctx.Done()
-			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		case <-bond.Failed():
+			p2pLogger.Warn("every UDP path failed, connection lost")
+			p.transportFailed(t, fmt.Errorf("every UDP path failed"))
+			return
+		case data = <-bond.RecvChannel():
+		}
+		atomic.AddUint64(&p.rxPackets, 1)
 
-			// Read UDP packet
-			n, addr, err := conn.ReadFromUDP(buffer)
+		// FEC datagrams yield their own payload and any payloads recovered from parity
+		frames := [][]byte{data}
+		if fec.IsShard(data) {
+			var err error
+			frames, err = p.fecDecoder.Decode(data, time.Now())
 			if err != nil {
-				// Check if it's a timeout (expected for ctx.Done() checking)
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					continue
-				}
-				log.Printf("⚠️  UDP read error: %v", err)
-				p.setConnected(false)
-				return
+				fecErrorLog.Warn(fecLogger, "FEC decoding failed", "error", err)
 			}
+		}
 
-			// Verify packet is from expected peer
-			if peerAddr != nil && !addr.IP.Equal(peerAddr.IP) {
-				log.Printf("⚠️  Received UDP packet from unexpected address: %v (expected %v)", addr, peerAddr)
-				continue
-			}
-
-			// Make a copy of the data
-			data := make([]byte, n)
-			copy(data, buffer[:n])
-
+		for _, frame := range frames {
 			// Send to receive channel
 			select {
-			case p.recvChan <- data:
-			case <-p.ctx.Done():
+			case p.recvChan <- frame:
+			case <-t.ctx.Done():
 				return
 			default:
-				log.Printf("⚠️  Receive buffer full, dropping UDP frame")
+				atomic.AddUint64(&p.rxDropped, 1)
+				recvFullLog.Warn(p2pLogger, "receive buffer full, dropping frame", "transport", "udp")
 			}
 		}
 	}
 }
 
+// SetMultipathConfig sets the bond configuration used by the next ConnectUDP
+func (p *P2PConnection) SetMultipathConfig(config multipath.Config) {
+	p.multipathConfig = config
+}
+
+// Multipath returns the bond carrying the UDP transport, or nil on other transports
+func (p *P2PConnection) Multipath() *multipath.Bond {
+	p.udpConnMutex.RLock()
+	defer p.udpConnMutex.RUnlock()
+	return p.bond
+}
+
+// UDPPeerAddr returns the peer's address on the direct UDP transport, or nil
+func (p *P2PConnection) UDPPeerAddr() *net.UDPAddr {
+	p.udpConnMutex.RLock()
+	defer p.udpConnMutex.RUnlock()
+	return p.udpPeerAddr
+}
+
+// AddInterfacePath opens a socket on a local interface and adds it as a path to the peer
+// The path carries traffic once the peer answers its probes.
+func (p *P2PConnection) AddInterfacePath(name string) error {
+	p.udpConnMutex.RLock()
+	bond := p.bond
+	peerAddr := p.udpPeerAddr
+	p.udpConnMutex.RUnlock()
+
+	if bond == nil || peerAddr == nil {
+		return fmt.Errorf("multipath requires a direct UDP connection")
+	}
+
+	conn, err := multipath.ListenInterface(name)
+	if err != nil {
+		return err
+	}
+
+	if err := bond.AddPath(name, conn, peerAddr, false); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to add path on %s: %w", name, err)
+	}
+
+	multipathLogger.Info("path added", "interface", name, "local", conn.LocalAddr(), "peer", peerAddr)
+	return nil
+}
+
+// SetFECEncoder enables forward error correction for outgoing UDP datagrams (nil disables)
+// Incoming FEC datagrams are always decoded.
+func (p *P2PConnection) SetFECEncoder(encoder *fec.Encoder) {
+	p.fecEncoder.Store(encoder)
+}
+
+// FECEncoder returns the active FEC encoder, or nil if FEC is off
+func (p *P2PConnection) FECEncoder() *fec.Encoder {
+	return p.fecEncoder.Load()
+}
+
+// FECStats returns datagrams recovered from parity and groups that could not be recovered
+func (p *P2PConnection) FECStats() (recovered, unrecoverable uint64) {
+	return p.fecDecoder.Recovered(), p.fecDecoder.Unrecoverable()
+}
+
+// PacketCounts returns the number of datagrams sent and received on the transport
+func (p *P2PConnection) PacketCounts() (tx, rx uint64) {
+	return atomic.LoadUint64(&p.txPackets), atomic.LoadUint64(&p.rxPackets)
+}
+
+// Dropped returns frames discarded before decryption because a receive queue was full
+func (p *P2PConnection) Dropped() uint64 {
+	dropped := atomic.LoadUint64(&p.rxDropped)
+	if bond := p.Multipath(); bond != nil {
+		dropped += bond.Dropped()
+	}
+	return dropped
+}
+
+// Transport returns the active transport mode
+func (p *P2PConnection) Transport() TransportMode {
+	return p.transportMode
+}
+
+// TransportName describes the transport for the status API: "udp", "websocket" or "relay"
+func (p *P2PConnection) TransportName() string {
+	if p.relayMode {
+		return "relay"
+	}
+	return p.transportMode.String()
+}
+
+// PeerAddr returns the remote address of the transport (the relay URL in relay mode)
+func (p *P2PConnection) PeerAddr() string {
+	return p.peerAddr
+}
+
 // handleWebSocket handles incoming WebSocket connections
 func (p *P2PConnection) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
@@ -383,11 +645,16 @@ func (p *P2PConnection) handleWebSocket(w http.ResponseWriter, r *http.Request)
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("⚠️  WebSocket upgrade failed: %v", err)
+		p2pLogger.Warn("WebSocket upgrade failed", "remote", r.RemoteAddr, "error", err)
 		return
 	}
 
-	log.Printf("✅ Incoming WebSocket connection from %s", r.RemoteAddr)
+	p2pLogger.Info("incoming WebSocket connection", "remote", r.RemoteAddr)
+
+	// The new connection replaces any current one
+	p.closeTransport()
+	p.transportMode = TransportWebSocket
+	p.relayMode = false
 
 	p.connMutex.Lock()
 	p.conn = conn
@@ -397,9 +664,9 @@ func (p *P2PConnection) handleWebSocket(w http.ResponseWriter, r *http.Request)
 	p.setConnected(true)
 
 	// Start send/receive goroutines
-	p.wg.Add(2)
-	go p.sendLoop()
-	go p.recvLoop()
+	t := p.startTransport()
+	go p.sendLoop(t)
+	go p.recvLoop(t)
 
 	// Trigger callback to notify DaemonManager to start frame router
 	if p.onConnectionAccepted != nil {
@@ -426,6 +693,13 @@ func (p *P2PConnection) SetOnConnectionAccepted(callback func()) {
 	p.onConnectionAccepted = callback
 }
 
+// SetOnTransportLost sets the callback for when the transport fails, e.g. the relay closes the WebSocket
+// It is called at most once per transport, from the failing loop; closing the
+// transport (Close, CloseTransport or a new connection) does not call it.
+func (p *P2PConnection) SetOnTransportLost(callback func(err error)) {
+	p.onTransportLost = callback
+}
+
 // EnableRelayMode configures the connection to use a relay server
 func (p *P2PConnection) EnableRelayMode(relayServer, peerID string) {
 	p.relayMode = true
@@ -433,24 +707,95 @@ func (p *P2PConnection) EnableRelayMode(relayServer, peerID string) {
 	p.peerID = peerID
 }
 
+// JoinNetworks sets the virtual networks ConnectViaRelay joins
+// The relay then only passes this peer the frames of those networks. Without
+// networks the peer is in the default network shared by all peers without one.
+func (p *P2PConnection) JoinNetworks(networks []string) {
+	p.networks = networks
+}
+
+// relayAssignmentTimeout bounds waiting for the relay's address assignment after connecting
+const relayAssignmentTimeout = 10 * time.Second
+
+// RequireAssignment makes ConnectViaRelay wait for the relay to assign tunnel addresses
+// The connection fails if the relay does not send them within relayAssignmentTimeout.
+func (p *P2PConnection) RequireAssignment(require bool) {
+	p.awaitAssignment = require
+}
+
+// ClaimLease sets the identity key ConnectViaRelay signs our lease claim with
+// A relay with an address pool keeps our lease under the key's public key, so only the
+// holder of the key gets our addresses. A valid address is our configured one, which
+// the relay then reserves instead of assigning another. Without a key (nil) we claim
+// no lease.
+func (p *P2PConnection) ClaimLease(key ed25519.PrivateKey, address netip.Addr) {
+	p.leaseKey = key
+	p.staticAddress = address
+}
+
+// Assignment returns the tunnel addresses the relay last assigned, or nil
+func (p *P2PConnection) Assignment() *ipam.Assignment {
+	return p.assignment.Load()
+}
+
+// handleRelayMessage records a text message from the relay; false if it is not one the daemon knows
+// Relays with an address pool send an assignment on every connection, whether or
+// not the daemon asked for one, so it is accepted here too.
+func (p *P2PConnection) handleRelayMessage(data []byte) bool {
+	var assignment ipam.Assignment
+	if err := json.Unmarshal(data, &assignment); err != nil || assignment.Type != ipam.MessageEstablished || !assignment.Address.IsValid() {
+		return false
+	}
+	p.assignment.Store(&assignment)
+	p2pLogger.Debug("relay assigned tunnel addresses", "address", assignment.Address, "address6", assignment.Address6)
+	return true
+}
+
+// awaitRelayAssignment reads the relay's first message, which must be an address assignment
+func (p *P2PConnection) awaitRelayAssignment(conn *websocket.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(relayAssignmentTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	msgType, data, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("no address assignment from relay (is it started with -pool?): %w", err)
+	}
+	if msgType != websocket.TextMessage || !p.handleRelayMessage(data) {
+		return fmt.Errorf("relay did not assign a tunnel address (is it started with -pool?)")
+	}
+	return nil
+}
+
 // ConnectViaRelay establishes WebSocket connection to relay server
 func (p *P2PConnection) ConnectViaRelay() error {
 	if !p.relayMode {
 		return fmt.Errorf("relay mode not enabled")
 	}
 
+	p.closeTransport()
 	p.transportMode = TransportWebSocket
 
-	// Build relay URL with /relay path and peer ID
-	relayURL := fmt.Sprintf("%s/relay?peer_id=%s", p.relayServer, p.peerID)
-	log.Printf("Connecting to relay server: %s", relayURL)
+	// Build relay URL with /relay path, peer ID and the networks to join
+	query := url.Values{"peer_id": {p.peerID}}
+	if len(p.networks) > 0 {
+		query["network"] = p.networks
+	}
+	if p.staticAddress.IsValid() {
+		query.Set("address", p.staticAddress.String())
+	}
+	relayURL := fmt.Sprintf("%s/relay?%s", p.relayServer, query.Encode())
+	p2pLogger.Debug("dialling relay", "url", relayURL)
 
 	dialer := &websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
 	}
 
-	// Establish WebSocket connection to relay
-	conn, resp, err := dialer.Dial(relayURL, nil)
+	// Establish WebSocket connection to relay, presenting a fresh lease claim out of the logged URL
+	header := http.Header{}
+	if p.leaseKey != nil {
+		header.Set(ipam.ClaimHeader, ipam.NewClaim(p.leaseKey, time.Now()).String())
+	}
+	conn, resp, err := dialer.Dial(relayURL, header)
 	if err != nil {
 		if resp != nil {
 			return fmt.Errorf("relay connection failed (status %d): %w", resp.StatusCode, err)
@@ -459,6 +804,14 @@ func (p *P2PConnection) ConnectViaRelay() error {
 	}
 	defer resp.Body.Close()
 
+	p.assignment.Store(nil)
+	if p.awaitAssignment {
+		if err := p.awaitRelayAssignment(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
 	p.connMutex.Lock()
 	p.conn = conn
 	p.peerAddr = relayURL
@@ -466,12 +819,12 @@ func (p *P2PConnection) ConnectViaRelay() error {
 
 	p.setConnected(true)
 
-	log.Printf("✅ Connected to relay server as peer %s", p.peerID)
+	p2pLogger.Debug("relay connection established", "peer_id", p.peerID)
 
 	// Start send/receive goroutines
-	p.wg.Add(2)
-	go p.sendLoop()
-	go p.recvLoop()
+	t := p.startTransport()
+	go p.sendLoop(t)
+	go p.recvLoop(t)
 
 	return nil
 }
@@ -0,0 +1,62 @@
+package daemonmgr
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// peerCredentials reads SO_PEERCRED from a Unix socket connection
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCred{}, fmt.Errorf("not a Unix socket connection")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if sockErr != nil {
+		return peerCred{}, fmt.Errorf("SO_PEERCRED: %w", sockErr)
+	}
+
+	return peerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
+
+// peerInGroup checks the peer process's supplementary groups
+// SO_PEERCRED only carries the primary GID, so the rest come from /proc.
+func peerInGroup(cred peerCred, gid int) bool {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", cred.PID))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		groups, ok := strings.CutPrefix(scanner.Text(), "Groups:")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(groups) {
+			if g, err := strconv.Atoi(field); err == nil && g == gid {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package daemonmgr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestPeerCredentials tests reading SO_PEERCRED from a Unix socket connection
+func TestPeerCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cred.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cred, err := peerCredentials(server)
+	if err != nil {
+		t.Fatalf("peerCredentials() failed: %v", err)
+	}
+	if int(cred.PID) != os.Getpid() || int(cred.UID) != os.Geteuid() || int(cred.GID) != os.Getegid() {
+		t.Errorf("peerCredentials() = %+v, want pid %d uid %d gid %d", cred, os.Getpid(), os.Geteuid(), os.Getegid())
+	}
+
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	if _, err := peerCredentials(pipe); err == nil {
+		t.Error("peerCredentials() accepted a connection that is not a Unix socket")
+	}
+}
+
+// TestPeerInGroup tests matching a process's supplementary groups
+func TestPeerInGroup(t *testing.T) {
+	pid, group := os.Getpid(), -1
+	if groups, err := os.Getgroups(); err == nil && len(groups) > 0 {
+		group = groups[0]
+	} else if os.Geteuid() == 0 {
+		// Give a child process a supplementary group instead
+		group = 4242
+		cmd := exec.Command("sleep", "30")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Groups: []uint32{uint32(group)}}}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("cannot start process with supplementary groups: %v", err)
+		}
+		defer func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}()
+		pid = cmd.Process.Pid
+	} else {
+		t.Skip("process has no supplementary groups")
+	}
+	peer := peerCred{PID: int32(pid)}
+
+	if !peerInGroup(peer, group) {
+		t.Errorf("peerInGroup() = false for supplementary group %d", group)
+	}
+	if peerInGroup(peer, 1<<30) {
+		t.Error("peerInGroup() = true for a group the process is not in")
+	}
+	if peerInGroup(peerCred{PID: -1}, group) {
+		t.Error("peerInGroup() = true for a process that does not exist")
+	}
+}
+
+// TestSocketPeerCredentials tests that SO_PEERCRED is read on the real socket and lets the daemon's own user in
+func TestSocketPeerCredentials(t *testing.T) {
+	api := newTestAPI(t, APIConfig{})
+	if err := api.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer api.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", api.config.SocketPath)
+		},
+	}}
+	resp, err := client.Get("http://daemon/health")
+	if err != nil {
+		t.Fatalf("GET /health failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	info, err := os.Stat(api.config.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("socket mode = %v, want 0660", info.Mode().Perm())
+	}
+}
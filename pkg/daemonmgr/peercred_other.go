@@ -0,0 +1,15 @@
+//go:build !linux
+
+package daemonmgr
+
+import "net"
+
+// peerCredentials is not implemented off Linux; the socket's file mode and group apply
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	return peerCred{}, errPeerCredUnsupported
+}
+
+// peerInGroup is never reached without peer credentials
+func peerInGroup(cred peerCred, gid int) bool {
+	return false
+}
@@ -0,0 +1,198 @@
+package daemonmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+	"github.com/shadowmesh/shadowmesh/pkg/pmtu"
+)
+
+// pmtuTickInterval is how often the prober is asked for the next probe
+const pmtuTickInterval = 250 * time.Millisecond
+
+// deviceMode returns the configured device mode ("tap" or "tun"), by default tun on macOS and tap elsewhere
+func (dm *DaemonManager) deviceMode() string {
+	if dm.cfg().Network.Mode == "" {
+		if runtime.GOOS == "darwin" {
+			return layer2.ModeTUN // macOS has no native TAP driver
+		}
+		return layer2.ModeTAP
+	}
+	return dm.cfg().Network.Mode
+}
+
+// maxTunnelFrameSize returns the largest tunnel datagram the daemon will send
+func (dm *DaemonManager) maxTunnelFrameSize() int {
+	if dm.cfg().PathMTU.MaxSize > 0 {
+		return dm.cfg().PathMTU.MaxSize
+	}
+	return pmtu.DefaultMaxSize
+}
+
+// initialTunnelFrameSize returns the datagram size used before discovery has run
+func (dm *DaemonManager) initialTunnelFrameSize() int {
+	if dm.cfg().PathMTU.Discovery {
+		return pmtu.DefaultBaseSize
+	}
+	return dm.maxTunnelFrameSize()
+}
+
+// deviceMTUFor returns the device MTU whose packets fit in a tunnel datagram of frameSize bytes
+// Accounts for the encrypted frame overhead, the network ID frames carry with networks
+// and, in TAP mode, the Ethernet header.
+func (dm *DaemonManager) deviceMTUFor(frameSize int) int {
+	mtu := frameSize - frameencryption.FrameOverhead
+	if dm.deviceMode() == layer2.ModeTAP {
+		mtu -= layer2.EthernetHeaderSize
+	}
+	if len(dm.cfg().Networks) > 0 {
+		mtu -= frameencryption.NetworkIDSize
+	}
+	return mtu
+}
+
+// applyPathMTU sizes fragmentation, the device MTU and MSS clamping for a tunnel datagram size
+func (dm *DaemonManager) applyPathMTU(frameSize int) {
+	dm.encryptionPipeline.SetMaxFrameSize(frameSize)
+
+	mtu := dm.deviceMTUFor(frameSize)
+
+	if dm.cfg().Network.MTU > 0 {
+		// Device MTU is pinned by configuration; clamp to whichever is smaller
+		if dm.cfg().Network.MTU < mtu {
+			mtu = dm.cfg().Network.MTU
+		}
+	} else if dm.tapDevice != nil && dm.tapDevice.MTU() != mtu {
+		if err := dm.tapDevice.SetMTU(mtu); err != nil {
+			pmtuLogger.Warn("failed to set device MTU", "mtu", mtu, "error", err)
+		} else {
+			pmtuLogger.Info("device MTU set", "device", dm.tapDevice.Name(), "mtu", mtu, "datagram_size", frameSize)
+		}
+	}
+	if dm.cfg().Network.MTU == 0 && dm.networks != nil {
+		for _, network := range dm.networks.list {
+			if network.device == nil || network.device.MTU() == mtu {
+				continue
+			}
+			if err := network.device.SetMTU(mtu); err != nil {
+				pmtuLogger.Warn("failed to set device MTU", "device", network.device.Name(), "mtu", mtu, "error", err)
+			}
+		}
+	}
+
+	dm.tunnelMTU.Store(int64(mtu))
+}
+
+// currentTunnelMTU returns the MTU used for TCP MSS clamping
+func (dm *DaemonManager) currentTunnelMTU() int {
+	return int(dm.tunnelMTU.Load())
+}
+
+// frameRouterPMTU runs path MTU discovery for the current connection
+func (dm *DaemonManager) frameRouterPMTU(ctx context.Context) {
+	if !dm.cfg().PathMTU.Discovery {
+		return
+	}
+
+	prober := pmtu.NewProber(pmtu.Config{MaxSize: dm.maxTunnelFrameSize()})
+	dm.proberMu.Lock()
+	dm.prober = prober
+	dm.proberMu.Unlock()
+
+	defer func() {
+		dm.proberMu.Lock()
+		if dm.prober == prober {
+			dm.prober = nil
+		}
+		dm.proberMu.Unlock()
+	}()
+
+	// Start every connection from the base size; the new path may be narrower
+	applied := prober.PLPMTU()
+	dm.applyPathMTU(applied)
+
+	ticker := time.NewTicker(pmtuTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if probe, ok := prober.Next(now); ok {
+				dm.sendPMTUProbe(probe)
+			}
+
+			if size := prober.PLPMTU(); size != applied {
+				pmtuLogger.Info("path MTU changed", "from", applied, "to", size, "state", prober.State())
+				applied = size
+				dm.applyPathMTU(size)
+			}
+		}
+	}
+}
+
+// sendPMTUProbe sends a control message padded so the encrypted frame is exactly probe.Size bytes
+func (dm *DaemonManager) sendPMTUProbe(probe pmtu.Probe) {
+	payload, err := json.Marshal(&ControlMessage{Type: ControlPMTUProbe, ProbeID: probe.ID})
+	if err != nil {
+		return
+	}
+
+	// Trailing whitespace is valid JSON, so the receiver decodes the probe unchanged
+	padding := probe.Size - frameencryption.FrameOverhead - len(payload)
+	if padding < 0 {
+		return
+	}
+	payload = append(payload, bytes.Repeat([]byte{' '}, padding)...)
+
+	if err := dm.queueControl(ControlPMTUProbe, payload); err != nil {
+		pmtuLogger.Warn("failed to send path MTU probe", "error", err)
+	}
+}
+
+// handlePMTUAck passes a probe acknowledgement to the active prober
+func (dm *DaemonManager) handlePMTUAck(probeID uint32) {
+	dm.proberMu.RLock()
+	prober := dm.prober
+	dm.proberMu.RUnlock()
+
+	if prober != nil {
+		prober.Ack(probeID, time.Now())
+	}
+}
+
+// MTUStatus reports the tunnel MTU and path MTU discovery state
+type MTUStatus struct {
+	DeviceMTU      int    `json:"device_mtu"`                // Largest IP packet the tunnel device accepts
+	MaxFrameSize   int    `json:"max_frame_size,omitempty"`  // Fragmentation threshold of the pipeline
+	PathMTU        int    `json:"path_mtu,omitempty"`        // Largest datagram confirmed to reach the peer
+	DiscoveryState string `json:"discovery_state,omitempty"` // Path MTU prober state
+}
+
+// pathMTUStatus returns path MTU details for the status API
+func (dm *DaemonManager) pathMTUStatus() MTUStatus {
+	status := MTUStatus{
+		DeviceMTU: dm.currentTunnelMTU(),
+	}
+
+	if dm.encryptionPipeline != nil {
+		status.MaxFrameSize = dm.encryptionPipeline.MaxFrameSize()
+	}
+
+	dm.proberMu.RLock()
+	prober := dm.prober
+	dm.proberMu.RUnlock()
+
+	if prober != nil {
+		status.PathMTU = prober.PLPMTU()
+		status.DiscoveryState = prober.State().String()
+	}
+
+	return status
+}
@@ -0,0 +1,371 @@
+package daemonmgr
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// defaultInitialBackoff is the delay before the first retry of a lost connection
+	defaultInitialBackoff = time.Second
+
+	// defaultMaxBackoff caps the delay between retries
+	defaultMaxBackoff = time.Minute
+)
+
+// dialTarget is what a connection is made to, kept so that it can be made again
+type dialTarget struct {
+	peerAddr    string // Peer (or relay) address; empty when connecting through relayServer only
+	relayServer string // Relay URL, if the connection goes through a relay
+	peerID      string // Our ID on the relay
+}
+
+// address returns the target for status, logs and events
+func (t dialTarget) address() string {
+	if t.peerAddr != "" {
+		return t.peerAddr
+	}
+	return t.relayServer
+}
+
+// connectSession is a connection the daemon keeps up, from Connect (or an accepted
+// connection) until Disconnect
+// The session outlives its transport: if the transport fails, the supervisor redials
+// the target and the frame router, encryption keys and negotiated peer state carry on.
+type connectSession struct {
+	target   dialTarget // Only read and written while dialling (connectMu)
+	address  string     // target.address(), fixed for the session
+	accepted bool       // Made by the peer; there is nothing to redial if it fails
+
+	ctx    context.Context // Cancelled by Disconnect, or when another session replaces this one
+	cancel context.CancelFunc
+	lost   chan error // Transport failures reported by P2PConnection
+}
+
+// newSession creates a session for target; it ends with the daemon at the latest
+func (dm *DaemonManager) newSession(target dialTarget, accepted bool) *connectSession {
+	ctx, cancel := context.WithCancel(dm.ctx)
+	return &connectSession{
+		target:   target,
+		address:  target.address(),
+		accepted: accepted,
+		ctx:      ctx,
+		cancel:   cancel,
+		lost:     make(chan error, 1),
+	}
+}
+
+// hasSession reports whether a connection is up or being retried
+func (dm *DaemonManager) hasSession() bool {
+	dm.stateMu.RLock()
+	defer dm.stateMu.RUnlock()
+	return dm.session != nil || dm.state == StateConnected
+}
+
+// newP2PConnection creates the P2P connection, reporting transport failures to the current session
+func (dm *DaemonManager) newP2PConnection() *P2PConnection {
+	p := NewP2PConnection()
+	p.SetOnTransportLost(dm.transportLost)
+	return p
+}
+
+// transportLost passes a transport failure to the supervisor of the current session
+// Called from the failing transport loop, which the supervisor waits for, so it must not block.
+func (dm *DaemonManager) transportLost(err error) {
+	dm.stateMu.RLock()
+	session := dm.session
+	dm.stateMu.RUnlock()
+
+	if session == nil {
+		return
+	}
+	select {
+	case session.lost <- err:
+	default: // A failure is already pending
+	}
+}
+
+// autoConnect connects to target in the background, so startup and reloads don't block
+// Failed attempts are retried with backoff, like a lost connection.
+func (dm *DaemonManager) autoConnect(target string) {
+	dm.stateMu.Lock()
+	if dm.state == StateConnected {
+		dm.stateMu.Unlock()
+		logger.Info("already connected, not auto-connecting", "target", target)
+		return
+	}
+	session := dm.newSession(dm.dialTargetFor(target), false)
+	previous := dm.session
+	dm.session = session
+	dm.stateMu.Unlock()
+
+	if previous != nil {
+		previous.cancel()
+	}
+
+	logger.Info("auto-connecting", "target", target)
+
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		if dm.redial(session, nil) {
+			dm.supervise(session)
+		}
+	}()
+}
+
+// acceptSession makes an accepted connection the current session
+// An incoming connection that replaces the transport of our own connection leaves
+// that session in charge; otherwise it replaces the session, including one that is
+// still being retried, since the peer has come to us.
+func (dm *DaemonManager) acceptSession() {
+	dm.stateMu.Lock()
+	previous := dm.session
+	if previous != nil && !previous.accepted && dm.state == StateConnected {
+		dm.stateMu.Unlock()
+		dm.startFrameRouter()
+		return
+	}
+	session := dm.newSession(dialTarget{}, true)
+	dm.session = session
+	dm.connectedTo = ""
+	dm.stateMu.Unlock()
+
+	if previous != nil {
+		previous.cancel()
+	}
+
+	dm.startFrameRouter()
+	dm.setState(StateConnected, nil)
+	dm.superviseSession(session)
+}
+
+// superviseSession watches a connected session in the background
+func (dm *DaemonManager) superviseSession(session *connectSession) {
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.supervise(session)
+	}()
+}
+
+// supervise redials the session's target whenever its transport fails, until the session ends
+// Accepted sessions end when their transport fails; the listener waits for the peer instead.
+func (dm *DaemonManager) supervise(session *connectSession) {
+	for {
+		var cause error
+		select {
+		case <-session.ctx.Done():
+			return
+		case cause = <-session.lost:
+		}
+
+		if session.accepted {
+			p2pLogger.Warn("accepted connection lost, waiting for the peer to reconnect", "error", cause)
+			dm.endSession(session, StateDisconnected, cause)
+			return
+		}
+
+		logger.Warn("connection lost", "target", session.address, "error", cause)
+		if dm.p2pConnection.Transport() == TransportUDP {
+			// The hole-punched socket was closed with the connection
+			dm.renewHolePuncher()
+		}
+		if !dm.redial(session, cause) {
+			return
+		}
+	}
+}
+
+// redial dials the session's target until it connects, waiting a jittered exponential backoff between attempts
+// cause is why the connection was lost; a first connection (nil cause) is tried
+// straight away. Each retry is published as EventReconnecting and success as
+// EventReconnected. Returns false if the session was cancelled or gave up.
+func (dm *DaemonManager) redial(session *connectSession, cause error) bool {
+	address := session.address
+	since := time.Now()
+
+	lastErr := cause
+	if cause == nil {
+		dm.setState(StateConnecting, nil)
+		err := dm.dialSession(session)
+		if err == nil {
+			logger.Info("connected", "target", address)
+			return true
+		}
+		if session.ctx.Err() != nil {
+			return false
+		}
+		logger.Warn("connection failed, retrying", "target", address, "error", err)
+		lastErr = err
+	}
+	dm.setState(StateReconnecting, lastErr)
+
+	for retry := 1; ; retry++ {
+		config := dm.cfg() // Reconnect settings apply from the next retry after a reload
+		if limit := config.Reconnect.MaxAttempts; limit > 0 && retry > limit {
+			logger.Error("giving up reconnecting", "target", address, "attempts", limit, "error", lastErr)
+			dm.endSession(session, StateError, fmt.Errorf("gave up reconnecting after %d attempts: %w", limit, lastErr))
+			return false
+		}
+
+		initial, longest := config.reconnectBackoff()
+		delay := backoffDelay(retry, initial, longest)
+		logger.Info("reconnecting", "target", address, "attempt", retry, "delay", delay)
+		dm.events.publish(Event{
+			Type: EventReconnecting,
+			Reconnect: &ReconnectEvent{
+				Target:      address,
+				Attempt:     retry,
+				DelayMillis: float64(delay.Microseconds()) / 1000,
+				Error:       lastErr.Error(),
+			},
+		})
+
+		select {
+		case <-session.ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		err := dm.dialSession(session)
+		if err == nil {
+			downtime := time.Since(since)
+			logger.Info("reconnected", "target", address, "attempts", retry, "downtime", downtime)
+			dm.events.publish(Event{
+				Type: EventReconnected,
+				Reconnect: &ReconnectEvent{
+					Target:         address,
+					Attempt:        retry,
+					DowntimeMillis: float64(downtime.Microseconds()) / 1000,
+				},
+			})
+			return true
+		}
+		if session.ctx.Err() != nil {
+			return false
+		}
+
+		logger.Warn("reconnect attempt failed", "target", address, "attempt", retry, "error", err)
+		lastErr = err
+		dm.stateMu.Lock()
+		dm.lastError = err
+		dm.stateMu.Unlock()
+	}
+}
+
+// dialSession makes one connection attempt for the session and brings the tunnel up on success
+func (dm *DaemonManager) dialSession(session *connectSession) error {
+	dm.connectMu.Lock()
+	defer dm.connectMu.Unlock()
+
+	if err := session.ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	target := session.target
+	err := dm.dial(&target)
+	dm.metrics.handshakeDone(start, err)
+	if err != nil {
+		return err
+	}
+
+	// Disconnected while dialling
+	if err := session.ctx.Err(); err != nil {
+		dm.p2pConnection.CloseTransport()
+		return err
+	}
+
+	session.target = target
+	dm.established(session)
+	return nil
+}
+
+// established makes a dialled session the current one and brings the tunnel up
+// On a redial the frame router is still running from the lost connection, and the
+// session resumes on the new transport. The caller holds connectMu.
+func (dm *DaemonManager) established(session *connectSession) *connectSession {
+	dm.stateMu.Lock()
+	dm.session = session
+	dm.connectedTo = session.address
+	dm.stateMu.Unlock()
+
+	dm.frameRouterMu.Lock()
+	resumed := dm.frameRouterRunning
+	dm.frameRouterMu.Unlock()
+
+	dm.startFrameRouter()
+	if resumed {
+		dm.resumeSession()
+	}
+
+	dm.setState(StateConnected, nil)
+	return session
+}
+
+// resumeSession carries the tunnel over to a redialled transport
+// Keys and key sequence are kept. The hello makes peers renegotiate in case they
+// restarted meanwhile, and multipath opens its extra paths again on a new UDP transport.
+func (dm *DaemonManager) resumeSession() {
+	dm.multipathMu.Lock()
+	dm.multipathStarted = false
+	dm.multipathMu.Unlock()
+	dm.updateMultipath()
+
+	if err := dm.sendHello(false); err != nil {
+		controlLogger.Warn("failed to send hello", "error", err)
+	}
+}
+
+// endSession takes the session's connection down and sets state, unless the session was replaced meanwhile
+func (dm *DaemonManager) endSession(session *connectSession, state ConnectionState, err error) {
+	dm.stateMu.Lock()
+	if dm.session != session {
+		dm.stateMu.Unlock()
+		return
+	}
+	dm.session = nil
+	dm.stateMu.Unlock()
+
+	session.cancel()
+
+	dm.connectMu.Lock()
+	dm.teardown()
+	dm.connectMu.Unlock()
+
+	dm.setState(state, err)
+}
+
+// reconnectBackoff returns the first and the longest delay between retries, defaults applied
+func (c *DaemonConfig) reconnectBackoff() (initial, longest time.Duration) {
+	initial, longest = c.Reconnect.InitialBackoff, c.Reconnect.MaxBackoff
+	if initial == 0 {
+		initial = defaultInitialBackoff
+	}
+	if longest == 0 {
+		longest = defaultMaxBackoff
+	}
+	if longest < initial {
+		longest = initial
+	}
+	return initial, longest
+}
+
+// backoffDelay returns the wait before a retry (from 1): initial, doubling per retry up to longest
+// Half of the delay is random jitter, so that daemons cut off by the same outage
+// (e.g. a relay restart) do not all redial at the same moment.
+func backoffDelay(retry int, initial, longest time.Duration) time.Duration {
+	delay := initial
+	for i := 1; i < retry && delay < longest; i++ {
+		delay *= 2
+	}
+	if delay > longest {
+		delay = longest
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
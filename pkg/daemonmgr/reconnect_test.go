@@ -0,0 +1,65 @@
+package daemonmgr
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoffDelay tests that retry delays double up to the limit and are jittered within their upper half
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name             string
+		retry            int
+		initial, longest time.Duration
+		want             time.Duration // Upper bound; the delay is at least half of it
+	}{
+		{"first retry", 1, time.Second, time.Minute, time.Second},
+		{"second retry", 2, time.Second, time.Minute, 2 * time.Second},
+		{"fifth retry", 5, time.Second, time.Minute, 16 * time.Second},
+		{"capped", 7, time.Second, time.Minute, time.Minute},
+		{"far past the cap", 1000, time.Second, time.Minute, time.Minute},
+		{"cap below a doubling", 3, 3 * time.Second, 10 * time.Second, 10 * time.Second},
+		{"initial equals longest", 4, 5 * time.Second, 5 * time.Second, 5 * time.Second},
+		{"milliseconds", 3, 20 * time.Millisecond, 100 * time.Millisecond, 80 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lowest, highest := tt.want, time.Duration(0)
+			for i := 0; i < 1000; i++ {
+				delay := backoffDelay(tt.retry, tt.initial, tt.longest)
+				if delay < tt.want/2 || delay > tt.want {
+					t.Fatalf("backoffDelay(%d, %v, %v) = %v, want between %v and %v", tt.retry, tt.initial, tt.longest, delay, tt.want/2, tt.want)
+				}
+				lowest, highest = min(lowest, delay), max(highest, delay)
+			}
+			// Jitter spreads the delays over most of the range
+			if spread := highest - lowest; spread < tt.want/4 {
+				t.Errorf("delays only spread over %v of %v: not jittered", spread, tt.want/2)
+			}
+		})
+	}
+}
+
+// TestReconnectBackoff tests the defaults and limits of the reconnect settings
+func TestReconnectBackoff(t *testing.T) {
+	tests := []struct {
+		name                     string
+		initial, longest         time.Duration
+		wantInitial, wantLongest time.Duration
+	}{
+		{"defaults", 0, 0, defaultInitialBackoff, defaultMaxBackoff},
+		{"configured", 2 * time.Second, 30 * time.Second, 2 * time.Second, 30 * time.Second},
+		{"initial above the default maximum", 2 * time.Minute, 0, 2 * time.Minute, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &DaemonConfig{}
+			config.Reconnect.InitialBackoff, config.Reconnect.MaxBackoff = tt.initial, tt.longest
+
+			initial, longest := config.reconnectBackoff()
+			if initial != tt.wantInitial || longest != tt.wantLongest {
+				t.Errorf("reconnectBackoff() = %v, %v, want %v, %v", initial, longest, tt.wantInitial, tt.wantLongest)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
+)
+
+// liveConfigFields are the settings Reload applies without restarting the daemon
+// Every other field keeps its running value until restart.
+var liveConfigFields = map[string]bool{
+	"daemon.log_level":             true,
+	"daemon.log_format":            true,
+	"daemon.log_levels":            true,
+	"network.routes":               true,
+	"network.advertise_subnets":    true,
+	"network.accept_subnets":       true,
+	"network.peer_subnets":         true,
+	"network.proxy_neighbors":      true,
+	"network.broadcast_limit":      true,
+	"acl.enabled":                  true,
+	"acl.default_inbound":          true,
+	"acl.default_outbound":         true,
+	"acl.groups":                   true,
+	"acl.rules":                    true,
+	"identity.peers":               true,
+	"peer.address":                 true,
+	"peer.id":                      true,
+	"relay.enabled":                true,
+	"relay.server":                 true,
+	"nat.enabled":                  true,
+	"nat.stun_server":              true,
+	"encryption.rotation_interval": true,
+	"reconnect.initial_backoff":    true,
+	"reconnect.max_backoff":        true,
+	"reconnect.max_attempts":       true,
+}
+
+// ReloadResult reports what a configuration reload changed, by YAML path (e.g. "nat.stun_server")
+type ReloadResult struct {
+	Applied         []string `json:"applied"`          // Changed fields now in effect
+	RestartRequired []string `json:"restart_required"` // Changed fields that keep their old value until restart
+}
+
+// String summarises the reload for logs and `shadowmesh watch`
+func (r *ReloadResult) String() string {
+	if len(r.Applied) == 0 && len(r.RestartRequired) == 0 {
+		return "configuration unchanged"
+	}
+
+	var parts []string
+	if len(r.Applied) > 0 {
+		parts = append(parts, "applied "+strings.Join(r.Applied, ", "))
+	}
+	if len(r.RestartRequired) > 0 {
+		parts = append(parts, "restart required for "+strings.Join(r.RestartRequired, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reload re-reads the configuration file and applies the settings that can change while running
+// The new file is validated first; if it is invalid nothing changes. Tunnels stay up unless
+// the peer or relay the daemon connected to on its own was changed.
+func (dm *DaemonManager) Reload() (*ReloadResult, error) {
+	dm.reloadMu.Lock()
+	defer dm.reloadMu.Unlock()
+
+	current := dm.cfg()
+	if current.path == "" {
+		return nil, fmt.Errorf("configuration was not loaded from a file")
+	}
+
+	next, err := LoadConfig(current.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Start from the running configuration and copy in only the live fields
+	merged := *current
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	mergeLiveFields(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(next).Elem(), "", result)
+
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		logger.Info("configuration reloaded, no changes", "path", current.path)
+		return result, nil
+	}
+
+	dm.config.Store(&merged)
+	dm.applyReload(current, &merged)
+
+	logger.Info("configuration reloaded", "path", current.path, "applied", result.Applied, "restart_required", result.RestartRequired)
+	dm.events.publish(Event{Type: EventConfigReloaded, Reload: result})
+
+	return result, nil
+}
+
+// mergeLiveFields compares next with merged field by field, copying changed live fields into merged
+func mergeLiveFields(merged, next reflect.Value, prefix string, result *ReloadResult) {
+	for i := 0; i < merged.NumField(); i++ {
+		field := merged.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			mergeLiveFields(merged.Field(i), next.Field(i), name, result)
+			continue
+		}
+		if reflect.DeepEqual(merged.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+
+		if liveConfigFields[name] {
+			merged.Field(i).Set(next.Field(i))
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+}
+
+// applyReload acts on the live fields that differ between the previous and new configuration
+func (dm *DaemonManager) applyReload(previous, next *DaemonConfig) {
+	if !reflect.DeepEqual(previous.LoggingOptions(), next.LoggingOptions()) {
+		if err := logging.Configure(next.LoggingOptions()); err != nil {
+			logger.Warn("failed to apply logging settings", "error", err)
+		}
+	}
+
+	if !slices.Equal(previous.Network.Routes, next.Network.Routes) {
+		dm.updateRoutes(previous.Network.Routes, next.Network.Routes)
+	}
+
+	advertiseChanged := !slices.Equal(previous.Network.AdvertiseSubnets, next.Network.AdvertiseSubnets)
+	identityChanged := !maps.Equal(previous.Identity.Peers, next.Identity.Peers)
+	if advertiseChanged || identityChanged || !slices.Equal(previous.Network.AcceptSubnets, next.Network.AcceptSubnets) ||
+		!slices.Equal(previous.Network.Routes, next.Network.Routes) ||
+		!reflect.DeepEqual(previous.Network.PeerSubnets, next.Network.PeerSubnets) {
+		dm.updatePeerSubnets()
+	}
+	if advertiseChanged {
+		warnForwarding(next.Network.AdvertiseSubnets)
+	}
+	if (advertiseChanged || identityChanged) && dm.GetState() == StateConnected {
+		// A hello that is not a reply makes peers answer, so both sides are current
+		// and newly listed peers exchange sender keys
+		if err := dm.sendHello(false); err != nil {
+			controlLogger.Warn("failed to send hello", "error", err)
+		}
+	}
+
+	if previous.Network.ProxyNeighbors != next.Network.ProxyNeighbors || previous.Network.BroadcastLimit != next.Network.BroadcastLimit {
+		dm.updateNeighbors(next)
+	}
+
+	if !reflect.DeepEqual(previous.ACL, next.ACL) {
+		dm.updateACL(next)
+	}
+
+	if previous.Encryption.RotationInterval != next.Encryption.RotationInterval {
+		select {
+		case dm.rotationChanged <- struct{}{}:
+		default: // The loop has not picked up the previous change yet
+		}
+	}
+
+	if previous.NAT != next.NAT {
+		if next.NAT.Enabled {
+			// Detection takes a few seconds; the old components stay in use until it completes
+			dm.wg.Add(1)
+			go func() {
+				defer dm.wg.Done()
+				if err := dm.initNATComponents(); err != nil {
+					natLogger.Warn("NAT re-initialization failed", "error", err)
+				}
+			}()
+		} else {
+			dm.setNATComponents(nil, nil)
+			natLogger.Info("NAT traversal disabled")
+		}
+	}
+
+	previousTarget, nextTarget := connectTarget(previous), connectTarget(next)
+	if previousTarget != nextTarget || previous.Peer.ID != next.Peer.ID {
+		dm.retarget(previousTarget, nextTarget)
+	}
+}
+
+// retarget moves the daemon's own connection from the previous peer or relay to the next one
+// Connections made through the API to another address, and accepted ones, are left alone.
+// A connection to the previous target is dropped even while it is being retried.
+func (dm *DaemonManager) retarget(previousTarget, nextTarget string) {
+	dm.stateMu.RLock()
+	state, session := dm.state, dm.session
+	dm.stateMu.RUnlock()
+
+	switch {
+	case session == nil && state == StateConnecting:
+		logger.Info("connection attempt in progress; the new peer settings apply to the next one")
+		return
+	case session != nil && session.address != previousTarget:
+		logger.Info("keeping the current connection; the new peer settings apply after disconnecting")
+		return
+	case session != nil:
+		logger.Info("peer settings changed, disconnecting", "target", previousTarget)
+		if err := dm.Disconnect(); err != nil {
+			logger.Warn("error disconnecting", "error", err)
+		}
+	}
+
+	if nextTarget != "" {
+		dm.autoConnect(nextTarget)
+	}
+}
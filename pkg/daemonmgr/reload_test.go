@@ -0,0 +1,169 @@
+package daemonmgr
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reloadBase is the configuration the reload tests start from
+var reloadBase = `
+network:
+  local_ip: 10.0.0.1/24
+  mtu: 1400
+reconnect:
+  max_backoff: 30s
+compression:
+  enabled: true
+` + keySection
+
+// loadTestDaemon writes config to a file and creates an unstarted daemon from it
+func loadTestDaemon(t *testing.T, config string) (*DaemonManager, string) {
+	t.Helper()
+
+	path := writeConfig(t, config)
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	dm, err := NewDaemonManager(loaded)
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+	return dm, path
+}
+
+// TestReloadLiveFields tests that changed live fields take effect without a restart
+func TestReloadLiveFields(t *testing.T) {
+	dm, path := loadTestDaemon(t, reloadBase)
+	if dm.GetACL() != nil {
+		t.Fatal("ACL enabled before reload")
+	}
+
+	next := strings.Replace(reloadBase, "max_backoff: 30s", "max_backoff: 2m", 1) + `
+acl:
+  enabled: true
+  rules:
+    - action: allow
+      protocol: tcp
+      ports: ["22"]
+`
+	if err := os.WriteFile(path, []byte(next), 0600); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := dm.SubscribeEvents(0)
+	defer cancel()
+
+	result, err := dm.Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	for _, field := range []string{"reconnect.max_backoff", "acl.enabled", "acl.rules"} {
+		if !slices.Contains(result.Applied, field) {
+			t.Errorf("Applied = %v, missing %s", result.Applied, field)
+		}
+	}
+	if len(result.RestartRequired) != 0 {
+		t.Errorf("RestartRequired = %v, want none", result.RestartRequired)
+	}
+
+	if got := dm.cfg().Reconnect.MaxBackoff; got != 2*time.Minute {
+		t.Errorf("reconnect.max_backoff = %v after reload, want 2m", got)
+	}
+	if stats := dm.GetACL(); stats == nil || len(stats.Rules) != 1 {
+		t.Errorf("ACL after reload = %+v, want one rule", stats)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != EventConfigReloaded || event.Reload == nil || len(event.Reload.Applied) != len(result.Applied) {
+			t.Errorf("event = %+v, want config_reloaded with the result", event)
+		}
+	default:
+		t.Error("no config_reloaded event published")
+	}
+
+	// Reloading the same file changes nothing
+	result, err = dm.Reload()
+	if err != nil || len(result.Applied) != 0 || len(result.RestartRequired) != 0 {
+		t.Errorf("second Reload() = %+v, %v, want no changes", result, err)
+	}
+}
+
+// TestReloadRestartRequired tests that fields needing a restart are reported and keep their running value
+func TestReloadRestartRequired(t *testing.T) {
+	dm, path := loadTestDaemon(t, reloadBase)
+	running := dm.cfg()
+
+	next := strings.NewReplacer("10.0.0.1/24", "10.0.0.9/24", "mtu: 1400", "mtu: 1300", "enabled: true", "enabled: false").Replace(reloadBase)
+	if err := os.WriteFile(path, []byte(next), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dm.Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	want := []string{"network.local_ip", "network.mtu", "compression.enabled"}
+	if !slices.Equal(result.RestartRequired, want) {
+		t.Errorf("RestartRequired = %v, want %v", result.RestartRequired, want)
+	}
+	if len(result.Applied) != 0 {
+		t.Errorf("Applied = %v, want none", result.Applied)
+	}
+
+	config := dm.cfg()
+	if config.Network.LocalIP != running.Network.LocalIP || config.Network.MTU != running.Network.MTU || !config.Compression.Enabled {
+		t.Errorf("restart-only fields changed by reload: local_ip %s, mtu %d, compression %v",
+			config.Network.LocalIP, config.Network.MTU, config.Compression.Enabled)
+	}
+}
+
+// TestReloadInvalid tests that an invalid configuration is rejected and the running one stays in effect
+func TestReloadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"invalid value", strings.Replace(reloadBase, "max_backoff: 30s", "max_backoff: -1s", 1), "reconnect.max_backoff"},
+		{"unknown field", reloadBase + "acl:\n  enable: true\n", "enable"},
+		{"malformed yaml", reloadBase + "network: [\n", "yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, path := loadTestDaemon(t, reloadBase)
+			running := dm.cfg()
+
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			result, err := dm.Reload()
+			if err == nil {
+				t.Fatalf("Reload() = %+v, want an error", result)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Reload() error = %v, want it to mention %s", err, tt.want)
+			}
+			if dm.cfg() != running {
+				t.Error("running configuration replaced by an invalid one")
+			}
+		})
+	}
+}
+
+// TestReloadWithoutFile tests that a daemon not configured from a file refuses to reload
+func TestReloadWithoutFile(t *testing.T) {
+	dm, err := NewDaemonManager(&DaemonConfig{})
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+	if _, err := dm.Reload(); err == nil {
+		t.Error("Reload() succeeded without a configuration file")
+	}
+}
@@ -0,0 +1,482 @@
+package daemonmgr
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/shadowmesh/shadowmesh/pkg/exitnode"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// configureDevice brings the network device up with the configured addresses and routes
+// Addresses and routes left over from a previous run are replaced rather than failing;
+// the device removes what it added when it stops.
+func (dm *DaemonManager) configureDevice() error {
+	config := dm.cfg()
+
+	if err := dm.tapDevice.SetUp(true); err != nil {
+		return err
+	}
+
+	for _, address := range []string{config.Network.LocalIP, config.Network.LocalIPv6} {
+		if address == "" || address == AutoAddress {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		if err := dm.tapDevice.AddAddress(prefix); err != nil {
+			return err
+		}
+	}
+
+	for _, route := range config.Network.Routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return fmt.Errorf("invalid route %q: %w", route, err)
+		}
+		if err := dm.tapDevice.AddRoute(prefix, netip.Addr{}); err != nil {
+			return err
+		}
+		routerLogger.Info("route added", "subnet", prefix.Masked(), "device", dm.tapDevice.Name())
+	}
+
+	warnForwarding(config.Network.AdvertiseSubnets)
+	dm.updatePeerSubnets()
+	return nil
+}
+
+// updateRoutes applies a reloaded network.routes: removes the subnets taken out and routes the ones added
+// Failures are logged; the other routes are still applied.
+func (dm *DaemonManager) updateRoutes(previous, next []string) {
+	if dm.tapDevice == nil {
+		return
+	}
+
+	for _, route := range previous {
+		if slices.Contains(next, route) {
+			continue
+		}
+		prefix, _ := netip.ParsePrefix(route) // Validated when loaded
+		if err := dm.tapDevice.RemoveRoute(prefix); err != nil {
+			routerLogger.Warn("failed to remove route", "subnet", route, "error", err)
+			continue
+		}
+		routerLogger.Info("route removed", "subnet", prefix.Masked(), "device", dm.tapDevice.Name())
+	}
+
+	for _, route := range next {
+		if slices.Contains(previous, route) {
+			continue
+		}
+		prefix, _ := netip.ParsePrefix(route)
+		if err := dm.tapDevice.AddRoute(prefix, netip.Addr{}); err != nil {
+			routerLogger.Warn("failed to add route", "subnet", route, "error", err)
+			continue
+		}
+		routerLogger.Info("route added", "subnet", prefix.Masked(), "device", dm.tapDevice.Name())
+	}
+}
+
+// tunnelPrefixes returns the configured tunnel addresses with their prefix lengths
+func (c *DaemonConfig) tunnelPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, address := range []string{c.Network.LocalIP, c.Network.LocalIPv6} {
+		if prefix, err := netip.ParsePrefix(address); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// peerSubnets returns network.peer_subnets by peer name; nil if not set
+func (c *DaemonConfig) peerSubnets() map[string][]netip.Prefix {
+	if len(c.Network.PeerSubnets) == 0 {
+		return nil
+	}
+	subnets := make(map[string][]netip.Prefix, len(c.Network.PeerSubnets))
+	for name, list := range c.Network.PeerSubnets {
+		subnets[name] = parseSubnets(list)
+	}
+	return subnets
+}
+
+// parseSubnets parses validated subnets, masking their host bits
+func parseSubnets(subnets []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(subnets))
+	for _, subnet := range subnets {
+		if prefix, err := netip.ParsePrefix(subnet); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes
+}
+
+// containsSubnet reports whether subnet lies within one of prefixes
+func containsSubnet(prefixes []netip.Prefix, subnet netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Bits() <= subnet.Bits() && prefix.Contains(subnet.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsSubnet reports whether subnet overlaps one of prefixes
+func overlapsSubnet(prefixes []netip.Prefix, subnet netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Overlaps(subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// announceSubnets adds our tunnel addresses and advertised subnets to a hello
+func (dm *DaemonManager) announceSubnets(msg *ControlMessage) {
+	config := dm.cfg()
+	for _, prefix := range dm.tunnelPrefixes() {
+		msg.Addresses = append(msg.Addresses, prefix.Addr().String())
+	}
+	for _, subnet := range parseSubnets(config.Network.AdvertiseSubnets) {
+		msg.Subnets = append(msg.Subnets, subnet.String())
+	}
+}
+
+// parseAnnouncement returns the tunnel addresses and subnets announced in a peer's hello
+// Addresses outside our tunnel networks and malformed entries are skipped.
+func (dm *DaemonManager) parseAnnouncement(senderID uint64, msg *ControlMessage) ([]netip.Addr, []netip.Prefix) {
+	tunnel := dm.tunnelPrefixes()
+
+	var addresses []netip.Addr
+	for _, address := range msg.Addresses {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			controlLogger.Warn("ignoring invalid peer address", senderAttr(senderID), "address", address)
+			continue
+		}
+		if !slices.ContainsFunc(tunnel, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+			controlLogger.Warn("ignoring peer address outside the tunnel network", senderAttr(senderID), "address", addr)
+			continue
+		}
+		if slices.ContainsFunc(tunnel, func(prefix netip.Prefix) bool { return prefix.Addr() == addr }) {
+			controlLogger.Warn("peer uses our own tunnel address, one of the two must change it (or use local_ip: auto)", senderAttr(senderID), "address", addr)
+			continue
+		}
+		if slices.ContainsFunc(tunnel, func(prefix netip.Prefix) bool { return !hostAddress(prefix, addr) }) {
+			controlLogger.Warn("ignoring the tunnel network's network or broadcast address announced by a peer", senderAttr(senderID), "address", addr)
+			continue
+		}
+		if slices.Contains(addresses, addr) {
+			continue
+		}
+		addresses = append(addresses, addr)
+	}
+
+	var subnets []netip.Prefix
+	for _, subnet := range msg.Subnets {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			controlLogger.Warn("ignoring invalid advertised subnet", senderAttr(senderID), "subnet", subnet)
+			continue
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return addresses, subnets
+}
+
+// hostAddress reports whether addr can be a host in prefix, rather than its IPv4 network or broadcast address
+// /31 and /32 networks and IPv6 have neither; addresses outside prefix are not its concern.
+func hostAddress(prefix netip.Prefix, addr netip.Addr) bool {
+	if !prefix.Addr().Is4() || prefix.Bits() >= 31 || !prefix.Contains(addr) {
+		return true
+	}
+	return addr != prefix.Masked().Addr() && prefix.Contains(addr.Next())
+}
+
+// sourceFilter holds the source addresses each peer may send from
+// Peers that have not announced their tunnel addresses (no hello yet, or a daemon
+// from before subnet advertisement) may use the addresses in our tunnel networks that
+// neither we nor another peer use.
+type sourceFilter struct {
+	peers  map[uint64][]netip.Prefix
+	tunnel []netip.Prefix
+	owned  map[netip.Addr]bool // Our tunnel addresses and those peers announced
+	names  map[uint64]string   // Names peers announced, for ACL rules
+}
+
+// allows reports whether senderID may send from src
+// The unspecified address and link-local addresses (DHCP, DAD, neighbour discovery) are always allowed.
+func (f *sourceFilter) allows(senderID uint64, src netip.Addr) bool {
+	if src.IsUnspecified() || src.IsLinkLocalUnicast() {
+		return true
+	}
+
+	if f.announces(senderID, src) {
+		return true
+	}
+	if _, known := f.peers[senderID]; known || f.owned[src] {
+		return false
+	}
+	for _, prefix := range f.tunnel {
+		if prefix.Contains(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// announces reports whether addr is one of senderID's tunnel addresses or within a subnet accepted from it
+func (f *sourceFilter) announces(senderID uint64, addr netip.Addr) bool {
+	for _, prefix := range f.peers[senderID] {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAllowed checks the source address of a frame or packet delivered from senderID
+// Payloads without a source address, such as non-IP frames in TAP mode, are allowed.
+// Returns the source address for logging.
+func (dm *DaemonManager) sourceAllowed(senderID uint64, payload []byte, layer layer2.Layer) (netip.Addr, bool) {
+	filter := dm.sources.Load()
+	if filter == nil {
+		return netip.Addr{}, true
+	}
+
+	var src netip.Addr
+	var ok bool
+	if layer == layer2.Layer3 {
+		src, ok = layer2.PacketSource(payload)
+	} else {
+		src, ok = layer2.FrameSource(payload)
+	}
+	if !ok {
+		return netip.Addr{}, true
+	}
+	return src, filter.allows(senderID, src)
+}
+
+// subnetRank returns how strongly the peer is entitled to advertise subnet (dm.peersMu held)
+// Without network.peer_subnets every peer may advertise it, a verified name ranking
+// above none. With it, only a verified peer whose entry contains the subnet may, a
+// more specific entry ranking higher.
+func (peer *peerSession) subnetRank(peerSubnets map[string][]netip.Prefix, subnet netip.Prefix) (int, bool) {
+	if peerSubnets == nil {
+		if peer.nameVerified {
+			return 1, true
+		}
+		return 0, true
+	}
+	if !peer.nameVerified {
+		return 0, false
+	}
+	rank := -1
+	for _, prefix := range peerSubnets[peer.name] {
+		if prefix.Bits() > rank && containsSubnet([]netip.Prefix{prefix}, subnet) {
+			rank = prefix.Bits()
+		}
+	}
+	return rank, rank >= 0
+}
+
+// gateway returns the next hop for a subnet behind the peer (dm.peersMu held)
+// In TAP mode the subnet is not on the device's segment, so it is routed through the
+// peer's tunnel address; a TUN device is point-to-point and needs no next hop.
+func (peer *peerSession) gateway(subnet netip.Prefix, layer layer2.Layer) netip.Addr {
+	if layer == layer2.Layer3 {
+		return netip.Addr{}
+	}
+	for _, addr := range peer.addresses {
+		if addr.Is4() == subnet.Addr().Is4() {
+			return addr
+		}
+	}
+	return netip.Addr{}
+}
+
+// updatePeerSubnets routes the accepted subnets peers advertise and rebuilds the source filter
+// A tunnel address belongs to the peer that announced it first; another peer claiming
+// it later may not send from it and is reported as conflicting. A subnet is accepted if it
+// lies within network.accept_subnets and the peer's network.peer_subnets entry (see
+// subnetRank), and does not overlap our tunnel network, whose addresses are only ever
+// allowed per peer. It is not routed if it overlaps our own advertised subnets or a
+// static route. If several peers advertise the same subnet it is routed to the one
+// subnetRank ranks highest, and among equals to the one that joined first.
+// With exit_node.use, all other traffic is routed to the exit named by exit_node.peer
+// (see exitnode.go).
+// Called when a hello arrives, when peers are forgotten and when the settings are reloaded.
+func (dm *DaemonManager) updatePeerSubnets() {
+	// Serialised so that the filter and routes stored last reflect the latest state
+	dm.subnetMu.Lock()
+	defer dm.subnetMu.Unlock()
+
+	config := dm.cfg()
+	tunnel := dm.tunnelPrefixes()
+	accept := parseSubnets(config.Network.AcceptSubnets)
+	reserved := append(parseSubnets(config.Network.AdvertiseSubnets), parseSubnets(config.Network.Routes)...)
+
+	filter := &sourceFilter{peers: make(map[uint64][]netip.Prefix), owned: make(map[netip.Addr]bool), names: make(map[uint64]string)}
+	for _, prefix := range tunnel {
+		filter.owned[prefix.Addr()] = true
+		filter.tunnel = append(filter.tunnel, prefix.Masked())
+		reserved = append(reserved, prefix.Masked())
+	}
+
+	var layer layer2.Layer
+	if dm.tapDevice != nil {
+		layer = dm.tapDevice.Layer()
+	}
+
+	routes := make(map[netip.Prefix]netip.Addr)
+	peerSubnets := config.peerSubnets()
+	routeOwners := make(map[netip.Prefix]uint64)
+	routeRanks := make(map[netip.Prefix]int)
+
+	dm.peersMu.Lock()
+	senderIDs := make([]uint64, 0, len(dm.peers))
+	for senderID := range dm.peers {
+		senderIDs = append(senderIDs, senderID)
+	}
+	slices.Sort(senderIDs)
+
+	owners := make(map[netip.Addr]uint64)
+	byJoin := slices.Clone(senderIDs)
+	slices.SortStableFunc(byJoin, func(a, b uint64) int { return dm.peers[a].joined.Compare(dm.peers[b].joined) })
+	for _, senderID := range byJoin {
+		for _, addr := range dm.peers[senderID].claims {
+			if _, taken := owners[addr]; !taken {
+				owners[addr] = senderID
+				filter.owned[addr] = true
+			}
+		}
+	}
+	names := dm.nameOwners(byJoin, config.peerKeys())
+
+	for _, senderID := range byJoin {
+		peer := dm.peers[senderID]
+		peer.addresses, peer.conflicting = nil, nil
+		for _, addr := range peer.claims {
+			if owners[addr] == senderID {
+				peer.addresses = append(peer.addresses, addr)
+			} else {
+				peer.conflicting = append(peer.conflicting, addr)
+			}
+		}
+
+		peer.accepted, peer.ignored = nil, nil
+		nameOwner, held := names[peer.name]
+		peer.nameVerified = held && nameOwner == senderID
+		peer.nameConflict = held && nameOwner != senderID
+		if peer.nameVerified {
+			filter.names[senderID] = peer.name
+		}
+		if !peer.announced {
+			continue
+		}
+
+		allowed := make([]netip.Prefix, 0, len(peer.addresses)+len(peer.subnets))
+		for _, addr := range peer.addresses {
+			allowed = append(allowed, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+		for _, subnet := range peer.subnets {
+			rank, entitled := peer.subnetRank(peerSubnets, subnet)
+			if !entitled || !containsSubnet(accept, subnet) || overlapsSubnet(filter.tunnel, subnet) {
+				peer.ignored = append(peer.ignored, subnet)
+				continue
+			}
+			peer.accepted = append(peer.accepted, subnet)
+			allowed = append(allowed, subnet)
+
+			if overlapsSubnet(reserved, subnet) {
+				continue
+			}
+			if _, taken := routeOwners[subnet]; taken && rank <= routeRanks[subnet] {
+				continue
+			}
+			routeOwners[subnet], routeRanks[subnet] = senderID, rank
+		}
+		filter.peers[senderID] = allowed
+	}
+	for subnet, senderID := range routeOwners {
+		routes[subnet] = dm.peers[senderID].gateway(subnet, layer)
+	}
+
+	exit, useExit := uint64(0), false
+	if config.ExitNode.Use {
+		exit, useExit = dm.selectExit(config.ExitNode.Peer)
+	}
+	if useExit {
+		dm.useExit(dm.peers[exit], layer, routes, filter)
+	}
+	dm.peersMu.Unlock()
+
+	dm.sources.Store(filter)
+	if useExit && !dm.activateExit(exit) {
+		for _, route := range exitnode.DefaultRoutes {
+			delete(routes, route)
+		}
+		useExit = false
+	}
+	dm.syncSubnetRoutes(routes)
+	if !useExit {
+		dm.deactivateExit()
+	}
+}
+
+// syncSubnetRoutes makes the device's peer subnet routes match routes (subnet → next hop)
+// Failures are logged; a failed route is retried on the next update. dm.subnetMu is held.
+func (dm *DaemonManager) syncSubnetRoutes(routes map[netip.Prefix]netip.Addr) {
+	if dm.tapDevice == nil {
+		return
+	}
+
+	for subnet := range dm.subnetRoutes {
+		if _, keep := routes[subnet]; keep {
+			continue
+		}
+		if err := dm.tapDevice.RemoveRoute(subnet); err != nil {
+			routerLogger.Warn("failed to remove subnet route", "subnet", subnet, "error", err)
+			continue
+		}
+		delete(dm.subnetRoutes, subnet)
+		routerLogger.Info("subnet route removed", "subnet", subnet, "device", dm.tapDevice.Name())
+	}
+
+	for subnet, via := range routes {
+		if current, installed := dm.subnetRoutes[subnet]; installed && current == via {
+			continue
+		}
+		if err := dm.tapDevice.AddRoute(subnet, via); err != nil {
+			routerLogger.Warn("failed to add subnet route", "subnet", subnet, "error", err)
+			continue
+		}
+		dm.subnetRoutes[subnet] = via
+		routerLogger.Info("subnet route added", "subnet", subnet, "via", via, "device", dm.tapDevice.Name())
+	}
+}
+
+// warnForwarding warns if the kernel will not forward traffic for the advertised subnets
+// Reads the Linux sysctls; elsewhere nothing is checked.
+func warnForwarding(subnets []string) {
+	warned := make(map[string]bool)
+	for _, subnet := range parseSubnets(subnets) {
+		sysctl := "net/ipv4/ip_forward"
+		if subnet.Addr().Is6() {
+			sysctl = "net/ipv6/conf/all/forwarding"
+		}
+		if warned[sysctl] {
+			continue
+		}
+		value, err := os.ReadFile("/proc/sys/" + sysctl)
+		if err == nil && strings.TrimSpace(string(value)) == "0" {
+			routerLogger.Warn("IP forwarding is disabled, advertised subnets are unreachable", "subnet", subnet, "sysctl", strings.ReplaceAll(sysctl, "/", "."))
+			warned[sysctl] = true
+		}
+	}
+}
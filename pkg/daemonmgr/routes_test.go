@@ -0,0 +1,239 @@
+package daemonmgr
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// newTestDaemon creates a daemon with a running encryption pipeline but no device or transport,
+// so that control messages can be handed to it directly
+func newTestDaemon(t *testing.T, configure func(*DaemonConfig)) *DaemonManager {
+	t.Helper()
+
+	config := &DaemonConfig{}
+	config.Network.LocalIP = "10.77.0.1/24"
+	config.Encryption.Key = testConfigKey
+	if configure != nil {
+		configure(config)
+	}
+
+	dm, err := NewDaemonManager(config)
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+	if err := dm.initEncryptionPipeline(); err != nil {
+		t.Fatalf("initEncryptionPipeline() failed: %v", err)
+	}
+	t.Cleanup(func() { dm.encryptionPipeline.Stop() })
+	return dm
+}
+
+// hello hands dm a hello from senderID announcing addresses and subnets
+func hello(dm *DaemonManager, senderID uint64, addresses, subnets []string) {
+	dm.handleHello(senderID, &ControlMessage{Type: ControlHello, Reply: true, Addresses: addresses, Subnets: subnets})
+}
+
+// peerByID returns the status of one peer
+func peerByID(t *testing.T, dm *DaemonManager, senderID uint64) PeerStatus {
+	t.Helper()
+
+	for _, peer := range dm.GetPeers() {
+		if peer.SenderID == fmt.Sprintf("%016x", senderID) {
+			return peer
+		}
+	}
+	t.Fatalf("peer %016x unknown", senderID)
+	return PeerStatus{}
+}
+
+// TestPeerAddressConflict tests that a tunnel address claimed by two peers stays with the one that announced it first
+func TestPeerAddressConflict(t *testing.T) {
+	dm := newTestDaemon(t, nil)
+	const bob, mallory = 0x0b, 0x0a // Mallory's lower sender ID must not matter
+
+	hello(dm, bob, []string{"10.77.0.2"}, nil)
+	hello(dm, mallory, []string{"10.77.0.2", "10.77.0.3"}, nil)
+	hello(dm, mallory, []string{"10.77.0.2", "10.77.0.3"}, nil) // Announcing again changes nothing
+
+	filter := dm.sources.Load()
+	tests := []struct {
+		senderID uint64
+		src      string
+		want     bool
+	}{
+		{bob, "10.77.0.2", true},
+		{mallory, "10.77.0.2", false},
+		{mallory, "10.77.0.3", true},
+		{bob, "10.77.0.3", false},
+	}
+	for _, tt := range tests {
+		if got := filter.allows(tt.senderID, netip.MustParseAddr(tt.src)); got != tt.want {
+			t.Errorf("allows(%016x, %s) = %v, want %v", tt.senderID, tt.src, got, tt.want)
+		}
+	}
+
+	status := peerByID(t, dm, mallory)
+	if !slices.Equal(status.Addresses, []string{"10.77.0.3"}) || !slices.Equal(status.Conflicting, []string{"10.77.0.2"}) {
+		t.Errorf("mallory's addresses = %v, conflicting %v; want [10.77.0.3] and [10.77.0.2]", status.Addresses, status.Conflicting)
+	}
+	if status := peerByID(t, dm, bob); !slices.Equal(status.Addresses, []string{"10.77.0.2"}) || len(status.Conflicting) != 0 {
+		t.Errorf("bob's addresses = %v, conflicting %v; want [10.77.0.2] and none", status.Addresses, status.Conflicting)
+	}
+
+	// Once bob is forgotten the address is free
+	dm.peersMu.Lock()
+	delete(dm.peers, bob)
+	dm.peersMu.Unlock()
+	dm.updatePeerSubnets()
+	if !dm.sources.Load().allows(mallory, netip.MustParseAddr("10.77.0.2")) {
+		t.Error("address not released when its owner was forgotten")
+	}
+}
+
+// TestUnannouncedPeerSource tests that a peer without announced addresses may not use ours or another peer's
+func TestUnannouncedPeerSource(t *testing.T) {
+	dm := newTestDaemon(t, nil)
+	const bob, carol, dave = 0x0b, 0x0c, 0x0d // carol sent no hello, dave one without addresses
+
+	hello(dm, bob, []string{"10.77.0.2"}, nil)
+	hello(dm, dave, nil, nil)
+
+	filter := dm.sources.Load()
+	for _, senderID := range []uint64{carol, dave} {
+		for src, want := range map[string]bool{
+			"10.77.0.1":   false, // Ours
+			"10.77.0.2":   false, // bob's
+			"10.77.0.9":   true,
+			"192.168.1.5": false,
+		} {
+			if got := filter.allows(senderID, netip.MustParseAddr(src)); got != want {
+				t.Errorf("allows(%016x, %s) = %v, want %v", senderID, src, got, want)
+			}
+		}
+		if filter.announces(senderID, netip.MustParseAddr("10.77.0.9")) {
+			t.Errorf("announces(%016x, 10.77.0.9) = true, want false", senderID)
+		}
+	}
+}
+
+// TestPeerAddressValidation tests which announced addresses and subnets a peer may send from
+func TestPeerAddressValidation(t *testing.T) {
+	dm := newTestDaemon(t, func(config *DaemonConfig) {
+		config.Network.AcceptSubnets = []string{"10.0.0.0/8", "192.168.0.0/16"}
+	})
+	const bob = 0x0b
+
+	hello(dm, bob,
+		[]string{"10.77.0.0", "10.77.0.255", "10.77.0.1", "192.168.1.5", "bogus", "10.77.0.4", "10.77.0.4"},
+		[]string{"10.77.0.0/16", "192.168.1.0/24", "172.16.0.0/12"})
+
+	status := peerByID(t, dm, bob)
+	if !slices.Equal(status.Addresses, []string{"10.77.0.4"}) {
+		t.Errorf("addresses = %v, want only [10.77.0.4]", status.Addresses)
+	}
+	if !slices.Equal(status.Subnets, []string{"192.168.1.0/24"}) || !slices.Equal(status.IgnoredSubnets, []string{"10.77.0.0/16", "172.16.0.0/12"}) {
+		t.Errorf("subnets = %v, ignored %v; want [192.168.1.0/24] and [10.77.0.0/16 172.16.0.0/12]", status.Subnets, status.IgnoredSubnets)
+	}
+
+	filter := dm.sources.Load()
+	for src, want := range map[string]bool{
+		"10.77.0.4":   true,
+		"192.168.1.5": true,  // Within an accepted subnet
+		"10.77.0.2":   false, // Within the advertised 10.77.0.0/16, but that overlaps the tunnel network
+		"10.77.0.255": false,
+		"10.77.0.1":   false,
+		"172.16.0.1":  false,
+	} {
+		if got := filter.allows(bob, netip.MustParseAddr(src)); got != want {
+			t.Errorf("allows(%s) = %v, want %v", src, got, want)
+		}
+	}
+}
+
+// TestPeerSubnetOwnership tests which peer a subnet advertised by several is routed to
+func TestPeerSubnetOwnership(t *testing.T) {
+	office, branch := testIdentity(0), testIdentity(1)
+	keys := map[string]string{
+		"office": hex.EncodeToString(office.Public().(ed25519.PublicKey)),
+		"branch": hex.EncodeToString(branch.Public().(ed25519.PublicKey)),
+	}
+	const mallory, officeID, branchID = 0x01, 0x0a, 0x0b // Mallory's lower sender ID must not matter
+
+	tests := []struct {
+		name        string
+		peerSubnets map[string][]string
+		want        map[string]string // Subnet → next hop
+		wantIgnored []string          // Subnets ignored from mallory
+	}{
+		{"verified name wins", nil,
+			map[string]string{"192.168.1.0/24": "10.77.0.2", "192.168.2.0/24": "10.77.0.2"}, nil},
+		{"allowlist", map[string][]string{"office": {"192.168.0.0/16"}, "branch": {"192.168.2.0/24"}},
+			map[string]string{"192.168.1.0/24": "10.77.0.2", "192.168.2.0/24": "10.77.0.3"}, []string{"192.168.1.0/24", "192.168.2.0/24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newTestDaemon(t, func(config *DaemonConfig) {
+				config.Network.AcceptSubnets = []string{"192.168.0.0/16"}
+				config.Network.PeerSubnets = tt.peerSubnets
+				config.Identity.Key = testIdentityKey
+				config.Identity.Peers = keys
+			})
+			device, err := layer2.NewPipeDevice(layer2.DeviceConfig{Mode: layer2.ModeTAP})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm.SetNetworkDevice(device)
+
+			subnets := []string{"192.168.1.0/24", "192.168.2.0/24"}
+			for _, peer := range []struct {
+				senderID uint64
+				address  string
+				name     string
+				key      ed25519.PrivateKey
+			}{{mallory, "10.77.0.9", "mallory", nil}, {officeID, "10.77.0.2", "office", office}, {branchID, "10.77.0.3", "branch", branch}} {
+				msg := &ControlMessage{Type: ControlHello, Reply: true, Addresses: []string{peer.address}, Subnets: subnets, Name: peer.name}
+				proveHello(t, dm, peer.senderID, msg, peer.key)
+			}
+
+			got := make(map[string]string)
+			for subnet, via := range dm.subnetRoutes {
+				got[subnet.String()] = via.String()
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("routes = %v, want %v", got, tt.want)
+			}
+			if ignored := peerByID(t, dm, mallory).IgnoredSubnets; !slices.Equal(ignored, tt.wantIgnored) {
+				t.Errorf("mallory's ignored subnets = %v, want %v", ignored, tt.wantIgnored)
+			}
+		})
+	}
+}
+
+// TestHostAddress tests recognising IPv4 network and broadcast addresses
+func TestHostAddress(t *testing.T) {
+	tests := []struct {
+		prefix, addr string
+		want         bool
+	}{
+		{"10.77.0.1/24", "10.77.0.0", false},
+		{"10.77.0.1/24", "10.77.0.255", false},
+		{"10.77.0.1/24", "10.77.0.7", true},
+		{"10.77.0.1/20", "10.77.15.255", false},
+		{"10.77.0.1/20", "10.77.1.255", true},
+		{"10.77.0.0/31", "10.77.0.0", true},
+		{"10.77.0.1/24", "10.78.0.0", true},
+		{"fd00:5d::1/64", "fd00:5d::", true},
+	}
+	for _, tt := range tests {
+		if got := hostAddress(netip.MustParsePrefix(tt.prefix), netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("hostAddress(%s, %s) = %v, want %v", tt.prefix, tt.addr, got, tt.want)
+		}
+	}
+}
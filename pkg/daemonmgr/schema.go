@@ -0,0 +1,190 @@
+package daemonmgr
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/fec"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// durationPattern matches the durations time.ParseDuration accepts, e.g. "90s" or "1h30m"
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// configSchemaHints adds descriptions and constraints to the schema generated from DaemonConfig
+// Keep them in step with the checks in Validate.
+var configSchemaHints = map[string]map[string]interface{}{
+	"daemon":                {"description": "API access and logging"},
+	"daemon.listen_address": {"description": "TCP API address for remote management, e.g. 0.0.0.0:9090; only served with api_token"},
+	"daemon.log_level":      {"description": "Log verbosity", "enum": []string{"debug", "info", "warn", "error"}},
+	"daemon.log_format":     {"description": "Log output format (default: text)", "enum": []string{"text", "json"}},
+	"daemon.log_levels":     {"description": "Levels for single components, overriding log_level, e.g. {nat: debug, router: warn}", "additionalProperties": map[string]interface{}{"enum": []string{"debug", "info", "warn", "error"}}},
+	"daemon.socket":         {"description": "Unix socket for local control (default: /run/shadowmesh/daemon.sock)"},
+	"daemon.socket_mode":    {"description": "Socket file mode in octal; must not grant access to other users (default: \"0660\")", "pattern": "^0?[0-7][0-7]0$"},
+	"daemon.socket_group":   {"description": "Group whose members may use the socket"},
+	"daemon.api_token":      {"description": "Bearer token required on the TCP API", "minLength": minAPITokenLength},
+	"daemon.api_token_file": {"description": "File holding api_token instead; must not be accessible to group or others"},
+
+	"network":                   {"description": "Tunnel device"},
+	"network.mode":              {"description": "Device type (default: tun on macOS, tap elsewhere)", "enum": []string{"tap", "tun"}},
+	"network.tap_device":        {"description": "Device name (for backward compatibility; default: tap0)", "maxLength": maxInterfaceNameLength},
+	"network.device_name":       {"description": "Device name (preferred over tap_device)", "maxLength": maxInterfaceNameLength},
+	"network.local_ip":          {"description": "Tunnel address with prefix length, e.g. 10.0.0.1/24, or auto to have the relay assign it (requires relay.enabled and identity.key)"},
+	"network.local_ipv6":        {"description": "IPv6 tunnel address with prefix length, e.g. fd00:5d::1/64"},
+	"network.routes":            {"description": "Subnets reached through the mesh, routed to the device; reloadable"},
+	"network.advertise_subnets": {"description": "Local subnets this node routes for peers (site-to-site); reloadable"},
+	"network.accept_subnets":    {"description": "Subnets peers may advertise; those advertised are routed to the device and accepted as source addresses from that peer (default: none); reloadable"},
+	"network.peer_subnets":      {"description": "Subnets each peer, by its name in identity.peers, may advertise within accept_subnets, e.g. {office: [192.168.1.0/24]}; when set, other peers' subnets are ignored and a subnet two peers advertise goes to the more specific entry; reloadable"},
+	"network.proxy_neighbors":   {"description": "Answer ARP requests and neighbour solicitations for peers locally instead of flooding them to every peer (tap mode); reloadable"},
+	"network.broadcast_limit":   {"description": "Broadcast and multicast frames sent to peers per second, duplicates dropped (0: no limit; tap mode); reloadable", "minimum": 0},
+	"network.mtu":               {"description": "Fixed device MTU (0: derived from the path MTU)", "anyOf": []interface{}{map[string]interface{}{"const": 0}, map[string]interface{}{"minimum": minMTU, "maximum": maxMTU}}},
+
+	"exit_node":             {"description": "Routing internet traffic through a peer (exit node mode, Linux only); restart required"},
+	"exit_node.advertise":   {"description": "Act as internet exit for peers: enable IP forwarding and masquerade their traffic"},
+	"exit_node.interface":   {"description": "Uplink the exit masquerades out of, e.g. eth0 (default: any interface but the tunnel)", "maxLength": maxInterfaceNameLength},
+	"exit_node.allow_lan":   {"description": "Let peers using this exit reach its private, link-local and connected networks; by default only the internet and network.advertise_subnets"},
+	"exit_node.use":         {"description": "Route all traffic through the exit_node.peer when it advertises itself as exit"},
+	"exit_node.peer":        {"description": "Name in identity.peers of the peer used as exit; required with use, no other peer is used in its place"},
+	"exit_node.dns":         {"description": "Redirect DNS queries to these servers while an exit is in use, e.g. [10.0.0.1]; the first IPv4 and IPv6 server are used"},
+	"exit_node.kill_switch": {"description": "Drop traffic leaving outside the tunnel, also while no exit is available; the relay, peer and STUN server stay reachable"},
+
+	"dns":          {"description": "Resolving <peer name>.<domain> to tunnel addresses; restart required"},
+	"dns.enabled":  {"description": "Serve DNS on the tunnel address: peer names from the hellos signed with their key in identity.peers, other names forwarded upstream; on Linux systemd-resolved sends queries for the domain to it"},
+	"dns.domain":   {"description": "Domain peer names are resolved under (default: mesh.internal)"},
+	"dns.name":     {"description": "Name this node announces to peers, e.g. laptop (default: peer.id, else the hostname)"},
+	"dns.listen":   {"description": "Addresses to serve on, e.g. [10.0.0.1:53] (default: the IPv4 tunnel address, port 53); split DNS needs port 53"},
+	"dns.upstream": {"description": "Servers other queries are forwarded to, e.g. [1.1.1.1, 9.9.9.9:53] (default: the name servers in /etc/resolv.conf)"},
+
+	"acl":                   {"description": "Stateful filtering of the packets exchanged with peers; reloadable"},
+	"acl.enabled":           {"description": "Filter by the rules: the first rule matching a new connection decides, and replies to allowed connections pass"},
+	"acl.default_inbound":   {"description": "Action for connections peers open that no rule matches (default: deny)", "enum": []string{"allow", "deny"}},
+	"acl.default_outbound":  {"description": "Action for connections to peers that no rule matches (default: allow)", "enum": []string{"allow", "deny"}},
+	"acl.groups":            {"description": "Named lists of peer names, addresses and CIDRs, used in rules as group:<name>, e.g. {admins: [laptop, 10.0.0.9]}"},
+	"acl.rules":             {"description": "Rules in the order they are checked"},
+	"acl.rules[].action":    {"description": "What happens to matching connections", "enum": []string{"allow", "deny"}},
+	"acl.rules[].direction": {"description": "in: connections peers open to us; out: connections we open to peers (default: in)", "enum": []string{"in", "out", "both"}},
+	"acl.rules[].peers":     {"description": "Peer names (as announced, see dns.name, and proven by identity.peers), group:<name>, addresses or CIDRs; * or none: any peer"},
+	"acl.rules[].protocol":  {"description": "Protocol to match (default: any)", "enum": []string{"tcp", "udp", "icmp", "any"}},
+	"acl.rules[].ports":     {"description": "Ports or ranges the connection is opened to, e.g. [\"22\", \"8000-8080\"]; needs protocol tcp or udp"},
+	"acl.rules[].networks":  {"description": "Virtual networks, by networks[].id, the rule applies in (default: every network)"},
+
+	"networks":            {"description": "Named virtual networks to join instead of the default one; the relay only switches frames within a network (tap mode)"},
+	"networks[].id":       {"description": "Network name; peers that join the same name share the network", "pattern": "^[A-Za-z0-9._-]{1,64}$"},
+	"networks[].key":      {"description": "Hex-encoded 32-byte key of the network; only peers holding it can read or send its frames", "pattern": "^[0-9a-fA-F]{64}$"},
+	"networks[].key_file": {"description": "File holding key instead; must not be accessible to group or others"},
+	"networks[].vlan":     {"description": "802.1Q tag of the network's frames on the main device (0: untagged, for at most one network)", "minimum": 0, "maximum": layer2.MaxVLAN},
+	"networks[].device":   {"description": "TAP device of its own for the network's frames, instead of the main device; excludes vlan", "maxLength": maxInterfaceNameLength},
+
+	"path_mtu":           {"description": "Path MTU discovery"},
+	"path_mtu.discovery": {"description": "Probe the path MTU to the peer (DPLPMTUD) and resize the device"},
+	"path_mtu.max_size":  {"description": "Largest tunnel datagram in bytes (0: 1472)", "anyOf": []interface{}{map[string]interface{}{"const": 0}, map[string]interface{}{"minimum": minMTU, "maximum": maxDatagramSize}}},
+
+	"encryption":                   {"description": "Pre-shared key and session key rotation"},
+	"encryption.key":               {"description": "Hex-encoded 32-byte pre-shared key; generate with `shadowmesh keys generate`", "pattern": "^[0-9a-fA-F]{64}$"},
+	"encryption.key_file":          {"description": "File holding key instead; must not be accessible to group or others"},
+	"encryption.rotation_interval": {"description": "Rotate the session transmit key this often, at least 1m (\"0s\": only at the nonce limit)"},
+
+	"identity":          {"description": "Keys proving peer names and binding data frames to their sender; ACL rules and MagicDNS only trust names signed by the key listed here"},
+	"identity.key":      {"description": "Hex-encoded 32-byte Ed25519 seed signing our dns.name and key share; our data frames are then only readable by peers in identity.peers; set with identity.peers; generate with `shadowmesh keys identity`", "pattern": "^[0-9a-fA-F]{64}$"},
+	"identity.key_file": {"description": "File holding key instead; must not be accessible to group or others"},
+	"identity.peers":    {"description": "Hex-encoded Ed25519 public key of each peer name, e.g. {laptop: 3b6a27bc...}"},
+
+	"peer":         {"description": "Peer to connect to on startup"},
+	"peer.address": {"description": "Peer host:port (can also be given to `shadowmesh connect`)"},
+	"peer.id":      {"description": "Peer ID for relay mode"},
+
+	"nat":             {"description": "NAT detection and UDP hole punching"},
+	"nat.enabled":     {"description": "Detect the NAT type and try direct UDP before the relay"},
+	"nat.stun_server": {"description": "STUN server host:port (default: stun.l.google.com:19302)"},
+
+	"relay":         {"description": "Relay server connection"},
+	"relay.enabled": {"description": "Connect through the relay server instead of directly"},
+	"relay.server":  {"description": "Relay server URL, e.g. ws://relay.example.com:9545", "pattern": "^wss?://"},
+
+	"reconnect":                 {"description": "Redialling a lost connection with jittered exponential backoff"},
+	"reconnect.initial_backoff": {"description": "Delay before the first retry, e.g. 1s (\"0s\": 1s)"},
+	"reconnect.max_backoff":     {"description": "Longest delay between retries, e.g. 1m (\"0s\": 1m)"},
+	"reconnect.max_attempts":    {"description": "Give up after this many failed retries (0: retry forever)", "minimum": 0},
+
+	"p2p":                  {"description": "Listener for incoming direct connections"},
+	"p2p.listener_enabled": {"description": "Accept incoming P2P connections"},
+	"p2p.listener_port":    {"description": "P2P listener port (0: 9545)", "minimum": 0, "maximum": 65535},
+
+	"compression":         {"description": "Frame compression"},
+	"compression.enabled": {"description": "Compress the frames this node sends with lz4, negotiated in the hello exchange after connecting: frames stay uncompressed until every peer has announced lz4 support"},
+
+	"fec":                   {"description": "Reed-Solomon forward error correction on the direct UDP transport"},
+	"fec.enabled":           {"description": "Send parity frames (used only if every peer supports it)"},
+	"fec.data_shards":       {"description": "Frames per FEC group (0: 8)", "minimum": 0, "maximum": fec.MaxDataShards},
+	"fec.parity_shards":     {"description": "Parity frames per group; the minimum when adaptive (0: 2)", "minimum": 0, "maximum": fec.MaxParityShards},
+	"fec.max_parity_shards": {"description": "Upper bound for adaptive parity (0: 8)", "minimum": 0, "maximum": fec.MaxParityShards},
+	"fec.adaptive":          {"description": "Adjust parity to the loss measured from keepalives"},
+
+	"multipath":            {"description": "Bonding of several uplinks"},
+	"multipath.enabled":    {"description": "Bond a direct UDP path per local uplink (the peer must enable it too)"},
+	"multipath.interfaces": {"description": "Uplinks to bond, e.g. [eth0, wwan0] (default: every interface with an IPv4 address)", "uniqueItems": true},
+	"multipath.mode":       {"description": "How frames are spread over paths", "enum": []string{"lowest_latency", "round_robin", "redundant"}},
+
+	"metrics":                {"description": "Prometheus metrics"},
+	"metrics.listen_address": {"description": "Also serve /metrics over plain TCP, e.g. 127.0.0.1:9101"},
+}
+
+// ConfigSchema returns a JSON Schema (draft 2020-12) for daemon.yaml, for editor completion and checks
+// The schema is generated from DaemonConfig, so it lists exactly the fields LoadConfig accepts.
+// No field is required: network.local_ip and the key may come from SHADOWMESH_* variables
+// or secret files, so only Validate can tell whether they are missing.
+func ConfigSchema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(DaemonConfig{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "ShadowMesh daemon configuration"
+	return schema
+}
+
+// schemaFor builds the schema of one field type, including its hints
+func schemaFor(t reflect.Type, path string) map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		schema["type"] = "string"
+		schema["pattern"] = durationPattern
+	case t.Kind() == reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			properties[name] = schemaFor(field.Type, fieldPath)
+		}
+		schema["type"] = "object"
+		if path != "" {
+			schema["type"] = []string{"object", "null"} // An empty section keeps the defaults
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case t.Kind() == reflect.Map:
+		schema["type"] = []string{"object", "null"}
+		schema["additionalProperties"] = schemaFor(t.Elem(), "")
+	case t.Kind() == reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), path+"[]") // Hints for list items, e.g. "acl.rules[].action"
+	case t.Kind() == reflect.Bool:
+		schema["type"] = "boolean"
+	case t.Kind() == reflect.Int:
+		schema["type"] = "integer"
+	case t.Kind() == reflect.String:
+		schema["type"] = "string"
+	}
+
+	for key, value := range configSchemaHints[path] {
+		schema[key] = value
+	}
+	return schema
+}
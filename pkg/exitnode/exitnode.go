@@ -0,0 +1,95 @@
+// Package exitnode lets a node act as an internet exit for its peers, and sends a
+// client's traffic through one
+//
+// The exit enables IP forwarding and masquerades traffic arriving from the tunnel,
+// keeping it out of the exit's local networks unless allowed. A client routes the
+// whole address space into the tunnel as two halves per family (DefaultRoutes): being
+// more specific, they win over the underlay default route without replacing it. The relay and peer endpoints keep host routes through the
+// underlay, so the tunnel does not carry itself. DNS queries can be redirected into the
+// tunnel, and a kill switch blocks whatever would leave outside it, also while the
+// tunnel is down.
+package exitnode
+
+import (
+	"net/netip"
+)
+
+// DefaultRoutes cover every IPv4 and IPv6 address in two halves per family
+// Routed to the tunnel device, they take precedence over 0.0.0.0/0 and ::/0.
+var DefaultRoutes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/1"),
+	netip.MustParsePrefix("128.0.0.0/1"),
+	netip.MustParsePrefix("::/1"),
+	netip.MustParsePrefix("8000::/1"),
+}
+
+// AnyAddress matches every IPv4 and IPv6 address: the sources an exit sends from
+var AnyAddress = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/0"),
+	netip.MustParsePrefix("::/0"),
+}
+
+// LANPrefixes are the private and link-local ranges an exit keeps its clients out of
+// Together with the prefixes connected to the exit's other interfaces, they make up
+// its local networks; unless GatewayConfig.AllowLAN is set, only the internet is reached.
+var LANPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+}
+
+// GatewayConfig configures the exit side
+type GatewayConfig struct {
+	Device   string         // Tunnel device the clients' traffic arrives on
+	Uplink   string         // Interface to masquerade out of (default: any but Device)
+	AllowLAN bool           // Also forward to LANPrefixes and the exit's connected prefixes
+	Subnets  []netip.Prefix // Subnets the exit routes for peers, forwarded even without AllowLAN
+}
+
+// ClientConfig configures the client side
+type ClientConfig struct {
+	Device     string       // Tunnel device
+	Endpoints  []netip.Addr // Underlay addresses of the relay, peers and STUN server
+	ListenPort int          // Local port of the P2P listener, whose traffic from the daemon the kill switch lets out (0: none)
+	KillSwitch bool         // Drop traffic leaving outside the tunnel
+}
+
+// Controller applies exit node settings to the host
+// A Controller serves one side, started by StartGateway or StartClient. Thread-safe.
+type Controller interface {
+	// StartGateway enables IP forwarding and masquerades traffic from the tunnel
+	// Without AllowLAN, traffic from the tunnel to the exit's local networks is dropped.
+	StartGateway(config GatewayConfig) error
+
+	// StartClient prepares the client side; with a kill switch, traffic outside the tunnel is blocked from here on
+	StartClient(config ClientConfig) error
+
+	// Activate routes the endpoints through the underlay and redirects DNS queries to dns
+	// Called before DefaultRoutes are routed to the tunnel.
+	Activate(dns []netip.Addr) error
+
+	// Deactivate undoes Activate once DefaultRoutes are removed; the kill switch stays
+	Deactivate() error
+
+	// Stop undoes everything the Controller applied
+	Stop() error
+}
+
+// NewController returns a Controller for the host network stack
+// On Linux it uses nftables, rtnetlink and /proc/sys; elsewhere every method fails.
+func NewController() Controller {
+	return newSystemController()
+}
+
+// NopController applies nothing
+// For devices outside the host network stack, such as a layer2.PipeDevice.
+type NopController struct{}
+
+func (NopController) StartGateway(GatewayConfig) error { return nil }
+func (NopController) StartClient(ClientConfig) error   { return nil }
+func (NopController) Activate([]netip.Addr) error      { return nil }
+func (NopController) Deactivate() error                { return nil }
+func (NopController) Stop() error                      { return nil }
@@ -0,0 +1,574 @@
+package exitnode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// forwardingSysctls are enabled on the exit, IPv4 first
+// Enabling IPv6 forwarding makes the kernel ignore router advertisements on interfaces
+// with accept_ra=1, so an exit relying on SLAAC for its own IPv6 uplink needs accept_ra=2.
+var forwardingSysctls = []string{"net/ipv4/ip_forward", "net/ipv6/conf/all/forwarding"}
+
+// systemController applies exit node settings with nftables, rtnetlink and /proc/sys
+// Its rules live in one inet table named after the device, replaced as a whole in a
+// single batch whenever they change, so there is never a moment without the kill switch.
+type systemController struct {
+	mu sync.Mutex
+
+	table   string
+	gateway *GatewayConfig
+	client  *ClientConfig
+
+	sysctls    map[string]string // Values before StartGateway, restored by Stop
+	hostRoutes []netlink.Route   // Endpoint routes added by Activate
+	dns        []netip.Addr      // DNS servers queries are redirected to while active
+}
+
+func newSystemController() Controller {
+	return &systemController{}
+}
+
+// StartGateway enables forwarding and masquerades traffic arriving on the tunnel device
+// Unless config.AllowLAN is set, a forward chain drops that traffic when it is bound
+// for the exit's local networks.
+func (s *systemController) StartGateway(config GatewayConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sysctls = make(map[string]string)
+	for i, sysctl := range forwardingSysctls {
+		previous, err := writeSysctl(sysctl, "1")
+		if errors.Is(err, os.ErrNotExist) && i > 0 {
+			continue // IPv6 disabled
+		}
+		if err != nil {
+			s.restoreSysctls()
+			return err
+		}
+		s.sysctls[sysctl] = previous
+	}
+
+	s.table = tableName(config.Device)
+	s.gateway = &config
+	if err := s.apply(); err != nil {
+		s.gateway = nil
+		s.restoreSysctls()
+		return err
+	}
+	return nil
+}
+
+// StartClient installs the kill switch if configured
+func (s *systemController) StartClient(config ClientConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.table = tableName(config.Device)
+	s.client = &config
+	if err := s.apply(); err != nil {
+		s.client = nil
+		return err
+	}
+	return nil
+}
+
+// Activate adds host routes for the endpoints through the underlay and redirects DNS
+// Endpoints reached without a gateway (on-link or local) need no route.
+func (s *systemController) Activate(dns []netip.Addr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return fmt.Errorf("exit node client not started")
+	}
+
+	tunnel, err := netlink.LinkByName(s.client.Device)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", s.client.Device, err)
+	}
+
+	s.removeHostRoutes()
+	for _, endpoint := range s.client.Endpoints {
+		route, err := underlayRoute(endpoint, tunnel.Attrs().Index)
+		if err != nil {
+			s.removeHostRoutes()
+			return err
+		}
+		if route == nil {
+			continue
+		}
+		if err := netlink.RouteReplace(route); err != nil {
+			s.removeHostRoutes()
+			return fmt.Errorf("failed to route %s through the underlay: %w", endpoint, err)
+		}
+		s.hostRoutes = append(s.hostRoutes, *route)
+	}
+
+	s.dns = dns
+	if err := s.apply(); err != nil {
+		s.dns = nil
+		s.removeHostRoutes()
+		return err
+	}
+	return nil
+}
+
+// Deactivate removes the endpoint routes and the DNS redirection
+func (s *systemController) Deactivate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeHostRoutes()
+	if s.dns == nil {
+		return nil
+	}
+	s.dns = nil
+	return s.apply()
+}
+
+// Stop removes the table and routes and restores the forwarding sysctls
+func (s *systemController) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeHostRoutes()
+	s.restoreSysctls()
+	s.gateway, s.client, s.dns = nil, nil, nil
+	if s.table == "" {
+		return nil
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("nftables: %w", err)
+	}
+	conn.DelTable(&nftables.Table{Family: nftables.TableFamilyINet, Name: s.table})
+	if err := conn.Flush(); err != nil && !errors.Is(err, unix.ENOENT) {
+		return fmt.Errorf("failed to remove nftables table %s: %w", s.table, err)
+	}
+	return nil
+}
+
+// apply replaces the table with the rules for the current state (s.mu held)
+func (s *systemController) apply() error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("nftables: %w", err)
+	}
+
+	// Adding before deleting makes the delete succeed whether or not the table exists
+	table := &nftables.Table{Family: nftables.TableFamilyINet, Name: s.table}
+	conn.AddTable(table)
+	conn.DelTable(table)
+	conn.AddTable(table)
+
+	if s.gateway != nil {
+		chain := conn.AddChain(&nftables.Chain{
+			Name:     "masquerade",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPostrouting,
+			Priority: nftables.ChainPriorityNATSource,
+		})
+		conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: masqueradeRule(*s.gateway)})
+
+		if !s.gateway.AllowLAN {
+			connected, err := connectedPrefixes(s.gateway.Device)
+			if err != nil {
+				return err
+			}
+			chain := conn.AddChain(&nftables.Chain{
+				Name:     "lan",
+				Table:    table,
+				Type:     nftables.ChainTypeFilter,
+				Hooknum:  nftables.ChainHookForward,
+				Priority: nftables.ChainPriorityFilter,
+			})
+			for _, rule := range lanRules(*s.gateway, connected) {
+				conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: rule})
+			}
+		}
+	}
+
+	if s.client != nil && len(s.dns) > 0 {
+		chain := conn.AddChain(&nftables.Chain{
+			Name:     "dns",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookOutput,
+			Priority: nftables.ChainPriorityNATDest,
+		})
+		for _, rule := range dnsRules(s.dns) {
+			conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: rule})
+		}
+	}
+
+	if s.client != nil && s.client.KillSwitch {
+		drop := nftables.ChainPolicyDrop
+		chain := conn.AddChain(&nftables.Chain{
+			Name:     "killswitch",
+			Table:    table,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookOutput,
+			Priority: nftables.ChainPriorityFilter,
+			Policy:   &drop,
+		})
+		for _, rule := range killSwitchRules(*s.client, currentSocketOwner()) {
+			conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: rule})
+		}
+
+		chain = conn.AddChain(&nftables.Chain{
+			Name:     "killswitch_forward",
+			Table:    table,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookForward,
+			Priority: nftables.ChainPriorityFilter,
+			Policy:   &drop,
+		})
+		for _, rule := range forwardKillSwitchRules(*s.client) {
+			conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: rule})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to apply nftables table %s: %w", s.table, err)
+	}
+	return nil
+}
+
+// removeHostRoutes deletes the endpoint routes added by Activate (s.mu held)
+func (s *systemController) removeHostRoutes() {
+	for i := range s.hostRoutes {
+		netlink.RouteDel(&s.hostRoutes[i]) // Gone already if the uplink went down
+	}
+	s.hostRoutes = nil
+}
+
+// restoreSysctls writes back the forwarding settings found by StartGateway (s.mu held)
+func (s *systemController) restoreSysctls() {
+	for sysctl, previous := range s.sysctls {
+		writeSysctl(sysctl, previous)
+	}
+	s.sysctls = nil
+}
+
+// tableName returns the nftables table for a tunnel device
+func tableName(device string) string {
+	return "shadowmesh_" + device
+}
+
+// writeSysctl sets a /proc/sys value, returning the one it replaces
+func writeSysctl(sysctl, value string) (string, error) {
+	path := "/proc/sys/" + sysctl
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", strings.ReplaceAll(sysctl, "/", "."), err)
+	}
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return "", fmt.Errorf("failed to set %s: %w", strings.ReplaceAll(sysctl, "/", "."), err)
+	}
+	return strings.TrimSpace(string(previous)), nil
+}
+
+// underlayRoute returns a host route for endpoint through the gateway it is reached by outside the tunnel
+// If the kernel already routes it into the tunnel, the main table's lowest-metric
+// default route on another interface is used. Returns nil if no gateway is needed.
+func underlayRoute(endpoint netip.Addr, tunnelIndex int) (*netlink.Route, error) {
+	ip := net.IP(endpoint.AsSlice())
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return nil, fmt.Errorf("no route to %s: %w", endpoint, err)
+	}
+
+	var via *netlink.Route
+	if len(routes) > 0 && routes[0].LinkIndex != tunnelIndex {
+		via = &routes[0]
+	} else {
+		family := netlink.FAMILY_V4
+		if endpoint.Is6() {
+			family = netlink.FAMILY_V6
+		}
+		defaults, err := netlink.RouteListFiltered(family, &netlink.Route{Table: unix.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes: %w", err)
+		}
+		for i, route := range defaults {
+			isDefault := route.Dst == nil || route.Dst.IP.IsUnspecified() && isZeroMask(route.Dst.Mask)
+			if !isDefault || route.LinkIndex == tunnelIndex || route.Gw == nil {
+				continue
+			}
+			if via == nil || route.Priority < via.Priority {
+				via = &defaults[i]
+			}
+		}
+		if via == nil {
+			return nil, fmt.Errorf("no route to %s outside the tunnel", endpoint)
+		}
+	}
+
+	if via.Gw == nil {
+		return nil, nil
+	}
+	return &netlink.Route{
+		Dst:       &net.IPNet{IP: ip, Mask: net.CIDRMask(endpoint.BitLen(), endpoint.BitLen())},
+		Gw:        via.Gw,
+		LinkIndex: via.LinkIndex,
+		Table:     unix.RT_TABLE_MAIN,
+	}, nil
+}
+
+// isZeroMask reports whether mask has no bits set
+func isZeroMask(mask net.IPMask) bool {
+	ones, _ := mask.Size()
+	return ones == 0
+}
+
+// masqueradeRule matches traffic from the tunnel leaving through the uplink: iifname dev oifname uplink masquerade
+func masqueradeRule(config GatewayConfig) []expr.Any {
+	exprs := matchMeta(expr.MetaKeyIIFNAME, expr.CmpOpEq, ifname(config.Device))
+	if config.Uplink != "" {
+		exprs = append(exprs, matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpEq, ifname(config.Uplink))...)
+	} else {
+		exprs = append(exprs, matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpNeq, ifname(config.Device))...)
+	}
+	return append(exprs, &expr.Masq{})
+}
+
+// connectedPrefixes returns the prefixes of the addresses on interfaces other than device and loopback
+// They are read when the rules are applied; an address the exit gets later is only
+// covered by LANPrefixes.
+func connectedPrefixes(device string) ([]netip.Prefix, error) {
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %w", err)
+	}
+	tunnel, _ := netlink.LinkByName(device)
+
+	var prefixes []netip.Prefix
+	for _, addr := range addrs {
+		if tunnel != nil && addr.LinkIndex == tunnel.Attrs().Index || addr.IP.IsLoopback() {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			continue
+		}
+		ones, _ := addr.Mask.Size()
+		prefix := netip.PrefixFrom(ip.Unmap(), ones).Masked()
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// lanRules keep traffic from the tunnel out of the exit's local networks
+// Traffic to the gateway's subnets and back into the tunnel is accepted first; what is
+// bound for LANPrefixes or a connected prefix is dropped. The rest, the internet, is
+// left to the masquerade chain.
+func lanRules(config GatewayConfig, connected []netip.Prefix) [][]expr.Any {
+	fromTunnel := matchMeta(expr.MetaKeyIIFNAME, expr.CmpOpEq, ifname(config.Device))
+	rules := [][]expr.Any{
+		append(append(slices.Clone(fromTunnel), matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpEq, ifname(config.Device))...), &expr.Verdict{Kind: expr.VerdictAccept}),
+	}
+
+	add := func(prefix netip.Prefix, kind expr.VerdictKind) {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked()
+		family := byte(unix.NFPROTO_IPV6)
+		if prefix.Addr().Is4() {
+			family = unix.NFPROTO_IPV4
+		}
+		rule := append(slices.Clone(fromTunnel), matchMeta(expr.MetaKeyNFPROTO, expr.CmpOpEq, []byte{family})...)
+		rule = append(rule, matchDestination(family, prefix.Addr().AsSlice(), net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()), expr.CmpOpEq)...)
+		rules = append(rules, append(rule, &expr.Verdict{Kind: kind}))
+	}
+	for _, subnet := range config.Subnets {
+		add(subnet, expr.VerdictAccept)
+	}
+	for _, prefix := range append(slices.Clone(LANPrefixes), connected...) {
+		add(prefix, expr.VerdictDrop)
+	}
+	return rules
+}
+
+// dnsRules send DNS queries to the first IPv4 and IPv6 server in dns
+// Queries to loopback addresses (a local stub resolver) are left alone; the stub's own
+// upstream queries are redirected.
+func dnsRules(dns []netip.Addr) [][]expr.Any {
+	var rules [][]expr.Any
+	redirected := make(map[bool]bool)
+	for _, server := range dns {
+		v4 := server.Is4() || server.Is4In6()
+		if redirected[v4] {
+			continue
+		}
+		redirected[v4] = true
+		server = server.Unmap()
+
+		family := byte(unix.NFPROTO_IPV6)
+		loopback := netip.IPv6Loopback().AsSlice()
+		mask := net.CIDRMask(128, 128)
+		if v4 {
+			family = unix.NFPROTO_IPV4
+			loopback = []byte{127, 0, 0, 0}
+			mask = net.CIDRMask(8, 32)
+		}
+
+		for _, proto := range []byte{unix.IPPROTO_UDP, unix.IPPROTO_TCP} {
+			rule := matchMeta(expr.MetaKeyNFPROTO, expr.CmpOpEq, []byte{family})
+			rule = append(rule, matchMeta(expr.MetaKeyL4PROTO, expr.CmpOpEq, []byte{proto})...)
+			rule = append(rule, matchPort(2, 53)...)
+			rule = append(rule, matchDestination(family, loopback, mask, expr.CmpOpNeq)...)
+			rule = append(rule,
+				&expr.Immediate{Register: 1, Data: server.AsSlice()},
+				&expr.NAT{Type: expr.NATTypeDestNAT, Family: uint32(family), RegAddrMin: 1},
+			)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// socketOwner identifies the daemon's own sockets for the kill switch
+type socketOwner struct {
+	uid    uint32
+	level  uint32 // Depth of the daemon's cgroup v2 (0: unknown or the root cgroup, not matched)
+	cgroup uint64 // ID of that cgroup, the inode of its directory
+}
+
+// currentSocketOwner returns the uid and cgroup v2 of this process
+// Under a service manager the cgroup holds the daemon alone; a daemon started from a
+// shell shares its session's cgroup, and the uid is the only other distinction.
+func currentSocketOwner() socketOwner {
+	owner := socketOwner{uid: uint32(os.Getuid())}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return owner
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		path, ok := strings.CutPrefix(line, "0::") // The cgroup v2 hierarchy
+		if !ok || path == "/" {
+			continue
+		}
+		var stat unix.Stat_t
+		if err := unix.Stat("/sys/fs/cgroup"+path, &stat); err == nil {
+			owner.level = uint32(strings.Count(strings.TrimSuffix(path, "/"), "/"))
+			owner.cgroup = stat.Ino
+		}
+	}
+	return owner
+}
+
+// killSwitchRules accept what may leave outside the tunnel; the chain drops the rest
+// Allowed are loopback, the tunnel, the endpoints, the P2P listener's replies, DHCP and
+// IPv6 link-local and link-scope multicast traffic (neighbour discovery). Listener
+// replies go to peers that are not known in advance, so they are matched by the socket:
+// the source port alone would let out any process bound to it, so the socket must also
+// belong to the daemon's uid and, where known, its cgroup.
+func killSwitchRules(config ClientConfig, owner socketOwner) [][]expr.Any {
+	accept := &expr.Verdict{Kind: expr.VerdictAccept}
+	rules := [][]expr.Any{
+		append(matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpEq, ifname("lo")), accept),
+		append(matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpEq, ifname(config.Device)), accept),
+	}
+
+	for _, endpoint := range config.Endpoints {
+		endpoint = endpoint.Unmap()
+		family := byte(unix.NFPROTO_IPV6)
+		if endpoint.Is4() {
+			family = unix.NFPROTO_IPV4
+		}
+		rule := matchMeta(expr.MetaKeyNFPROTO, expr.CmpOpEq, []byte{family})
+		rule = append(rule, matchDestination(family, endpoint.AsSlice(), net.CIDRMask(endpoint.BitLen(), endpoint.BitLen()), expr.CmpOpEq)...)
+		rules = append(rules, append(rule, accept))
+	}
+
+	if config.ListenPort != 0 {
+		for _, proto := range []byte{unix.IPPROTO_UDP, unix.IPPROTO_TCP} {
+			rule := matchMeta(expr.MetaKeyL4PROTO, expr.CmpOpEq, []byte{proto})
+			rule = append(rule, matchPort(0, uint16(config.ListenPort))...)
+			rule = append(rule, matchMeta(expr.MetaKeySKUID, expr.CmpOpEq, binary.NativeEndian.AppendUint32(nil, owner.uid))...)
+			if owner.level > 0 {
+				rule = append(rule,
+					&expr.Socket{Key: expr.SocketKeyCgroupv2, Level: owner.level, Register: 1},
+					&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binary.NativeEndian.AppendUint64(nil, owner.cgroup)},
+				)
+			}
+			rules = append(rules, append(rule, accept))
+		}
+	}
+
+	// DHCP and DHCPv6 keep the underlay address
+	for _, port := range []uint16{67, 547} {
+		rule := matchMeta(expr.MetaKeyL4PROTO, expr.CmpOpEq, []byte{unix.IPPROTO_UDP})
+		rule = append(rule, matchPort(2, port)...)
+		rules = append(rules, append(rule, accept))
+	}
+
+	for _, prefix := range []netip.Prefix{netip.MustParsePrefix("fe80::/10"), netip.MustParsePrefix("ff02::/16")} {
+		rule := matchMeta(expr.MetaKeyNFPROTO, expr.CmpOpEq, []byte{unix.NFPROTO_IPV6})
+		rule = append(rule, matchDestination(unix.NFPROTO_IPV6, prefix.Addr().AsSlice(), net.CIDRMask(prefix.Bits(), 128), expr.CmpOpEq)...)
+		rules = append(rules, append(rule, accept))
+	}
+	return rules
+}
+
+// forwardKillSwitchRules accept forwarded traffic to and from the tunnel; the chain drops the rest
+// Without them, traffic the host routes for others (containers, virtual machines, a
+// shared connection) would leave through the underlay.
+func forwardKillSwitchRules(config ClientConfig) [][]expr.Any {
+	accept := &expr.Verdict{Kind: expr.VerdictAccept}
+	return [][]expr.Any{
+		append(matchMeta(expr.MetaKeyOIFNAME, expr.CmpOpEq, ifname(config.Device)), accept),
+		append(matchMeta(expr.MetaKeyIIFNAME, expr.CmpOpEq, ifname(config.Device)), accept),
+	}
+}
+
+// matchMeta compares a packet's meta key with data
+func matchMeta(key expr.MetaKey, op expr.CmpOp, data []byte) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: op, Register: 1, Data: data},
+	}
+}
+
+// matchPort matches the transport port at offset (0: source, 2: destination); the protocol must be matched first
+func matchPort(offset uint32, port uint16) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: offset, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binary.BigEndian.AppendUint16(nil, port)},
+	}
+}
+
+// matchDestination compares the destination address under mask with addr; the family must be matched first
+func matchDestination(family byte, addr []byte, mask net.IPMask, op expr.CmpOp) []expr.Any {
+	offset, length := uint32(16), uint32(4) // IPv4 header
+	if family == unix.NFPROTO_IPV6 {
+		offset, length = 24, 16
+	}
+	exprs := []expr.Any{&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: length}}
+	if ones, _ := mask.Size(); ones != len(mask)*8 {
+		exprs = append(exprs, &expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: length, Mask: mask, Xor: make([]byte, length)})
+	}
+	return append(exprs, &expr.Cmp{Op: op, Register: 1, Data: addr})
+}
+
+// ifname returns an interface name as nftables compares it: zero-padded to IFNAMSIZ
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
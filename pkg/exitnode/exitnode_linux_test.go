@@ -0,0 +1,172 @@
+package exitnode
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/google/nftables/expr"
+)
+
+// TestDNSRules tests that queries are redirected to the first server of each family
+func TestDNSRules(t *testing.T) {
+	dns := []netip.Addr{
+		netip.MustParseAddr("10.77.0.1"),
+		netip.MustParseAddr("10.77.0.2"),
+		netip.MustParseAddr("fd00:5d::1"),
+	}
+
+	rules := dnsRules(dns)
+	if len(rules) != 4 {
+		t.Fatalf("got %d rules, want UDP and TCP for each family", len(rules))
+	}
+
+	var targets []netip.Addr
+	for _, rule := range rules {
+		if nat, ok := rule[len(rule)-1].(*expr.NAT); !ok || nat.Type != expr.NATTypeDestNAT {
+			t.Fatalf("rule ends with %T, want a destination NAT", rule[len(rule)-1])
+		}
+		immediate := rule[len(rule)-2].(*expr.Immediate)
+		addr, _ := netip.AddrFromSlice(immediate.Data)
+		targets = append(targets, addr)
+	}
+
+	want := []netip.Addr{dns[0], dns[0], dns[2], dns[2]}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("rule %d redirects to %s, want %s", i, targets[i], want[i])
+		}
+	}
+}
+
+// TestKillSwitchRules tests that the kill switch lets out each endpoint and the listener's traffic
+func TestKillSwitchRules(t *testing.T) {
+	config := ClientConfig{
+		Device:     "shadowmesh0",
+		Endpoints:  []netip.Addr{netip.MustParseAddr("203.0.113.7"), netip.MustParseAddr("2001:db8::7")},
+		ListenPort: 9545,
+		KillSwitch: true,
+	}
+	owner := socketOwner{uid: 0, level: 2, cgroup: 4242}
+
+	// lo, the device, 2 endpoints, UDP and TCP from the listener, DHCP and DHCPv6, 2 IPv6 link-scope prefixes
+	rules := killSwitchRules(config, owner)
+	if len(rules) != 10 {
+		t.Fatalf("got %d rules, want 10", len(rules))
+	}
+	for i, rule := range rules {
+		if verdict, ok := rule[len(rule)-1].(*expr.Verdict); !ok || verdict.Kind != expr.VerdictAccept {
+			t.Errorf("rule %d does not accept", i)
+		}
+	}
+
+	endpoint := rules[2][len(rules[2])-2].(*expr.Cmp)
+	if addr, _ := netip.AddrFromSlice(endpoint.Data); addr != config.Endpoints[0] {
+		t.Errorf("endpoint rule matches %s, want %s", addr, config.Endpoints[0])
+	}
+
+	// The listener's port alone does not let a packet out: the socket must be the daemon's
+	for _, rule := range rules[4:6] {
+		var uid, cgroup bool
+		for i, e := range rule {
+			if meta, ok := e.(*expr.Meta); ok && meta.Key == expr.MetaKeySKUID {
+				uid = slices.Equal(rule[i+1].(*expr.Cmp).Data, binary.NativeEndian.AppendUint32(nil, owner.uid))
+			}
+			if socket, ok := e.(*expr.Socket); ok && socket.Key == expr.SocketKeyCgroupv2 && socket.Level == owner.level {
+				cgroup = slices.Equal(rule[i+1].(*expr.Cmp).Data, binary.NativeEndian.AppendUint64(nil, owner.cgroup))
+			}
+		}
+		if !uid || !cgroup {
+			t.Errorf("listener rule matches socket uid %v, cgroup %v, want both", uid, cgroup)
+		}
+	}
+
+	// Without a known cgroup, the uid still has to match
+	rules = killSwitchRules(config, socketOwner{uid: 1000})
+	for _, e := range rules[4] {
+		if _, ok := e.(*expr.Socket); ok {
+			t.Errorf("listener rule matches a cgroup, want none at level 0")
+		}
+	}
+}
+
+// TestForwardKillSwitchRules tests that forwarded traffic only passes to and from the tunnel
+func TestForwardKillSwitchRules(t *testing.T) {
+	rules := forwardKillSwitchRules(ClientConfig{Device: "shadowmesh0", KillSwitch: true})
+
+	want := []expr.MetaKey{expr.MetaKeyOIFNAME, expr.MetaKeyIIFNAME}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i, rule := range rules {
+		if meta := rule[0].(*expr.Meta); meta.Key != want[i] {
+			t.Errorf("rule %d matches meta key %v, want %v", i, meta.Key, want[i])
+		}
+		if cmp := rule[1].(*expr.Cmp); !slices.Equal(cmp.Data, ifname("shadowmesh0")) {
+			t.Errorf("rule %d matches interface %q, want shadowmesh0", i, cmp.Data)
+		}
+	}
+}
+
+// TestLANRules tests that the exit drops traffic from the tunnel to its local networks, except routed subnets
+func TestLANRules(t *testing.T) {
+	config := GatewayConfig{Device: "shadowmesh0", Subnets: []netip.Prefix{netip.MustParsePrefix("192.168.50.0/24")}}
+	connected := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("2001:db8:1::/64")}
+
+	rules := lanRules(config, connected)
+	if len(rules) != 1+len(config.Subnets)+len(LANPrefixes)+len(connected) {
+		t.Fatalf("got %d rules, want tunnel to tunnel, each subnet, LAN and connected prefix", len(rules))
+	}
+
+	// destination returns the prefix a rule matches and its verdict
+	destination := func(rule []expr.Any) (netip.Prefix, expr.VerdictKind) {
+		bits := -1
+		var addr netip.Addr
+		for i, e := range rule {
+			if payload, ok := e.(*expr.Payload); !ok || payload.Base != expr.PayloadBaseNetworkHeader {
+				continue
+			}
+			cmp := rule[len(rule)-2].(*expr.Cmp)
+			addr, _ = netip.AddrFromSlice(cmp.Data)
+			bits = addr.BitLen()
+			if bitwise, ok := rule[i+1].(*expr.Bitwise); ok {
+				bits, _ = net.IPMask(bitwise.Mask).Size()
+			}
+		}
+		return netip.PrefixFrom(addr, bits), rule[len(rule)-1].(*expr.Verdict).Kind
+	}
+
+	if _, kind := destination(rules[0]); kind != expr.VerdictAccept {
+		t.Errorf("tunnel to tunnel rule does not accept")
+	}
+	tests := []struct {
+		dst  string
+		want expr.VerdictKind
+	}{
+		{"192.168.50.7", expr.VerdictAccept},
+		{"192.168.1.1", expr.VerdictDrop},
+		{"10.1.2.3", expr.VerdictDrop},
+		{"172.20.0.1", expr.VerdictDrop},
+		{"169.254.169.254", expr.VerdictDrop},
+		{"fd12::1", expr.VerdictDrop},
+		{"198.51.100.9", expr.VerdictDrop},
+		{"2001:db8:1::9", expr.VerdictDrop},
+		{"8.8.8.8", -1},
+		{"2606:4700::1111", -1},
+	}
+	for _, tt := range tests {
+		dst := netip.MustParseAddr(tt.dst)
+		got := expr.VerdictKind(-1) // Falls through to the masquerade chain
+		for _, rule := range rules[1:] {
+			if prefix, kind := destination(rule); prefix.Contains(dst) {
+				got = kind
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("verdict for %s = %v, want %v", dst, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package exitnode
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+)
+
+// systemController is not implemented off Linux
+type systemController struct{}
+
+var errUnsupported = fmt.Errorf("exit node mode is not supported on %s", runtime.GOOS)
+
+func newSystemController() Controller { return systemController{} }
+
+func (systemController) StartGateway(GatewayConfig) error { return errUnsupported }
+func (systemController) StartClient(ClientConfig) error   { return errUnsupported }
+func (systemController) Activate([]netip.Addr) error      { return errUnsupported }
+func (systemController) Deactivate() error                { return nil }
+func (systemController) Stop() error                      { return nil }
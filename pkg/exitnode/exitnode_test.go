@@ -0,0 +1,29 @@
+package exitnode
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestDefaultRoutes tests that the default routes cover each family exactly once, without a /0
+func TestDefaultRoutes(t *testing.T) {
+	addrs := []string{"0.0.0.0", "127.255.255.255", "128.0.0.0", "255.255.255.255", "::", "7fff::1", "8000::", "ffff::ffff"}
+	for _, s := range addrs {
+		addr := netip.MustParseAddr(s)
+		matches := 0
+		for _, prefix := range DefaultRoutes {
+			if prefix.Contains(addr) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			t.Errorf("%s is covered by %d default routes, want 1", addr, matches)
+		}
+	}
+
+	for _, prefix := range DefaultRoutes {
+		if prefix.Bits() == 0 {
+			t.Errorf("default route %s would replace the underlay default route", prefix)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+// Package fec implements Reed-Solomon forward error correction for the UDP transport.
+//
+// Outgoing datagrams are grouped N at a time; after the Nth datagram K parity datagrams
+// are sent, and any N of the N+K datagrams in a group are enough to rebuild the rest.
+// Data datagrams are forwarded on arrival, so FEC only adds latency for the frames that
+// had to be recovered.
+//
+// Shard datagram format:
+//
+//	[1 byte Magic][4 bytes group ID][1 byte index][1 byte data shards][1 byte parity shards][shard]
+//
+// A shard is [2 bytes payload length][payload], zero-padded to the group's shard size
+// for parity computation. Data datagrams are sent unpadded and carry 0 for the shard
+// counts, which are only known once the group closes; parity datagrams carry both.
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	// Magic is the first byte of every FEC datagram
+	// Encrypted frames start with their version byte (1), so the two never collide.
+	Magic = 0xFE
+	// HeaderSize is the size of the FEC datagram header
+	HeaderSize = 8
+	// Overhead is the number of bytes FEC adds to each datagram (header + length prefix)
+	Overhead = HeaderSize + lengthPrefixSize
+
+	// MaxDataShards is the largest supported group size
+	MaxDataShards = 32
+	// MaxParityShards is the largest supported parity count
+	MaxParityShards = 32
+
+	// DefaultFlushInterval closes a partially filled group so its parity is not held back
+	DefaultFlushInterval = 20 * time.Millisecond
+	// groupTimeout is how long the decoder waits for the shards of a group
+	groupTimeout = 2 * time.Second
+	// maxPendingGroups bounds decoder memory
+	maxPendingGroups = 256
+
+	lengthPrefixSize = 2
+)
+
+var (
+	// ErrInvalidShardCount indicates an unsupported data/parity shard configuration
+	ErrInvalidShardCount = errors.New("invalid FEC shard count")
+	// ErrPayloadTooLarge indicates a payload that does not fit the 16-bit length prefix
+	ErrPayloadTooLarge = errors.New("payload too large for FEC")
+	// ErrInvalidShard indicates a malformed FEC datagram
+	ErrInvalidShard = errors.New("invalid FEC shard")
+)
+
+// IsShard reports whether a received datagram is FEC-encoded
+func IsShard(datagram []byte) bool {
+	return len(datagram) >= HeaderSize && datagram[0] == Magic
+}
+
+// ParityForLoss returns the parity shard count that covers twice the measured loss rate
+// The result is clamped to [minParity, maxParity].
+func ParityForLoss(dataShards int, loss float64, minParity, maxParity int) int {
+	parity := maxParity
+	if loss <= 0 {
+		parity = minParity
+	} else if loss < 0.5 {
+		// Lost shards ≈ loss × (N + K); require K ≥ 2 × that
+		margin := 2 * loss
+		parity = int(math.Ceil(margin * float64(dataShards) / (1 - margin)))
+	}
+
+	if parity < minParity {
+		parity = minParity
+	}
+	if parity > maxParity {
+		parity = maxParity
+	}
+	return parity
+}
+
+// codecCache holds one Reed-Solomon codec per (data, parity) combination
+type codecCache map[[2]int]reedsolomon.Encoder
+
+func (c codecCache) get(dataShards, parityShards int) (reedsolomon.Encoder, error) {
+	key := [2]int{dataShards, parityShards}
+	if codec, ok := c[key]; ok {
+		return codec, nil
+	}
+
+	codec, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Reed-Solomon codec (%d+%d): %w", dataShards, parityShards, err)
+	}
+	c[key] = codec
+	return codec, nil
+}
+
+func validateShardCounts(dataShards, parityShards int) error {
+	if dataShards < 1 || dataShards > MaxDataShards {
+		return fmt.Errorf("%w: data shards must be between 1 and %d, got %d", ErrInvalidShardCount, MaxDataShards, dataShards)
+	}
+	if parityShards < 0 || parityShards > MaxParityShards {
+		return fmt.Errorf("%w: parity shards must be between 0 and %d, got %d", ErrInvalidShardCount, MaxParityShards, parityShards)
+	}
+	return nil
+}
+
+func putHeader(datagram []byte, groupID uint32, index, dataShards, parityShards int) {
+	datagram[0] = Magic
+	binary.BigEndian.PutUint32(datagram[1:5], groupID)
+	datagram[5] = byte(index)
+	datagram[6] = byte(dataShards)
+	datagram[7] = byte(parityShards)
+}
+
+// Encoder groups outgoing datagrams and produces parity
+//
+// Thread-safe: SetParityShards may be called while another goroutine encodes.
+type Encoder struct {
+	mu            sync.Mutex
+	dataShards    int
+	parityShards  int
+	flushInterval time.Duration
+	codecs        codecCache
+
+	groupID      uint32
+	group        [][]byte // Length-prefixed shards of the open group
+	groupParity  int      // Parity count fixed when the group opened
+	groupStarted time.Time
+}
+
+// NewEncoder creates an encoder for groups of dataShards datagrams
+func NewEncoder(dataShards, parityShards int) (*Encoder, error) {
+	if err := validateShardCounts(dataShards, parityShards); err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		dataShards:    dataShards,
+		parityShards:  parityShards,
+		flushInterval: DefaultFlushInterval,
+		codecs:        make(codecCache),
+	}, nil
+}
+
+// SetParityShards changes the parity count; the open group keeps its original count
+func (e *Encoder) SetParityShards(parityShards int) error {
+	if err := validateShardCounts(e.dataShards, parityShards); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.parityShards = parityShards
+	e.mu.Unlock()
+	return nil
+}
+
+// ParityShards returns the parity count used for new groups
+func (e *Encoder) ParityShards() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.parityShards
+}
+
+// DataShards returns the group size
+func (e *Encoder) DataShards() int {
+	return e.dataShards
+}
+
+// Encode wraps payload as the next data shard and returns the datagrams to send
+// The result is the data datagram, followed by the group's parity datagrams if this
+// payload completed the group. With zero parity the payload is returned unchanged.
+func (e *Encoder) Encode(payload []byte, now time.Time) ([][]byte, error) {
+	if len(payload) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(payload))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.group) == 0 {
+		if e.parityShards == 0 {
+			return [][]byte{payload}, nil
+		}
+		e.groupID++
+		e.groupParity = e.parityShards
+		e.groupStarted = now
+	}
+
+	index := len(e.group)
+	shard := make([]byte, lengthPrefixSize+len(payload))
+	binary.BigEndian.PutUint16(shard, uint16(len(payload)))
+	copy(shard[lengthPrefixSize:], payload)
+	e.group = append(e.group, shard)
+
+	datagram := make([]byte, HeaderSize+len(shard))
+	putHeader(datagram, e.groupID, index, 0, 0)
+	copy(datagram[HeaderSize:], shard)
+
+	out := [][]byte{datagram}
+	if len(e.group) == e.dataShards {
+		parity, err := e.closeGroup()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, parity...)
+	}
+	return out, nil
+}
+
+// Flush closes a partially filled group that has been open longer than the flush interval
+// Call periodically so parity for a quiet link is not delayed indefinitely.
+func (e *Encoder) Flush(now time.Time) ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.group) == 0 || now.Sub(e.groupStarted) < e.flushInterval {
+		return nil, nil
+	}
+	return e.closeGroup()
+}
+
+// closeGroup computes parity for the open group and resets it (caller holds mu)
+func (e *Encoder) closeGroup() ([][]byte, error) {
+	group := e.group
+	e.group = nil
+
+	dataShards := len(group)
+	codec, err := e.codecs.get(dataShards, e.groupParity)
+	if err != nil {
+		return nil, err
+	}
+
+	shardSize := 0
+	for _, shard := range group {
+		if len(shard) > shardSize {
+			shardSize = len(shard)
+		}
+	}
+
+	shards := make([][]byte, dataShards+e.groupParity)
+	for i, shard := range group {
+		padded := make([]byte, shardSize)
+		copy(padded, shard)
+		shards[i] = padded
+	}
+	for i := dataShards; i < len(shards); i++ {
+		shards[i] = make([]byte, shardSize)
+	}
+
+	if err := codec.Encode(shards); err != nil {
+		return nil, fmt.Errorf("failed to compute parity: %w", err)
+	}
+
+	out := make([][]byte, 0, e.groupParity)
+	for i := dataShards; i < len(shards); i++ {
+		datagram := make([]byte, HeaderSize+shardSize)
+		putHeader(datagram, e.groupID, i, dataShards, e.groupParity)
+		copy(datagram[HeaderSize:], shards[i])
+		out = append(out, datagram)
+	}
+	return out, nil
+}
+
+// decodeGroup collects the shards of one group on the receiving side
+type decodeGroup struct {
+	dataShards   int // 0 until a parity shard arrives
+	parityShards int
+	shards       map[int][]byte
+	done         bool // All data shards delivered (received or recovered)
+	expires      time.Time
+}
+
+// Decoder forwards data shards and rebuilds lost ones from parity
+//
+// Not safe for concurrent use; owned by the transport receive loop. Counters may be
+// read concurrently.
+type Decoder struct {
+	groups map[uint32]*decodeGroup
+	codecs codecCache
+
+	recovered uint64 // Datagrams rebuilt from parity
+	lost      uint64 // Groups that expired with data still missing
+}
+
+// NewDecoder creates a decoder
+func NewDecoder() *Decoder {
+	return &Decoder{
+		groups: make(map[uint32]*decodeGroup),
+		codecs: make(codecCache),
+	}
+}
+
+// Decode processes one FEC datagram and returns the payloads it makes available
+// A data shard yields its own payload; a parity shard may yield recovered payloads.
+func (d *Decoder) Decode(datagram []byte, now time.Time) ([][]byte, error) {
+	if !IsShard(datagram) {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidShard)
+	}
+
+	groupID := binary.BigEndian.Uint32(datagram[1:5])
+	index := int(datagram[5])
+	dataShards := int(datagram[6])
+	parityShards := int(datagram[7])
+	shard := datagram[HeaderSize:]
+
+	if len(shard) < lengthPrefixSize {
+		return nil, fmt.Errorf("%w: shard too short", ErrInvalidShard)
+	}
+
+	isParity := dataShards != 0
+	if isParity {
+		if err := validateShardCounts(dataShards, parityShards); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidShard, err)
+		}
+		if parityShards == 0 || index < dataShards || index >= dataShards+parityShards {
+			return nil, fmt.Errorf("%w: parity index %d out of range", ErrInvalidShard, index)
+		}
+	} else if index >= MaxDataShards {
+		return nil, fmt.Errorf("%w: data index %d out of range", ErrInvalidShard, index)
+	}
+
+	d.expire(now)
+
+	group, ok := d.groups[groupID]
+	if !ok {
+		if len(d.groups) >= maxPendingGroups {
+			d.evictOldest()
+		}
+		group = &decodeGroup{
+			shards:  make(map[int][]byte),
+			expires: now.Add(groupTimeout),
+		}
+		d.groups[groupID] = group
+	}
+
+	if group.done {
+		return nil, nil
+	}
+	if _, duplicate := group.shards[index]; duplicate {
+		return nil, nil
+	}
+
+	if isParity {
+		if group.dataShards != 0 && (group.dataShards != dataShards || group.parityShards != parityShards) {
+			return nil, fmt.Errorf("%w: inconsistent shard counts in group %d", ErrInvalidShard, groupID)
+		}
+		group.dataShards = dataShards
+		group.parityShards = parityShards
+	} else if group.dataShards != 0 && index >= group.dataShards {
+		return nil, fmt.Errorf("%w: data index %d beyond group size %d", ErrInvalidShard, index, group.dataShards)
+	}
+
+	stored := make([]byte, len(shard))
+	copy(stored, shard)
+	group.shards[index] = stored
+
+	var out [][]byte
+	if !isParity {
+		payload, err := unwrapShard(stored)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, payload)
+	}
+
+	recovered, err := d.tryRecover(group)
+	if err != nil {
+		return out, err
+	}
+	return append(out, recovered...), nil
+}
+
+// tryRecover rebuilds missing data shards once enough shards of a group have arrived
+func (d *Decoder) tryRecover(group *decodeGroup) ([][]byte, error) {
+	if group.dataShards == 0 {
+		return nil, nil
+	}
+
+	missing := 0
+	for i := 0; i < group.dataShards; i++ {
+		if _, ok := group.shards[i]; !ok {
+			missing++
+		}
+	}
+	if missing == 0 {
+		group.done = true
+		group.shards = nil
+		return nil, nil
+	}
+	if len(group.shards) < group.dataShards {
+		return nil, nil
+	}
+
+	// Parity shards define the shard size; data shards were sent unpadded
+	shardSize := 0
+	for i := group.dataShards; i < group.dataShards+group.parityShards; i++ {
+		if shard, ok := group.shards[i]; ok {
+			shardSize = len(shard)
+			break
+		}
+	}
+
+	shards := make([][]byte, group.dataShards+group.parityShards)
+	for i, shard := range group.shards {
+		if len(shard) > shardSize {
+			group.done = true
+			group.shards = nil
+			return nil, fmt.Errorf("%w: shard %d larger than parity", ErrInvalidShard, i)
+		}
+		padded := make([]byte, shardSize)
+		copy(padded, shard)
+		shards[i] = padded
+	}
+
+	codec, err := d.codecs.get(group.dataShards, group.parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := codec.ReconstructData(shards); err != nil {
+		group.done = true
+		group.shards = nil
+		return nil, fmt.Errorf("%w: reconstruction failed: %v", ErrInvalidShard, err)
+	}
+
+	var out [][]byte
+	for i := 0; i < group.dataShards; i++ {
+		if _, ok := group.shards[i]; ok {
+			continue
+		}
+		payload, err := unwrapShard(shards[i])
+		if err != nil {
+			continue
+		}
+		out = append(out, payload)
+		atomic.AddUint64(&d.recovered, 1)
+	}
+
+	group.done = true
+	group.shards = nil
+	return out, nil
+}
+
+// unwrapShard strips the length prefix and padding from a shard
+func unwrapShard(shard []byte) ([]byte, error) {
+	length := int(binary.BigEndian.Uint16(shard[:lengthPrefixSize]))
+	if lengthPrefixSize+length > len(shard) {
+		return nil, fmt.Errorf("%w: length %d exceeds shard", ErrInvalidShard, length)
+	}
+	return shard[lengthPrefixSize : lengthPrefixSize+length], nil
+}
+
+// expire drops groups that stopped receiving shards
+func (d *Decoder) expire(now time.Time) {
+	for id, group := range d.groups {
+		if now.After(group.expires) {
+			d.drop(id, group)
+		}
+	}
+}
+
+// evictOldest drops the group closest to expiry
+func (d *Decoder) evictOldest() {
+	var oldestID uint32
+	var oldest *decodeGroup
+	for id, group := range d.groups {
+		if oldest == nil || group.expires.Before(oldest.expires) {
+			oldestID = id
+			oldest = group
+		}
+	}
+	if oldest != nil {
+		d.drop(oldestID, oldest)
+	}
+}
+
+func (d *Decoder) drop(id uint32, group *decodeGroup) {
+	if !group.done && group.dataShards != 0 {
+		atomic.AddUint64(&d.lost, 1)
+	}
+	delete(d.groups, id)
+}
+
+// Recovered returns the number of datagrams rebuilt from parity
+func (d *Decoder) Recovered() uint64 {
+	return atomic.LoadUint64(&d.recovered)
+}
+
+// Unrecoverable returns the number of groups that expired with data still missing
+func (d *Decoder) Unrecoverable() uint64 {
+	return atomic.LoadUint64(&d.lost)
+}
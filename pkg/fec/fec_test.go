@@ -0,0 +1,249 @@
+package fec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// makePayloads returns n random payloads of varying length
+func makePayloads(n int) [][]byte {
+	payloads := make([][]byte, n)
+	for i := range payloads {
+		payloads[i] = make([]byte, 100+i*37)
+		rand.Read(payloads[i])
+	}
+	return payloads
+}
+
+// encodeAll encodes payloads and returns every datagram produced
+func encodeAll(t *testing.T, enc *Encoder, payloads [][]byte, now time.Time) [][]byte {
+	t.Helper()
+	var datagrams [][]byte
+	for _, payload := range payloads {
+		out, err := enc.Encode(payload, now)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		datagrams = append(datagrams, out...)
+	}
+	return datagrams
+}
+
+// containsPayload reports whether want is among got
+func containsPayload(got [][]byte, want []byte) bool {
+	for _, payload := range got {
+		if bytes.Equal(payload, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestEncodeProducesParity tests that a full group emits N data and K parity datagrams
+func TestEncodeProducesParity(t *testing.T) {
+	enc, err := NewEncoder(4, 2)
+	if err != nil {
+		t.Fatalf("NewEncoder failed: %v", err)
+	}
+
+	datagrams := encodeAll(t, enc, makePayloads(4), time.Now())
+	if len(datagrams) != 6 {
+		t.Fatalf("Expected 6 datagrams, got %d", len(datagrams))
+	}
+
+	for i, datagram := range datagrams {
+		if !IsShard(datagram) {
+			t.Errorf("Datagram %d is not an FEC shard", i)
+		}
+		isParity := datagram[6] != 0
+		if isParity != (i >= 4) {
+			t.Errorf("Datagram %d parity = %v", i, isParity)
+		}
+	}
+}
+
+// TestRecoverLostDatagrams tests recovery of up to K lost datagrams per group
+func TestRecoverLostDatagrams(t *testing.T) {
+	for _, lost := range [][]int{{0}, {3}, {1, 2}, {0, 5}, {4, 5}} {
+		enc, _ := NewEncoder(4, 2)
+		dec := NewDecoder()
+		payloads := makePayloads(4)
+		datagrams := encodeAll(t, enc, payloads, time.Now())
+
+		drop := make(map[int]bool)
+		for _, i := range lost {
+			drop[i] = true
+		}
+
+		var received [][]byte
+		for i, datagram := range datagrams {
+			if drop[i] {
+				continue
+			}
+			out, err := dec.Decode(datagram, time.Now())
+			if err != nil {
+				t.Fatalf("lost %v: Decode failed: %v", lost, err)
+			}
+			received = append(received, out...)
+		}
+
+		if len(received) != len(payloads) {
+			t.Errorf("lost %v: received %d payloads, expected %d", lost, len(received), len(payloads))
+		}
+		for i, payload := range payloads {
+			if !containsPayload(received, payload) {
+				t.Errorf("lost %v: payload %d missing", lost, i)
+			}
+		}
+	}
+}
+
+// TestTooManyLosses tests that a group losing more than K datagrams delivers what arrived
+func TestTooManyLosses(t *testing.T) {
+	enc, _ := NewEncoder(4, 1)
+	dec := NewDecoder()
+	datagrams := encodeAll(t, enc, makePayloads(4), time.Now())
+
+	delivered := 0
+	for i, datagram := range datagrams {
+		if i == 0 || i == 1 {
+			continue
+		}
+		out, _ := dec.Decode(datagram, time.Now())
+		delivered += len(out)
+	}
+
+	if delivered != 2 {
+		t.Errorf("Expected the 2 received payloads to be delivered, got %d", delivered)
+	}
+	if dec.Recovered() != 0 {
+		t.Errorf("Expected no recovery, got %d", dec.Recovered())
+	}
+}
+
+// TestDuplicatesIgnored tests that repeated and late datagrams are not delivered twice
+func TestDuplicatesIgnored(t *testing.T) {
+	enc, _ := NewEncoder(2, 1)
+	dec := NewDecoder()
+	datagrams := encodeAll(t, enc, makePayloads(2), time.Now())
+
+	// Lose the first data shard, recover it, then receive it late
+	dec.Decode(datagrams[1], time.Now())
+	out, _ := dec.Decode(datagrams[2], time.Now())
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 recovered payload, got %d", len(out))
+	}
+
+	for _, datagram := range [][]byte{datagrams[0], datagrams[1], datagrams[2]} {
+		if out, _ := dec.Decode(datagram, time.Now()); len(out) != 0 {
+			t.Error("Late or duplicate datagram delivered again")
+		}
+	}
+}
+
+// TestFlushPartialGroup tests that an idle partial group gets parity after the flush interval
+func TestFlushPartialGroup(t *testing.T) {
+	enc, _ := NewEncoder(8, 2)
+	dec := NewDecoder()
+	now := time.Now()
+	payloads := makePayloads(3)
+
+	datagrams := encodeAll(t, enc, payloads, now)
+	if parity, _ := enc.Flush(now); len(parity) != 0 {
+		t.Fatal("Group flushed before the flush interval")
+	}
+
+	parity, err := enc.Flush(now.Add(DefaultFlushInterval))
+	if err != nil || len(parity) != 2 {
+		t.Fatalf("Expected 2 parity datagrams, got %d (err %v)", len(parity), err)
+	}
+
+	// Lose the middle payload and recover it from the partial group's parity
+	var received [][]byte
+	for _, datagram := range append([][]byte{datagrams[0], datagrams[2]}, parity...) {
+		out, err := dec.Decode(datagram, now)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		received = append(received, out...)
+	}
+	if !containsPayload(received, payloads[1]) {
+		t.Error("Payload lost from partial group was not recovered")
+	}
+}
+
+// TestZeroParityPassThrough tests that FEC is bypassed when parity is zero
+func TestZeroParityPassThrough(t *testing.T) {
+	enc, _ := NewEncoder(4, 0)
+	payload := []byte{1, 2, 3}
+
+	out, err := enc.Encode(payload, time.Now())
+	if err != nil || len(out) != 1 || !bytes.Equal(out[0], payload) || IsShard(out[0]) {
+		t.Errorf("Expected payload unchanged, got %v (err %v)", out, err)
+	}
+}
+
+// TestSetParityShardsAppliesToNextGroup tests changing the parity count mid-stream
+func TestSetParityShardsAppliesToNextGroup(t *testing.T) {
+	enc, _ := NewEncoder(2, 1)
+	now := time.Now()
+
+	enc.Encode([]byte("a"), now)
+	if err := enc.SetParityShards(3); err != nil {
+		t.Fatalf("SetParityShards failed: %v", err)
+	}
+
+	out, _ := enc.Encode([]byte("b"), now)
+	if len(out) != 2 {
+		t.Errorf("Open group should keep 1 parity shard, got %d datagrams", len(out))
+	}
+
+	datagrams := encodeAll(t, enc, [][]byte{[]byte("c"), []byte("d")}, now)
+	if len(datagrams) != 5 {
+		t.Errorf("Next group should have 3 parity shards, got %d datagrams", len(datagrams))
+	}
+
+	if err := enc.SetParityShards(MaxParityShards + 1); !errors.Is(err, ErrInvalidShardCount) {
+		t.Errorf("Expected ErrInvalidShardCount, got %v", err)
+	}
+}
+
+// TestDecodeRejectsMalformed tests header validation
+func TestDecodeRejectsMalformed(t *testing.T) {
+	dec := NewDecoder()
+	for name, datagram := range map[string][]byte{
+		"no magic":        {1, 0, 0, 0, 1, 0, 0, 0, 0, 0},
+		"short shard":     {Magic, 0, 0, 0, 1, 0, 0, 0, 0},
+		"parity in data":  {Magic, 0, 0, 0, 1, 1, 4, 2, 0, 0},
+		"bad length":      {Magic, 0, 0, 0, 1, 0, 0, 0, 0xFF, 0xFF},
+		"too many shards": {Magic, 0, 0, 0, 1, 40, 33, 1, 0, 0},
+	} {
+		if _, err := dec.Decode(datagram, time.Now()); !errors.Is(err, ErrInvalidShard) {
+			t.Errorf("%s: expected ErrInvalidShard, got %v", name, err)
+		}
+	}
+}
+
+// TestParityForLoss tests parity adaptation to measured loss
+func TestParityForLoss(t *testing.T) {
+	tests := []struct {
+		loss     float64
+		expected int
+	}{
+		{0, 1},
+		{0.01, 1},
+		{0.05, 1},
+		{0.10, 2},
+		{0.20, 6},
+		{0.60, 8},
+	}
+
+	for _, tt := range tests {
+		if got := ParityForLoss(8, tt.loss, 1, 8); got != tt.expected {
+			t.Errorf("ParityForLoss(8, %.2f) = %d, expected %d", tt.loss, got, tt.expected)
+		}
+	}
+}
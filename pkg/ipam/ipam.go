@@ -0,0 +1,438 @@
+// Package ipam allocates tunnel addresses to nodes from a pool
+//
+// A relay hands each node an IPv4 address from its pool when the node connects and
+// remembers it per node identity in a lease file, so a node keeps its address across
+// reconnects and relay restarts and two nodes never share one. IPv6 addresses are
+// derived from a hash of the identity's public key within a ULA prefix, so they are
+// stable even without the lease file.
+//
+// A node's identity is its ed25519 key (the daemon's identity.key): leases are keyed by
+// the public key, and each connection presents a Claim signed with the private key
+// (see ClaimHeader), so only the holder of the key can lease or renew its addresses.
+// Leases expire once their node has not been seen for the lease time, which frees the
+// address. Nodes with a configured address reserve it with Reserve so that it is not
+// handed to another node.
+package ipam
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageEstablished is the type of the relay's Assignment message
+const MessageEstablished = "established"
+
+// ClaimHeader is the HTTP header a node presents its lease claim in when connecting to the relay (Claim.String)
+const ClaimHeader = "X-Shadowmesh-Lease-Claim"
+
+// MaxClaimSkew bounds how far a claim's time may be from the relay's clock
+const MaxClaimSkew = 5 * time.Minute
+
+// claimSignaturePrefix separates claim signatures from other uses of identity keys
+const claimSignaturePrefix = "shadowmesh lease claim\x00"
+
+// DefaultLeaseTime is how long a lease outlives the last time its node was seen
+const DefaultLeaseTime = 7 * 24 * time.Hour
+
+// ErrPoolExhausted is returned when every address in the pool is leased
+var ErrPoolExhausted = errors.New("address pool exhausted")
+
+// ErrLeaseHeld is returned when a reserved address belongs to another node
+var ErrLeaseHeld = errors.New("lease held by another node")
+
+// ErrInvalidClaim is returned for claims with a bad signature, too far from the relay's
+// clock, or not newer than the last claim of their identity (a replay)
+var ErrInvalidClaim = errors.New("invalid lease claim")
+
+// ulaRange is the IPv6 unique local address range (RFC 4193)
+var ulaRange = netip.MustParsePrefix("fc00::/7")
+
+// Assignment is the relay's "established" message: the tunnel addresses assigned to a node
+// Sent as a WebSocket text message before any frame once the relay has accepted the node.
+type Assignment struct {
+	Type     string       `json:"type"`     // MessageEstablished
+	Address  netip.Prefix `json:"address"`  // IPv4 address with the pool's prefix length
+	Address6 netip.Prefix `json:"address6"` // IPv6 address with the ULA prefix length; zero without an IPv6 pool
+}
+
+// Claim is a node's signed request for the lease of its identity
+// The signature covers the identity and time, so a claim cannot be made for another
+// identity; a claim is accepted once, and only while its time is within MaxClaimSkew.
+type Claim struct {
+	Identity  ed25519.PublicKey
+	Time      time.Time
+	Signature []byte
+}
+
+// Lease records the addresses allocated to one node identity
+type Lease struct {
+	Address   netip.Addr `json:"address"`
+	Address6  netip.Addr `json:"address6,omitempty"`
+	Static    bool       `json:"static,omitempty"` // Address configured on the node and reserved with Reserve
+	Claimed   time.Time  `json:"claimed"`          // Time of the newest claim accepted; older ones are refused
+	Allocated time.Time  `json:"allocated"`
+	LastSeen  time.Time  `json:"last_seen"`
+}
+
+// leaseFile is the JSON layout of the lease file
+type leaseFile struct {
+	Leases map[string]Lease `json:"leases"`
+}
+
+// Pool allocates addresses from an IPv4 prefix and, optionally, an IPv6 ULA prefix
+// Thread-safe.
+type Pool struct {
+	prefix  netip.Prefix
+	prefix6 netip.Prefix
+	path    string
+
+	mu        sync.Mutex
+	leases    map[string]Lease
+	leaseTime time.Duration
+	now       func() time.Time // time.Now; replaced by tests
+}
+
+// NewPool creates a pool over prefix and prefix6, loading the leases in path
+// prefix must be an IPv4 network of at least 4 addresses. prefix6 is optional (zero
+// Prefix) and must lie within fc00::/7 with at least 16 host bits. path may be empty
+// to keep leases in memory only; a missing file starts an empty pool.
+func NewPool(prefix, prefix6 netip.Prefix, path string) (*Pool, error) {
+	if !prefix.IsValid() || !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("pool must be an IPv4 network of /30 or larger, got %s", prefix)
+	}
+	if prefix6.IsValid() && (!ulaRange.Contains(prefix6.Addr()) || prefix6.Bits() < ulaRange.Bits() || prefix6.Bits() > 112) {
+		return nil, fmt.Errorf("IPv6 pool must be a unique local prefix (fc00::/7) of /112 or larger, got %s", prefix6)
+	}
+
+	p := &Pool{
+		prefix:    prefix.Masked(),
+		prefix6:   prefix6.Masked(),
+		path:      path,
+		leases:    make(map[string]Lease),
+		leaseTime: DefaultLeaseTime,
+		now:       time.Now,
+	}
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leases: %w", err)
+	}
+	var file leaseFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid lease file %s: %w", path, err)
+	}
+	for identity, lease := range file.Leases {
+		p.leases[identity] = lease
+	}
+	return p, nil
+}
+
+// Prefix returns the IPv4 network addresses are allocated from
+func (p *Pool) Prefix() netip.Prefix {
+	return p.prefix
+}
+
+// SetLeaseTime sets how long a lease outlives the last time its node was seen (DefaultLeaseTime)
+func (p *Pool) SetLeaseTime(leaseTime time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.leaseTime = leaseTime
+}
+
+// Allocate returns the addresses of the claim's identity, leasing new ones on its first connection
+// It fails with ErrInvalidClaim unless the claim verifies (see Claim.Verify) and is
+// newer than the identity's last one. A lease whose address lies outside the pool (the
+// pool was changed) is replaced. The lease file is rewritten whenever a lease is
+// created or renewed.
+func (p *Pool) Allocate(claim Claim) (Assignment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	identity := claim.ID()
+	lease, err := p.claim(claim, now)
+	if err != nil {
+		return Assignment{}, err
+	}
+	if lease.Address.IsValid() && !p.prefix.Contains(lease.Address) {
+		lease.Address = netip.Addr{}
+	}
+	if !lease.Address.IsValid() {
+		addr, err := p.nextFree()
+		if err != nil {
+			return Assignment{}, err
+		}
+		lease.Address, lease.Allocated = addr, now
+	}
+	if p.prefix6.IsValid() && !p.prefix6.Contains(lease.Address6) {
+		lease.Address6 = p.deriveFree(claim.Identity)
+	} else if !p.prefix6.IsValid() {
+		lease.Address6 = netip.Addr{}
+	}
+	lease.Static = false
+	lease.LastSeen = now
+	p.leases[identity] = lease
+
+	if err := p.save(); err != nil {
+		return Assignment{}, err
+	}
+
+	assignment := Assignment{
+		Type:    MessageEstablished,
+		Address: netip.PrefixFrom(lease.Address, p.prefix.Bits()),
+	}
+	if lease.Address6.IsValid() {
+		assignment.Address6 = netip.PrefixFrom(lease.Address6, p.prefix6.Bits())
+	}
+	return assignment, nil
+}
+
+// Reserve leases addr, the address a node configured itself, to the claim's identity
+// Other nodes are then not allocated addr. It fails with ErrLeaseHeld if another node
+// holds addr, and with ErrInvalidClaim like Allocate. Like allocated leases,
+// reservations expire unless their node keeps connecting.
+func (p *Pool) Reserve(claim Claim, addr netip.Addr) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.prefix.Contains(addr) || addr == p.prefix.Addr() || !p.prefix.Contains(addr.Next()) {
+		return fmt.Errorf("%s is not a host address of the pool %s", addr, p.prefix)
+	}
+
+	now := p.now()
+	identity := claim.ID()
+	lease, err := p.claim(claim, now)
+	if err != nil {
+		return err
+	}
+	for id, other := range p.leases {
+		if id != identity && other.Address == addr {
+			return fmt.Errorf("%w: %s is leased to %s", ErrLeaseHeld, addr, id)
+		}
+	}
+
+	if lease.Address != addr {
+		lease.Allocated = now
+	}
+	lease.Address, lease.Address6, lease.Static = addr, netip.Addr{}, true
+	lease.LastSeen = now
+	p.leases[identity] = lease
+	return p.save()
+}
+
+// Renew records that the nodes of identities (Claim.ID) are still connected, so their leases do not expire
+// Unknown identities are skipped. The relay renews the leases of connected nodes
+// periodically and once more when they disconnect.
+func (p *Pool) Renew(identities ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	renewed := false
+	for _, identity := range identities {
+		if lease, ok := p.leases[identity]; ok {
+			lease.LastSeen = now
+			p.leases[identity] = lease
+			renewed = true
+		}
+	}
+	if !renewed {
+		return nil
+	}
+	return p.save()
+}
+
+// Leases returns a copy of the current leases by identity (Claim.ID)
+func (p *Pool) Leases() map[string]Lease {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	leases := make(map[string]Lease, len(p.leases))
+	for identity, lease := range p.leases {
+		leases[identity] = lease
+	}
+	return leases
+}
+
+// claim returns the lease of the claim's identity after expiring old leases and verifying the claim (p.mu held)
+// The lease is zero if the identity has none.
+func (p *Pool) claim(claim Claim, now time.Time) (Lease, error) {
+	for id, lease := range p.leases {
+		if now.Sub(lease.LastSeen) > p.leaseTime {
+			delete(p.leases, id)
+		}
+	}
+
+	if err := claim.Verify(now); err != nil {
+		return Lease{}, err
+	}
+	lease := p.leases[claim.ID()]
+	if !claim.Time.After(lease.Claimed) {
+		return Lease{}, fmt.Errorf("%w: not newer than the last claim of %s", ErrInvalidClaim, claim.ID())
+	}
+	lease.Claimed = claim.Time
+	return lease, nil
+}
+
+// nextFree returns the lowest host address not leased (p.mu held)
+// The network and broadcast addresses are never allocated.
+func (p *Pool) nextFree() (netip.Addr, error) {
+	used := make(map[netip.Addr]bool, len(p.leases))
+	for _, lease := range p.leases {
+		used[lease.Address] = true
+	}
+
+	for addr := p.prefix.Addr().Next(); p.prefix.Contains(addr.Next()); addr = addr.Next() {
+		if !used[addr] {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%w: all of %s is leased", ErrPoolExhausted, p.prefix)
+}
+
+// deriveFree derives the IPv6 address of identity from its public key, rehashing while another lease holds it (p.mu held)
+func (p *Pool) deriveFree(identity ed25519.PublicKey) netip.Addr {
+	self := hex.EncodeToString(identity)
+	used := make(map[netip.Addr]bool, len(p.leases))
+	for id, lease := range p.leases {
+		if id != self {
+			used[lease.Address6] = true
+		}
+	}
+
+	hash := sha256.Sum256(identity)
+	for {
+		addr := DeriveAddress(p.prefix6, hash[:])
+		if !used[addr] {
+			return addr
+		}
+		hash = sha256.Sum256(hash[:])
+	}
+}
+
+// DeriveAddress fills the host bits of prefix with hash, such as the hash of a node's public key
+// An all-zero host part (the subnet-router anycast address) is avoided by setting the
+// last bit. hash must be at least 16 bytes.
+func DeriveAddress(prefix netip.Prefix, hash []byte) netip.Addr {
+	network := prefix.Masked().Addr().As16()
+	bits := prefix.Bits()
+
+	var addr [16]byte
+	hostPart := false
+	for i := range addr {
+		// Bits of this byte belonging to the network, counted from the most significant
+		networkBits := min(max(bits-8*i, 0), 8)
+		mask := byte(0xff) >> networkBits
+		addr[i] = network[i]&^mask | hash[i]&mask
+		if addr[i]&mask != 0 {
+			hostPart = true
+		}
+	}
+	if !hostPart {
+		addr[15] |= 1
+	}
+	return netip.AddrFrom16(addr)
+}
+
+// NewClaim signs a claim for the lease of key's identity at now
+func NewClaim(key ed25519.PrivateKey, now time.Time) Claim {
+	claim := Claim{Identity: key.Public().(ed25519.PublicKey), Time: now}
+	claim.Signature = ed25519.Sign(key, claim.signedData())
+	return claim
+}
+
+// ParseClaim parses a claim in the form of Claim.String, without verifying it
+func ParseClaim(s string) (Claim, error) {
+	fields := strings.Split(s, ".")
+	if len(fields) != 3 {
+		return Claim{}, fmt.Errorf("%w: want identity.time.signature", ErrInvalidClaim)
+	}
+	identity, err := hex.DecodeString(fields[0])
+	if err != nil || len(identity) != ed25519.PublicKeySize {
+		return Claim{}, fmt.Errorf("%w: identity must be a hex-encoded ed25519 public key", ErrInvalidClaim)
+	}
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Claim{}, fmt.Errorf("%w: time must be in Unix nanoseconds", ErrInvalidClaim)
+	}
+	signature, err := hex.DecodeString(fields[2])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return Claim{}, fmt.Errorf("%w: signature must be a hex-encoded ed25519 signature", ErrInvalidClaim)
+	}
+	return Claim{Identity: identity, Time: time.Unix(0, nanos), Signature: signature}, nil
+}
+
+// String encodes the claim for ClaimHeader: the identity, time and signature separated by dots
+func (c Claim) String() string {
+	return fmt.Sprintf("%s.%d.%s", hex.EncodeToString(c.Identity), c.Time.UnixNano(), hex.EncodeToString(c.Signature))
+}
+
+// ID returns the identity a claim's lease is kept under: its public key, hex
+func (c Claim) ID() string {
+	return hex.EncodeToString(c.Identity)
+}
+
+// Verify checks the claim's signature and that its time is within MaxClaimSkew of now
+func (c Claim) Verify(now time.Time) error {
+	if len(c.Identity) != ed25519.PublicKeySize || !ed25519.Verify(c.Identity, c.signedData(), c.Signature) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidClaim)
+	}
+	if skew := now.Sub(c.Time); skew > MaxClaimSkew || skew < -MaxClaimSkew {
+		return fmt.Errorf("%w: time is %v off the relay's clock", ErrInvalidClaim, skew.Round(time.Second))
+	}
+	return nil
+}
+
+// signedData returns what a claim's signature covers
+func (c Claim) signedData() []byte {
+	data := append([]byte(claimSignaturePrefix), c.Identity...)
+	return binary.BigEndian.AppendUint64(data, uint64(c.Time.UnixNano()))
+}
+
+// save writes the leases to the lease file, replacing it atomically (p.mu held)
+func (p *Pool) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(leaseFile{Leases: p.leases}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode leases: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save leases: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save leases: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save leases: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		return fmt.Errorf("failed to save leases: %w", err)
+	}
+	return nil
+}
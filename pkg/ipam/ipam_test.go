@@ -0,0 +1,374 @@
+package ipam
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testClock is a pool's clock that signs claims of test nodes
+type testClock struct {
+	now time.Time
+}
+
+// newTestClock returns a clock at a fixed time and makes the pools use it
+func newTestClock(pools ...*Pool) *testClock {
+	clock := &testClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, pool := range pools {
+		pool.now = clock.Now
+	}
+	return clock
+}
+
+// Now returns the clock's time
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// claim signs a claim of the named node, a nanosecond after the last so that it is not a replay
+func (c *testClock) claim(name string) Claim {
+	c.now = c.now.Add(time.Nanosecond)
+	return NewClaim(testKey(name), c.now)
+}
+
+// testKey returns the identity key of a named test node
+func testKey(name string) ed25519.PrivateKey {
+	seed := sha256.Sum256([]byte(name))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+// testID returns the identity the leases of a named test node are kept under
+func testID(name string) string {
+	return hex.EncodeToString(testKey(name).Public().(ed25519.PublicKey))
+}
+
+// TestAllocate tests that identities get distinct, stable addresses and the pool runs out cleanly
+func TestAllocate(t *testing.T) {
+	pool, err := NewPool(netip.MustParsePrefix("10.77.0.0/30"), netip.Prefix{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTestClock(pool)
+
+	alice, err := pool.Allocate(clock.claim("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := pool.Allocate(clock.claim("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Address != netip.MustParsePrefix("10.77.0.1/30") || bob.Address != netip.MustParsePrefix("10.77.0.2/30") {
+		t.Errorf("addresses = %s, %s, want 10.77.0.1/30, 10.77.0.2/30", alice.Address, bob.Address)
+	}
+	if alice.Type != MessageEstablished || alice.Address6.IsValid() {
+		t.Errorf("assignment = %+v, want an established message without IPv6", alice)
+	}
+
+	again, err := pool.Allocate(clock.claim("alice"))
+	if err != nil || again.Address != alice.Address {
+		t.Errorf("second allocation = %s, %v, want %s", again.Address, err, alice.Address)
+	}
+
+	// The network and broadcast addresses are never handed out
+	if _, err := pool.Allocate(clock.claim("carol")); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("allocation from a full pool: err = %v, want ErrPoolExhausted", err)
+	}
+}
+
+// TestLeasesPersist tests that a pool reloaded from its lease file keeps every address
+func TestLeasesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	prefix, prefix6 := netip.MustParsePrefix("10.77.0.0/24"), netip.MustParsePrefix("fd00:77::/64")
+
+	pool, err := NewPool(prefix, prefix6, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTestClock(pool)
+	want := make(map[string]Assignment)
+	for _, identity := range []string{"alice", "bob", "carol"} {
+		if want[identity], err = pool.Allocate(clock.claim(identity)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// IPv6 addresses come from the hash of the public key
+	hash := sha256.Sum256(testKey("alice").Public().(ed25519.PublicKey))
+	if got := want["alice"].Address6.Addr(); got != DeriveAddress(prefix6, hash[:]) {
+		t.Errorf("alice's IPv6 address = %s, want %s", got, DeriveAddress(prefix6, hash[:]))
+	}
+
+	reloaded, err := NewPool(prefix, prefix6, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = clock.Now
+	// Allocating in a different order must not shuffle addresses
+	for _, identity := range []string{"carol", "alice", "bob"} {
+		got, err := reloaded.Allocate(clock.claim(identity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[identity] {
+			t.Errorf("%s after reload = %+v, want %+v", identity, got, want[identity])
+		}
+	}
+	if dave, err := reloaded.Allocate(clock.claim("dave")); err != nil || dave.Address != netip.MustParsePrefix("10.77.0.4/24") {
+		t.Errorf("new identity after reload = %s, %v, want 10.77.0.4/24", dave.Address, err)
+	}
+
+	// A changed pool replaces leases that no longer fit it
+	moved, err := NewPool(netip.MustParsePrefix("10.78.0.0/24"), netip.Prefix{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved.now = clock.Now
+	if got, err := moved.Allocate(clock.claim("bob")); err != nil || got.Address != netip.MustParsePrefix("10.78.0.1/24") || got.Address6.IsValid() {
+		t.Errorf("allocation from a changed pool = %+v, %v, want 10.78.0.1/24 without IPv6", got, err)
+	}
+}
+
+// TestClaim tests that a lease is only handed out for a fresh claim signed with its identity's key
+func TestClaim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	pool, err := NewPool(netip.MustParsePrefix("10.77.0.0/24"), netip.Prefix{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTestClock(pool)
+
+	claim := clock.claim("alice")
+	alice, err := pool.Allocate(claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := clock.claim("mallory")
+	forged.Identity = claim.Identity
+	stale := clock.claim("alice")
+	clock.now = clock.now.Add(MaxClaimSkew + time.Second)
+	early := NewClaim(testKey("alice"), clock.now.Add(MaxClaimSkew+time.Second))
+	tests := []struct {
+		name  string
+		claim Claim
+	}{
+		{"signed by another key", forged},
+		{"replayed", claim},
+		{"too old", stale},
+		{"too far ahead", early},
+	}
+	for _, tt := range tests {
+		if _, err := pool.Allocate(tt.claim); !errors.Is(err, ErrInvalidClaim) {
+			t.Errorf("%s: err = %v, want ErrInvalidClaim", tt.name, err)
+		}
+	}
+	if got, err := pool.Allocate(clock.claim("alice")); err != nil || got != alice {
+		t.Errorf("allocation with a fresh claim = %+v, %v, want %+v", got, err, alice)
+	}
+
+	// Replays are refused after a reload too
+	replayed := clock.claim("alice")
+	if _, err := pool.Allocate(replayed); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewPool(netip.MustParsePrefix("10.77.0.0/24"), netip.Prefix{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = clock.Now
+	if _, err := reloaded.Allocate(replayed); !errors.Is(err, ErrInvalidClaim) {
+		t.Errorf("replayed claim after reload: err = %v, want ErrInvalidClaim", err)
+	}
+}
+
+// TestParseClaim tests the header encoding of claims
+func TestParseClaim(t *testing.T) {
+	claim := NewClaim(testKey("alice"), time.Date(2026, 1, 1, 0, 0, 0, 5, time.UTC))
+	parsed, err := ParseClaim(claim.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(claim.Time); err != nil || parsed.ID() != testID("alice") || !parsed.Time.Equal(claim.Time) {
+		t.Errorf("parsed claim = %+v, %v, want alice's claim", parsed, err)
+	}
+
+	for _, bad := range []string{"", "alice", claim.ID() + ".now." + hex.EncodeToString(claim.Signature), "00.1.00"} {
+		if _, err := ParseClaim(bad); !errors.Is(err, ErrInvalidClaim) {
+			t.Errorf("ParseClaim(%q) err = %v, want ErrInvalidClaim", bad, err)
+		}
+	}
+}
+
+// TestLeaseExpiry tests that leases of nodes not seen for the lease time are freed and renewed ones kept
+func TestLeaseExpiry(t *testing.T) {
+	pool, err := NewPool(netip.MustParsePrefix("10.77.0.0/29"), netip.Prefix{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTestClock(pool)
+	pool.SetLeaseTime(time.Hour)
+
+	for _, identity := range []string{"alice", "bob"} {
+		if _, err := pool.Allocate(clock.claim(identity)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bob stays connected, alice leaves
+	clock.now = clock.now.Add(50 * time.Minute)
+	if err := pool.Renew(testID("bob"), "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(20 * time.Minute)
+
+	carol, err := pool.Allocate(clock.claim("carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.Address != netip.MustParsePrefix("10.77.0.1/29") {
+		t.Errorf("carol = %s, want alice's expired 10.77.0.1/29", carol.Address)
+	}
+	leases := pool.Leases()
+	if _, ok := leases[testID("alice")]; ok {
+		t.Error("expired lease of alice kept")
+	}
+	if leases[testID("bob")].Address != netip.MustParseAddr("10.77.0.2") {
+		t.Errorf("bob's renewed lease = %+v, want 10.77.0.2", leases[testID("bob")])
+	}
+
+	// With the lease gone, the identity gets a new one
+	if got, err := pool.Allocate(clock.claim("alice")); err != nil || got.Address != netip.MustParsePrefix("10.77.0.3/29") {
+		t.Errorf("alice after expiry = %+v, %v, want a new lease 10.77.0.3/29", got, err)
+	}
+}
+
+// TestReserve tests that configured addresses are reserved for their node and skipped by Allocate
+func TestReserve(t *testing.T) {
+	pool, err := NewPool(netip.MustParsePrefix("10.77.0.0/24"), netip.MustParsePrefix("fd00:77::/64"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTestClock(pool)
+
+	if err := pool.Reserve(clock.claim("alice"), netip.MustParseAddr("10.77.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Reserve(clock.claim("alice"), netip.MustParseAddr("10.77.0.1")); err != nil {
+		t.Errorf("reserving again: %v", err)
+	}
+	if lease := pool.Leases()[testID("alice")]; !lease.Static || lease.Address6.IsValid() {
+		t.Errorf("reservation = %+v, want a static lease without IPv6", lease)
+	}
+
+	bob, err := pool.Allocate(clock.claim("bob"))
+	if err != nil || bob.Address != netip.MustParsePrefix("10.77.0.2/24") {
+		t.Errorf("bob = %s, %v, want 10.77.0.2/24 next to the reservation", bob.Address, err)
+	}
+
+	forged := clock.claim("mallory")
+	forged.Identity = testKey("alice").Public().(ed25519.PublicKey)
+	tests := []struct {
+		name     string
+		identity string
+		claim    Claim
+		addr     string
+		want     error
+	}{
+		{"address of another node", "carol", clock.claim("carol"), "10.77.0.2", ErrLeaseHeld},
+		{"reserved address", "carol", clock.claim("carol"), "10.77.0.1", ErrLeaseHeld},
+		{"identity signed by another key", "alice", forged, "10.77.0.9", ErrInvalidClaim},
+		{"network address", "carol", clock.claim("carol"), "10.77.0.0", nil},
+		{"broadcast address", "carol", clock.claim("carol"), "10.77.0.255", nil},
+		{"outside the pool", "carol", clock.claim("carol"), "10.78.0.1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pool.Reserve(tt.claim, netip.MustParseAddr(tt.addr))
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("Reserve(%s, %s) = %v, want an error", tt.identity, tt.addr, err)
+			}
+		})
+	}
+
+	// A node switching to an assigned address keeps its reserved one
+	if got, err := pool.Allocate(clock.claim("alice")); err != nil || got.Address != netip.MustParsePrefix("10.77.0.1/24") || !got.Address6.IsValid() {
+		t.Errorf("alice's allocation = %+v, %v, want 10.77.0.1/24 and an IPv6 address", got, err)
+	}
+	if pool.Leases()[testID("alice")].Static {
+		t.Error("allocated lease still marked static")
+	}
+}
+
+// TestNewPool tests that unusable pools are rejected
+func TestNewPool(t *testing.T) {
+	tests := []struct {
+		prefix, prefix6 string
+	}{
+		{"10.77.0.0/31", ""},
+		{"fd00::/64", ""},
+		{"10.77.0.0/24", "2001:db8::/64"},
+		{"10.77.0.0/24", "fd00::/120"},
+	}
+	for _, tt := range tests {
+		var prefix6 netip.Prefix
+		if tt.prefix6 != "" {
+			prefix6 = netip.MustParsePrefix(tt.prefix6)
+		}
+		if _, err := NewPool(netip.MustParsePrefix(tt.prefix), prefix6, ""); err == nil {
+			t.Errorf("NewPool(%s, %s) succeeded, want an error", tt.prefix, tt.prefix6)
+		}
+	}
+}
+
+// TestDeriveAddress tests that derived addresses keep the prefix and take the host part from the hash
+func TestDeriveAddress(t *testing.T) {
+	prefix := netip.MustParsePrefix("fd12:3456:789a:1::/64")
+	hash := sha256.Sum256([]byte("alice"))
+
+	addr := DeriveAddress(prefix, hash[:])
+	if !prefix.Contains(addr) {
+		t.Fatalf("derived %s outside %s", addr, prefix)
+	}
+	got := addr.As16()
+	if string(got[8:]) != string(hash[8:16]) {
+		t.Errorf("host part of %s does not come from the hash", addr)
+	}
+	if DeriveAddress(prefix, hash[:]) != addr {
+		t.Error("derivation is not deterministic")
+	}
+
+	// A prefix boundary inside a byte keeps the network bits
+	odd := netip.MustParsePrefix("fd00::/61")
+	if addr := DeriveAddress(odd, hash[:]); !odd.Contains(addr) {
+		t.Errorf("derived %s outside %s", addr, odd)
+	}
+
+	// An all-zero host part would be the subnet-router anycast address
+	if addr := DeriveAddress(prefix, make([]byte, 16)); addr == prefix.Addr() {
+		t.Errorf("derived the subnet-router anycast address %s", addr)
+	}
+}
+
+// TestAssignmentJSON tests the wire format of the established message
+func TestAssignmentJSON(t *testing.T) {
+	assignment := Assignment{Type: MessageEstablished, Address: netip.MustParsePrefix("10.77.0.7/24")}
+	data, err := json.Marshal(assignment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"established","address":"10.77.0.7/24","address6":""}`; string(data) != want {
+		t.Errorf("encoded = %s, want %s", data, want)
+	}
+
+	var decoded Assignment
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != assignment {
+		t.Errorf("decoded = %+v, %v, want %+v", decoded, err, assignment)
+	}
+}
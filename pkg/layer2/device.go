@@ -0,0 +1,368 @@
+package layer2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Device modes accepted by NewNetworkDevice
+const (
+	ModeTAP = "tap"
+	ModeTUN = "tun"
+)
+
+// Layer is the network layer of the packets a device carries
+type Layer int
+
+const (
+	Layer2 Layer = 2 // Ethernet frames (TAP)
+	Layer3 Layer = 3 // IP packets (TUN)
+)
+
+// String returns "L2" or "L3"
+func (l Layer) String() string {
+	return "L" + strconv.Itoa(int(l))
+}
+
+// NetworkDevice is a virtual network interface, either TAP (Ethernet frames) or TUN (IP packets)
+//
+// Both backends share channel semantics:
+//   - ReadChannel delivers everything read from the interface as an EthernetFrame.
+//     A TUN device has no link layer: its frames have zero MAC addresses, an EtherType
+//     derived from the IP version and the whole IP packet as Payload.
+//   - WriteChannel accepts the device's native unit: a complete Ethernet frame for
+//     Layer2 and a bare IP packet for Layer3. Invalid writes are dropped and reported
+//     on ErrorChannel.
+//
+// Neither channel is closed by Stop, so senders and receivers stop on their own signal.
+//
+// Interface settings are applied over netlink on Linux. Adding an address or route
+// that is already present, or removing one that is not, succeeds; Stop removes the
+// addresses and routes the device added and takes the interface down.
+type NetworkDevice interface {
+	Name() string
+	Layer() Layer
+	Start()
+	Stop() error
+
+	ReadChannel() <-chan *EthernetFrame
+	WriteChannel() chan<- []byte
+	ErrorChannel() <-chan error
+
+	MTU() int
+	SetMTU(mtu int) error
+	SetUp(up bool) error
+
+	AddAddress(prefix netip.Prefix) error
+	RemoveAddress(prefix netip.Prefix) error
+	Addresses() []netip.Prefix
+	AddRoute(prefix netip.Prefix, via netip.Addr) error
+	RemoveRoute(prefix netip.Prefix) error
+	Routes() []netip.Prefix
+}
+
+// DeviceConfig contains configuration for NewNetworkDevice
+type DeviceConfig struct {
+	Mode string // ModeTAP or ModeTUN
+	Name string // Interface name; may be ignored by the platform (see NewTAPDevice)
+	MTU  int    // Maximum Transmission Unit of the IP layer (default 1500)
+}
+
+// NewNetworkDevice creates the device for config.Mode
+// Creating a device requires root or CAP_NET_ADMIN.
+func NewNetworkDevice(config DeviceConfig) (NetworkDevice, error) {
+	switch config.Mode {
+	case ModeTAP:
+		return NewTAPDevice(TAPConfig{Name: config.Name, MTU: config.MTU})
+	case ModeTUN:
+		return NewTUNDevice(TUNConfig{Name: config.Name, MTU: config.MTU})
+	default:
+		return nil, fmt.Errorf("unknown device mode %q: must be %s or %s", config.Mode, ModeTAP, ModeTUN)
+	}
+}
+
+// ifaceDevice is the interface I/O shared by TAPDevice and TUNDevice
+type ifaceDevice struct {
+	iface      io.ReadWriteCloser
+	kind       string // "TAP" or "TUN", for errors
+	layer      Layer
+	name       string
+	headerSize int                                       // Link-layer header added to the MTU (Ethernet for TAP)
+	parse      func(data []byte) (*EthernetFrame, error) // Converts what was read into a frame
+	validate   func(data []byte) error                   // Checks a write before it reaches the interface
+
+	link      linkConfigurator
+	confMu    sync.Mutex
+	addresses []netip.Prefix // Added by AddAddress, removed by Stop
+	routes    []netip.Prefix // Added by AddRoute, removed by Stop
+
+	maxMTU    int                 // MTU at creation; sizes the read buffer and bounds SetMTU
+	mtu       atomic.Int64        // Current interface MTU
+	readChan  chan *EthernetFrame // Parsed frames read from the device (to be encrypted and sent)
+	writeChan chan []byte         // Frames or packets to write to the device (decrypted)
+	errorChan chan error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// newIfaceDevice creates the shared device state for an open interface
+func newIfaceDevice(iface io.ReadWriteCloser, name, kind string, layer Layer, mtu int) *ifaceDevice {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &ifaceDevice{
+		iface:     iface,
+		kind:      kind,
+		layer:     layer,
+		name:      name,
+		link:      systemLink{},
+		maxMTU:    mtu,
+		readChan:  make(chan *EthernetFrame, 2000), // Sized for burst traffic
+		writeChan: make(chan []byte, 2000),
+		errorChan: make(chan error, 10),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	d.mtu.Store(int64(mtu))
+	return d
+}
+
+// Start begins reading and writing
+func (d *ifaceDevice) Start() {
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.writeLoop()
+}
+
+// Stop removes the device's configuration, closes the interface and waits for the read and write loops
+func (d *ifaceDevice) Stop() error {
+	d.cancel()
+
+	cleanupErr := d.deconfigure()
+
+	// Closing the interface unblocks a pending read
+	err := d.iface.Close()
+	d.wg.Wait()
+
+	if err != nil {
+		return fmt.Errorf("failed to close %s device: %w", d.kind, err)
+	}
+	if cleanupErr != nil {
+		return fmt.Errorf("failed to clean up %s: %w", d.name, cleanupErr)
+	}
+	return nil
+}
+
+// reportError passes err to ErrorChannel, dropping it if nobody keeps up
+func (d *ifaceDevice) reportError(err error) {
+	select {
+	case d.errorChan <- err:
+	default:
+	}
+}
+
+// readLoop continuously reads from the interface
+func (d *ifaceDevice) readLoop() {
+	defer d.wg.Done()
+
+	buffer := make([]byte, d.maxMTU+d.headerSize)
+
+	for {
+		n, err := d.iface.Read(buffer)
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			d.reportError(fmt.Errorf("%s read error: %w", d.kind, err))
+			continue
+		}
+
+		// Parse (validates size and extracts header fields; copies the data)
+		frame, err := d.parse(buffer[:n])
+		if err != nil {
+			d.reportError(fmt.Errorf("malformed %s packet dropped: %w", d.kind, err))
+			continue
+		}
+
+		select {
+		case d.readChan <- frame:
+		default:
+			d.reportError(fmt.Errorf("read channel full, dropping frame"))
+		}
+	}
+}
+
+// writeLoop continuously writes to the interface
+func (d *ifaceDevice) writeLoop() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+
+		case data := <-d.writeChan:
+			if err := d.validate(data); err != nil {
+				d.reportError(fmt.Errorf("dropping invalid write to %s: %w", d.name, err))
+				continue
+			}
+
+			// Peers may run a larger MTU than ours; only reject what the device can never carry
+			if len(data) > d.maxMTU+d.headerSize {
+				d.reportError(fmt.Errorf("dropping invalid write to %s: too large (%d bytes)", d.name, len(data)))
+				continue
+			}
+
+			if _, err := d.iface.Write(data); err != nil && d.ctx.Err() == nil {
+				d.reportError(fmt.Errorf("%s write error: %w", d.kind, err))
+			}
+		}
+	}
+}
+
+// Layer returns the network layer the device carries
+func (d *ifaceDevice) Layer() Layer {
+	return d.layer
+}
+
+// ReadChannel returns the channel for parsed frames read from the device
+func (d *ifaceDevice) ReadChannel() <-chan *EthernetFrame {
+	return d.readChan
+}
+
+// WriteChannel returns the channel for frames (TAP) or packets (TUN) to write to the device
+func (d *ifaceDevice) WriteChannel() chan<- []byte {
+	return d.writeChan
+}
+
+// ErrorChannel returns the channel for errors
+func (d *ifaceDevice) ErrorChannel() <-chan error {
+	return d.errorChan
+}
+
+// Name returns the interface name
+func (d *ifaceDevice) Name() string {
+	return d.name
+}
+
+// MTU returns the current interface MTU
+func (d *ifaceDevice) MTU() int {
+	return int(d.mtu.Load())
+}
+
+// SetMTU changes the interface MTU (e.g. after path MTU discovery)
+// The MTU cannot be raised above the value the device was created with.
+// This requires CAP_NET_ADMIN capability or root privileges
+func (d *ifaceDevice) SetMTU(mtu int) error {
+	if mtu < 576 || mtu > d.maxMTU {
+		return fmt.Errorf("invalid MTU %d for %s: must be between 576 and %d", mtu, d.name, d.maxMTU)
+	}
+	if err := d.link.SetMTU(d.name, mtu); err != nil {
+		return err
+	}
+	d.mtu.Store(int64(mtu))
+	return nil
+}
+
+// SetUp brings the interface up or down
+func (d *ifaceDevice) SetUp(up bool) error {
+	return d.link.SetUp(d.name, up)
+}
+
+// AddAddress adds an IPv4 or IPv6 address with its prefix length, e.g. 10.0.0.1/24
+// Adding an address that is already present succeeds. Addresses added are removed by Stop.
+func (d *ifaceDevice) AddAddress(prefix netip.Prefix) error {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	if err := d.link.AddAddress(d.name, prefix); err != nil {
+		return err
+	}
+	if !slices.Contains(d.addresses, prefix) {
+		d.addresses = append(d.addresses, prefix)
+	}
+	return nil
+}
+
+// RemoveAddress removes an address; removing one that is not present succeeds
+func (d *ifaceDevice) RemoveAddress(prefix netip.Prefix) error {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	if err := d.link.RemoveAddress(d.name, prefix); err != nil {
+		return err
+	}
+	d.addresses = slices.DeleteFunc(d.addresses, func(p netip.Prefix) bool { return p == prefix })
+	return nil
+}
+
+// Addresses returns the addresses added to the device
+func (d *ifaceDevice) Addresses() []netip.Prefix {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+	return slices.Clone(d.addresses)
+}
+
+// AddRoute routes a subnet to the device, e.g. a peer's LAN
+// via is the next hop on the device's network, such as the peer's tunnel address; the
+// zero Addr makes the subnet on-link. Adding a route that is already present replaces
+// it. Routes added are removed by Stop.
+func (d *ifaceDevice) AddRoute(prefix netip.Prefix, via netip.Addr) error {
+	prefix = prefix.Masked()
+
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	if err := d.link.AddRoute(d.name, prefix, via); err != nil {
+		return err
+	}
+	if !slices.Contains(d.routes, prefix) {
+		d.routes = append(d.routes, prefix)
+	}
+	return nil
+}
+
+// RemoveRoute removes a route to the device; removing one that is not present succeeds
+func (d *ifaceDevice) RemoveRoute(prefix netip.Prefix) error {
+	prefix = prefix.Masked()
+
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	if err := d.link.RemoveRoute(d.name, prefix); err != nil {
+		return err
+	}
+	d.routes = slices.DeleteFunc(d.routes, func(p netip.Prefix) bool { return p == prefix })
+	return nil
+}
+
+// Routes returns the routes added to the device
+func (d *ifaceDevice) Routes() []netip.Prefix {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+	return slices.Clone(d.routes)
+}
+
+// deconfigure removes the routes and addresses added to the device and takes it down
+// Everything is attempted; the errors are joined.
+func (d *ifaceDevice) deconfigure() error {
+	var errs []error
+	for _, prefix := range d.Routes() {
+		errs = append(errs, d.RemoveRoute(prefix))
+	}
+	for _, prefix := range d.Addresses() {
+		errs = append(errs, d.RemoveAddress(prefix))
+	}
+	errs = append(errs, d.SetUp(false))
+	return errors.Join(errs...)
+}
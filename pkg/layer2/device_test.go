@@ -0,0 +1,288 @@
+package layer2
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testIPv4Packet returns a minimal IPv4 packet carrying UDP
+func testIPv4Packet() []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = 17
+	return packet
+}
+
+// TestParsePacket tests wrapping IPv4 and IPv6 packets in frames
+func TestParsePacket(t *testing.T) {
+	ipv6 := make([]byte, 48)
+	ipv6[0] = 0x60
+
+	tests := []struct {
+		name      string
+		packet    []byte
+		etherType uint16
+	}{
+		{"IPv4", testIPv4Packet(), EtherTypeIPv4},
+		{"IPv6", ipv6, EtherTypeIPv6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := ParsePacket(tt.packet)
+			if err != nil {
+				t.Fatalf("ParsePacket() failed: %v", err)
+			}
+			if frame.EtherType != tt.etherType {
+				t.Errorf("EtherType = 0x%04X, want 0x%04X", frame.EtherType, tt.etherType)
+			}
+			if frame.DestinationMAC != [6]byte{} || frame.SourceMAC != [6]byte{} {
+				t.Errorf("MAC addresses = %v, %v, want zero", frame.DestinationMAC, frame.SourceMAC)
+			}
+			if !bytes.Equal(frame.Payload, tt.packet) {
+				t.Errorf("Payload = %v, want %v", frame.Payload, tt.packet)
+			}
+
+			// The payload must not alias the read buffer
+			tt.packet[1] = 0xFF
+			if frame.Payload[1] == 0xFF {
+				t.Error("Payload shares memory with the input")
+			}
+		})
+	}
+}
+
+// TestParsePacketInvalid tests that non-IP and truncated packets are rejected
+func TestParsePacketInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":          {},
+		"short IPv4":     {0x45, 0x00, 0x00, 0x14},
+		"short IPv6":     append([]byte{0x60}, make([]byte, 30)...),
+		"Ethernet frame": {0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x08, 0x06, 0, 0, 0, 0, 0, 0},
+	}
+
+	for name, packet := range tests {
+		if _, err := ParsePacket(packet); err == nil {
+			t.Errorf("%s: ParsePacket() succeeded, want error", name)
+		}
+		if IsIPPacket(packet) {
+			t.Errorf("%s: IsIPPacket() = true, want false", name)
+		}
+	}
+}
+
+// TestParsePacketClampMSS tests that MSS clamping applies to packets read from a TUN device
+func TestParsePacketClampMSS(t *testing.T) {
+	frame, err := ParsePacket(buildTCPSYN(false, 1460, tcpFlagSYN))
+	if err != nil {
+		t.Fatalf("ParsePacket() failed: %v", err)
+	}
+	if !ClampFrameMSS(frame, 1400) {
+		t.Error("ClampFrameMSS() did not clamp a SYN from a TUN device")
+	}
+}
+
+// TestNewNetworkDeviceUnknownMode tests that an unknown mode is rejected without creating a device
+func TestNewNetworkDeviceUnknownMode(t *testing.T) {
+	device, err := NewNetworkDevice(DeviceConfig{Mode: "tunnel", Name: "test0"})
+	if err == nil {
+		t.Fatal("NewNetworkDevice() succeeded with an unknown mode")
+	}
+	if device != nil {
+		t.Errorf("NewNetworkDevice() returned a device with an error: %v", device)
+	}
+}
+
+// TestDeviceChannels tests the shared read and write channel semantics of a TUN device
+func TestDeviceChannels(t *testing.T) {
+	device, err := NewPipeDevice(DeviceConfig{Mode: ModeTUN, Name: "test0"})
+	if err != nil {
+		t.Fatalf("NewPipeDevice() failed: %v", err)
+	}
+
+	var _ NetworkDevice = device
+	if device.Layer() != Layer3 {
+		t.Errorf("Layer() = %v, want L3", device.Layer())
+	}
+
+	device.Start()
+
+	// Reads are wrapped in frames
+	packet := testIPv4Packet()
+	if err := device.Inject(packet); err != nil {
+		t.Fatalf("Inject() failed: %v", err)
+	}
+
+	select {
+	case frame := <-device.ReadChannel():
+		if frame.EtherType != EtherTypeIPv4 || !bytes.Equal(frame.Payload, packet) {
+			t.Errorf("read frame = %v, want IPv4 packet %v", frame, packet)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no frame read")
+	}
+
+	// Writes are validated: a non-IP write is dropped and reported
+	device.WriteChannel() <- []byte{0x00, 0x01, 0x02}
+	select {
+	case err := <-device.ErrorChannel():
+		t.Logf("invalid write reported: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("invalid write not reported")
+	}
+
+	device.WriteChannel() <- packet
+	select {
+	case written := <-device.Output():
+		if !bytes.Equal(written, packet) {
+			t.Errorf("written = %v, want %v", written, packet)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet not written")
+	}
+
+	if err := device.Stop(); err != nil {
+		t.Errorf("Stop() failed: %v", err)
+	}
+	if err := device.Inject(packet); err == nil {
+		t.Error("Inject() after Stop() succeeded")
+	}
+}
+
+// TestPipeDeviceTAP tests that a pipe device in TAP mode carries whole Ethernet frames
+func TestPipeDeviceTAP(t *testing.T) {
+	device, err := NewPipeDevice(DeviceConfig{Mode: ModeTAP})
+	if err != nil {
+		t.Fatalf("NewPipeDevice() failed: %v", err)
+	}
+	device.Start()
+	defer device.Stop()
+
+	if device.Layer() != Layer2 {
+		t.Errorf("Layer() = %v, want L2", device.Layer())
+	}
+
+	frame := &EthernetFrame{
+		DestinationMAC: [6]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		SourceMAC:      [6]byte{0x02, 0, 0, 0, 0, 1},
+		EtherType:      EtherTypeIPv4,
+		Payload:        testIPv4Packet(),
+	}
+	if err := device.Inject(frame.Serialize()); err != nil {
+		t.Fatalf("Inject() failed: %v", err)
+	}
+
+	select {
+	case read := <-device.ReadChannel():
+		if read.SourceMAC != frame.SourceMAC || !bytes.Equal(read.Payload, frame.Payload) {
+			t.Errorf("read frame = %v, want %v", read, frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no frame read")
+	}
+
+	device.WriteChannel() <- frame.Serialize()
+	select {
+	case written := <-device.Output():
+		if !bytes.Equal(written, frame.Serialize()) {
+			t.Errorf("written = %v, want %v", written, frame.Serialize())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame not written")
+	}
+
+	if err := device.SetMTU(1400); err != nil || device.MTU() != 1400 {
+		t.Errorf("SetMTU(1400) = %v, MTU() = %d", err, device.MTU())
+	}
+}
+
+// recordingLink is a linkConfigurator that keeps the kernel state it would have set
+type recordingLink struct {
+	up        bool
+	addresses []netip.Prefix
+	routes    []netip.Prefix
+}
+
+func (l *recordingLink) SetUp(name string, up bool) error  { l.up = up; return nil }
+func (l *recordingLink) SetMTU(name string, mtu int) error { return nil }
+
+func (l *recordingLink) AddAddress(name string, prefix netip.Prefix) error {
+	if !slices.Contains(l.addresses, prefix) {
+		l.addresses = append(l.addresses, prefix)
+	}
+	return nil
+}
+
+func (l *recordingLink) RemoveAddress(name string, prefix netip.Prefix) error {
+	l.addresses = slices.DeleteFunc(l.addresses, func(p netip.Prefix) bool { return p == prefix })
+	return nil
+}
+
+func (l *recordingLink) AddRoute(name string, prefix netip.Prefix, via netip.Addr) error {
+	if prefix != prefix.Masked() {
+		return fmt.Errorf("route %s not masked", prefix)
+	}
+	if !slices.Contains(l.routes, prefix) {
+		l.routes = append(l.routes, prefix)
+	}
+	return nil
+}
+
+func (l *recordingLink) RemoveRoute(name string, prefix netip.Prefix) error {
+	l.routes = slices.DeleteFunc(l.routes, func(p netip.Prefix) bool { return p == prefix })
+	return nil
+}
+
+// TestDeviceConfiguration tests that addresses and routes are added once and cleaned up on Stop
+func TestDeviceConfiguration(t *testing.T) {
+	device, err := NewPipeDevice(DeviceConfig{Mode: ModeTUN})
+	if err != nil {
+		t.Fatalf("NewPipeDevice() failed: %v", err)
+	}
+	link := &recordingLink{}
+	device.link = link
+	device.Start()
+
+	ipv4 := netip.MustParsePrefix("10.0.0.1/24")
+	ipv6 := netip.MustParsePrefix("fd00:5d::1/64")
+	route := netip.MustParsePrefix("192.168.50.7/24")
+
+	if err := device.SetUp(true); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		for _, prefix := range []netip.Prefix{ipv4, ipv6} {
+			if err := device.AddAddress(prefix); err != nil {
+				t.Fatalf("AddAddress(%s) failed: %v", prefix, err)
+			}
+		}
+		if err := device.AddRoute(route, netip.Addr{}); err != nil {
+			t.Fatalf("AddRoute(%s) failed: %v", route, err)
+		}
+	}
+
+	if got := device.Addresses(); !slices.Equal(got, []netip.Prefix{ipv4, ipv6}) {
+		t.Errorf("Addresses() = %v, want [%s %s]", got, ipv4, ipv6)
+	}
+	if got, want := device.Routes(), []netip.Prefix{route.Masked()}; !slices.Equal(got, want) {
+		t.Errorf("Routes() = %v, want %v", got, want)
+	}
+
+	if err := device.RemoveAddress(ipv6); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.RemoveAddress(ipv6); err != nil {
+		t.Errorf("removing an absent address failed: %v", err)
+	}
+
+	if err := device.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if link.up || len(link.addresses) > 0 || len(link.routes) > 0 {
+		t.Errorf("after Stop: up %v, addresses %v, routes %v; want everything removed", link.up, link.addresses, link.routes)
+	}
+}
@@ -9,8 +9,10 @@ import (
 type EthernetFrame struct {
 	DestinationMAC [6]byte // Bytes 0-5: Destination MAC address
 	SourceMAC      [6]byte // Bytes 6-11: Source MAC address
-	EtherType      uint16  // Bytes 12-13: EtherType (network byte order)
-	Payload        []byte  // Bytes 14-end: Frame payload
+	EtherType      uint16  // Bytes 12-13: EtherType (network byte order), after any 802.1Q tag
+	Payload        []byte  // Bytes 14-end (18-end if tagged): Frame payload
+	VLAN           uint16  // 802.1Q VLAN ID (0: untagged or priority-tagged)
+	Priority       uint8   // 802.1Q priority code point
 }
 
 // Common EtherType values
@@ -18,6 +20,7 @@ const (
 	EtherTypeIPv4 = 0x0800 // Internet Protocol version 4 (IPv4)
 	EtherTypeARP  = 0x0806 // Address Resolution Protocol (ARP)
 	EtherTypeIPv6 = 0x86DD // Internet Protocol version 6 (IPv6)
+	EtherTypeVLAN = 0x8100 // IEEE 802.1Q VLAN tag
 )
 
 // Frame size constraints
@@ -25,27 +28,42 @@ const (
 	EthernetHeaderSize = 14   // Minimum Ethernet header size (6 + 6 + 2 bytes)
 	MinFrameSize       = 14   // Minimum valid frame size (header only)
 	MaxFrameSize       = 1514 // Maximum frame size (1500 MTU + 14 header)
+	VLANTagSize        = 4    // 802.1Q tag between the source MAC and the EtherType
+	MaxVLAN            = 4094 // Highest usable VLAN ID (4095 is reserved)
 )
 
 // ParseFrame parses raw Ethernet frame data into an EthernetFrame struct
 //
 // The frame must be at least 14 bytes (Ethernet header) and at most 1514 bytes
-// (1500 byte MTU + 14 byte header). Returns an error if the frame is malformed.
+// (1500 byte MTU + 14 byte header), 1518 bytes with an 802.1Q tag. Returns an
+// error if the frame is malformed.
 //
 // Ethernet frame structure:
 //   - Bytes 0-5:   Destination MAC address
 //   - Bytes 6-11:  Source MAC address
 //   - Bytes 12-13: EtherType (big-endian/network byte order)
 //   - Bytes 14+:   Payload
+//
+// An 802.1Q tag (EtherType 0x8100, then the priority and VLAN ID) before the
+// EtherType is removed into VLAN and Priority.
 func ParseFrame(data []byte) (*EthernetFrame, error) {
 	// Validate minimum frame size
 	if len(data) < MinFrameSize {
 		return nil, fmt.Errorf("frame too small: got %d bytes, minimum %d bytes required", len(data), MinFrameSize)
 	}
 
+	tagged := binary.BigEndian.Uint16(data[12:14]) == EtherTypeVLAN
+	if tagged && len(data) < MinFrameSize+VLANTagSize {
+		return nil, fmt.Errorf("frame too small: got %d bytes, minimum %d bytes required with a VLAN tag", len(data), MinFrameSize+VLANTagSize)
+	}
+
 	// Validate maximum frame size
-	if len(data) > MaxFrameSize {
-		return nil, fmt.Errorf("frame too large: got %d bytes, maximum %d bytes allowed", len(data), MaxFrameSize)
+	maxSize := MaxFrameSize
+	if tagged {
+		maxSize += VLANTagSize
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("frame too large: got %d bytes, maximum %d bytes allowed", len(data), maxSize)
 	}
 
 	frame := &EthernetFrame{}
@@ -56,18 +74,48 @@ func ParseFrame(data []byte) (*EthernetFrame, error) {
 	// Extract source MAC (bytes 6-11)
 	copy(frame.SourceMAC[:], data[6:12])
 
-	// Extract EtherType (bytes 12-13, big-endian)
-	frame.EtherType = binary.BigEndian.Uint16(data[12:14])
+	// Extract the VLAN tag (bytes 14-15) if present
+	headerSize := EthernetHeaderSize
+	if tagged {
+		tci := binary.BigEndian.Uint16(data[14:16])
+		frame.Priority = uint8(tci >> 13)
+		frame.VLAN = tci & 0x0FFF
+		headerSize += VLANTagSize
+	}
+
+	// Extract EtherType (bytes 12-13, or 16-17 after a tag, big-endian)
+	frame.EtherType = binary.BigEndian.Uint16(data[headerSize-2 : headerSize])
 
 	// Extract payload (bytes 14 to end)
-	if len(data) > EthernetHeaderSize {
-		frame.Payload = make([]byte, len(data)-EthernetHeaderSize)
-		copy(frame.Payload, data[EthernetHeaderSize:])
+	if len(data) > headerSize {
+		frame.Payload = make([]byte, len(data)-headerSize)
+		copy(frame.Payload, data[headerSize:])
 	}
 
 	return frame, nil
 }
 
+// SplitFrame returns the EtherType and payload of a serialized Ethernet frame, after any 802.1Q tag
+// Unlike ParseFrame it does not copy; ok is false if data is too short.
+func SplitFrame(data []byte) (etherType uint16, payload []byte, ok bool) {
+	if len(data) < EthernetHeaderSize {
+		return 0, nil, false
+	}
+	etherType = binary.BigEndian.Uint16(data[12:14])
+	if etherType != EtherTypeVLAN {
+		return etherType, data[EthernetHeaderSize:], true
+	}
+	if len(data) < EthernetHeaderSize+VLANTagSize {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(data[16:18]), data[EthernetHeaderSize+VLANTagSize:], true
+}
+
+// Tagged reports whether the frame carries an 802.1Q tag when serialized
+func (f *EthernetFrame) Tagged() bool {
+	return f.VLAN != 0 || f.Priority != 0
+}
+
 // Serialize converts the EthernetFrame back to raw bytes
 //
 // Returns a byte slice containing the full Ethernet frame:
@@ -75,9 +123,14 @@ func ParseFrame(data []byte) (*EthernetFrame, error) {
 //   - Bytes 6-11:  Source MAC
 //   - Bytes 12-13: EtherType (big-endian)
 //   - Bytes 14+:   Payload
+//
+// A frame with a VLAN ID or priority gets an 802.1Q tag before the EtherType.
 func (f *EthernetFrame) Serialize() []byte {
-	size := EthernetHeaderSize + len(f.Payload)
-	data := make([]byte, size)
+	headerSize := EthernetHeaderSize
+	if f.Tagged() {
+		headerSize += VLANTagSize
+	}
+	data := make([]byte, headerSize+len(f.Payload))
 
 	// Copy destination MAC (bytes 0-5)
 	copy(data[0:6], f.DestinationMAC[:])
@@ -85,12 +138,18 @@ func (f *EthernetFrame) Serialize() []byte {
 	// Copy source MAC (bytes 6-11)
 	copy(data[6:12], f.SourceMAC[:])
 
-	// Write EtherType (bytes 12-13, big-endian)
-	binary.BigEndian.PutUint16(data[12:14], f.EtherType)
+	// Write the VLAN tag (bytes 12-15)
+	if f.Tagged() {
+		binary.BigEndian.PutUint16(data[12:14], EtherTypeVLAN)
+		binary.BigEndian.PutUint16(data[14:16], uint16(f.Priority&0x07)<<13|f.VLAN&0x0FFF)
+	}
+
+	// Write EtherType (bytes 12-13, or 16-17 after a tag, big-endian)
+	binary.BigEndian.PutUint16(data[headerSize-2:headerSize], f.EtherType)
 
 	// Copy payload (bytes 14+)
 	if len(f.Payload) > 0 {
-		copy(data[EthernetHeaderSize:], f.Payload)
+		copy(data[headerSize:], f.Payload)
 	}
 
 	return data
@@ -108,6 +167,10 @@ func (f *EthernetFrame) String() string {
 		etherTypeStr = "IPv6"
 	}
 
+	if f.VLAN != 0 {
+		etherTypeStr = fmt.Sprintf("%s, vlan=%d", etherTypeStr, f.VLAN)
+	}
+
 	return fmt.Sprintf("Frame[dst=%02x:%02x:%02x:%02x:%02x:%02x, src=%02x:%02x:%02x:%02x:%02x:%02x, type=%s, payload=%d bytes]",
 		f.DestinationMAC[0], f.DestinationMAC[1], f.DestinationMAC[2],
 		f.DestinationMAC[3], f.DestinationMAC[4], f.DestinationMAC[5],
@@ -250,6 +250,65 @@ func TestSerializeRoundTrip(t *testing.T) {
 	}
 }
 
+// TestParseFrameVLAN tests parsing and serializing an 802.1Q tagged frame
+func TestParseFrameVLAN(t *testing.T) {
+	data := []byte{
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // Destination MAC
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Source MAC
+		0x81, 0x00, // 802.1Q
+		0xA0, 0x14, // Priority 5, VLAN 20
+		0x08, 0x06, // EtherType: ARP
+		0x00, 0x01, 0x08, 0x00,
+	}
+
+	frame, err := ParseFrame(data)
+	if err != nil {
+		t.Fatalf("ParseFrame() failed: %v", err)
+	}
+	if frame.VLAN != 20 || frame.Priority != 5 || frame.EtherType != EtherTypeARP {
+		t.Errorf("VLAN = %d, Priority = %d, EtherType = 0x%04X; want 20, 5, 0x0806", frame.VLAN, frame.Priority, frame.EtherType)
+	}
+	if !bytes.Equal(frame.Payload, data[18:]) {
+		t.Errorf("Payload = %v, want %v", frame.Payload, data[18:])
+	}
+	if serialized := frame.Serialize(); !bytes.Equal(serialized, data) {
+		t.Errorf("Serialize() round-trip failed:\noriginal:   %v\nserialized: %v", data, serialized)
+	}
+
+	// Removing the tag gives the untagged frame
+	frame.VLAN, frame.Priority = 0, 0
+	if untagged := frame.Serialize(); !bytes.Equal(untagged[:12], data[:12]) || !bytes.Equal(untagged[12:], data[16:]) {
+		t.Errorf("untagged Serialize() = %v", untagged)
+	}
+
+	if _, err := ParseFrame(data[:16]); err == nil {
+		t.Error("ParseFrame() accepted a truncated VLAN tag")
+	}
+
+	// The tag does not count against the maximum frame size
+	large := make([]byte, MaxFrameSize+VLANTagSize)
+	copy(large, data[:18])
+	if _, err := ParseFrame(large); err != nil {
+		t.Errorf("ParseFrame() failed for a max-size tagged frame: %v", err)
+	}
+}
+
+// TestSplitFrame tests finding the EtherType and payload behind an 802.1Q tag
+func TestSplitFrame(t *testing.T) {
+	untagged := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x86, 0xDD, 0x60}
+	tagged := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0x00, 0x00, 0x0A, 0x86, 0xDD, 0x60}
+
+	for name, data := range map[string][]byte{"untagged": untagged, "tagged": tagged} {
+		etherType, payload, ok := SplitFrame(data)
+		if !ok || etherType != EtherTypeIPv6 || !bytes.Equal(payload, []byte{0x60}) {
+			t.Errorf("%s: SplitFrame() = 0x%04X, %v, %v", name, etherType, payload, ok)
+		}
+	}
+	if _, _, ok := SplitFrame(tagged[:16]); ok {
+		t.Error("SplitFrame() accepted a truncated VLAN tag")
+	}
+}
+
 // BenchmarkParseFrame benchmarks frame parsing performance
 func BenchmarkParseFrame(b *testing.B) {
 	// Typical IPv4 frame
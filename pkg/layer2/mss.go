@@ -0,0 +1,169 @@
+package layer2
+
+import "encoding/binary"
+
+// Header sizes used to derive the TCP MSS from an interface MTU
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	tcpHeaderSize  = 20
+
+	ipProtocolTCP = 6
+	tcpFlagSYN    = 0x02
+	tcpOptionEnd  = 0
+	tcpOptionNOP  = 1
+	tcpOptionMSS  = 2
+)
+
+// ClampFrameMSS clamps the MSS option of a TCP SYN carried in an Ethernet frame
+// Returns true if the frame was modified. See ClampMSS.
+func ClampFrameMSS(frame *EthernetFrame, mtu int) bool {
+	switch frame.EtherType {
+	case EtherTypeIPv4, EtherTypeIPv6:
+		return ClampMSS(frame.Payload, mtu)
+	}
+	return false
+}
+
+// ClampMSS lowers the MSS option of a TCP SYN or SYN-ACK so that segments fit in mtu
+//
+// The packet is an IPv4 or IPv6 packet and is modified in place; the TCP checksum is
+// updated incrementally (RFC 1624). Returns true if the packet was modified. Packets that
+// are not TCP SYNs, carry no MSS option, or already advertise a small enough MSS are left
+// untouched.
+//
+// Thread-safe: operates only on the given packet.
+func ClampMSS(packet []byte, mtu int) bool {
+	if len(packet) < 1 {
+		return false
+	}
+
+	var tcp []byte
+	var maxMSS int
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4HeaderSize || packet[9] != ipProtocolTCP {
+			return false
+		}
+		// Only the first fragment carries the TCP header
+		if binary.BigEndian.Uint16(packet[6:8])&0x1FFF != 0 {
+			return false
+		}
+		headerLen := int(packet[0]&0x0F) * 4
+		if headerLen < ipv4HeaderSize || len(packet) < headerLen {
+			return false
+		}
+		tcp = packet[headerLen:]
+		maxMSS = mtu - ipv4HeaderSize - tcpHeaderSize
+
+	case 6:
+		offset, ok := ipv6TransportOffset(packet)
+		if !ok {
+			return false
+		}
+		tcp = packet[offset:]
+		maxMSS = mtu - ipv6HeaderSize - tcpHeaderSize
+
+	default:
+		return false
+	}
+
+	if maxMSS <= 0 || maxMSS > 0xFFFF {
+		return false
+	}
+
+	return clampTCPMSS(tcp, uint16(maxMSS))
+}
+
+// ipv6TransportOffset walks IPv6 extension headers and returns the offset of the TCP header
+func ipv6TransportOffset(packet []byte) (int, bool) {
+	if len(packet) < ipv6HeaderSize {
+		return 0, false
+	}
+
+	next := packet[6]
+	offset := ipv6HeaderSize
+	for {
+		switch next {
+		case ipProtocolTCP:
+			return offset, offset <= len(packet)
+		case 0, 43, 60: // Hop-by-hop, routing, destination options
+			if len(packet) < offset+2 {
+				return 0, false
+			}
+			next = packet[offset]
+			offset += (int(packet[offset+1]) + 1) * 8
+		default:
+			// Fragments, ESP and anything else are not clamped
+			return 0, false
+		}
+	}
+}
+
+// clampTCPMSS rewrites the MSS option in a TCP SYN header if it exceeds maxMSS
+func clampTCPMSS(tcp []byte, maxMSS uint16) bool {
+	if len(tcp) < tcpHeaderSize || tcp[13]&tcpFlagSYN == 0 {
+		return false
+	}
+
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset <= tcpHeaderSize || len(tcp) < dataOffset {
+		return false
+	}
+
+	options := tcp[tcpHeaderSize:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case tcpOptionEnd:
+			return false
+		case tcpOptionNOP:
+			i++
+			continue
+		}
+
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return false
+		}
+		length := int(options[i+1])
+
+		if options[i] == tcpOptionMSS && length == 4 {
+			mss := binary.BigEndian.Uint16(options[i+2 : i+4])
+			if mss <= maxMSS {
+				return false
+			}
+
+			binary.BigEndian.PutUint16(options[i+2:i+4], maxMSS)
+			checksum := binary.BigEndian.Uint16(tcp[16:18])
+			binary.BigEndian.PutUint16(tcp[16:18], updateChecksum(checksum, mss, maxMSS))
+			return true
+		}
+
+		i += length
+	}
+
+	return false
+}
+
+// updateChecksum adjusts a ones'-complement checksum for one changed 16-bit word (RFC 1624)
+func updateChecksum(checksum, oldValue, newValue uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^oldValue) + uint32(newValue)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	return ^uint16(sum)
+}
+
+// ClampRawFrameMSS clamps the MSS option of a TCP SYN in a serialized Ethernet frame
+// Returns true if the frame was modified. See ClampMSS.
+func ClampRawFrameMSS(data []byte, mtu int) bool {
+	etherType, payload, ok := SplitFrame(data)
+	if !ok {
+		return false
+	}
+
+	switch etherType {
+	case EtherTypeIPv4, EtherTypeIPv6:
+		return ClampMSS(payload, mtu)
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package layer2
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildTCPSYN builds an IPv4 or IPv6 TCP SYN with an MSS option and a valid checksum
+func buildTCPSYN(ipv6 bool, mss uint16, flags byte) []byte {
+	tcp := make([]byte, 24)
+	binary.BigEndian.PutUint16(tcp[0:2], 40000) // Source port
+	binary.BigEndian.PutUint16(tcp[2:4], 443)   // Destination port
+	tcp[12] = 6 << 4                            // Data offset: 24 bytes
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	tcp[20] = tcpOptionMSS
+	tcp[21] = 4
+	binary.BigEndian.PutUint16(tcp[22:24], mss)
+
+	var packet []byte
+	if ipv6 {
+		packet = make([]byte, ipv6HeaderSize, ipv6HeaderSize+len(tcp))
+		packet[0] = 6 << 4
+		binary.BigEndian.PutUint16(packet[4:6], uint16(len(tcp)))
+		packet[6] = ipProtocolTCP
+		packet[7] = 64
+		packet[23] = 1 // Source ::1
+		packet[39] = 2 // Destination ::2
+	} else {
+		packet = make([]byte, ipv4HeaderSize, ipv4HeaderSize+len(tcp))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:4], uint16(ipv4HeaderSize+len(tcp)))
+		packet[8] = 64
+		packet[9] = ipProtocolTCP
+		copy(packet[12:16], []byte{10, 0, 0, 1})
+		copy(packet[16:20], []byte{10, 0, 0, 2})
+	}
+	packet = append(packet, tcp...)
+
+	binary.BigEndian.PutUint16(packet[len(packet)-len(tcp)+16:], tcpChecksum(packet, ipv6))
+	return packet
+}
+
+// tcpChecksum computes the TCP checksum of a packet from scratch (checksum field treated as zero)
+func tcpChecksum(packet []byte, ipv6 bool) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+
+	var tcp []byte
+	if ipv6 {
+		tcp = packet[ipv6HeaderSize:]
+		add(packet[8:40])
+	} else {
+		tcp = packet[ipv4HeaderSize:]
+		add(packet[12:20])
+	}
+	sum += ipProtocolTCP + uint32(len(tcp))
+
+	segment := append([]byte(nil), tcp...)
+	segment[16], segment[17] = 0, 0
+	add(segment)
+
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// TestClampMSS tests MSS rewriting and checksum update for IPv4 and IPv6 SYNs
+func TestClampMSS(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ipv6    bool
+		mss     uint16
+		flags   byte
+		mtu     int
+		want    uint16
+		clamped bool
+	}{
+		{"IPv4 SYN", false, 1460, tcpFlagSYN, 1400, 1360, true},
+		{"IPv4 SYN-ACK", false, 1460, tcpFlagSYN | 0x10, 1400, 1360, true},
+		{"IPv6 SYN", true, 1440, tcpFlagSYN, 1400, 1340, true},
+		{"IPv4 SYN already small", false, 1200, tcpFlagSYN, 1400, 1200, false},
+		{"IPv4 ACK", false, 1460, 0x10, 1400, 1460, false},
+	} {
+		packet := buildTCPSYN(tc.ipv6, tc.mss, tc.flags)
+
+		clamped := ClampMSS(packet, tc.mtu)
+		if clamped != tc.clamped {
+			t.Errorf("%s: ClampMSS returned %v, expected %v", tc.name, clamped, tc.clamped)
+		}
+
+		got := binary.BigEndian.Uint16(packet[len(packet)-2:])
+		if got != tc.want {
+			t.Errorf("%s: MSS = %d, expected %d", tc.name, got, tc.want)
+		}
+
+		checksumOffset := len(packet) - 24 + 16
+		if binary.BigEndian.Uint16(packet[checksumOffset:]) != tcpChecksum(packet, tc.ipv6) {
+			t.Errorf("%s: TCP checksum invalid after clamping", tc.name)
+		}
+	}
+}
+
+// TestClampFrameMSS tests clamping through an Ethernet frame
+func TestClampFrameMSS(t *testing.T) {
+	frame := &EthernetFrame{EtherType: EtherTypeIPv4, Payload: buildTCPSYN(false, 1460, tcpFlagSYN)}
+	if !ClampFrameMSS(frame, 1380) {
+		t.Fatal("Expected IPv4 frame to be clamped")
+	}
+	if mss := binary.BigEndian.Uint16(frame.Payload[len(frame.Payload)-2:]); mss != 1340 {
+		t.Errorf("MSS = %d, expected 1340", mss)
+	}
+
+	arp := &EthernetFrame{EtherType: EtherTypeARP, Payload: make([]byte, 28)}
+	if ClampFrameMSS(arp, 1380) {
+		t.Error("ARP frame should not be modified")
+	}
+
+	// Serialized frame
+	raw := (&EthernetFrame{EtherType: EtherTypeIPv6, Payload: buildTCPSYN(true, 1440, tcpFlagSYN)}).Serialize()
+	if !ClampRawFrameMSS(raw, 1380) {
+		t.Fatal("Expected serialized IPv6 frame to be clamped")
+	}
+	if mss := binary.BigEndian.Uint16(raw[len(raw)-2:]); mss != 1320 {
+		t.Errorf("MSS = %d, expected 1320", mss)
+	}
+	if ClampRawFrameMSS(raw[:10], 1380) {
+		t.Error("Truncated frame should not be modified")
+	}
+}
+
+// TestClampMSSMalformed tests that truncated packets are ignored without panicking
+func TestClampMSSMalformed(t *testing.T) {
+	packet := buildTCPSYN(false, 1460, tcpFlagSYN)
+	for i := 0; i < len(packet); i++ {
+		ClampMSS(append([]byte(nil), packet[:i]...), 1400)
+	}
+
+	// Option length running past the header
+	bad := buildTCPSYN(false, 1460, tcpFlagSYN)
+	bad[ipv4HeaderSize+21] = 40
+	if ClampMSS(bad, 1400) {
+		t.Error("Packet with invalid option length should not be clamped")
+	}
+}
@@ -0,0 +1,25 @@
+package layer2
+
+import "net/netip"
+
+// linkConfigurator applies interface settings by interface name
+// Adding what is already present and removing what is absent both succeed, so that
+// a daemon restarted over a leftover interface configures it without errors.
+type linkConfigurator interface {
+	SetUp(name string, up bool) error
+	SetMTU(name string, mtu int) error
+	AddAddress(name string, prefix netip.Prefix) error
+	RemoveAddress(name string, prefix netip.Prefix) error
+	AddRoute(name string, prefix netip.Prefix, via netip.Addr) error
+	RemoveRoute(name string, prefix netip.Prefix) error
+}
+
+// nopLink is the configurator of devices without a kernel interface; the device only tracks the settings
+type nopLink struct{}
+
+func (nopLink) SetUp(string, bool) error                        { return nil }
+func (nopLink) SetMTU(string, int) error                        { return nil }
+func (nopLink) AddAddress(string, netip.Prefix) error           { return nil }
+func (nopLink) RemoveAddress(string, netip.Prefix) error        { return nil }
+func (nopLink) AddRoute(string, netip.Prefix, netip.Addr) error { return nil }
+func (nopLink) RemoveRoute(string, netip.Prefix) error          { return nil }
@@ -0,0 +1,129 @@
+package layer2
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// systemLink configures kernel interfaces over rtnetlink
+type systemLink struct{}
+
+// link looks up the interface by name
+func (systemLink) link(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+	return link, nil
+}
+
+// SetUp brings the interface up or down
+func (s systemLink) SetUp(name string, up bool) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	if up {
+		err = netlink.LinkSetUp(link)
+	} else {
+		err = netlink.LinkSetDown(link)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set %s %s: %w", name, upDown(up), err)
+	}
+	return nil
+}
+
+// SetMTU sets the interface MTU
+func (s systemLink) SetMTU(name string, mtu int) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("failed to set MTU %d on %s: %w", mtu, name, err)
+	}
+	return nil
+}
+
+// AddAddress adds an address, or updates it if present
+// IPv6 addresses skip duplicate address detection; the tunnel prefix is ours alone.
+func (s systemLink) AddAddress(name string, prefix netip.Prefix) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	addr := &netlink.Addr{IPNet: ipNet(prefix)}
+	if prefix.Addr().Is6() {
+		addr.Flags = unix.IFA_F_NODAD
+	}
+	if err := netlink.AddrReplace(link, addr); err != nil {
+		return fmt.Errorf("failed to add address %s on %s: %w", prefix, name, err)
+	}
+	return nil
+}
+
+// RemoveAddress removes an address; an address that is not present is not an error
+func (s systemLink) RemoveAddress(name string, prefix netip.Prefix) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	err = netlink.AddrDel(link, &netlink.Addr{IPNet: ipNet(prefix)})
+	if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+		return fmt.Errorf("failed to remove address %s from %s: %w", prefix, name, err)
+	}
+	return nil
+}
+
+// AddRoute routes prefix to the interface, through via if it is valid, replacing an existing route to it
+func (s systemLink) AddRoute(name string, prefix netip.Prefix, via netip.Addr) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: ipNet(prefix.Masked()), Scope: netlink.SCOPE_LINK}
+	if via.IsValid() {
+		route.Gw = via.AsSlice()
+		route.Scope = netlink.SCOPE_UNIVERSE
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		return fmt.Errorf("failed to add route %s via %s: %w", prefix, name, err)
+	}
+	return nil
+}
+
+// RemoveRoute removes the route to prefix; a route that is not present is not an error
+func (s systemLink) RemoveRoute(name string, prefix netip.Prefix) error {
+	link, err := s.link(name)
+	if err != nil {
+		return err
+	}
+	route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: ipNet(prefix.Masked())}
+	err = netlink.RouteDel(route)
+	if err != nil && !errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("failed to remove route %s via %s: %w", prefix, name, err)
+	}
+	return nil
+}
+
+// ipNet converts a prefix, keeping its address bits
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
+
+// upDown names a link state for errors
+func upDown(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package layer2
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+)
+
+// systemLink is not implemented off Linux; interfaces must be configured by other means
+type systemLink struct{}
+
+var errLinkUnsupported = fmt.Errorf("interface configuration is not supported on %s", runtime.GOOS)
+
+func (systemLink) SetUp(string, bool) error                        { return errLinkUnsupported }
+func (systemLink) SetMTU(string, int) error                        { return errLinkUnsupported }
+func (systemLink) AddAddress(string, netip.Prefix) error           { return errLinkUnsupported }
+func (systemLink) RemoveAddress(string, netip.Prefix) error        { return errLinkUnsupported }
+func (systemLink) AddRoute(string, netip.Prefix, netip.Addr) error { return errLinkUnsupported }
+func (systemLink) RemoveRoute(string, netip.Prefix) error          { return errLinkUnsupported }
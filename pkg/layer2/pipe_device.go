@@ -0,0 +1,122 @@
+package layer2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// errPipeClosed is returned when using a PipeDevice after Stop
+var errPipeClosed = errors.New("pipe device closed")
+
+// PipeDevice is an in-memory NetworkDevice whose host side is held by the caller
+// It behaves like a TAP or TUN device (config.Mode) without creating an interface, so
+// it needs no privileges: Inject plays the role of the operating system sending a
+// frame or packet into the device, and Output receives what was written to it.
+// Interface settings (addresses, routes, MTU) are only recorded. Used by tests and
+// userspace network stacks.
+type PipeDevice struct {
+	*ifaceDevice
+	pipe *pipeIface
+}
+
+// NewPipeDevice creates an in-memory device carrying frames (tap) or packets (tun)
+// config.Name is returned by Name; it defaults to "pipe0".
+func NewPipeDevice(config DeviceConfig) (*PipeDevice, error) {
+	if config.MTU == 0 {
+		config.MTU = 1500
+	}
+	if config.Name == "" {
+		config.Name = "pipe0"
+	}
+
+	pipe := newPipeIface()
+	var device *ifaceDevice
+	switch config.Mode {
+	case ModeTAP:
+		device = newIfaceDevice(pipe, config.Name, "TAP", Layer2, config.MTU)
+		device.headerSize = EthernetHeaderSize + VLANTagSize // Room for an 802.1Q tag
+		device.parse = ParseFrame
+		device.validate = validateFrame
+	case ModeTUN:
+		device = newIfaceDevice(pipe, config.Name, "TUN", Layer3, config.MTU)
+		device.parse = ParsePacket
+		device.validate = validatePacket
+	default:
+		return nil, fmt.Errorf("unknown device mode %q: must be %s or %s", config.Mode, ModeTAP, ModeTUN)
+	}
+
+	device.link = nopLink{}
+
+	return &PipeDevice{ifaceDevice: device, pipe: pipe}, nil
+}
+
+// Inject passes data into the device as if the host had sent it
+// It is read, parsed and delivered on ReadChannel like traffic from a real
+// interface. data is copied. Blocks while the device is not reading.
+func (p *PipeDevice) Inject(data []byte) error {
+	if len(data) > p.maxMTU+p.headerSize {
+		return fmt.Errorf("%d bytes exceed the device MTU %d", len(data), p.maxMTU)
+	}
+
+	select {
+	case p.pipe.in <- append([]byte(nil), data...):
+		return nil
+	case <-p.pipe.closed:
+		return errPipeClosed
+	}
+}
+
+// Output returns the frames (tap) or packets (tun) written to the device
+// Writes are dropped if the channel is not drained, like a full interface queue.
+func (p *PipeDevice) Output() <-chan []byte {
+	return p.pipe.out
+}
+
+// pipeIface is the in-memory interface of a PipeDevice
+type pipeIface struct {
+	in     chan []byte // Injected by the host, read by the device
+	out    chan []byte // Written by the device
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeIface() *pipeIface {
+	return &pipeIface{
+		in:     make(chan []byte),
+		out:    make(chan []byte, 2000),
+		closed: make(chan struct{}),
+	}
+}
+
+// Read returns the next injected frame or packet
+func (p *pipeIface) Read(b []byte) (int, error) {
+	select {
+	case data := <-p.in:
+		return copy(b, data), nil
+	case <-p.closed:
+		return 0, io.EOF
+	}
+}
+
+// Write passes a copy of b to the host, dropping it if the host is not keeping up
+func (p *pipeIface) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, errPipeClosed
+	default:
+	}
+
+	select {
+	case p.out <- append([]byte(nil), b...):
+	default:
+	}
+	return len(b), nil
+}
+
+// Close makes pending and later reads return io.EOF
+func (p *pipeIface) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
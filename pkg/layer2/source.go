@@ -0,0 +1,41 @@
+package layer2
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// PacketSource returns the source address of an IP packet
+// ok is false for data that is not an IPv4 or IPv6 packet.
+func PacketSource(packet []byte) (src netip.Addr, ok bool) {
+	if !IsIPPacket(packet) {
+		return netip.Addr{}, false
+	}
+	if packet[0]>>4 == 4 {
+		return netip.AddrFrom4([4]byte(packet[12:16])), true
+	}
+	return netip.AddrFrom16([16]byte(packet[8:24])), true
+}
+
+// FrameSource returns the sender's IP address in a serialized Ethernet frame
+// This is the source of an IPv4 or IPv6 packet, or the sender protocol address of an
+// IPv4 ARP message, which claims that address for the sender's MAC. An 802.1Q tag
+// is skipped. ok is false for other frames.
+func FrameSource(data []byte) (src netip.Addr, ok bool) {
+	etherType, payload, ok := SplitFrame(data)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	switch etherType {
+	case EtherTypeIPv4, EtherTypeIPv6:
+		return PacketSource(payload)
+	case EtherTypeARP:
+		// Hardware type, protocol type, address lengths, operation, then the sender's MAC and IPv4 address
+		if len(payload) < 28 || binary.BigEndian.Uint16(payload[2:4]) != EtherTypeIPv4 || payload[5] != 4 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(payload[14:18])), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,56 @@
+package layer2
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// withEthernet wraps a payload in an Ethernet header with the given EtherType
+func withEthernet(etherType uint16, payload []byte) []byte {
+	frame := &EthernetFrame{EtherType: etherType, Payload: payload}
+	return frame.Serialize()
+}
+
+// TestPacketSource tests extracting the source address from IP packets and frames
+func TestPacketSource(t *testing.T) {
+	arp := make([]byte, 28)
+	arp[1] = 1                            // Hardware type: Ethernet
+	arp[2], arp[3] = 0x08, 0x00           // Protocol type: IPv4
+	arp[4], arp[5] = 6, 4                 // Address lengths
+	copy(arp[14:18], []byte{10, 0, 0, 9}) // Sender protocol address
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		packet []byte // Also checked with PacketSource if set
+		want   string // Empty if no source is expected
+	}{
+		{"IPv4", withEthernet(EtherTypeIPv4, buildTCPSYN(false, 1460, tcpFlagSYN)), buildTCPSYN(false, 1460, tcpFlagSYN), "10.0.0.1"},
+		{"IPv6", withEthernet(EtherTypeIPv6, buildTCPSYN(true, 1460, tcpFlagSYN)), buildTCPSYN(true, 1460, tcpFlagSYN), "::1"},
+		{"ARP", withEthernet(EtherTypeARP, arp), nil, "10.0.0.9"},
+		{"truncated ARP", withEthernet(EtherTypeARP, arp[:20]), nil, ""},
+		{"other protocol", withEthernet(0x88CC, make([]byte, 40)), nil, ""},
+		{"truncated IPv4", withEthernet(EtherTypeIPv4, []byte{0x45, 0, 0}), []byte{0x45, 0, 0}, ""},
+		{"short frame", []byte{1, 2, 3}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want netip.Addr
+			if tt.want != "" {
+				want = netip.MustParseAddr(tt.want)
+			}
+
+			src, ok := FrameSource(tt.frame)
+			if ok != want.IsValid() || src != want {
+				t.Errorf("FrameSource() = %v, %v, want %v", src, ok, want)
+			}
+			if tt.packet != nil {
+				src, ok := PacketSource(tt.packet)
+				if ok != want.IsValid() || src != want {
+					t.Errorf("PacketSource() = %v, %v, want %v", src, ok, want)
+				}
+			}
+		})
+	}
+}
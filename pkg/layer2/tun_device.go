@@ -0,0 +1,94 @@
+package layer2
+
+import (
+	"fmt"
+
+	"github.com/songgao/water"
+)
+
+// TUNDevice manages a TUN (Layer 3) network interface
+// Packets read and written are bare IPv4 or IPv6 packets; ReadChannel wraps them
+// in EthernetFrames (see ParsePacket) so both device types are routed alike.
+type TUNDevice struct {
+	*ifaceDevice
+}
+
+// TUNConfig contains configuration for the TUN device
+type TUNConfig struct {
+	Name string // TUN device name (e.g., "shadowmesh0" on Linux; "utunN" or empty on macOS)
+	MTU  int    // Maximum Transmission Unit (default 1500)
+}
+
+// NewTUNDevice creates and configures a new TUN device
+// The interface carries no packet information header, on Linux and macOS alike.
+func NewTUNDevice(config TUNConfig) (*TUNDevice, error) {
+	if config.MTU == 0 {
+		config.MTU = 1500
+	}
+
+	tunConfig := water.Config{
+		DeviceType: water.TUN,
+	}
+	if config.Name != "" {
+		tunConfig.Name = config.Name
+	}
+
+	// Create TUN interface (requires root/admin privileges)
+	iface, err := water.New(tunConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+
+	// iface.Name() is the actual OS-assigned name (may differ from config.Name on macOS)
+	device := newIfaceDevice(iface, iface.Name(), "TUN", Layer3, config.MTU)
+	device.parse = ParsePacket
+	device.validate = validatePacket
+
+	return &TUNDevice{device}, nil
+}
+
+// ParsePacket wraps an IP packet, as read from a TUN device, in an EthernetFrame
+// The frame has zero MAC addresses, EtherTypeIPv4 or EtherTypeIPv6 according to the
+// IP version, and a copy of the packet as Payload.
+func ParsePacket(packet []byte) (*EthernetFrame, error) {
+	if err := validatePacket(packet); err != nil {
+		return nil, err
+	}
+
+	frame := &EthernetFrame{
+		EtherType: EtherTypeIPv4,
+		Payload:   make([]byte, len(packet)),
+	}
+	if packet[0]>>4 == 6 {
+		frame.EtherType = EtherTypeIPv6
+	}
+	copy(frame.Payload, packet)
+
+	return frame, nil
+}
+
+// validatePacket checks that data is an IP packet that can be written to a TUN device
+func validatePacket(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty packet")
+	}
+
+	switch version := data[0] >> 4; version {
+	case 4:
+		if len(data) < ipv4HeaderSize {
+			return fmt.Errorf("IPv4 packet too short (%d bytes)", len(data))
+		}
+	case 6:
+		if len(data) < ipv6HeaderSize {
+			return fmt.Errorf("IPv6 packet too short (%d bytes)", len(data))
+		}
+	default:
+		return fmt.Errorf("not an IP packet (version %d)", version)
+	}
+	return nil
+}
+
+// IsIPPacket reports whether data is a well-formed IPv4 or IPv6 packet, as a TUN device carries
+func IsIPPacket(data []byte) bool {
+	return validatePacket(data) == nil
+}
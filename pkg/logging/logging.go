@@ -0,0 +1,201 @@
+// Package logging configures structured, levelled logging (log/slog) for ShadowMesh binaries.
+//
+// Each component takes its logger once, usually as a package variable:
+//
+//	var logger = logging.Logger("nat")
+//
+// and logs with key/value attributes instead of formatted strings:
+//
+//	logger.Warn("hole punch failed", "peer", addr, "error", err)
+//
+// Loggers follow Configure, which can be called again at any time (e.g. on a
+// configuration reload) to change the output format, the default level or the
+// level of single components. Output written through the standard log package
+// goes to the same handler at info level once Configure has run.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ComponentKey is the attribute naming the component that logged a record
+const ComponentKey = "component"
+
+// Options configures logging for the process
+type Options struct {
+	Level      string            // debug, info, warn or error (default: info)
+	Format     string            // text or json (default: text)
+	Components map[string]string // Levels for single components, overriding Level
+	Output     io.Writer         // Default: os.Stderr
+}
+
+// config is the active configuration; loggers read it on every record
+type config struct {
+	handler    slog.Handler
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+// levelFor returns the lowest level a component logs
+func (c *config) levelFor(component string) slog.Level {
+	if level, ok := c.components[component]; ok {
+		return level
+	}
+	return c.level
+}
+
+var (
+	active atomic.Pointer[config]
+
+	registryMu sync.Mutex
+	registry   = make(map[string]bool)
+)
+
+func init() {
+	active.Store(&config{
+		handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// ParseLevel converts debug, info, warn or error to a slog.Level
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+}
+
+// Configure replaces the output, format and levels of every logger
+// Nothing changes if the options are invalid.
+func Configure(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	components := make(map[string]slog.Level, len(opts.Components))
+	for component, name := range opts.Components {
+		componentLevel, err := ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+		components[component] = componentLevel
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stderr
+	}
+	// Levels are checked by the loggers, so the handler passes everything it is given
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch opts.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(output, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(output, handlerOpts)
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", opts.Format)
+	}
+
+	active.Store(&config{handler: handler, level: level, components: components})
+	slog.SetDefault(slog.New(&componentHandler{}))
+	return nil
+}
+
+// Logger returns the logger of a component, e.g. "nat"
+// The component is added to every record and selects its level in Options.Components.
+func Logger(component string) *slog.Logger {
+	registryMu.Lock()
+	registry[component] = true
+	registryMu.Unlock()
+
+	return slog.New(&componentHandler{component: component})
+}
+
+// Components lists the components that have taken a logger, for validating Options.Components
+func Components() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// componentHandler checks a component's level and forwards records to the active handler
+type componentHandler struct {
+	component string
+	derive    []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+
+	cache atomic.Pointer[cachedHandler]
+}
+
+// cachedHandler is the active handler with the component's attributes applied
+type cachedHandler struct {
+	config  *config
+	handler slog.Handler
+}
+
+// Enabled reports whether the component logs at level
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= active.Load().levelFor(h.component)
+}
+
+// Handle writes a record through the active handler
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+// handler returns the active handler with the component's attributes, rebuilding it after Configure
+func (h *componentHandler) handler() slog.Handler {
+	cfg := active.Load()
+	if cached := h.cache.Load(); cached != nil && cached.config == cfg {
+		return cached.handler
+	}
+
+	handler := cfg.handler
+	if h.component != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String(ComponentKey, h.component)})
+	}
+	for _, derive := range h.derive {
+		handler = derive(handler)
+	}
+	h.cache.Store(&cachedHandler{config: cfg, handler: handler})
+	return handler
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+// WithGroup returns a handler that nests later attributes under name
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+// with copies the handler with one more derivation
+func (h *componentHandler) with(derive func(slog.Handler) slog.Handler) *componentHandler {
+	derived := make([]func(slog.Handler) slog.Handler, len(h.derive), len(h.derive)+1)
+	copy(derived, h.derive)
+	return &componentHandler{component: h.component, derive: append(derived, derive)}
+}
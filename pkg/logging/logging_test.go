@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configure sets up logging into a buffer for one test
+func configure(t *testing.T, opts Options) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	opts.Output = &buf
+	if err := Configure(opts); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	t.Cleanup(func() { Configure(Options{}) })
+	return &buf
+}
+
+// TestComponentLevels tests the default level and per-component overrides
+func TestComponentLevels(t *testing.T) {
+	nat := Logger("test-nat")
+	router := Logger("test-router")
+	buf := configure(t, Options{Level: "warn", Components: map[string]string{"test-nat": "debug"}})
+
+	nat.Debug("probing")
+	router.Info("frame forwarded")
+	router.Warn("queue full")
+
+	out := buf.String()
+	if !strings.Contains(out, "msg=probing component=test-nat") {
+		t.Errorf("Debug record of a debug component missing:\n%s", out)
+	}
+	if strings.Contains(out, "frame forwarded") {
+		t.Errorf("Info record logged below the warn level:\n%s", out)
+	}
+	if !strings.Contains(out, "msg=\"queue full\" component=test-router") {
+		t.Errorf("Warning missing:\n%s", out)
+	}
+}
+
+// TestJSONFormat tests JSON output, attributes added with With, and reconfiguring existing loggers
+func TestJSONFormat(t *testing.T) {
+	logger := Logger("test-json").With("peer", "192.0.2.1:9001")
+	configure(t, Options{})
+	logger.Info("before") // Caches the text handler
+	buf := configure(t, Options{Format: "json"})
+
+	logger.Info("connected", "rtt_ms", 12)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Output is not one JSON record (%v):\n%s", err, buf)
+	}
+	if record["msg"] != "connected" || record[ComponentKey] != "test-json" || record["peer"] != "192.0.2.1:9001" || record["rtt_ms"] != 12.0 {
+		t.Errorf("Unexpected record %v", record)
+	}
+}
+
+// TestStandardLog tests that the standard logger is routed through the handler
+func TestStandardLog(t *testing.T) {
+	buf := configure(t, Options{Format: "json"})
+	log.Printf("legacy %d", 1)
+	if !strings.Contains(buf.String(), `"msg":"legacy 1"`) {
+		t.Errorf("Standard log output not routed:\n%s", buf)
+	}
+}
+
+// TestConfigureInvalid tests that invalid options are rejected
+func TestConfigureInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Level: "verbose"},
+		{Format: "xml"},
+		{Components: map[string]string{"nat": "loud"}},
+	} {
+		if err := Configure(opts); err == nil {
+			t.Errorf("Configure(%+v) succeeded", opts)
+		}
+	}
+}
+
+// TestLimiter tests that repeated records are dropped and counted
+func TestLimiter(t *testing.T) {
+	logger := Logger("test-limiter")
+	buf := configure(t, Options{})
+	limiter := NewLimiter(50 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		limiter.Warn(logger, "pipeline full")
+	}
+	time.Sleep(60 * time.Millisecond)
+	limiter.Warn(logger, "pipeline full")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %d:\n%s", len(lines), buf)
+	}
+	if strings.Contains(lines[0], "suppressed") || !strings.Contains(lines[1], "suppressed=4") {
+		t.Errorf("Unexpected suppression counts:\n%s", buf)
+	}
+
+	// Records below the level neither log nor count
+	limiter = NewLimiter(time.Hour)
+	limiter.Log(logger, -8, "trace") // Below debug
+	if ok, suppressed := limiter.Allow(); !ok || suppressed != 0 {
+		t.Errorf("Disabled record consumed the limiter: %v, %d", ok, suppressed)
+	}
+}
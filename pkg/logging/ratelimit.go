@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Limiter lets a repeated message through at most once per interval
+// Use one per call site on hot paths (e.g. "pipeline full" on every dropped frame);
+// the next record that gets through reports how many were suppressed.
+// Thread-safe: shared by every goroutine reaching the call site.
+type Limiter struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	next       time.Time
+	suppressed int
+}
+
+// NewLimiter creates a limiter allowing one record per interval
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{interval: interval}
+}
+
+// Allow reports whether to log now, and how many records were suppressed since the last one
+func (l *Limiter) Allow() (bool, int) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.next) {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.next = now.Add(l.interval)
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// Log writes a record if the logger is enabled for level and the limiter allows it
+// A "suppressed" attribute counts the records dropped since the previous one.
+func (l *Limiter) Log(logger *slog.Logger, level slog.Level, msg string, args ...any) {
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	ok, suppressed := l.Allow()
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	logger.Log(context.Background(), level, msg, args...)
+}
+
+// Warn is Log at warning level
+func (l *Limiter) Warn(logger *slog.Logger, msg string, args ...any) {
+	l.Log(logger, slog.LevelWarn, msg, args...)
+}
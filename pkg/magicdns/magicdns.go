@@ -0,0 +1,294 @@
+// Package magicdns resolves mesh peer names to their tunnel addresses
+//
+// A Server answers <name>.<domain> (mesh.internal by default) from a Resolver, such as
+// the daemon's peer table, and forwards every other query to upstream servers. On
+// Linux a Configurator makes systemd-resolved send queries for the domain to the
+// server over the tunnel device (split DNS), leaving all other lookups as they were.
+package magicdns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultDomain is the domain peer names are resolved under
+const DefaultDomain = "mesh.internal"
+
+// recordTTL is the TTL of answers for peer names, short because peers come and go
+const recordTTL = 10
+
+// forwardTimeout bounds one upstream exchange
+const forwardTimeout = 5 * time.Second
+
+// Resolver returns the tunnel addresses of a peer name (a Label), or nil if no peer has it
+type Resolver func(name string) []netip.Addr
+
+// Config describes a Server
+type Config struct {
+	Listen   []netip.AddrPort // Addresses served on, over UDP and TCP; port 0 picks a free port
+	Domain   string           // Domain peer names are resolved under (default: DefaultDomain)
+	Upstream []netip.AddrPort // Servers other queries are forwarded to, in order; none refuses them
+	Resolve  Resolver
+}
+
+// Server answers queries for peer names and forwards the rest
+type Server struct {
+	config Config
+	domain string // Lower-case FQDN, e.g. "mesh.internal."
+	client *dns.Client
+
+	mu      sync.Mutex
+	servers []*dns.Server
+	listen  []netip.AddrPort // Bound addresses, with the ports picked for port 0
+}
+
+// NewServer creates a server; Start binds it
+func NewServer(config Config) *Server {
+	domain := config.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	return &Server{
+		config: config,
+		domain: dns.Fqdn(strings.ToLower(domain)),
+		client: &dns.Client{Timeout: forwardTimeout},
+	}
+}
+
+// Start binds every listen address over UDP and TCP and serves queries in the background
+// If an address cannot be bound, the ones already bound are closed again.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, addr := range s.config.Listen {
+		packetConn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(addr))
+		if err != nil {
+			s.shutdown()
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		// TCP on the same port, also when the UDP port was picked
+		bound := packetConn.LocalAddr().(*net.UDPAddr).AddrPort()
+		listener, err := net.ListenTCP("tcp", net.TCPAddrFromAddrPort(bound))
+		if err != nil {
+			packetConn.Close()
+			s.shutdown()
+			return fmt.Errorf("failed to listen on %s: %w", bound, err)
+		}
+
+		udpServer := &dns.Server{PacketConn: packetConn, Handler: s}
+		tcpServer := &dns.Server{Listener: listener, Handler: s}
+		s.servers = append(s.servers, udpServer, tcpServer)
+		s.listen = append(s.listen, netip.AddrPortFrom(bound.Addr().Unmap(), bound.Port()))
+		go udpServer.ActivateAndServe()
+		go tcpServer.ActivateAndServe()
+	}
+	return nil
+}
+
+// Listen returns the addresses served on, with the ports picked for port 0
+func (s *Server) Listen() []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]netip.AddrPort(nil), s.listen...)
+}
+
+// Upstream returns the servers other queries are forwarded to
+func (s *Server) Upstream() []netip.AddrPort {
+	return s.config.Upstream
+}
+
+// Close stops serving
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown()
+}
+
+// shutdown stops all servers (s.mu held)
+func (s *Server) shutdown() error {
+	var errs []error
+	for _, server := range s.servers {
+		if err := server.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.servers, s.listen = nil, nil
+	return errors.Join(errs...)
+}
+
+// ServeDNS answers a query for the mesh domain, or forwards it upstream
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if len(req.Question) != 1 || req.Opcode != dns.OpcodeQuery {
+		reply := new(dns.Msg)
+		w.WriteMsg(reply.SetRcode(req, dns.RcodeFormatError))
+		return
+	}
+
+	name := strings.ToLower(req.Question[0].Name)
+	if name == s.domain || strings.HasSuffix(name, "."+s.domain) {
+		w.WriteMsg(s.answer(req, name))
+		return
+	}
+
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+	w.WriteMsg(s.forward(req, network))
+}
+
+// answer resolves a name within the mesh domain
+// Only direct children of the domain are peer names; anything deeper does not exist.
+func (s *Server) answer(req *dns.Msg, name string) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.Authoritative = true
+	reply.RecursionAvailable = len(s.config.Upstream) > 0
+
+	label := strings.TrimSuffix(strings.TrimSuffix(name, s.domain), ".")
+	var addrs []netip.Addr
+	if label != "" && !strings.Contains(label, ".") && s.config.Resolve != nil {
+		addrs = s.config.Resolve(label)
+	}
+	if label != "" && len(addrs) == 0 {
+		reply.Rcode = dns.RcodeNameError
+	}
+
+	question := req.Question[0]
+	for _, addr := range addrs {
+		header := dns.RR_Header{Name: question.Name, Class: dns.ClassINET, Ttl: recordTTL}
+		switch {
+		case addr.Is4() && (question.Qtype == dns.TypeA || question.Qtype == dns.TypeANY):
+			header.Rrtype = dns.TypeA
+			reply.Answer = append(reply.Answer, &dns.A{Hdr: header, A: addr.AsSlice()})
+		case addr.Is6() && (question.Qtype == dns.TypeAAAA || question.Qtype == dns.TypeANY):
+			header.Rrtype = dns.TypeAAAA
+			reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: header, AAAA: addr.AsSlice()})
+		}
+	}
+
+	if len(reply.Answer) == 0 {
+		// Negative answers carry the SOA, so resolvers cache them only briefly
+		if label == "" && question.Qtype == dns.TypeSOA {
+			reply.Answer = append(reply.Answer, s.soa())
+		} else {
+			reply.Ns = append(reply.Ns, s.soa())
+		}
+	}
+	return reply
+}
+
+// soa returns the synthesized SOA record of the mesh domain
+func (s *Server) soa() *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: recordTTL},
+		Ns:      "ns." + s.domain,
+		Mbox:    "hostmaster." + s.domain,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  recordTTL,
+	}
+}
+
+// forward passes a query to the upstream servers in turn and returns the first answer
+func (s *Server) forward(req *dns.Msg, network string) *dns.Msg {
+	reply := new(dns.Msg)
+	if len(s.config.Upstream) == 0 {
+		return reply.SetRcode(req, dns.RcodeRefused)
+	}
+
+	client := *s.client
+	client.Net = network
+	for _, upstream := range s.config.Upstream {
+		if response, _, err := client.Exchange(req, upstream.String()); err == nil {
+			return response
+		}
+	}
+	return reply.SetRcode(req, dns.RcodeServerFailure)
+}
+
+// Label turns a peer name into a DNS label: lower case, with runs of other characters
+// than letters, digits and hyphens replaced by a hyphen
+// Returns "" if nothing usable is left.
+func Label(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	label := strings.TrimRight(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// ValidDomain reports whether domain is a usable domain name for the mesh, such as mesh.internal
+func ValidDomain(domain string) bool {
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || Label(label) != strings.ToLower(label) {
+			return false
+		}
+	}
+	_, ok := dns.IsDomainName(domain)
+	return ok
+}
+
+// SystemUpstream returns the name servers in a resolv.conf file, such as /etc/resolv.conf
+func SystemUpstream(path string) ([]netip.AddrPort, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var servers []netip.AddrPort
+	for _, server := range config.Servers {
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			continue // Scoped IPv6 addresses and the like
+		}
+		port := uint16(53)
+		fmt.Sscanf(config.Port, "%d", &port)
+		servers = append(servers, netip.AddrPortFrom(addr, port))
+	}
+	return servers, nil
+}
+
+// Configurator points the host's resolver at the server for the mesh domain only (split DNS)
+type Configurator interface {
+	// Configure sends queries for domain to servers (port 53) over device
+	Configure(device string, servers []netip.Addr, domain string) error
+	// Revert removes what Configure set
+	Revert() error
+}
+
+// NewConfigurator returns the Configurator for this host: systemd-resolved on Linux
+func NewConfigurator() Configurator {
+	return newSystemConfigurator()
+}
+
+// NopConfigurator leaves the host's resolver alone
+// For devices outside the host network stack, such as a layer2.PipeDevice.
+type NopConfigurator struct{}
+
+func (NopConfigurator) Configure(string, []netip.Addr, string) error { return nil }
+func (NopConfigurator) Revert() error                                { return nil }
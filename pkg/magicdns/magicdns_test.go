@@ -0,0 +1,171 @@
+package magicdns
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startServer starts a server on a loopback port resolving names from peers
+func startServer(t *testing.T, peers map[string][]netip.Addr, upstream []netip.AddrPort) netip.AddrPort {
+	t.Helper()
+
+	server := NewServer(Config{
+		Listen:   []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Upstream: upstream,
+		Resolve:  func(name string) []netip.Addr { return peers[name] },
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server.Listen()[0]
+}
+
+// query sends one question to server
+func query(t *testing.T, network string, server netip.AddrPort, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	client := &dns.Client{Net: network}
+	reply, _, err := client.Exchange(req, server.String())
+	if err != nil {
+		t.Fatalf("query %s %s over %s: %v", name, dns.TypeToString[qtype], network, err)
+	}
+	return reply
+}
+
+// answers returns the addresses in a reply's answer section
+func answers(reply *dns.Msg) []string {
+	var addrs []string
+	for _, rr := range reply.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, rr.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, rr.AAAA.String())
+		}
+	}
+	return addrs
+}
+
+// TestServerPeerNames tests answers for names in the mesh domain
+func TestServerPeerNames(t *testing.T) {
+	server := startServer(t, map[string][]netip.Addr{
+		"alice": {netip.MustParseAddr("10.77.0.1"), netip.MustParseAddr("fd77::1")},
+	}, nil)
+
+	for _, network := range []string{"udp", "tcp"} {
+		reply := query(t, network, server, "Alice.Mesh.Internal.", dns.TypeA)
+		if reply.Rcode != dns.RcodeSuccess || !reply.Authoritative || !slices.Equal(answers(reply), []string{"10.77.0.1"}) {
+			t.Errorf("A over %s = %s %v, want 10.77.0.1", network, dns.RcodeToString[reply.Rcode], answers(reply))
+		}
+	}
+
+	if reply := query(t, "udp", server, "alice.mesh.internal.", dns.TypeAAAA); !slices.Equal(answers(reply), []string{"fd77::1"}) {
+		t.Errorf("AAAA = %v, want fd77::1", answers(reply))
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"bob.mesh.internal.", dns.TypeA, dns.RcodeNameError},
+		{"www.alice.mesh.internal.", dns.TypeA, dns.RcodeNameError},
+		{"mesh.internal.", dns.TypeA, dns.RcodeSuccess},
+		{"alice.mesh.internal.", dns.TypeMX, dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		reply := query(t, "udp", server, tt.name, tt.qtype)
+		if reply.Rcode != tt.rcode || len(reply.Answer) != 0 {
+			t.Errorf("%s %s = %s with %d answers, want %s without", tt.name, dns.TypeToString[tt.qtype], dns.RcodeToString[reply.Rcode], len(reply.Answer), dns.RcodeToString[tt.rcode])
+		}
+		if len(reply.Ns) != 1 || reply.Ns[0].Header().Rrtype != dns.TypeSOA {
+			t.Errorf("%s: negative answer without SOA: %v", tt.name, reply.Ns)
+		}
+	}
+}
+
+// TestServerForward tests that other names are forwarded upstream, and refused without one
+func TestServerForward(t *testing.T) {
+	refusing := startServer(t, nil, nil)
+	// The upstream only knows its own mesh domain, under which it resolves "www"
+	upstreamServer := NewServer(Config{
+		Listen:  []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Domain:  "example.com",
+		Resolve: func(name string) []netip.Addr { return []netip.Addr{netip.MustParseAddr("192.0.2.80")} },
+	})
+	if err := upstreamServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer upstreamServer.Close()
+
+	// An unreachable first upstream is skipped
+	dead := netip.MustParseAddrPort("127.0.0.1:1")
+	server := startServer(t, nil, []netip.AddrPort{dead, upstreamServer.Listen()[0]})
+
+	for _, network := range []string{"udp", "tcp"} {
+		reply := query(t, network, server, "www.example.com.", dns.TypeA)
+		if reply.Rcode != dns.RcodeSuccess || !slices.Equal(answers(reply), []string{"192.0.2.80"}) {
+			t.Errorf("forwarded over %s = %s %v, want 192.0.2.80", network, dns.RcodeToString[reply.Rcode], answers(reply))
+		}
+	}
+
+	if reply := query(t, "udp", refusing, "www.example.com.", dns.TypeA); reply.Rcode != dns.RcodeRefused {
+		t.Errorf("without upstream = %s, want REFUSED", dns.RcodeToString[reply.Rcode])
+	}
+}
+
+// TestLabel tests turning peer names into DNS labels
+func TestLabel(t *testing.T) {
+	tests := map[string]string{
+		"alice":          "alice",
+		"Bob's Laptop":   "bob-s-laptop",
+		"node_01.office": "node-01-office",
+		"--edge--":       "edge",
+		"ünïcode":        "n-code",
+		"...":            "",
+	}
+	for name, want := range tests {
+		if got := Label(name); got != want {
+			t.Errorf("Label(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestValidDomain tests mesh domain validation
+func TestValidDomain(t *testing.T) {
+	for domain, want := range map[string]bool{
+		"mesh.internal": true,
+		"corp":          true,
+		"":              false,
+		"mesh.":         false,
+		"mesh..local":   false,
+		"my_mesh.local": false,
+	} {
+		if got := ValidDomain(domain); got != want {
+			t.Errorf("ValidDomain(%q) = %v, want %v", domain, got, want)
+		}
+	}
+}
+
+// TestSystemUpstream tests reading name servers from resolv.conf
+func TestSystemUpstream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("# generated\nnameserver 192.0.2.53\nnameserver 2001:db8::53\nsearch example.com\n"), 0644)
+
+	servers, err := SystemUpstream(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.AddrPort{netip.MustParseAddrPort("192.0.2.53:53"), netip.MustParseAddrPort("[2001:db8::53]:53")}
+	if !slices.Equal(servers, want) {
+		t.Errorf("SystemUpstream() = %v, want %v", servers, want)
+	}
+}
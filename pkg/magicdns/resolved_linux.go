@@ -0,0 +1,87 @@
+package magicdns
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	resolvedService = "org.freedesktop.resolve1"
+	resolvedPath    = "/org/freedesktop/resolve1"
+	resolvedManager = resolvedService + ".Manager"
+)
+
+// resolvedConfigurator sets per-link DNS in systemd-resolved over D-Bus
+// The settings belong to the tunnel device and disappear with it; Revert removes them earlier.
+type resolvedConfigurator struct {
+	ifindex int32 // Link configured; 0 if none
+}
+
+// linkDNS is a server in SetLinkDNS, D-Bus signature (iay)
+type linkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+// linkDomain is a domain in SetLinkDomains, D-Bus signature (sb)
+type linkDomain struct {
+	Domain      string
+	RoutingOnly bool // Only route queries for the domain to the link, do not search it
+}
+
+func newSystemConfigurator() Configurator { return &resolvedConfigurator{} }
+
+// Configure makes systemd-resolved send queries for domain, and only those, to servers on device
+func (c *resolvedConfigurator) Configure(device string, servers []netip.Addr, domain string) error {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return fmt.Errorf("split DNS: %w", err)
+	}
+	ifindex := int32(iface.Index)
+
+	var dnsServers []linkDNS
+	for _, server := range servers {
+		family := int32(syscall.AF_INET)
+		if server.Is6() {
+			family = syscall.AF_INET6
+		}
+		dnsServers = append(dnsServers, linkDNS{Family: family, Address: server.AsSlice()})
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("split DNS: failed to connect to the system bus: %w", err)
+	}
+	resolved := conn.Object(resolvedService, resolvedPath)
+	if call := resolved.Call(resolvedManager+".SetLinkDNS", 0, ifindex, dnsServers); call.Err != nil {
+		return fmt.Errorf("split DNS: systemd-resolved SetLinkDNS failed: %w", call.Err)
+	}
+	c.ifindex = ifindex
+	if call := resolved.Call(resolvedManager+".SetLinkDomains", 0, ifindex, []linkDomain{{Domain: domain, RoutingOnly: true}}); call.Err != nil {
+		return fmt.Errorf("split DNS: systemd-resolved SetLinkDomains failed: %w", call.Err)
+	}
+	// Keep other lookups off the link; older systemd versions lack this and route by domain only
+	resolved.Call(resolvedManager+".SetLinkDefaultRoute", 0, ifindex, false)
+	return nil
+}
+
+// Revert drops the link's DNS settings
+func (c *resolvedConfigurator) Revert() error {
+	if c.ifindex == 0 {
+		return nil
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("split DNS: failed to connect to the system bus: %w", err)
+	}
+	call := conn.Object(resolvedService, resolvedPath).Call(resolvedManager+".RevertLink", 0, c.ifindex)
+	c.ifindex = 0
+	if call.Err != nil {
+		return fmt.Errorf("split DNS: systemd-resolved RevertLink failed: %w", call.Err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package magicdns
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+)
+
+// systemConfigurator is not implemented off Linux; peer names still resolve through the server's address
+type systemConfigurator struct{}
+
+func newSystemConfigurator() Configurator { return systemConfigurator{} }
+
+func (systemConfigurator) Configure(string, []netip.Addr, string) error {
+	return fmt.Errorf("split DNS is not supported on %s", runtime.GOOS)
+}
+
+func (systemConfigurator) Revert() error { return nil }
@@ -0,0 +1,383 @@
+package meshtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// IP protocol numbers and header sizes the host understands
+const (
+	protoICMP = 1
+	protoTCP  = 6
+
+	ipv4HeaderSize = 20
+	icmpHeaderSize = 8
+
+	arpRequest = 1
+	arpReply   = 2
+)
+
+// errClosed is returned by operations on a closed host or connection
+var errClosed = errors.New("closed")
+
+// broadcastMAC is the Ethernet broadcast address, used for ARP requests
+var broadcastMAC = [6]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// Host is a minimal IPv4 host on the host side of a PipeDevice
+// It answers ARP (TAP mode) and ICMP echo requests, sends pings and carries TCP
+// connections (see Dial and Listen). Received packets with a bad checksum are
+// dropped and counted, so tests notice when the tunnel corrupts a packet.
+//
+// Thread-safe: all methods may be called concurrently.
+type Host struct {
+	device *layer2.PipeDevice
+	addr   netip.Addr
+	mac    [6]byte
+
+	mu        sync.Mutex
+	neighbors map[netip.Addr][6]byte       // Resolved by ARP
+	resolved  map[netip.Addr]chan struct{} // Closed when an ARP reply arrives
+	pings     map[uint16]chan struct{}     // Echo requests awaiting a reply, by sequence
+	conns     map[connKey]*Conn            // Open TCP connections
+	listeners map[uint16]chan *Conn        // Accept queues, by local port
+	nextPort  uint16
+	nextSeq   uint16
+	ipID      uint16
+
+	checksumErrors atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHost attaches a host with address addr to device and starts processing its output
+// The device must be in TAP or TUN mode; in TAP mode the host's MAC address is
+// derived from addr.
+func NewHost(device *layer2.PipeDevice, addr netip.Addr) *Host {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := addr.As4()
+
+	h := &Host{
+		device:    device,
+		addr:      addr,
+		mac:       [6]byte{0x02, 0x00, a[0], a[1], a[2], a[3]}, // Locally administered
+		neighbors: make(map[netip.Addr][6]byte),
+		resolved:  make(map[netip.Addr]chan struct{}),
+		pings:     make(map[uint16]chan struct{}),
+		conns:     make(map[connKey]*Conn),
+		listeners: make(map[uint16]chan *Conn),
+		nextPort:  40000,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	h.wg.Add(1)
+	go h.receiveLoop()
+	return h
+}
+
+// Addr returns the host's IPv4 address
+func (h *Host) Addr() netip.Addr {
+	return h.addr
+}
+
+// ChecksumErrors returns the number of received packets dropped for a bad checksum
+func (h *Host) ChecksumErrors() uint64 {
+	return h.checksumErrors.Load()
+}
+
+// Close stops the host; open connections fail
+func (h *Host) Close() {
+	h.cancel()
+	h.wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.conns {
+		c.fail(errClosed)
+	}
+}
+
+// Ping sends an ICMP echo request with size bytes of random data to dst and waits for the reply
+// Returns the round trip time.
+func (h *Host) Ping(ctx context.Context, dst netip.Addr, size int) (time.Duration, error) {
+	h.mu.Lock()
+	h.nextSeq++
+	seq := h.nextSeq
+	reply := make(chan struct{})
+	h.pings[seq] = reply
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.pings, seq)
+		h.mu.Unlock()
+	}()
+
+	echo := make([]byte, icmpHeaderSize+size)
+	echo[0] = 8 // Echo request
+	binary.BigEndian.PutUint16(echo[4:6], 1)
+	binary.BigEndian.PutUint16(echo[6:8], seq)
+	rand.Read(echo[icmpHeaderSize:]) // Incompressible, so the size on the wire is the size asked for
+	binary.BigEndian.PutUint16(echo[2:4], checksum(echo, 0))
+
+	start := time.Now()
+	if err := h.sendIP(ctx, dst, protoICMP, echo); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-reply:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("no echo reply from %s: %w", dst, ctx.Err())
+	case <-h.ctx.Done():
+		return 0, errClosed
+	}
+}
+
+// sendIP sends an IPv4 packet to dst, resolving its MAC address first in TAP mode
+func (h *Host) sendIP(ctx context.Context, dst netip.Addr, proto byte, payload []byte) error {
+	h.mu.Lock()
+	h.ipID++
+	id := h.ipID
+	h.mu.Unlock()
+
+	packet := make([]byte, ipv4HeaderSize+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint16(packet[4:6], id)
+	packet[8] = 64 // TTL
+	packet[9] = proto
+	src, dstBytes := h.addr.As4(), dst.As4()
+	copy(packet[12:16], src[:])
+	copy(packet[16:20], dstBytes[:])
+	binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:ipv4HeaderSize], 0))
+	copy(packet[ipv4HeaderSize:], payload)
+
+	if h.device.Layer() == layer2.Layer3 {
+		return h.device.Inject(packet)
+	}
+
+	mac, err := h.resolve(ctx, dst)
+	if err != nil {
+		return err
+	}
+	frame := &layer2.EthernetFrame{
+		DestinationMAC: mac,
+		SourceMAC:      h.mac,
+		EtherType:      layer2.EtherTypeIPv4,
+		Payload:        packet,
+	}
+	return h.device.Inject(frame.Serialize())
+}
+
+// resolve returns the MAC address of dst, asking with ARP requests until it answers
+func (h *Host) resolve(ctx context.Context, dst netip.Addr) ([6]byte, error) {
+	for {
+		h.mu.Lock()
+		if mac, ok := h.neighbors[dst]; ok {
+			h.mu.Unlock()
+			return mac, nil
+		}
+		wait, ok := h.resolved[dst]
+		if !ok {
+			wait = make(chan struct{})
+			h.resolved[dst] = wait
+		}
+		h.mu.Unlock()
+
+		if err := h.sendARP(arpRequest, broadcastMAC, dst); err != nil {
+			return [6]byte{}, err
+		}
+
+		select {
+		case <-wait:
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return [6]byte{}, fmt.Errorf("no ARP reply from %s: %w", dst, ctx.Err())
+		case <-h.ctx.Done():
+			return [6]byte{}, errClosed
+		}
+	}
+}
+
+// sendARP sends an ARP request for target, or a reply to the host at dstMAC
+func (h *Host) sendARP(op uint16, dstMAC [6]byte, target netip.Addr) error {
+	arp := make([]byte, 28)
+	binary.BigEndian.PutUint16(arp[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(arp[2:4], layer2.EtherTypeIPv4)
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:8], op)
+	copy(arp[8:14], h.mac[:])
+	src, dst := h.addr.As4(), target.As4()
+	copy(arp[14:18], src[:])
+	if op == arpReply {
+		copy(arp[18:24], dstMAC[:])
+	}
+	copy(arp[24:28], dst[:])
+
+	frame := &layer2.EthernetFrame{
+		DestinationMAC: dstMAC,
+		SourceMAC:      h.mac,
+		EtherType:      layer2.EtherTypeARP,
+		Payload:        arp,
+	}
+	return h.device.Inject(frame.Serialize())
+}
+
+// receiveLoop handles what the daemon writes to the device
+func (h *Host) receiveLoop() {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case data := <-h.device.Output():
+			if h.device.Layer() == layer2.Layer3 {
+				h.handleIP(data)
+				continue
+			}
+
+			frame, err := layer2.ParseFrame(data)
+			if err != nil {
+				continue
+			}
+			if frame.DestinationMAC != h.mac && frame.DestinationMAC != broadcastMAC {
+				continue
+			}
+			switch frame.EtherType {
+			case layer2.EtherTypeARP:
+				h.handleARP(frame.Payload)
+			case layer2.EtherTypeIPv4:
+				h.handleIP(frame.Payload)
+			}
+		}
+	}
+}
+
+// handleARP answers requests for our address and records the sender
+func (h *Host) handleARP(arp []byte) {
+	if len(arp) < 28 {
+		return
+	}
+	var senderMAC [6]byte
+	copy(senderMAC[:], arp[8:14])
+	sender := netip.AddrFrom4([4]byte(arp[14:18]))
+	target := netip.AddrFrom4([4]byte(arp[24:28]))
+
+	op := binary.BigEndian.Uint16(arp[6:8])
+	if op == arpRequest && target != h.addr {
+		return
+	}
+
+	// Learn the sender from requests too, as it is about to talk to us
+	h.mu.Lock()
+	h.neighbors[sender] = senderMAC
+	if wait, ok := h.resolved[sender]; ok {
+		close(wait)
+		delete(h.resolved, sender)
+	}
+	h.mu.Unlock()
+
+	if op == arpRequest {
+		h.sendARP(arpReply, senderMAC, sender)
+	}
+}
+
+// handleIP dispatches an IPv4 packet addressed to the host
+func (h *Host) handleIP(packet []byte) {
+	if len(packet) < ipv4HeaderSize || packet[0]>>4 != 4 {
+		return
+	}
+	headerLen := int(packet[0]&0x0F) * 4
+	total := int(binary.BigEndian.Uint16(packet[2:4]))
+	if headerLen < ipv4HeaderSize || total < headerLen || total > len(packet) {
+		return
+	}
+	if checksum(packet[:headerLen], 0) != 0 {
+		h.checksumErrors.Add(1)
+		return
+	}
+
+	src := netip.AddrFrom4([4]byte(packet[12:16]))
+	dst := netip.AddrFrom4([4]byte(packet[16:20]))
+	if dst != h.addr {
+		return
+	}
+
+	payload := packet[headerLen:total]
+	switch packet[9] {
+	case protoICMP:
+		h.handleICMP(src, payload)
+	case protoTCP:
+		if checksum(payload, pseudoHeaderSum(src, dst, protoTCP, len(payload))) != 0 {
+			h.checksumErrors.Add(1)
+			return
+		}
+		h.handleTCP(src, payload)
+	}
+}
+
+// handleICMP answers echo requests and completes pings
+func (h *Host) handleICMP(src netip.Addr, icmp []byte) {
+	if len(icmp) < icmpHeaderSize {
+		return
+	}
+	if checksum(icmp, 0) != 0 {
+		h.checksumErrors.Add(1)
+		return
+	}
+
+	switch icmp[0] {
+	case 8: // Echo request
+		reply := append([]byte(nil), icmp...)
+		reply[0] = 0
+		reply[2], reply[3] = 0, 0
+		binary.BigEndian.PutUint16(reply[2:4], checksum(reply, 0))
+		go h.sendIP(h.ctx, src, protoICMP, reply)
+
+	case 0: // Echo reply
+		seq := binary.BigEndian.Uint16(icmp[6:8])
+		h.mu.Lock()
+		if reply, ok := h.pings[seq]; ok {
+			close(reply)
+			delete(h.pings, seq)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// checksum returns the Internet checksum (RFC 1071) of data, starting from a partial sum
+// Over data that includes a valid checksum field, the result is 0.
+func checksum(data []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+// pseudoHeaderSum returns the partial checksum of the IPv4 pseudo header for TCP and UDP
+func pseudoHeaderSum(src, dst netip.Addr, proto byte, length int) uint32 {
+	s, d := src.As4(), dst.As4()
+	sum := uint32(binary.BigEndian.Uint16(s[0:2])) + uint32(binary.BigEndian.Uint16(s[2:4]))
+	sum += uint32(binary.BigEndian.Uint16(d[0:2])) + uint32(binary.BigEndian.Uint16(d[2:4]))
+	return sum + uint32(proto) + uint32(length)
+}
@@ -0,0 +1,968 @@
+package meshtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/miekg/dns"
+	"github.com/shadowmesh/shadowmesh/pkg/acl"
+	"github.com/shadowmesh/shadowmesh/pkg/daemonmgr"
+	"github.com/shadowmesh/shadowmesh/pkg/exitnode"
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+	"github.com/shadowmesh/shadowmesh/pkg/magicdns"
+)
+
+// testKey is the pre-shared key of the test daemons
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// testPeers are the peer IDs whose identity keys every test daemon trusts
+var testPeers = []string{"alice", "bob", "carol", "dave", "mallory"}
+
+// identitySeed returns a test daemon's identity.key, derived from its peer ID
+func identitySeed(peerID string) []byte {
+	seed := sha256.Sum256([]byte("identity " + peerID))
+	return seed[:]
+}
+
+// identityPublic returns the public key for identity.peers of a test daemon
+func identityPublic(peerID string) string {
+	return hex.EncodeToString(ed25519.NewKeyFromSeed(identitySeed(peerID)).Public().(ed25519.PublicKey))
+}
+
+// startDaemon starts a daemon on a pipe device, connected to relay
+// prefix may be daemonmgr.AutoAddress for a relay with an address pool.
+// configure, if not nil, adjusts the daemon's configuration before it starts.
+func startDaemon(t *testing.T, relay *Relay, mode, prefix, peerID string, configure func(*daemonmgr.DaemonConfig)) (*daemonmgr.DaemonManager, *layer2.PipeDevice) {
+	t.Helper()
+
+	dm, device := newDaemon(t, relay, mode, prefix, peerID, configure)
+	runDaemon(t, dm, device, prefix, peerID)
+	return dm, device
+}
+
+// newDaemon creates a daemon on a pipe device like startDaemon, leaving it to the caller to start with runDaemon
+func newDaemon(t *testing.T, relay *Relay, mode, prefix, peerID string, configure func(*daemonmgr.DaemonConfig)) (*daemonmgr.DaemonManager, *layer2.PipeDevice) {
+	t.Helper()
+
+	config := &daemonmgr.DaemonConfig{}
+	config.Daemon.Socket = filepath.Join(t.TempDir(), "daemon.sock")
+	config.Network.Mode = mode
+	config.Network.LocalIP = prefix
+	config.Encryption.Key = testKey
+	config.Relay.Enabled = true
+	config.Relay.Server = relay.URL()
+	config.Peer.ID = peerID
+	config.Identity.Key = hex.EncodeToString(identitySeed(peerID))
+	config.Identity.Peers = make(map[string]string)
+	for _, name := range testPeers {
+		config.Identity.Peers[name] = identityPublic(name)
+	}
+	config.Compression.Enabled = true
+	config.PathMTU.MaxSize = 1200 // Below the device MTU, so that large packets are fragmented in the tunnel
+	if configure != nil {
+		configure(config)
+	}
+
+	dm, err := daemonmgr.NewDaemonManager(config)
+	if err != nil {
+		t.Fatalf("NewDaemonManager() failed: %v", err)
+	}
+
+	device, err := layer2.NewPipeDevice(layer2.DeviceConfig{Mode: mode, Name: peerID, MTU: 1400})
+	if err != nil {
+		t.Fatalf("NewPipeDevice() failed: %v", err)
+	}
+	dm.SetNetworkDevice(device)
+	dm.SetExitNodeController(exitnode.NopController{})
+	dm.SetDNSConfigurator(magicdns.NopConfigurator{})
+	return dm, device
+}
+
+// runDaemon starts a daemon from newDaemon and waits until it is connected
+func runDaemon(t *testing.T, dm *daemonmgr.DaemonManager, device *layer2.PipeDevice, prefix, peerID string) {
+	t.Helper()
+
+	if err := dm.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	if prefix == daemonmgr.AutoAddress {
+		// Assigned once connected
+	} else if got := device.Addresses(); len(got) != 1 || got[0] != netip.MustParsePrefix(prefix) {
+		t.Errorf("device addresses = %v, want [%s]", got, prefix)
+	}
+
+	t.Cleanup(func() { dm.Stop() })
+
+	deadline := time.Now().Add(10 * time.Second)
+	for dm.GetState() != daemonmgr.StateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("daemon %s not connected: %v", peerID, dm.GetState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitSenderKeys waits until every daemon has bound the data frames of all the others to
+// their sender keys; data frames a daemon receives before then are dropped
+func waitSenderKeys(t *testing.T, daemons ...*daemonmgr.DaemonManager) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for i, dm := range daemons {
+		for {
+			bound := 0
+			for _, peer := range dm.GetPeers() {
+				if peer.SenderKey {
+					bound++
+				}
+			}
+			if bound >= len(daemons)-1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("daemon %d has %d peers bound to their sender keys, want %d", i, bound, len(daemons)-1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// attachHost attaches a host with address addr to a daemon's device
+func attachHost(t *testing.T, device *layer2.PipeDevice, addr string) *Host {
+	t.Helper()
+
+	host := NewHost(device, netip.MustParseAddr(addr))
+	t.Cleanup(host.Close)
+	return host
+}
+
+// TestMesh tests pings and a TCP stream between the devices of two daemons connected through a relay
+func TestMesh(t *testing.T) {
+	for _, mode := range []string{layer2.ModeTAP, layer2.ModeTUN} {
+		t.Run(mode, func(t *testing.T) {
+			relay := NewRelay()
+			defer relay.Close()
+
+			aliceDaemon, aliceDevice := startDaemon(t, relay, mode, "10.77.0.1/24", "alice", nil)
+			bobDaemon, bobDevice := startDaemon(t, relay, mode, "10.77.0.2/24", "bob", nil)
+			waitSenderKeys(t, aliceDaemon, bobDaemon)
+			alice := attachHost(t, aliceDevice, "10.77.0.1")
+			bob := attachHost(t, bobDevice, "10.77.0.2")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			t.Run("ping", func(t *testing.T) {
+				// Includes a ping larger than a tunnel datagram, which is fragmented in the tunnel
+				for _, size := range []int{56, 1000, 1300} {
+					rtt, err := alice.Ping(ctx, bob.Addr(), size)
+					if err != nil {
+						t.Fatalf("ping %d bytes: %v", size, err)
+					}
+					t.Logf("ping %d bytes: %v", size, rtt)
+				}
+				if aliceDaemon.GetStatus().Pipeline.FragmentedFrames == 0 {
+					t.Error("large ping was not fragmented")
+				}
+				if _, err := bob.Ping(ctx, alice.Addr(), 56); err != nil {
+					t.Fatalf("ping back: %v", err)
+				}
+			})
+
+			t.Run("tcp", func(t *testing.T) {
+				listener, err := bob.Listen(5001)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer listener.Close()
+
+				sent := make([]byte, 64*1024)
+				rand.Read(sent)
+
+				received := make(chan []byte, 1)
+				go func() {
+					conn, err := listener.Accept(ctx)
+					if err != nil {
+						received <- nil
+						return
+					}
+					data, _ := io.ReadAll(conn)
+					conn.Close()
+					received <- data
+				}()
+
+				conn, err := alice.Dial(ctx, bob.Addr(), 5001)
+				if err != nil {
+					t.Fatalf("Dial() failed: %v", err)
+				}
+				if mss := conn.PeerMSS(); mss == 0 || mss > 1400-40 {
+					t.Errorf("peer MSS = %d, want at most %d", mss, 1400-40)
+				}
+				if _, err := conn.Write(sent); err != nil {
+					t.Fatalf("Write() failed: %v", err)
+				}
+				if err := conn.Close(); err != nil {
+					t.Fatalf("Close() failed: %v", err)
+				}
+
+				select {
+				case data := <-received:
+					if !bytes.Equal(data, sent) {
+						t.Errorf("received %d bytes, want the %d sent", len(data), len(sent))
+					}
+				case <-ctx.Done():
+					t.Fatal("stream not received")
+				}
+			})
+
+			if n := alice.ChecksumErrors() + bob.ChecksumErrors(); n != 0 {
+				t.Errorf("%d packets arrived with a bad checksum", n)
+			}
+		})
+	}
+}
+
+// TestMeshCompressionOneSided tests that compression negotiated in the hello exchange only
+// compresses the direction of the daemon that enables it, and that both directions still work
+func TestMeshCompressionOneSided(t *testing.T) {
+	relay := NewRelay()
+	defer relay.Close()
+
+	aliceDaemon, aliceDevice := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.1/24", "alice", nil)
+	bobDaemon, bobDevice := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.2/24", "bob", func(config *daemonmgr.DaemonConfig) {
+		config.Compression.Enabled = false
+	})
+	waitSenderKeys(t, aliceDaemon, bobDaemon)
+	alice := attachHost(t, aliceDevice, "10.77.0.1")
+	bob := attachHost(t, bobDevice, "10.77.0.2")
+
+	// Alice compresses once bob's hello shows that bob can decode lz4
+	deadline := time.Now().Add(5 * time.Second)
+	for !aliceDaemon.GetStatus().Compression {
+		if time.Now().After(deadline) {
+			t.Fatal("alice did not enable compression after bob's hello")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if bobDaemon.GetStatus().Compression {
+		t.Error("bob compresses although compression.enabled is false")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	listener, err := bob.Listen(5001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	sent := make([]byte, 32*1024) // Zeros compress well
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			received <- nil
+			return
+		}
+		data, _ := io.ReadAll(conn)
+		conn.Close()
+		received <- data
+	}()
+
+	conn, err := alice.Dial(ctx, bob.Addr(), 5001)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	if _, err := conn.Write(sent); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	select {
+	case data := <-received:
+		if !bytes.Equal(data, sent) {
+			t.Errorf("received %d bytes, want the %d sent", len(data), len(sent))
+		}
+	case <-ctx.Done():
+		t.Fatal("stream not received")
+	}
+
+	// Bob's uncompressed frames still reach alice
+	if _, err := bob.Ping(ctx, alice.Addr(), 56); err != nil {
+		t.Fatalf("ping from bob: %v", err)
+	}
+
+	if ratio := aliceDaemon.GetStatus().CompressionRatio; ratio <= 1 {
+		t.Errorf("alice's compression ratio = %.2f, want above 1", ratio)
+	}
+	if ratio := bobDaemon.GetStatus().CompressionRatio; ratio != 1 {
+		t.Errorf("bob's compression ratio = %.2f, want none", ratio)
+	}
+}
+
+// TestMeshReconnect tests that a daemon whose relay connection drops redials it and resumes the
+// session: the same keys and peers carry on over the new transport
+func TestMeshReconnect(t *testing.T) {
+	relay := NewRelay()
+	defer relay.Close()
+
+	aliceDaemon, aliceDevice := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.1/24", "alice", func(config *daemonmgr.DaemonConfig) {
+		config.Reconnect.InitialBackoff = 20 * time.Millisecond
+		config.Reconnect.MaxBackoff = 100 * time.Millisecond
+	})
+	bobDaemon, bobDevice := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.2/24", "bob", nil)
+	waitSenderKeys(t, aliceDaemon, bobDaemon)
+	alice := attachHost(t, aliceDevice, "10.77.0.1")
+	bob := attachHost(t, bobDevice, "10.77.0.2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+		t.Fatalf("ping before the drop: %v", err)
+	}
+	before := aliceDaemon.GetStatus()
+	peers := aliceDaemon.GetPeers()
+	if len(peers) != 1 {
+		t.Fatalf("alice knows %d peers before the drop, want 1", len(peers))
+	}
+	handshake := peers[0].LastHandshake
+
+	events, unsubscribe := aliceDaemon.SubscribeEvents(0)
+	defer unsubscribe()
+
+	if !relay.Drop("alice") {
+		t.Fatal("alice is not connected to the relay")
+	}
+
+	var reconnecting, reconnected bool
+	for !reconnected {
+		select {
+		case event := <-events:
+			switch event.Type {
+			case daemonmgr.EventReconnecting:
+				reconnecting = true
+			case daemonmgr.EventReconnected:
+				reconnected = true
+			}
+		case <-ctx.Done():
+			t.Fatalf("alice did not reconnect (state %v)", aliceDaemon.GetState())
+		}
+	}
+	if !reconnecting {
+		t.Error("reconnected without a reconnecting event")
+	}
+	if state := aliceDaemon.GetState(); state != daemonmgr.StateConnected {
+		t.Errorf("state after reconnecting = %v, want connected", state)
+	}
+
+	// The tunnel works again in both directions without a new key exchange
+	if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+		t.Fatalf("ping after reconnecting: %v", err)
+	}
+	if _, err := bob.Ping(ctx, alice.Addr(), 56); err != nil {
+		t.Fatalf("ping back after reconnecting: %v", err)
+	}
+	if after := aliceDaemon.GetStatus(); after.KeySequence != before.KeySequence {
+		t.Errorf("key sequence %d after reconnecting, want %d: the session was not resumed", after.KeySequence, before.KeySequence)
+	}
+
+	// The resumed session renegotiates with a fresh hello
+	peers = aliceDaemon.GetPeers()
+	if len(peers) != 1 || !peers[0].LastHandshake.After(handshake) {
+		t.Errorf("peers after reconnecting = %+v, want bob with a new hello", peers)
+	}
+}
+
+// TestMeshSubnets tests that an advertised subnet is routed by the peer accepting it, and that
+// packets from sources a peer may not use are dropped
+func TestMeshSubnets(t *testing.T) {
+	for _, mode := range []string{layer2.ModeTAP, layer2.ModeTUN} {
+		t.Run(mode, func(t *testing.T) {
+			relay := NewRelay()
+			defer relay.Close()
+
+			aliceDaemon, aliceDevice := startDaemon(t, relay, mode, "10.77.0.1/24", "alice", func(config *daemonmgr.DaemonConfig) {
+				config.Network.AdvertiseSubnets = []string{"192.168.77.0/24", "172.16.0.0/12"}
+			})
+			bobDaemon, bobDevice := startDaemon(t, relay, mode, "10.77.0.2/24", "bob", func(config *daemonmgr.DaemonConfig) {
+				config.Network.AcceptSubnets = []string{"192.168.0.0/16"}
+			})
+			malloryDaemon, malloryDevice := startDaemon(t, relay, mode, "10.77.0.3/24", "mallory", nil)
+			waitSenderKeys(t, aliceDaemon, bobDaemon, malloryDaemon)
+
+			// The subnet is routed once alice's hello has arrived
+			subnet := netip.MustParsePrefix("192.168.77.0/24")
+			deadline := time.Now().Add(5 * time.Second)
+			for !slices.Contains(bobDevice.Routes(), subnet) {
+				if time.Now().After(deadline) {
+					t.Fatalf("bob's routes = %v, want %s", bobDevice.Routes(), subnet)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if routes := bobDevice.Routes(); len(routes) != 1 {
+				t.Errorf("bob's routes = %v, want only %s (172.16.0.0/12 is not accepted)", routes, subnet)
+			}
+
+			bob := attachHost(t, bobDevice, "10.77.0.2")
+			lan := attachHost(t, aliceDevice, "192.168.77.5")       // Behind alice
+			spoofer := attachHost(t, malloryDevice, "192.168.77.6") // Claims an address in alice's subnet
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := lan.Ping(ctx, bob.Addr(), 56); err != nil {
+				t.Fatalf("ping from alice's subnet: %v", err)
+			}
+
+			spoofCtx, cancelSpoof := context.WithTimeout(context.Background(), time.Second)
+			defer cancelSpoof()
+			if _, err := spoofer.Ping(spoofCtx, bob.Addr(), 56); err == nil {
+				t.Fatal("ping from a spoofed source was answered")
+			}
+
+			var aliceSubnets []string
+			var spoofedDrops uint64
+			for _, peer := range bobDaemon.GetPeers() {
+				switch {
+				case slices.Contains(peer.Addresses, "10.77.0.1"):
+					aliceSubnets = peer.Subnets
+					if !slices.Equal(peer.IgnoredSubnets, []string{"172.16.0.0/12"}) {
+						t.Errorf("alice's ignored subnets = %v, want [172.16.0.0/12]", peer.IgnoredSubnets)
+					}
+				case slices.Contains(peer.Addresses, "10.77.0.3"):
+					spoofedDrops = peer.Drops["source_address"]
+				}
+			}
+			if !slices.Equal(aliceSubnets, []string{subnet.String()}) {
+				t.Errorf("alice's accepted subnets = %v, want [%s]", aliceSubnets, subnet)
+			}
+			if spoofedDrops == 0 {
+				t.Error("no source_address drops counted for mallory")
+			}
+		})
+	}
+}
+
+// TestMeshExitNode tests routing all traffic through the peer named as exit, and removing the
+// default routes when it is gone
+func TestMeshExitNode(t *testing.T) {
+	for _, mode := range []string{layer2.ModeTAP, layer2.ModeTUN} {
+		t.Run(mode, func(t *testing.T) {
+			relay := NewRelay()
+			defer relay.Close()
+
+			// mallory offers an exit too, but bob only uses the one it names
+			malloryDaemon, _ := startDaemon(t, relay, mode, "10.77.0.3/24", "mallory", func(config *daemonmgr.DaemonConfig) {
+				config.ExitNode.Advertise = true
+			})
+			aliceDaemon, aliceDevice := startDaemon(t, relay, mode, "10.77.0.1/24", "alice", func(config *daemonmgr.DaemonConfig) {
+				config.ExitNode.Advertise = true
+			})
+			bobDaemon, bobDevice := startDaemon(t, relay, mode, "10.77.0.2/24", "bob", func(config *daemonmgr.DaemonConfig) {
+				config.ExitNode.Use = true
+				config.ExitNode.Peer = "alice"
+				config.ExitNode.DNS = []string{"10.77.0.1"}
+				config.ExitNode.KillSwitch = true
+			})
+			waitSenderKeys(t, malloryDaemon, aliceDaemon, bobDaemon)
+
+			// Only the IPv4 halves: neither end has an IPv6 tunnel address
+			want := exitnode.DefaultRoutes[:2]
+			deadline := time.Now().Add(5 * time.Second)
+			hasRoutes := func(routes []netip.Prefix) bool {
+				return len(routes) == len(want) && slices.Contains(routes, want[0]) && slices.Contains(routes, want[1])
+			}
+			for !hasRoutes(bobDevice.Routes()) {
+				if time.Now().After(deadline) {
+					t.Fatalf("bob's routes = %v, want %v", bobDevice.Routes(), want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			var exit string
+			for _, peer := range bobDaemon.GetPeers() {
+				if peer.Exit && peer.Name == "alice" {
+					exit = peer.SenderID
+				}
+			}
+			status := bobDaemon.GetStatus().ExitNode
+			if exit == "" || status.Peer != exit || status.Role != "client" || !status.KillSwitch {
+				t.Errorf("bob's exit node status = %+v, want client of exit %q with kill switch", status, exit)
+			}
+
+			// A host on the internet side of alice answers from an address outside the tunnel network
+			bob := attachHost(t, bobDevice, "10.77.0.2")
+			internet := attachHost(t, aliceDevice, "198.51.100.7")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := bob.Ping(ctx, internet.Addr(), 56); err != nil {
+				t.Fatalf("ping through the exit: %v", err)
+			}
+
+			if err := bobDaemon.Disconnect(); err != nil {
+				t.Fatalf("Disconnect() failed: %v", err)
+			}
+			if routes := bobDevice.Routes(); len(routes) != 0 {
+				t.Errorf("bob's routes after disconnecting = %v, want none", routes)
+			}
+			if status := bobDaemon.GetStatus().ExitNode; status.Peer != "" {
+				t.Errorf("bob still uses exit %s after disconnecting", status.Peer)
+			}
+		})
+	}
+}
+
+// TestMeshAutoAddress tests daemons with addresses assigned by the relay, kept across a relay restart
+func TestMeshAutoAddress(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.json")
+	prefix, prefix6 := netip.MustParsePrefix("10.88.0.0/24"), netip.MustParsePrefix("fd88::/64")
+
+	// startMesh starts a relay on the lease file and connects the daemons in order
+	startMesh := func(t *testing.T, order ...string) map[string]*daemonmgr.DaemonManager {
+		pool, err := ipam.NewPool(prefix, prefix6, leases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relay := NewRelayWithPool(pool)
+		t.Cleanup(relay.Close)
+
+		daemons := make(map[string]*daemonmgr.DaemonManager)
+		devices := make(map[string]*layer2.PipeDevice)
+		for _, peerID := range order {
+			daemons[peerID], devices[peerID] = startDaemon(t, relay, layer2.ModeTUN, daemonmgr.AutoAddress, peerID, nil)
+		}
+
+		for _, peerID := range order {
+			addresses := devices[peerID].Addresses()
+			if len(addresses) != 2 || !prefix.Contains(addresses[0].Addr()) || !prefix6.Contains(addresses[1].Addr()) {
+				t.Fatalf("%s's device addresses = %v, want one in %s and one in %s", peerID, addresses, prefix, prefix6)
+			}
+			if status := daemons[peerID].GetStatus(); status.LocalIP != addresses[0].String() {
+				t.Errorf("%s's status local_ip = %q, want %s", peerID, status.LocalIP, addresses[0])
+			}
+		}
+
+		waitSenderKeys(t, daemons["alice"], daemons["bob"])
+		alice := attachHost(t, devices["alice"], devices["alice"].Addresses()[0].Addr().String())
+		bob := attachHost(t, devices["bob"], devices["bob"].Addresses()[0].Addr().String())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+			t.Fatalf("ping between assigned addresses: %v", err)
+		}
+		return daemons
+	}
+
+	first := make(map[string]string)
+	t.Run("assign", func(t *testing.T) {
+		for peerID, dm := range startMesh(t, "alice", "bob") {
+			first[peerID] = dm.GetStatus().LocalIP
+		}
+		if first["alice"] != "10.88.0.1/24" || first["bob"] != "10.88.0.2/24" {
+			t.Errorf("assigned addresses = %v, want alice 10.88.0.1/24 and bob 10.88.0.2/24", first)
+		}
+	})
+
+	// Connecting in the other order must not swap the addresses
+	t.Run("persist", func(t *testing.T) {
+		for peerID, dm := range startMesh(t, "bob", "alice") {
+			if got := dm.GetStatus().LocalIP; got != first[peerID] {
+				t.Errorf("%s's address after relay restart = %s, want %s", peerID, got, first[peerID])
+			}
+		}
+	})
+
+	// Configured addresses are reserved, and a lease only goes to the daemon holding its identity key
+	t.Run("claim", func(t *testing.T) {
+		pool, err := ipam.NewPool(prefix, prefix6, leases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relay := NewRelayWithPool(pool)
+		t.Cleanup(relay.Close)
+
+		startDaemon(t, relay, layer2.ModeTUN, "10.88.0.3/24", "carol", nil)
+		if lease := pool.Leases()[identityPublic("carol")]; !lease.Static || lease.Address != netip.MustParseAddr("10.88.0.3") {
+			t.Errorf("carol's lease = %+v, want 10.88.0.3 reserved", lease)
+		}
+		dave, _ := startDaemon(t, relay, layer2.ModeTUN, daemonmgr.AutoAddress, "dave", nil)
+		if got := dave.GetStatus().LocalIP; got != "10.88.0.4/24" {
+			t.Errorf("dave's address = %s, want 10.88.0.4/24 past the reservation", got)
+		}
+
+		claim := ipam.NewClaim(ed25519.NewKeyFromSeed(identitySeed("mallory")), time.Now())
+		claim.Identity = ed25519.NewKeyFromSeed(identitySeed("alice")).Public().(ed25519.PublicKey)
+		_, resp, err := websocket.DefaultDialer.Dial(relay.URL()+"/relay?peer_id=alice", http.Header{ipam.ClaimHeader: {claim.String()}})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("claiming alice's lease with another key: err = %v, want status 403", err)
+		}
+	})
+}
+
+// TestMeshDNS tests resolving peer names from the hellos and forwarding other names upstream
+func TestMeshDNS(t *testing.T) {
+	relay := NewRelay()
+	defer relay.Close()
+
+	upstream := magicdns.NewServer(magicdns.Config{
+		Listen:  []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Domain:  "example.com",
+		Resolve: func(name string) []netip.Addr { return []netip.Addr{netip.MustParseAddr("192.0.2.80")} },
+	})
+	if err := upstream.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	// The pipe devices' addresses do not exist on the host, so serve on loopback
+	enableDNS := func(config *daemonmgr.DaemonConfig) {
+		config.DNS.Enabled = true
+		config.DNS.Listen = []string{"127.0.0.1:0"}
+		config.DNS.Upstream = []string{upstream.Listen()[0].String()}
+		config.Identity.Peers["bob-s-laptop"] = identityPublic("bob")
+	}
+	aliceDaemon, _ := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.1/24", "alice", enableDNS)
+	bobDaemon, _ := startDaemon(t, relay, layer2.ModeTUN, "10.77.0.2/24", "bob", func(config *daemonmgr.DaemonConfig) {
+		enableDNS(config)
+		config.DNS.Name = "Bob's Laptop"
+	})
+	waitSenderKeys(t, aliceDaemon, bobDaemon)
+
+	status := aliceDaemon.GetStatus().DNS
+	if status == nil || len(status.Listen) != 1 || status.Name != "alice" || status.Domain != magicdns.DefaultDomain {
+		t.Fatalf("alice's DNS status = %+v, want alice.mesh.internal on one address", status)
+	}
+	server := status.Listen[0]
+
+	lookup := func(name string) []string {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		reply, err := dns.Exchange(req, server)
+		if err != nil {
+			t.Fatalf("query %s: %v", name, err)
+		}
+		var addrs []string
+		for _, rr := range reply.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, a.A.String())
+			}
+		}
+		return addrs
+	}
+
+	// bob's name is known once its hello has arrived
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(lookup("bob-s-laptop.mesh.internal."), []string{"10.77.0.2"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("bob-s-laptop.mesh.internal = %v, want 10.77.0.2", lookup("bob-s-laptop.mesh.internal."))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peers := aliceDaemon.GetPeers(); len(peers) != 1 || peers[0].Name != "bob-s-laptop" {
+		t.Errorf("alice's peers = %+v, want bob-s-laptop", peers)
+	}
+
+	for name, want := range map[string][]string{
+		"alice.mesh.internal.": {"10.77.0.1"},
+		"carol.mesh.internal.": nil,
+		"www.example.com.":     {"192.0.2.80"},
+	} {
+		if got := lookup(name); !slices.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestMeshACL tests that a node's ACL admits only the connections its rules allow, while
+// replies to connections the node opens pass
+func TestMeshACL(t *testing.T) {
+	for _, mode := range []string{layer2.ModeTAP, layer2.ModeTUN} {
+		t.Run(mode, func(t *testing.T) {
+			relay := NewRelay()
+			defer relay.Close()
+
+			aliceDaemon, aliceDevice := startDaemon(t, relay, mode, "10.77.0.1/24", "alice", nil)
+			bobDaemon, bobDevice := startDaemon(t, relay, mode, "10.77.0.2/24", "bob", func(config *daemonmgr.DaemonConfig) {
+				config.ACL.Enabled = true
+				config.ACL.Groups = map[string][]string{"pingers": {"alice"}}
+				config.ACL.Rules = []acl.Rule{
+					{Action: acl.Allow, Peers: []string{"alice"}, Protocol: "tcp", Ports: []string{"5001"}},
+					{Action: acl.Allow, Peers: []string{"group:pingers"}, Protocol: "icmp"},
+				}
+			})
+			// mallory announces alice's name, which only alice's identity key can sign
+			malloryDaemon, malloryDevice := startDaemon(t, relay, mode, "10.77.0.3/24", "mallory", func(config *daemonmgr.DaemonConfig) {
+				config.DNS.Name = "alice"
+			})
+			waitSenderKeys(t, aliceDaemon, bobDaemon, malloryDaemon)
+
+			// Rules match peers by the names in their hellos
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				var verified, spoofed int
+				for _, peer := range bobDaemon.GetPeers() {
+					if peer.Name == "alice" && peer.NameVerified {
+						verified++
+					} else if peer.Name == "alice" && peer.NameConflict {
+						spoofed++
+					}
+				}
+				if verified == 1 && spoofed == 1 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("bob's peers = %+v, want alice verified and mallory's claim on the name flagged", bobDaemon.GetPeers())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			alice := attachHost(t, aliceDevice, "10.77.0.1")
+			bob := attachHost(t, bobDevice, "10.77.0.2")
+			mallory := attachHost(t, malloryDevice, "10.77.0.3")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			denied := func() context.Context {
+				deniedCtx, cancelDenied := context.WithTimeout(ctx, time.Second)
+				t.Cleanup(cancelDenied)
+				return deniedCtx
+			}
+
+			if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+				t.Fatalf("ping from alice: %v", err)
+			}
+			if _, err := mallory.Ping(denied(), bob.Addr(), 56); err == nil {
+				t.Error("ping from mallory was answered")
+			}
+			// Replies to connections bob opens need no rule
+			if _, err := bob.Ping(ctx, mallory.Addr(), 56); err != nil {
+				t.Errorf("ping from bob to mallory: %v", err)
+			}
+
+			for _, port := range []uint16{5001, 5002} {
+				listener, err := bob.Listen(port)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer listener.Close()
+			}
+			conn, err := alice.Dial(ctx, bob.Addr(), 5001)
+			if err != nil {
+				t.Fatalf("alice dialling the allowed port: %v", err)
+			}
+			conn.Close()
+			if _, err := alice.Dial(denied(), bob.Addr(), 5002); err == nil {
+				t.Error("alice connected to a port no rule allows")
+			}
+			if _, err := mallory.Dial(denied(), bob.Addr(), 5001); err == nil {
+				t.Error("mallory connected to alice's port")
+			}
+
+			stats := bobDaemon.GetACL()
+			if stats == nil {
+				t.Fatal("bob's ACL status missing")
+			}
+			if stats.Rules[0].Hits == 0 || stats.Rules[1].Hits == 0 || stats.DefaultInbound.Hits == 0 || stats.DeniedInbound == 0 {
+				t.Errorf("bob's ACL stats = %+v, want hits on both rules and the default", stats)
+			}
+			for _, peer := range bobDaemon.GetPeers() {
+				if peer.NameConflict && peer.Drops["acl"] == 0 {
+					t.Error("no acl drops counted for mallory")
+				}
+			}
+		})
+	}
+}
+
+// TestMeshNeighborProxy tests that a daemon answers ARP for a peer it has heard from, and
+// limits the broadcasts its device floods to peers
+func TestMeshNeighborProxy(t *testing.T) {
+	relay := NewRelay()
+	defer relay.Close()
+
+	aliceDaemon, aliceDevice := startDaemon(t, relay, layer2.ModeTAP, "10.77.0.1/24", "alice", func(config *daemonmgr.DaemonConfig) {
+		config.Network.ProxyNeighbors = true
+		config.Network.BroadcastLimit = 5
+	})
+	bobDaemon, bobDevice := startDaemon(t, relay, layer2.ModeTAP, "10.77.0.2/24", "bob", nil)
+	carolDaemon, carolDevice := startDaemon(t, relay, layer2.ModeTAP, "10.77.0.3/24", "carol", nil)
+	waitSenderKeys(t, aliceDaemon, bobDaemon, carolDaemon)
+	alice := attachHost(t, aliceDevice, "10.77.0.1")
+	bob := attachHost(t, bobDevice, "10.77.0.2")
+	carol := attachHost(t, carolDevice, "10.77.0.3")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Bob's ARP request for carol is flooded to alice, whose daemon learns bob's MAC address
+	if _, err := bob.Ping(ctx, carol.Addr(), 56); err != nil {
+		t.Fatalf("ping from bob to carol: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for aliceDaemon.GetStatus().Neighbors.Bindings == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("alice learned no binding from bob's ARP request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Alice's request for bob is answered by her daemon with the learned address
+	if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+		t.Fatalf("ping from alice to bob: %v", err)
+	}
+	if status := aliceDaemon.GetStatus().Neighbors; status.Answered == 0 {
+		t.Errorf("alice's neighbour status = %+v, want a request answered", status)
+	}
+
+	// A burst of requests for absent hosts is cut to the limit
+	for i := 0; i < 20; i++ {
+		arp := make([]byte, 28)
+		copy(arp, []byte{0, 1, 0x08, 0x00, 6, 4, 0, 1, 0x02, 0, 0, 0, 0, 0x63, 10, 77, 0, 99})
+		copy(arp[24:28], []byte{10, 77, 0, byte(100 + i)})
+		frame := &layer2.EthernetFrame{
+			DestinationMAC: [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			SourceMAC:      [6]byte{0x02, 0, 0, 0, 0, 0x63},
+			EtherType:      layer2.EtherTypeARP,
+			Payload:        arp,
+		}
+		if err := aliceDevice.Inject(frame.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for aliceDaemon.GetStatus().Neighbors.Suppressed < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("alice's neighbour status = %+v, want the burst suppressed", aliceDaemon.GetStatus().Neighbors)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestMeshNetworks tests that the relay switches frames only within the virtual networks peers joined
+// Alice and bob share office, untagged on their devices. Alice and carol share lab on
+// devices of its own, and dave carries lab on VLAN 20 of his device. Eve joins no
+// network, and mallory joins lab without its key.
+func TestMeshNetworks(t *testing.T) {
+	relay := NewRelay()
+	defer relay.Close()
+	relay.AllowNetworks("office", "lab")
+
+	labDevice := func(dm *daemonmgr.DaemonManager, name string) *layer2.PipeDevice {
+		device, err := layer2.NewPipeDevice(layer2.DeviceConfig{Mode: layer2.ModeTAP, Name: name, MTU: 1400})
+		if err != nil {
+			t.Fatalf("NewPipeDevice() failed: %v", err)
+		}
+		dm.SetVirtualNetworkDevice("lab", device)
+		return device
+	}
+	join := func(networks ...daemonmgr.VirtualNetwork) func(*daemonmgr.DaemonConfig) {
+		return func(config *daemonmgr.DaemonConfig) { config.Networks = networks }
+	}
+	officeKey, labKey := strings.Repeat("0f", 32), strings.Repeat("1a", 32)
+
+	aliceDaemon, aliceDevice := newDaemon(t, relay, layer2.ModeTAP, "10.80.0.1/24", "alice",
+		join(daemonmgr.VirtualNetwork{ID: "office", Key: officeKey}, daemonmgr.VirtualNetwork{ID: "lab", Key: labKey, Device: "alice-lab"}))
+	aliceLab := labDevice(aliceDaemon, "alice-lab")
+	runDaemon(t, aliceDaemon, aliceDevice, "10.80.0.1/24", "alice")
+	bobDaemon, bobDevice := startDaemon(t, relay, layer2.ModeTAP, "10.80.0.2/24", "bob", join(daemonmgr.VirtualNetwork{ID: "office", Key: officeKey}))
+	carolDaemon, carolDevice := newDaemon(t, relay, layer2.ModeTAP, "10.80.0.3/24", "carol", join(daemonmgr.VirtualNetwork{ID: "lab", Key: labKey, Device: "carol-lab"}))
+	carolLab := labDevice(carolDaemon, "carol-lab")
+	runDaemon(t, carolDaemon, carolDevice, "10.80.0.3/24", "carol")
+	daveDaemon, daveDevice := startDaemon(t, relay, layer2.ModeTAP, "10.80.0.4/24", "dave", join(daemonmgr.VirtualNetwork{ID: "lab", Key: labKey, VLAN: 20}))
+	eveDaemon, _ := startDaemon(t, relay, layer2.ModeTAP, "10.80.0.5/24", "eve", nil)
+	malloryDaemon, _ := startDaemon(t, relay, layer2.ModeTAP, "10.80.0.6/24", "mallory", join(daemonmgr.VirtualNetwork{ID: "lab", Key: officeKey}))
+	waitSenderKeys(t, aliceDaemon, bobDaemon) // Peers only hear from those sharing a network
+	waitSenderKeys(t, aliceDaemon, carolDaemon, daveDaemon)
+
+	alice := attachHost(t, aliceDevice, "10.80.0.1")
+	bob := attachHost(t, bobDevice, "10.80.0.2")
+	aliceLabHost := attachHost(t, aliceLab, "10.81.0.1")
+	carolLabHost := attachHost(t, carolLab, "10.81.0.3")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := alice.Ping(ctx, bob.Addr(), 56); err != nil {
+		t.Fatalf("ping from alice to bob in office: %v", err)
+	}
+	// The network ID leaves less room in each datagram, so the large ping is fragmented
+	for _, size := range []int{56, 1300} {
+		if _, err := aliceLabHost.Ping(ctx, carolLabHost.Addr(), size); err != nil {
+			t.Fatalf("ping %d bytes from alice to carol in lab: %v", size, err)
+		}
+	}
+
+	// Lab frames reach dave's device tagged, and his tagged frames reach lab
+	request := make([]byte, 28)
+	copy(request, []byte{0, 1, 0x08, 0x00, 6, 4, 0, 1, 0x02, 0, 0, 0, 0, 0x04, 10, 81, 0, 4})
+	copy(request[24:28], []byte{10, 81, 0, 3})
+	frame := &layer2.EthernetFrame{
+		DestinationMAC: [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		SourceMAC:      [6]byte{0x02, 0, 0, 0, 0, 0x04},
+		EtherType:      layer2.EtherTypeARP,
+		VLAN:           20,
+		Payload:        request,
+	}
+	if err := daveDevice.Inject(frame.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	for answered := false; !answered; {
+		select {
+		case data := <-daveDevice.Output():
+			reply, err := layer2.ParseFrame(data)
+			if err != nil || reply.EtherType != layer2.EtherTypeARP || reply.DestinationMAC != frame.SourceMAC {
+				continue
+			}
+			if reply.VLAN != 20 {
+				t.Fatalf("ARP reply on dave's device in VLAN %d, want 20", reply.VLAN)
+			}
+			answered = true
+		case <-ctx.Done():
+			t.Fatal("carol's ARP reply did not reach dave's device")
+		}
+	}
+
+	// Peers never received frames of networks they did not join
+	if drops := bobDaemon.GetStatus().Drops["network"]; drops != 0 {
+		t.Errorf("bob dropped %d frames of other networks, want the relay to keep them away", drops)
+	}
+	if status := eveDaemon.GetStatus(); status.Pipeline.RxFrames != 0 {
+		t.Errorf("eve received %d frames without sharing a network", status.Pipeline.RxFrames)
+	}
+
+	// The relay lets mallory into lab, but without its key no frame of it decrypts
+	if networks := malloryDaemon.GetStatus().Networks; len(networks) != 1 || networks[0].RxFrames != 0 {
+		t.Errorf("mallory's networks = %+v, want no frames of lab", networks)
+	}
+
+	networks := aliceDaemon.GetStatus().Networks
+	if len(networks) != 2 || networks[0].ID != "office" || networks[1].Device != "alice-lab" {
+		t.Fatalf("alice's networks = %+v", networks)
+	}
+	if lab := networks[1]; lab.TxFrames == 0 || lab.RxFrames == 0 {
+		t.Errorf("alice's lab status = %+v, want frames both ways", lab)
+	}
+
+	// Networks the relay does not serve cannot be joined
+	_, resp, err := websocket.DefaultDialer.Dial(relay.URL()+"/relay?peer_id=mallory&network=office&network=finance", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("joining an unserved network: err = %v, want status 403", err)
+	}
+}
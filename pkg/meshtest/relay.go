@@ -0,0 +1,220 @@
+// Package meshtest runs daemons end to end inside one process, without root
+//
+// A Relay is a loopback relay server that daemons connect to in relay mode, and a
+// Host is a minimal userspace IPv4 stack (ARP, ICMP echo and a simple TCP) attached
+// to the host side of a layer2.PipeDevice. Together they let tests send pings and
+// TCP streams from one daemon's device to another's through the full
+// device → encrypt → transport → decrypt → device path.
+package meshtest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+)
+
+// Relay is an in-process relay server: every frame a peer sends is forwarded to the other peers in its network
+// It speaks the protocol of cmd/relay-server (WebSocket on /relay?peer_id=<id>&network=<name>...&address=<ip>).
+type Relay struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+	pool     *ipam.Pool
+	networks map[string]bool // Networks peers may join; nil allows any, unlike cmd/relay-server
+
+	mu    sync.Mutex
+	peers map[string]*relayPeer
+}
+
+// relayPeer is a connected peer with the networks it joined
+type relayPeer struct {
+	conn     *websocket.Conn
+	send     chan []byte
+	networks frameencryption.NetworkSet
+}
+
+// NewRelay starts a relay on a loopback port
+func NewRelay() *Relay {
+	r := &Relay{peers: make(map[string]*relayPeer)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/relay", r.handleWebSocket)
+	r.server = httptest.NewServer(mux)
+	return r
+}
+
+// NewRelayWithPool starts a relay that assigns tunnel addresses from pool, like cmd/relay-server -pool
+func NewRelayWithPool(pool *ipam.Pool) *Relay {
+	r := NewRelay()
+	r.pool = pool
+	return r
+}
+
+// AllowNetworks restricts the networks peers may join to names, like cmd/relay-server -networks
+// Peers asking for another network are refused with 403 Forbidden.
+func (r *Relay) AllowNetworks(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.networks = make(map[string]bool, len(names))
+	for _, name := range names {
+		r.networks[name] = true
+	}
+}
+
+// URL returns the relay's address for relay.server, e.g. "ws://127.0.0.1:40000"
+func (r *Relay) URL() string {
+	return "ws" + strings.TrimPrefix(r.server.URL, "http")
+}
+
+// Close disconnects all peers and stops the relay
+func (r *Relay) Close() {
+	r.server.CloseClientConnections()
+	r.server.Close()
+}
+
+// Drop closes a peer's connection, as a relay restart or network outage would
+// It reports whether the peer was connected.
+func (r *Relay) Drop(peerID string) bool {
+	r.mu.Lock()
+	peer, ok := r.peers[peerID]
+	r.mu.Unlock()
+
+	if ok {
+		peer.conn.Close()
+	}
+	return ok
+}
+
+// lease reserves a peer's configured address or assigns one from the pool, as cmd/relay-server does
+// The assignment is nil without a pool, for a peer with a configured address and for
+// one without a lease claim.
+func (r *Relay) lease(req *http.Request) (*ipam.Assignment, error) {
+	header := req.Header.Get(ipam.ClaimHeader)
+	if r.pool == nil || header == "" {
+		return nil, nil
+	}
+	claim, err := ipam.ParseClaim(header)
+	if err != nil {
+		return nil, err
+	}
+	if address := req.URL.Query().Get("address"); address != "" {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return nil, err
+		}
+		return nil, r.pool.Reserve(claim, addr)
+	}
+	assignment, err := r.pool.Allocate(claim)
+	if err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// handleWebSocket forwards a peer's frames until it disconnects
+func (r *Relay) handleWebSocket(w http.ResponseWriter, req *http.Request) {
+	peerID := req.URL.Query().Get("peer_id")
+	if peerID == "" {
+		http.Error(w, "peer_id required", http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	allowed := r.networks
+	r.mu.Unlock()
+	for _, network := range req.URL.Query()["network"] {
+		if allowed != nil && !allowed[network] {
+			http.Error(w, "network not served", http.StatusForbidden)
+			return
+		}
+	}
+
+	assignment, err := r.lease(req)
+	if err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, ipam.ErrInvalidClaim) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if assignment != nil {
+		if err := conn.WriteJSON(assignment); err != nil {
+			return
+		}
+	}
+
+	peer := &relayPeer{
+		conn:     conn,
+		send:     make(chan []byte, 1000),
+		networks: frameencryption.JoinNetworks(req.URL.Query()["network"]),
+	}
+	send := peer.send
+	r.mu.Lock()
+	r.peers[peerID] = peer
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		if r.peers[peerID] == peer {
+			delete(r.peers, peerID)
+		}
+		r.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case frame := <-send:
+				if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType == websocket.BinaryMessage {
+			r.forward(peer, data)
+		}
+	}
+}
+
+// forward passes a frame to every peer but its sender in the frame's network, dropping it for peers that are not keeping up
+func (r *Relay) forward(sender *relayPeer, frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, peer := range r.peers {
+		if peer == sender || !peer.networks.Relays(sender.networks, frame) {
+			continue
+		}
+		select {
+		case peer.send <- frame:
+		default:
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package meshtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// TCP header flags and options the host uses
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	tcpHeaderSize = 20
+	tcpOptionMSS  = 2
+)
+
+const (
+	// retransmitInterval is how long a segment waits for its ACK before it is sent again
+	retransmitInterval = 200 * time.Millisecond
+
+	// maxRetransmits bounds the attempts for one segment before the connection fails
+	maxRetransmits = 50
+)
+
+// errReset is returned by a connection the peer reset
+var errReset = errors.New("connection reset by peer")
+
+// connKey identifies a connection on its host
+type connKey struct {
+	remote     netip.Addr
+	remotePort uint16
+	localPort  uint16
+}
+
+// Connection states
+const (
+	stateSynSent = iota
+	stateSynReceived
+	stateEstablished
+)
+
+// Conn is a TCP connection of a Host
+// The implementation is deliberately simple: one segment is in flight at a time and
+// is retransmitted until acknowledged, and segments arriving out of order are
+// dropped. It is meant for checking that streams cross the tunnel intact, not for
+// throughput.
+type Conn struct {
+	host     *Host
+	key      connKey
+	localMSS int
+
+	writeMu sync.Mutex // Serializes senders, which wait for each segment's ACK
+
+	mu      sync.Mutex
+	state   int
+	iss     uint32 // Initial send sequence
+	sndNxt  uint32 // Next sequence to send
+	sndUna  uint32 // Oldest unacknowledged sequence
+	rcvNxt  uint32 // Next sequence expected from the peer
+	peerMSS int    // MSS option of the peer's SYN, as received
+	readBuf []byte
+	eof     bool // The peer sent FIN
+	finSent bool
+	err     error
+	notify  chan struct{} // Closed and replaced on every change
+}
+
+// Listener accepts TCP connections on a port of a Host
+type Listener struct {
+	host   *Host
+	port   uint16
+	accept chan *Conn
+}
+
+// Listen accepts connections to port
+func (h *Host) Listen(port uint16) (*Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.listeners[port]; ok {
+		return nil, fmt.Errorf("port %d already in use", port)
+	}
+	l := &Listener{host: h, port: port, accept: make(chan *Conn, 16)}
+	h.listeners[port] = l.accept
+	return l, nil
+}
+
+// Accept waits for the next established connection
+func (l *Listener) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.host.ctx.Done():
+		return nil, errClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *Listener) Close() {
+	l.host.mu.Lock()
+	defer l.host.mu.Unlock()
+	delete(l.host.listeners, l.port)
+}
+
+// Dial opens a TCP connection to dst:port
+func (h *Host) Dial(ctx context.Context, dst netip.Addr, port uint16) (*Conn, error) {
+	h.mu.Lock()
+	h.nextPort++
+	key := connKey{remote: dst, remotePort: port, localPort: h.nextPort}
+	c := h.newConn(key, stateSynSent)
+	h.conns[key] = c
+	h.mu.Unlock()
+
+	err := c.transmit(ctx, tcpSYN, c.iss, nil, func() bool { return c.state == stateEstablished })
+	if err != nil {
+		c.fail(err)
+		return nil, fmt.Errorf("connecting to %s:%d: %w", dst, port, err)
+	}
+	return c, nil
+}
+
+// newConn creates a connection with a random initial sequence; the caller holds h.mu
+func (h *Host) newConn(key connKey, state int) *Conn {
+	iss := rand.Uint32()
+	return &Conn{
+		host:     h,
+		key:      key,
+		localMSS: h.device.MTU() - ipv4HeaderSize - tcpHeaderSize,
+		state:    state,
+		iss:      iss,
+		sndNxt:   iss + 1, // The SYN takes one sequence number
+		sndUna:   iss,
+		notify:   make(chan struct{}),
+	}
+}
+
+// PeerMSS returns the MSS the peer advertised, as it arrived (after any clamping on the way)
+func (c *Conn) PeerMSS() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerMSS
+}
+
+// segmentSize returns the largest payload to send in one segment
+func (c *Conn) segmentSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peerMSS > 0 && c.peerMSS < c.localMSS {
+		return c.peerMSS
+	}
+	return c.localMSS
+}
+
+// Write sends b, returning once all of it has been acknowledged
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(len(b), written+c.segmentSize())]
+
+		c.mu.Lock()
+		if c.finSent {
+			c.mu.Unlock()
+			return written, errClosed
+		}
+		seq := c.sndNxt
+		c.sndNxt += uint32(len(chunk))
+		c.mu.Unlock()
+
+		end := seq + uint32(len(chunk))
+		if err := c.transmit(context.Background(), tcpACK|tcpPSH, seq, chunk, func() bool { return seqLEQ(end, c.sndUna) }); err != nil {
+			c.fail(err)
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Read reads data received from the peer; io.EOF once the peer closed and all data was read
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		wait := c.notify
+		c.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-c.host.ctx.Done():
+			return 0, errClosed
+		}
+	}
+}
+
+// Close sends FIN once all written data was acknowledged and waits for its ACK
+// Data from the peer can still be read until it closes too.
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	if c.finSent || c.err != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.finSent = true
+	seq := c.sndNxt
+	c.sndNxt++
+	c.mu.Unlock()
+
+	err := c.transmit(context.Background(), tcpFIN|tcpACK, seq, nil, func() bool { return seqLEQ(seq+1, c.sndUna) })
+	if err != nil {
+		c.fail(err)
+		return err
+	}
+	c.release()
+	return nil
+}
+
+// transmit sends a segment until done reports that it was acknowledged
+// done is called with c.mu held.
+func (c *Conn) transmit(ctx context.Context, flags byte, seq uint32, payload []byte, done func() bool) error {
+	for range maxRetransmits {
+		c.mu.Lock()
+		if done() {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return err
+		}
+		wait := c.notify
+		c.mu.Unlock()
+
+		if err := c.send(ctx, flags, seq, payload); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(retransmitInterval)
+		for waiting := true; waiting; {
+			select {
+			case <-wait:
+				c.mu.Lock()
+				if done() || c.err != nil {
+					waiting = false
+				}
+				wait = c.notify
+				c.mu.Unlock()
+			case <-timer.C:
+				waiting = false
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-c.host.ctx.Done():
+				timer.Stop()
+				return errClosed
+			}
+		}
+		timer.Stop()
+	}
+	return fmt.Errorf("no ACK after %d attempts", maxRetransmits)
+}
+
+// send sends one segment, acknowledging everything received so far
+func (c *Conn) send(ctx context.Context, flags byte, seq uint32, payload []byte) error {
+	var options []byte
+	if flags&tcpSYN != 0 {
+		options = make([]byte, 4)
+		options[0], options[1] = tcpOptionMSS, 4
+		binary.BigEndian.PutUint16(options[2:4], uint16(c.localMSS))
+	}
+
+	c.mu.Lock()
+	ack := c.rcvNxt
+	if c.state != stateSynSent {
+		flags |= tcpACK
+	}
+	c.mu.Unlock()
+
+	headerLen := tcpHeaderSize + len(options)
+	segment := make([]byte, headerLen+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], c.key.localPort)
+	binary.BigEndian.PutUint16(segment[2:4], c.key.remotePort)
+	binary.BigEndian.PutUint32(segment[4:8], seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(segment[8:12], ack)
+	}
+	segment[12] = byte(headerLen/4) << 4
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:16], 0xFFFF) // Window
+	copy(segment[tcpHeaderSize:], options)
+	copy(segment[headerLen:], payload)
+	binary.BigEndian.PutUint16(segment[16:18], checksum(segment, pseudoHeaderSum(c.host.addr, c.key.remote, protoTCP, len(segment))))
+
+	return c.host.sendIP(ctx, c.key.remote, protoTCP, segment)
+}
+
+// changed wakes up everything waiting on the connection; the caller holds c.mu
+func (c *Conn) changed() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// fail ends the connection with err
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		c.changed()
+	}
+	c.mu.Unlock()
+	c.release()
+}
+
+// release removes a finished connection from its host, once both sides have closed or it failed
+func (c *Conn) release() {
+	c.mu.Lock()
+	finished := c.err != nil || (c.finSent && c.eof && seqLEQ(c.sndNxt, c.sndUna))
+	c.mu.Unlock()
+
+	if finished {
+		go func() {
+			c.host.mu.Lock()
+			if c.host.conns[c.key] == c {
+				delete(c.host.conns, c.key)
+			}
+			c.host.mu.Unlock()
+		}()
+	}
+}
+
+// handleTCP processes a segment (checksum already verified) from src
+func (h *Host) handleTCP(src netip.Addr, segment []byte) {
+	if len(segment) < tcpHeaderSize {
+		return
+	}
+	headerLen := int(segment[12]>>4) * 4
+	if headerLen < tcpHeaderSize || headerLen > len(segment) {
+		return
+	}
+
+	key := connKey{
+		remote:     src,
+		remotePort: binary.BigEndian.Uint16(segment[0:2]),
+		localPort:  binary.BigEndian.Uint16(segment[2:4]),
+	}
+	seq := binary.BigEndian.Uint32(segment[4:8])
+	ack := binary.BigEndian.Uint32(segment[8:12])
+	flags := segment[13]
+	payload := segment[headerLen:]
+
+	h.mu.Lock()
+	c, ok := h.conns[key]
+	accept, listening := h.listeners[key.localPort]
+	if !ok && flags&tcpSYN != 0 && flags&tcpACK == 0 && listening {
+		// New connection to a listening port
+		c = h.newConn(key, stateSynReceived)
+		c.rcvNxt = seq + 1
+		c.peerMSS = mssOption(segment[tcpHeaderSize:headerLen])
+		h.conns[key] = c
+		ok = true
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if flags&tcpRST != 0 {
+		c.fail(errReset)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case stateSynSent:
+		if flags&(tcpSYN|tcpACK) != tcpSYN|tcpACK || ack != c.iss+1 {
+			return
+		}
+		c.rcvNxt = seq + 1
+		c.peerMSS = mssOption(segment[tcpHeaderSize:headerLen])
+		c.sndUna = ack
+		c.state = stateEstablished
+		c.changed()
+		go c.send(h.ctx, tcpACK, c.sndNxt, nil)
+		return
+
+	case stateSynReceived:
+		if flags&tcpSYN != 0 {
+			// (Re)transmitted SYN: answer with our SYN-ACK
+			go c.send(h.ctx, tcpSYN|tcpACK, c.iss, nil)
+			return
+		}
+		if flags&tcpACK == 0 || ack != c.iss+1 {
+			return
+		}
+		c.sndUna = ack
+		c.state = stateEstablished
+		c.changed()
+		select {
+		case accept <- c:
+		default: // Accept queue full; the connection is left to time out
+		}
+	}
+
+	if flags&tcpSYN != 0 {
+		// Our ACK of the SYN-ACK was lost; acknowledge again
+		go c.send(h.ctx, tcpACK, c.sndNxt, nil)
+		return
+	}
+
+	if flags&tcpACK != 0 && seqLEQ(c.sndUna, ack) && seqLEQ(ack, c.sndNxt) && ack != c.sndUna {
+		c.sndUna = ack
+		c.changed()
+	}
+
+	if len(payload) > 0 || flags&tcpFIN != 0 {
+		if seq == c.rcvNxt && !c.eof {
+			c.readBuf = append(c.readBuf, payload...)
+			c.rcvNxt += uint32(len(payload))
+			if flags&tcpFIN != 0 {
+				c.rcvNxt++
+				c.eof = true
+			}
+			c.changed()
+		}
+		// Acknowledge new data as well as retransmissions whose ACK was lost
+		go c.send(h.ctx, tcpACK, c.sndNxt, nil)
+	}
+
+	if c.eof && c.finSent && seqLEQ(c.sndNxt, c.sndUna) {
+		go c.release()
+	}
+}
+
+// mssOption returns the value of the MSS option in TCP options, or 0
+func mssOption(options []byte) int {
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case 0: // End of options
+			return 0
+		case 1: // No-op
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return 0
+		}
+		if options[i] == tcpOptionMSS && options[i+1] == 4 {
+			return int(binary.BigEndian.Uint16(options[i+2 : i+4]))
+		}
+		i += int(options[i+1])
+	}
+	return 0
+}
+
+// seqLEQ reports whether sequence number a is at or before b, allowing for wraparound
+func seqLEQ(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
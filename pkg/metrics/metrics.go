@@ -0,0 +1,231 @@
+// Package metrics exports counters, gauges and histograms in the Prometheus text format.
+//
+// Values are collected when /metrics is scraped: each component registers a collect
+// function that reads its own counters and writes them through a Writer, so hot paths
+// keep their existing atomic counters and pay nothing extra. Histograms are the
+// exception; they are observed as events happen and only read at scrape time.
+//
+// Exposition format (version 0.0.4):
+//
+//	# HELP shadowmesh_frames_total Frames through the encryption pipeline.
+//	# TYPE shadowmesh_frames_total counter
+//	shadowmesh_frames_total{direction="tx"} 1027
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the Content-Type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// LatencyBuckets are histogram upper bounds in seconds, from 500µs to 10s
+var LatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Label is one name="value" pair of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// L builds a Label
+func L(name, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+// Registry holds the collect functions behind one /metrics endpoint
+// Thread-safe: collectors may be registered while the endpoint is being scraped.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []func(w *Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a function that writes metrics on every scrape
+func (r *Registry) Register(collect func(w *Writer)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+// WriteTo collects every registered metric and writes the exposition text to out
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]func(w *Writer){}, r.collectors...)
+	r.mu.Unlock()
+
+	w := &Writer{families: make(map[string]*family)}
+	for _, collect := range collectors {
+		collect(w)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range w.order {
+		f := w.families[name]
+		buf.WriteString("# HELP " + name + " " + escapeHelp(f.help) + "\n")
+		buf.WriteString("# TYPE " + name + " " + f.kind + "\n")
+		buf.Write(f.samples.Bytes())
+	}
+	return buf.WriteTo(out)
+}
+
+// Handler serves the registry in the text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+// Writer accumulates the samples of one scrape, grouped by metric family
+// Samples of a family may be written by several collectors and in any order.
+type Writer struct {
+	families map[string]*family
+	order    []string
+}
+
+// family is one metric name with its HELP, TYPE and samples
+type family struct {
+	help    string
+	kind    string
+	samples bytes.Buffer
+}
+
+// Counter writes a sample of a monotonically increasing value
+func (w *Writer) Counter(name, help string, value float64, labels ...Label) {
+	f := w.family(name, help, "counter")
+	writeSample(&f.samples, name, labels, value)
+}
+
+// Gauge writes a sample of a value that can go up and down
+func (w *Writer) Gauge(name, help string, value float64, labels ...Label) {
+	f := w.family(name, help, "gauge")
+	writeSample(&f.samples, name, labels, value)
+}
+
+// Histogram writes the buckets, sum and count of h
+func (w *Writer) Histogram(name, help string, h *Histogram, labels ...Label) {
+	f := w.family(name, help, "histogram")
+
+	h.mu.Lock()
+	counts := append([]uint64{}, h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		writeSample(&f.samples, name+"_bucket", append(labels[:len(labels):len(labels)], L("le", formatFloat(bound))), float64(cumulative))
+	}
+	writeSample(&f.samples, name+"_bucket", append(labels[:len(labels):len(labels)], L("le", "+Inf")), float64(count))
+	writeSample(&f.samples, name+"_sum", labels, sum)
+	writeSample(&f.samples, name+"_count", labels, float64(count))
+}
+
+// family returns the family for name, creating it on first use
+func (w *Writer) family(name, help, kind string) *family {
+	f, ok := w.families[name]
+	if !ok {
+		f = &family{help: help, kind: kind}
+		w.families[name] = f
+		w.order = append(w.order, name)
+	}
+	return f
+}
+
+// Histogram counts observations into buckets with fixed upper bounds
+// Thread-safe: Observe can be called from any goroutine.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // Sorted upper bounds; +Inf is implicit
+	counts []uint64  // Observations per bucket (not cumulative)
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// ObserveDuration records a duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// writeSample writes one `name{labels} value` line
+func writeSample(buf *bytes.Buffer, name string, labels []Label, value float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(label.Name)
+			buf.WriteString(`="`)
+			buf.WriteString(labelEscaper.Replace(label.Value))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeHelp escapes HELP text (quotes are allowed there)
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// formatFloat formats a sample value, including the special values
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
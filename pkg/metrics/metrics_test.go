@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the registry's exposition text
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return sb.String()
+}
+
+// TestExposition tests that samples are grouped under one HELP and TYPE per family
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	r.Register(func(w *Writer) {
+		w.Counter("test_frames_total", "Frames.", 3, L("direction", "tx"))
+		w.Gauge("test_peers", "Connected peers.", 2)
+	})
+	r.Register(func(w *Writer) {
+		w.Counter("test_frames_total", "Frames.", 5, L("direction", "rx"))
+	})
+
+	want := `# HELP test_frames_total Frames.
+# TYPE test_frames_total counter
+test_frames_total{direction="tx"} 3
+test_frames_total{direction="rx"} 5
+# HELP test_peers Connected peers.
+# TYPE test_peers gauge
+test_peers 2
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestLabelEscaping tests escaping of quotes, backslashes and newlines in label values
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Register(func(w *Writer) {
+		w.Gauge("test_info", "Info.", 1, L("path", "a\"b\\c\nd"))
+	})
+
+	if got := scrape(t, r); !strings.Contains(got, `test_info{path="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label value not escaped:\n%s", got)
+	}
+}
+
+// TestHistogram tests cumulative buckets, sum and count
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 0.01, 1})
+	h.ObserveDuration(5 * time.Millisecond)
+	h.Observe(0.01) // Upper bounds are inclusive
+	h.Observe(0.5)
+	h.Observe(3)
+
+	r := NewRegistry()
+	r.Register(func(w *Writer) {
+		w.Histogram("test_rtt_seconds", "RTT.", h, L("peer", "a"))
+	})
+
+	got := scrape(t, r)
+	for _, line := range []string{
+		"# TYPE test_rtt_seconds histogram",
+		`test_rtt_seconds_bucket{peer="a",le="0.01"} 2`,
+		`test_rtt_seconds_bucket{peer="a",le="0.1"} 2`,
+		`test_rtt_seconds_bucket{peer="a",le="1"} 3`,
+		`test_rtt_seconds_bucket{peer="a",le="+Inf"} 4`,
+		`test_rtt_seconds_sum{peer="a"} 3.515`,
+		`test_rtt_seconds_count{peer="a"} 4`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+// TestHandler tests the HTTP handler's content type and method check
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Register(func(w *Writer) { w.Gauge("test_up", "Up.", 1) })
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+	if !strings.Contains(rec.Body.String(), "test_up 1\n") {
+		t.Errorf("Unexpected body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/metrics", nil))
+	if rec.Code != 405 {
+		t.Errorf("POST returned %d, want 405", rec.Code)
+	}
+}
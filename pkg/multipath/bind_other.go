@@ -0,0 +1,10 @@
+//go:build !linux
+
+package multipath
+
+import "syscall"
+
+// bindToDevice is a no-op where SO_BINDTODEVICE is unavailable; the source address selects the uplink
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
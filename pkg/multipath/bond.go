@@ -0,0 +1,493 @@
+package multipath
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxPaths is the most paths a bond keeps
+	DefaultMaxPaths = 8
+
+	// DefaultProbeInterval is how often each path is probed for RTT and loss
+	DefaultProbeInterval = time.Second
+
+	// DefaultPathTimeout marks a path down when no probe has been answered for this long
+	DefaultPathTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNoPath is returned when sending on a bond without paths
+	ErrNoPath = errors.New("no multipath path available")
+
+	// ErrTooManyPaths is returned when adding a path beyond MaxPaths
+	ErrTooManyPaths = errors.New("too many multipath paths")
+)
+
+// Config configures a Bond (zero values select the defaults)
+type Config struct {
+	Mode           Mode
+	AcceptPaths    bool          // Adopt new peer addresses once they answer our probes
+	MaxPaths       int           // Most paths kept, including adopted ones (default: 8)
+	ProbeInterval  time.Duration // Path probe interval (default: 1s)
+	PathTimeout    time.Duration // Silence before a path is marked down (default: 5s)
+	ReorderWindow  int           // Frames held back waiting for a gap (default: 64)
+	ReorderTimeout time.Duration // Wait before a gap is skipped (default: 50ms)
+
+	// OnPathChange is called when a path comes up or goes down (optional)
+	// It runs on the bond's receive and maintenance goroutines and must not block.
+	OnPathChange func(PathStats)
+}
+
+// Bond schedules datagrams across several UDP paths to one peer and merges what they receive
+// Thread-safe: Send may be called concurrently with receiving and path changes.
+type Bond struct {
+	config Config
+	mode   atomic.Int32
+
+	// Wrap data in sequenced multipath datagrams; only once the peer can decode them
+	sequenced atomic.Bool
+
+	mu      sync.RWMutex
+	paths   []*Path
+	readers map[*net.UDPConn]bool
+	nextID  uint8
+
+	seq     atomic.Uint32
+	counter atomic.Uint64
+
+	reorderMu sync.Mutex
+	reorder   *Reorderer
+
+	recvChan     chan []byte
+	failed       chan struct{}
+	failOnce     sync.Once
+	readersAlive atomic.Int32
+	dropped      atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewBond creates a bond without paths and starts probing
+func NewBond(config Config) *Bond {
+	if config.MaxPaths <= 0 {
+		config.MaxPaths = DefaultMaxPaths
+	}
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = DefaultProbeInterval
+	}
+	if config.PathTimeout <= 0 {
+		config.PathTimeout = DefaultPathTimeout
+	}
+	if config.ReorderTimeout <= 0 {
+		config.ReorderTimeout = DefaultReorderTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bond{
+		config:   config,
+		readers:  make(map[*net.UDPConn]bool),
+		reorder:  NewReorderer(config.ReorderWindow, config.ReorderTimeout),
+		recvChan: make(chan []byte, 1000),
+		failed:   make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	b.mode.Store(int32(config.Mode))
+	b.seq.Store(randomUint32())
+
+	b.wg.Add(1)
+	go b.maintenanceLoop()
+
+	return b
+}
+
+// AddPath adds a path over conn to the peer at remote
+// A verified path (e.g. one established by hole punching) carries traffic at once;
+// others wait until they answer a probe. The bond takes ownership of conn.
+func (b *Bond) AddPath(name string, conn *net.UDPConn, remote *net.UDPAddr, verified bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.paths) >= b.config.MaxPaths {
+		return ErrTooManyPaths
+	}
+
+	path := newPath(b.nextID, name, conn, remote, verified, time.Now())
+	b.nextID++
+	b.paths = append(b.paths, path)
+	b.startReaderLocked(conn)
+
+	// Probe straight away so the path comes up (and is adopted by the peer) quickly
+	if b.sequenced.Load() {
+		b.sendProbe(path, time.Now())
+	}
+
+	return nil
+}
+
+// startReaderLocked starts the receive loop for a socket once (b.mu held)
+func (b *Bond) startReaderLocked(conn *net.UDPConn) {
+	if b.readers[conn] {
+		return
+	}
+	b.readers[conn] = true
+	b.readersAlive.Add(1)
+
+	b.wg.Add(1)
+	go b.readLoop(conn)
+}
+
+// SetSequenced enables multipath framing and probing; the peer must understand multipath datagrams
+func (b *Bond) SetSequenced(enabled bool) {
+	if b.sequenced.Swap(enabled) == enabled || !enabled {
+		return
+	}
+
+	// Paths were not probed until now; restart their timeout and probe them at once
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, path := range b.paths {
+		path.mu.Lock()
+		if path.up {
+			path.lastAck = now
+		}
+		path.mu.Unlock()
+		b.sendProbe(path, now)
+	}
+}
+
+// Sequenced reports whether data is sent in multipath datagrams
+func (b *Bond) Sequenced() bool {
+	return b.sequenced.Load()
+}
+
+// SetMode changes the scheduling mode
+func (b *Bond) SetMode(mode Mode) {
+	b.mode.Store(int32(mode))
+}
+
+// Mode returns the scheduling mode
+func (b *Bond) Mode() Mode {
+	return Mode(b.mode.Load())
+}
+
+// Send transmits a datagram on the paths chosen by the scheduler
+// Without sequencing the first path is used as a plain UDP transport.
+// Succeeds if at least one path accepted the datagram.
+func (b *Bond) Send(payload []byte) error {
+	b.mu.RLock()
+	paths := b.paths
+	b.mu.RUnlock()
+
+	if len(paths) == 0 {
+		return ErrNoPath
+	}
+
+	if !b.sequenced.Load() {
+		return paths[0].write(payload)
+	}
+
+	states := make([]PathStats, len(paths))
+	for i, path := range paths {
+		path.mu.Lock()
+		states[i] = PathStats{Up: path.up, RTT: path.rtt, Loss: path.loss}
+		path.mu.Unlock()
+	}
+
+	datagram := encodeData(b.seq.Add(1), payload)
+
+	var lastErr error
+	sent := false
+	for _, i := range b.Mode().Select(states, b.counter.Add(1)) {
+		if err := paths[i].write(datagram); err != nil {
+			lastErr = fmt.Errorf("path %s: %w", paths[i].name, err)
+			continue
+		}
+		sent = true
+	}
+
+	if !sent {
+		return lastErr
+	}
+	return nil
+}
+
+// RecvChannel returns received payloads in send order
+func (b *Bond) RecvChannel() <-chan []byte {
+	return b.recvChan
+}
+
+// Failed is closed when every socket in the bond has failed
+func (b *Bond) Failed() <-chan struct{} {
+	return b.failed
+}
+
+// Paths returns a snapshot of every path
+func (b *Bond) Paths() []PathStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make([]PathStats, len(b.paths))
+	for i, path := range b.paths {
+		stats[i] = path.Stats()
+	}
+	return stats
+}
+
+// ReorderStats returns frames put back in order, duplicates dropped, and frames given up on
+func (b *Bond) ReorderStats() (reordered, duplicates, skipped uint64) {
+	b.reorderMu.Lock()
+	defer b.reorderMu.Unlock()
+	return b.reorder.Stats()
+}
+
+// Dropped returns payloads dropped because the receive channel was full
+func (b *Bond) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close stops the bond and closes every socket
+func (b *Bond) Close() error {
+	b.cancel()
+
+	b.mu.Lock()
+	for conn := range b.readers {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+// readLoop receives datagrams on one socket
+func (b *Bond) readLoop(conn *net.UDPConn) {
+	defer b.wg.Done()
+	defer func() {
+		if b.readersAlive.Add(-1) == 0 && b.ctx.Err() == nil {
+			b.failOnce.Do(func() { close(b.failed) })
+		}
+	}()
+
+	buffer := make([]byte, 65535) // Maximum UDP packet size
+
+	for {
+		// Set read deadline to allow checking ctx.Done()
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			b.markConnDown(conn)
+			return
+		}
+
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		b.handleDatagram(conn, addr, data, time.Now())
+	}
+}
+
+// handleDatagram dispatches one received datagram
+func (b *Bond) handleDatagram(conn *net.UDPConn, addr *net.UDPAddr, data []byte, now time.Time) {
+	path, knownHost := b.findPath(conn, addr)
+
+	if !IsDatagram(data) {
+		// Plain datagram from a peer not using multipath framing
+		if path == nil && !knownHost {
+			return
+		}
+		if path != nil {
+			path.received(len(data))
+		}
+		b.deliver(data)
+		return
+	}
+
+	datagramType, id, payload, err := decode(data)
+	if err != nil {
+		return
+	}
+
+	switch datagramType {
+	case typeProbe:
+		if path == nil {
+			if path = b.adoptPath(conn, addr, now); path == nil {
+				return
+			}
+		}
+		path.received(len(data))
+		path.write(encodeProbe(typeProbeAck, id))
+
+	case typeProbeAck:
+		if path != nil {
+			path.received(len(data))
+			if path.probeAcked(id, now) {
+				b.pathChanged(path)
+			}
+		}
+
+	case typeData:
+		// Data is only accepted on paths the peer has proven it can be reached on
+		if path == nil {
+			return
+		}
+		path.received(len(data))
+
+		b.reorderMu.Lock()
+		out := b.reorder.Push(id, payload, now)
+		b.reorderMu.Unlock()
+
+		for _, payload := range out {
+			b.deliver(payload)
+		}
+	}
+}
+
+// findPath returns the path a datagram arrived on, and whether its host is a known peer address
+func (b *Bond) findPath(conn *net.UDPConn, addr *net.UDPAddr) (*Path, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	knownHost := false
+	for _, path := range b.paths {
+		if path.matches(conn, addr) {
+			return path, true
+		}
+		if path.remote.IP.Equal(addr.IP) {
+			knownHost = true
+		}
+	}
+	return nil, knownHost
+}
+
+// adoptPath adds a path for a peer address that probed us, if allowed
+// The path carries data only after it answers one of our own probes.
+func (b *Bond) adoptPath(conn *net.UDPConn, addr *net.UDPAddr, now time.Time) *Path {
+	if !b.config.AcceptPaths {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, path := range b.paths {
+		if path.matches(conn, addr) {
+			return path
+		}
+	}
+	if len(b.paths) >= b.config.MaxPaths {
+		return nil
+	}
+
+	remote := &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+	path := newPath(b.nextID, "remote", conn, remote, false, now)
+	b.nextID++
+	b.paths = append(b.paths, path)
+	b.sendProbe(path, now)
+
+	return path
+}
+
+// deliver queues a received payload without blocking
+func (b *Bond) deliver(payload []byte) {
+	select {
+	case b.recvChan <- payload:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// markConnDown marks every path using a failed socket as down
+func (b *Bond) markConnDown(conn *net.UDPConn) {
+	b.mu.RLock()
+	paths := b.paths
+	b.mu.RUnlock()
+
+	for _, path := range paths {
+		if path.conn == conn && path.markDown() {
+			b.pathChanged(path)
+		}
+	}
+}
+
+// pathChanged reports a path coming up or going down to Config.OnPathChange
+func (b *Bond) pathChanged(path *Path) {
+	if b.config.OnPathChange != nil {
+		b.config.OnPathChange(path.Stats())
+	}
+}
+
+// maintenanceLoop probes paths and skips reorder gaps that timed out
+func (b *Bond) maintenanceLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.config.ReorderTimeout / 2)
+	defer ticker.Stop()
+
+	lastProbe := time.Now()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.reorderMu.Lock()
+			out := b.reorder.Flush(now)
+			b.reorderMu.Unlock()
+
+			for _, payload := range out {
+				b.deliver(payload)
+			}
+
+			if b.sequenced.Load() && now.Sub(lastProbe) >= b.config.ProbeInterval {
+				lastProbe = now
+				b.probeAll(now)
+			}
+		}
+	}
+}
+
+// probeAll expires unanswered probes and sends a new probe on every path
+func (b *Bond) probeAll(now time.Time) {
+	b.mu.RLock()
+	paths := b.paths
+	b.mu.RUnlock()
+
+	for _, path := range paths {
+		if path.expireProbes(now, 2*b.config.ProbeInterval, b.config.PathTimeout) {
+			b.pathChanged(path)
+		}
+		b.sendProbe(path, now)
+	}
+}
+
+// sendProbe sends a probe with an unguessable ID, so only a peer that received it can answer
+func (b *Bond) sendProbe(path *Path, now time.Time) {
+	id := randomUint32()
+	path.probeSent(id, now)
+	path.write(encodeProbe(typeProbe, id))
+}
+
+// randomUint32 returns a random 32-bit value
+func randomUint32() uint32 {
+	var buf [4]byte
+	rand.Read(buf[:])
+	return binary.BigEndian.Uint32(buf[:])
+}
@@ -0,0 +1,242 @@
+package multipath
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenLoopback opens a UDP socket on the loopback interface
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	return conn
+}
+
+// bondPair connects an active bond with two paths to a passive bond that adopts the second
+func bondPair(t *testing.T, mode Mode) (active, passive *Bond) {
+	t.Helper()
+
+	primary := listenLoopback(t)
+	secondary := listenLoopback(t)
+	peer := listenLoopback(t)
+
+	config := Config{Mode: mode, ProbeInterval: 20 * time.Millisecond, ReorderTimeout: 20 * time.Millisecond}
+	active = NewBond(config)
+	config.AcceptPaths = true
+	passive = NewBond(config)
+	t.Cleanup(func() {
+		active.Close()
+		passive.Close()
+	})
+
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	if err := active.AddPath("primary", primary, peerAddr, true); err != nil {
+		t.Fatalf("AddPath failed: %v", err)
+	}
+	if err := passive.AddPath("primary", peer, primary.LocalAddr().(*net.UDPAddr), true); err != nil {
+		t.Fatalf("AddPath failed: %v", err)
+	}
+
+	active.SetSequenced(true)
+	passive.SetSequenced(true)
+
+	if err := active.AddPath("secondary", secondary, peerAddr, false); err != nil {
+		t.Fatalf("AddPath failed: %v", err)
+	}
+
+	waitPathsUp(t, active, 2)
+	waitPathsUp(t, passive, 2)
+	return active, passive
+}
+
+// waitPathsUp waits until the bond has n paths up
+func waitPathsUp(t *testing.T, b *Bond, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		up := 0
+		for _, path := range b.Paths() {
+			if path.Up {
+				up++
+			}
+		}
+		if up >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d paths up: %+v", n, b.Paths())
+}
+
+// receive reads n payloads from the bond
+func receive(t *testing.T, b *Bond, n int) [][]byte {
+	t.Helper()
+	var payloads [][]byte
+	for len(payloads) < n {
+		select {
+		case payload := <-b.RecvChannel():
+			payloads = append(payloads, payload)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Received %d of %d payloads", len(payloads), n)
+		}
+	}
+	return payloads
+}
+
+// TestBondRoundRobin tests that traffic is spread over both paths and arrives in order
+func TestBondRoundRobin(t *testing.T) {
+	active, passive := bondPair(t, ModeRoundRobin)
+
+	for i := 0; i < 20; i++ {
+		if err := active.Send([]byte{byte(i)}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	for i, payload := range receive(t, passive, 20) {
+		if !bytes.Equal(payload, []byte{byte(i)}) {
+			t.Fatalf("Payload %d out of order: %v", i, payload)
+		}
+	}
+
+	for _, path := range active.Paths() {
+		if path.TxPackets < 10 {
+			t.Errorf("Path %s sent %d datagrams, expected at least 10", path.Interface, path.TxPackets)
+		}
+		if path.RTT <= 0 {
+			t.Errorf("Path %s has no RTT sample", path.Interface)
+		}
+	}
+}
+
+// TestBondRedundant tests that redundant copies are delivered once
+func TestBondRedundant(t *testing.T) {
+	active, passive := bondPair(t, ModeRedundant)
+
+	for i := 0; i < 10; i++ {
+		active.Send([]byte{byte(i)})
+	}
+	receive(t, passive, 10)
+
+	select {
+	case payload := <-passive.RecvChannel():
+		t.Errorf("Duplicate delivered: %v", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, duplicates, _ := passive.ReorderStats(); duplicates != 10 {
+		t.Errorf("Expected 10 duplicates dropped, got %d", duplicates)
+	}
+}
+
+// TestBondPlainPassthrough tests that an unsequenced bond is a plain UDP transport
+func TestBondPlainPassthrough(t *testing.T) {
+	local := listenLoopback(t)
+	remote := listenLoopback(t)
+	defer remote.Close()
+
+	b := NewBond(Config{})
+	defer b.Close()
+	b.AddPath("primary", local, remote.LocalAddr().(*net.UDPAddr), true)
+
+	if err := b.Send([]byte("frame")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	buffer := make([]byte, 64)
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := remote.ReadFromUDP(buffer)
+	if err != nil || string(buffer[:n]) != "frame" {
+		t.Fatalf("Expected plain datagram, got %q (err %v)", buffer[:n], err)
+	}
+
+	// Plain datagrams from the peer are delivered as-is
+	remote.WriteToUDP([]byte("reply"), local.LocalAddr().(*net.UDPAddr))
+	if payload := receive(t, b, 1)[0]; string(payload) != "reply" {
+		t.Errorf("Received %q, expected reply", payload)
+	}
+}
+
+// TestBondIgnoresStrangers tests that unknown addresses cannot open a path without AcceptPaths
+func TestBondIgnoresStrangers(t *testing.T) {
+	local := listenLoopback(t)
+	peer := listenLoopback(t)
+	stranger := listenLoopback(t)
+	defer peer.Close()
+	defer stranger.Close()
+
+	b := NewBond(Config{})
+	defer b.Close()
+	b.AddPath("primary", local, peer.LocalAddr().(*net.UDPAddr), true)
+
+	localAddr := local.LocalAddr().(*net.UDPAddr)
+	stranger.WriteToUDP(encodeProbe(typeProbe, 1), localAddr)
+	stranger.WriteToUDP(encodeData(1, []byte("data")), localAddr)
+
+	select {
+	case payload := <-b.RecvChannel():
+		t.Errorf("Delivered datagram from unknown path: %q", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if paths := b.Paths(); len(paths) != 1 {
+		t.Errorf("Expected 1 path, got %d", len(paths))
+	}
+}
+
+// TestBondPathChanges tests that paths coming up and going down are reported
+func TestBondPathChanges(t *testing.T) {
+	primary := listenLoopback(t)
+	secondary := listenLoopback(t)
+	peer := listenLoopback(t)
+
+	changes := make(chan PathStats, 16)
+	active := NewBond(Config{
+		ProbeInterval: 20 * time.Millisecond,
+		PathTimeout:   100 * time.Millisecond,
+		OnPathChange:  func(stats PathStats) { changes <- stats },
+	})
+	passive := NewBond(Config{AcceptPaths: true})
+	t.Cleanup(func() {
+		active.Close()
+		passive.Close()
+	})
+
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	active.AddPath("primary", primary, peerAddr, true)
+	passive.AddPath("primary", peer, primary.LocalAddr().(*net.UDPAddr), true)
+	active.SetSequenced(true)
+	passive.SetSequenced(true)
+	active.AddPath("secondary", secondary, peerAddr, false)
+
+	select {
+	case stats := <-changes:
+		if stats.Interface != "secondary" || !stats.Up {
+			t.Errorf("Expected secondary up, got %+v", stats)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Secondary path not reported up")
+	}
+
+	// Once the peer stops answering, both paths time out
+	passive.Close()
+	down := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case stats := <-changes:
+			if stats.Up {
+				t.Errorf("Unexpected path up: %+v", stats)
+			}
+			down[stats.Interface] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Paths not reported down: %v", down)
+		}
+	}
+	if !down["primary"] || !down["secondary"] {
+		t.Errorf("Expected both paths down, got %v", down)
+	}
+}
@@ -0,0 +1,104 @@
+package multipath
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// ListenInterface opens a UDP socket whose traffic leaves through the named interface
+// The socket is bound to the interface's IPv4 address and, where supported,
+// to the device itself so the routing table cannot move it to another uplink.
+func ListenInterface(name string) (*net.UDPConn, error) {
+	ip, err := interfaceIPv4(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config := net.ListenConfig{Control: bindToDevice(name)}
+	conn, err := config.ListenPacket(context.Background(), "udp4", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket on %s: %w", name, err)
+	}
+
+	return conn.(*net.UDPConn), nil
+}
+
+// Interfaces returns the up, non-loopback interfaces with a global IPv4 address
+// Names in exclude (e.g. the tunnel device itself) are skipped.
+func Interfaces(exclude ...string) ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || skip[iface.Name] {
+			continue
+		}
+		if _, err := interfaceIPv4(iface.Name); err == nil {
+			names = append(names, iface.Name)
+		}
+	}
+	return names, nil
+}
+
+// interfaceIPv4 returns the first global unicast IPv4 address of an interface
+func interfaceIPv4(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil && ip.IsGlobalUnicast() {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+// RouteInterface returns the interface the routing table uses to reach remote
+// No packet is sent; the kernel picks the source address when the socket connects.
+func RouteInterface(remote *net.UDPAddr) (string, error) {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "", fmt.Errorf("no route to %s: %w", remote, err)
+	}
+	defer conn.Close()
+
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has address %s", local)
+}
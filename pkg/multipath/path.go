@@ -0,0 +1,179 @@
+package multipath
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rttSmoothing and lossSmoothing weight each new sample in the moving averages
+const (
+	rttSmoothing  = 0.125
+	lossSmoothing = 0.1
+)
+
+// Path is one socket and remote address pair between the peers
+type Path struct {
+	id     uint8
+	name   string // Local interface, or "remote" for paths opened by the peer
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+
+	mu      sync.Mutex
+	up      bool
+	rtt     time.Duration
+	loss    float64
+	lastAck time.Time
+	probes  map[uint32]time.Time // Outstanding probe ID → send time
+
+	txPackets atomic.Uint64
+	rxPackets atomic.Uint64
+	txBytes   atomic.Uint64
+	rxBytes   atomic.Uint64
+}
+
+// PathStats is a snapshot of a path's state for scheduling and the status API
+type PathStats struct {
+	ID        uint8         `json:"id"`
+	Interface string        `json:"interface"`
+	Local     string        `json:"local"`
+	Remote    string        `json:"remote"`
+	Up        bool          `json:"up"`
+	RTT       time.Duration `json:"-"`
+	Loss      float64       `json:"loss"`
+	TxPackets uint64        `json:"tx_packets"`
+	RxPackets uint64        `json:"rx_packets"`
+	TxBytes   uint64        `json:"tx_bytes"`
+	RxBytes   uint64        `json:"rx_bytes"`
+}
+
+// MarshalJSON reports the RTT in milliseconds
+func (s PathStats) MarshalJSON() ([]byte, error) {
+	type plain PathStats
+	return json.Marshal(struct {
+		plain
+		RTTMillis float64 `json:"rtt_ms"`
+	}{plain(s), float64(s.RTT) / float64(time.Millisecond)})
+}
+
+// newPath creates a path; verified paths are usable before their first probe is answered
+func newPath(id uint8, name string, conn *net.UDPConn, remote *net.UDPAddr, verified bool, now time.Time) *Path {
+	path := &Path{
+		id:     id,
+		name:   name,
+		conn:   conn,
+		remote: remote,
+		up:     verified,
+		probes: make(map[uint32]time.Time),
+	}
+	if verified {
+		path.lastAck = now
+	}
+	return path
+}
+
+// matches reports whether a datagram from addr on conn belongs to this path
+func (p *Path) matches(conn *net.UDPConn, addr *net.UDPAddr) bool {
+	return p.conn == conn && p.remote.IP.Equal(addr.IP) && p.remote.Port == addr.Port
+}
+
+// write sends a datagram on the path
+func (p *Path) write(datagram []byte) error {
+	if _, err := p.conn.WriteToUDP(datagram, p.remote); err != nil {
+		return err
+	}
+	p.txPackets.Add(1)
+	p.txBytes.Add(uint64(len(datagram)))
+	return nil
+}
+
+// received counts an inbound datagram
+func (p *Path) received(size int) {
+	p.rxPackets.Add(1)
+	p.rxBytes.Add(uint64(size))
+}
+
+// probeSent records an outstanding probe
+func (p *Path) probeSent(id uint32, now time.Time) {
+	p.mu.Lock()
+	p.probes[id] = now
+	p.mu.Unlock()
+}
+
+// probeAcked updates RTT and loss from a probe answer
+// Returns true if the answer brought the path up; unknown probes are ignored.
+func (p *Path) probeAcked(id uint32, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sent, ok := p.probes[id]
+	if !ok {
+		return false
+	}
+	delete(p.probes, id)
+
+	sample := now.Sub(sent)
+	if p.rtt == 0 {
+		p.rtt = sample
+	} else {
+		p.rtt += time.Duration(rttSmoothing * float64(sample-p.rtt))
+	}
+	p.loss -= lossSmoothing * p.loss
+	p.lastAck = now
+	wasUp := p.up
+	p.up = true
+	return !wasUp
+}
+
+// expireProbes counts unanswered probes as lost and marks the path down when it stops answering
+// Returns true if the path went down.
+func (p *Path) expireProbes(now time.Time, probeTimeout, pathTimeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, sent := range p.probes {
+		if now.Sub(sent) >= probeTimeout {
+			delete(p.probes, id)
+			p.loss += lossSmoothing * (1 - p.loss)
+		}
+	}
+
+	if p.up && now.Sub(p.lastAck) >= pathTimeout {
+		p.up = false
+		return true
+	}
+	return false
+}
+
+// markDown marks the path down, returning true if it was up
+func (p *Path) markDown() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wasUp := p.up
+	p.up = false
+	return wasUp
+}
+
+// Stats returns a snapshot of the path
+func (p *Path) Stats() PathStats {
+	p.mu.Lock()
+	up, rtt, loss := p.up, p.rtt, p.loss
+	p.mu.Unlock()
+
+	return PathStats{
+		ID:        p.id,
+		Interface: p.name,
+		Local:     p.conn.LocalAddr().String(),
+		Remote:    p.remote.String(),
+		Up:        up,
+		RTT:       rtt,
+		Loss:      loss,
+		TxPackets: p.txPackets.Load(),
+		RxPackets: p.rxPackets.Load(),
+		TxBytes:   p.txBytes.Load(),
+		RxBytes:   p.rxBytes.Load(),
+	}
+}
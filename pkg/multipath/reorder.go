@@ -0,0 +1,164 @@
+package multipath
+
+import "time"
+
+const (
+	// DefaultReorderWindow is the most frames held back waiting for a missing one
+	DefaultReorderWindow = 64
+
+	// DefaultReorderTimeout is how long a gap is waited for before it is skipped
+	DefaultReorderTimeout = 50 * time.Millisecond
+
+	// resetDistance is a sequence jump treated as the peer restarting its counter
+	resetDistance = 1 << 16
+)
+
+// heldFrame is a frame waiting for the frames before it
+type heldFrame struct {
+	payload []byte
+	arrived time.Time
+}
+
+// Reorderer restores send order of sequenced frames that arrive over several paths
+// Missing frames are waited for up to the timeout, or until the window fills,
+// and duplicates (e.g. from redundant scheduling) are dropped.
+// Not thread-safe: callers serialize Push and Flush.
+type Reorderer struct {
+	window  int
+	timeout time.Duration
+
+	started bool
+	next    uint32 // Next sequence number to deliver
+	held    map[uint32]heldFrame
+
+	reordered  uint64
+	duplicates uint64
+	skipped    uint64
+}
+
+// NewReorderer creates a reorder buffer (zero values select the defaults)
+func NewReorderer(window int, timeout time.Duration) *Reorderer {
+	if window <= 0 {
+		window = DefaultReorderWindow
+	}
+	if timeout <= 0 {
+		timeout = DefaultReorderTimeout
+	}
+
+	return &Reorderer{
+		window:  window,
+		timeout: timeout,
+		held:    make(map[uint32]heldFrame),
+	}
+}
+
+// Push accepts a received frame and returns the frames now deliverable, in order
+func (r *Reorderer) Push(seq uint32, payload []byte, now time.Time) [][]byte {
+	if !r.started {
+		r.started = true
+		r.next = seq
+	}
+
+	distance := int32(seq - r.next)
+	if distance >= resetDistance || distance <= -resetDistance {
+		// Peer restarted its sequence; deliver what we hold and follow the new counter
+		out := r.drainAll()
+		r.next = seq
+		return append(out, r.accept(seq, payload, now)...)
+	}
+
+	if distance < 0 {
+		// Already delivered or skipped
+		r.duplicates++
+		return nil
+	}
+
+	return r.accept(seq, payload, now)
+}
+
+// accept delivers or holds a frame at or after the next expected sequence
+func (r *Reorderer) accept(seq uint32, payload []byte, now time.Time) [][]byte {
+	if seq != r.next {
+		if _, ok := r.held[seq]; ok {
+			r.duplicates++
+			return nil
+		}
+		r.held[seq] = heldFrame{payload: payload, arrived: now}
+
+		// A full window means the gap is not going to be filled in time
+		var out [][]byte
+		for len(r.held) > r.window {
+			out = append(out, r.skipGap()...)
+		}
+		return out
+	}
+
+	out := [][]byte{payload}
+	r.next++
+	return append(out, r.drain()...)
+}
+
+// Flush skips gaps whose following frames have waited longer than the timeout
+func (r *Reorderer) Flush(now time.Time) [][]byte {
+	var out [][]byte
+	for len(r.held) > 0 && now.Sub(r.oldestArrival()) >= r.timeout {
+		out = append(out, r.skipGap()...)
+	}
+	return out
+}
+
+// drain delivers held frames that are now in sequence
+func (r *Reorderer) drain() [][]byte {
+	var out [][]byte
+	for {
+		frame, ok := r.held[r.next]
+		if !ok {
+			return out
+		}
+		delete(r.held, r.next)
+		out = append(out, frame.payload)
+		r.next++
+		r.reordered++
+	}
+}
+
+// skipGap gives up on the missing frames before the earliest held one
+func (r *Reorderer) skipGap() [][]byte {
+	earliest := r.next
+	first := true
+	for seq := range r.held {
+		if first || seq-r.next < earliest-r.next {
+			earliest = seq
+			first = false
+		}
+	}
+
+	r.skipped += uint64(earliest - r.next)
+	r.next = earliest
+	return r.drain()
+}
+
+// drainAll delivers every held frame in sequence order, skipping gaps
+func (r *Reorderer) drainAll() [][]byte {
+	var out [][]byte
+	for len(r.held) > 0 {
+		out = append(out, r.skipGap()...)
+	}
+	return out
+}
+
+// oldestArrival returns when the longest-waiting held frame arrived
+func (r *Reorderer) oldestArrival() time.Time {
+	var oldest time.Time
+	for _, frame := range r.held {
+		if oldest.IsZero() || frame.arrived.Before(oldest) {
+			oldest = frame.arrived
+		}
+	}
+	return oldest
+}
+
+// Stats returns frames delivered out of arrival order, duplicates dropped, and frames given up on
+func (r *Reorderer) Stats() (reordered, duplicates, skipped uint64) {
+	return r.reordered, r.duplicates, r.skipped
+}
@@ -0,0 +1,138 @@
+package multipath
+
+import (
+	"testing"
+	"time"
+)
+
+// seqs returns the sequence numbers encoded as single-byte payloads
+func seqs(frames [][]byte) []int {
+	out := make([]int, len(frames))
+	for i, frame := range frames {
+		out[i] = int(frame[0])
+	}
+	return out
+}
+
+// equalInts reports whether two int slices are equal
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestReorderRestoresOrder tests that out-of-order frames are delivered in sequence
+func TestReorderRestoresOrder(t *testing.T) {
+	r := NewReorderer(8, time.Second)
+	now := time.Now()
+
+	var delivered [][]byte
+	for _, seq := range []uint32{10, 12, 13, 11, 14} {
+		delivered = append(delivered, r.Push(seq, []byte{byte(seq)}, now)...)
+	}
+
+	if got := seqs(delivered); !equalInts(got, []int{10, 11, 12, 13, 14}) {
+		t.Errorf("Delivered %v, expected 10..14 in order", got)
+	}
+	if reordered, _, _ := r.Stats(); reordered != 2 {
+		t.Errorf("Expected 2 reordered frames, got %d", reordered)
+	}
+}
+
+// TestReorderDropsDuplicates tests that redundant copies are delivered once
+func TestReorderDropsDuplicates(t *testing.T) {
+	r := NewReorderer(8, time.Second)
+	now := time.Now()
+
+	var delivered [][]byte
+	for _, seq := range []uint32{1, 1, 3, 3, 2, 2} {
+		delivered = append(delivered, r.Push(seq, []byte{byte(seq)}, now)...)
+	}
+
+	if got := seqs(delivered); !equalInts(got, []int{1, 2, 3}) {
+		t.Errorf("Delivered %v, expected [1 2 3]", got)
+	}
+	if _, duplicates, _ := r.Stats(); duplicates != 3 {
+		t.Errorf("Expected 3 duplicates, got %d", duplicates)
+	}
+}
+
+// TestReorderTimeoutSkipsGap tests that a lost frame stops holding back later ones after the timeout
+func TestReorderTimeoutSkipsGap(t *testing.T) {
+	r := NewReorderer(8, 50*time.Millisecond)
+	now := time.Now()
+
+	r.Push(1, []byte{1}, now)
+	if out := r.Push(3, []byte{3}, now); len(out) != 0 {
+		t.Fatal("Frame after a gap delivered before the timeout")
+	}
+	if out := r.Flush(now.Add(10 * time.Millisecond)); len(out) != 0 {
+		t.Fatal("Gap skipped before the timeout")
+	}
+
+	out := r.Flush(now.Add(50 * time.Millisecond))
+	if got := seqs(out); !equalInts(got, []int{3}) {
+		t.Errorf("Flush delivered %v, expected [3]", got)
+	}
+	if _, _, skipped := r.Stats(); skipped != 1 {
+		t.Errorf("Expected 1 skipped frame, got %d", skipped)
+	}
+
+	// The lost frame arriving late is not delivered out of order
+	if out := r.Push(2, []byte{2}, now); len(out) != 0 {
+		t.Error("Late frame delivered after its gap was skipped")
+	}
+}
+
+// TestReorderWindowFull tests that a full window skips the gap without waiting
+func TestReorderWindowFull(t *testing.T) {
+	r := NewReorderer(2, time.Hour)
+	now := time.Now()
+
+	r.Push(0, []byte{0}, now)
+	r.Push(2, []byte{2}, now)
+	r.Push(3, []byte{3}, now)
+	out := r.Push(4, []byte{4}, now)
+
+	if got := seqs(out); !equalInts(got, []int{2, 3, 4}) {
+		t.Errorf("Delivered %v, expected [2 3 4]", got)
+	}
+}
+
+// TestReorderSequenceWrap tests delivery across the 32-bit sequence wrap
+func TestReorderSequenceWrap(t *testing.T) {
+	r := NewReorderer(8, time.Second)
+	now := time.Now()
+
+	var delivered int
+	for _, seq := range []uint32{0xFFFFFFFE, 0, 0xFFFFFFFF, 1} {
+		delivered += len(r.Push(seq, []byte{0}, now))
+	}
+
+	if delivered != 4 {
+		t.Errorf("Expected 4 frames across the wrap, got %d", delivered)
+	}
+}
+
+// TestReorderPeerRestart tests that a large sequence jump resets the buffer
+func TestReorderPeerRestart(t *testing.T) {
+	r := NewReorderer(8, time.Second)
+	now := time.Now()
+
+	r.Push(100, []byte{1}, now)
+	r.Push(102, []byte{2}, now)
+
+	out := r.Push(5_000_000, []byte{3}, now)
+	if got := seqs(out); !equalInts(got, []int{2, 3}) {
+		t.Errorf("Delivered %v on restart, expected [2 3]", got)
+	}
+	if out := r.Push(5_000_001, []byte{4}, now); len(out) != 1 {
+		t.Error("Frame after restart not delivered")
+	}
+}
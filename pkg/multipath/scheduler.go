@@ -0,0 +1,108 @@
+package multipath
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mode selects how frames are spread across paths
+type Mode int
+
+const (
+	// ModeLowestLatency sends every frame on the path with the lowest loss-adjusted RTT
+	ModeLowestLatency Mode = iota
+	// ModeRoundRobin alternates frames across healthy paths to aggregate bandwidth
+	ModeRoundRobin
+	// ModeRedundant sends every frame on every path; the receiver keeps the first copy
+	ModeRedundant
+)
+
+// lossyThreshold is the loss above which round-robin stops using a path while a better one exists
+const lossyThreshold = 0.2
+
+// String returns the configuration name of the mode
+func (m Mode) String() string {
+	switch m {
+	case ModeLowestLatency:
+		return "lowest_latency"
+	case ModeRoundRobin:
+		return "round_robin"
+	case ModeRedundant:
+		return "redundant"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseMode parses a mode name from configuration ("" selects lowest_latency)
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "lowest_latency":
+		return ModeLowestLatency, nil
+	case "round_robin":
+		return ModeRoundRobin, nil
+	case "redundant":
+		return ModeRedundant, nil
+	default:
+		return 0, fmt.Errorf("unknown multipath mode %q (expected round_robin, lowest_latency or redundant)", s)
+	}
+}
+
+// Select returns the indices of the paths the next frame is sent on
+// counter advances round-robin scheduling. With no usable path the first
+// path is returned so traffic keeps flowing while probes recover.
+func (m Mode) Select(paths []PathStats, counter uint64) []int {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var up []int
+	for i, path := range paths {
+		if path.Up {
+			up = append(up, i)
+		}
+	}
+	if len(up) == 0 {
+		return []int{0}
+	}
+
+	switch m {
+	case ModeRedundant:
+		return up
+
+	case ModeRoundRobin:
+		var healthy []int
+		for _, i := range up {
+			if paths[i].Loss <= lossyThreshold {
+				healthy = append(healthy, i)
+			}
+		}
+		if len(healthy) == 0 {
+			healthy = up
+		}
+		return []int{healthy[counter%uint64(len(healthy))]}
+
+	default:
+		best := up[0]
+		for _, i := range up[1:] {
+			if effectiveLatency(paths[i]) < effectiveLatency(paths[best]) {
+				best = i
+			}
+		}
+		return []int{best}
+	}
+}
+
+// effectiveLatency ranks a path by RTT inflated by its loss
+// Paths without an RTT sample rank last.
+func effectiveLatency(path PathStats) time.Duration {
+	if path.RTT <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+
+	loss := path.Loss
+	if loss > 0.9 {
+		loss = 0.9
+	}
+	return time.Duration(float64(path.RTT) / (1 - loss))
+}
@@ -0,0 +1,79 @@
+package multipath
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseMode tests mode parsing and names
+func TestParseMode(t *testing.T) {
+	for _, mode := range []Mode{ModeLowestLatency, ModeRoundRobin, ModeRedundant} {
+		parsed, err := ParseMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("ParseMode(%q) = %v, %v", mode.String(), parsed, err)
+		}
+	}
+
+	if mode, err := ParseMode(""); err != nil || mode != ModeLowestLatency {
+		t.Errorf("Empty mode should default to lowest_latency, got %v, %v", mode, err)
+	}
+	if _, err := ParseMode("fastest"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+// TestSelectLowestLatency tests that the path with the lowest loss-adjusted RTT is chosen
+func TestSelectLowestLatency(t *testing.T) {
+	paths := []PathStats{
+		{Up: true, RTT: 40 * time.Millisecond},             // fibre
+		{Up: true, RTT: 30 * time.Millisecond, Loss: 0.5},  // lossy LTE: effectively 60ms
+		{Up: false, RTT: 5 * time.Millisecond},             // down
+		{Up: true, RTT: 35 * time.Millisecond, Loss: 0.01}, // second fibre
+		{Up: true}, // not yet measured
+	}
+
+	if got := ModeLowestLatency.Select(paths, 0); len(got) != 1 || got[0] != 3 {
+		t.Errorf("Selected %v, expected [3]", got)
+	}
+}
+
+// TestSelectRoundRobin tests that round-robin alternates over healthy paths
+func TestSelectRoundRobin(t *testing.T) {
+	paths := []PathStats{
+		{Up: true, Loss: 0.01},
+		{Up: true, Loss: 0.5}, // too lossy while others are healthy
+		{Up: true},
+		{Up: false},
+	}
+
+	var got []int
+	for counter := uint64(0); counter < 4; counter++ {
+		got = append(got, ModeRoundRobin.Select(paths, counter)...)
+	}
+	if !equalInts(got, []int{0, 2, 0, 2}) {
+		t.Errorf("Round-robin selected %v, expected [0 2 0 2]", got)
+	}
+}
+
+// TestSelectRedundant tests that redundant mode uses every path that is up
+func TestSelectRedundant(t *testing.T) {
+	paths := []PathStats{{Up: true}, {Up: false}, {Up: true}}
+
+	if got := ModeRedundant.Select(paths, 0); !equalInts(got, []int{0, 2}) {
+		t.Errorf("Selected %v, expected [0 2]", got)
+	}
+}
+
+// TestSelectNoPathUp tests the fallback to the first path when none is up
+func TestSelectNoPathUp(t *testing.T) {
+	paths := []PathStats{{Up: false}, {Up: false}}
+
+	for _, mode := range []Mode{ModeLowestLatency, ModeRoundRobin, ModeRedundant} {
+		if got := mode.Select(paths, 7); !equalInts(got, []int{0}) {
+			t.Errorf("%s selected %v, expected [0]", mode, got)
+		}
+	}
+	if got := ModeRedundant.Select(nil, 0); len(got) != 0 {
+		t.Errorf("Selected %v with no paths", got)
+	}
+}
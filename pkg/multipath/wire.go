@@ -0,0 +1,89 @@
+// Package multipath bonds several UDP paths between two peers into one transport.
+//
+// Every local uplink (fibre, LTE, ...) gets its own socket and therefore its own
+// path to the peer. Frames are scheduled across the paths that answer probes,
+// using per-path RTT and loss, and put back in order on receipt.
+//
+// Datagram formats (first byte 0xFD, distinct from encrypted frames and FEC shards):
+//
+//	Data:      [1 magic][1 type=0][4 sequence][payload]
+//	Probe:     [1 magic][1 type=1][4 probe ID]
+//	Probe ack: [1 magic][1 type=2][4 probe ID]
+//
+// Datagrams without the magic byte are passed through unchanged, so a bond can
+// talk to a peer that does not use multipath.
+package multipath
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// Magic is the first byte of every multipath datagram
+	Magic = 0xFD
+
+	// DataHeaderSize is the header prepended to each data datagram
+	DataHeaderSize = 6
+
+	// probeSize is the length of probe and probe ack datagrams
+	probeSize = 6
+)
+
+// Datagram types
+const (
+	typeData     = 0
+	typeProbe    = 1
+	typeProbeAck = 2
+)
+
+var (
+	// ErrInvalidDatagram is returned for truncated or unknown multipath datagrams
+	ErrInvalidDatagram = errors.New("invalid multipath datagram")
+)
+
+// IsDatagram reports whether a received datagram carries a multipath header
+func IsDatagram(datagram []byte) bool {
+	return len(datagram) > 0 && datagram[0] == Magic
+}
+
+// encodeData prepends the data header to a payload
+func encodeData(seq uint32, payload []byte) []byte {
+	datagram := make([]byte, DataHeaderSize+len(payload))
+	datagram[0] = Magic
+	datagram[1] = typeData
+	binary.BigEndian.PutUint32(datagram[2:6], seq)
+	copy(datagram[DataHeaderSize:], payload)
+	return datagram
+}
+
+// encodeProbe builds a probe or probe ack datagram
+func encodeProbe(datagramType byte, probeID uint32) []byte {
+	datagram := make([]byte, probeSize)
+	datagram[0] = Magic
+	datagram[1] = datagramType
+	binary.BigEndian.PutUint32(datagram[2:6], probeID)
+	return datagram
+}
+
+// decode parses a multipath datagram into its type, sequence or probe ID, and payload
+func decode(datagram []byte) (datagramType byte, id uint32, payload []byte, err error) {
+	if len(datagram) < DataHeaderSize || datagram[0] != Magic {
+		return 0, 0, nil, ErrInvalidDatagram
+	}
+
+	datagramType = datagram[1]
+	id = binary.BigEndian.Uint32(datagram[2:6])
+
+	switch datagramType {
+	case typeData:
+		return datagramType, id, datagram[DataHeaderSize:], nil
+	case typeProbe, typeProbeAck:
+		if len(datagram) != probeSize {
+			return 0, 0, nil, ErrInvalidDatagram
+		}
+		return datagramType, id, nil, nil
+	default:
+		return 0, 0, nil, ErrInvalidDatagram
+	}
+}
@@ -92,6 +92,14 @@ func NewNATDetector() *NATDetector {
 	}
 }
 
+// SetSTUNServers replaces the STUN servers used for detection and clears the cached result
+func (nd *NATDetector) SetSTUNServers(servers ...string) {
+	nd.cacheMutex.Lock()
+	defer nd.cacheMutex.Unlock()
+	nd.stunClient = &STUNClient{servers: servers}
+	nd.cachedResult = nil
+}
+
 // SetManualOverride sets a manual NAT type override for debugging
 func (nd *NATDetector) SetManualOverride(natType NATType) {
 	nd.cacheMutex.Lock()
@@ -248,6 +248,21 @@ func TestDetectorDefaultConfiguration(t *testing.T) {
 	}
 }
 
+// TestSetSTUNServers tests replacing the STUN servers
+func TestSetSTUNServers(t *testing.T) {
+	detector := NewNATDetector()
+	detector.CacheResult(&DetectionResult{NATType: NATTypeFullCone, DetectedAt: time.Now()}, time.Hour)
+
+	detector.SetSTUNServers("stun.example.net:3478")
+
+	if servers := detector.stunClient.servers; len(servers) != 1 || servers[0] != "stun.example.net:3478" {
+		t.Errorf("Expected the configured server only, got %v", servers)
+	}
+	if _, ok := detector.GetCachedResult(); ok {
+		t.Error("Expected the cached result to be cleared")
+	}
+}
+
 // TestConcurrentAccess tests concurrent access to detector
 func TestConcurrentAccess(t *testing.T) {
 	detector := NewNATDetector()
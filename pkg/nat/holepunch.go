@@ -5,14 +5,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
 )
 
+var logger = logging.Logger("nat")
+
 // ConnectionCandidate represents a connection attempt candidate
 type ConnectionCandidate struct {
 	LocalAddr  *net.UDPAddr
@@ -102,7 +105,7 @@ func (h *HolePuncher) EstablishConnection(remoteCandidates []Candidate) (*net.UD
 	select {
 	case result := <-results:
 		atomic.AddUint64(&h.metrics.SuccessCount, 1)
-		log.Printf("HolePunch: Connection established to %s", result.RemoteAddr)
+		logger.Info("hole punch succeeded", "remote", result.RemoteAddr)
 		return result.Conn, nil
 	case <-ctx.Done():
 		atomic.AddUint64(&h.metrics.TimeoutCount, 1)
@@ -146,7 +149,7 @@ func (h *HolePuncher) tryCandidate(ctx context.Context, candidate Candidate, res
 				return
 			}
 
-			log.Printf("Received packet from unexpected address: %v (expected %v)", addr, remoteAddr)
+			logger.Debug("hole punch packet from unexpected address", "from", addr, "expected", remoteAddr)
 		}
 	}
 }
@@ -0,0 +1,309 @@
+// Package neighbor answers ARP and IPv6 neighbour discovery for peers and limits flooding in TAP mode
+//
+// A TAP mesh is one Ethernet segment, so every ARP request and neighbour
+// solicitation is flooded to all peers, through the relay. A Table learns the
+// MAC address behind each peer address from the ARP and neighbour discovery
+// messages peers send, and Reply answers requests for those addresses on the
+// peers' behalf, so they need not leave the node. A Suppressor rate-limits the
+// broadcast and multicast frames that are still flooded and drops duplicates.
+package neighbor
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// DefaultTTL is how long a binding is used without hearing from its address
+const DefaultTTL = 5 * time.Minute
+
+// maxBindings bounds the table; new addresses beyond it are not learned
+const maxBindings = 4096
+
+// ARP and ICMPv6 message fields (RFC 826, RFC 4861)
+const (
+	arpSize             = 28
+	arpRequest          = 1
+	arpReply            = 2
+	ipv6HeaderSize      = 40
+	protoICMPv6         = 58
+	ndSolicitation      = 135
+	ndAdvert            = 136
+	ndMessageSize       = 24 // Type, code, checksum, flags and target address
+	ndSourceLL          = 1  // Source link-layer address option
+	ndTargetLL          = 2  // Target link-layer address option
+	ndHopLimit          = 255
+	ndSolicitedOverride = 0x60 // Solicited and override flags of an advertisement
+)
+
+// binding is the MAC address behind a peer address
+type binding struct {
+	mac  [6]byte
+	seen time.Time
+}
+
+// Table holds the MAC addresses peers announced for their IP addresses
+// Thread-safe.
+type Table struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	bindings map[netip.Addr]binding
+}
+
+// NewTable creates an empty table whose bindings expire ttl after their address was last heard
+func NewTable(ttl time.Duration) *Table {
+	return &Table{ttl: ttl, now: time.Now, bindings: make(map[netip.Addr]binding)}
+}
+
+// Learn records the bindings in a frame a peer sent
+// ARP messages bind their sender, neighbour solicitations their source and
+// advertisements their target. Other IP packets only keep an existing binding of
+// their source alive when they come from its MAC address. Addresses permit
+// rejects, e.g. those the peer may not use, are not learned; nil permits all.
+func (t *Table) Learn(frame []byte, permit func(netip.Addr) bool) {
+	etherType, payload, ok := layer2.SplitFrame(frame)
+	if !ok {
+		return
+	}
+	srcMAC := [6]byte(frame[6:12])
+
+	switch etherType {
+	case layer2.EtherTypeARP:
+		if !ipv4ARP(payload) {
+			return
+		}
+		if sender := netip.AddrFrom4([4]byte(payload[14:18])); !sender.IsUnspecified() && (permit == nil || permit(sender)) {
+			t.learn(sender, [6]byte(payload[8:14]), true)
+		}
+	case layer2.EtherTypeIPv6:
+		if addr, mac, ok := ndBinding(payload); ok {
+			if permit == nil || permit(addr) {
+				t.learn(addr, mac, true)
+			}
+		} else if src, ok := layer2.PacketSource(payload); ok {
+			t.learn(src, srcMAC, false)
+		}
+	case layer2.EtherTypeIPv4:
+		if src, ok := layer2.PacketSource(payload); ok {
+			t.learn(src, srcMAC, false)
+		}
+	}
+}
+
+// learn binds addr to mac; unless create is set, only an existing binding to mac is refreshed
+func (t *Table) learn(addr netip.Addr, mac [6]byte, create bool) {
+	if mac[0]&1 != 0 || mac == [6]byte{} { // Multicast or unset
+		return
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, known := t.bindings[addr]
+	switch {
+	case known && current.mac == mac:
+	case !create:
+		return
+	case !known && len(t.bindings) >= maxBindings:
+		t.expire(now)
+		if len(t.bindings) >= maxBindings {
+			return
+		}
+	}
+	t.bindings[addr] = binding{mac: mac, seen: now}
+}
+
+// expire forgets the bindings not heard from within the TTL (t.mu held)
+func (t *Table) expire(now time.Time) {
+	for addr, b := range t.bindings {
+		if now.Sub(b.seen) > t.ttl {
+			delete(t.bindings, addr)
+		}
+	}
+}
+
+// Lookup returns the MAC address bound to addr, if it has been heard from within the TTL
+func (t *Table) Lookup(addr netip.Addr) ([6]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.bindings[addr]
+	if !ok || t.now().Sub(b.seen) > t.ttl {
+		return [6]byte{}, false
+	}
+	return b.mac, true
+}
+
+// Len returns the number of bindings in use, forgetting expired ones
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	return len(t.bindings)
+}
+
+// Clear forgets every binding, e.g. when the peers are gone
+func (t *Table) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.bindings)
+}
+
+// Reply answers an ARP request or neighbour solicitation for a bound address
+// frame is read from the local device. Returns the ARP reply or neighbour
+// advertisement to write back to the device, or nil if the frame is not such a
+// request, the address is not bound, or the request is a probe that must reach
+// the peers (gratuitous ARP, duplicate address detection).
+func (t *Table) Reply(frame *layer2.EthernetFrame) []byte {
+	switch frame.EtherType {
+	case layer2.EtherTypeARP:
+		return t.replyARP(frame)
+	case layer2.EtherTypeIPv6:
+		return t.replyND(frame)
+	}
+	return nil
+}
+
+// replyARP answers an IPv4 ARP request
+func (t *Table) replyARP(frame *layer2.EthernetFrame) []byte {
+	request := frame.Payload
+	if !ipv4ARP(request) || binary.BigEndian.Uint16(request[6:8]) != arpRequest {
+		return nil
+	}
+	sender := netip.AddrFrom4([4]byte(request[14:18]))
+	target := netip.AddrFrom4([4]byte(request[24:28]))
+	if sender.IsUnspecified() || sender == target {
+		return nil
+	}
+	mac, ok := t.Lookup(target)
+	if !ok || mac == frame.SourceMAC {
+		return nil
+	}
+
+	reply := make([]byte, layer2.EthernetHeaderSize+arpSize)
+	copy(reply[0:6], request[8:14])
+	copy(reply[6:12], mac[:])
+	binary.BigEndian.PutUint16(reply[12:14], layer2.EtherTypeARP)
+
+	arp := reply[layer2.EthernetHeaderSize:]
+	copy(arp[0:6], request[0:6]) // Hardware and protocol type and lengths
+	binary.BigEndian.PutUint16(arp[6:8], arpReply)
+	copy(arp[8:14], mac[:])
+	copy(arp[14:18], request[24:28])
+	copy(arp[18:28], request[8:18]) // The requester's MAC and IPv4 address
+	return reply
+}
+
+// replyND answers a neighbour solicitation with an advertisement
+func (t *Table) replyND(frame *layer2.EthernetFrame) []byte {
+	packet := frame.Payload
+	if !ndMessage(packet, ndSolicitation) {
+		return nil
+	}
+	src := netip.AddrFrom16([16]byte(packet[8:24]))
+	target := netip.AddrFrom16([16]byte(packet[48:64]))
+	if src.IsUnspecified() { // Duplicate address detection
+		return nil
+	}
+	mac, ok := t.Lookup(target)
+	if !ok || mac == frame.SourceMAC {
+		return nil
+	}
+
+	const icmpSize = ndMessageSize + 8 // With the target link-layer address option
+	reply := make([]byte, layer2.EthernetHeaderSize+ipv6HeaderSize+icmpSize)
+	copy(reply[0:6], frame.SourceMAC[:])
+	copy(reply[6:12], mac[:])
+	binary.BigEndian.PutUint16(reply[12:14], layer2.EtherTypeIPv6)
+
+	ip := reply[layer2.EthernetHeaderSize:]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], icmpSize)
+	ip[6] = protoICMPv6
+	ip[7] = ndHopLimit
+	copy(ip[8:24], packet[48:64])
+	copy(ip[24:40], packet[8:24])
+
+	icmp := ip[ipv6HeaderSize:]
+	icmp[0] = ndAdvert
+	icmp[4] = ndSolicitedOverride
+	copy(icmp[8:24], packet[48:64])
+	icmp[24] = ndTargetLL
+	icmp[25] = 1 // Length in units of 8 bytes
+	copy(icmp[26:32], mac[:])
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(ip[8:24], ip[24:40], icmp))
+	return reply
+}
+
+// ipv4ARP reports whether payload is an Ethernet ARP message for IPv4 addresses
+func ipv4ARP(payload []byte) bool {
+	return len(payload) >= arpSize &&
+		binary.BigEndian.Uint16(payload[0:2]) == 1 && // Ethernet
+		binary.BigEndian.Uint16(payload[2:4]) == layer2.EtherTypeIPv4 &&
+		payload[4] == 6 && payload[5] == 4
+}
+
+// ndMessage reports whether packet is a valid neighbour discovery message of icmpType
+// Neighbour discovery is never forwarded, so it has a hop limit of 255 and no extension headers.
+func ndMessage(packet []byte, icmpType byte) bool {
+	return len(packet) >= ipv6HeaderSize+ndMessageSize && packet[0]>>4 == 6 &&
+		packet[6] == protoICMPv6 && packet[7] == ndHopLimit &&
+		packet[ipv6HeaderSize] == icmpType && packet[ipv6HeaderSize+1] == 0
+}
+
+// ndBinding returns the binding a neighbour solicitation or advertisement announces
+func ndBinding(packet []byte) (addr netip.Addr, mac [6]byte, ok bool) {
+	var option byte
+	switch {
+	case ndMessage(packet, ndSolicitation):
+		addr, option = netip.AddrFrom16([16]byte(packet[8:24])), ndSourceLL
+	case ndMessage(packet, ndAdvert):
+		addr, option = netip.AddrFrom16([16]byte(packet[48:64])), ndTargetLL
+	default:
+		return netip.Addr{}, mac, false
+	}
+	if addr.IsUnspecified() {
+		return netip.Addr{}, mac, false
+	}
+
+	options := packet[ipv6HeaderSize+ndMessageSize:]
+	for len(options) >= 8 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			break
+		}
+		if options[0] == option && length == 8 {
+			return addr, [6]byte(options[2:8]), true
+		}
+		options = options[length:]
+	}
+	return netip.Addr{}, mac, false
+}
+
+// icmpv6Checksum computes the ICMPv6 checksum over the pseudo header and message (RFC 4443)
+// The message's checksum field must be zero.
+func icmpv6Checksum(src, dst, message []byte) uint16 {
+	var sum uint32
+	add := func(data []byte) {
+		for i := 0; i+1 < len(data); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(data[i:]))
+		}
+		if len(data)%2 == 1 {
+			sum += uint32(data[len(data)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(message)) + protoICMPv6
+	add(message)
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,229 @@
+package neighbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+var (
+	localMAC  = [6]byte{0x02, 0, 0, 0, 0, 0x01}
+	peerMAC   = [6]byte{0x02, 0, 0, 0, 0, 0x02}
+	broadcast = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// arp builds an ARP frame from sender to target
+func arp(op uint16, srcMAC [6]byte, sender, target string) *layer2.EthernetFrame {
+	payload := make([]byte, arpSize)
+	binary.BigEndian.PutUint16(payload[0:2], 1)
+	binary.BigEndian.PutUint16(payload[2:4], layer2.EtherTypeIPv4)
+	payload[4], payload[5] = 6, 4
+	binary.BigEndian.PutUint16(payload[6:8], op)
+	copy(payload[8:14], srcMAC[:])
+	copy(payload[14:18], netip.MustParseAddr(sender).AsSlice())
+	copy(payload[24:28], netip.MustParseAddr(target).AsSlice())
+	return &layer2.EthernetFrame{DestinationMAC: broadcast, SourceMAC: srcMAC, EtherType: layer2.EtherTypeARP, Payload: payload}
+}
+
+// nd builds a neighbour solicitation or advertisement with a link-layer address option
+func nd(icmpType byte, srcMAC [6]byte, src, dst, target string) *layer2.EthernetFrame {
+	icmp := make([]byte, ndMessageSize+8)
+	icmp[0] = icmpType
+	copy(icmp[8:24], netip.MustParseAddr(target).AsSlice())
+	icmp[24] = ndSourceLL
+	if icmpType == ndAdvert {
+		icmp[24] = ndTargetLL
+	}
+	icmp[25] = 1
+	copy(icmp[26:32], srcMAC[:])
+
+	packet := make([]byte, ipv6HeaderSize, ipv6HeaderSize+len(icmp))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(icmp)))
+	packet[6] = protoICMPv6
+	packet[7] = ndHopLimit
+	copy(packet[8:24], netip.MustParseAddr(src).AsSlice())
+	copy(packet[24:40], netip.MustParseAddr(dst).AsSlice())
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(packet[8:24], packet[24:40], icmp))
+	packet = append(packet, icmp...)
+
+	dstMAC := [6]byte{0x33, 0x33, 0xff, 0, 0, 0x02}
+	return &layer2.EthernetFrame{DestinationMAC: dstMAC, SourceMAC: srcMAC, EtherType: layer2.EtherTypeIPv6, Payload: packet}
+}
+
+func TestARPProxy(t *testing.T) {
+	table := NewTable(DefaultTTL)
+	table.Learn(arp(arpReply, peerMAC, "10.0.0.2", "10.0.0.1").Serialize(), nil)
+
+	if mac, ok := table.Lookup(netip.MustParseAddr("10.0.0.2")); !ok || mac != peerMAC {
+		t.Fatalf("Lookup = %x, %v; want %x", mac, ok, peerMAC)
+	}
+
+	reply := table.Reply(arp(arpRequest, localMAC, "10.0.0.1", "10.0.0.2"))
+	if reply == nil {
+		t.Fatal("request for a bound address was not answered")
+	}
+	frame, err := layer2.ParseFrame(reply)
+	if err != nil {
+		t.Fatalf("ParseFrame: %v", err)
+	}
+	if frame.DestinationMAC != localMAC || frame.SourceMAC != peerMAC || frame.EtherType != layer2.EtherTypeARP {
+		t.Errorf("reply header = %x > %x %04x", frame.SourceMAC, frame.DestinationMAC, frame.EtherType)
+	}
+	want := arp(arpReply, peerMAC, "10.0.0.2", "10.0.0.1").Payload
+	copy(want[18:24], localMAC[:])
+	if !bytes.Equal(frame.Payload, want) {
+		t.Errorf("reply = % x, want % x", frame.Payload, want)
+	}
+
+	for name, request := range map[string]*layer2.EthernetFrame{
+		"unknown":    arp(arpRequest, localMAC, "10.0.0.1", "10.0.0.3"),
+		"gratuitous": arp(arpRequest, localMAC, "10.0.0.2", "10.0.0.2"),
+		"probe":      arp(arpRequest, localMAC, "0.0.0.0", "10.0.0.2"),
+		"reply":      arp(arpReply, localMAC, "10.0.0.1", "10.0.0.2"),
+		"own":        arp(arpRequest, peerMAC, "10.0.0.1", "10.0.0.2"),
+	} {
+		if reply := table.Reply(request); reply != nil {
+			t.Errorf("%s: answered % x", name, reply)
+		}
+	}
+}
+
+func TestNDProxy(t *testing.T) {
+	table := NewTable(DefaultTTL)
+	table.Learn(nd(ndAdvert, peerMAC, "fd00::2", "fd00::1", "fd00::2").Serialize(), nil)
+
+	solicitation := nd(ndSolicitation, localMAC, "fd00::1", "ff02::1:ff00:2", "fd00::2")
+	reply := table.Reply(solicitation)
+	if reply == nil {
+		t.Fatal("solicitation for a bound address was not answered")
+	}
+	frame, err := layer2.ParseFrame(reply)
+	if err != nil {
+		t.Fatalf("ParseFrame: %v", err)
+	}
+	if frame.DestinationMAC != localMAC || frame.SourceMAC != peerMAC {
+		t.Errorf("reply header = %x > %x", frame.SourceMAC, frame.DestinationMAC)
+	}
+	packet := frame.Payload
+	if !layer2.IsIPPacket(packet) || !ndMessage(packet, ndAdvert) {
+		t.Fatalf("reply is not an advertisement: % x", packet)
+	}
+	if src, dst := netip.AddrFrom16([16]byte(packet[8:24])), netip.AddrFrom16([16]byte(packet[24:40])); src.String() != "fd00::2" || dst.String() != "fd00::1" {
+		t.Errorf("reply addresses = %s > %s", src, dst)
+	}
+	if sum := icmpv6Checksum(packet[8:24], packet[24:40], packet[ipv6HeaderSize:]); sum != 0 {
+		t.Errorf("reply checksum does not verify (%04x)", sum)
+	}
+	if packet[ipv6HeaderSize+4] != ndSolicitedOverride {
+		t.Errorf("reply flags = %02x", packet[ipv6HeaderSize+4])
+	}
+	if addr, mac, ok := ndBinding(packet); !ok || addr.String() != "fd00::2" || mac != peerMAC {
+		t.Errorf("reply announces %s at %x (%v)", addr, mac, ok)
+	}
+
+	dad := nd(ndSolicitation, localMAC, "::", "ff02::1:ff00:2", "fd00::2")
+	if reply := table.Reply(dad); reply != nil {
+		t.Errorf("duplicate address detection answered: % x", reply)
+	}
+}
+
+func TestTableLearn(t *testing.T) {
+	now := time.Unix(1000, 0)
+	table := NewTable(time.Minute)
+	table.now = func() time.Time { return now }
+	addr := netip.MustParseAddr("10.0.0.2")
+
+	// IP packets refresh bindings but do not create them
+	ip := make([]byte, 20)
+	ip[0], ip[8], ip[9] = 0x45, 64, 1
+	binary.BigEndian.PutUint16(ip[2:4], 20)
+	copy(ip[12:16], addr.AsSlice())
+	copy(ip[16:20], netip.MustParseAddr("10.0.0.1").AsSlice())
+	packet := (&layer2.EthernetFrame{DestinationMAC: localMAC, SourceMAC: peerMAC, EtherType: layer2.EtherTypeIPv4, Payload: ip}).Serialize()
+	table.Learn(packet, nil)
+	if table.Len() != 0 {
+		t.Fatal("an IP packet created a binding")
+	}
+
+	table.Learn(arp(arpRequest, peerMAC, "10.0.0.2", "10.0.0.1").Serialize(), nil)
+	now = now.Add(50 * time.Second)
+	table.Learn(packet, nil)
+	now = now.Add(50 * time.Second)
+	if _, ok := table.Lookup(addr); !ok {
+		t.Error("binding expired although refreshed")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := table.Lookup(addr); ok {
+		t.Error("binding not expired")
+	}
+	if table.Len() != 0 {
+		t.Error("expired binding counted")
+	}
+
+	// Multicast source addresses are never bound
+	table.Learn(arp(arpReply, broadcast, "10.0.0.3", "10.0.0.1").Serialize(), nil)
+	if table.Len() != 0 {
+		t.Error("multicast MAC bound")
+	}
+
+	// Addresses the peer may not use are not learned
+	deny := func(netip.Addr) bool { return false }
+	table.Learn(nd(ndAdvert, peerMAC, "fd00::2", "fd00::1", "fd00::9").Serialize(), deny)
+	table.Learn(arp(arpReply, peerMAC, "10.0.0.9", "10.0.0.1").Serialize(), deny)
+	if table.Len() != 0 {
+		t.Error("denied address learned")
+	}
+
+	table.Learn(nd(ndSolicitation, peerMAC, "fd00::2", "ff02::1:ff00:1", "fd00::1").Serialize(), nil)
+	if mac, ok := table.Lookup(netip.MustParseAddr("fd00::2")); !ok || mac != peerMAC {
+		t.Errorf("solicitation source not learned: %x, %v", mac, ok)
+	}
+	table.Clear()
+	if table.Len() != 0 {
+		t.Error("Clear left bindings")
+	}
+}
+
+func TestSuppressor(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewSuppressor(2)
+	s.now = func() time.Time { return now }
+
+	unicast := &layer2.EthernetFrame{DestinationMAC: peerMAC, SourceMAC: localMAC, EtherType: layer2.EtherTypeIPv4}
+	for i := 0; i < 10; i++ {
+		if !s.Allow(unicast) {
+			t.Fatal("unicast frame suppressed")
+		}
+	}
+
+	request := arp(arpRequest, localMAC, "10.0.0.1", "10.0.0.2")
+	if !s.Allow(request) {
+		t.Fatal("first broadcast suppressed")
+	}
+	if s.Allow(request) {
+		t.Error("duplicate broadcast passed")
+	}
+	now = now.Add(duplicateWindow)
+	if !s.Allow(request) {
+		t.Error("repeat after the duplicate window suppressed")
+	}
+	if s.Allow(arp(arpRequest, localMAC, "10.0.0.1", "10.0.0.3")) {
+		t.Error("broadcast over the limit passed")
+	}
+	now = now.Add(time.Second)
+	if !s.Allow(arp(arpRequest, localMAC, "10.0.0.1", "10.0.0.4")) {
+		t.Error("broadcast after refill suppressed")
+	}
+
+	s.SetRate(0)
+	for i := 0; i < 10; i++ {
+		if !s.Allow(arp(arpRequest, localMAC, "10.0.0.1", netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}).String())) {
+			t.Fatal("broadcast suppressed without a limit")
+		}
+	}
+}
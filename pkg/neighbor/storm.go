@@ -0,0 +1,103 @@
+package neighbor
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/layer2"
+)
+
+// duplicateWindow is how long an identical flooded frame is dropped after the first
+const duplicateWindow = 250 * time.Millisecond
+
+// maxRecent bounds the frames remembered for duplicate detection
+const maxRecent = 1024
+
+// Suppressor limits the broadcast and multicast frames flooded to peers
+// Identical frames repeated within a short window are dropped, and the rest are
+// limited by a token bucket. Thread-safe.
+type Suppressor struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	rate   int // Frames per second, 0 for no limit
+	tokens float64
+	last   time.Time
+	recent map[uint64]time.Time
+}
+
+// NewSuppressor creates a suppressor passing rate flooded frames per second (0: no limit)
+func NewSuppressor(rate int) *Suppressor {
+	return &Suppressor{now: time.Now, rate: rate, tokens: float64(rate), recent: make(map[uint64]time.Time)}
+}
+
+// SetRate changes the limit, e.g. on a configuration reload
+func (s *Suppressor) SetRate(rate int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rate != s.rate {
+		s.rate, s.tokens = rate, float64(rate)
+	}
+}
+
+// Rate returns the limit in frames per second (0: no limit)
+func (s *Suppressor) Rate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// Flooded reports whether a frame goes to every peer, i.e. is broadcast or multicast
+func Flooded(frame *layer2.EthernetFrame) bool {
+	return frame.DestinationMAC[0]&1 != 0
+}
+
+// Allow reports whether a frame may be sent
+// Unicast frames always pass.
+func (s *Suppressor) Allow(frame *layer2.EthernetFrame) bool {
+	if !Flooded(frame) {
+		return true
+	}
+
+	h := fnv.New64a()
+	h.Write(frame.DestinationMAC[:])
+	h.Write(frame.SourceMAC[:])
+	h.Write([]byte{byte(frame.EtherType >> 8), byte(frame.EtherType)})
+	h.Write(frame.Payload)
+	key := h.Sum64()
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seen, ok := s.recent[key]; ok && now.Sub(seen) < duplicateWindow {
+		return false
+	}
+	if len(s.recent) >= maxRecent {
+		for k, seen := range s.recent {
+			if now.Sub(seen) >= duplicateWindow {
+				delete(s.recent, k)
+			}
+		}
+		if len(s.recent) >= maxRecent {
+			clear(s.recent)
+		}
+	}
+	s.recent[key] = now
+
+	if s.rate <= 0 {
+		return true
+	}
+	s.tokens += now.Sub(s.last).Seconds() * float64(s.rate)
+	s.last = now
+	if s.tokens > float64(s.rate) {
+		s.tokens = float64(s.rate)
+	}
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
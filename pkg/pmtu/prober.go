@@ -0,0 +1,289 @@
+// Package pmtu implements datagram packetization layer path MTU discovery (DPLPMTUD, RFC 8899)
+// for the ShadowMesh tunnel.
+//
+// The prober only decides which probe sizes to send and interprets acknowledgements; the
+// caller transmits padded probe datagrams of the requested size over the tunnel and reports
+// acknowledgements back. Sizes are transport payload sizes (an encrypted frame as sent on
+// the wire), not IP packet sizes.
+package pmtu
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults for a UDP underlay on a standard 1500 byte Ethernet path
+const (
+	// DefaultBaseSize fits any IPv6 path: 1280 minimum MTU - 40 IPv6 header - 8 UDP header
+	DefaultBaseSize = 1232
+	// DefaultMaxSize is the largest payload of a 1500 byte IPv4 packet: 1500 - 20 - 8
+	DefaultMaxSize = 1472
+	// DefaultMinStep stops the search once the window is this small
+	DefaultMinStep = 8
+	// DefaultMaxProbes is how many unacknowledged probes mark a size as too large
+	DefaultMaxProbes = 3
+	// DefaultProbeTimeout is how long to wait for a probe acknowledgement
+	DefaultProbeTimeout = 1 * time.Second
+	// DefaultRaiseInterval is how often a completed search is repeated (PMTU_RAISE_TIMER)
+	DefaultRaiseInterval = 10 * time.Minute
+	// DefaultConfirmInterval is how often the current size is re-confirmed to detect black holes
+	DefaultConfirmInterval = 30 * time.Second
+)
+
+// State is the DPLPMTUD state of a prober
+type State int
+
+const (
+	StateBase           State = iota // Confirming the base size
+	StateSearching                   // Probing for a larger size
+	StateSearchComplete              // Largest size found; periodically re-confirmed
+	StateError                       // Even the base size is not acknowledged
+)
+
+func (s State) String() string {
+	switch s {
+	case StateBase:
+		return "base"
+	case StateSearching:
+		return "searching"
+	case StateSearchComplete:
+		return "search_complete"
+	case StateError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Config controls the probe sizes and timers
+type Config struct {
+	BaseSize        int           // Size assumed to work on any path (default: DefaultBaseSize)
+	MaxSize         int           // Largest size worth probing (default: DefaultMaxSize)
+	MinStep         int           // Search granularity in bytes (default: DefaultMinStep)
+	MaxProbes       int           // Probes per size before giving up (default: DefaultMaxProbes)
+	ProbeTimeout    time.Duration // Wait per probe (default: DefaultProbeTimeout)
+	RaiseInterval   time.Duration // Delay before searching again (default: DefaultRaiseInterval)
+	ConfirmInterval time.Duration // Delay between black hole checks (default: DefaultConfirmInterval)
+}
+
+// Probe is a probe datagram the caller should send
+type Probe struct {
+	ID   uint32 // Echoed back in the acknowledgement
+	Size int    // Exact size of the datagram on the wire
+}
+
+// probeKind records why a probe was sent
+type probeKind int
+
+const (
+	probeBase probeKind = iota
+	probeSearch
+	probeConfirm
+)
+
+// outstandingProbe is the probe currently awaiting acknowledgement
+type outstandingProbe struct {
+	kind    probeKind
+	size    int
+	firstID uint32 // IDs firstID..lastID were all sent for this size
+	lastID  uint32
+	sent    int
+	sentAt  time.Time
+}
+
+// Prober runs the DPLPMTUD search for one path
+//
+// Thread-safe: all methods may be called concurrently.
+type Prober struct {
+	config Config
+
+	mu          sync.Mutex
+	state       State
+	plpmtu      int // Largest acknowledged size (BaseSize until confirmed otherwise)
+	low         int // Largest size known to work during a search
+	high        int // Smallest size known to fail during a search (MaxSize+1 if none)
+	nextID      uint32
+	outstanding *outstandingProbe
+	nextSearch  time.Time // Next raise (search complete) or base retry (error)
+	nextConfirm time.Time // Next black hole check (search complete)
+}
+
+// NewProber creates a prober in the base state
+func NewProber(config Config) *Prober {
+	if config.BaseSize == 0 {
+		config.BaseSize = DefaultBaseSize
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	if config.MaxSize < config.BaseSize {
+		config.MaxSize = config.BaseSize
+	}
+	if config.MinStep == 0 {
+		config.MinStep = DefaultMinStep
+	}
+	if config.MaxProbes == 0 {
+		config.MaxProbes = DefaultMaxProbes
+	}
+	if config.ProbeTimeout == 0 {
+		config.ProbeTimeout = DefaultProbeTimeout
+	}
+	if config.RaiseInterval == 0 {
+		config.RaiseInterval = DefaultRaiseInterval
+	}
+	if config.ConfirmInterval == 0 {
+		config.ConfirmInterval = DefaultConfirmInterval
+	}
+
+	return &Prober{
+		config: config,
+		state:  StateBase,
+		plpmtu: config.BaseSize,
+	}
+}
+
+// Next returns the probe to send now, if any
+// Call periodically (more often than ProbeTimeout). Unacknowledged probes are retried
+// up to MaxProbes times before the size is considered too large.
+func (p *Prober) Next(now time.Time) (Probe, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.outstanding != nil {
+		if now.Sub(p.outstanding.sentAt) < p.config.ProbeTimeout {
+			return Probe{}, false
+		}
+		if p.outstanding.sent < p.config.MaxProbes {
+			// Retry the same size under a new ID
+			p.nextID++
+			p.outstanding.lastID = p.nextID
+			p.outstanding.sent++
+			p.outstanding.sentAt = now
+			return Probe{ID: p.nextID, Size: p.outstanding.size}, true
+		}
+		p.probeFailed(now)
+	}
+
+	kind, size, ok := p.nextProbeSize(now)
+	if !ok {
+		return Probe{}, false
+	}
+
+	p.nextID++
+	p.outstanding = &outstandingProbe{
+		kind:    kind,
+		size:    size,
+		firstID: p.nextID,
+		lastID:  p.nextID,
+		sent:    1,
+		sentAt:  now,
+	}
+	return Probe{ID: p.nextID, Size: size}, true
+}
+
+// nextProbeSize picks the next size to probe for the current state
+func (p *Prober) nextProbeSize(now time.Time) (probeKind, int, bool) {
+	switch p.state {
+	case StateBase:
+		return probeBase, p.config.BaseSize, true
+
+	case StateSearching:
+		if p.low >= p.config.MaxSize || p.high-p.low <= p.config.MinStep {
+			p.state = StateSearchComplete
+			p.nextSearch = now.Add(p.config.RaiseInterval)
+			p.nextConfirm = now.Add(p.config.ConfirmInterval)
+			return 0, 0, false
+		}
+		if p.high > p.config.MaxSize {
+			// Most paths support the maximum, so try it before bisecting
+			return probeSearch, p.config.MaxSize, true
+		}
+		return probeSearch, p.low + (p.high-p.low)/2, true
+
+	case StateSearchComplete:
+		if !now.Before(p.nextSearch) {
+			p.startSearch()
+			return p.nextProbeSize(now)
+		}
+		if !now.Before(p.nextConfirm) {
+			return probeConfirm, p.plpmtu, true
+		}
+		return 0, 0, false
+
+	case StateError:
+		if !now.Before(p.nextSearch) {
+			p.state = StateBase
+			return probeBase, p.config.BaseSize, true
+		}
+		return 0, 0, false
+	}
+
+	return 0, 0, false
+}
+
+// startSearch opens the search window above the current size
+func (p *Prober) startSearch() {
+	p.state = StateSearching
+	p.low = p.plpmtu
+	p.high = p.config.MaxSize + 1
+}
+
+// probeFailed handles a size that was never acknowledged
+func (p *Prober) probeFailed(now time.Time) {
+	probe := p.outstanding
+	p.outstanding = nil
+
+	switch probe.kind {
+	case probeBase:
+		// Keep using the base size; fragmentation above the tunnel still works
+		p.state = StateError
+		p.plpmtu = p.config.BaseSize
+		p.nextSearch = now.Add(p.config.ConfirmInterval)
+	case probeSearch:
+		p.high = probe.size
+	case probeConfirm:
+		// Black hole: the path shrank under us, start over from the base size
+		p.state = StateBase
+		p.plpmtu = p.config.BaseSize
+	}
+}
+
+// Ack records the acknowledgement of a probe
+// Returns true if the acknowledgement matched the outstanding probe.
+func (p *Prober) Ack(id uint32, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	probe := p.outstanding
+	if probe == nil || id < probe.firstID || id > probe.lastID {
+		return false
+	}
+	p.outstanding = nil
+
+	switch probe.kind {
+	case probeBase:
+		p.plpmtu = probe.size
+		p.startSearch()
+	case probeSearch:
+		p.low = probe.size
+		p.plpmtu = probe.size
+	case probeConfirm:
+		p.nextConfirm = now.Add(p.config.ConfirmInterval)
+	}
+
+	return true
+}
+
+// PLPMTU returns the largest datagram size currently known to reach the peer
+func (p *Prober) PLPMTU() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.plpmtu
+}
+
+// State returns the current DPLPMTUD state
+func (p *Prober) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
@@ -0,0 +1,156 @@
+package pmtu
+
+import (
+	"testing"
+	"time"
+)
+
+// simulatePath drives the prober against a path that delivers datagrams up to pathMTU bytes
+// Returns the time after the last step.
+func simulatePath(p *Prober, pathMTU int, now time.Time, steps int) time.Time {
+	for i := 0; i < steps; i++ {
+		probe, ok := p.Next(now)
+		if ok && probe.Size <= pathMTU {
+			p.Ack(probe.ID, now)
+		}
+		now = now.Add(DefaultProbeTimeout)
+	}
+	return now
+}
+
+// TestProberFullPath tests that a path supporting the maximum completes after one search probe
+func TestProberFullPath(t *testing.T) {
+	p := NewProber(Config{})
+
+	if p.PLPMTU() != DefaultBaseSize {
+		t.Errorf("Initial PLPMTU = %d, expected base size %d", p.PLPMTU(), DefaultBaseSize)
+	}
+
+	simulatePath(p, 1500, time.Now(), 5)
+
+	if p.PLPMTU() != DefaultMaxSize {
+		t.Errorf("PLPMTU = %d, expected %d", p.PLPMTU(), DefaultMaxSize)
+	}
+	if p.State() != StateSearchComplete {
+		t.Errorf("State = %s, expected %s", p.State(), StateSearchComplete)
+	}
+}
+
+// TestProberConstrainedPath tests that the search converges below a smaller path MTU
+func TestProberConstrainedPath(t *testing.T) {
+	for _, pathMTU := range []int{1240, 1350, 1420, 1471} {
+		p := NewProber(Config{})
+		simulatePath(p, pathMTU, time.Now(), 100)
+
+		got := p.PLPMTU()
+		if got > pathMTU || pathMTU-got > DefaultMinStep {
+			t.Errorf("path %d: PLPMTU = %d, expected within %d bytes below", pathMTU, got, DefaultMinStep)
+		}
+		if p.State() != StateSearchComplete {
+			t.Errorf("path %d: state = %s, expected %s", pathMTU, p.State(), StateSearchComplete)
+		}
+	}
+}
+
+// TestProberRetriesBeforeGivingUp tests that a size is only rejected after MaxProbes losses
+func TestProberRetriesBeforeGivingUp(t *testing.T) {
+	p := NewProber(Config{})
+	now := time.Now()
+
+	base, _ := p.Next(now)
+	p.Ack(base.ID, now)
+
+	first, ok := p.Next(now)
+	if !ok || first.Size != DefaultMaxSize {
+		t.Fatalf("Expected probe of %d bytes, got %+v", DefaultMaxSize, first)
+	}
+
+	// No retry before the timeout
+	if _, ok := p.Next(now.Add(DefaultProbeTimeout / 2)); ok {
+		t.Error("Probe retried before timeout")
+	}
+
+	now = now.Add(DefaultProbeTimeout)
+	retry, ok := p.Next(now)
+	if !ok || retry.Size != first.Size || retry.ID == first.ID {
+		t.Fatalf("Expected retry of %d bytes with new ID, got %+v", first.Size, retry)
+	}
+
+	// A late acknowledgement of the first attempt still counts
+	if !p.Ack(first.ID, now) {
+		t.Error("Late acknowledgement of earlier attempt rejected")
+	}
+	if p.PLPMTU() != DefaultMaxSize {
+		t.Errorf("PLPMTU = %d, expected %d", p.PLPMTU(), DefaultMaxSize)
+	}
+}
+
+// TestProberBaseFailure tests the error state when nothing is acknowledged
+func TestProberBaseFailure(t *testing.T) {
+	p := NewProber(Config{})
+	now := simulatePath(p, 0, time.Now(), DefaultMaxProbes+1)
+
+	if p.State() != StateError {
+		t.Errorf("State = %s, expected %s", p.State(), StateError)
+	}
+	if p.PLPMTU() != DefaultBaseSize {
+		t.Errorf("PLPMTU = %d, expected base size %d", p.PLPMTU(), DefaultBaseSize)
+	}
+
+	// Path recovers: the base size is retried after the confirm interval
+	simulatePath(p, 1500, now.Add(DefaultConfirmInterval), 5)
+	if p.PLPMTU() != DefaultMaxSize {
+		t.Errorf("PLPMTU after recovery = %d, expected %d", p.PLPMTU(), DefaultMaxSize)
+	}
+}
+
+// TestProberBlackHole tests that a shrinking path is detected by confirmation probes
+func TestProberBlackHole(t *testing.T) {
+	p := NewProber(Config{})
+	now := simulatePath(p, 1500, time.Now(), 5)
+	if p.PLPMTU() != DefaultMaxSize {
+		t.Fatalf("PLPMTU = %d, expected %d", p.PLPMTU(), DefaultMaxSize)
+	}
+
+	// Path MTU drops; confirmation probes are lost until the prober falls back
+	// to the base size (which is immediately re-probed and acknowledged)
+	now = simulatePath(p, 1300, now.Add(DefaultConfirmInterval), DefaultMaxProbes+1)
+	if p.State() != StateSearching {
+		t.Fatalf("State = %s, expected %s after black hole", p.State(), StateSearching)
+	}
+	if p.PLPMTU() != DefaultBaseSize {
+		t.Errorf("PLPMTU = %d, expected base size %d", p.PLPMTU(), DefaultBaseSize)
+	}
+
+	simulatePath(p, 1300, now, 100)
+	if got := p.PLPMTU(); got > 1300 || 1300-got > DefaultMinStep {
+		t.Errorf("PLPMTU after re-search = %d, expected just below 1300", got)
+	}
+}
+
+// TestProberRaise tests that a completed search is repeated after the raise interval
+func TestProberRaise(t *testing.T) {
+	p := NewProber(Config{ConfirmInterval: time.Hour})
+	now := simulatePath(p, 1400, time.Now(), 100)
+	if p.PLPMTU() > 1400 {
+		t.Fatalf("PLPMTU = %d exceeds path MTU", p.PLPMTU())
+	}
+
+	simulatePath(p, 1500, now.Add(DefaultRaiseInterval), 5)
+	if p.PLPMTU() != DefaultMaxSize {
+		t.Errorf("PLPMTU after raise = %d, expected %d", p.PLPMTU(), DefaultMaxSize)
+	}
+}
+
+// TestProberIgnoresUnknownAck tests that stray acknowledgements are ignored
+func TestProberIgnoresUnknownAck(t *testing.T) {
+	p := NewProber(Config{})
+	if p.Ack(42, time.Now()) {
+		t.Error("Acknowledgement without outstanding probe accepted")
+	}
+
+	probe, _ := p.Next(time.Now())
+	if p.Ack(probe.ID+1, time.Now()) {
+		t.Error("Acknowledgement with wrong ID accepted")
+	}
+}
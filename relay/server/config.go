@@ -2,18 +2,29 @@ package main
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the relay server configuration
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Identity IdentityConfig `yaml:"identity"`
-	Limits   LimitsConfig   `yaml:"limits"`
-	Logging  LoggingConfig  `yaml:"logging"`
+	Server     ServerConfig     `yaml:"server"`
+	Identity   IdentityConfig   `yaml:"identity"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Addressing AddressingConfig `yaml:"addressing"`
+	Logging    LoggingConfig    `yaml:"logging"`
+
+	// Networks are the virtual networks clients may join with network= on /ws
+	// Clients naming another are refused; without networks all share the default one.
+	Networks []string `yaml:"networks,omitempty"`
 }
 
 // ServerConfig contains server-specific settings
@@ -44,15 +55,29 @@ type LimitsConfig struct {
 	HeartbeatInterval int `yaml:"heartbeat_interval"` // Seconds between heartbeats
 	HeartbeatTimeout  int `yaml:"heartbeat_timeout"`  // Seconds before client timeout
 	MaxFrameSize      int `yaml:"max_frame_size"`     // Maximum frame size in bytes
+	TunnelMTU         int `yaml:"tunnel_mtu"`         // MTU advertised to clients in ESTABLISHED
 	ReadBufferSize    int `yaml:"read_buffer_size"`   // WebSocket read buffer
 	WriteBufferSize   int `yaml:"write_buffer_size"`  // WebSocket write buffer
 }
 
+// AddressingConfig contains tunnel address assignment settings
+// Clients are assigned addresses in the ESTABLISHED message, leased to the identity key
+// of the claim they sign when connecting (ipam.ClaimHeader).
+type AddressingConfig struct {
+	Pool      string `yaml:"pool"`       // IPv4 network to assign from, e.g. "10.77.0.0/24"; empty disables assignment
+	Pool6     string `yaml:"pool6"`      // IPv6 ULA prefix to derive addresses in, e.g. "fd77::/64"; optional
+	Leases    string `yaml:"leases"`     // File the leases are persisted in
+	LeaseTime int    `yaml:"lease_time"` // Seconds a lease outlives its client's last connection
+}
+
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
 	Level      string `yaml:"level"`       // debug, info, warn, error
 	Format     string `yaml:"format"`      // text, json
 	OutputFile string `yaml:"output_file"` // Log file path (empty = stdout)
+
+	// Components overrides the level of single components: relay, connection, handshake, router
+	Components map[string]string `yaml:"components,omitempty"`
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -81,9 +106,14 @@ func DefaultConfig() *Config {
 			HeartbeatInterval: 30,
 			HeartbeatTimeout:  90,
 			MaxFrameSize:      65536,
+			TunnelMTU:         DefaultTunnelMTU,
 			ReadBufferSize:    2 * 1024 * 1024, // 2MB (increased from 4KB for burst traffic)
 			WriteBufferSize:   2 * 1024 * 1024, // 2MB (prevents buffer full errors)
 		},
+		Addressing: AddressingConfig{
+			Leases:    filepath.Join(homeDir, ".shadowmesh-relay", "leases.json"),
+			LeaseTime: int(ipam.DefaultLeaseTime / time.Second),
+		},
 		Logging: LoggingConfig{
 			Level:      "info",
 			Format:     "text",
@@ -191,6 +221,31 @@ func (c *Config) Validate() error {
 	if c.Limits.MaxFrameSize < 1500 || c.Limits.MaxFrameSize > 65536 {
 		return fmt.Errorf("limits.max_frame_size must be between 1500 and 65536")
 	}
+	if c.Limits.TunnelMTU < 1280 || c.Limits.TunnelMTU > 9000 {
+		return fmt.Errorf("limits.tunnel_mtu must be between 1280 and 9000")
+	}
+
+	// Validate networks
+	for i, network := range c.Networks {
+		if network == "" || len(network) > 64 {
+			return fmt.Errorf("networks[%d] must be 1 to 64 characters", i)
+		}
+		if slices.Contains(c.Networks[:i], network) {
+			return fmt.Errorf("networks[%d] %q is listed twice", i, network)
+		}
+	}
+
+	// Validate addressing settings
+	if c.Addressing.Pool != "" {
+		if _, err := c.AddressPool(); err != nil {
+			return fmt.Errorf("addressing: %w", err)
+		}
+		if c.Addressing.LeaseTime < 60 {
+			return fmt.Errorf("addressing.lease_time must be at least 60 seconds")
+		}
+	} else if c.Addressing.Pool6 != "" {
+		return fmt.Errorf("addressing.pool6 requires addressing.pool")
+	}
 
 	// Validate logging settings
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
@@ -203,9 +258,43 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("logging.format must be one of: text, json")
 	}
 
+	components := logging.Components()
+	for component, level := range c.Logging.Components {
+		if !slices.Contains(components, component) {
+			return fmt.Errorf("logging.components: unknown component %q (want one of: %s)", component, strings.Join(components, ", "))
+		}
+		if !validLevels[level] {
+			return fmt.Errorf("logging.components.%s must be one of: debug, info, warn, error", component)
+		}
+	}
+
 	return nil
 }
 
+// AddressPool returns the pool clients are assigned addresses from, or nil if addressing.pool is empty
+// The pool's leases are loaded from addressing.leases.
+func (c *Config) AddressPool() (*ipam.Pool, error) {
+	if c.Addressing.Pool == "" {
+		return nil, nil
+	}
+	prefix, err := netip.ParsePrefix(c.Addressing.Pool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool: %w", err)
+	}
+	var prefix6 netip.Prefix
+	if c.Addressing.Pool6 != "" {
+		if prefix6, err = netip.ParsePrefix(c.Addressing.Pool6); err != nil {
+			return nil, fmt.Errorf("invalid pool6: %w", err)
+		}
+	}
+	pool, err := ipam.NewPool(prefix, prefix6, c.Addressing.Leases)
+	if err != nil {
+		return nil, err
+	}
+	pool.SetLeaseTime(time.Duration(c.Addressing.LeaseTime) * time.Second)
+	return pool, nil
+}
+
 // GetKeysDir returns the keys directory, creating it if necessary
 func (c *Config) GetKeysDir() (string, error) {
 	keysDir := c.Identity.KeysDir
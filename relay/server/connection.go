@@ -4,13 +4,15 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"net/http"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/shadowmesh/shadowmesh/pkg/crypto/frameencryption"
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
 	"github.com/shadowmesh/shadowmesh/shared/crypto"
 	"github.com/shadowmesh/shadowmesh/shared/protocol"
 )
@@ -48,6 +50,8 @@ type ClientConnection struct {
 	// Connection info
 	conn       *websocket.Conn
 	clientID   [32]byte
+	networks   frameencryption.NetworkSet // Virtual networks joined with network=; frames only cross within them
+	leaseClaim *ipam.Claim                // Verified claim of the client's address lease (ipam.ClaimHeader); nil for none
 	state      ClientState
 	stateMutex sync.RWMutex
 
@@ -98,6 +102,9 @@ type ConnectionManager struct {
 	// Router (injected)
 	router *Router
 
+	// Address pool whose leases are renewed while clients are connected (injected); nil without one
+	pool *ipam.Pool
+
 	// Lifecycle
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -106,6 +113,7 @@ type ConnectionManager struct {
 	// Statistics
 	totalConnections  atomic.Uint64
 	activeConnections atomic.Int64
+	metrics           *relayMetrics
 }
 
 // HandshakeHandler interface for processing handshakes
@@ -131,6 +139,7 @@ func NewConnectionManager(config *Config) *ConnectionManager {
 		ctx:     ctx,
 		cancel:  cancel,
 	}
+	cm.metrics = newRelayMetrics(cm)
 
 	return cm
 }
@@ -147,13 +156,14 @@ func (cm *ConnectionManager) SetRouter(router *Router) {
 
 // Start starts the WebSocket server
 func (cm *ConnectionManager) Start() error {
-	log.Printf("Starting relay server on %s", cm.listenAddr)
+	connLogger.Info("starting relay server", "address", cm.listenAddr)
 
 	// Create HTTP handler
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", cm.handleWebSocket)
 	mux.HandleFunc("/health", cm.handleHealth)
 	mux.HandleFunc("/stats", cm.handleStats)
+	mux.Handle("/metrics", cm.metrics.registry.Handler())
 
 	// Create HTTP server
 	cm.httpServer = &http.Server{
@@ -184,7 +194,7 @@ func (cm *ConnectionManager) Start() error {
 		cm.wg.Add(1)
 		go cm.heartbeatMonitor()
 
-		log.Println("Starting HTTPS server with TLS 1.3")
+		connLogger.Info("serving HTTPS with TLS 1.3")
 		return cm.httpServer.ListenAndServeTLS(certFile, keyFile)
 	}
 
@@ -192,13 +202,13 @@ func (cm *ConnectionManager) Start() error {
 	cm.wg.Add(1)
 	go cm.heartbeatMonitor()
 
-	log.Println("WARNING: Starting HTTP server without TLS (not recommended for production)")
+	connLogger.Warn("serving HTTP without TLS (not recommended for production)")
 	return cm.httpServer.ListenAndServe()
 }
 
 // Stop gracefully stops the server
 func (cm *ConnectionManager) Stop() error {
-	log.Println("Stopping relay server...")
+	connLogger.Info("stopping relay server")
 
 	// Cancel context
 	cm.cancel()
@@ -208,7 +218,7 @@ func (cm *ConnectionManager) Stop() error {
 	defer cancel()
 
 	if err := cm.httpServer.Shutdown(ctx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+		connLogger.Warn("HTTP server shutdown failed", "error", err)
 	}
 
 	// Close all client connections
@@ -221,7 +231,7 @@ func (cm *ConnectionManager) Stop() error {
 	// Wait for goroutines
 	cm.wg.Wait()
 
-	log.Println("Relay server stopped")
+	connLogger.Info("relay server stopped")
 	return nil
 }
 
@@ -230,40 +240,64 @@ func (cm *ConnectionManager) handleWebSocket(w http.ResponseWriter, r *http.Requ
 	// Check if we're at capacity
 	if int(cm.activeConnections.Load()) >= cm.config.Limits.MaxClients {
 		http.Error(w, "Server at capacity", http.StatusServiceUnavailable)
-		log.Println("Rejected connection: server at capacity")
+		connLogger.Warn("rejected connection: server at capacity", "remote", r.RemoteAddr)
 		return
 	}
 
+	// Only configured networks can be joined
+	networks := r.URL.Query()["network"]
+	for _, network := range networks {
+		if !slices.Contains(cm.config.Networks, network) {
+			http.Error(w, fmt.Sprintf("network %q is not served by this relay", network), http.StatusForbidden)
+			connLogger.Warn("rejected connection: unknown network", "remote", r.RemoteAddr, "network", network)
+			return
+		}
+	}
+
+	// Check the lease claim before upgrading, so a bad one is an HTTP error; the handshake leases the address
+	var leaseClaim *ipam.Claim
+	if header := r.Header.Get(ipam.ClaimHeader); cm.pool != nil && header != "" {
+		claim, err := ipam.ParseClaim(header)
+		if err == nil {
+			err = claim.Verify(time.Now())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			connLogger.Warn("rejected connection: invalid lease claim", "remote", r.RemoteAddr, "error", err)
+			return
+		}
+		leaseClaim = &claim
+	}
+
 	// Upgrade connection
 	conn, err := cm.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		connLogger.Warn("WebSocket upgrade failed", "remote", r.RemoteAddr, "error", err)
 		return
 	}
 
 	// Create client connection
-	client := cm.newClientConnection(conn)
+	client := cm.newClientConnection(conn, frameencryption.JoinNetworks(networks))
+	client.leaseClaim = leaseClaim
 
 	// Update statistics
 	cm.totalConnections.Add(1)
 	cm.activeConnections.Add(1)
 
-	log.Printf("New connection from %s (total: %d, active: %d)",
-		r.RemoteAddr,
-		cm.totalConnections.Load(),
-		cm.activeConnections.Load())
+	connLogger.Info("new connection", "remote", r.RemoteAddr, "total", cm.totalConnections.Load(), "active", cm.activeConnections.Load())
 
 	// Handle client in separate goroutine
 	cm.wg.Add(1)
 	go cm.handleClient(client)
 }
 
-// newClientConnection creates a new client connection
-func (cm *ConnectionManager) newClientConnection(conn *websocket.Conn) *ClientConnection {
+// newClientConnection creates a new client connection in networks
+func (cm *ConnectionManager) newClientConnection(conn *websocket.Conn, networks frameencryption.NetworkSet) *ClientConnection {
 	ctx, cancel := context.WithCancel(cm.ctx)
 
 	return &ClientConnection{
 		conn:          conn,
+		networks:      networks,
 		state:         ClientStateConnecting,
 		sendChan:      make(chan *protocol.Message, 100),
 		receiveChan:   make(chan *protocol.Message, 100),
@@ -297,12 +331,15 @@ func (cm *ConnectionManager) handleClient(client *ClientConnection) {
 
 	// Perform handshake
 	if cm.handshakeHandler != nil {
-		if err := cm.handshakeHandler.HandleHandshake(handshakeCtx, client); err != nil {
-			log.Printf("Handshake failed: %v", err)
+		start := time.Now()
+		err := cm.handshakeHandler.HandleHandshake(handshakeCtx, client)
+		cm.metrics.handshakeDone(start, err)
+		if err != nil {
+			handshakeLogger.Warn("handshake failed", "remote", client.conn.RemoteAddr(), "error", err)
 			return
 		}
 	} else {
-		log.Println("WARNING: No handshake handler configured")
+		handshakeLogger.Error("no handshake handler configured")
 		return
 	}
 
@@ -313,7 +350,7 @@ func (cm *ConnectionManager) handleClient(client *ClientConnection) {
 	cm.registerClient(client)
 	defer cm.unregisterClient(client)
 
-	log.Printf("Client %x established (session: %x)", client.clientID[:8], client.sessionID[:8])
+	connLogger.Info("client established", clientAttr(client.clientID), "session", fmt.Sprintf("%x", client.sessionID[:8]))
 
 	// Process messages until disconnection
 	for {
@@ -348,15 +385,15 @@ func (cm *ConnectionManager) handleClientMessage(client *ClientConnection, msg *
 		select {
 		case client.sendChan <- response:
 		default:
-			log.Printf("Failed to send heartbeat response to client %x", client.clientID[:8])
+			heartbeatFullLog.Warn(connLogger, "failed to send heartbeat response", clientAttr(client.clientID))
 		}
 
 	// Future: Add key rotation handling
 	// case protocol.MsgTypeKeyRotation:
-	// 	log.Printf("Key rotation request from client %x", client.clientID[:8])
+	// 	handshakeLogger.Info("key rotation requested", clientAttr(client.clientID))
 
 	default:
-		log.Printf("Unexpected message type %d from client %x", msg.Header.Type, client.clientID[:8])
+		invalidFrameLog.Warn(connLogger, "unexpected message type", "type", msg.Header.Type, clientAttr(client.clientID))
 	}
 }
 
@@ -366,7 +403,7 @@ func (cm *ConnectionManager) registerClient(client *ClientConnection) {
 	defer cm.clientsMutex.Unlock()
 
 	cm.clients[client.clientID] = client
-	log.Printf("Registered client %x (total clients: %d)", client.clientID[:8], len(cm.clients))
+	connLogger.Debug("registered client", clientAttr(client.clientID), "clients", len(cm.clients))
 }
 
 // unregisterClient removes a client from the active clients map
@@ -375,10 +412,35 @@ func (cm *ConnectionManager) unregisterClient(client *ClientConnection) {
 	defer cm.clientsMutex.Unlock()
 
 	delete(cm.clients, client.clientID)
-	log.Printf("Unregistered client %x (remaining clients: %d)", client.clientID[:8], len(cm.clients))
+	connLogger.Info("client disconnected", clientAttr(client.clientID), "clients", len(cm.clients))
+	cm.renewLeases(client) // The lease expires the lease time after this
+}
+
+// SetAddressPool sets the pool whose leases are renewed for connected clients
+func (cm *ConnectionManager) SetAddressPool(pool *ipam.Pool) {
+	cm.pool = pool
+}
+
+// renewLeases keeps the address leases of clients from expiring
+func (cm *ConnectionManager) renewLeases(clients ...*ClientConnection) {
+	if cm.pool == nil {
+		return
+	}
+	var identities []string
+	for _, client := range clients {
+		if client.leaseClaim != nil {
+			identities = append(identities, client.leaseClaim.ID())
+		}
+	}
+	if len(identities) == 0 {
+		return
+	}
+	if err := cm.pool.Renew(identities...); err != nil {
+		connLogger.Warn("failed to renew address leases", "error", err)
+	}
 }
 
-// heartbeatMonitor monitors client heartbeats and disconnects stale clients
+// heartbeatMonitor monitors client heartbeats, disconnects stale clients and renews the others' leases
 func (cm *ConnectionManager) heartbeatMonitor() {
 	defer cm.wg.Done()
 
@@ -394,15 +456,19 @@ func (cm *ConnectionManager) heartbeatMonitor() {
 		case <-ticker.C:
 			cm.clientsMutex.RLock()
 			now := time.Now()
+			var established []*ClientConnection
 			for clientID, client := range cm.clients {
 				if client.getState() == ClientStateEstablished {
 					if now.Sub(client.lastHeartbeat) > timeoutDuration {
-						log.Printf("Client %x heartbeat timeout, disconnecting", clientID[:8])
+						connLogger.Warn("heartbeat timeout, disconnecting", clientAttr(clientID))
 						client.Close()
+						continue
 					}
+					established = append(established, client)
 				}
 			}
 			cm.clientsMutex.RUnlock()
+			cm.renewLeases(established...)
 		}
 	}
 }
@@ -449,20 +515,20 @@ func (cc *ClientConnection) readLoop(cm *ConnectionManager) {
 		messageType, data, err := cc.conn.ReadMessage()
 		if err != nil {
 			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-				log.Printf("Read error: %v", err)
+				connLogger.Warn("read failed", "error", err)
 			}
 			return
 		}
 
 		if messageType != websocket.BinaryMessage {
-			log.Printf("Unexpected message type: %d", messageType)
+			invalidFrameLog.Warn(connLogger, "unexpected WebSocket message type", "type", messageType)
 			continue
 		}
 
 		// Decode protocol message
 		msg, err := protocol.DecodeMessage(data)
 		if err != nil {
-			log.Printf("Message decode error: %v", err)
+			invalidFrameLog.Warn(connLogger, "message decode failed", "error", err)
 			continue
 		}
 
@@ -492,13 +558,13 @@ func (cc *ClientConnection) writeLoop() {
 			// Encode message
 			data, err := protocol.EncodeMessage(msg)
 			if err != nil {
-				log.Printf("Message encode error: %v", err)
+				connLogger.Warn("message encode failed", "error", err)
 				continue
 			}
 
 			// Write message to WebSocket
 			if err := cc.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
-				log.Printf("Write error: %v", err)
+				connLogger.Warn("write failed", "error", err)
 				return
 			}
 
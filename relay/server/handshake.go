@@ -3,21 +3,28 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/shadowmesh/shadowmesh/pkg/ipam"
 	"github.com/shadowmesh/shadowmesh/shared/crypto"
 	"github.com/shadowmesh/shadowmesh/shared/protocol"
 )
 
+// DefaultTunnelMTU is the tunnel MTU advertised to clients: a 1500 byte underlay minus
+// IPv4/UDP headers (28), encrypted frame overhead (38) and an Ethernet header (14).
+// Clients refine it with path MTU discovery.
+const DefaultTunnelMTU = 1420
+
 // RelayHandshakeHandler handles the relay side of the handshake protocol
 type RelayHandshakeHandler struct {
 	relayID        [32]byte
 	sigKeys        *crypto.HybridSigningKey
 	tlsCertManager *TLSCertificateManager
+	tunnelMTU      uint16
+	pool           *ipam.Pool // Assigns tunnel addresses in ESTABLISHED; nil leaves addressing to the clients
 }
 
 // NewRelayHandshakeHandler creates a new relay handshake handler
@@ -26,20 +33,33 @@ func NewRelayHandshakeHandler(relayID [32]byte, sigKeys *crypto.HybridSigningKey
 		relayID:        relayID,
 		sigKeys:        sigKeys,
 		tlsCertManager: tlsCertManager,
+		tunnelMTU:      DefaultTunnelMTU,
 	}
 }
 
+// SetTunnelMTU sets the MTU advertised to clients in the ESTABLISHED message
+func (rh *RelayHandshakeHandler) SetTunnelMTU(mtu int) {
+	rh.tunnelMTU = uint16(mtu)
+}
+
+// SetAddressPool makes the handshake assign clients tunnel addresses from pool in the ESTABLISHED message
+// Leases are kept under the identity key of the client's lease claim (ipam.ClaimHeader),
+// not the client ID, which clients choose freely. Clients without a claim get none.
+func (rh *RelayHandshakeHandler) SetAddressPool(pool *ipam.Pool) {
+	rh.pool = pool
+}
+
 // HandleHandshake performs the relay side of the handshake protocol
 //
 // Handshake flow (4 messages):
 // 1. Client → Relay: HELLO (client identity + KEM public key)
 // 2. Relay → Client: CHALLENGE (relay identity + KEM ciphertext)
 // 3. Client → Relay: RESPONSE (session proof)
-// 4. Relay → Client: ESTABLISHED (confirmation)
+// 4. Relay → Client: ESTABLISHED (confirmation and, with an address pool, the client's tunnel addresses)
 //
 // After successful handshake, both parties derive symmetric session keys.
 func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *ClientConnection) error {
-	log.Printf("Starting handshake with client from %s", client.conn.RemoteAddr())
+	handshakeLogger.Debug("starting handshake", "remote", client.conn.RemoteAddr())
 
 	// Create handshake state
 	handshakeState, err := protocol.NewRelayHandshakeState(rh.relayID, rh.sigKeys)
@@ -58,7 +78,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 		return fmt.Errorf("invalid HELLO payload type")
 	}
 
-	log.Printf("Received HELLO from client %x", helloPayload.ClientID[:8])
+	handshakeLogger.Debug("received HELLO", clientAttr(helloPayload.ClientID))
 
 	// Process HELLO message
 	if err := handshakeState.ProcessHelloMessage(helloPayload); err != nil {
@@ -79,9 +99,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 	}
 
 	challengePayload := challengeMsg.Payload.(*protocol.ChallengeMessage)
-	log.Printf("Sent CHALLENGE to client %x (session: %x)",
-		client.clientID[:8],
-		challengePayload.SessionID[:8])
+	handshakeLogger.Debug("sent CHALLENGE", clientAttr(client.clientID), "session", fmt.Sprintf("%x", challengePayload.SessionID[:8]))
 
 	// Store session ID
 	client.sessionID = challengePayload.SessionID
@@ -97,7 +115,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 		return fmt.Errorf("invalid RESPONSE payload type")
 	}
 
-	log.Printf("Received RESPONSE from client %x", client.clientID[:8])
+	handshakeLogger.Debug("received RESPONSE", clientAttr(client.clientID))
 
 	// Verify RESPONSE
 	if err := handshakeState.VerifyResponseMessage(responsePayload); err != nil {
@@ -115,7 +133,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 	if err != nil {
 		// Log warning but continue with zero values
 		// Client may still work in relay-only mode
-		log.Printf("Warning: Failed to extract client address: %v", err)
+		handshakeLogger.Warn("failed to extract client address", clientAttr(client.clientID), "error", err)
 		peerIP = [16]byte{}
 		peerPort = 0
 	}
@@ -123,11 +141,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 	// Set direct P2P support based on whether we got a valid address
 	peerSupportsDirectP2P := peerPort != 0
 
-	log.Printf("Client %x public address: IP=%v, Port=%d, SupportsDirectP2P=%v",
-		client.clientID[:8],
-		formatIPFromArray(peerIP),
-		peerPort,
-		peerSupportsDirectP2P)
+	handshakeLogger.Info("client public address", clientAttr(client.clientID), "ip", formatIPFromArray(peerIP), "port", peerPort, "direct_p2p", peerSupportsDirectP2P)
 
 	// Get TLS certificate and signature for Epic 2 Direct P2P
 	var peerTLSCert []byte
@@ -141,25 +155,22 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 		var err error
 		peerTLSCertSig, err = rh.tlsCertManager.SignCertificate()
 		if err != nil {
-			log.Printf("Warning: Failed to sign TLS certificate: %v", err)
+			handshakeLogger.Warn("failed to sign TLS certificate, client limited to relay mode", clientAttr(client.clientID), "error", err)
 			// Continue with empty cert/sig - client will work in relay-only mode
 			peerTLSCert = nil
 			peerTLSCertSig = nil
 		} else {
-			log.Printf("Providing TLS certificate to client %x for Direct P2P (cert: %d bytes, sig: %d bytes)",
-				client.clientID[:8],
-				len(peerTLSCert),
-				len(peerTLSCertSig))
+			handshakeLogger.Debug("providing TLS certificate for direct P2P", clientAttr(client.clientID), "cert_bytes", len(peerTLSCert), "sig_bytes", len(peerTLSCertSig))
 		}
 	} else {
-		log.Printf("Warning: No TLS certificate manager configured, Direct P2P disabled")
+		handshakeLogger.Warn("no TLS certificate manager configured, direct P2P disabled")
 	}
 
 	establishedMsg := protocol.NewEstablishedMessage(
 		client.sessionID,
 		0,                     // Server capabilities (future use)
 		30,                    // Heartbeat interval (seconds)
-		1500,                  // MTU
+		rh.tunnelMTU,          // MTU (clients refine with path MTU discovery)
 		3600,                  // Key rotation interval (seconds)
 		peerIP,                // Peer public IP (detected from connection)
 		peerPort,              // Peer public port (detected from connection)
@@ -168,11 +179,23 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 		peerTLSCertSig,        // Peer TLS certificate signature (placeholder)
 	)
 
+	// Assign tunnel addresses only once the handshake succeeded, under the identity of the lease claim
+	if rh.pool != nil && client.leaseClaim != nil {
+		assignment, err := rh.pool.Allocate(*client.leaseClaim)
+		if err != nil {
+			return fmt.Errorf("failed to assign tunnel address: %w", err)
+		}
+		established := establishedMsg.Payload.(*protocol.EstablishedMessage)
+		established.TunnelAddress = assignment.Address
+		established.TunnelAddress6 = assignment.Address6
+		handshakeLogger.Info("assigned tunnel address", clientAttr(client.clientID), "address", assignment.Address, "address6", assignment.Address6)
+	}
+
 	if err := rh.sendMessage(ctx, client, establishedMsg); err != nil {
 		return fmt.Errorf("failed to send ESTABLISHED: %w", err)
 	}
 
-	log.Printf("Sent ESTABLISHED to client %x", client.clientID[:8])
+	handshakeLogger.Debug("sent ESTABLISHED", clientAttr(client.clientID))
 
 	// Store session keys in client connection (copy to fixed-size arrays)
 	if len(handshakeState.TXKey) != 32 || len(handshakeState.RXKey) != 32 {
@@ -197,9 +220,7 @@ func (rh *RelayHandshakeHandler) HandleHandshake(ctx context.Context, client *Cl
 	}
 	client.rxEncryptor = rxEncryptor
 
-	log.Printf("Handshake complete with client %x (session: %x)",
-		client.clientID[:8],
-		client.sessionID[:8])
+	handshakeLogger.Info("handshake complete", clientAttr(client.clientID), "session", fmt.Sprintf("%x", client.sessionID[:8]))
 
 	return nil
 }
@@ -257,7 +278,7 @@ func (rh *RelayHandshakeHandler) HandleKeyRotation(
 	ctx context.Context,
 	client *ClientConnection,
 ) error {
-	log.Printf("Key rotation requested by client %x (not yet implemented)", client.clientID[:8])
+	handshakeLogger.Warn("key rotation requested but not yet implemented", clientAttr(client.clientID))
 	return fmt.Errorf("key rotation not yet implemented")
 }
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
+)
+
+// Component loggers; logging.components sets their levels by these names
+var (
+	logger          = logging.Logger("relay")
+	connLogger      = logging.Logger("connection")
+	handshakeLogger = logging.Logger("handshake")
+	routerLogger    = logging.Logger("router")
+)
+
+// Per-frame warnings are rate limited per call site; the drops are still counted in the metrics
+var (
+	invalidFrameLog  = logging.NewLimiter(time.Second)
+	decryptFailedLog = logging.NewLimiter(time.Second)
+	encryptFailedLog = logging.NewLimiter(time.Second)
+	routeFailedLog   = logging.NewLimiter(time.Second)
+	heartbeatFullLog = logging.NewLimiter(time.Second)
+)
+
+// setupLogging applies the logging section, opening logging.output_file if set
+func setupLogging(config LoggingConfig) error {
+	opts := logging.Options{
+		Level:      config.Level,
+		Format:     config.Format,
+		Components: config.Components,
+		Output:     os.Stdout,
+	}
+	if config.OutputFile != "" {
+		file, err := os.OpenFile(config.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		opts.Output = file
+	}
+	return logging.Configure(opts)
+}
+
+// fatal logs an error that stops the relay and exits
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// clientAttr identifies a client by the first 8 bytes of its ID
+func clientAttr(clientID [32]byte) slog.Attr {
+	return slog.String("client", fmt.Sprintf("%x", clientID[:8]))
+}
@@ -12,6 +12,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/shadowmesh/shadowmesh/pkg/logging"
 	"github.com/shadowmesh/shadowmesh/shared/crypto"
 )
 
@@ -56,16 +57,21 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Setup logging; the identity and server logs below are structured
+	if err := setupLogging(config.Logging); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
 	// Print banner
 	printBanner()
 
 	// Load or generate relay identity
 	relayID, sigKeys, err := loadOrGenerateIdentity(config)
 	if err != nil {
-		log.Fatalf("Failed to load identity: %v", err)
+		fatal("failed to load identity", err)
 	}
 
-	log.Printf("Relay ID: %x", relayID[:])
+	logger.Info("relay identity", "relay_id", fmt.Sprintf("%x", relayID[:]))
 
 	// Create connection manager
 	connMgr := NewConnectionManager(config)
@@ -91,14 +97,26 @@ func main() {
 
 	// Generate ephemeral TLS certificate at startup
 	if err := tlsCertManager.GenerateEphemeralCertificate(relayIP); err != nil {
-		log.Fatalf("Failed to generate TLS certificate: %v", err)
+		fatal("failed to generate TLS certificate", err)
 	}
 
 	certFingerprint := tlsCertManager.GetCertificateFingerprint()
-	log.Printf("Generated TLS certificate for Direct P2P (fingerprint: %x)", certFingerprint[:8])
+	logger.Info("generated TLS certificate for direct P2P", "fingerprint", fmt.Sprintf("%x", certFingerprint[:8]))
 
 	// Create handshake handler with TLS certificate manager
 	handshakeHandler := NewRelayHandshakeHandler(relayID, sigKeys, tlsCertManager)
+	handshakeHandler.SetTunnelMTU(config.Limits.TunnelMTU)
+
+	// Assign tunnel addresses in the handshake if a pool is configured
+	pool, err := config.AddressPool()
+	if err != nil {
+		fatal("failed to load address pool", err)
+	}
+	if pool != nil {
+		handshakeHandler.SetAddressPool(pool)
+		connMgr.SetAddressPool(pool)
+		logger.Info("assigning tunnel addresses", "pool", config.Addressing.Pool, "pool6", config.Addressing.Pool6, "leases", config.Addressing.Leases)
+	}
 	connMgr.SetHandshakeHandler(handshakeHandler)
 
 	// Start statistics reporter
@@ -117,23 +135,23 @@ func main() {
 		}
 	}()
 
-	log.Println("ShadowMesh Relay Server started successfully")
+	logger.Info("relay server started", "version", version, "address", config.Server.ListenAddr)
 
 	// Wait for shutdown signal or error
 	select {
 	case sig := <-sigChan:
-		log.Printf("Received signal: %v", sig)
+		logger.Info("received signal", "signal", sig)
 	case err := <-errChan:
-		log.Printf("Server error: %v", err)
+		logger.Error("server failed", "error", err)
 	}
 
 	// Graceful shutdown
-	log.Println("Shutting down...")
+	logger.Info("shutting down")
 	if err := connMgr.Stop(); err != nil {
-		log.Printf("Shutdown error: %v", err)
+		logger.Warn("shutdown failed", "error", err)
 	}
 
-	log.Println("Goodbye!")
+	logger.Info("stopped")
 }
 
 // loadOrGenerateIdentity loads or generates relay identity (ID + signing keys)
@@ -155,7 +173,7 @@ func loadOrGenerateIdentity(config *Config) ([32]byte, *crypto.HybridSigningKey,
 			return relayID, nil, fmt.Errorf("signing key not found and auto_generate is disabled")
 		}
 
-		log.Println("Generating new relay identity...")
+		logger.Info("generating new relay identity")
 
 		// Generate signing key
 		sigKeys, err := crypto.GenerateSigningKey()
@@ -178,15 +196,13 @@ func loadOrGenerateIdentity(config *Config) ([32]byte, *crypto.HybridSigningKey,
 			return relayID, nil, fmt.Errorf("failed to save relay ID: %w", err)
 		}
 
-		log.Printf("Generated new relay ID: %x", relayID[:8])
-		log.Printf("Signing key saved to: %s", sigKeyPath)
-		log.Printf("Relay ID saved to: %s", relayIDPath)
+		logger.Info("generated relay identity", "relay_id", fmt.Sprintf("%x", relayID[:8]), "signing_key", sigKeyPath, "relay_id_file", relayIDPath)
 
 		return relayID, sigKeys, nil
 	}
 
 	// Load existing identity
-	log.Println("Loading existing relay identity...")
+	logger.Debug("loading relay identity")
 
 	// Load signing key
 	sigKeys, err := loadSigningKey(sigKeyPath)
@@ -211,7 +227,7 @@ func loadOrGenerateIdentity(config *Config) ([32]byte, *crypto.HybridSigningKey,
 
 	copy(relayID[:], idBytes)
 
-	log.Printf("Loaded relay ID: %x", relayID[:8])
+	logger.Info("loaded relay identity", "relay_id", fmt.Sprintf("%x", relayID[:8]))
 
 	return relayID, sigKeys, nil
 }
@@ -286,6 +302,11 @@ func showConfigInfo(config *Config) error {
 	} else {
 		log.Printf("  Output: stdout")
 	}
+	for _, component := range logging.Components() {
+		if level, ok := config.Logging.Components[component]; ok {
+			log.Printf("  %s: %s", component, level)
+		}
+	}
 
 	return nil
 }
@@ -338,11 +359,7 @@ func startStatsReporter(connMgr *ConnectionManager, router *Router) func() {
 				return
 			case <-ticker.C:
 				routerStats := router.GetStats()
-				log.Printf("Stats: active_clients=%d, total_connections=%d, frames_routed=%d, bytes_routed=%d",
-					connMgr.activeConnections.Load(),
-					connMgr.totalConnections.Load(),
-					routerStats.FramesRouted,
-					routerStats.BytesRouted)
+				logger.Info("stats", "active_clients", connMgr.activeConnections.Load(), "total_connections", connMgr.totalConnections.Load(), "frames_routed", routerStats.FramesRouted, "bytes_routed", routerStats.BytesRouted)
 			}
 		}
 	}()
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/shadowmesh/shadowmesh/pkg/metrics"
+)
+
+// relayMetrics holds what /metrics cannot read from ConnectionManager and Router counters
+type relayMetrics struct {
+	registry *metrics.Registry
+
+	handshakeSuccesses atomic.Uint64
+	handshakeFailures  atomic.Uint64
+	handshakeDuration  *metrics.Histogram // Successful handshakes only
+}
+
+// newRelayMetrics creates the relay's metrics registry
+func newRelayMetrics(cm *ConnectionManager) *relayMetrics {
+	m := &relayMetrics{
+		registry:          metrics.NewRegistry(),
+		handshakeDuration: metrics.NewHistogram(metrics.LatencyBuckets),
+	}
+	m.registry.Register(cm.collectMetrics)
+	return m
+}
+
+// handshakeDone records the outcome of a client handshake
+func (m *relayMetrics) handshakeDone(start time.Time, err error) {
+	if err != nil {
+		m.handshakeFailures.Add(1)
+		return
+	}
+	m.handshakeSuccesses.Add(1)
+	m.handshakeDuration.ObserveDuration(time.Since(start))
+}
+
+// collectMetrics writes the relay's metrics for a /metrics scrape
+// Names match cmd/relay-server so the same Grafana dashboard covers both relays.
+func (cm *ConnectionManager) collectMetrics(w *metrics.Writer) {
+	cm.clientsMutex.RLock()
+	established := len(cm.clients)
+	cm.clientsMutex.RUnlock()
+
+	w.Gauge("shadowmesh_relay_clients", "Connected clients.", float64(cm.activeConnections.Load()))
+	w.Gauge("shadowmesh_relay_established_clients", "Clients that completed the handshake.", float64(established))
+	w.Gauge("shadowmesh_relay_max_clients", "Configured client limit.", float64(cm.config.Limits.MaxClients))
+	w.Counter("shadowmesh_relay_connections_total", "Client connections accepted.", float64(cm.totalConnections.Load()))
+
+	w.Counter("shadowmesh_relay_handshakes_total", "Client handshakes, by result.", float64(cm.metrics.handshakeSuccesses.Load()), metrics.L("result", "success"))
+	w.Counter("shadowmesh_relay_handshakes_total", "Client handshakes, by result.", float64(cm.metrics.handshakeFailures.Load()), metrics.L("result", "failure"))
+	w.Histogram("shadowmesh_relay_handshake_duration_seconds", "Time taken by successful client handshakes.", cm.metrics.handshakeDuration)
+
+	if cm.router == nil {
+		return
+	}
+	stats := cm.router.GetStats()
+	w.Counter("shadowmesh_relay_frames_total", "Frames received from and forwarded to clients.", float64(stats.FramesRouted), metrics.L("direction", "in"))
+	w.Counter("shadowmesh_relay_frames_total", "Frames received from and forwarded to clients.", float64(stats.BroadcastCount), metrics.L("direction", "out"))
+	w.Counter("shadowmesh_relay_bytes_total", "Frame bytes received from and forwarded to clients.", float64(stats.BytesRouted), metrics.L("direction", "in"))
+	w.Gauge("shadowmesh_relay_routing_table_entries", "Learned MAC routes.", float64(stats.RoutingTableSize))
+
+	reasons := make([]string, 0, len(stats.Drops))
+	for reason := range stats.Drops {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		w.Counter("shadowmesh_relay_dropped_frames_total", "Frames dropped, by reason.", float64(stats.Drops[reason]), metrics.L("reason", reason))
+	}
+}
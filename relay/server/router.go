@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 
@@ -19,6 +20,49 @@ const (
 	RoutingModeDirect
 )
 
+// DropReason classifies frames the router could not deliver
+type DropReason int
+
+const (
+	DropInvalidType    DropReason = iota // Not a data frame
+	DropOversized                        // Larger than limits.max_frame_size
+	DropInvalidPayload                   // Data frame payload did not decode
+	DropUnknownMode                      // Routing mode not implemented
+	DropNoSessionKey                     // Source or destination has no session key yet
+	DropDecryptFailed                    // Frame from the source did not decrypt
+	DropEncryptFailed                    // Re-encryption for a destination failed
+	DropSendFailed                       // Destination's send queue was full or closed
+	DropTooShort                         // Shorter than an Ethernet header
+
+	numDropReasons
+)
+
+// String returns the reason's name as used in metrics and /stats
+func (d DropReason) String() string {
+	switch d {
+	case DropInvalidType:
+		return "invalid_type"
+	case DropOversized:
+		return "oversized"
+	case DropInvalidPayload:
+		return "invalid_payload"
+	case DropUnknownMode:
+		return "unknown_mode"
+	case DropNoSessionKey:
+		return "no_session_key"
+	case DropDecryptFailed:
+		return "decrypt_failed"
+	case DropEncryptFailed:
+		return "encrypt_failed"
+	case DropSendFailed:
+		return "send_failed"
+	case DropTooShort:
+		return "too_short"
+	default:
+		return "unknown"
+	}
+}
+
 // Router handles frame routing between clients
 type Router struct {
 	// Configuration
@@ -33,6 +77,7 @@ type Router struct {
 	framesFailed   atomic.Uint64
 	bytesRouted    atomic.Uint64
 	broadcastCount atomic.Uint64
+	drops          [numDropReasons]atomic.Uint64 // framesFailed by reason
 
 	// Routing table (for future direct routing)
 	routingTable map[[6]byte][32]byte // MAC address -> ClientID
@@ -53,27 +98,23 @@ func NewRouter(connMgr *ConnectionManager, mode RoutingMode, maxFrameSize int) *
 func (r *Router) RouteFrame(source *ClientConnection, msg *protocol.Message) {
 	// Validate message type
 	if msg.Header.Type != protocol.MsgTypeDataFrame {
-		log.Printf("Router received non-data frame (type %d) from client %x",
-			msg.Header.Type,
-			source.clientID[:8])
-		r.framesFailed.Add(1)
+		invalidFrameLog.Warn(routerLogger, "non-data frame", "type", msg.Header.Type, clientAttr(source.clientID))
+		r.fail(DropInvalidType)
 		return
 	}
 
 	// Validate frame size
 	if msg.Header.Length > uint32(r.maxFrameSize) {
-		log.Printf("Oversized frame (%d bytes) from client %x, dropping",
-			msg.Header.Length,
-			source.clientID[:8])
-		r.framesFailed.Add(1)
+		invalidFrameLog.Warn(routerLogger, "oversized frame, dropping", "bytes", msg.Header.Length, clientAttr(source.clientID))
+		r.fail(DropOversized)
 		return
 	}
 
 	// Extract payload
 	dataPayload, ok := msg.Payload.(*protocol.DataFrame)
 	if !ok {
-		log.Printf("Invalid data frame payload from client %x", source.clientID[:8])
-		r.framesFailed.Add(1)
+		invalidFrameLog.Warn(routerLogger, "invalid data frame payload", clientAttr(source.clientID))
+		r.fail(DropInvalidPayload)
 		return
 	}
 
@@ -86,29 +127,35 @@ func (r *Router) RouteFrame(source *ClientConnection, msg *protocol.Message) {
 		r.routeDirect(source, msg, dataPayload)
 
 	default:
-		log.Printf("Unknown routing mode: %d", r.mode)
-		r.framesFailed.Add(1)
+		routeFailedLog.Warn(routerLogger, "unknown routing mode", "mode", r.mode)
+		r.fail(DropUnknownMode)
 	}
 }
 
-// routeBroadcast broadcasts a frame to all other clients
+// delivers reports whether a frame from source may be delivered to dest: they must share its network
+// Every route, broadcast or direct, checks this before sending.
+func (r *Router) delivers(source, dest *ClientConnection, plaintext []byte) bool {
+	return dest.networks.Relays(source.networks, plaintext)
+}
+
+// routeBroadcast broadcasts a frame to all other clients in its network
 func (r *Router) routeBroadcast(source *ClientConnection, msg *protocol.Message, data *protocol.DataFrame) {
 	// STEP 1: Decrypt the frame using relay's RX encryptor for source client
 	// Use the persistent encryptor to maintain nonce consistency
 	if source.rxEncryptor == nil {
-		log.Printf("RX encryptor not initialized for source client %x", source.clientID[:8])
-		r.framesFailed.Add(1)
+		decryptFailedLog.Warn(routerLogger, "RX encryptor not initialized", clientAttr(source.clientID))
+		r.fail(DropNoSessionKey)
 		return
 	}
 
 	plaintext, err := source.rxEncryptor.Decrypt(data.EncryptedData)
 	if err != nil {
-		log.Printf("Failed to decrypt frame from client %x: %v", source.clientID[:8], err)
-		r.framesFailed.Add(1)
+		decryptFailedLog.Warn(routerLogger, "failed to decrypt frame", clientAttr(source.clientID), "error", err)
+		r.fail(DropDecryptFailed)
 		return
 	}
 
-	// STEP 2: Get all destination clients except source
+	// STEP 2: Get all destination clients except source that share the frame's network
 	r.connMgr.clientsMutex.RLock()
 	destinations := make([]*ClientConnection, 0, len(r.connMgr.clients)-1)
 	for clientID, client := range r.connMgr.clients {
@@ -117,8 +164,8 @@ func (r *Router) routeBroadcast(source *ClientConnection, msg *protocol.Message,
 			continue
 		}
 
-		// Only send to established clients
-		if client.getState() == ClientStateEstablished {
+		// Only send to established clients in the frame's network
+		if client.getState() == ClientStateEstablished && r.delivers(source, client, plaintext) {
 			destinations = append(destinations, client)
 		}
 	}
@@ -130,16 +177,16 @@ func (r *Router) routeBroadcast(source *ClientConnection, msg *protocol.Message,
 		// Use the persistent TX encryptor for destination client
 		// This maintains nonce consistency for all encrypted frames to this client
 		if dest.txEncryptor == nil {
-			log.Printf("TX encryptor not initialized for dest client %x", dest.clientID[:8])
-			r.framesFailed.Add(1)
+			encryptFailedLog.Warn(routerLogger, "TX encryptor not initialized", clientAttr(dest.clientID))
+			r.fail(DropNoSessionKey)
 			continue
 		}
 
 		// Re-encrypt plaintext for destination
 		reEncrypted, err := dest.txEncryptor.Encrypt(plaintext)
 		if err != nil {
-			log.Printf("Failed to re-encrypt frame for client %x: %v", dest.clientID[:8], err)
-			r.framesFailed.Add(1)
+			encryptFailedLog.Warn(routerLogger, "failed to re-encrypt frame", clientAttr(dest.clientID), "error", err)
+			r.fail(DropEncryptFailed)
 			continue
 		}
 
@@ -148,11 +195,8 @@ func (r *Router) routeBroadcast(source *ClientConnection, msg *protocol.Message,
 
 		// Send re-encrypted frame to destination
 		if err := dest.SendMessage(reEncryptedMsg); err != nil {
-			log.Printf("Failed to route frame from %x to %x: %v",
-				source.clientID[:8],
-				dest.clientID[:8],
-				err)
-			r.framesFailed.Add(1)
+			routeFailedLog.Warn(routerLogger, "failed to route frame", clientAttr(source.clientID), "dest", fmt.Sprintf("%x", dest.clientID[:8]), "error", err)
+			r.fail(DropSendFailed)
 		} else {
 			successCount++
 		}
@@ -174,8 +218,8 @@ func (r *Router) routeDirect(source *ClientConnection, msg *protocol.Message, da
 	// Extract destination MAC from Ethernet frame
 	// Ethernet frame format: [6 bytes dest MAC][6 bytes source MAC][2 bytes ethertype][payload]
 	if len(data.EncryptedData) < 14 {
-		log.Printf("Frame too short for Ethernet header from client %x", source.clientID[:8])
-		r.framesFailed.Add(1)
+		invalidFrameLog.Warn(routerLogger, "frame too short for Ethernet header", clientAttr(source.clientID))
+		r.fail(DropTooShort)
 		return
 	}
 
@@ -185,8 +229,9 @@ func (r *Router) routeDirect(source *ClientConnection, msg *protocol.Message, da
 	// 2. Authenticated but unencrypted headers
 	// 3. Initial broadcast with learning
 
-	// For now, fall back to broadcast
-	log.Printf("Direct routing not yet implemented, falling back to broadcast")
+	// For now, fall back to broadcast, which only reaches the frame's network. A
+	// destination found with LookupRoute must pass delivers just the same.
+	routerLogger.Debug("direct routing not yet implemented, falling back to broadcast")
 	r.routeBroadcast(source, msg, data)
 }
 
@@ -201,10 +246,7 @@ func (r *Router) LearnRoute(macAddr [6]byte, clientID [32]byte) {
 	// Check if route already exists
 	if existingClientID, exists := r.routingTable[macAddr]; exists {
 		if existingClientID != clientID {
-			log.Printf("MAC %x moved from client %x to %x",
-				macAddr[:],
-				existingClientID[:8],
-				clientID[:8])
+			routerLogger.Info("MAC moved", "mac", net.HardwareAddr(macAddr[:]).String(), "from", fmt.Sprintf("%x", existingClientID[:8]), clientAttr(clientID))
 		}
 	}
 
@@ -229,20 +271,32 @@ func (r *Router) RemoveClientRoutes(clientID [32]byte) {
 	for macAddr, routeClientID := range r.routingTable {
 		if routeClientID == clientID {
 			delete(r.routingTable, macAddr)
-			log.Printf("Removed route for MAC %x (client %x disconnected)",
-				macAddr[:],
-				clientID[:8])
+			routerLogger.Debug("removed route of disconnected client", "mac", net.HardwareAddr(macAddr[:]).String(), clientAttr(clientID))
 		}
 	}
 }
 
+// fail counts a frame that could not be delivered
+func (r *Router) fail(reason DropReason) {
+	r.framesFailed.Add(1)
+	r.drops[reason].Add(1)
+}
+
 // GetStats returns routing statistics
 func (r *Router) GetStats() RouterStats {
+	drops := make(map[string]uint64)
+	for reason := DropReason(0); reason < numDropReasons; reason++ {
+		if count := r.drops[reason].Load(); count > 0 {
+			drops[reason.String()] = count
+		}
+	}
+
 	return RouterStats{
 		FramesRouted:   r.framesRouted.Load(),
 		FramesFailed:   r.framesFailed.Load(),
 		BytesRouted:    r.bytesRouted.Load(),
 		BroadcastCount: r.broadcastCount.Load(),
+		Drops:          drops,
 		RoutingTableSize: func() int {
 			r.routingMutex.RLock()
 			defer r.routingMutex.RUnlock()
@@ -258,12 +312,14 @@ type RouterStats struct {
 	BytesRouted      uint64 `json:"bytes_routed"`
 	BroadcastCount   uint64 `json:"broadcast_count"`
 	RoutingTableSize int    `json:"routing_table_size"`
+
+	Drops map[string]uint64 `json:"drops"` // FramesFailed by DropReason name
 }
 
 // SetRoutingMode changes the routing mode
 func (r *Router) SetRoutingMode(mode RoutingMode) {
 	r.mode = mode
-	log.Printf("Routing mode changed to %d", mode)
+	routerLogger.Info("routing mode changed", "mode", mode)
 }
 
 // GetRoutingMode returns the current routing mode
@@ -277,7 +333,7 @@ func (r *Router) ClearRoutingTable() {
 	defer r.routingMutex.Unlock()
 
 	r.routingTable = make(map[[6]byte][32]byte)
-	log.Println("Routing table cleared")
+	routerLogger.Info("routing table cleared")
 }
 
 // GetRoutingTableSnapshot returns a copy of the routing table
@@ -173,7 +173,7 @@ else
         echo "Window scaling: $(sysctl -n net.ipv4.tcp_window_scaling)"
         echo ""
         echo "=== ShadowMesh Status ==="
-        curl -s http://127.0.0.1:9090/status | python3 -m json.tool 2>/dev/null || echo "Failed to get status"
+        sudo curl -s --unix-socket /run/shadowmesh/daemon.sock http://localhost/status | python3 -m json.tool 2>/dev/null || echo "Failed to get status"
         echo ""
     } > "$SYS_INFO_FILE"
 
@@ -111,7 +111,7 @@ echo -e "${YELLOW}[6/6] Testing connection...${NC}"
 echo ""
 
 echo "Initiating connection from Endpoint 1..."
-ssh ${ENDPOINT1_HOST} "curl -X POST http://localhost:9090/connect 2>/dev/null" || true
+ssh ${ENDPOINT1_HOST} "sudo curl -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/connect 2>/dev/null" || true
 sleep 3
 echo ""
 
@@ -130,7 +130,7 @@ echo -e "${BLUE}Testing Commands${NC}"
 echo -e "${BLUE}========================================${NC}"
 echo ""
 echo "Check Endpoint 1 status:"
-echo "  ssh ${ENDPOINT1_HOST} 'curl http://localhost:9090/status'"
+echo "  ssh ${ENDPOINT1_HOST} 'sudo curl --unix-socket /run/shadowmesh/daemon.sock http://localhost/status'"
 echo ""
 echo "Check Endpoint 2 status:"
 echo "  ssh ${ENDPOINT2_HOST} 'curl http://localhost:9091/status'"
@@ -197,11 +197,11 @@ print_info "========================================"
 
 # Get current status
 print_info "Checking current connection status..."
-CURRENT_STATUS=$(curl -s http://127.0.0.1:9090/status)
+CURRENT_STATUS=$(sudo curl -s --unix-socket /run/shadowmesh/daemon.sock http://localhost/status)
 
 if echo "$CURRENT_STATUS" | grep -q "Connected"; then
     print_info "Disconnecting existing connection..."
-    curl -s -X POST http://127.0.0.1:9090/disconnect
+    sudo curl -s -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/disconnect
     sleep 1
     print_success "Disconnected"
 
@@ -220,7 +220,7 @@ if echo "$CURRENT_STATUS" | grep -q "Connected"; then
 
         print_info "Connecting to ${PEER_ADDR} (relay: ${USE_RELAY})..."
 
-        CONNECT_RESULT=$(curl -s -X POST http://127.0.0.1:9090/connect \
+        CONNECT_RESULT=$(sudo curl -s -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/connect \
             -H "Content-Type: application/json" \
             -d "{\"peer_address\": \"${PEER_ADDR}\", \"use_relay\": ${USE_RELAY}}")
 
@@ -229,7 +229,7 @@ if echo "$CURRENT_STATUS" | grep -q "Connected"; then
         sleep 2
 
         # Verify connection
-        NEW_STATUS=$(curl -s http://127.0.0.1:9090/status)
+        NEW_STATUS=$(sudo curl -s --unix-socket /run/shadowmesh/daemon.sock http://localhost/status)
         if echo "$NEW_STATUS" | grep -q "Connected"; then
             print_success "Connection established"
         else
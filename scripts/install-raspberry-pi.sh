@@ -232,7 +232,7 @@ cat > /etc/shadowmesh/daemon.yaml <<EOF
 # Generated: $(date)
 
 daemon:
-  listen_address: "127.0.0.1:9090"
+  socket: "/run/shadowmesh/daemon.sock"
   log_level: "info"
 
 network:
@@ -248,6 +248,11 @@ peer:
 nat:
   enabled: true
   stun_server: "stun.l.google.com:19302"
+
+# Recover lost datagrams on cellular uplinks without retransmits
+fec:
+  enabled: true
+  adaptive: true
 EOF
 
 echo "✅ Configuration saved to /etc/shadowmesh/daemon.yaml"
@@ -268,12 +273,12 @@ echo "To start the daemon:"
 echo "  sudo shadowmesh-daemon /etc/shadowmesh/daemon.yaml"
 echo ""
 echo "To connect to peer (on initiator):"
-echo "  curl -X POST http://127.0.0.1:9090/connect \\"
+echo "  sudo curl -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/connect \\"
 echo "    -H \"Content-Type: application/json\" \\"
 echo "    -d '{\"peer_address\": \"PEER_IP:9001\"}'"
 echo ""
 echo "To check status:"
-echo "  curl http://127.0.0.1:9090/status"
+echo "  sudo curl --unix-socket /run/shadowmesh/daemon.sock http://localhost/status"
 echo ""
 echo "For detailed testing instructions, see:"
 echo "  $SHADOWMESH_DIR/docs/QUICK_START_TESTING.md"
@@ -146,8 +146,8 @@ echo "  1. Apply TCP optimizations (if not done already):"
 echo "     ${GREEN}sudo ./scripts/optimize-tcp-performance.sh${NC}"
 echo ""
 echo "  2. Restart ShadowMesh connections:"
-echo "     ${GREEN}curl -X POST http://127.0.0.1:9090/disconnect${NC}"
-echo "     ${GREEN}curl -X POST http://127.0.0.1:9090/connect -H \"Content-Type: application/json\" -d '{\"peer_address\": \"PEER_IP:9001\", \"use_relay\": true}'${NC}"
+echo "     ${GREEN}sudo curl -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/disconnect${NC}"
+echo "     ${GREEN}sudo curl -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/connect -H \"Content-Type: application/json\" -d '{\"peer_address\": \"PEER_IP:9001\", \"use_relay\": true}'${NC}"
 echo ""
 echo "  3. Re-run iperf3 test:"
 echo "     ${GREEN}iperf3 -c 10.0.0.X -t 30 -P 4${NC}"
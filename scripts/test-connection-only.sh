@@ -22,7 +22,7 @@ echo -e "${YELLOW}[1/4] Creating test configurations...${NC}"
 
 cat > /tmp/shadowmesh-test1.yaml <<EOF
 daemon:
-  listen_address: "127.0.0.1:19090"
+  socket: "/tmp/shadowmesh-test1.sock"
   log_level: "info"
 
 network:
@@ -47,7 +47,7 @@ EOF
 
 cat > /tmp/shadowmesh-test2.yaml <<EOF
 daemon:
-  listen_address: "127.0.0.1:19091"
+  socket: "/tmp/shadowmesh-test2.sock"
   log_level: "info"
 
 network:
@@ -123,9 +123,9 @@ echo ""
 
 # Check daemon 1 status
 echo "Endpoint 1 Status:"
-if curl -s http://localhost:19090/status 2>/dev/null | grep -q "state"; then
+if curl -s --unix-socket /tmp/shadowmesh-test1.sock http://localhost/status 2>/dev/null | grep -q "state"; then
     echo -e "${GREEN}  ✅ Daemon 1 API responding${NC}"
-    curl -s http://localhost:19090/status | python3 -m json.tool
+    curl -s --unix-socket /tmp/shadowmesh-test1.sock http://localhost/status | python3 -m json.tool
 else
     echo -e "${RED}  ❌ Daemon 1 not responding${NC}"
     echo "Logs:"
@@ -135,9 +135,9 @@ echo ""
 
 # Check daemon 2 status
 echo "Endpoint 2 Status:"
-if curl -s http://localhost:19091/status 2>/dev/null | grep -q "state"; then
+if curl -s --unix-socket /tmp/shadowmesh-test2.sock http://localhost/status 2>/dev/null | grep -q "state"; then
     echo -e "${GREEN}  ✅ Daemon 2 API responding${NC}"
-    curl -s http://localhost:19091/status | python3 -m json.tool
+    curl -s --unix-socket /tmp/shadowmesh-test2.sock http://localhost/status | python3 -m json.tool
 else
     echo -e "${RED}  ❌ Daemon 2 not responding${NC}"
     echo "Logs:"
@@ -153,7 +153,7 @@ echo ""
 echo "Attempting connection from Endpoint 1 to relay..."
 echo ""
 
-if curl -X POST http://localhost:19090/connect 2>/dev/null; then
+if curl -X POST --unix-socket /tmp/shadowmesh-test1.sock http://localhost/connect 2>/dev/null; then
     echo ""
     echo -e "${GREEN}✅ Connection initiated successfully${NC}"
 else
@@ -96,13 +96,13 @@ echo ""
 echo "Daemons are running. To test connection:"
 echo ""
 echo "Terminal 1 (Endpoint 1 status):"
-echo "  curl http://localhost:9090/status"
+echo "  sudo curl --unix-socket /run/shadowmesh/daemon.sock http://localhost/status"
 echo ""
 echo "Terminal 2 (Endpoint 2 status):"
 echo "  curl http://localhost:9091/status"
 echo ""
 echo "Connect endpoint 1 to endpoint 2 via relay:"
-echo "  curl -X POST http://localhost:9090/connect"
+echo "  sudo curl -X POST --unix-socket /run/shadowmesh/daemon.sock http://localhost/connect"
 echo ""
 echo "Connect endpoint 2 to endpoint 1 via relay:"
 echo "  curl -X POST http://localhost:9091/connect"